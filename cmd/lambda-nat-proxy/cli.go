@@ -2,11 +2,24 @@ package main
 
 import (
 	"fmt"
+	"log"
+	"os"
 
 	"github.com/spf13/cobra"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/events"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/ui"
 	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
 )
 
+// version is reported by the version command and in support bundles. Bump
+// it with a release in internal/changelog/changelog.yaml.
+const version = "v1.0.0"
+
+// logEvents publishes logged warnings and errors to the run command's event
+// bus; it is installed in the logger at init and idle until then
+var logEvents = &events.LogHandler{}
+
 // executeCliCommand executes the cobra CLI
 func executeCliCommand() error {
 	return rootCmd.Execute()
@@ -18,7 +31,53 @@ var rootCmd = &cobra.Command{
 	Short: "A QUIC NAT Traversal SOCKS5 Proxy using AWS Lambda",
 	Long: `lambda-nat-proxy is a high-performance SOCKS5 proxy that uses QUIC protocol
 and AWS Lambda for NAT traversal. It provides seamless network connectivity
-through NAT and firewall restrictions.`,
+through NAT and firewall restrictions.` + "\n" + exitCodesHelp,
+	// Errors are reported by main so that they map to stable exit codes
+	SilenceErrors: true,
+	// Route AWS API calls through the configured HTTP proxy before any
+	// command runs. Commands report configuration errors themselves.
+	// --ascii overrides the terminal detection done by the ui package.
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if asciiOutput, _ := cmd.Flags().GetBool("ascii"); cmd.Flags().Changed("ascii") {
+			ui.SetASCII(asciiOutput)
+		}
+		if cfg, err := loadConfig(cmd); err == nil {
+			shared.ApplyHTTPProxy(cfg.HTTPProxy.Settings())
+		}
+	},
+}
+
+// loadConfig loads the config file named by --config, or the first one
+// found, and applies the profile selected by --profile
+func loadConfig(cmd *cobra.Command) (*config.CLIConfig, error) {
+	configPath, _ := cmd.Flags().GetString("config")
+	cfg, _, err := loadConfigWithProvenance(cmd, configPath)
+	return cfg, err
+}
+
+// loadConfigWithProvenance loads configPath like loadConfig and also returns
+// where each setting came from
+func loadConfigWithProvenance(cmd *cobra.Command, configPath string) (*config.CLIConfig, config.Provenance, error) {
+	cfg, sources, err := config.LoadCLIConfigWithProvenance(configPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	keys, err := cfg.ApplyProfile(selectedProfile(cmd))
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, key := range keys {
+		sources.SetProfile(key)
+	}
+	return cfg, sources, nil
+}
+
+// selectedProfile returns the profile named by --profile or $LAMBDA_PROXY_PROFILE
+func selectedProfile(cmd *cobra.Command) string {
+	if name, _ := cmd.Flags().GetString("profile"); name != "" {
+		return name
+	}
+	return os.Getenv(config.ProfileEnv)
 }
 
 // versionCmd represents the version command
@@ -27,7 +86,7 @@ var versionCmd = &cobra.Command{
 	Short: "Print the version information",
 	Long:  "Print the version information for lambda-nat-proxy",
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("lambda-nat-proxy v1.0.0")
+		ui.Println("lambda-nat-proxy " + version)
 	},
 }
 
@@ -38,14 +97,26 @@ func init() {
 		Format:      "text", // Human-readable format for CLI
 		AddSource:   false,
 		ServiceName: "lambda-nat-proxy-cli",
+		Output:      ui.Stdout,
+		Forward:     logEvents,
 	})
+	log.SetOutput(shared.NewPrivacyWriter(ui.Stderr))
+	rootCmd.SetOut(ui.Stdout)
+	rootCmd.SetErr(ui.Stderr)
 	
 	// Add global flags
 	rootCmd.PersistentFlags().StringP("config", "c", "", "config file path")
+	rootCmd.PersistentFlags().Bool("ascii", false, "Write plain ASCII output without emoji or box drawing (default: detected from the terminal and $"+ui.ASCIIEnv+")")
+	rootCmd.PersistentFlags().String("profile", "", "Use a named profile from the config file's profiles section (default $"+config.ProfileEnv+")")
 	
 	// Disable completion command
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
 	
+	// Bad flags are configuration errors for exit code purposes
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return configError(err)
+	})
+	
 	// Add commands to root
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(runCmd)
@@ -53,4 +124,11 @@ func init() {
 	rootCmd.AddCommand(destroyCmd)
 	rootCmd.AddCommand(statusCmd)
 	rootCmd.AddCommand(configCmd)
+	
+	// Document exit codes in every command's --help
+	for _, cmd := range rootCmd.Commands() {
+		if cmd.Long != "" {
+			cmd.Long += "\n" + exitCodesHelp
+		}
+	}
 }
\ No newline at end of file
@@ -278,6 +278,12 @@ func TestCommandHelp(t *testing.T) {
 		"status",
 		"config",
 		"version",
+		"support-bundle",
+		"stacks",
+		"doctor",
+		"cost",
+		"policy",
+		"ci-e2e", "stop", "reload", "reset-launch", "session", "evacuate", "speedtest", "upgrade", "cleanup", "changelog", "relay",
 	}
 	
 	for _, command := range commands {
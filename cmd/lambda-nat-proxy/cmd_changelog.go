@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/changelog"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/control"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/ui"
+)
+
+// changelogCmd prints the release notes built into this binary
+var changelogCmd = &cobra.Command{
+	Use:   "changelog",
+	Short: "Show the release notes and migration steps",
+	Long: `Show the release notes built into this binary, newest release first.
+
+Notes marked redeploy need existing stacks to be redeployed with
+'lambda-nat-proxy deploy' before this proxy can use them; notes marked
+breaking change something that used to work. The first run and deploy
+after an upgrade show the notes since the version run before, once.
+
+  lambda-nat-proxy changelog
+  lambda-nat-proxy changelog --since v1.0.0`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runChangelog(cmd)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(changelogCmd)
+
+	changelogCmd.Flags().String("since", "", "Only show releases newer than this version")
+	changelogCmd.Flags().String("format", "text", "Output format (text, json)")
+}
+
+func runChangelog(cmd *cobra.Command) error {
+	releases, err := changelog.Releases()
+	if err != nil {
+		return err
+	}
+	since, _ := cmd.Flags().GetString("since")
+	if since != "" {
+		if changelog.Compare(since, "v0.0.0") < 0 {
+			return configError(fmt.Errorf("invalid --since version %q, expected e.g. v1.0.0", since))
+		}
+		releases = changelog.Between(releases, since, version)
+	}
+
+	format, _ := cmd.Flags().GetString("format")
+	switch format {
+	case "json":
+		if releases == nil {
+			releases = []changelog.Release{}
+		}
+		data, err := json.MarshalIndent(releases, "", "  ")
+		if err != nil {
+			return err
+		}
+		ui.Println(string(data))
+	case "text":
+		if len(releases) == 0 {
+			ui.Printf("No release notes since %s\n", since)
+			return nil
+		}
+		for i, release := range releases {
+			if i > 0 {
+				ui.Println()
+			}
+			ui.Printf("%s (protocol %d)\n", release.Version, release.Protocol)
+			for _, note := range release.Notes {
+				ui.Printf("  • %s%s\n", noteTags(note), note.Text)
+			}
+		}
+	default:
+		return configError(fmt.Errorf("unknown format %q (text, json)", format))
+	}
+	return nil
+}
+
+// noteTags labels a note that needs a redeploy or breaks something
+func noteTags(note changelog.Note) string {
+	switch {
+	case note.Breaking && note.Redeploy:
+		return "[breaking, redeploy] "
+	case note.Breaking:
+		return "[breaking] "
+	case note.Redeploy:
+		return "[redeploy] "
+	}
+	return ""
+}
+
+// showUpgradeNotes logs the release notes since the version run before
+// this one, the first time a new version runs. deploying leaves out the
+// advice to redeploy, since that is what's happening.
+func showUpgradeNotes(deploying bool) {
+	previous, releases, err := changelog.Upgrade(control.DefaultVersionPath(), version)
+	if err != nil {
+		log.Printf("⚠️  Failed to check for release notes: %v", err)
+		return
+	}
+	if len(releases) == 0 {
+		return
+	}
+	log.Printf("Upgraded from %s to %s; 'lambda-nat-proxy changelog' shows these notes again:", previous, version)
+	redeployFrom := ""
+	for _, release := range releases {
+		for _, note := range release.Notes {
+			log.Printf("  %s: %s%s", release.Version, noteTags(note), note.Text)
+		}
+		if release.NeedsRedeploy() {
+			redeployFrom = release.Version // releases are newest first
+		}
+	}
+	if redeployFrom != "" && !deploying {
+		log.Printf("⚠️  Stacks deployed before %s must be redeployed with 'lambda-nat-proxy deploy' before this proxy can use them", redeployFrom)
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/changelog"
+)
+
+func TestChangelogHasVersion(t *testing.T) {
+	releases, err := changelog.Releases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) == 0 || releases[0].Version != version {
+		t.Fatalf("newest changelog release is not %s; add its notes to internal/changelog/changelog.yaml", version)
+	}
+}
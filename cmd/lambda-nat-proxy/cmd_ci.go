@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/e2e"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/ui"
+)
+
+// ciStackPrefix names ephemeral stacks so leftovers are easy to spot in 'stacks list'
+const ciStackPrefix = "lambda-nat-proxy-ci-"
+
+// ciDestroyTimeout bounds teardown, which runs even after the other steps time out
+const ciDestroyTimeout = 15 * time.Minute
+
+// ciE2ECmd deploys a throwaway stack, tests it and tears it down
+var ciE2ECmd = &cobra.Command{
+	Use:   "ci-e2e",
+	Short: "Deploy an ephemeral stack, test it end to end and destroy it",
+	Long: `Run the end-to-end suite against a throwaway deployment, for CI pipelines:
+
+1. deploy     a new stack named ` + ciStackPrefix + `<random>
+2. session    start the proxy and wait for its first session
+3. smoke      fetch --smoke-url through the tunnel
+4. benchmark  measure latency, download and upload speed against the
+              Lambda's own test targets, or download --benchmark-url
+5. destroy    delete the stack, even if an earlier step failed
+
+Each step runs this binary's own deploy, run and destroy commands with a
+temporary copy of the configuration, so credentials and settings come from
+the usual places. Results are written as JUnit XML (--junit) and JSON
+(--json) for the CI system to pick up, and the command fails if any step
+failed. Use --keep to leave the stack deployed for debugging.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCIE2E(cmd)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(ciE2ECmd)
+
+	ciE2ECmd.Flags().StringP("region", "r", "", "AWS region (overrides config)")
+	ciE2ECmd.Flags().StringP("mode", "m", "test", "Performance mode to deploy (test, normal, performance)")
+	ciE2ECmd.Flags().IntP("port", "p", 18080, "SOCKS5 port for the proxy under test")
+	ciE2ECmd.Flags().Duration("timeout", 20*time.Minute, "Time allowed for deploy and tests, not counting destroy")
+	ciE2ECmd.Flags().Duration("session-timeout", 2*time.Minute, "Time allowed for the first session to come up")
+	ciE2ECmd.Flags().String("smoke-url", "https://checkip.amazonaws.com", "URL fetched through the tunnel by the smoke test")
+	ciE2ECmd.Flags().String("benchmark-url", "", "URL downloaded by the benchmark instead of the Lambda's test targets")
+	ciE2ECmd.Flags().Duration("benchmark-duration", 10*time.Second, "Length of each benchmark transfer to the Lambda's test targets (0 = skip)")
+	ciE2ECmd.Flags().String("junit", "", "Write JUnit XML results to this file")
+	ciE2ECmd.Flags().String("json", "", "Write JSON results to this file")
+	ciE2ECmd.Flags().Bool("keep", false, "Leave the stack deployed instead of destroying it")
+}
+
+func runCIE2E(cmd *cobra.Command) error {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return configError(err)
+	}
+	if region, _ := cmd.Flags().GetString("region"); cmd.Flags().Changed("region") {
+		cfg.AWS.Region = region
+	}
+	mode, _ := cmd.Flags().GetString("mode")
+	cfg.Deployment.Mode = config.PerformanceMode(mode)
+	cfg.Deployment.StackName, err = ciStackName()
+	if err != nil {
+		return err
+	}
+	if errors := config.ValidateCLIConfig(cfg); len(errors) > 0 {
+		ui.Printf("Configuration validation errors:\n")
+		for _, err := range errors {
+			ui.Printf("  - %s\n", err.Error())
+		}
+		return configError(fmt.Errorf("configuration validation failed"))
+	}
+
+	port, _ := cmd.Flags().GetInt("port")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	sessionTimeout, _ := cmd.Flags().GetDuration("session-timeout")
+	smokeURL, _ := cmd.Flags().GetString("smoke-url")
+	benchmarkURL, _ := cmd.Flags().GetString("benchmark-url")
+	benchmarkDuration, _ := cmd.Flags().GetDuration("benchmark-duration")
+	junitPath, _ := cmd.Flags().GetString("junit")
+	jsonPath, _ := cmd.Flags().GetString("json")
+	keep, _ := cmd.Flags().GetBool("keep")
+	if port < 1 || port > 65535 {
+		return configError(fmt.Errorf("--port must be between 1 and 65535"))
+	}
+
+	// The child commands read the ephemeral stack's settings from a copy of the config
+	workDir, err := os.MkdirTemp("", "lambda-nat-proxy-ci-")
+	if err != nil {
+		return fmt.Errorf("failed to create work directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+	ciConfigPath := filepath.Join(workDir, "lambda-nat-proxy.yaml")
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal configuration: %w", err)
+	}
+	if err := os.WriteFile(ciConfigPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write configuration: %w", err)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate lambda-nat-proxy binary: %w", err)
+	}
+
+	report := e2e.NewReport("lambda-nat-proxy-e2e")
+	report.StackName = cfg.Deployment.StackName
+	report.Region = cfg.AWS.Region
+	report.Mode = string(cfg.Deployment.Mode)
+
+	ui.Printf("\n🧪 Lambda NAT Proxy CI End-to-End Run\n")
+	ui.Printf("=====================================\n\n")
+	ui.Printf("Stack:  %s\n", report.StackName)
+	ui.Printf("Region: %s\n", report.Region)
+	ui.Printf("Mode:   %s\n\n", report.Mode)
+
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(signalCtx, timeout)
+	defer cancel()
+
+	child := func(ctx context.Context, args ...string) *exec.Cmd {
+		c := exec.CommandContext(ctx, self, append(args, "--config", ciConfigPath)...)
+		c.Stdout = os.Stderr // keep stdout for the summary
+		c.Stderr = os.Stderr
+		return c
+	}
+
+	deployErr := report.Run("deploy", func(step *e2e.Step) error {
+		return child(ctx, "deploy").Run()
+	})
+	if deployErr == nil {
+		runCITests(ctx, report, child, workDir, port, sessionTimeout, smokeURL, benchmarkURL, benchmarkDuration)
+	} else {
+		for _, name := range []string{"session", "smoke", "benchmark"} {
+			report.Skip(name, "deploy failed")
+		}
+	}
+
+	// Tear down even if the run timed out or was interrupted
+	if keep {
+		report.Skip("destroy", "--keep")
+		ui.Printf("\n⚠️  Stack %s left deployed; remove it with: lambda-nat-proxy destroy --stack-name %s --region %s --force\n",
+			report.StackName, report.StackName, report.Region)
+	} else {
+		destroyCtx, destroyCancel := context.WithTimeout(context.Background(), ciDestroyTimeout)
+		defer destroyCancel()
+		report.Run("destroy", func(step *e2e.Step) error {
+			return child(destroyCtx, "destroy", "--force").Run()
+		})
+	}
+
+	if err := writeCIReports(report, junitPath, jsonPath); err != nil {
+		return err
+	}
+	printCISummary(report)
+	if failed := report.Failed(); failed > 0 {
+		return fmt.Errorf("%d of %d end-to-end steps failed", failed, len(report.Results))
+	}
+	return nil
+}
+
+// runCITests starts the proxy against the deployed stack and runs the session, smoke and benchmark steps
+func runCITests(ctx context.Context, report *e2e.Report, child func(context.Context, ...string) *exec.Cmd,
+	workDir string, port int, sessionTimeout time.Duration, smokeURL, benchmarkURL string, benchmarkDuration time.Duration) {
+	proxyAddr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	proxy := child(ctx, "run", "--port", strconv.Itoa(port), "--dashboard=false", "--no-browser",
+		"--control-socket", filepath.Join(workDir, "control.sock"))
+	exited := make(chan struct{})
+
+	sessionErr := report.Run("session", func(step *e2e.Step) error {
+		if err := proxy.Start(); err != nil {
+			close(exited)
+			return fmt.Errorf("failed to start proxy: %w", err)
+		}
+		go func() {
+			proxy.Wait()
+			close(exited)
+		}()
+		waitCtx, waitCancel := context.WithTimeout(ctx, sessionTimeout)
+		defer waitCancel()
+		return e2e.WaitForProxy(waitCtx, proxyAddr, exited)
+	})
+	defer stopCIProxy(proxy, exited)
+	if sessionErr != nil {
+		report.Skip("smoke", "no session")
+		report.Skip("benchmark", "no session")
+		return
+	}
+
+	report.Run("smoke", func(step *e2e.Step) error {
+		fetchCtx, fetchCancel := context.WithTimeout(ctx, 30*time.Second)
+		defer fetchCancel()
+		body, err := e2e.Fetch(fetchCtx, proxyAddr, smokeURL)
+		if err != nil {
+			return err
+		}
+		if len(body) > 200 {
+			body = body[:200]
+		}
+		step.Log("%s", body)
+		return nil
+	})
+
+	switch {
+	case benchmarkURL != "":
+		report.Run("benchmark", func(step *e2e.Step) error {
+			result, err := e2e.Download(ctx, proxyAddr, benchmarkURL)
+			if err != nil {
+				return err
+			}
+			step.Metric("bytes", float64(result.Bytes))
+			step.Metric("time_to_first_byte_ms", float64(result.TimeToFirst.Milliseconds()))
+			step.Metric("throughput_mbps", result.BitsPerSecond/1e6)
+			step.Log("%.1f Mbit/s, first byte after %v", result.BitsPerSecond/1e6, result.TimeToFirst.Round(time.Millisecond))
+			return nil
+		})
+	case benchmarkDuration > 0:
+		report.Run("benchmark", func(step *e2e.Step) error {
+			return runTunnelBenchmark(ctx, step, proxyAddr, benchmarkDuration)
+		})
+	default:
+		report.Skip("benchmark", "--benchmark-duration is 0")
+	}
+}
+
+// runTunnelBenchmark measures the tunnel against the Lambda's own test targets
+func runTunnelBenchmark(ctx context.Context, step *e2e.Step, proxyAddr string, duration time.Duration) error {
+	latency, err := e2e.TunnelLatency(ctx, proxyAddr, 10)
+	if err != nil {
+		return err
+	}
+	download, err := e2e.TunnelDownload(ctx, proxyAddr, duration)
+	if err != nil {
+		return err
+	}
+	upload, err := e2e.TunnelUpload(ctx, proxyAddr, duration)
+	if err != nil {
+		return err
+	}
+	step.Metric("latency_ms", float64(latency.Median.Microseconds())/1000)
+	step.Metric("bytes", float64(download.Bytes))
+	step.Metric("time_to_first_byte_ms", float64(download.TimeToFirst.Milliseconds()))
+	step.Metric("throughput_mbps", download.BitsPerSecond/1e6)
+	step.Metric("upload_bytes", float64(upload.Bytes))
+	step.Metric("upload_mbps", upload.BitsPerSecond/1e6)
+	step.Log("%.1f Mbit/s down, %.1f Mbit/s up, %v round trip", download.BitsPerSecond/1e6,
+		upload.BitsPerSecond/1e6, latency.Median.Round(100*time.Microsecond))
+	return nil
+}
+
+// stopCIProxy interrupts the proxy so it shuts down its sessions, killing it if it doesn't exit
+func stopCIProxy(proxy *exec.Cmd, exited <-chan struct{}) {
+	if proxy.Process == nil {
+		return
+	}
+	if err := proxy.Process.Signal(os.Interrupt); err != nil {
+		proxy.Process.Kill()
+	}
+	select {
+	case <-exited:
+	case <-time.After(15 * time.Second):
+		proxy.Process.Kill()
+		<-exited
+	}
+}
+
+// ciStackName returns a random name for an ephemeral stack
+func ciStackName() (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to generate stack name: %w", err)
+	}
+	return ciStackPrefix + hex.EncodeToString(suffix), nil
+}
+
+// writeCIReports writes the JUnit and JSON reports requested
+func writeCIReports(report *e2e.Report, junitPath, jsonPath string) error {
+	for _, output := range []struct {
+		path  string
+		write func(*os.File) error
+	}{
+		{junitPath, func(f *os.File) error { return report.WriteJUnit(f) }},
+		{jsonPath, func(f *os.File) error { return report.WriteJSON(f) }},
+	} {
+		if output.path == "" {
+			continue
+		}
+		f, err := os.Create(output.path)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", output.path, err)
+		}
+		err = output.write(f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", output.path, err)
+		}
+	}
+	return nil
+}
+
+// printCISummary prints one line per step
+func printCISummary(report *e2e.Report) {
+	ui.Printf("\n📋 Results\n")
+	ui.Printf("----------\n")
+	for _, result := range report.Results {
+		switch {
+		case result.Skipped:
+			ui.Printf("⏭️  %-10s skipped (%s)\n", result.Name, result.Output)
+		case result.Passed:
+			detail := ""
+			if result.Output != "" {
+				detail = " - " + result.Output
+			}
+			ui.Printf("✅ %-10s %v%s\n", result.Name, result.Duration.Round(time.Second), detail)
+		default:
+			ui.Printf("❌ %-10s %v: %s\n", result.Name, result.Duration.Round(time.Second), result.Error)
+		}
+	}
+	ui.Println()
+}
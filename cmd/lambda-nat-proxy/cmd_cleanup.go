@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	awsclients "github.com/dan-v/lambda-nat-punch-proxy/internal/aws"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/s3"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/ui"
+)
+
+// cleanupCmd deletes stale coordination and response objects
+var cleanupCmd = &cobra.Command{
+	Use:   "cleanup",
+	Short: "Delete stale coordination objects from the S3 bucket",
+	Long: `Delete the coordination and response objects sessions left in the
+deployment's S3 bucket.
+
+The proxy deletes a session's objects once its tunnel closes, and objects
+older than the Lambda timeout when it starts; the bucket's lifecycle rules
+delete anything left after a day. This command does the same cleanup on
+demand, for example after a crash.
+
+By default only objects older than the mode's Lambda timeout are deleted,
+which can't belong to a live session. --older-than 0 deletes every one,
+which fails any launch a running proxy has in progress.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCleanup(cmd)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cleanupCmd)
+
+	cleanupCmd.Flags().StringP("region", "r", "", "AWS region (overrides config)")
+	cleanupCmd.Flags().StringP("stack-name", "s", "", "CloudFormation stack name")
+	cleanupCmd.Flags().Duration("older-than", 0, "Delete objects last written longer ago than this (default: the mode's Lambda timeout)")
+}
+
+func runCleanup(cmd *cobra.Command) error {
+	ctx := context.Background()
+
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return configError(err)
+	}
+	if region, _ := cmd.Flags().GetString("region"); cmd.Flags().Changed("region") {
+		cfg.AWS.Region = region
+	}
+	if stackName, _ := cmd.Flags().GetString("stack-name"); cmd.Flags().Changed("stack-name") {
+		cfg.Deployment.StackName = stackName
+	}
+	if err := applyStackSelection(ctx, cmd, cfg); err != nil {
+		return err
+	}
+	if errors := config.ValidateCLIConfig(cfg); len(errors) > 0 {
+		ui.Printf("Configuration validation errors:\n")
+		for _, err := range errors {
+			ui.Printf("  - %s\n", err.Error())
+		}
+		return configError(fmt.Errorf("configuration validation failed"))
+	}
+
+	stack, err := autoDetectStack(cfg)
+	if err != nil {
+		return infraError(err)
+	}
+	maxAge := time.Duration(cfg.ToConfig(stack.CoordinationBucketName).ModeConfig.LambdaTimeout) * time.Second
+	if cmd.Flags().Changed("older-than") {
+		maxAge, _ = cmd.Flags().GetDuration("older-than")
+		if maxAge < 0 {
+			return configError(fmt.Errorf("--older-than cannot be negative"))
+		}
+	}
+
+	clientFactory, err := awsclients.NewClientFactory(cfg)
+	if err != nil {
+		return credentialsError(fmt.Errorf("failed to create AWS clients: %w", err))
+	}
+	clients := clientFactory.GetClients()
+
+	result, err := s3.CleanupStale(ctx, clients.S3, stack.CoordinationBucketName, maxAge)
+	if err != nil {
+		return infraError(fmt.Errorf("failed to clean up %s: %w", stack.CoordinationBucketName, err))
+	}
+	if result.Found == 0 {
+		ui.Printf("No coordination objects older than %v in %s\n", maxAge, stack.CoordinationBucketName)
+		return nil
+	}
+	ui.Printf("Deleted %d of %d coordination objects older than %v from %s\n", result.Deleted, result.Found, maxAge, stack.CoordinationBucketName)
+	if result.Deleted < result.Found {
+		return infraError(fmt.Errorf("%d objects could not be deleted", result.Found-result.Deleted))
+	}
+	return nil
+}
@@ -2,14 +2,20 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"reflect"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
 	
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/ui"
 )
 
 // configCmd represents the config command
@@ -51,17 +57,29 @@ var configShowCmd = &cobra.Command{
 	Long: `Display the current configuration values.
 
 This command shows the merged configuration from all sources
-(defaults, config file, environment variables, and command line flags)
-and indicates where each value comes from.`,
+(defaults, config file, environment variables, and command line flags).
+The table format also shows where each value comes from; the JSON format
+prints only the configuration, for scripts.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return runConfigShow(cmd)
 	},
 }
 
+// configReloadCmd represents the config reload command
+var configReloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Apply configuration changes to the running proxy",
+	Long:  reloadLong,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runReload(cmd)
+	},
+}
+
 func init() {
 	// Add subcommands to config
 	configCmd.AddCommand(configInitCmd)
 	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configReloadCmd)
 	
 	// Add config init specific flags
 	configInitCmd.Flags().StringP("output", "o", "", "Output file path (defaults to XDG config directory)")
@@ -69,6 +87,11 @@ func init() {
 	
 	// Add config show specific flags
 	configShowCmd.Flags().StringP("format", "", "yaml", "Output format (yaml, json, table)")
+	configShowCmd.Flags().StringP("region", "r", "", "AWS region (overrides config)")
+	configShowCmd.Flags().StringP("stack-name", "s", "", "CloudFormation stack name (overrides config)")
+	
+	// Add config reload specific flags
+	addControlSocketFlag(configReloadCmd)
 }
 
 // runConfigInit implements the config init command
@@ -83,12 +106,12 @@ func runConfigInit(cmd *cobra.Command) error {
 	
 	// Check if file already exists
 	if _, err := os.Stat(outputPath); err == nil && !force {
-		fmt.Printf("Configuration file already exists at: %s\n\n", outputPath)
-		fmt.Println("What would you like to do?")
-		fmt.Println("1. View current config (recommended)")
-		fmt.Println("2. Overwrite with fresh defaults")
-		fmt.Println("3. Cancel")
-		fmt.Print("\nChoose an option [1/2/3]: ")
+		ui.Printf("Configuration file already exists at: %s\n\n", outputPath)
+		ui.Println("What would you like to do?")
+		ui.Println("1. View current config (recommended)")
+		ui.Println("2. Overwrite with fresh defaults")
+		ui.Println("3. Cancel")
+		ui.Print("\nChoose an option [1/2/3]: ")
 		
 		reader := bufio.NewReader(os.Stdin)
 		response, err := reader.ReadString('\n')
@@ -100,19 +123,19 @@ func runConfigInit(cmd *cobra.Command) error {
 		switch response {
 		case "1", "":
 			// Show current config
-			fmt.Println("\nCurrent configuration:")
-			fmt.Println("─────────────────────")
+			ui.Println("\nCurrent configuration:")
+			ui.Println("─────────────────────")
 			
 			// Load and display config directly
 			configPath, _ := cmd.Flags().GetString("config")
-			cfg, err := config.LoadCLIConfig(configPath)
+			cfg, err := loadConfig(cmd)
 			if err != nil {
-				return fmt.Errorf("failed to load configuration: %w", err)
+				return configError(err)
 			}
 			
 			// Show config source information
 			configSource := getConfigSource(configPath)
-			fmt.Printf("# Configuration loaded from: %s\n\n", configSource)
+			ui.Printf("# Configuration loaded from: %s\n\n", configSource)
 			
 			// Display config in YAML format
 			encoder := yaml.NewEncoder(os.Stdout)
@@ -122,16 +145,16 @@ func runConfigInit(cmd *cobra.Command) error {
 				return fmt.Errorf("failed to display config: %w", err)
 			}
 			
-			fmt.Printf("\nTo edit: %s\n", outputPath)
+			ui.Printf("\nTo edit: %s\n", outputPath)
 			return nil
 		case "2":
 			// Continue with overwrite
-			fmt.Println("Overwriting existing config file...")
+			ui.Println("Overwriting existing config file...")
 		case "3":
-			fmt.Println("Operation cancelled.")
+			ui.Println("Operation cancelled.")
 			return nil
 		default:
-			fmt.Println("Invalid option. Operation cancelled.")
+			ui.Println("Invalid option. Operation cancelled.")
 			return nil
 		}
 	}
@@ -141,42 +164,105 @@ func runConfigInit(cmd *cobra.Command) error {
 		return fmt.Errorf("failed to create config file: %w", err)
 	}
 	
-	fmt.Printf("Configuration file created: %s\n", outputPath)
-	fmt.Println("Edit this file to customize your lambda-nat-proxy settings.")
+	ui.Printf("Configuration file created: %s\n", outputPath)
+	ui.Println("Edit this file to customize your lambda-nat-proxy settings.")
 	
 	return nil
 }
 
 // runConfigShow implements the config show command
 func runConfigShow(cmd *cobra.Command) error {
+	format, _ := cmd.Flags().GetString("format")
+	if format != "yaml" && format != "json" && format != "table" {
+		return configError(fmt.Errorf("unsupported format: %s (use yaml, json, or table)", format))
+	}
+	
 	// Load configuration
 	configPath, _ := cmd.Flags().GetString("config")
-	cfg, err := config.LoadCLIConfig(configPath)
+	cfg, sources, err := loadConfigWithProvenance(cmd, configPath)
 	if err != nil {
-		return fmt.Errorf("failed to load configuration: %w", err)
+		return configError(err)
+	}
+	
+	// Apply command line flag overrides
+	if region, _ := cmd.Flags().GetString("region"); cmd.Flags().Changed("region") {
+		cfg.AWS.Region = region
+		sources.SetFlag("aws.region")
+	}
+	if stackName, _ := cmd.Flags().GetString("stack-name"); cmd.Flags().Changed("stack-name") {
+		cfg.Deployment.StackName = stackName
+		sources.SetFlag("deployment.stack_name")
+	}
+	if stack, _ := cmd.Flags().GetString("stack"); stack != "" {
+		mode := cfg.Deployment.Mode
+		if err := applyStackSelection(context.Background(), cmd, cfg); err != nil {
+			return err
+		}
+		sources.SetFlag("aws.region")
+		sources.SetFlag("deployment.stack_name")
+		if cfg.Deployment.Mode != mode {
+			sources.SetFlag("deployment.mode")
+		}
+	}
+	
+	if format == "json" {
+		data, err := json.MarshalIndent(cfg, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
 	}
 	
 	// Show config source information
 	configSource := getConfigSource(configPath)
-	fmt.Printf("# Configuration loaded from: %s\n\n", configSource)
+	if profile := selectedProfile(cmd); profile != "" {
+		configSource += fmt.Sprintf(" (profile %s)", profile)
+	}
+	ui.Printf("# Configuration loaded from: %s\n\n", configSource)
 	
-	format, _ := cmd.Flags().GetString("format")
+	if format == "table" {
+		w := tabwriter.NewWriter(ui.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+		for _, setting := range config.Settings(cfg) {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", setting.Key, formatSettingValue(setting.Value), sources.Source(setting.Key))
+		}
+		return w.Flush()
+	}
 	
-	switch format {
-	case "yaml":
-		encoder := yaml.NewEncoder(os.Stdout)
-		encoder.SetIndent(2)
-		defer encoder.Close()
-		return encoder.Encode(cfg)
-	case "json":
-		// Could implement JSON output here
-		return fmt.Errorf("JSON format not yet implemented")
-	case "table":
-		// Could implement table format here
-		return fmt.Errorf("table format not yet implemented")
-	default:
-		return fmt.Errorf("unsupported format: %s", format)
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	defer encoder.Close()
+	return encoder.Encode(cfg)
+}
+
+// formatSettingValue renders a configuration value on one line
+func formatSettingValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return `""`
+		}
+		return v
+	case time.Duration:
+		return v.String()
+	}
+	
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map:
+		if rv.Len() == 0 && rv.Kind() == reflect.Map {
+			return "{}"
+		}
+		if rv.Len() == 0 {
+			return "[]"
+		}
+		data, err := json.Marshal(value)
+		if err == nil {
+			return string(data)
+		}
 	}
+	return fmt.Sprint(value)
 }
 
 // getConfigSource returns a user-friendly description of where config is loaded from
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+
+	awsclients "github.com/dan-v/lambda-nat-punch-proxy/internal/aws"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/cost"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/deploy"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/ui"
+)
+
+// maxCostPeriod keeps the projection based on recent usage
+const maxCostPeriod = 60 * 24 * time.Hour
+
+// costCmd estimates the monthly bill of a deployment
+var costCmd = &cobra.Command{
+	Use:   "cost",
+	Short: "Estimate the monthly cost of the deployment",
+	Long: `Estimate what the deployment costs per month from its recent usage, and
+what it would cost in each performance mode.
+
+Usage over --period is read from CloudWatch and projected to a month:
+- Lambda invocations and run time, billed by the memory of the mode
+- Data transfer out of AWS, from the BytesTransferred metric the Lambda
+  publishes when proxy.lambda_metrics is enabled (otherwise pass --data-gb)
+- S3 requests, a few per session for the coordination objects
+
+Mode projections assume the proxy stays up as long as it did: only the
+memory billed and how often sessions rotate change. Prices are on-demand
+us-east-1 prices without the free tier, so treat the result as a guide.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCost(cmd)
+	},
+}
+
+// CostReport is the output of the cost command
+type CostReport struct {
+	StackName    string          `json:"stack_name" yaml:"stack_name"`
+	Region       string          `json:"region" yaml:"region"`
+	FunctionName string          `json:"function_name" yaml:"function_name"`
+	Mode         string          `json:"mode" yaml:"mode"`
+	Architecture string          `json:"architecture" yaml:"architecture"`
+	Usage        cost.Usage      `json:"usage" yaml:"usage"`
+	Pricing      cost.Pricing    `json:"pricing" yaml:"pricing"`
+	Modes        []cost.Estimate `json:"modes" yaml:"modes"`
+}
+
+func init() {
+	rootCmd.AddCommand(costCmd)
+
+	costCmd.Flags().StringP("region", "r", "", "AWS region (overrides config)")
+	costCmd.Flags().StringP("stack-name", "s", "", "CloudFormation stack name")
+	costCmd.Flags().Duration("period", 7*24*time.Hour, "Usage window to project from (at most 1440h)")
+	costCmd.Flags().Float64("data-gb", 0, "Monthly data transfer in GB to assume when the Lambda doesn't publish metrics")
+	costCmd.Flags().String("format", "table", "Output format (table, json, yaml)")
+}
+
+func runCost(cmd *cobra.Command) error {
+	ctx := context.Background()
+
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return configError(err)
+	}
+	if region, _ := cmd.Flags().GetString("region"); cmd.Flags().Changed("region") {
+		cfg.AWS.Region = region
+	}
+	if stackName, _ := cmd.Flags().GetString("stack-name"); cmd.Flags().Changed("stack-name") {
+		cfg.Deployment.StackName = stackName
+	}
+	if err := applyStackSelection(ctx, cmd, cfg); err != nil {
+		return err
+	}
+	if errors := config.ValidateCLIConfig(cfg); len(errors) > 0 {
+		ui.Printf("Configuration validation errors:\n")
+		for _, err := range errors {
+			ui.Printf("  - %s\n", err.Error())
+		}
+		return configError(fmt.Errorf("configuration validation failed"))
+	}
+
+	period, _ := cmd.Flags().GetDuration("period")
+	if period < time.Hour || period > maxCostPeriod {
+		return configError(fmt.Errorf("--period must be between 1h and %s", maxCostPeriod))
+	}
+	dataGB, _ := cmd.Flags().GetFloat64("data-gb")
+	if dataGB < 0 {
+		return configError(fmt.Errorf("--data-gb cannot be negative"))
+	}
+
+	clientFactory, err := awsclients.NewReadOnlyClientFactory(cfg, "")
+	if err != nil {
+		return credentialsError(fmt.Errorf("failed to create AWS clients: %w", err))
+	}
+	if err := clientFactory.ValidateCredentials(ctx); err != nil {
+		return credentialsError(fmt.Errorf("invalid AWS credentials: %w", err))
+	}
+	clients := clientFactory.GetClients()
+
+	lambdaInfo, err := deploy.NewLambdaDeployer(clients, cfg).GetFunctionInfo(ctx)
+	if err != nil {
+		return infraError(fmt.Errorf("failed to find the Lambda function (is the stack deployed?): %w", err))
+	}
+
+	usage, err := cost.ReadUsage(ctx, clients.CloudWatch, lambdaInfo.FunctionName, cfg.Proxy.LambdaMetrics.Settings().Namespace, period)
+	if err != nil {
+		return infraError(fmt.Errorf("failed to read usage: %w", err))
+	}
+	if !usage.BytesMeasured && cmd.Flags().Changed("data-gb") {
+		usage.BytesTransferred = dataGB * (1 << 30) * float64(period) / float64(cost.Month)
+	}
+
+	pricing := cost.DefaultPricing().ForArchitecture(lambdaInfo.Architecture)
+	mode, _, _ := config.ResolveMode(cfg.Deployment.Mode)
+	report := &CostReport{
+		StackName:    cfg.Deployment.StackName,
+		Region:       cfg.AWS.Region,
+		FunctionName: lambdaInfo.FunctionName,
+		Mode:         string(mode),
+		Architecture: lambdaInfo.Architecture,
+		Usage:        usage,
+		Pricing:      pricing,
+		Modes:        pricing.ProjectModes(usage, mode),
+	}
+
+	format, _ := cmd.Flags().GetString("format")
+	switch strings.ToLower(format) {
+	case "json":
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON: %w", err)
+		}
+		fmt.Println(string(data))
+	case "yaml":
+		data, err := yaml.Marshal(report)
+		if err != nil {
+			return fmt.Errorf("failed to marshal YAML: %w", err)
+		}
+		fmt.Print(string(data))
+	case "table":
+		outputCostTable(report, cmd.Flags().Changed("data-gb"))
+	default:
+		return configError(fmt.Errorf("unsupported format: %s (use table, json, or yaml)", format))
+	}
+	return nil
+}
+
+// outputCostTable prints the measured usage and the monthly projection for each mode
+func outputCostTable(report *CostReport, dataAssumed bool) {
+	ui.Printf("\n💰 Lambda NAT Proxy Cost Estimate\n")
+	ui.Printf("=================================\n\n")
+
+	ui.Printf("📊 Usage (last %s)\n", formatAge(report.Usage.Period))
+	ui.Printf("------------------\n")
+	ui.Printf("Function:    %s (%s mode, %s)\n", report.FunctionName, report.Mode, report.Architecture)
+	ui.Printf("Invocations: %.0f\n", report.Usage.Invocations)
+	ui.Printf("Run time:    %s\n", (time.Duration(report.Usage.DurationSeconds) * time.Second).String())
+	switch {
+	case report.Usage.BytesMeasured:
+		ui.Printf("Data:        %.2f GB\n", report.Usage.BytesTransferred/(1<<30))
+	case dataAssumed:
+		ui.Printf("Data:        %.2f GB (from --data-gb)\n", report.Usage.BytesTransferred/(1<<30))
+	default:
+		ui.Printf("Data:        unknown (enable proxy.lambda_metrics or pass --data-gb)\n")
+	}
+	ui.Println()
+
+	ui.Printf("🧾 Projected Monthly Cost (USD)\n")
+	ui.Printf("-------------------------------\n")
+	w := tabwriter.NewWriter(ui.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "MODE\tMEMORY\tSESSIONS\tCOMPUTE\tREQUESTS\tDATA\tS3\tTOTAL\t")
+	for _, estimate := range report.Modes {
+		name := estimate.Mode
+		if estimate.Mode == report.Mode {
+			name += " *"
+		}
+		fmt.Fprintf(w, "%s\t%d MB\t%.0f\t$%.2f\t$%.2f\t$%.2f\t$%.2f\t$%.2f\t\n", name, estimate.MemoryMB,
+			estimate.Invocations, estimate.LambdaCompute, estimate.LambdaRequests,
+			estimate.DataTransfer, estimate.S3Requests, estimate.Total)
+	}
+	w.Flush()
+	ui.Printf("\n* deployed mode. Prices: us-east-1 on-demand, excluding the free tier.\n")
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/control"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/ui"
+)
+
+// daemonStartTimeout bounds how long run --daemon waits for the first session
+const daemonStartTimeout = 90 * time.Second
+
+// stopCmd stops a proxy running on this machine
+var stopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Stop the proxy running in the background",
+	Long: `Stop a proxy started on this machine, usually with 'run --daemon'.
+
+The proxy closes its sessions and exits as it does on Ctrl+C. The command
+waits for it to exit, up to --timeout.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runStop(cmd)
+	},
+}
+
+// reloadLong describes reload and config reload
+const reloadLong = `Re-read the configuration file, and the policy file it names, in a proxy
+running on this machine, without dropping its sessions or connections.
+
+The session pool, drain policy, ACL, policy file, resolvers, idle and
+connect timeouts, stream lifetime, compression, bandwidth caps, log level,
+privacy mode and feature flags change at once. New
+connections get the new settings, open ones keep those they started with,
+and Lambdas launched from now on enforce the new allow and deny rules. Other settings,
+such as the port, mode and stack, need a restart; the proxy logs which.
+
+A running proxy also reloads when the file is saved and on SIGHUP.`
+
+// reloadCmd reloads the configuration of a proxy running on this machine
+var reloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Reload the configuration of the running proxy",
+	Long:  reloadLong,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runReload(cmd)
+	},
+}
+
+// resetLaunchCmd clears the launch backoff of a proxy running on this machine
+var resetLaunchCmd = &cobra.Command{
+	Use:   "reset-launch",
+	Short: "Clear the running proxy's launch backoff and launch a session now",
+	Long: `Make a proxy running on this machine forget its failed session launches.
+
+After repeated failures, for example during an AWS outage, the proxy waits
+longer and longer between launch attempts. Once the cause is fixed, this
+command clears the failures, their cooldown and any launch stuck in
+progress, and the proxy launches a session at once if it has none. This
+saves restarting the proxy. The proxy logs every reset.
+
+The same reset is available as POST /api/launch/reset on the dashboard port.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runResetLaunch(cmd)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(stopCmd)
+	rootCmd.AddCommand(reloadCmd)
+	rootCmd.AddCommand(resetLaunchCmd)
+
+	addControlSocketFlag(stopCmd)
+	stopCmd.Flags().Duration("timeout", 30*time.Second, "How long to wait for the proxy to exit")
+	addControlSocketFlag(reloadCmd)
+	addControlSocketFlag(resetLaunchCmd)
+}
+
+// addControlSocketFlag adds the --control-socket flag to cmd
+func addControlSocketFlag(cmd *cobra.Command) {
+	cmd.Flags().String("control-socket", "", "Control socket of the running proxy (default "+control.DefaultSocketPath()+")")
+}
+
+// controlSocketPath returns the control socket chosen for cmd
+func controlSocketPath(cmd *cobra.Command) string {
+	if path, _ := cmd.Flags().GetString("control-socket"); path != "" {
+		return path
+	}
+	return control.DefaultSocketPath()
+}
+
+// notRunningError explains that nothing answers on the control socket
+func notRunningError(path string) error {
+	return fmt.Errorf("no proxy is running (nothing answers on %s); start one with: lambda-nat-proxy run --daemon", path)
+}
+
+func runStop(cmd *cobra.Command) error {
+	path := controlSocketPath(cmd)
+	client := control.NewClient(path)
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+
+	status, err := client.Status(context.Background())
+	if errors.Is(err, control.ErrNotRunning) {
+		return notRunningError(path)
+	}
+	if err != nil {
+		return err
+	}
+	if err := client.Stop(context.Background()); err != nil {
+		return fmt.Errorf("failed to stop proxy: %w", err)
+	}
+	ui.Printf("Stopping proxy (PID %d)...\n", status.PID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := client.WaitStopped(ctx); err != nil {
+		return fmt.Errorf("proxy (PID %d) did not exit within %v: %w", status.PID, timeout, err)
+	}
+	ui.Println("✅ Proxy stopped")
+	return nil
+}
+
+func runReload(cmd *cobra.Command) error {
+	path := controlSocketPath(cmd)
+	err := control.NewClient(path).Reload(context.Background())
+	if errors.Is(err, control.ErrNotRunning) {
+		return notRunningError(path)
+	}
+	if err != nil {
+		return configError(fmt.Errorf("reload failed, the previous configuration stays in effect: %w", err))
+	}
+	ui.Println("✅ Configuration reloaded")
+	return nil
+}
+
+func runResetLaunch(cmd *cobra.Command) error {
+	path := controlSocketPath(cmd)
+	reset, err := control.NewClient(path).ResetLaunch(context.Background())
+	if errors.Is(err, control.ErrNotRunning) {
+		return notRunningError(path)
+	}
+	if err != nil {
+		return fmt.Errorf("launch reset failed: %w", err)
+	}
+
+	ui.Printf("%s Cleared %d failed launch attempts", ui.OK, reset.FailedAttempts)
+	if reset.CooldownLeft > 0 {
+		ui.Printf(" and a %s cooldown", reset.CooldownLeft.Round(time.Second))
+	}
+	ui.Println()
+	if reset.LaunchPending {
+		ui.Printf("%s A launch was still marked in progress; if it finishes, a duplicate session is discarded\n", ui.Warn)
+	}
+	switch {
+	case reset.OverBudget:
+		ui.Printf("%s The budget is used up, so no session is launched until the next period\n", ui.Warn)
+	case reset.HasPrimary:
+		ui.Println("A primary session is up, so nothing needs launching")
+	default:
+		ui.Println("Launching a session now; follow it with: lambda-nat-proxy status --local")
+	}
+	return nil
+}
+
+// startDaemon starts run again in the background, without --daemon and with
+// its output going to the log file, then waits for it to be ready
+func startDaemon(cmd *cobra.Command) error {
+	logPath, _ := cmd.Flags().GetString("log-file")
+	if logPath == "" {
+		logPath = control.DefaultLogPath()
+	}
+	pidPath, _ := cmd.Flags().GetString("pid-file")
+	if pidPath == "" {
+		pidPath = control.DefaultPIDPath()
+	}
+	socketPath := controlSocketPath(cmd)
+	client := control.NewClient(socketPath)
+	if status, err := client.Status(context.Background()); err == nil {
+		return configError(fmt.Errorf("a proxy is already running (PID %d); stop it with: lambda-nat-proxy stop", status.PID))
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate lambda-nat-proxy binary: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(logPath), 0700); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer logFile.Close()
+
+	var args []string
+	for _, arg := range os.Args[1:] {
+		if arg != "--daemon" && !strings.HasPrefix(arg, "--daemon=") {
+			args = append(args, arg)
+		}
+	}
+	args = append(args, "--no-browser", "--pid-file", pidPath, "--control-socket", socketPath)
+
+	child := exec.Command(self, args...)
+	child.Stdout = logFile
+	child.Stderr = logFile
+	child.SysProcAttr = detachedProcAttr()
+	if err := child.Start(); err != nil {
+		return fmt.Errorf("failed to start proxy in the background: %w", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- child.Wait() }()
+
+	ui.Printf("Starting proxy in the background (PID %d), waiting for the first session...\n", child.Process.Pid)
+	deadline := time.After(daemonStartTimeout)
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-exited:
+			return networkError(fmt.Errorf("proxy exited during startup (%v); see %s", err, logPath))
+		case <-deadline:
+			return networkError(fmt.Errorf("proxy not ready after %v; it is still starting (PID %d), see %s", daemonStartTimeout, child.Process.Pid, logPath))
+		case <-ticker.C:
+		}
+		status, err := client.Status(context.Background())
+		if err != nil {
+			continue
+		}
+		ui.Printf("✅ Proxy running (PID %d), SOCKS5 proxy at localhost:%d\n", status.PID, status.SOCKS5Port)
+		ui.Printf("   Logs:   %s\n", logPath)
+		ui.Printf("   Status: lambda-nat-proxy status --local\n")
+		ui.Printf("   Stop:   lambda-nat-proxy stop\n")
+		return nil
+	}
+}
+
+// runLocalStatus prints the status of the proxy running on this machine
+func runLocalStatus(cmd *cobra.Command, format string) error {
+	path := controlSocketPath(cmd)
+	status, err := control.NewClient(path).Status(context.Background())
+	if errors.Is(err, control.ErrNotRunning) {
+		return notRunningError(path)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(format) {
+	case "json":
+		data, err := json.MarshalIndent(status, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON: %w", err)
+		}
+		fmt.Println(string(data))
+	case "yaml":
+		data, err := yaml.Marshal(status)
+		if err != nil {
+			return fmt.Errorf("failed to marshal YAML: %w", err)
+		}
+		fmt.Print(string(data))
+	case "table":
+		outputLocalStatus(status)
+	default:
+		return configError(fmt.Errorf("unsupported format: %s (use table, json, or yaml)", format))
+	}
+	return nil
+}
+
+// printCryptoWarnings lists the weaknesses in the sessions' negotiated encryption
+func printCryptoWarnings(sessions []control.SessionStatus) {
+	for _, session := range sessions {
+		for _, warning := range session.Crypto.Warnings {
+			ui.Printf("%s Session %s: %s\n", ui.Warn, session.ID, warning)
+		}
+	}
+}
+
+// outputLocalStatus prints a running proxy's status and sessions
+func outputLocalStatus(status *control.Status) {
+	ui.Printf("\n🖥️  Local Proxy\n")
+	ui.Printf("--------------\n")
+	ui.Printf("PID:         %d (%s)\n", status.PID, status.Version)
+	ui.Printf("Uptime:      %s\n", time.Since(status.StartedAt).Round(time.Second))
+	ui.Printf("Stack:       %s (%s, %s mode)\n", status.StackName, status.Region, status.Mode)
+	ui.Printf("SOCKS5:      localhost:%d\n", status.SOCKS5Port)
+	if status.PolicyFile != "" {
+		ui.Printf("Policy:      %s\n", status.PolicyFile)
+	}
+	ui.Printf("Connections: %d\n", status.Connections)
+	regions := make([]string, 0, len(status.Evacuated))
+	for region := range status.Evacuated {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	for _, region := range regions {
+		ui.Printf("Evacuated:   %s until %s\n", region, status.Evacuated[region].Local().Format(time.Kitchen))
+	}
+	ui.Println()
+
+	printSessionTable(status.Sessions)
+}
+
+// printSessionTable lists a running proxy's sessions
+func printSessionTable(sessions []control.SessionStatus) {
+	if len(sessions) == 0 {
+		ui.Printf("No sessions\n\n")
+		return
+	}
+	w := tabwriter.NewWriter(ui.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ROLE\tSESSION\tHEALTHY\tUP\tTTL\tSTREAMS\tENCRYPTION")
+	for _, session := range sessions {
+		role := session.Role
+		if session.Region != "" {
+			role += " (" + session.Region + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%v\t%v\t%s\t%d\t%s\n", role, session.ID, session.Healthy,
+			session.Uptime.Round(time.Second), formatTTL(session.TTL), session.ActiveStreams, session.Crypto)
+	}
+	w.Flush()
+	printCryptoWarnings(sessions)
+	ui.Println()
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -11,6 +13,8 @@ import (
 	awsclients "github.com/dan-v/lambda-nat-punch-proxy/internal/aws"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/deploy"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/ui"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
 )
 
 // deployCmd represents the deploy command
@@ -23,9 +27,14 @@ This command will:
 - Deploy CloudFormation stack with S3 bucket and IAM roles
 - Build and deploy the Lambda function
 - Configure S3 trigger for Lambda invocation
+- Create a function URL when deployment.coordination is function_url
 - Set appropriate memory and timeout based on performance mode
 
-The deployment process typically takes 2-5 minutes.`,
+The deployment process typically takes 2-5 minutes.
+
+With --generate-template, nothing is deployed. Deploy writes a template that
+also creates the Lambda function, with the Lambda package beside it, and
+prints a console link for someone without CLI access to create the stack.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return runDeploy(cmd)
 	},
@@ -35,10 +44,9 @@ func runDeploy(cmd *cobra.Command) error {
 	ctx := context.Background()
 	
 	// Load configuration
-	configPath, _ := cmd.Flags().GetString("config")
-	cfg, err := config.LoadCLIConfig(configPath)
+	cfg, err := loadConfig(cmd)
 	if err != nil {
-		return fmt.Errorf("failed to load configuration: %w", err)
+		return configError(err)
 	}
 	
 	// Apply command line flag overrides
@@ -51,44 +59,80 @@ func runDeploy(cmd *cobra.Command) error {
 	if stackName, _ := cmd.Flags().GetString("stack-name"); cmd.Flags().Changed("stack-name") {
 		cfg.Deployment.StackName = stackName
 	}
+	if architecture, _ := cmd.Flags().GetString("architecture"); cmd.Flags().Changed("architecture") {
+		cfg.Deployment.Architecture = architecture
+	}
+	if warmup, _ := cmd.Flags().GetDuration("warmup"); cmd.Flags().Changed("warmup") {
+		cfg.Deployment.Warmup = warmup
+	}
+	if variant, _ := cmd.Flags().GetString("template-variant"); cmd.Flags().Changed("template-variant") {
+		cfg.Deployment.TemplateVariant = variant
+	}
+	if modes, _ := cmd.Flags().GetStringSlice("session-modes"); cmd.Flags().Changed("session-modes") {
+		cfg.Deployment.SessionModes = make([]config.PerformanceMode, len(modes))
+		for i, mode := range modes {
+			cfg.Deployment.SessionModes[i] = config.PerformanceMode(mode)
+		}
+	}
+	
+	if err := applyStackSelection(ctx, cmd, cfg); err != nil {
+		return err
+	}
 	
 	// Validate configuration
 	if errors := config.ValidateCLIConfig(cfg); len(errors) > 0 {
-		fmt.Printf("❌ Configuration validation failed:\n\n")
+		ui.Printf("❌ Configuration validation failed:\n\n")
 		for _, err := range errors {
 			errMsg := err.Error()
-			fmt.Printf("  • %s\n", errMsg)
+			ui.Printf("  • %s\n", errMsg)
 			// Add specific guidance based on common configuration issues
 			if strings.Contains(errMsg, "region") {
-				fmt.Printf("    💡 Set region with: --region us-west-2 or in config file\n")
+				ui.Printf("    💡 Set region with: --region us-west-2 or in config file\n")
 			} else if strings.Contains(errMsg, "mode") {
-				fmt.Printf("    💡 Valid modes: test, normal, performance\n")
+				ui.Printf("    💡 Valid modes: test, normal, performance\n")
 			} else if strings.Contains(errMsg, "stack") {
-				fmt.Printf("    💡 Stack names must be 1-128 chars, letters/numbers/hyphens only\n")
+				ui.Printf("    💡 Stack names must be 1-128 chars, letters/numbers/hyphens only\n")
 			}
 		}
-		fmt.Printf("\n💡 Generate a sample config file with: lambda-nat-proxy config init\n")
-		return fmt.Errorf("please fix the configuration issues above")
+		ui.Printf("\n💡 Generate a sample config file with: lambda-nat-proxy config init\n")
+		return configError(fmt.Errorf("configuration validation failed: please fix the configuration issues above"))
 	}
 	
 	dryRun, _ := cmd.Flags().GetBool("dry-run")
 	if dryRun {
-		return runDeployDryRun(cfg)
+		return runDeployDryRun(ctx, cfg)
+	}
+	
+	// Fail before touching the stack if this build can't deploy the architecture
+	architecture := deploy.LambdaArchitecture(cfg)
+	if len((&EmbeddedLambdaProvider{}).GetLambdaBinary(architecture)) == 0 {
+		return configError(fmt.Errorf("this build has no embedded %s Lambda binary: rebuild with 'make build' or set deployment.architecture to %s", architecture, shared.ArchitectureX86_64))
+	}
+	
+	if templatePath, _ := cmd.Flags().GetString("generate-template"); templatePath != "" {
+		templateURL, _ := cmd.Flags().GetString("template-url")
+		codeBucket, _ := cmd.Flags().GetString("code-bucket")
+		return runDeployGenerateTemplate(cfg, templatePath, templateURL, codeBucket)
 	}
 	
 	log.Printf("Starting deployment in %s mode...", cfg.Deployment.Mode)
 	log.Printf("AWS Region: %s", cfg.AWS.Region)
 	log.Printf("Stack: %s", cfg.Deployment.StackName)
+	log.Printf("Lambda architecture: %s", architecture)
+	if cfg.Deployment.TemplateVariant != "" && cfg.Deployment.TemplateVariant != config.TemplateStandard {
+		log.Printf("Template variant: %s", cfg.Deployment.TemplateVariant)
+	}
+	showUpgradeNotes(true)
 	
 	// Create AWS clients
 	clientFactory, err := awsclients.NewClientFactory(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to create AWS clients: %w", err)
+		return credentialsError(fmt.Errorf("failed to create AWS clients: %w", err))
 	}
 	
 	// Validate AWS credentials
 	if err := clientFactory.ValidateCredentials(ctx); err != nil {
-		return fmt.Errorf("invalid AWS credentials: %w", err)
+		return credentialsError(fmt.Errorf("invalid AWS credentials: %w", err))
 	}
 	
 	clients := clientFactory.GetClients()
@@ -128,6 +172,7 @@ func runDeploy(cmd *cobra.Command) error {
 	}
 	
 	lambdaDeployer := deploy.NewLambdaDeployer(clients, cfg)
+	lambdaDeployer.SetVPCConfig(stackOutput.LambdaSubnetIDs, stackOutput.LambdaSecurityGroupID)
 	lambdaResult, err := lambdaDeployer.DeployLambdaFunction(ctx, buildResult.ZipPath, stackOutput.LambdaExecutionRoleArn)
 	if err != nil {
 		return fmt.Errorf("failed to deploy Lambda function: %w", err)
@@ -138,55 +183,231 @@ func runDeploy(cmd *cobra.Command) error {
 	log.Printf("   Memory: %d MB", lambdaResult.MemorySize)
 	log.Printf("   Timeout: %d seconds", lambdaResult.Timeout)
 	
+	// Once 'upgrade' has published the code behind the live alias, the
+	// triggers run the alias and this deploy waits for the next upgrade
+	invokeArn := lambdaResult.FunctionArn
+	if live, err := lambdaDeployer.LiveState(ctx); err != nil {
+		log.Printf("⚠️  %v", err)
+	} else if live.Pinned {
+		invokeArn = deploy.LiveARN(lambdaResult.FunctionArn)
+		log.Printf("⚠️  Sessions run the %s alias (version %s); run 'lambda-nat-proxy upgrade' to roll out this deploy", shared.LiveAlias, live.Version)
+	}
+	
 	// Step 3: Configure S3 triggers
 	log.Printf("Step 3/3: Configuring S3 triggers...")
 	
 	triggerDeployer := deploy.NewTriggerDeployer(clients, cfg)
-	if err := triggerDeployer.ConfigureS3Triggers(ctx, stackOutput.CoordinationBucketName, lambdaResult.FunctionArn); err != nil {
+	if err := triggerDeployer.ConfigureS3Triggers(ctx, stackOutput.CoordinationBucketName, invokeArn); err != nil {
 		return fmt.Errorf("failed to configure S3 triggers: %w", err)
 	}
 	
 	log.Printf("✅ S3 triggers configured successfully")
 	
+	// The function URL only exists while function_url coordination is chosen
+	var functionURL string
+	if cfg.Deployment.Coordination == shared.CoordinationFunctionURL {
+		functionURL, err = lambdaDeployer.ConfigureFunctionURL(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to configure function URL: %w", err)
+		}
+		log.Printf("✅ Function URL configured (IAM authentication, streamed responses)")
+	} else if err := lambdaDeployer.RemoveFunctionURL(ctx); err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+	
+	if cfg.Deployment.Warmup > 0 {
+		if err := lambdaDeployer.ConfigureWarmup(ctx, invokeArn); err != nil {
+			return fmt.Errorf("failed to schedule warm-up invocations: %w", err)
+		}
+		log.Printf("✅ Warm-up invocations scheduled every %v", cfg.Deployment.Warmup)
+	} else if err := lambdaDeployer.RemoveWarmup(ctx, invokeArn); err != nil {
+		log.Printf("⚠️  %v", err)
+	}
+	
+	if err := lambdaDeployer.PublishSessionModes(ctx); err != nil {
+		return fmt.Errorf("failed to publish session modes: %w", err)
+	}
+	
 	// Display deployment summary
-	fmt.Println("\n🎉 Deployment completed successfully!")
-	fmt.Printf("Stack Name: %s\n", stackOutput.StackName)
-	fmt.Printf("Region: %s\n", cfg.AWS.Region)
-	fmt.Printf("S3 Bucket: %s\n", stackOutput.CoordinationBucketName)
-	fmt.Printf("Lambda Function: %s\n", lambdaResult.FunctionName)
-	fmt.Printf("Performance Mode: %s\n", cfg.Deployment.Mode)
-	fmt.Println("\nYou can now run the proxy with:")
-	fmt.Printf("  lambda-nat-proxy run\n")
+	ui.Println("\n🎉 Deployment completed successfully!")
+	ui.Printf("Stack Name: %s\n", stackOutput.StackName)
+	ui.Printf("Region: %s\n", cfg.AWS.Region)
+	ui.Printf("S3 Bucket: %s\n", stackOutput.CoordinationBucketName)
+	ui.Printf("Lambda Function: %s\n", lambdaResult.FunctionName)
+	if functionURL != "" {
+		ui.Printf("Function URL: %s\n", functionURL)
+	}
+	ui.Printf("Performance Mode: %s\n", cfg.Deployment.Mode)
+	ui.Println("\nYou can now run the proxy with:")
+	ui.Printf("  lambda-nat-proxy run\n")
 	
 	return nil
 }
 
-func runDeployDryRun(cfg *config.CLIConfig) error {
-	fmt.Println("🔍 Dry run - showing what would be deployed:")
-	fmt.Printf("Stack Name: %s\n", cfg.Deployment.StackName)
-	fmt.Printf("AWS Region: %s\n", cfg.AWS.Region)
-	fmt.Printf("Performance Mode: %s\n", cfg.Deployment.Mode)
+func runDeployDryRun(ctx context.Context, cfg *config.CLIConfig) error {
+	ui.Println("🔍 Dry run - showing what would be deployed:")
+	ui.Printf("Stack Name: %s\n", cfg.Deployment.StackName)
+	ui.Printf("AWS Region: %s\n", cfg.AWS.Region)
+	ui.Printf("Performance Mode: %s\n", cfg.Deployment.Mode)
 	
 	modeConfig := config.GetModeConfigs()[cfg.Deployment.Mode]
-	fmt.Printf("Lambda Memory: %d MB\n", modeConfig.LambdaMemory)
-	fmt.Printf("Lambda Timeout: %d seconds\n", modeConfig.LambdaTimeout)
-	fmt.Printf("Session TTL: %v\n", modeConfig.SessionTTL)
+	ui.Printf("Lambda Memory: %d MB\n", modeConfig.LambdaMemory)
+	ui.Printf("Lambda Timeout: %d seconds\n", modeConfig.LambdaTimeout)
+	sessionTTL, _, _ := cfg.Rotation.Resolve(modeConfig)
+	ui.Printf("Session TTL: %v\n", sessionTTL)
+	
+	architecture := deploy.LambdaArchitecture(cfg)
+	if len((&EmbeddedLambdaProvider{}).GetLambdaBinary(architecture)) == 0 {
+		ui.Printf("Lambda Architecture: %s (⚠️  not embedded in this build)\n", architecture)
+	} else {
+		ui.Printf("Lambda Architecture: %s\n", architecture)
+	}
+	
+	template, err := deploy.GetCloudFormationTemplate(cfg, "")
+	if err != nil {
+		return configError(err)
+	}
+	if cfg.Deployment.TemplateVariant != "" {
+		ui.Printf("Template Variant: %s\n", cfg.Deployment.TemplateVariant)
+	}
+	if cfg.Deployment.TemplateOverlay != "" {
+		ui.Printf("Template Overlay: %s (valid)\n", cfg.Deployment.TemplateOverlay)
+	}
+	
+	printStackChangePreview(ctx, cfg, template)
+	
+	ui.Println("\nDeployment steps that would be performed:")
+	ui.Println("1. Deploy CloudFormation stack with S3 bucket and IAM roles")
+	ui.Println("2. Build Lambda deployment package")
+	ui.Println("3. Deploy Lambda function with performance mode settings")
+	ui.Println("4. Configure S3 bucket notifications to trigger Lambda")
+	step := 5
+	if cfg.Deployment.Coordination == shared.CoordinationFunctionURL {
+		ui.Printf("%d. Create an IAM-authenticated function URL for coordination\n", step)
+		step++
+	}
+	if cfg.Deployment.Warmup > 0 {
+		ui.Printf("%d. Schedule warm-up invocations every %v with EventBridge\n", step, cfg.Deployment.Warmup)
+		step++
+	}
+	for _, mode := range cfg.Deployment.SessionModes {
+		if mode != cfg.Deployment.Mode {
+			ui.Printf("%d. Publish %s mode (%d MB) as the '%s' alias for 'run --mode %s'\n", step, mode, config.GetModeConfigs()[mode].LambdaMemory, mode, mode)
+			step++
+		}
+	}
+	
+	ui.Println("\nTo perform actual deployment, run without --dry-run flag")
+	
+	return nil
+}
+
+// runDeployGenerateTemplate writes a template that creates the whole
+// deployment, with the Lambda package beside it, and prints how someone with
+// only console access creates the stack from them
+func runDeployGenerateTemplate(cfg *config.CLIConfig, templatePath, templateURL, codeBucket string) error {
+	binary := (&EmbeddedLambdaProvider{}).GetLambdaBinary(deploy.LambdaArchitecture(cfg))
+	template, err := deploy.GetDelegatedTemplate(cfg, deploy.CodeSHA256(binary))
+	if err != nil {
+		return configError(err)
+	}
 	
-	fmt.Println("\nDeployment steps that would be performed:")
-	fmt.Println("1. Deploy CloudFormation stack with S3 bucket and IAM roles")
-	fmt.Println("2. Build Lambda deployment package")
-	fmt.Println("3. Deploy Lambda function with performance mode settings")
-	fmt.Println("4. Configure S3 bucket notifications to trigger Lambda")
+	buildResult, err := deploy.NewLambdaBuilderWithProvider(cfg, &EmbeddedLambdaProvider{}).BuildLambdaPackage("build", "lambda")
+	if err != nil {
+		return fmt.Errorf("failed to build Lambda package: %w", err)
+	}
+	zipData, err := os.ReadFile(buildResult.ZipPath)
+	if err != nil {
+		return fmt.Errorf("failed to read Lambda package: %w", err)
+	}
+	zipPath := strings.TrimSuffix(templatePath, filepath.Ext(templatePath)) + ".zip"
+	if err := os.WriteFile(zipPath, zipData, 0644); err != nil {
+		return fmt.Errorf("failed to write Lambda package: %w", err)
+	}
+	if err := os.WriteFile(templatePath, []byte(template), 0644); err != nil {
+		return fmt.Errorf("failed to write template: %w", err)
+	}
 	
-	fmt.Println("\nTo perform actual deployment, run without --dry-run flag")
+	parameters := map[string]string{
+		"StackName": cfg.Deployment.StackName,
+		"Mode":      string(cfg.Deployment.Mode),
+		"CodeKey":   filepath.Base(zipPath),
+	}
+	if codeBucket != "" {
+		parameters["CodeBucket"] = codeBucket
+	}
 	
+	ui.Printf("%s Wrote %s and the Lambda package %s\n", ui.OK, templatePath, zipPath)
+	ui.Println("\nTo create the stack with only AWS console access:")
+	bucket := codeBucket
+	if bucket == "" {
+		bucket = "a bucket"
+	}
+	ui.Printf("1. Upload %s to %s in %s\n", filepath.Base(zipPath), bucket, cfg.AWS.Region)
+	if templateURL == "" {
+		ui.Printf("2. Open the link below, upload %s and name the stack %s\n", filepath.Base(templatePath), cfg.Deployment.StackName)
+	} else {
+		ui.Printf("2. Upload %s to %s and open the link below\n", filepath.Base(templatePath), templateURL)
+	}
+	ui.Println("3. Acknowledge that the stack creates IAM roles, and create it")
+	ui.Printf("\n  %s\n", deploy.QuickCreateURL(cfg.AWS.Region, cfg.Deployment.StackName, templateURL, parameters))
+	ui.Println("\nOnce the stack is created, use it from here with:")
+	ui.Printf("  lambda-nat-proxy run --stack %s\n", cfg.Deployment.StackName)
+	ui.Printf("  lambda-nat-proxy status --stack %s\n", cfg.Deployment.StackName)
 	return nil
 }
 
+// printStackChangePreview shows what deploying template would change in an
+// existing stack, using a change set that is deleted again. Without AWS
+// access the preview is skipped rather than failing the dry run.
+func printStackChangePreview(ctx context.Context, cfg *config.CLIConfig, template string) {
+	clientFactory, err := awsclients.NewClientFactory(cfg)
+	if err == nil {
+		err = clientFactory.ValidateCredentials(ctx)
+	}
+	if err != nil {
+		ui.Printf("\n⚠️  Skipping the stack change preview: %v\n", err)
+		return
+	}
+	
+	preview, err := deploy.NewStackDeployer(clientFactory.GetClients(), cfg).PreviewChanges(ctx, template)
+	if err != nil {
+		ui.Printf("\n⚠️  Could not preview stack changes: %v\n", err)
+		return
+	}
+	if !preview.StackExists {
+		ui.Printf("\nStack %s does not exist yet; all of its resources would be created\n", cfg.Deployment.StackName)
+		return
+	}
+	if len(preview.Changes) == 0 {
+		ui.Printf("\nStack changes: none, the stack is up to date\n")
+		return
+	}
+	
+	ui.Printf("\nStack changes (%d):\n", len(preview.Changes))
+	for _, change := range preview.Changes {
+		line := fmt.Sprintf("  %-8s %-32s %s", change.Action, change.LogicalID, change.Type)
+		switch change.Replacement {
+		case "True":
+			line += " (⚠️  replaced)"
+		case "Conditional":
+			line += " (may be replaced)"
+		}
+		ui.Println(line)
+	}
+}
+
 func init() {
 	// Add deploy-specific flags
 	deployCmd.Flags().StringP("mode", "m", "normal", "Performance mode (test, normal, performance)")
 	deployCmd.Flags().StringP("region", "r", "", "AWS region (overrides config)")
 	deployCmd.Flags().StringP("stack-name", "s", "", "CloudFormation stack name")
+	deployCmd.Flags().String("architecture", "", "Lambda architecture (x86_64, arm64; overrides config)")
+	deployCmd.Flags().Duration("warmup", 0, "Invoke the Lambda on a schedule this often to avoid cold starts, e.g. 5m (0 = off; overrides config)")
+	deployCmd.Flags().String("template-variant", "", "Infrastructure flavor (standard, minimal, hardened, vpc; overrides config)")
+	deployCmd.Flags().StringSlice("session-modes", nil, "Other performance modes 'run --mode' may use without a redeploy (overrides config)")
 	deployCmd.Flags().BoolP("dry-run", "", false, "Show what would be deployed without actually deploying")
+	deployCmd.Flags().String("generate-template", "", "Write a template that creates the whole deployment from the console, and the Lambda package beside it, instead of deploying")
+	deployCmd.Flags().String("template-url", "", "S3 HTTPS URL the generated template will be uploaded to, for a one-click console link")
+	deployCmd.Flags().String("code-bucket", "", "S3 bucket the generated Lambda package will be uploaded to, filled in on the console form")
 }
\ No newline at end of file
@@ -8,15 +8,17 @@ import (
 	"os"
 	"strings"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
-	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/spf13/cobra"
 	
 	awsclients "github.com/dan-v/lambda-nat-punch-proxy/internal/aws"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/deploy"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/ui"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
 )
 
 // destroyCmd represents the destroy command
@@ -41,10 +43,9 @@ func runDestroy(cmd *cobra.Command) error {
 	ctx := context.Background()
 	
 	// Load configuration
-	configPath, _ := cmd.Flags().GetString("config")
-	cfg, err := config.LoadCLIConfig(configPath)
+	cfg, err := loadConfig(cmd)
 	if err != nil {
-		return fmt.Errorf("failed to load configuration: %w", err)
+		return configError(err)
 	}
 	
 	// Apply command line flag overrides
@@ -55,13 +56,17 @@ func runDestroy(cmd *cobra.Command) error {
 		cfg.Deployment.StackName = stackName
 	}
 	
+	if err := applyStackSelection(ctx, cmd, cfg); err != nil {
+		return err
+	}
+	
 	// Validate configuration
 	if errors := config.ValidateCLIConfig(cfg); len(errors) > 0 {
-		fmt.Printf("Configuration validation errors:\n")
+		ui.Printf("Configuration validation errors:\n")
 		for _, err := range errors {
-			fmt.Printf("  - %s\n", err.Error())
+			ui.Printf("  - %s\n", err.Error())
 		}
-		return fmt.Errorf("configuration validation failed")
+		return configError(fmt.Errorf("configuration validation failed"))
 	}
 	
 	stackName := cfg.Deployment.StackName
@@ -69,12 +74,12 @@ func runDestroy(cmd *cobra.Command) error {
 	// Create AWS clients
 	clientFactory, err := awsclients.NewClientFactory(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to create AWS clients: %w", err)
+		return credentialsError(fmt.Errorf("failed to create AWS clients: %w", err))
 	}
 	
 	// Validate AWS credentials
 	if err := clientFactory.ValidateCredentials(ctx); err != nil {
-		return fmt.Errorf("invalid AWS credentials: %w", err)
+		return credentialsError(fmt.Errorf("invalid AWS credentials: %w", err))
 	}
 	
 	clients := clientFactory.GetClients()
@@ -88,28 +93,28 @@ func runDestroy(cmd *cobra.Command) error {
 	}
 	
 	// Show what will be destroyed
-	fmt.Printf("\n🔥 Lambda NAT Proxy Destruction Plan\n")
-	fmt.Printf("===================================\n\n")
-	fmt.Printf("The following resources will be PERMANENTLY DELETED:\n\n")
+	ui.Printf("\n🔥 Lambda NAT Proxy Destruction Plan\n")
+	ui.Printf("===================================\n\n")
+	ui.Printf("The following resources will be PERMANENTLY DELETED:\n\n")
 	
 	if stackOutput != nil {
-		fmt.Printf("📦 CloudFormation Stack: %s\n", stackOutput.StackName)
-		fmt.Printf("🪣 S3 Bucket: %s\n", stackOutput.CoordinationBucketName)
-		fmt.Printf("⚡ Lambda Function: %s-lambda\n", cfg.Deployment.StackName)
-		fmt.Printf("📋 CloudWatch Logs: /aws/lambda/%s-lambda\n", cfg.Deployment.StackName)
+		ui.Printf("📦 CloudFormation Stack: %s\n", stackOutput.StackName)
+		ui.Printf("🪣 S3 Bucket: %s\n", stackOutput.CoordinationBucketName)
+		ui.Printf("⚡ Lambda Function: %s-lambda\n", cfg.Deployment.StackName)
+		ui.Printf("📋 CloudWatch Logs: /aws/lambda/%s-lambda\n", cfg.Deployment.StackName)
 	} else {
-		fmt.Printf("📦 CloudFormation Stack: %s (if exists)\n", stackName)
-		fmt.Printf("⚡ Lambda Function: %s-lambda (if exists)\n", cfg.Deployment.StackName)
-		fmt.Printf("📋 CloudWatch Logs: /aws/lambda/%s-lambda (if exists)\n", cfg.Deployment.StackName)
+		ui.Printf("📦 CloudFormation Stack: %s (if exists)\n", stackName)
+		ui.Printf("⚡ Lambda Function: %s-lambda (if exists)\n", cfg.Deployment.StackName)
+		ui.Printf("📋 CloudWatch Logs: /aws/lambda/%s-lambda (if exists)\n", cfg.Deployment.StackName)
 	}
 	
-	fmt.Printf("\n⚠️  WARNING: This action cannot be undone!\n")
-	fmt.Printf("💀 All data and configurations will be permanently lost.\n\n")
+	ui.Printf("\n⚠️  WARNING: This action cannot be undone!\n")
+	ui.Printf("💀 All data and configurations will be permanently lost.\n\n")
 	
 	// Check for --force flag
 	force, _ := cmd.Flags().GetBool("force")
 	if !force {
-		fmt.Printf("Type 'yes' to continue with destruction: ")
+		ui.Printf("Type 'yes' to continue with destruction: ")
 		reader := bufio.NewReader(os.Stdin)
 		input, err := reader.ReadString('\n')
 		if err != nil {
@@ -117,12 +122,12 @@ func runDestroy(cmd *cobra.Command) error {
 		}
 		
 		if strings.TrimSpace(strings.ToLower(input)) != "yes" {
-			fmt.Println("Destruction cancelled.")
+			ui.Println("Destruction cancelled.")
 			return nil
 		}
 	}
 	
-	fmt.Printf("\n🚀 Starting destruction process...\n\n")
+	ui.Printf("\n🚀 Starting destruction process...\n\n")
 	
 	keepLogs, _ := cmd.Flags().GetBool("keep-logs")
 	
@@ -133,8 +138,12 @@ func runDestroy(cmd *cobra.Command) error {
 		}
 	}
 	
-	// Step 2: Delete Lambda function
+	// Step 2: Delete Lambda function, after the warm-up schedule that invokes it
 	lambdaDeployer := deploy.NewLambdaDeployer(clients, cfg)
+	functionArn := shared.FunctionARN(clients.Partition, cfg.AWS.Region, clients.AccountID, fmt.Sprintf("%s-lambda", cfg.Deployment.StackName))
+	if err := lambdaDeployer.RemoveWarmup(ctx, functionArn); err != nil {
+		log.Printf("Warning: %v", err)
+	}
 	log.Printf("Step 1/3: Deleting Lambda function...")
 	if err := lambdaDeployer.DeleteLambdaFunction(ctx); err != nil {
 		log.Printf("Warning: Lambda deletion failed: %v", err)
@@ -164,12 +173,12 @@ func runDestroy(cmd *cobra.Command) error {
 	}
 	
 	// Final status
-	fmt.Printf("\n🎉 Destruction completed!\n")
-	fmt.Printf("All AWS resources have been removed.\n")
+	ui.Printf("\n🎉 Destruction completed!\n")
+	ui.Printf("All AWS resources have been removed.\n")
 	if keepLogs {
-		fmt.Printf("\nNote: CloudWatch logs were preserved as requested.\n")
+		ui.Printf("\nNote: CloudWatch logs were preserved as requested.\n")
 	}
-	fmt.Printf("\nYou can run 'lambda-nat-proxy status' to verify all resources are gone.\n")
+	ui.Printf("\nYou can run 'lambda-nat-proxy status' to verify all resources are gone.\n")
 	
 	return nil
 }
@@ -180,10 +189,7 @@ func cleanupS3Resources(ctx context.Context, clients *awsclients.Clients, cfg *c
 	// Remove S3 triggers first
 	triggerDeployer := deploy.NewTriggerDeployer(clients, cfg)
 	functionName := fmt.Sprintf("%s-lambda", cfg.Deployment.StackName)
-	functionArn := fmt.Sprintf("arn:aws:lambda:%s:%s:function:%s", 
-		cfg.AWS.Region, 
-		clients.AccountID, 
-		functionName)
+	functionArn := shared.FunctionARN(clients.Partition, cfg.AWS.Region, clients.AccountID, functionName)
 	
 	if err := triggerDeployer.RemoveS3Triggers(ctx, bucketName, functionArn); err != nil {
 		log.Printf("Warning: Failed to remove S3 triggers: %v", err)
@@ -206,7 +212,7 @@ func emptyS3Bucket(ctx context.Context, s3Client awsclients.S3API, bucketName st
 	}
 	
 	for {
-		result, err := s3Client.ListObjectsV2WithContext(ctx, input)
+		result, err := s3Client.ListObjectsV2(ctx, input)
 		if err != nil {
 			return fmt.Errorf("failed to list objects: %w", err)
 		}
@@ -216,21 +222,21 @@ func emptyS3Bucket(ctx context.Context, s3Client awsclients.S3API, bucketName st
 		}
 		
 		// Delete objects in batch
-		var objects []*s3.ObjectIdentifier
+		var objects []s3types.ObjectIdentifier
 		for _, obj := range result.Contents {
-			objects = append(objects, &s3.ObjectIdentifier{
+			objects = append(objects, s3types.ObjectIdentifier{
 				Key: obj.Key,
 			})
 		}
 		
 		deleteInput := &s3.DeleteObjectsInput{
 			Bucket: aws.String(bucketName),
-			Delete: &s3.Delete{
+			Delete: &s3types.Delete{
 				Objects: objects,
 			},
 		}
 		
-		_, err = s3Client.DeleteObjectsWithContext(ctx, deleteInput)
+		_, err = s3Client.DeleteObjects(ctx, deleteInput)
 		if err != nil {
 			return fmt.Errorf("failed to delete objects: %w", err)
 		}
@@ -238,7 +244,7 @@ func emptyS3Bucket(ctx context.Context, s3Client awsclients.S3API, bucketName st
 		log.Printf("Deleted %d objects from bucket", len(objects))
 		
 		// Check if there are more objects
-		if !*result.IsTruncated {
+		if !aws.ToBool(result.IsTruncated) {
 			break
 		}
 		input.ContinuationToken = result.NextContinuationToken
@@ -254,13 +260,11 @@ func deleteCloudWatchLogs(ctx context.Context, clients *awsclients.Clients, func
 		LogGroupName: aws.String(logGroupName),
 	}
 	
-	_, err := clients.CloudWatchLogs.DeleteLogGroupWithContext(ctx, input)
+	_, err := clients.CloudWatchLogs.DeleteLogGroup(ctx, input)
 	if err != nil {
-		if awsErr, ok := err.(awserr.Error); ok {
-			if awsErr.Code() == cloudwatchlogs.ErrCodeResourceNotFoundException {
-				log.Printf("CloudWatch log group %s does not exist", logGroupName)
-				return nil
-			}
+		if shared.AWSErrorCode(err) == "ResourceNotFoundException" {
+			log.Printf("CloudWatch log group %s does not exist", logGroupName)
+			return nil
 		}
 		return fmt.Errorf("failed to delete CloudWatch log group: %w", err)
 	}
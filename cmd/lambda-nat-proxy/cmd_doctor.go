@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	awsclients "github.com/dan-v/lambda-nat-punch-proxy/internal/aws"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/stun"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/ui"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// doctorCheckTimeout bounds each network check
+const doctorCheckTimeout = 15 * time.Second
+
+// doctorCmd checks the two network paths the proxy needs
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check the HTTPS and UDP paths to AWS",
+	Long: `Check the two network paths lambda-nat-proxy needs, separately:
+
+- HTTPS to the AWS APIs, used by deploy, status, destroy and to start
+  sessions. It goes through http_proxy from the config file, or the
+  HTTPS_PROXY and NO_PROXY environment variables.
+- Outbound UDP, used by STUN and the tunnel itself. It never goes through
+  an HTTP proxy.
+
+Behind a corporate proxy that blocks direct HTTPS, deploy and manage
+commands can still work if the HTTPS check passes. Running the proxy also
+needs the UDP check to pass.
+
+--verify-crypto also reports, for every hop between a SOCKS5 client and
+the Lambda, what is encrypted and authenticated in the current
+configuration, and the setting that hardens each weak hop. It completes a
+tunnel handshake on the loopback interface with the Lambda's TLS settings
+and checks the TLS that S3 negotiates with this machine. The command fails
+if any hop is sent in the clear.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runDoctor(cmd)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+	doctorCmd.Flags().Bool("verify-crypto", false, "Also report the encryption and authentication of every hop")
+}
+
+func runDoctor(cmd *cobra.Command) error {
+	ctx := context.Background()
+
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return configError(err)
+	}
+	if errors := config.ValidateCLIConfig(cfg); len(errors) > 0 {
+		ui.Printf("Configuration validation errors:\n")
+		for _, err := range errors {
+			ui.Printf("  - %s\n", err.Error())
+		}
+		return configError(fmt.Errorf("configuration validation failed"))
+	}
+
+	ui.Printf("\n🩺 Lambda NAT Proxy Doctor\n")
+	ui.Printf("=========================\n\n")
+
+	// HTTPS path
+	ui.Printf("🔒 HTTPS to AWS\n")
+	ui.Printf("--------------\n")
+	proxySettings := cfg.HTTPProxy.Settings()
+	endpoint := fmt.Sprintf("https://sts.%s.%s", cfg.AWS.Region, shared.DNSSuffixForRegion(cfg.AWS.Region))
+	proxyURL, err := proxySettings.ProxyFor(endpoint)
+	switch {
+	case err != nil:
+		ui.Printf("Proxy:       ❌ %v\n", err)
+	case proxyURL != nil:
+		proxyURL.User = nil // never print credentials
+		ui.Printf("Proxy:       %s (from %s)\n", proxyURL, proxySettings.Source())
+	default:
+		ui.Printf("Proxy:       direct (proxy settings: %s)\n", proxySettings.Source())
+	}
+
+	httpsErr := checkAWSPath(ctx, cfg)
+	if httpsErr != nil {
+		ui.Printf("AWS API:     ❌ %v\n", httpsErr)
+	} else {
+		ui.Printf("AWS API:     ✅ reached %s\n", endpoint)
+	}
+	ui.Println()
+
+	// UDP path
+	ui.Printf("📡 UDP\n")
+	ui.Printf("------\n")
+	udpCtx, cancel := context.WithTimeout(ctx, doctorCheckTimeout)
+	defer cancel()
+	report, udpErr := stun.Diagnose(udpCtx, []string{cfg.Proxy.STUNServer, supportSecondSTUNServer})
+	if udpErr == nil {
+		answered := 0
+		for _, mapping := range report.Mappings {
+			if mapping.Error != "" {
+				ui.Printf("STUN:        ❌ %s: %s\n", mapping.Server, mapping.Error)
+				continue
+			}
+			answered++
+			ui.Printf("STUN:        ✅ %s sees %s\n", mapping.Server, mapping.Mapped)
+		}
+		if answered == 0 {
+			udpErr = fmt.Errorf("no STUN server answered; outbound UDP looks blocked")
+		} else {
+			ui.Printf("NAT mapping: %s\n", report.Mapping)
+		}
+		if report.Advice != "" {
+			ui.Printf("💡 %s\n", report.Advice)
+		}
+	} else {
+		ui.Printf("STUN:        ❌ %v\n", udpErr)
+	}
+	ui.Println()
+
+	// Encryption of every hop
+	verifyCrypto, _ := cmd.Flags().GetBool("verify-crypto")
+	plainHops := 0
+	if verifyCrypto {
+		plainHops = runCryptoReport(ctx, cfg)
+	}
+
+	// Summary
+	ui.Printf("💡 Summary\n")
+	ui.Printf("----------\n")
+	ui.Printf("Deploy and manage: %s\n", boolToIcon(httpsErr == nil))
+	ui.Printf("Run the proxy:     %s\n", boolToIcon(httpsErr == nil && udpErr == nil))
+	if verifyCrypto {
+		ui.Printf("Encryption:        %s\n", boolToIcon(plainHops == 0))
+	}
+
+	switch {
+	case httpsErr != nil:
+		return credentialsError(fmt.Errorf("AWS APIs are unreachable: %w", httpsErr))
+	case udpErr != nil:
+		return networkError(fmt.Errorf("UDP path check failed: %w", udpErr))
+	case plainHops > 0:
+		return configError(fmt.Errorf("%d hops are sent in the clear; see the advice above", plainHops))
+	}
+	return nil
+}
+
+// checkAWSPath calls STS through the configured HTTP proxy
+func checkAWSPath(ctx context.Context, cfg *config.CLIConfig) error {
+	ctx, cancel := context.WithTimeout(ctx, doctorCheckTimeout)
+	defer cancel()
+
+	factory, err := awsclients.NewReadOnlyClientFactory(cfg, "")
+	if err != nil {
+		return err
+	}
+	return factory.ValidateCredentials(ctx)
+}
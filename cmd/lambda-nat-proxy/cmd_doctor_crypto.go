@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/quic"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/ui"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// cryptoLevel grades how well a hop is protected
+type cryptoLevel int
+
+const (
+	cryptoSecure cryptoLevel = iota // encrypted and authenticated
+	cryptoWeak                      // encrypted, but with a gap worth knowing about
+	cryptoPlain                     // sent in the clear
+)
+
+// tunnelAuthGap explains why the tunnel hop is never better than weak
+const tunnelAuthGap = "the Lambda doesn't verify the proxy's self-signed certificate, so the tunnel is encrypted but not authenticated"
+
+// cryptoHop is one leg of the path between a SOCKS5 client and the Lambda
+type cryptoHop struct {
+	Name   string
+	Level  cryptoLevel
+	Detail string
+	Advice string // the setting that hardens the hop, if any
+
+	// verify, if set, checks the hop over the network and updates it
+	verify func(ctx context.Context, hop *cryptoHop)
+}
+
+// icon shows the hop's level in the report
+func (h cryptoHop) icon() string {
+	switch h.Level {
+	case cryptoSecure:
+		return ui.OK
+	case cryptoWeak:
+		return ui.Warn
+	}
+	return ui.Fail
+}
+
+// cryptoHops describes the encryption and authentication of every hop the
+// configuration uses, as far as the configuration tells
+func cryptoHops(cfg *config.CLIConfig) []cryptoHop {
+	hops := []cryptoHop{{
+		Name:   "SOCKS5 clients",
+		Level:  cryptoWeak,
+		Detail: fmt.Sprintf("plain SOCKS5 without authentication on port %d of every interface", cfg.Proxy.Port),
+		Advice: fmt.Sprintf("firewall port %d from other machines, and use TLS between your client and its destination", cfg.Proxy.Port),
+	}}
+
+	aws := cryptoHop{
+		Name:   "AWS APIs",
+		Level:  cryptoSecure,
+		Detail: "HTTPS, every request signed with SigV4",
+	}
+	if proxyURL, err := url.Parse(cfg.HTTPProxy.URL); err == nil && proxyURL.Scheme == "http" && proxyURL.User != nil {
+		aws.Level = cryptoWeak
+		aws.Detail += "; the http_proxy credentials are sent to the proxy in the clear"
+		aws.Advice = "use an https:// proxy URL"
+	}
+	hops = append(hops, aws)
+
+	credentials := cryptoHop{Name: "Lambda credentials", Level: cryptoSecure}
+	if cfg.Deployment.Coordination == shared.CoordinationFunctionURL {
+		hops = append(hops, cryptoHop{
+			Name:   "Coordination",
+			Level:  cryptoSecure,
+			Detail: "HTTPS to an IAM-authenticated function URL, signed with SigV4",
+		})
+		credentials.Detail = "none handed out; function URL sessions don't use S3"
+	} else {
+		hops = append(hops, cryptoHop{
+			Name:   "Coordination",
+			Level:  cryptoWeak,
+			Detail: "HTTPS to a private bucket, encrypted at rest with SSE-S3; objects are not signed, so anyone allowed to write the bucket can start a session",
+			Advice: "set deployment.coordination: function_url to take the bucket out of the path",
+			verify: func(ctx context.Context, hop *cryptoHop) { verifyS3TLS(ctx, cfg, hop) },
+		})
+		credentials.Detail = "each session gets STS credentials limited to its own response object"
+		if !cfg.Deployment.SessionCredentials {
+			credentials.Level = cryptoWeak
+			credentials.Detail = "each Lambda answers with its execution role, which can read and write every session's objects"
+			credentials.Advice = "set deployment.session_credentials: true"
+		}
+	}
+	hops = append(hops, credentials)
+
+	hops = append(hops, cryptoHop{
+		Name:   "Tunnel",
+		Level:  cryptoWeak,
+		Detail: "QUIC with TLS 1.3; " + tunnelAuthGap,
+		Advice: "no setting changes this; use TLS between your client and its destination",
+		verify: verifyTunnelCrypto,
+	})
+
+	dns := cryptoHop{Name: "Lambda DNS", Level: cryptoSecure}
+	switch upstream := cfg.Proxy.LambdaDNS.Upstream; {
+	case upstream == "":
+		dns.Detail = "the Lambda's AWS resolver, inside AWS's network"
+	case strings.HasPrefix(upstream, shared.DNSSchemeTLS+"://"):
+		dns.Detail = "DNS over TLS to " + strings.TrimPrefix(upstream, shared.DNSSchemeTLS+"://")
+	case strings.HasPrefix(upstream, shared.DNSSchemeHTTPS+"://"):
+		dns.Detail = "DNS over HTTPS to " + upstream
+	default:
+		dns.Level = cryptoPlain
+		dns.Detail = "plain DNS to " + upstream + " over the internet"
+		dns.Advice = "use a tls:// or https:// lambda_dns.upstream"
+	}
+	hops = append(hops, dns)
+
+	for _, tracing := range []struct{ name, endpoint string }{
+		{"Proxy traces", cfg.Tracing.Endpoint},
+		{"Lambda traces", cfg.Tracing.LambdaEndpoint},
+	} {
+		if tracing.endpoint == "" {
+			continue
+		}
+		hop := cryptoHop{Name: tracing.name, Level: cryptoSecure, Detail: "OTLP over HTTPS to " + tracing.endpoint}
+		if strings.HasPrefix(tracing.endpoint, "http://") {
+			hop.Level = cryptoPlain
+			hop.Detail = "OTLP over plain HTTP to " + tracing.endpoint
+			hop.Advice = "use an https:// collector endpoint"
+		}
+		hops = append(hops, hop)
+	}
+	return hops
+}
+
+// verifyTunnelCrypto replaces the tunnel hop's description with what a
+// loopback handshake with the Lambda's TLS configuration negotiated
+func verifyTunnelCrypto(ctx context.Context, hop *cryptoHop) {
+	crypto, err := quic.VerifyLoopback(ctx)
+	if err != nil {
+		hop.Level = cryptoPlain
+		hop.Detail = fmt.Sprintf("loopback handshake failed: %v", err)
+		return
+	}
+	hop.Detail = fmt.Sprintf("%s; %s", crypto, tunnelAuthGap)
+	if len(crypto.Warnings) > 0 {
+		hop.Level = cryptoPlain
+		hop.Detail = fmt.Sprintf("%s: %s", crypto, strings.Join(crypto.Warnings, "; "))
+	}
+}
+
+// verifyS3TLS checks the TLS that S3 negotiates with this machine, through
+// the configured HTTP proxy
+func verifyS3TLS(ctx context.Context, cfg *config.CLIConfig, hop *cryptoHop) {
+	ctx, cancel := context.WithTimeout(ctx, doctorCheckTimeout)
+	defer cancel()
+
+	endpoint := fmt.Sprintf("https://s3.%s.%s/", cfg.AWS.Region, shared.DNSSuffixForRegion(cfg.AWS.Region))
+	client := &http.Client{Transport: &http.Transport{Proxy: cfg.HTTPProxy.Settings().ProxyFunc()}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, endpoint, nil)
+	if err != nil {
+		return
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		hop.Detail += fmt.Sprintf(" (could not reach %s: %v)", endpoint, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.TLS == nil || len(resp.TLS.VerifiedChains) == 0 {
+		hop.Level = cryptoPlain
+		hop.Detail = "S3 answered without a verified TLS certificate"
+		return
+	}
+	hop.Detail += fmt.Sprintf(" (S3: %s, %s)", tls.VersionName(resp.TLS.Version), tls.CipherSuiteName(resp.TLS.CipherSuite))
+	if resp.TLS.Version < tls.VersionTLS12 {
+		hop.Level = cryptoPlain
+		hop.Advice = "S3 negotiated an old TLS version; check for a TLS-intercepting proxy"
+	}
+}
+
+// runCryptoReport checks and prints the encryption of every hop, and returns
+// how many are sent in the clear
+func runCryptoReport(ctx context.Context, cfg *config.CLIConfig) int {
+	hops := cryptoHops(cfg)
+	for i := range hops {
+		if hops[i].verify != nil {
+			hops[i].verify(ctx, &hops[i])
+		}
+	}
+
+	ui.Printf("🔐 Encryption\n")
+	ui.Printf("-------------\n")
+	plain := 0
+	for _, hop := range hops {
+		ui.Printf("%-19s %s %s\n", hop.Name+":", hop.icon(), hop.Detail)
+		if hop.Advice != "" {
+			ui.Printf("%-19s 💡 %s\n", "", hop.Advice)
+		}
+		if hop.Level == cryptoPlain {
+			plain++
+		}
+	}
+	ui.Println()
+	return plain
+}
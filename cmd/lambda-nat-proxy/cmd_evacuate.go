@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/control"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/ui"
+)
+
+// defaultEvacuation is how long a region stays evacuated unless --for says otherwise
+const defaultEvacuation = 30 * time.Minute
+
+// evacuateCmd moves the running proxy's traffic out of a region
+var evacuateCmd = &cobra.Command{
+	Use:   "evacuate <region>",
+	Short: "Move the running proxy's traffic out of a region for a while",
+	Long: `Drain every session a proxy running on this machine keeps in a region and
+launch none there until the evacuation ends, for example during a regional
+AWS incident.
+
+While its own region is evacuated the proxy tunnels through the sessions
+it keeps in another region for policy egress rules, so it needs at least
+one such region; evacuating the last region is refused. Connections a
+policy rule sends to an evacuated region are refused rather than tunnelled
+elsewhere. Open connections finish under the drain policy.
+
+The evacuation ends after --for, or at once with --end, and the proxy then
+launches sessions in the region again.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runEvacuate(cmd, args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(evacuateCmd)
+
+	addControlSocketFlag(evacuateCmd)
+	evacuateCmd.Flags().Duration("for", defaultEvacuation, "How long to keep the region evacuated")
+	evacuateCmd.Flags().Bool("end", false, "End the region's evacuation now")
+}
+
+func runEvacuate(cmd *cobra.Command, region string) error {
+	path := controlSocketPath(cmd)
+	client := control.NewClient(path)
+	end, _ := cmd.Flags().GetBool("end")
+	d, _ := cmd.Flags().GetDuration("for")
+	if !end && d <= 0 {
+		return configError(fmt.Errorf("--for must be positive, got %s", d))
+	}
+
+	var evacuation *control.Evacuation
+	var err error
+	if end {
+		evacuation, err = client.EndEvacuation(context.Background(), region)
+	} else {
+		evacuation, err = client.EvacuateRegion(context.Background(), region, d)
+	}
+	if errors.Is(err, control.ErrNotRunning) {
+		return notRunningError(path)
+	}
+	if err != nil {
+		return fmt.Errorf("evacuation failed: %w", err)
+	}
+
+	if end {
+		ui.Printf("%s Ended the evacuation of %s; sessions launch there again\n", ui.OK, evacuation.Region)
+		return nil
+	}
+	ui.Printf("%s Evacuated %s until %s, draining %d sessions\n", ui.OK, evacuation.Region,
+		evacuation.Until.Local().Format(time.Kitchen), len(evacuation.Drained))
+	ui.Println("Follow the move with: lambda-nat-proxy status --local")
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/spf13/cobra"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/policy"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/ui"
+)
+
+// policyCmd groups commands for the proxy's policy file
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Work with the routing and limits policy file",
+	Long: `Work with the policy file named by proxy.policy_file.
+
+A policy file puts routing, allow and deny rules, bandwidth limits and
+per-client quotas in one document. Rules are checked in order and the first
+one matching a destination decides whether it is tunnelled, dialed directly
+from this machine, or refused. With resolve enabled, names no rule matches
+are also checked by the address they resolve to.`,
+}
+
+// policyTestCmd shows what the policy does with a destination
+var policyTestCmd = &cobra.Command{
+	Use:   "test <host:port>",
+	Short: "Show how the policy handles a destination",
+	Long: `Evaluate the policy for a destination and show the action, the rule that
+decided it and the limits that apply, without running the proxy:
+
+  lambda-nat-proxy policy test git.corp.example.com:22
+  lambda-nat-proxy policy test 93.184.216.34:443 --file policy.yaml`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runPolicyTest(cmd, args[0])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(policyCmd)
+	policyCmd.AddCommand(policyTestCmd)
+
+	policyTestCmd.Flags().String("file", "", "Policy file to test (overrides proxy.policy_file)")
+}
+
+func runPolicyTest(cmd *cobra.Command, destination string) error {
+	path, _ := cmd.Flags().GetString("file")
+	if path == "" {
+		cfg, err := loadConfig(cmd)
+		if err != nil {
+			return configError(err)
+		}
+		path = cfg.Proxy.PolicyFile
+	}
+	if path == "" {
+		return configError(fmt.Errorf("no policy file: set proxy.policy_file or pass --file"))
+	}
+	if _, _, err := net.SplitHostPort(destination); err != nil {
+		return configError(fmt.Errorf("destination must be host:port, e.g. example.com:443"))
+	}
+
+	p, err := policy.Load(path)
+	if err != nil {
+		return configError(err)
+	}
+	decision, err := p.EvaluateContext(context.Background(), destination)
+	if err != nil {
+		return configError(err)
+	}
+
+	rule := decision.Rule
+	if rule == "" {
+		rule = "(default)"
+	}
+	ui.Printf("Destination: %s\n", destination)
+	ui.Printf("Action:      %s\n", decision.Action)
+	ui.Printf("Rule:        %s\n", rule)
+	if decision.Address != "" {
+		ui.Printf("Matched:     %s, the address it resolves to now\n", decision.Address)
+	}
+	if decision.Egress != "" {
+		ui.Printf("Egress:      %s\n", decision.Egress)
+	}
+	if decision.Action == policy.ActionDeny {
+		return nil
+	}
+
+	if decision.RateLimit > 0 {
+		ui.Printf("Rule limit:  %s/s, shared by every connection the rule matches\n", formatByteSize(decision.RateLimit))
+	}
+	limits := p.Bandwidth()
+	for _, limit := range []struct {
+		label string
+		value int64
+	}{
+		{"Global:      ", limits.Global},
+		{"Per client:  ", limits.PerClient},
+		{"Per dest:    ", limits.PerDestination},
+	} {
+		if limit.value > 0 {
+			ui.Printf("%s%s/s\n", limit.label, formatByteSize(limit.value))
+		}
+	}
+	if quota := p.QuotaSpec(); quota.PerClient != "" {
+		ui.Printf("Quota:       %s per client every %s\n", quota.PerClient, quota.Period)
+	}
+	return nil
+}
+
+// formatByteSize renders bytes in the largest binary unit that keeps the value at least 1
+func formatByteSize(bytes int64) string {
+	value := float64(bytes)
+	for _, unit := range []string{"B", "KB", "MB"} {
+		if value < 1024 {
+			return fmt.Sprintf("%.4g%s", value, unit)
+		}
+		value /= 1024
+	}
+	return fmt.Sprintf("%.4g%s", value, "GB")
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/relay"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// relayCmd runs the TCP relay sessions fall back to when UDP is blocked
+var relayCmd = &cobra.Command{
+	Use:   "relay",
+	Short: "Run a TCP relay for sessions on networks that block UDP",
+	Long: `Run a relay that sessions tunnel through over TCP when the proxy's
+network blocks UDP and hole punching can't work.
+
+Run it on a host both the proxy and the Lambda can reach, such as a small
+VM with a public address, and point proxy.relay in the proxy's config at
+it. Both sides present --token; the relay pairs them by session and copies
+bytes between them. The tunnel is still QUIC encrypted end to end, so the
+relay sees only ciphertext.
+
+By default the relay serves TLS with --tls-cert and --tls-key, or with a
+self-signed certificate whose fingerprint it prints for
+proxy.relay.fingerprint. The self-signed certificate is new each run, so
+give a certificate for a relay that restarts. --plain serves bare TCP
+(tcp:// URLs).`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runRelay(cmd)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(relayCmd)
+
+	relayCmd.Flags().String("listen", ":4433", "Address to accept connections on")
+	relayCmd.Flags().String("token", "", "Token both sides must present (required)")
+	relayCmd.Flags().String("tls-cert", "", "TLS certificate file (default: self-signed)")
+	relayCmd.Flags().String("tls-key", "", "TLS private key file")
+	relayCmd.Flags().Bool("plain", false, "Serve bare TCP instead of TLS")
+}
+
+func runRelay(cmd *cobra.Command) error {
+	listenAddr, _ := cmd.Flags().GetString("listen")
+	token, _ := cmd.Flags().GetString("token")
+	certFile, _ := cmd.Flags().GetString("tls-cert")
+	keyFile, _ := cmd.Flags().GetString("tls-key")
+	plain, _ := cmd.Flags().GetBool("plain")
+	if token == "" {
+		return configError(fmt.Errorf("--token is required"))
+	}
+	if (certFile == "") != (keyFile == "") {
+		return configError(fmt.Errorf("--tls-cert and --tls-key must be given together"))
+	}
+	if plain && certFile != "" {
+		return configError(fmt.Errorf("--plain can't be used with --tls-cert"))
+	}
+
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", listenAddr, err)
+	}
+	scheme := "tls"
+	switch {
+	case plain:
+		scheme = "tcp"
+		log.Printf("⚠️  Relay: serving bare TCP; session IDs and the token cross the network unencrypted")
+	case certFile != "":
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			listener.Close()
+			return configError(fmt.Errorf("failed to load TLS certificate: %w", err))
+		}
+		listener = tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+	default:
+		tlsConfig, err := shared.GenerateTLSConfig(shared.TLSConfigOptions{Organization: "Lambda NAT Proxy Relay"})
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		tlsConfig.NextProtos = nil
+		listener = tls.NewListener(listener, tlsConfig)
+		log.Printf("Relay: self-signed certificate fingerprint %s", shared.CertificateFingerprint(tlsConfig.Certificates[0].Certificate[0]))
+	}
+	log.Printf("Relay: listening on %s (%s://)", listener.Addr(), scheme)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return relay.New(token).Serve(ctx, listener)
+}
@@ -2,32 +2,44 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"net/http"
+	"net"
 	"os"
 	"os/exec"
 	"os/signal"
 	"runtime"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awslambda "github.com/aws/aws-sdk-go-v2/service/lambda"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/spf13/cobra"
 	
 	awsclients "github.com/dan-v/lambda-nat-punch-proxy/internal/aws"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/anomaly"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/audit"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/control"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/dashboard"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/deploy"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/events"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/features"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/leakcheck"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/manager"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/metrics"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/nat"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/notify"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/quic"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/s3"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/socks5"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/stun"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
 )
 
 // runCmd represents the run command
@@ -43,26 +55,33 @@ This command starts the proxy server which will:
 - Launch the dashboard web interface (auto-opens in browser)
 - Handle automatic session rotation and failover
 
-The proxy will run until stopped with Ctrl+C.`,
+The proxy will run until stopped with Ctrl+C. With --daemon it runs in the
+background instead, logging to --log-file, until 'lambda-nat-proxy stop'.
+
+--mode can pick another mode than the one deployed, e.g. performance for a
+big download. Its sessions run with that mode's Lambda memory if deploy
+published it with deployment.session_modes.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return runProxy(cmd)
 	},
 }
 
 func runProxy(cmd *cobra.Command) error {
+	if daemon, _ := cmd.Flags().GetBool("daemon"); daemon {
+		return startDaemon(cmd)
+	}
+	startedAt := time.Now()
+	
 	// Load configuration
-	configPath, _ := cmd.Flags().GetString("config")
-	cfg, err := config.LoadCLIConfig(configPath)
+	cfg, err := loadConfig(cmd)
 	if err != nil {
-		return fmt.Errorf("failed to load configuration: %w", err)
+		return configError(err)
 	}
 	
 	// Apply command line flag overrides
-	if port, _ := cmd.Flags().GetInt("port"); cmd.Flags().Changed("port") {
-		cfg.Proxy.Port = port
-	}
-	if mode, _ := cmd.Flags().GetString("mode"); cmd.Flags().Changed("mode") {
-		cfg.Deployment.Mode = config.PerformanceMode(mode)
+	applyRunFlags(cmd, cfg)
+	if err := applyStackSelection(context.Background(), cmd, cfg); err != nil {
+		return err
 	}
 	
 	// Validate configuration
@@ -71,17 +90,43 @@ func runProxy(cmd *cobra.Command) error {
 		for _, err := range errors {
 			fmt.Fprintf(os.Stderr, "  - %s\n", err.Error())
 		}
-		return fmt.Errorf("configuration validation failed")
+		return configError(fmt.Errorf("configuration validation failed"))
 	}
+	showUpgradeNotes(false)
 	
 	// Auto-detect S3 bucket from CloudFormation stack
-	bucketName, err := autoDetectS3Bucket(cfg)
+	stack, err := autoDetectStack(cfg)
 	if err != nil {
-		return fmt.Errorf("unable to find S3 bucket. Please deploy infrastructure first:\n\n  lambda-nat-proxy deploy\n\nError details: %v", err)
+		return infraError(fmt.Errorf("unable to find S3 bucket. Please deploy infrastructure first:\n\n  lambda-nat-proxy deploy\n\nError details: %v", err))
 	}
+	bucketName := stack.CoordinationBucketName
 	
-	// Convert to legacy config format
-	legacyConfig := cfg.ToLegacyConfig(bucketName)
+	// Resolve the CLI config into the runtime config
+	runtimeCfg := cfg.ToConfig(bucketName)
+	if runtimeCfg.SessionCredentials {
+		if stack.SessionCredentialsRoleArn == "" {
+			return infraError(fmt.Errorf("deployment.session_credentials is set but stack %s has no session role; run 'lambda-nat-proxy deploy' again", cfg.Deployment.StackName))
+		}
+		runtimeCfg.SessionCredentialsRoleArn = stack.SessionCredentialsRoleArn
+		runtimeCfg.EncryptionKeyArn = stack.EncryptionKeyArn
+	}
+	
+	// A policy file replaces the ACL and bandwidth limits
+	proxyPolicy, err := loadPolicy(runtimeCfg)
+	if err != nil {
+		return configError(err)
+	}
+	if proxyPolicy != nil {
+		log.Printf("Using policy file: %s", runtimeCfg.PolicyFile)
+	}
+	shared.SetLogLevel(runtimeCfg.LogLevel)
+	shared.SetPrivacyMode(runtimeCfg.PrivacyMode)
+	if err := features.Apply(runtimeCfg.Features, "the config file"); err != nil {
+		return configError(err)
+	}
+	if runtimeCfg.PrivacyMode {
+		log.Printf("Privacy mode: public IP addresses are shown as %s", shared.RedactedIP)
+	}
 	
 	// Set up debug logging if requested
 	if debug, _ := cmd.Flags().GetBool("debug"); debug {
@@ -91,34 +136,212 @@ func runProxy(cmd *cobra.Command) error {
 			cfg.AWS.Region, bucketName, cfg.Proxy.Port, cfg.Deployment.Mode)
 	}
 	
-	log.Printf("Using S3 bucket: %s", legacyConfig.S3BucketName)
-	log.Printf("Using AWS region: %s", legacyConfig.AWSRegion)
+	log.Printf("Using S3 bucket: %s", runtimeCfg.S3BucketName)
+	log.Printf("Using AWS region: %s", runtimeCfg.AWSRegion)
 	
-	// Create AWS session
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(legacyConfig.AWSRegion),
-	})
+	// Load AWS configuration
+	awsCfg, err := shared.LoadAWSConfig(context.Background(), runtimeCfg.AWSRegion)
 	if err != nil {
-		return fmt.Errorf("failed to create AWS session: %w", err)
+		return fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	
+	// Sessions of a mode the function isn't deployed with run on the alias
+	// deploy published for it, with that mode's memory
+	alias, err := s3.LookupSessionAlias(context.Background(), awslambda.NewFromConfig(awsCfg), runtimeCfg.LambdaFunctionName,
+		string(runtimeCfg.Mode), runtimeCfg.ModeConfig.LambdaMemory)
+	if err == nil && alias != "" && runtimeCfg.Coordination != shared.CoordinationS3 {
+		err = fmt.Errorf("sessions of %s mode reach its alias only with deployment.coordination: %s", runtimeCfg.Mode, shared.CoordinationS3)
+	}
+	switch {
+	case err != nil && cmd.Flags().Changed("mode"):
+		return infraError(err)
+	case err != nil:
+		log.Printf("⚠️  %v; sessions get the deployed function's memory", err)
+	case alias != "":
+		runtimeCfg.SessionAlias = alias
+		log.Printf("Sessions run on the %s alias with %d MB", alias, runtimeCfg.ModeConfig.LambdaMemory)
 	}
 	
 	// Initialize components
-	stunClient := stun.New()
-	s3Coord := s3.New(awss3.New(sess), legacyConfig.S3BucketName)
-	natTraversal := nat.New()
-	socks5Proxy := socks5.New()
+	if !runtimeCfg.PunchPorts.IsZero() {
+		log.Printf("Using local UDP port range %s for STUN and hole punching", runtimeCfg.PunchPorts)
+		if runtimeCfg.PunchPorts.Size() < 2 {
+			log.Printf("⚠️  A single punch port cannot be shared during session rotation; new sessions may fail to launch until the old one closes")
+		}
+	}
+	stunClient := stun.NewWithPortRange(runtimeCfg.PunchPorts)
+	s3Client := awss3.NewFromConfig(awsCfg)
+	s3Coord, err := newCoordinator(awsCfg, s3Client, runtimeCfg)
+	if err != nil {
+		return err
+	}
+	cleanupStaleCoordination(s3Client, runtimeCfg)
+	natTraversal := nat.NewWithOptions(nat.Options{
+		Ports:        runtimeCfg.PunchPorts,
+		PredictPorts: runtimeCfg.PunchPredictPorts,
+	})
+	
+	// Record launched sessions, finding any a crashed run left running
+	sessionState, orphans, err := manager.OpenSessionState(control.DefaultStatePath(cfg.Deployment.StackName, runtimeCfg.SOCKS5Port))
+	if err != nil {
+		log.Printf("⚠️  %v; sessions a crashed run left running can't be stopped", err)
+	}
+	
+	// Keep sessions in every other region policy rules send traffic through
+	egressRegions, err := newEgressRegions(cfg, proxyPolicy, stunClient, natTraversal, sessionState)
+	if err != nil {
+		return err
+	}
+	coordinators := map[string]s3.Coordinator{runtimeCfg.AWSRegion: s3Coord}
+	for region, egress := range egressRegions {
+		coordinators[region] = egress.coord
+	}
+	stopOrphanedSessions(orphans, coordinators)
+	proxyOpts := socks5.DefaultOptions()
+	proxyOpts.Egress = make(map[string]*manager.ConnManager, len(egressRegions)+1)
+	for region, egress := range egressRegions {
+		proxyOpts.Egress[region] = egress.cm
+	}
+	proxyOpts.Listen = runtimeCfg.SOCKS5Listen
+	proxyOpts.SessionWaitTimeout = runtimeCfg.SessionWaitTimeout
+	proxyOpts.MaxConnections = runtimeCfg.MaxConnections
+	proxyOpts.IdleTimeout = runtimeCfg.TunnelIdleTimeout
+	proxyOpts.ConnectTimeout = runtimeCfg.TunnelConnectTimeout
+	proxyOpts.MaxStreamLifetime = runtimeCfg.TunnelMaxLifetime
+	proxyOpts.Compression = runtimeCfg.TunnelCompression
+	proxyOpts.PipelineConnect = runtimeCfg.PipelineConnect
+	proxyOpts.Bandwidth = runtimeCfg.Bandwidth
+	if !runtimeCfg.Bandwidth.IsZero() {
+		log.Printf("Bandwidth limits (bytes/s, 0 = unlimited): global %d, per client %d, per destination %d",
+			runtimeCfg.Bandwidth.Global, runtimeCfg.Bandwidth.PerClient, runtimeCfg.Bandwidth.PerDestination)
+	}
+	proxyOpts.Resources = runtimeCfg.Resources
+	proxyOpts.Refusal = runtimeCfg.Refusal
+	proxyOpts.ProxyProtocol = runtimeCfg.ProxyProtocol
+	proxyOpts.PinDNSAnswers = runtimeCfg.DNSPinTTL
+	if runtimeCfg.ProxyProtocol.Enabled {
+		log.Printf("Accepting PROXY protocol headers on the SOCKS5 port")
+	}
+	if !runtimeCfg.Resources.IsZero() {
+		log.Printf("Resource limits (0 = unlimited): %d goroutines, %d open files, %d bytes of memory",
+			runtimeCfg.Resources.MaxGoroutines, runtimeCfg.Resources.MaxOpenFiles, runtimeCfg.Resources.MaxMemory)
+	}
+	if !runtimeCfg.Budget.IsZero() {
+		log.Printf("Budget per %s (0 = no cap): %.2f GB transferred, $%.2f estimated spend; no new sessions launch past either",
+			runtimeCfg.Budget.Period, float64(runtimeCfg.Budget.MaxTransfer)/(1<<30), runtimeCfg.Budget.MaxSpend)
+	}
+	acl, err := shared.NewACL(runtimeCfg.ACL)
+	if err != nil {
+		return fmt.Errorf("failed to parse ACL: %w", err)
+	}
+	proxyOpts.ACL = acl
+	proxyOpts.Policy = proxyPolicy
+	proxyOpts.Resolvers = runtimeCfg.Resolvers
+	for _, resolver := range runtimeCfg.Resolvers {
+		log.Printf("Resolving locally: %s", resolver)
+	}
+	if acl != nil {
+		log.Printf("Destination ACL: default %q, %d allow rules, %d deny rules",
+			runtimeCfg.ACL.Default, len(runtimeCfg.ACL.Allow), len(runtimeCfg.ACL.Deny))
+	}
+	var auditLog *audit.Logger
+	if runtimeCfg.AuditLogPath != "" {
+		auditLog, err = audit.New(runtimeCfg.AuditLogPath, runtimeCfg.AuditLog)
+		if err != nil {
+			return configError(err)
+		}
+		defer auditLog.Close()
+		proxyOpts.Audit = auditLog
+		log.Printf("Audit log: %s", runtimeCfg.AuditLogPath)
+	}
+	tracer, err := shared.NewTracer(runtimeCfg.Tracing)
+	if err != nil {
+		return configError(err)
+	}
+	if tracer != nil {
+		shared.SetTracer(tracer)
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			defer cancel()
+			tracer.Shutdown(shutdownCtx)
+		}()
+		log.Printf("Exporting traces to %s", runtimeCfg.Tracing.Endpoint)
+	}
+	if runtimeCfg.LambdaTracing.Endpoint != "" {
+		log.Printf("Lambda exporting traces to %s", runtimeCfg.LambdaTracing.Endpoint)
+	}
+	tracker := dashboard.NewConnectionTracker()
+	proxyOpts.Tracker = tracker
+	
+	// Session, connection and error events of every region, for the audit
+	// log and the dashboard's /api/events stream
+	eventBus := events.NewBus()
+	logEvents.Attach(eventBus)
+	defer logEvents.Attach(nil)
+	for _, egress := range egressRegions {
+		egress.cm.SetEventBus(eventBus)
+	}
+	proxyOpts.Events = eventBus
+	socks5Proxy := socks5.NewWithOptions(proxyOpts)
 	quicServer := quic.New()
 	
 	// Create launcher for session management
-	launcher := internal.NewLauncher(legacyConfig, stunClient, s3Coord, natTraversal, quicServer)
+	launcher := internal.NewLauncher(runtimeCfg, stunClient, s3Coord, natTraversal, quicServer)
+	launcher.SetSessionState(sessionState)
+	if runtimeCfg.SessionAlias != "" {
+		launcher.SetInvoker(s3.NewAliasInvoker(awslambda.NewFromConfig(awsCfg), runtimeCfg.LambdaFunctionName, runtimeCfg.SessionAlias, runtimeCfg.S3BucketName))
+	} else if runtimeCfg.InvokeFallback > 0 && runtimeCfg.Coordination == shared.CoordinationS3 {
+		lambdaClient := awslambda.NewFromConfig(awsCfg)
+		qualifier := s3.LiveQualifier(context.Background(), lambdaClient, runtimeCfg.LambdaFunctionName)
+		launcher.SetInvoker(s3.NewAliasInvoker(lambdaClient, runtimeCfg.LambdaFunctionName, qualifier, runtimeCfg.S3BucketName))
+	}
+	
+	// Watch session health history for degradations
+	var anomalies *anomaly.Detector
+	if runtimeCfg.AnomalyDetection {
+		anomalies = anomaly.New(runtimeCfg.Anomaly)
+		launcher.SetAnomalyDetector(anomalies)
+		log.Printf("Anomaly detection enabled")
+	}
 	
 	// Create connection manager
-	cm := manager.New(legacyConfig, launcher)
+	cm := manager.New(runtimeCfg, launcher)
+	// The proxy shares the map, so rules naming this region use these sessions
+	proxyOpts.Egress[runtimeCfg.AWSRegion] = cm
+	launcher.SetLaunchHistory(cm.LaunchHistory())
+	cm.SetEventBus(eventBus)
+	var notifiers notify.Multi
+	if runtimeCfg.Notifications {
+		notifiers = append(notifiers, notify.NewDesktop())
+		log.Printf("Desktop notifications enabled")
+	}
+	for _, webhook := range runtimeCfg.Webhooks {
+		notifiers = append(notifiers, notify.NewWebhook(webhook))
+		log.Printf("Posting notifications to a %s webhook", notify.WebhookFormat(webhook))
+	}
+	if len(notifiers) > 0 {
+		cm.SetNotifier(notifiers)
+	}
 	
 	// Create context with interrupt handling
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 	
+	auditLog.Follow(ctx, eventBus)
+	go anomalies.Run(ctx)
+	startEgressRegions(ctx, egressRegions)
+	go leakcheck.New(func() leakcheck.Sample {
+		sample := leakcheck.Sample{
+			Connections: int(metrics.GetActiveSOCKS5Connections()),
+			Tracked:     tracker.GetConnectionCount(),
+		}
+		for _, session := range cm.GetAllSessions() {
+			sample.Sessions++
+			sample.Streams += int(session.ActiveStreams())
+		}
+		return sample
+	}).Run(ctx)
+	
 	// Start connection manager in background
 	errCh := make(chan error, 1)
 	go func() {
@@ -133,16 +356,16 @@ func runProxy(cmd *cobra.Command) error {
 	if _, err := cm.WaitForSession(waitCtx); err != nil {
 		cancel()
 		if err == context.DeadlineExceeded {
-			return fmt.Errorf("timeout establishing initial session after 30 seconds.\n\n"+
+			return networkError(fmt.Errorf("timeout establishing initial session after 30 seconds.\n\n"+
 				"🔧 Troubleshooting steps:\n"+
 				"1. Check AWS Lambda function status: lambda-nat-proxy status\n"+
 				"2. Verify S3 bucket permissions and triggers\n"+
 				"3. Check CloudWatch logs: lambda-nat-proxy status --logs\n"+
 				"4. Ensure firewall allows outbound UDP traffic\n"+
-				"5. Try a different performance mode: --mode test")
+				"5. Try a different performance mode: --mode test"))
 		}
-		return fmt.Errorf("failed to establish initial session: %w\n\n"+
-			"💡 Run 'lambda-nat-proxy status' to check infrastructure health", err)
+		return networkError(fmt.Errorf("failed to establish initial session: %w\n\n"+
+			"💡 Run 'lambda-nat-proxy status' to check infrastructure health", err))
 	}
 	log.Printf("Initial session established successfully")
 	
@@ -151,15 +374,22 @@ func runProxy(cmd *cobra.Command) error {
 	enableMetrics, _ := cmd.Flags().GetBool("metrics")
 	enableDashboard, _ := cmd.Flags().GetBool("dashboard")
 	noBrowser, _ := cmd.Flags().GetBool("no-browser")
+	httpHost, _ := cmd.Flags().GetString("http-host")
+	
+	// The metrics and dashboard servers stop with ctx; shutdown waits for
+	// them so their ports are free when run returns
+	var httpServers sync.WaitGroup
 	
 	if debug || enableMetrics {
+		httpServers.Add(1)
 		go func() {
-			log.Println("🔍 Starting comprehensive metrics server on :6060")
+			defer httpServers.Done()
+			log.Printf("🔍 Starting comprehensive metrics server on %s", net.JoinHostPort(httpHost, "6060"))
 			log.Println("📊 Metrics available at:")
 			log.Println("   - http://localhost:6060/metrics (Prometheus format)")
 			log.Println("   - http://localhost:6060/debug/vars (JSON format)")
 			
-			if err := metrics.StartMetricsServer(":6060"); err != nil && err != http.ErrServerClosed {
+			if err := metrics.StartMetricsServer(ctx, net.JoinHostPort(httpHost, "6060")); err != nil {
 				log.Printf("❌ Metrics server error: %v", err)
 			}
 		}()
@@ -168,21 +398,28 @@ func runProxy(cmd *cobra.Command) error {
 	// Start dashboard server if requested
 	var dashboardServer *dashboard.DashboardServer
 	if enableDashboard {
-		// Start connection tracking metrics collection
-		dashboard.StartMetricsCollection()
+		// Record connection history until shutdown
+		go tracker.Run(ctx)
 		
-		dashboardServer = dashboard.NewDashboardServer(cm)
+		// Optionally show the status of a (possibly remote) deployment, read-only
+		var source dashboard.DeploymentSource
+		if monitorCfg, roleARN, ok := monitorConfig(cmd, cfg); ok {
+			source, err = deploymentSource(monitorCfg, roleARN)
+			if err != nil {
+				return credentialsError(fmt.Errorf("failed to set up deployment monitoring: %w", err))
+			}
+			log.Printf("Dashboard monitoring stack %s in %s (read-only)", monitorCfg.Deployment.StackName, monitorCfg.AWS.Region)
+		}
+		dashboardServer = dashboard.NewDashboardServerWithDeploymentSource(cm, source)
+		dashboardServer.SetConnectionTracker(tracker)
+		dashboardServer.SetAnomalyDetector(anomalies)
+		dashboardServer.SetEventBus(eventBus)
+		httpServers.Add(1)
 		go func() {
-			log.Println("🎨 Starting dashboard server on :8081")
+			defer httpServers.Done()
+			log.Printf("🎨 Starting dashboard server on %s", net.JoinHostPort(httpHost, "8081"))
 			log.Println("🌐 Dashboard available at: http://localhost:8081")
 			
-			httpServer := &http.Server{
-				Addr:         ":8081",
-				Handler:      dashboardServer,
-				ReadTimeout:  15 * time.Second,
-				WriteTimeout: 15 * time.Second,
-			}
-			
 			// Auto-open dashboard in browser after a short delay (unless disabled)
 			if !noBrowser {
 				go func() {
@@ -191,7 +428,7 @@ func runProxy(cmd *cobra.Command) error {
 				}()
 			}
 			
-			if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			if err := dashboardServer.ListenAndServe(ctx, net.JoinHostPort(httpHost, "8081")); err != nil {
 				log.Printf("❌ Dashboard server error: %v", err)
 			}
 		}()
@@ -199,8 +436,8 @@ func runProxy(cmd *cobra.Command) error {
 	
 	// Start SOCKS5 proxy in background with context
 	go func() {
-		log.Printf("Starting SOCKS5 proxy on port %d", legacyConfig.SOCKS5Port)
-		if err := socks5Proxy.StartWithConnManagerAndContext(ctx, legacyConfig.SOCKS5Port, cm); err != nil {
+		log.Printf("Starting SOCKS5 proxy on port %d", runtimeCfg.SOCKS5Port)
+		if err := socks5Proxy.StartWithConnManagerAndContext(ctx, runtimeCfg.SOCKS5Port, cm); err != nil {
 			if ctx.Err() == nil { // Only log error if not due to context cancellation
 				log.Printf("SOCKS5 proxy error: %v", err)
 			}
@@ -208,7 +445,83 @@ func runProxy(cmd *cobra.Command) error {
 		}
 	}()
 	
-	log.Printf("Proxy is ready! Use SOCKS5 proxy at localhost:%d", legacyConfig.SOCKS5Port)
+	// Start the DNS stub so lookups outside the proxy also go through the Lambda
+	if runtimeCfg.DNSListen != "" {
+		go func() {
+			if err := socks5.NewDNSStub(cm).ListenAndServe(ctx, runtimeCfg.DNSListen); err != nil {
+				log.Printf("❌ DNS stub error: %v", err)
+			}
+		}()
+	}
+	
+	// Apply config file edits without a restart
+	reloader := newConfigReloader(cmd, cfg, runtimeCfg, socks5Proxy, cm, s3Coord, egressRegions)
+	go reloader.Watch(ctx)
+	
+	// Let stop, reload and status --local reach this proxy
+	controlServer, err := control.Listen(controlSocketPath(cmd), control.Handlers{
+		Status: func() control.Status {
+			return localStatus(cm, egressRegions, reloader, startedAt)
+		},
+		Reload: reloader.Reload,
+		ResetLaunch: func() control.LaunchReset {
+			return control.LaunchReset(cm.ResetLaunchState("the control socket"))
+		},
+		RotateSession: func(region string) (control.SessionAction, error) {
+			regionCM := cm
+			if region != "" && region != runtimeCfg.AWSRegion {
+				regionEgress, ok := egressRegions[region]
+				if !ok {
+					return control.SessionAction{}, fmt.Errorf("no sessions are kept in %s", region)
+				}
+				regionCM = regionEgress.cm
+			} else {
+				region = ""
+			}
+			id, err := regionCM.RotateNow("the control socket")
+			return control.SessionAction{SessionID: id, Region: region}, err
+		},
+		DrainSession: func(id string) (control.SessionAction, error) {
+			return sessionAction(cm, egressRegions, func(cm *manager.ConnManager) (string, error) {
+				return cm.DrainSession(id, "the control socket")
+			})
+		},
+		KillSession: func(id string) (control.SessionAction, error) {
+			return sessionAction(cm, egressRegions, func(cm *manager.ConnManager) (string, error) {
+				return cm.KillSession(id, "the control socket")
+			})
+		},
+		EvacuateRegion: func(region string, d time.Duration) (control.Evacuation, error) {
+			return evacuateRegion(cm, runtimeCfg.AWSRegion, egressRegions, region, d)
+		},
+		EndEvacuation: func(region string) (control.Evacuation, error) {
+			regionCM, region, err := regionManager(cm, runtimeCfg.AWSRegion, egressRegions, region)
+			if err != nil {
+				return control.Evacuation{}, err
+			}
+			if !regionCM.EndEvacuation("the control socket") {
+				return control.Evacuation{}, fmt.Errorf("%s is not evacuated", region)
+			}
+			return control.Evacuation{Region: region}, nil
+		},
+		Stop: func() {
+			log.Printf("Stop requested")
+			cancel()
+		},
+	})
+	if err != nil {
+		cancel()
+		return configError(err)
+	}
+	defer controlServer.Close()
+	if pidPath, _ := cmd.Flags().GetString("pid-file"); pidPath != "" {
+		if err := control.WritePIDFile(pidPath); err != nil {
+			log.Printf("⚠️  Failed to write PID file: %v", err)
+		}
+		defer os.Remove(pidPath)
+	}
+	
+	log.Printf("Proxy is ready! Use SOCKS5 proxy at localhost:%d", runtimeCfg.SOCKS5Port)
 	
 	// Wait for connection manager to finish or interrupt
 	err = <-errCh
@@ -221,11 +534,17 @@ func runProxy(cmd *cobra.Command) error {
 		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 3*time.Second)
 		defer shutdownCancel()
 		
-		// Stop dashboard and metrics collection immediately
-		if enableDashboard && dashboardServer != nil {
-			log.Printf("Shutting down dashboard server...")
-			dashboardServer.Shutdown()
-			dashboard.StopMetricsCollection()
+		// The dashboard and metrics servers are shutting down with ctx, as
+		// did connection history; wait until their ports are released
+		serversDone := make(chan struct{})
+		go func() {
+			httpServers.Wait()
+			close(serversDone)
+		}()
+		select {
+		case <-serversDone:
+		case <-shutdownCtx.Done():
+			log.Printf("Timed out waiting for the dashboard and metrics servers to stop")
 		}
 		
 		// Give minimal time for connections to close
@@ -235,18 +554,232 @@ func runProxy(cmd *cobra.Command) error {
 		case <-time.After(500 * time.Millisecond):
 			log.Printf("Proxy stopped gracefully")
 		}
+		if err := sessionState.Close(); err != nil {
+			log.Printf("⚠️  %v", err)
+		}
 		return nil
 	}
 	
 	return err
 }
 
-// autoDetectS3Bucket attempts to detect the S3 bucket from CloudFormation stack
-func autoDetectS3Bucket(cfg *config.CLIConfig) (string, error) {
+// applyRunFlags applies the run command's flag overrides to cfg
+func applyRunFlags(cmd *cobra.Command, cfg *config.CLIConfig) {
+	if port, _ := cmd.Flags().GetInt("port"); cmd.Flags().Changed("port") {
+		cfg.Proxy.Port = port
+	}
+	if mode, _ := cmd.Flags().GetString("mode"); cmd.Flags().Changed("mode") {
+		cfg.Deployment.Mode = config.PerformanceMode(mode)
+	}
+	if datagrams, _ := cmd.Flags().GetBool("datagrams"); cmd.Flags().Changed("datagrams") {
+		cfg.Proxy.EnableDatagrams = datagrams
+	}
+	if pipeline, _ := cmd.Flags().GetBool("pipeline-connect"); cmd.Flags().Changed("pipeline-connect") {
+		cfg.Proxy.PipelineConnect = pipeline
+	}
+	if rateLimit, _ := cmd.Flags().GetString("rate-limit"); cmd.Flags().Changed("rate-limit") {
+		cfg.Proxy.RateLimit.Global = rateLimit
+	}
+	if dnsListen, _ := cmd.Flags().GetString("dns-listen"); cmd.Flags().Changed("dns-listen") {
+		cfg.Proxy.DNSListen = dnsListen
+	}
+	if auditLog, _ := cmd.Flags().GetString("audit-log"); cmd.Flags().Changed("audit-log") {
+		cfg.Proxy.AuditLog.Path = auditLog
+	}
+	if otlpEndpoint, _ := cmd.Flags().GetString("otlp-endpoint"); cmd.Flags().Changed("otlp-endpoint") {
+		cfg.Tracing.Endpoint = otlpEndpoint
+	}
+	if privacy, _ := cmd.Flags().GetBool("privacy"); cmd.Flags().Changed("privacy") {
+		cfg.Proxy.PrivacyMode = privacy
+	}
+	if notifications, _ := cmd.Flags().GetBool("notify"); cmd.Flags().Changed("notify") {
+		cfg.Proxy.Notifications = notifications
+	}
+	// --debug shows the Lambda's side too, unless the config picks a level
+	if debug, _ := cmd.Flags().GetBool("debug"); debug && cfg.Proxy.LambdaLogs.Level == "" {
+		cfg.Proxy.LambdaLogs.Level = "debug"
+	}
+}
+
+// localStatus describes this proxy for status --local
+func localStatus(cm *manager.ConnManager, egress map[string]egressRegion, reloader *configReloader, startedAt time.Time) control.Status {
+	cfg, runtimeCfg := reloader.current()
+	status := control.Status{
+		PID:         os.Getpid(),
+		Version:     version,
+		StartedAt:   startedAt,
+		StackName:   cfg.Deployment.StackName,
+		Region:      runtimeCfg.AWSRegion,
+		Mode:        string(runtimeCfg.Mode),
+		SOCKS5Port:  runtimeCfg.SOCKS5Port,
+		PolicyFile:  runtimeCfg.PolicyFile,
+		Connections: metrics.GetActiveSOCKS5Connections(),
+		Sessions:    []control.SessionStatus{},
+	}
+	addSessions := func(cm *manager.ConnManager, region string) {
+		for _, session := range cm.GetAllSessions() {
+			status.Sessions = append(status.Sessions, control.SessionStatus{
+				ID:            session.ID,
+				Role:          session.Role,
+				Region:        region,
+				Healthy:       session.IsHealthy(),
+				Uptime:        time.Since(session.StartedAt),
+				TTL:           session.RemainingTTL(),
+				ActiveStreams: session.ActiveStreams(),
+				Crypto:        session.Crypto,
+			})
+		}
+	}
+	addSessions(cm, "")
+	for region, regionEgress := range egress {
+		addSessions(regionEgress.cm, region)
+	}
+	addEvacuation := func(cm *manager.ConnManager, region string) {
+		if until := cm.EvacuatedUntil(); !until.IsZero() {
+			if status.Evacuated == nil {
+				status.Evacuated = make(map[string]time.Time)
+			}
+			status.Evacuated[region] = until
+		}
+	}
+	addEvacuation(cm, runtimeCfg.AWSRegion)
+	for region, regionEgress := range egress {
+		addEvacuation(regionEgress.cm, region)
+	}
+	return status
+}
+
+// regionManager returns the connection manager keeping sessions in region,
+// which is the proxy's own if region is empty, and the region's name
+func regionManager(cm *manager.ConnManager, home string, egress map[string]egressRegion, region string) (*manager.ConnManager, string, error) {
+	if region == "" || region == home {
+		return cm, home, nil
+	}
+	regionEgress, ok := egress[region]
+	if !ok {
+		return nil, region, fmt.Errorf("no sessions are kept in %s", region)
+	}
+	return regionEgress.cm, region, nil
+}
+
+// evacuateRegion drains region's sessions and stops launching there for d,
+// as long as another region that isn't evacuated keeps sessions for the
+// proxy's traffic to move to
+func evacuateRegion(cm *manager.ConnManager, home string, egress map[string]egressRegion, region string, d time.Duration) (control.Evacuation, error) {
+	regionCM, region, err := regionManager(cm, home, egress, region)
+	if err != nil {
+		return control.Evacuation{}, err
+	}
+	remaining := 0
+	if cm != regionCM && !cm.Evacuated() {
+		remaining++
+	}
+	for _, regionEgress := range egress {
+		if regionEgress.cm != regionCM && !regionEgress.cm.Evacuated() {
+			remaining++
+		}
+	}
+	if remaining == 0 {
+		return control.Evacuation{}, fmt.Errorf("evacuating %s would leave no region to tunnel through; keep sessions in another region with a policy rule that sets egress, or stop the proxy", region)
+	}
+
+	drained := regionCM.Evacuate(d, "the control socket")
+	return control.Evacuation{Region: region, Until: regionCM.EvacuatedUntil(), Drained: drained}, nil
+}
+
+// sessionAction applies act to the proxy's own sessions, then to each egress
+// region's in turn until one holds the session act names
+func sessionAction(cm *manager.ConnManager, egress map[string]egressRegion, act func(*manager.ConnManager) (string, error)) (control.SessionAction, error) {
+	id, err := act(cm)
+	if !errors.Is(err, manager.ErrSessionNotFound) {
+		return control.SessionAction{SessionID: id}, err
+	}
+	for region, regionEgress := range egress {
+		if id, regionErr := act(regionEgress.cm); !errors.Is(regionErr, manager.ErrSessionNotFound) {
+			return control.SessionAction{SessionID: id, Region: region}, regionErr
+		}
+	}
+	return control.SessionAction{}, err
+}
+
+// newCoordinator returns the coordinator for sessions of runtimeCfg's stack
+func newCoordinator(awsCfg aws.Config, s3Client *awss3.Client, runtimeCfg *config.Config) (s3.Coordinator, error) {
+	s3Coord := s3.NewWithSettings(s3Client, runtimeCfg.S3BucketName, runtimeCfg.SessionSettings())
+	// Function URL sessions don't touch S3, so they need no credentials
+	if runtimeCfg.SessionCredentialsRoleArn != "" && runtimeCfg.Coordination == shared.CoordinationS3 {
+		issuer := s3.NewCredentialIssuer(sts.NewFromConfig(awsCfg), runtimeCfg.SessionCredentialsRoleArn, runtimeCfg.S3BucketName)
+		if runtimeCfg.EncryptionKeyArn != "" {
+			issuer.(*s3.STSCredentialIssuer).SetEncryptionKey(runtimeCfg.EncryptionKeyArn)
+		}
+		s3Coord.(*s3.DefaultCoordinator).SetCredentialIssuer(issuer)
+		log.Printf("Lambdas answer with credentials scoped to their session (%s)", runtimeCfg.SessionCredentialsRoleArn)
+	}
+	if runtimeCfg.Coordination == shared.CoordinationFunctionURL {
+		functionURL, err := s3.LookupFunctionURL(context.Background(), awslambda.NewFromConfig(awsCfg), runtimeCfg.LambdaFunctionName)
+		if err != nil {
+			return nil, err
+		}
+		s3Coord = s3.NewFunctionURL(functionURL, runtimeCfg.AWSRegion, awsCfg.Credentials, runtimeCfg.SessionSettings())
+		log.Printf("Coordinating sessions through the function URL %s", functionURL)
+	}
+	return s3Coord, nil
+}
+
+// stopOrphanedSessions stops the Lambdas of sessions a crashed run of this
+// proxy left running, so they don't bill until their timeout next to the
+// sessions about to be launched. Failures are logged and never block startup.
+func stopOrphanedSessions(orphans []manager.SessionRecord, coordinators map[string]s3.Coordinator) {
+	if len(orphans) == 0 {
+		return
+	}
+	log.Printf("Found %d sessions a previous run left behind, stopping their Lambdas", len(orphans))
+	
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	
+	for _, orphan := range orphans {
+		stopper, ok := coordinators[orphan.Region].(s3.SessionStopper)
+		if !ok {
+			log.Printf("⚠️  Can't stop session %s in %s; its Lambda runs until its tunnel times out, at the latest until %s",
+				orphan.ID, orphan.Region, orphan.ExpiresAt.Local().Format(time.Kitchen))
+			continue
+		}
+		if err := stopper.StopSession(ctx, orphan.ID, orphan.IntegrityKey); err != nil {
+			log.Printf("⚠️  %v", err)
+			continue
+		}
+		log.Printf("Stopped session %s in %s", orphan.ID, orphan.Region)
+	}
+}
+
+// cleanupStaleCoordination removes coordination and response objects left by
+// previous runs. Objects older than the Lambda timeout can't belong to a live
+// session. Failures are logged and never block startup.
+func cleanupStaleCoordination(s3Client *awss3.Client, cfg *config.Config) {
+	maxAge := time.Duration(cfg.ModeConfig.LambdaTimeout) * time.Second
+	if maxAge <= 0 {
+		return
+	}
+	
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	
+	result, err := s3.CleanupStale(ctx, s3Client, cfg.S3BucketName, maxAge)
+	if err != nil {
+		log.Printf("⚠️  Failed to clean up stale S3 objects: %v", err)
+		return
+	}
+	if result.Found > 0 {
+		log.Printf("🧹 Removed %d of %d stale coordination objects older than %v", result.Deleted, result.Found, maxAge)
+	}
+}
+
+// autoDetectStack finds the CloudFormation stack and its S3 bucket
+func autoDetectStack(cfg *config.CLIConfig) (*deploy.StackOutput, error) {
 	// Create AWS clients
 	clientFactory, err := awsclients.NewClientFactory(cfg)
 	if err != nil {
-		return "", fmt.Errorf("failed to create AWS clients: %w", err)
+		return nil, fmt.Errorf("failed to create AWS clients: %w", err)
 	}
 	
 	// Try to get stack outputs
@@ -256,7 +789,7 @@ func autoDetectS3Bucket(cfg *config.CLIConfig) (string, error) {
 	stackOutput, err := stackDeployer.GetStackOutputs(context.Background())
 	if err != nil {
 		// Provide more helpful error message with multiple options
-		return "", fmt.Errorf("CloudFormation stack '%s' not found in region %s.\n\n"+
+		return nil, fmt.Errorf("CloudFormation stack '%s' not found in region %s.\n\n"+
 			"📦 To deploy infrastructure:\n"+
 			"   lambda-nat-proxy deploy\n\n"+
 			"🔍 To check existing deployments:\n"+
@@ -267,10 +800,10 @@ func autoDetectS3Bucket(cfg *config.CLIConfig) (string, error) {
 	}
 	
 	if stackOutput.CoordinationBucketName == "" {
-		return "", fmt.Errorf("S3 bucket not found in CloudFormation stack outputs")
+		return nil, fmt.Errorf("S3 bucket not found in CloudFormation stack outputs")
 	}
 	
-	return stackOutput.CoordinationBucketName, nil
+	return stackOutput, nil
 }
 
 func init() {
@@ -280,7 +813,42 @@ func init() {
 	runCmd.Flags().Bool("metrics", false, "Enable metrics server on port 6060")
 	runCmd.Flags().Bool("dashboard", true, "Enable dashboard web UI on port 8081")
 	runCmd.Flags().Bool("no-browser", false, "Disable auto-opening dashboard in browser")
+	runCmd.Flags().String("http-host", "127.0.0.1", "Address the dashboard and metrics servers listen on (0.0.0.0 = every interface)")
+	runCmd.Flags().Bool("privacy", false, "Show public IP addresses as <ip> in logs and the dashboard")
+	runCmd.Flags().Bool("notify", false, "Show desktop notifications when the egress IP changes, a session is lost or the budget runs low")
 	runCmd.Flags().StringP("mode", "m", "normal", "Performance mode (test, normal, performance)")
+	runCmd.Flags().Bool("datagrams", false, "Relay small SOCKS5 UDP packets over QUIC datagrams")
+	runCmd.Flags().Bool("pipeline-connect", false, "Answer SOCKS5 CONNECT before the Lambda has connected, saving a round trip")
+	runCmd.Flags().String("rate-limit", "", "Cap total proxy bandwidth, e.g. 5MB (per second)")
+	runCmd.Flags().String("dns-listen", "", "Serve DNS on this address (e.g. 127.0.0.1:5300), resolving through the Lambda")
+	runCmd.Flags().String("audit-log", "", "Append one JSON line per proxied connection to this file")
+	runCmd.Flags().String("otlp-endpoint", "", "Export OpenTelemetry spans to this OTLP/HTTP collector (e.g. http://localhost:4318)")
+	runCmd.Flags().String("monitor-role-arn", "", "Show a deployment on the dashboard using this read-only IAM role")
+	runCmd.Flags().String("monitor-region", "", "Region of the deployment shown on the dashboard (default: config region)")
+	runCmd.Flags().String("monitor-stack-name", "", "Stack name of the deployment shown on the dashboard (default: config stack)")
+	runCmd.Flags().Bool("daemon", false, "Run in the background; stop with 'lambda-nat-proxy stop'")
+	runCmd.Flags().String("log-file", "", "Log file in daemon mode (default "+control.DefaultLogPath()+")")
+	runCmd.Flags().String("pid-file", "", "Write the process ID to this file (daemon default "+control.DefaultPIDPath()+")")
+	addControlSocketFlag(runCmd)
+}
+
+// monitorConfig returns the configuration of the deployment the dashboard should
+// monitor, if any --monitor-* flag was given
+func monitorConfig(cmd *cobra.Command, cfg *config.CLIConfig) (*config.CLIConfig, string, bool) {
+	flags := cmd.Flags()
+	if !flags.Changed("monitor-role-arn") && !flags.Changed("monitor-region") && !flags.Changed("monitor-stack-name") {
+		return nil, "", false
+	}
+	
+	monitorCfg := *cfg
+	if region, _ := flags.GetString("monitor-region"); region != "" {
+		monitorCfg.AWS.Region = region
+	}
+	if stackName, _ := flags.GetString("monitor-stack-name"); stackName != "" {
+		monitorCfg.Deployment.StackName = stackName
+	}
+	roleARN, _ := flags.GetString("monitor-role-arn")
+	return &monitorCfg, roleARN, true
 }
 
 // openBrowser opens the specified URL in the user's default browser
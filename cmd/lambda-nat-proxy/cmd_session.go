@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/control"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/ui"
+)
+
+// sessionCmd groups commands that manage the running proxy's sessions
+var sessionCmd = &cobra.Command{
+	Use:   "session",
+	Short: "Manage the running proxy's Lambda sessions",
+	Long: `List, rotate, drain or kill the Lambda sessions of a proxy running on
+this machine.
+
+Sessions are named by their ID, as shown by 'session list', or by any
+unique start or end of it. The same actions are available on the dashboard port
+as POST /api/sessions/rotate, /api/sessions/drain?id=<id> and
+/api/sessions/kill?id=<id>.`,
+}
+
+// sessionListCmd lists the running proxy's sessions
+var sessionListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the running proxy's sessions",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runSessionList(cmd)
+	},
+}
+
+// sessionRotateCmd rotates the primary session early
+var sessionRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Rotate the primary session now",
+	Long: `Replace the primary session now instead of at the end of its TTL, for
+example to get a new egress IP.
+
+The replacement is launched, or a warm secondary takes over, as in a
+scheduled rotation. The old primary then drains under the drain policy, so
+open connections are not cut off.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		region, _ := cmd.Flags().GetString("region")
+		return runSessionAction(cmd, "Rotating", func(client *control.Client) (*control.SessionAction, error) {
+			return client.RotateSession(context.Background(), region)
+		})
+	},
+}
+
+// sessionDrainCmd drains one session
+var sessionDrainCmd = &cobra.Command{
+	Use:   "drain <session-id>",
+	Short: "Stop using a session and shut it down once its connections finish",
+	Long: `Stop opening new connections on a session and shut it down once its open
+connections finish or the drain times out, under the drain policy.
+
+Draining the primary rotates it early instead, so a session is always up.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runSessionAction(cmd, "Draining", func(client *control.Client) (*control.SessionAction, error) {
+			return client.DrainSession(context.Background(), args[0])
+		})
+	},
+}
+
+// sessionKillCmd closes one session at once
+var sessionKillCmd = &cobra.Command{
+	Use:   "kill <session-id>",
+	Short: "Close a session at once",
+	Long: `Close a session at once, cutting off its open connections.
+
+This is for a session that is stuck. A killed primary is replaced as if it
+had been lost; prefer 'session drain' for a session that still works.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runSessionAction(cmd, "Killed", func(client *control.Client) (*control.SessionAction, error) {
+			return client.KillSession(context.Background(), args[0])
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sessionCmd)
+	sessionCmd.AddCommand(sessionListCmd)
+	sessionCmd.AddCommand(sessionRotateCmd)
+	sessionCmd.AddCommand(sessionDrainCmd)
+	sessionCmd.AddCommand(sessionKillCmd)
+
+	for _, cmd := range []*cobra.Command{sessionListCmd, sessionRotateCmd, sessionDrainCmd, sessionKillCmd} {
+		addControlSocketFlag(cmd)
+	}
+	sessionRotateCmd.Flags().String("region", "", "Rotate the sessions kept in this region for policy egress rules")
+}
+
+func runSessionList(cmd *cobra.Command) error {
+	path := controlSocketPath(cmd)
+	status, err := control.NewClient(path).Status(context.Background())
+	if errors.Is(err, control.ErrNotRunning) {
+		return notRunningError(path)
+	}
+	if err != nil {
+		return err
+	}
+	ui.Println()
+	printSessionTable(status.Sessions)
+	return nil
+}
+
+// runSessionAction sends a session control request and reports the session it acted on
+func runSessionAction(cmd *cobra.Command, verb string, act func(*control.Client) (*control.SessionAction, error)) error {
+	path := controlSocketPath(cmd)
+	action, err := act(control.NewClient(path))
+	if errors.Is(err, control.ErrNotRunning) {
+		return notRunningError(path)
+	}
+	if err != nil {
+		return fmt.Errorf("session %s failed: %w", cmd.Name(), err)
+	}
+
+	ui.Printf("%s %s session %s", ui.OK, verb, action.SessionID)
+	if action.Region != "" {
+		ui.Printf(" in %s", action.Region)
+	}
+	ui.Println()
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/e2e"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/ui"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// speedtestCmd measures a running proxy's tunnel
+var speedtestCmd = &cobra.Command{
+	Use:   "speedtest",
+	Short: "Measure the tunnel's latency and throughput",
+	Long: `Measure the round-trip latency, download and upload speed of the tunnel of
+a proxy running on this machine.
+
+The Lambda serves the test targets itself (` + shared.EchoTarget + `,
+` + shared.SourceTarget + ` and ` + shared.DiscardTarget + `), so the
+results cover exactly the path your traffic takes through the tunnel, without
+a third-party speed test server or the Lambda's internet connection. The
+Lambda must have been deployed by a version that serves them.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runSpeedtest(cmd)
+	},
+}
+
+// SpeedtestResult is the output of the speedtest command
+type SpeedtestResult struct {
+	Proxy        string  `json:"proxy"`
+	LatencyMs    float64 `json:"latency_ms"`
+	DownloadMbps float64 `json:"download_mbps"`
+	UploadMbps   float64 `json:"upload_mbps"`
+	DownloadMB   float64 `json:"download_mb"`
+	UploadMB     float64 `json:"upload_mb"`
+}
+
+func init() {
+	rootCmd.AddCommand(speedtestCmd)
+
+	speedtestCmd.Flags().String("proxy", "", "SOCKS5 address of the proxy (default: 127.0.0.1 and the configured port)")
+	speedtestCmd.Flags().Duration("duration", 10*time.Second, "Length of the download and of the upload")
+	speedtestCmd.Flags().Int("samples", 10, "Number of round trips timed for the latency")
+	speedtestCmd.Flags().String("format", "table", "Output format (table, json)")
+}
+
+func runSpeedtest(cmd *cobra.Command) error {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return configError(err)
+	}
+	proxyAddr, _ := cmd.Flags().GetString("proxy")
+	if proxyAddr == "" {
+		proxyAddr = net.JoinHostPort("127.0.0.1", strconv.Itoa(cfg.Proxy.Port))
+	}
+	duration, _ := cmd.Flags().GetDuration("duration")
+	samples, _ := cmd.Flags().GetInt("samples")
+	format, _ := cmd.Flags().GetString("format")
+	format = strings.ToLower(format)
+	if duration <= 0 || samples < 1 {
+		return configError(fmt.Errorf("--duration and --samples must be positive"))
+	}
+	if format != "table" && format != "json" {
+		return configError(fmt.Errorf("unsupported format: %s (use table or json)", format))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	table := format == "table"
+	if table {
+		ui.Printf("\n🚀 Lambda NAT Proxy Speed Test\n")
+		ui.Printf("=============================\n\n")
+		ui.Printf("Proxy:    %s\n", proxyAddr)
+	}
+
+	latency, err := e2e.TunnelLatency(ctx, proxyAddr, samples)
+	if err != nil {
+		return err
+	}
+	if table {
+		ui.Printf("Latency:  %v (min %v, max %v)\n", latency.Median.Round(100*time.Microsecond),
+			latency.Min.Round(100*time.Microsecond), latency.Max.Round(100*time.Microsecond))
+	}
+	download, err := e2e.TunnelDownload(ctx, proxyAddr, duration)
+	if err != nil {
+		return err
+	}
+	if table {
+		ui.Printf("Download: %.1f Mbit/s (%.1f MB)\n", download.BitsPerSecond/1e6, float64(download.Bytes)/1e6)
+	}
+	upload, err := e2e.TunnelUpload(ctx, proxyAddr, duration)
+	if err != nil {
+		return err
+	}
+	if table {
+		ui.Printf("Upload:   %.1f Mbit/s (%.1f MB)\n\n", upload.BitsPerSecond/1e6, float64(upload.Bytes)/1e6)
+		return nil
+	}
+
+	data, err := json.MarshalIndent(SpeedtestResult{
+		Proxy:        proxyAddr,
+		LatencyMs:    float64(latency.Median.Microseconds()) / 1000,
+		DownloadMbps: download.BitsPerSecond / 1e6,
+		UploadMbps:   upload.BitsPerSecond / 1e6,
+		DownloadMB:   float64(download.Bytes) / 1e6,
+		UploadMB:     float64(upload.Bytes) / 1e6,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	fmt.Println(string(data))
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+
+	awsclients "github.com/dan-v/lambda-nat-punch-proxy/internal/aws"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/deploy"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/ui"
+)
+
+// stacksCmd groups commands for the deployments in an account
+var stacksCmd = &cobra.Command{
+	Use:   "stacks",
+	Short: "Manage deployed stacks",
+	Long: `Find the lambda-nat-proxy stacks deployed in your account.
+
+Every deploy creates a CloudFormation stack tagged Project=lambda-nat-proxy.
+Since default stack names end in a random suffix, running config init more
+than once can leave several stacks behind.`,
+}
+
+// stacksListCmd lists the deployed stacks
+var stacksListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List deployed stacks across regions",
+	Long: `List the lambda-nat-proxy stacks in the configured regions, with their
+mode, status and age.
+
+Regions searched are aws.region and aws.regions from the config file, or
+--regions. Pass a stack's name, or just its suffix, to any other command
+with --stack to select it; its region is filled in automatically:
+
+  lambda-nat-proxy stacks list
+  lambda-nat-proxy status --stack a1b2c3d4
+  lambda-nat-proxy destroy --stack lambda-nat-proxy-a1b2c3d4`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runStacksList(cmd)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(stacksCmd)
+	stacksCmd.AddCommand(stacksListCmd)
+
+	rootCmd.PersistentFlags().String("stack", "", "Select a deployed stack by name or suffix, searching the configured regions (see 'stacks list')")
+
+	stacksListCmd.Flags().StringSlice("regions", nil, "Regions to search (overrides config)")
+	stacksListCmd.Flags().String("format", "table", "Output format (table, json, yaml)")
+}
+
+func runStacksList(cmd *cobra.Command) error {
+	ctx := context.Background()
+
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return configError(err)
+	}
+
+	regions := searchRegions(cfg)
+	if flagRegions, _ := cmd.Flags().GetStringSlice("regions"); cmd.Flags().Changed("regions") {
+		regions = flagRegions
+	}
+	if len(regions) == 0 {
+		return configError(fmt.Errorf("no regions to search: set aws.region or pass --regions"))
+	}
+
+	stacks, err := discoverStacks(ctx, cfg, regions)
+	if err != nil {
+		return err
+	}
+
+	format, _ := cmd.Flags().GetString("format")
+	switch strings.ToLower(format) {
+	case "json":
+		if stacks == nil {
+			stacks = []deploy.DeployedStack{}
+		}
+		data, err := json.MarshalIndent(stacks, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON: %w", err)
+		}
+		fmt.Println(string(data))
+	case "yaml":
+		data, err := yaml.Marshal(stacks)
+		if err != nil {
+			return fmt.Errorf("failed to marshal YAML: %w", err)
+		}
+		fmt.Print(string(data))
+	case "table":
+		if len(stacks) == 0 {
+			ui.Printf("No lambda-nat-proxy stacks found in %s\n", strings.Join(regions, ", "))
+			return nil
+		}
+		now := time.Now()
+		w := tabwriter.NewWriter(ui.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tREGION\tMODE\tSTATUS\tAGE\t")
+		for _, stack := range stacks {
+			current := ""
+			if stack.Name == cfg.Deployment.StackName && stack.Region == cfg.AWS.Region {
+				current = "(config)"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", stack.Name, stack.Region, stack.Mode, stack.Status, formatAge(stack.Age(now)), current)
+		}
+		w.Flush()
+	default:
+		return configError(fmt.Errorf("unsupported format: %s (use table, json, or yaml)", format))
+	}
+	return nil
+}
+
+// searchRegions returns the configured region followed by any extra regions, without duplicates
+func searchRegions(cfg *config.CLIConfig) []string {
+	var regions []string
+	seen := make(map[string]bool)
+	for _, region := range append([]string{cfg.AWS.Region}, cfg.AWS.Regions...) {
+		if region == "" || seen[region] {
+			continue
+		}
+		seen[region] = true
+		regions = append(regions, region)
+	}
+	return regions
+}
+
+// discoverStacks lists the project's stacks in every region with read-only
+// clients. A region that can't be searched is reported and skipped, unless
+// none can be.
+func discoverStacks(ctx context.Context, cfg *config.CLIConfig, regions []string) ([]deploy.DeployedStack, error) {
+	var stacks []deploy.DeployedStack
+	var failures []string
+	for _, region := range regions {
+		regionCfg := *cfg
+		regionCfg.AWS.Region = region
+		factory, err := awsclients.NewReadOnlyClientFactory(&regionCfg, "")
+		if err != nil {
+			return nil, credentialsError(fmt.Errorf("failed to create AWS clients: %w", err))
+		}
+		if err := factory.ValidateCredentials(ctx); err != nil {
+			return nil, credentialsError(fmt.Errorf("invalid AWS credentials: %w", err))
+		}
+
+		found, err := deploy.ListProjectStacks(ctx, factory.GetClients().CloudFormation, region)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️  %v\n", err)
+			failures = append(failures, region)
+			continue
+		}
+		stacks = append(stacks, found...)
+	}
+	if len(failures) == len(regions) {
+		return nil, fmt.Errorf("failed to list stacks in %s", strings.Join(failures, ", "))
+	}
+	return stacks, nil
+}
+
+// applyStackSelection points cfg at the stack named by --stack, if given,
+// taking its region and, unless --mode was passed, its mode
+func applyStackSelection(ctx context.Context, cmd *cobra.Command, cfg *config.CLIConfig) error {
+	name, _ := cmd.Flags().GetString("stack")
+	if name == "" {
+		return nil
+	}
+	regions := searchRegions(cfg)
+	if len(regions) == 0 {
+		return configError(fmt.Errorf("no regions to search for stack %q: set aws.region", name))
+	}
+
+	stacks, err := discoverStacks(ctx, cfg, regions)
+	if err != nil {
+		return err
+	}
+	stack, err := deploy.FindStack(stacks, name)
+	if err != nil {
+		return infraError(fmt.Errorf("%w (searched %s; see 'lambda-nat-proxy stacks list')", err, strings.Join(regions, ", ")))
+	}
+
+	cfg.AWS.Region = stack.Region
+	cfg.Deployment.StackName = stack.Name
+	if stack.Mode != "" && !cmd.Flags().Changed("mode") {
+		cfg.Deployment.Mode = config.PerformanceMode(stack.Mode)
+	}
+	return nil
+}
+
+// formatAge renders a stack age in its largest whole unit
+func formatAge(age time.Duration) string {
+	switch {
+	case age <= 0:
+		return "-"
+	case age >= 48*time.Hour:
+		return fmt.Sprintf("%dd", int(age.Hours()/24))
+	case age >= time.Hour:
+		return fmt.Sprintf("%dh", int(age.Hours()))
+	default:
+		return fmt.Sprintf("%dm", int(age.Minutes()))
+	}
+}
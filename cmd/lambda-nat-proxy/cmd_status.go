@@ -4,18 +4,29 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"text/tabwriter"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
-	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	cwltypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v2"
 	
 	awsclients "github.com/dan-v/lambda-nat-punch-proxy/internal/aws"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/dashboard"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/deploy"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/manager"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/ui"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
 )
 
 // statusCmd represents the status command
@@ -30,6 +41,20 @@ This command displays:
 - S3 bucket contents and recent activity
 - Recent CloudWatch logs
 
+Use --sessions to also show the sessions of a proxy running on this machine,
+with how long each phase of its recent session launches took.
+
+Use --watch to redraw a compact view every --interval until Ctrl-C, like
+'kubectl get --watch'. It adds Lambda invocation counts from CloudWatch and,
+if the proxy is running here, each session's role and time left before
+rotation.
+
+Use --local to show only the proxy running on this machine, read from its
+control socket without calling AWS: its PID, uptime, connections and sessions.
+
+Status only reads from AWS. Use --role-arn to inspect a deployment in another
+account through a read-only role, e.g. to monitor a teammate's deployment.
+
 Use this command to verify deployment status and troubleshoot issues.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return runStatus(cmd)
@@ -38,11 +63,13 @@ Use this command to verify deployment status and troubleshoot issues.`,
 
 // StatusInfo holds deployment status information
 type StatusInfo struct {
-	Stack   *StackStatus   `json:"stack,omitempty" yaml:"stack,omitempty"`
-	Lambda  *LambdaStatus  `json:"lambda,omitempty" yaml:"lambda,omitempty"`
-	S3      *S3Status      `json:"s3,omitempty" yaml:"s3,omitempty"`
-	Logs    []LogEntry     `json:"logs,omitempty" yaml:"logs,omitempty"`
-	Summary *StatusSummary `json:"summary" yaml:"summary"`
+	Stack       *StackStatus                `json:"stack,omitempty" yaml:"stack,omitempty"`
+	Lambda      *LambdaStatus               `json:"lambda,omitempty" yaml:"lambda,omitempty"`
+	S3          *S3Status                   `json:"s3,omitempty" yaml:"s3,omitempty"`
+	Logs        []LogEntry                  `json:"logs,omitempty" yaml:"logs,omitempty"`
+	Invocations *InvocationStatus           `json:"invocations,omitempty" yaml:"invocations,omitempty"`
+	Proxy       *dashboard.SessionsResponse `json:"proxy,omitempty" yaml:"proxy,omitempty"`
+	Summary     *StatusSummary              `json:"summary" yaml:"summary"`
 }
 
 type StackStatus struct {
@@ -72,6 +99,14 @@ type S3Status struct {
 	NotificationsOK bool   `json:"notifications_configured" yaml:"notifications_configured"`
 }
 
+// InvocationStatus counts Lambda invocations from CloudWatch metrics
+type InvocationStatus struct {
+	Last5Minutes   int64 `json:"last_5_minutes" yaml:"last_5_minutes"`
+	LastHour       int64 `json:"last_hour" yaml:"last_hour"`
+	Errors5Minutes int64 `json:"errors_last_5_minutes" yaml:"errors_last_5_minutes"`
+	ErrorsLastHour int64 `json:"errors_last_hour" yaml:"errors_last_hour"`
+}
+
 type LogEntry struct {
 	Timestamp string `json:"timestamp" yaml:"timestamp"`
 	Message   string `json:"message" yaml:"message"`
@@ -90,11 +125,15 @@ type StatusSummary struct {
 func runStatus(cmd *cobra.Command) error {
 	ctx := context.Background()
 	
+	if local, _ := cmd.Flags().GetBool("local"); local {
+		format, _ := cmd.Flags().GetString("format")
+		return runLocalStatus(cmd, format)
+	}
+	
 	// Load configuration
-	configPath, _ := cmd.Flags().GetString("config")
-	cfg, err := config.LoadCLIConfig(configPath)
+	cfg, err := loadConfig(cmd)
 	if err != nil {
-		return fmt.Errorf("failed to load configuration: %w", err)
+		return configError(err)
 	}
 	
 	// Apply command line flag overrides
@@ -105,28 +144,65 @@ func runStatus(cmd *cobra.Command) error {
 		cfg.Deployment.StackName = stackName
 	}
 	
+	if err := applyStackSelection(ctx, cmd, cfg); err != nil {
+		return err
+	}
+	
 	// Validate configuration
 	if errors := config.ValidateCLIConfig(cfg); len(errors) > 0 {
-		fmt.Printf("Configuration validation errors:\n")
+		ui.Printf("Configuration validation errors:\n")
 		for _, err := range errors {
-			fmt.Printf("  - %s\n", err.Error())
+			ui.Printf("  - %s\n", err.Error())
 		}
-		return fmt.Errorf("configuration validation failed")
+		return configError(fmt.Errorf("configuration validation failed"))
 	}
 	
-	// Create AWS clients
-	clientFactory, err := awsclients.NewClientFactory(cfg)
+	// Create read-only AWS clients, assuming the monitoring role if given
+	roleARN, _ := cmd.Flags().GetString("role-arn")
+	clientFactory, err := awsclients.NewReadOnlyClientFactory(cfg, roleARN)
 	if err != nil {
-		return fmt.Errorf("failed to create AWS clients: %w", err)
+		return credentialsError(fmt.Errorf("failed to create AWS clients: %w", err))
 	}
 	
 	// Validate AWS credentials
 	if err := clientFactory.ValidateCredentials(ctx); err != nil {
-		return fmt.Errorf("invalid AWS credentials: %w", err)
+		return credentialsError(fmt.Errorf("invalid AWS credentials: %w", err))
 	}
 	
-	clients := clientFactory.GetClients()
+	// Redraw until interrupted in watch mode
+	format, _ := cmd.Flags().GetString("format")
+	if watch, _ := cmd.Flags().GetBool("watch"); watch {
+		if strings.ToLower(format) != "table" {
+			return configError(fmt.Errorf("--watch only supports the table format"))
+		}
+		interval, _ := cmd.Flags().GetDuration("interval")
+		if interval < time.Second {
+			return configError(fmt.Errorf("--interval must be at least 1s"))
+		}
+		dashboardURL, _ := cmd.Flags().GetString("dashboard-url")
+		return watchStatus(ctx, clientFactory.GetClients(), cfg, interval, dashboardURL)
+	}
 	
+	// Gather status information, with recent logs if requested
+	showLogs, _ := cmd.Flags().GetBool("logs")
+	statusInfo := collectStatus(ctx, clientFactory.GetClients(), cfg, showLogs)
+	
+	// Add the local proxy's sessions and launch timings if requested
+	if showSessions, _ := cmd.Flags().GetBool("sessions"); showSessions {
+		dashboardURL, _ := cmd.Flags().GetString("dashboard-url")
+		sessions, err := fetchProxySessions(ctx, dashboardURL)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️  Could not read sessions from the proxy: %v\n", err)
+		}
+		statusInfo.Proxy = sessions
+	}
+	
+	// Output status in requested format
+	return outputStatus(statusInfo, format)
+}
+
+// collectStatus gathers the status of the deployment described by cfg
+func collectStatus(ctx context.Context, clients *awsclients.Clients, cfg *config.CLIConfig, withLogs bool) *StatusInfo {
 	// Gather status information
 	statusInfo := &StatusInfo{
 		Summary: &StatusSummary{
@@ -181,10 +257,7 @@ func runStatus(cmd *cobra.Command) error {
 		if statusInfo.Lambda != nil {
 			// Check S3 trigger configuration
 			triggerDeployer := deploy.NewTriggerDeployer(clients, cfg)
-			functionArn := fmt.Sprintf("arn:aws:lambda:%s:%s:function:%s", 
-				cfg.AWS.Region, 
-				clients.AccountID, 
-				statusInfo.Lambda.Name)
+			functionArn := shared.FunctionARN(clients.Partition, cfg.AWS.Region, clients.AccountID, statusInfo.Lambda.Name)
 			if err := triggerDeployer.ValidateTriggerConfiguration(ctx, statusInfo.Stack.BucketName, functionArn); err == nil {
 				statusInfo.Summary.TriggersOK = true
 			} else {
@@ -194,8 +267,7 @@ func runStatus(cmd *cobra.Command) error {
 	}
 	
 	// Get recent logs if requested
-	showLogs, _ := cmd.Flags().GetBool("logs")
-	if showLogs && statusInfo.Lambda != nil {
+	if withLogs && statusInfo.Lambda != nil {
 		// Fetch recent logs
 		if logs, err := getRecentLogs(ctx, clients, statusInfo.Lambda.Name); err == nil {
 			statusInfo.Logs = logs
@@ -215,9 +287,7 @@ func runStatus(cmd *cobra.Command) error {
 		statusInfo.Summary.Overall = "UNHEALTHY"
 	}
 	
-	// Output status in requested format
-	format, _ := cmd.Flags().GetString("format")
-	return outputStatus(statusInfo, format)
+	return statusInfo
 }
 
 func getS3Status(ctx context.Context, clients *awsclients.Clients, cfg *config.CLIConfig, bucketName string) (*S3Status, error) {
@@ -230,7 +300,7 @@ func getS3Status(ctx context.Context, clients *awsclients.Clients, cfg *config.C
 		Bucket: aws.String(bucketName),
 	}
 	
-	result, err := clients.S3.ListObjectsV2WithContext(ctx, input)
+	result, err := clients.S3.ListObjectsV2(ctx, input)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list S3 objects: %w", err)
 	}
@@ -267,12 +337,12 @@ func getRecentLogs(ctx context.Context, clients *awsclients.Clients, functionNam
 	// Get log streams
 	input := &cloudwatchlogs.DescribeLogStreamsInput{
 		LogGroupName: aws.String(logGroupName),
-		OrderBy:      aws.String("LastEventTime"),
+		OrderBy:      cwltypes.OrderByLastEventTime,
 		Descending:   aws.Bool(true),
-		Limit:        aws.Int64(5),
+		Limit:        aws.Int32(5),
 	}
 	
-	streams, err := clients.CloudWatchLogs.DescribeLogStreamsWithContext(ctx, input)
+	streams, err := clients.CloudWatchLogs.DescribeLogStreams(ctx, input)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get log streams: %w", err)
 	}
@@ -285,10 +355,10 @@ func getRecentLogs(ctx context.Context, clients *awsclients.Clients, functionNam
 			LogGroupName:  aws.String(logGroupName),
 			LogStreamName: streams.LogStreams[0].LogStreamName,
 			StartFromHead: aws.Bool(false),
-			Limit:         aws.Int64(20),
+			Limit:         aws.Int32(20),
 		}
 		
-		events, err := clients.CloudWatchLogs.GetLogEventsWithContext(ctx, eventsInput)
+		events, err := clients.CloudWatchLogs.GetLogEvents(ctx, eventsInput)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get log events: %w", err)
 		}
@@ -342,150 +412,402 @@ func outputStatus(status *StatusInfo, format string) error {
 		return outputStatusTable(status)
 		
 	default:
-		return fmt.Errorf("unsupported format: %s (use table, json, or yaml)", format)
+		return configError(fmt.Errorf("unsupported format: %s (use table, json, or yaml)", format))
 	}
 	
 	return nil
 }
 
 func outputStatusTable(status *StatusInfo) error {
-	fmt.Printf("\n🚀 Lambda NAT Proxy Status\n")
-	fmt.Printf("========================\n\n")
+	ui.Printf("\n🚀 Lambda NAT Proxy Status\n")
+	ui.Printf("========================\n\n")
 	
 	// Overall status
-	statusEmoji := "❌"
+	statusEmoji := ui.Fail
 	if status.Summary.Overall == "HEALTHY" {
-		statusEmoji = "✅"
+		statusEmoji = ui.OK
 	} else if status.Summary.Overall == "DEGRADED" {
-		statusEmoji = "⚠️"
+		statusEmoji = ui.Warn
 	}
 	
-	fmt.Printf("Overall Status: %s %s\n", statusEmoji, status.Summary.Overall)
-	fmt.Printf("Last Updated:   %s\n\n", status.Summary.LastUpdated)
+	ui.Printf("Overall Status: %s %s\n", statusEmoji, status.Summary.Overall)
+	ui.Printf("Last Updated:   %s\n\n", status.Summary.LastUpdated)
 	
 	// CloudFormation Stack
-	fmt.Printf("📦 CloudFormation Stack\n")
-	fmt.Printf("----------------------\n")
+	ui.Printf("📦 CloudFormation Stack\n")
+	ui.Printf("----------------------\n")
 	if status.Stack != nil {
-		statusIcon := "✅"
+		statusIcon := ui.OK
 		if !status.Summary.StackOK {
-			statusIcon = "❌"
+			statusIcon = ui.Fail
 		}
-		fmt.Printf("Status:      %s %s\n", statusIcon, status.Stack.Status)
-		fmt.Printf("Name:        %s\n", status.Stack.Name)
+		ui.Printf("Status:      %s %s\n", statusIcon, status.Stack.Status)
+		ui.Printf("Name:        %s\n", status.Stack.Name)
 		if status.Stack.CreatedAt != nil {
-			fmt.Printf("Created:     %s\n", status.Stack.CreatedAt.Format("2006-01-02 15:04:05"))
+			ui.Printf("Created:     %s\n", status.Stack.CreatedAt.Format("2006-01-02 15:04:05"))
 		}
 		if status.Stack.UpdatedAt != nil {
-			fmt.Printf("Updated:     %s\n", status.Stack.UpdatedAt.Format("2006-01-02 15:04:05"))
+			ui.Printf("Updated:     %s\n", status.Stack.UpdatedAt.Format("2006-01-02 15:04:05"))
 		}
-		fmt.Printf("Bucket:      %s\n", status.Stack.BucketName)
+		ui.Printf("Bucket:      %s\n", status.Stack.BucketName)
 	} else {
-		fmt.Printf("Status:      ❌ NOT FOUND\n")
+		ui.Printf("Status:      ❌ NOT FOUND\n")
 	}
-	fmt.Println()
+	ui.Println()
 	
 	// Lambda Function
-	fmt.Printf("⚡ Lambda Function\n")
-	fmt.Printf("-----------------\n")
+	ui.Printf("⚡ Lambda Function\n")
+	ui.Printf("-----------------\n")
 	if status.Lambda != nil {
-		statusIcon := "✅"
+		statusIcon := ui.OK
 		if !status.Summary.LambdaOK {
-			statusIcon = "❌"
-		}
-		fmt.Printf("Status:      %s %s\n", statusIcon, status.Lambda.State)
-		fmt.Printf("Name:        %s\n", status.Lambda.Name)
-		fmt.Printf("Runtime:     %s\n", status.Lambda.Runtime)
-		fmt.Printf("Memory:      %d MB\n", status.Lambda.MemorySize)
-		fmt.Printf("Timeout:     %d seconds\n", status.Lambda.Timeout)
-		fmt.Printf("Code Size:   %d bytes\n", status.Lambda.CodeSize)
-		fmt.Printf("Modified:    %s\n", status.Lambda.LastModified)
+			statusIcon = ui.Fail
+		}
+		ui.Printf("Status:      %s %s\n", statusIcon, status.Lambda.State)
+		ui.Printf("Name:        %s\n", status.Lambda.Name)
+		ui.Printf("Runtime:     %s\n", status.Lambda.Runtime)
+		ui.Printf("Memory:      %d MB\n", status.Lambda.MemorySize)
+		ui.Printf("Timeout:     %d seconds\n", status.Lambda.Timeout)
+		ui.Printf("Code Size:   %d bytes\n", status.Lambda.CodeSize)
+		ui.Printf("Modified:    %s\n", status.Lambda.LastModified)
 	} else {
-		fmt.Printf("Status:      ❌ NOT FOUND\n")
+		ui.Printf("Status:      ❌ NOT FOUND\n")
 	}
-	fmt.Println()
+	ui.Println()
 	
 	// S3 Bucket
-	fmt.Printf("🪣 S3 Bucket\n")
-	fmt.Printf("------------\n")
+	ui.Printf("🪣 S3 Bucket\n")
+	ui.Printf("------------\n")
 	if status.S3 != nil {
-		statusIcon := "✅"
+		statusIcon := ui.OK
 		if !status.Summary.S3OK {
-			statusIcon = "❌"
+			statusIcon = ui.Fail
 		}
-		fmt.Printf("Status:       %s ACCESSIBLE\n", statusIcon)
-		fmt.Printf("Name:         %s\n", status.S3.BucketName)
-		fmt.Printf("Objects:      %d\n", status.S3.ObjectCount)
-		fmt.Printf("Total Size:   %d bytes\n", status.S3.TotalSize)
+		ui.Printf("Status:       %s ACCESSIBLE\n", statusIcon)
+		ui.Printf("Name:         %s\n", status.S3.BucketName)
+		ui.Printf("Objects:      %d\n", status.S3.ObjectCount)
+		ui.Printf("Total Size:   %d bytes\n", status.S3.TotalSize)
 		if status.S3.LastActivity != "" {
-			fmt.Printf("Last Activity:%s\n", status.S3.LastActivity)
+			ui.Printf("Last Activity:%s\n", status.S3.LastActivity)
 		}
 		
-		notificationIcon := "✅"
+		notificationIcon := ui.OK
 		if !status.S3.NotificationsOK {
-			notificationIcon = "❌"
+			notificationIcon = ui.Fail
 		}
-		fmt.Printf("Notifications:%s Configured\n", notificationIcon)
+		ui.Printf("Notifications:%s Configured\n", notificationIcon)
 	} else {
-		fmt.Printf("Status:       ❌ NOT ACCESSIBLE\n")
+		ui.Printf("Status:       ❌ NOT ACCESSIBLE\n")
 	}
-	fmt.Println()
+	ui.Println()
 	
 	// S3 Triggers
-	fmt.Printf("🔗 S3 Triggers\n")
-	fmt.Printf("--------------\n")
-	triggerIcon := "✅"
+	ui.Printf("🔗 S3 Triggers\n")
+	ui.Printf("--------------\n")
+	triggerIcon := ui.OK
 	if !status.Summary.TriggersOK {
-		triggerIcon = "❌"
+		triggerIcon = ui.Fail
 	}
 	if status.Lambda != nil && status.S3 != nil {
-		fmt.Printf("Status:      %s CONFIGURED\n", triggerIcon)
+		ui.Printf("Status:      %s CONFIGURED\n", triggerIcon)
 	} else {
-		fmt.Printf("Status:      ❌ NOT AVAILABLE (missing dependencies)\n")
+		ui.Printf("Status:      ❌ NOT AVAILABLE (missing dependencies)\n")
 	}
-	fmt.Println()
+	ui.Println()
 	
 	// Recent Logs
 	if len(status.Logs) > 0 {
-		fmt.Printf("📋 Recent Logs\n")
-		fmt.Printf("--------------\n")
+		ui.Printf("📋 Recent Logs\n")
+		ui.Printf("--------------\n")
 		for i, entry := range status.Logs {
 			if i >= 10 { // Limit to 10 entries in table view
 				break
 			}
 			levelIcon := "ℹ️"
 			if entry.Level == "ERROR" {
-				levelIcon = "❌"
+				levelIcon = ui.Fail
 			} else if entry.Level == "WARN" {
-				levelIcon = "⚠️"
+				levelIcon = ui.Warn
 			}
-			fmt.Printf("%s [%s] %s\n", levelIcon, entry.Timestamp, entry.Message)
+			ui.Printf("%s [%s] %s\n", levelIcon, entry.Timestamp, entry.Message)
 		}
-		fmt.Println()
+		ui.Println()
+	}
+	
+	// Proxy sessions and launch timings
+	if status.Proxy != nil {
+		outputSessionsTable(status.Proxy)
 	}
 	
 	// Summary
-	fmt.Printf("💡 Quick Status\n")
-	fmt.Printf("---------------\n")
-	fmt.Printf("Stack:    %s\n", boolToIcon(status.Summary.StackOK))
-	fmt.Printf("Lambda:   %s\n", boolToIcon(status.Summary.LambdaOK))
-	fmt.Printf("S3:       %s\n", boolToIcon(status.Summary.S3OK))
-	fmt.Printf("Triggers: %s\n", boolToIcon(status.Summary.TriggersOK))
+	ui.Printf("💡 Quick Status\n")
+	ui.Printf("---------------\n")
+	ui.Printf("Stack:    %s\n", boolToIcon(status.Summary.StackOK))
+	ui.Printf("Lambda:   %s\n", boolToIcon(status.Summary.LambdaOK))
+	ui.Printf("S3:       %s\n", boolToIcon(status.Summary.S3OK))
+	ui.Printf("Triggers: %s\n", boolToIcon(status.Summary.TriggersOK))
 	
 	// Show deployment guidance if nothing is deployed
 	if status.Summary.Overall == "UNHEALTHY" && !status.Summary.StackOK {
-		fmt.Printf("\n💡 Getting Started\n")
-		fmt.Printf("------------------\n")
-		fmt.Printf("No infrastructure found. To get started:\n\n")
-		fmt.Printf("1. Deploy the infrastructure:\n")
-		fmt.Printf("   lambda-nat-proxy deploy\n\n")
-		fmt.Printf("2. Start the proxy:\n")
-		fmt.Printf("   lambda-nat-proxy run\n")
+		ui.Printf("\n💡 Getting Started\n")
+		ui.Printf("------------------\n")
+		ui.Printf("No infrastructure found. To get started:\n\n")
+		ui.Printf("1. Deploy the infrastructure:\n")
+		ui.Printf("   lambda-nat-proxy deploy\n\n")
+		ui.Printf("2. Start the proxy:\n")
+		ui.Printf("   lambda-nat-proxy run\n")
 	}
 	
 	return nil
 }
 
+// invocationMetricPeriod is the CloudWatch period invocation counts are summed over
+const invocationMetricPeriod = 5 * time.Minute
+
+// getLambdaInvocations counts the function's invocations and errors in the
+// last five minutes and the last hour
+func getLambdaInvocations(ctx context.Context, clients *awsclients.Clients, functionName string) (*InvocationStatus, error) {
+	invocations := &InvocationStatus{}
+	now := time.Now()
+	
+	for _, metric := range []struct {
+		name        string
+		last5, hour *int64
+	}{
+		{"Invocations", &invocations.Last5Minutes, &invocations.LastHour},
+		{"Errors", &invocations.Errors5Minutes, &invocations.ErrorsLastHour},
+	} {
+		result, err := clients.CloudWatch.GetMetricStatistics(ctx, &cloudwatch.GetMetricStatisticsInput{
+			Namespace:  aws.String("AWS/Lambda"),
+			MetricName: aws.String(metric.name),
+			Dimensions: []cwtypes.Dimension{{
+				Name:  aws.String("FunctionName"),
+				Value: aws.String(functionName),
+			}},
+			StartTime:  aws.Time(now.Add(-time.Hour)),
+			EndTime:    aws.Time(now),
+			Period:     aws.Int32(int32(invocationMetricPeriod.Seconds())),
+			Statistics: []cwtypes.Statistic{cwtypes.StatisticSum},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s metric: %w", metric.name, err)
+		}
+		
+		for _, point := range result.Datapoints {
+			sum := int64(aws.ToFloat64(point.Sum))
+			*metric.hour += sum
+			if now.Sub(aws.ToTime(point.Timestamp)) <= invocationMetricPeriod {
+				*metric.last5 += sum
+			}
+		}
+	}
+	
+	return invocations, nil
+}
+
+// clearScreen moves the cursor home and clears the terminal
+const clearScreen = "\033[H\033[2J"
+
+// watchStatus polls the deployment, its invocation counts and the local
+// proxy's sessions every interval and redraws them until interrupted
+func watchStatus(ctx context.Context, clients *awsclients.Clients, cfg *config.CLIConfig, interval time.Duration, dashboardURL string) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+	
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	
+	for {
+		status := collectStatus(ctx, clients, cfg, false)
+		if status.Lambda != nil {
+			if invocations, err := getLambdaInvocations(ctx, clients, status.Lambda.Name); err == nil {
+				status.Invocations = invocations
+			}
+		}
+		proxy, proxyErr := fetchProxySessions(ctx, dashboardURL)
+		status.Proxy = proxy
+		
+		if ctx.Err() != nil {
+			return nil
+		}
+		ui.Print(clearScreen)
+		outputWatchFrame(status, proxyErr, interval)
+		
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// outputWatchFrame prints one compact redraw of the watch view
+func outputWatchFrame(status *StatusInfo, proxyErr error, interval time.Duration) {
+	ui.Printf("🚀 Lambda NAT Proxy Status  (every %s, Ctrl-C to stop)  %s\n", interval, status.Summary.LastUpdated)
+	ui.Printf("Overall: %s\n\n", status.Summary.Overall)
+	
+	w := tabwriter.NewWriter(ui.Stdout, 0, 0, 2, ' ', 0)
+	stackState, lambdaState := "NOT FOUND", "NOT FOUND"
+	if status.Stack != nil {
+		stackState = status.Stack.Status
+	}
+	if status.Lambda != nil {
+		lambdaState = status.Lambda.State
+	}
+	fmt.Fprintf(w, "Stack:\t%s\t%s\n", boolToIcon(status.Summary.StackOK), stackState)
+	fmt.Fprintf(w, "Lambda:\t%s\t%s\n", boolToIcon(status.Summary.LambdaOK), lambdaState)
+	fmt.Fprintf(w, "S3:\t%s\t\n", boolToIcon(status.Summary.S3OK))
+	fmt.Fprintf(w, "Triggers:\t%s\t\n", boolToIcon(status.Summary.TriggersOK))
+	w.Flush()
+	ui.Println()
+	
+	ui.Printf("⚡ Invocations\n")
+	ui.Printf("--------------\n")
+	if status.Invocations != nil {
+		ui.Printf("Last 5 min:  %d (%d errors)\n", status.Invocations.Last5Minutes, status.Invocations.Errors5Minutes)
+		ui.Printf("Last hour:   %d (%d errors)\n", status.Invocations.LastHour, status.Invocations.ErrorsLastHour)
+	} else {
+		ui.Printf("Not available\n")
+	}
+	ui.Println()
+	
+	ui.Printf("🔌 Proxy Sessions\n")
+	ui.Printf("-----------------\n")
+	switch {
+	case proxyErr != nil:
+		ui.Printf("Proxy not running on this machine\n")
+	case len(status.Proxy.Sessions) == 0:
+		ui.Printf("No active sessions\n")
+	default:
+		w = tabwriter.NewWriter(ui.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ROLE\tSESSION\tSTATUS\tUP\tTTL\tSTREAMS\tRTT\t")
+		for _, session := range status.Proxy.Sessions {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%.0fms\t\n", session.Role, session.ID, session.Status,
+				session.Duration.Round(time.Second), formatTTL(session.TimeToLive), session.ActiveStreams, session.RTT)
+		}
+		w.Flush()
+	}
+}
+
+// formatTTL renders the time left before a session rotates
+func formatTTL(ttl time.Duration) string {
+	if ttl <= 0 {
+		return "-"
+	}
+	return ttl.Round(time.Second).String()
+}
+
+// fetchProxySessions reads the sessions and recent launches of the proxy
+// serving its dashboard at dashboardURL
+func fetchProxySessions(ctx context.Context, dashboardURL string) (*dashboard.SessionsResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(dashboardURL, "/")+"/api/sessions", nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dashboard URL: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("is 'lambda-nat-proxy run' running with the dashboard? %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("dashboard returned %s", resp.Status)
+	}
+	
+	var sessions dashboard.SessionsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&sessions); err != nil {
+		return nil, fmt.Errorf("failed to decode sessions: %w", err)
+	}
+	return &sessions, nil
+}
+
+// launchPhaseColumns are the launch phases shown in the status table
+var launchPhaseColumns = []struct {
+	phase  string
+	header string
+}{
+	{manager.LaunchPhaseSTUN, "STUN"},
+	{manager.LaunchPhaseS3Put, "S3 PUT"},
+	{manager.LaunchPhaseLambdaWait, "LAMBDA"},
+	{manager.LaunchPhaseHolePunch, "PUNCH"},
+	{manager.LaunchPhaseRelay, "RELAY"},
+	{manager.LaunchPhaseQUICHandshake, "QUIC"},
+	{manager.LaunchPhaseControlStream, "CONTROL"},
+	{manager.LaunchPhaseHello, "HELLO"},
+}
+
+// outputSessionsTable prints the proxy's sessions and a per-phase breakdown of its recent launches
+func outputSessionsTable(proxy *dashboard.SessionsResponse) {
+	ui.Printf("🔌 Proxy Sessions\n")
+	ui.Printf("-----------------\n")
+	if len(proxy.Sessions) == 0 {
+		ui.Printf("No active sessions\n")
+	}
+	for _, session := range proxy.Sessions {
+		ui.Printf("%-10s %s  %s, up %s, %d streams\n", session.Role, session.ID, session.Status,
+			session.Duration.Round(time.Second), session.ActiveStreams)
+		ui.Printf("%-10s %s\n", "", session.Crypto)
+		if session.Relayed {
+			ui.Printf("%-10s %s\n", "", "relayed over TCP")
+		}
+		for _, warning := range session.Crypto.Warnings {
+			ui.Printf("%-10s %s %s\n", "", ui.Warn, warning)
+		}
+	}
+	ui.Println()
+	
+	if len(proxy.Launches) == 0 {
+		return
+	}
+	ui.Printf("⏱️  Recent Launches\n")
+	ui.Printf("------------------\n")
+	w := tabwriter.NewWriter(ui.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(w, "STARTED\t")
+	for _, column := range launchPhaseColumns {
+		fmt.Fprintf(w, "%s\t", column.header)
+	}
+	fmt.Fprintln(w, "TRIGGER\tTOTAL\t")
+	for _, launch := range proxy.Launches {
+		fmt.Fprintf(w, "%s\t", launch.StartedAt.Local().Format("15:04:05"))
+		for _, column := range launchPhaseColumns {
+			fmt.Fprintf(w, "%s\t", formatPhase(launch, column.phase))
+		}
+		total := launch.Duration.Round(time.Millisecond).String()
+		if launch.Error != "" {
+			total = "failed"
+		}
+		fmt.Fprintf(w, "%s\t%s\t\n", formatTrigger(launch), total)
+	}
+	w.Flush()
+	for _, launch := range proxy.Launches {
+		if launch.Error != "" {
+			ui.Printf("❌ %s: %s\n", launch.StartedAt.Local().Format("15:04:05"), launch.Error)
+		}
+	}
+	ui.Println()
+}
+
+// formatPhase renders one phase of a launch in milliseconds, or "-" if it didn't run
+func formatPhase(launch manager.LaunchRecord, phase string) string {
+	for _, p := range launch.Phases {
+		if p.Name == phase {
+			return fmt.Sprintf("%dms", p.Duration.Milliseconds())
+		}
+	}
+	return "-"
+}
+
+// formatTrigger renders how the Lambda was started and how long after the S3
+// write, e.g. "s3 380ms", or "-" if it didn't say
+func formatTrigger(launch manager.LaunchRecord) string {
+	if launch.Trigger == "" {
+		return "-"
+	}
+	return fmt.Sprintf("%s %dms", launch.Trigger, launch.TriggerLatency.Milliseconds())
+}
+
 func boolToIcon(b bool) string {
 	if b {
 		return "✅ OK"
@@ -499,4 +821,51 @@ func init() {
 	statusCmd.Flags().StringP("stack-name", "s", "", "CloudFormation stack name")
 	statusCmd.Flags().StringP("format", "", "table", "Output format (table, json, yaml)")
 	statusCmd.Flags().BoolP("logs", "l", false, "Show recent Lambda logs")
+	statusCmd.Flags().String("role-arn", "", "IAM role to assume for reading a deployment in another account")
+	statusCmd.Flags().Bool("sessions", false, "Show the sessions and launch timings of the proxy running on this machine")
+	statusCmd.Flags().String("dashboard-url", "http://localhost:8081", "Dashboard of the proxy to read sessions from")
+	statusCmd.Flags().BoolP("watch", "w", false, "Redraw deployment, invocation and session status until interrupted")
+	statusCmd.Flags().Duration("interval", 5*time.Second, "How often --watch polls")
+	statusCmd.Flags().Bool("local", false, "Show the proxy running on this machine, without calling AWS")
+	addControlSocketFlag(statusCmd)
+}
+
+// deploymentSource returns a dashboard deployment source that checks the stack
+// and Lambda described by cfg through read-only clients
+func deploymentSource(cfg *config.CLIConfig, roleARN string) (dashboard.DeploymentSource, error) {
+	clientFactory, err := awsclients.NewReadOnlyClientFactory(cfg, roleARN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS clients: %w", err)
+	}
+	clients := clientFactory.GetClients()
+	
+	return func(ctx context.Context) (*dashboard.DeploymentStatus, error) {
+		status := &dashboard.DeploymentStatus{
+			StackName: cfg.Deployment.StackName,
+			Region:    cfg.AWS.Region,
+			ReadOnly:  clientFactory.IsReadOnly(),
+		}
+		
+		accountID, err := clientFactory.GetAccountID(ctx)
+		if err != nil {
+			return status, err
+		}
+		status.AccountID = accountID
+		
+		stackOutput, err := deploy.NewStackDeployer(clients, cfg).GetStackOutputs(ctx)
+		if err != nil {
+			return status, err
+		}
+		status.StackName = stackOutput.StackName
+		status.StackStatus = stackOutput.StackStatus
+		
+		lambdaInfo, err := deploy.NewLambdaDeployer(clients, cfg).GetFunctionInfo(ctx)
+		if err != nil {
+			return status, err
+		}
+		status.LambdaName = lambdaInfo.FunctionName
+		status.LambdaState = lambdaInfo.State
+		
+		return status, nil
+	}, nil
 }
\ No newline at end of file
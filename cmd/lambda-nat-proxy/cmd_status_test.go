@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+
+	awsclients "github.com/dan-v/lambda-nat-punch-proxy/internal/aws"
+)
+
+// fakeCloudWatch returns canned datapoints per metric name
+type fakeCloudWatch struct {
+	datapoints map[string][]cwtypes.Datapoint
+}
+
+func (f fakeCloudWatch) GetMetricStatistics(ctx context.Context, input *cloudwatch.GetMetricStatisticsInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricStatisticsOutput, error) {
+	return &cloudwatch.GetMetricStatisticsOutput{Datapoints: f.datapoints[aws.ToString(input.MetricName)]}, nil
+}
+
+func TestGetLambdaInvocations(t *testing.T) {
+	now := time.Now()
+	point := func(age time.Duration, sum float64) cwtypes.Datapoint {
+		return cwtypes.Datapoint{Timestamp: aws.Time(now.Add(-age)), Sum: aws.Float64(sum)}
+	}
+	clients := &awsclients.Clients{CloudWatch: fakeCloudWatch{datapoints: map[string][]cwtypes.Datapoint{
+		"Invocations": {point(2*time.Minute, 3), point(20*time.Minute, 5), point(50*time.Minute, 1)},
+		"Errors":      {point(40*time.Minute, 2)},
+	}}}
+
+	invocations, err := getLambdaInvocations(context.Background(), clients, "proxy-fn")
+	if err != nil {
+		t.Fatalf("getLambdaInvocations failed: %v", err)
+	}
+	want := InvocationStatus{Last5Minutes: 3, LastHour: 9, Errors5Minutes: 0, ErrorsLastHour: 2}
+	if *invocations != want {
+		t.Errorf("Expected %+v, got %+v", want, *invocations)
+	}
+}
+
+func TestFormatTTL(t *testing.T) {
+	if got := formatTTL(0); got != "-" {
+		t.Errorf("Expected \"-\" for an expired TTL, got %q", got)
+	}
+	if got := formatTTL(90*time.Second + 400*time.Millisecond); got != "1m30s" {
+		t.Errorf("Expected 1m30s, got %q", got)
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"runtime"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+
+	awsclients "github.com/dan-v/lambda-nat-punch-proxy/internal/aws"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/stun"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/ui"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// Support bundle limits
+const (
+	supportFetchTimeout = 3 * time.Second
+	supportNATTimeout   = 10 * time.Second
+	supportMaxLogBytes  = 1 << 20 // tail of --log-file kept in the bundle
+
+	// supportSecondSTUNServer is compared with the configured server to
+	// diagnose the NAT's mapping behaviour
+	supportSecondSTUNServer = "stun.cloudflare.com:3478"
+)
+
+// Local endpoints of a running proxy, matching the addresses used by run
+var supportProxyEndpoints = map[string]string{
+	"proxy/sessions.json":  "http://localhost:8081/api/sessions",
+	"proxy/rotations.json": "http://localhost:8081/api/rotations",
+	"proxy/dashboard.json": "http://localhost:8081/api/dashboard",
+	"proxy/metrics.txt":    "http://localhost:6060/metrics",
+}
+
+// supportBundleCmd represents the support-bundle command
+var supportBundleCmd = &cobra.Command{
+	Use:   "support-bundle",
+	Short: "Collect diagnostics into an archive for bug reports",
+	Long: `Collect diagnostics into a single zip archive to attach to bug reports.
+
+The bundle contains:
+- The configuration, with the AWS profile, account IDs and access keys removed
+- Version, Go runtime and platform information
+- Stack, Lambda and S3 status with recent Lambda logs (skip with --skip-aws)
+- A NAT diagnosis comparing the mappings seen by two STUN servers (skip with --skip-nat)
+- Sessions, rotations and metrics from a proxy running on this machine, if any
+- The tail of a local proxy log file given with --log-file
+
+Sections that cannot be collected are listed in manifest.json instead.
+Public IP addresses, such as this machine's and the Lambdas', are replaced
+with <ip> unless you opt in with --include-ips. Review the archive before
+sharing it.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runSupportBundle(cmd)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(supportBundleCmd)
+
+	supportBundleCmd.Flags().StringP("output", "o", "", "Archive path (default lambda-nat-proxy-support-<time>.zip)")
+	supportBundleCmd.Flags().String("log-file", "", "Proxy log file to include (last 1MB)")
+	supportBundleCmd.Flags().Bool("skip-aws", false, "Don't query AWS for stack status and Lambda logs")
+	supportBundleCmd.Flags().Bool("skip-nat", false, "Don't run the NAT diagnosis")
+	supportBundleCmd.Flags().Bool("include-ips", false, "Keep public IP addresses instead of replacing them with <ip>")
+	supportBundleCmd.Flags().Bool("redact-ips", true, "Replace public IP addresses in every file")
+	supportBundleCmd.Flags().MarkDeprecated("redact-ips", "public IP addresses are redacted by default; use --include-ips to keep them")
+	supportBundleCmd.Flags().StringP("region", "r", "", "AWS region (overrides config)")
+	supportBundleCmd.Flags().StringP("stack-name", "s", "", "CloudFormation stack name (overrides config)")
+	supportBundleCmd.Flags().String("role-arn", "", "Read-only IAM role to assume for the status section")
+}
+
+// SupportManifest describes the contents of a support bundle
+type SupportManifest struct {
+	CreatedAt string            `json:"created_at"`
+	Version   string            `json:"version"`
+	GoVersion string            `json:"go_version"`
+	Platform  string            `json:"platform"`
+	Redacted  []string          `json:"redacted"`
+	Files     []string          `json:"files"`
+	Skipped   map[string]string `json:"skipped,omitempty"` // section -> reason
+}
+
+// supportBundle writes sanitized files into a zip archive
+type supportBundle struct {
+	zip       *zip.Writer
+	redactIPs bool
+	manifest  SupportManifest
+}
+
+func runSupportBundle(cmd *cobra.Command) error {
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return configError(err)
+	}
+	if region, _ := cmd.Flags().GetString("region"); cmd.Flags().Changed("region") {
+		cfg.AWS.Region = region
+	}
+	if stackName, _ := cmd.Flags().GetString("stack-name"); cmd.Flags().Changed("stack-name") {
+		cfg.Deployment.StackName = stackName
+	}
+	if err := applyStackSelection(context.Background(), cmd, cfg); err != nil {
+		return err
+	}
+
+	outputPath, _ := cmd.Flags().GetString("output")
+	if outputPath == "" {
+		outputPath = fmt.Sprintf("lambda-nat-proxy-support-%s.zip", time.Now().Format("20060102-150405"))
+	}
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create support bundle: %w", err)
+	}
+	defer file.Close()
+
+	includeIPs, _ := cmd.Flags().GetBool("include-ips")
+	bundle := newSupportBundle(file, !includeIPs)
+	ctx := context.Background()
+
+	ui.Println("📦 Collecting support bundle...")
+	bundle.addYAML("config.yaml", sanitizedConfig(cfg))
+	for _, line := range config.ValidateCLIConfig(cfg) {
+		bundle.skip("config validation", line.Error())
+	}
+
+	if skipAWS, _ := cmd.Flags().GetBool("skip-aws"); skipAWS {
+		bundle.skip("status.json", "--skip-aws")
+	} else {
+		ui.Println("   Reading deployment status and Lambda logs...")
+		roleARN, _ := cmd.Flags().GetString("role-arn")
+		bundle.addStatus(ctx, cfg, roleARN)
+	}
+
+	if skipNAT, _ := cmd.Flags().GetBool("skip-nat"); skipNAT {
+		bundle.skip("nat.json", "--skip-nat")
+	} else {
+		ui.Println("   Diagnosing NAT behaviour...")
+		natCtx, cancel := context.WithTimeout(ctx, supportNATTimeout)
+		report, err := stun.Diagnose(natCtx, []string{cfg.Proxy.STUNServer, supportSecondSTUNServer})
+		cancel()
+		if err != nil {
+			bundle.skip("nat.json", err.Error())
+		} else {
+			bundle.addJSON("nat.json", report)
+		}
+	}
+
+	bundle.addProxyState()
+
+	if logFile, _ := cmd.Flags().GetString("log-file"); logFile != "" {
+		bundle.addLogTail("proxy.log", logFile)
+	}
+
+	if err := bundle.close(); err != nil {
+		return fmt.Errorf("failed to write support bundle: %w", err)
+	}
+
+	ui.Printf("✅ Support bundle written to %s (%d files)\n", outputPath, len(bundle.manifest.Files))
+	if len(bundle.manifest.Skipped) > 0 {
+		ui.Printf("⚠️  %d sections could not be collected; see manifest.json\n", len(bundle.manifest.Skipped))
+	}
+	ui.Println("Review the archive before attaching it to a bug report.")
+	return nil
+}
+
+func newSupportBundle(w io.Writer, redactIPs bool) *supportBundle {
+	redacted := []string{"aws profile", "account ids", "access keys"}
+	if redactIPs {
+		redacted = append(redacted, "public ip addresses")
+	}
+	return &supportBundle{
+		zip:       zip.NewWriter(w),
+		redactIPs: redactIPs,
+		manifest: SupportManifest{
+			CreatedAt: time.Now().UTC().Format(time.RFC3339),
+			Version:   version,
+			GoVersion: runtime.Version(),
+			Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+			Redacted:  redacted,
+			Skipped:   make(map[string]string),
+		},
+	}
+}
+
+// add writes data to name after sanitizing it
+func (b *supportBundle) add(name string, data []byte) {
+	w, err := b.zip.Create(name)
+	if err != nil {
+		b.skip(name, err.Error())
+		return
+	}
+	if _, err := w.Write(sanitizeSupportData(data, b.redactIPs)); err != nil {
+		b.skip(name, err.Error())
+		return
+	}
+	b.manifest.Files = append(b.manifest.Files, name)
+}
+
+func (b *supportBundle) addJSON(name string, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		b.skip(name, err.Error())
+		return
+	}
+	b.add(name, data)
+}
+
+func (b *supportBundle) addYAML(name string, v interface{}) {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		b.skip(name, err.Error())
+		return
+	}
+	b.add(name, data)
+}
+
+// skip records why a section is missing
+func (b *supportBundle) skip(section, reason string) {
+	if previous, ok := b.manifest.Skipped[section]; ok {
+		reason = previous + "; " + reason
+	}
+	b.manifest.Skipped[section] = reason
+}
+
+// addStatus adds the deployment status with recent Lambda logs
+func (b *supportBundle) addStatus(ctx context.Context, cfg *config.CLIConfig, roleARN string) {
+	clientFactory, err := awsclients.NewReadOnlyClientFactory(cfg, roleARN)
+	if err != nil {
+		b.skip("status.json", err.Error())
+		return
+	}
+	if err := clientFactory.ValidateCredentials(ctx); err != nil {
+		b.skip("status.json", err.Error())
+		return
+	}
+	b.addJSON("status.json", collectStatus(ctx, clientFactory.GetClients(), cfg, true))
+}
+
+// addProxyState adds what a locally running proxy reports about itself
+func (b *supportBundle) addProxyState() {
+	client := &http.Client{Timeout: supportFetchTimeout}
+	for name, url := range supportProxyEndpoints {
+		resp, err := client.Get(url)
+		if err != nil {
+			b.skip(name, "no running proxy with the dashboard or metrics enabled")
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, supportMaxLogBytes))
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK {
+			b.skip(name, fmt.Sprintf("%s returned %s", url, resp.Status))
+			continue
+		}
+		b.add(name, data)
+	}
+}
+
+// addLogTail adds the last supportMaxLogBytes of path
+func (b *supportBundle) addLogTail(name, path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		b.skip(name, err.Error())
+		return
+	}
+	defer file.Close()
+
+	if info, err := file.Stat(); err == nil && info.Size() > supportMaxLogBytes {
+		file.Seek(-supportMaxLogBytes, io.SeekEnd)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		b.skip(name, err.Error())
+		return
+	}
+	b.add(name, data)
+}
+
+// close writes the manifest and finishes the archive
+func (b *supportBundle) close() error {
+	b.manifest.Files = append(b.manifest.Files, "manifest.json")
+	data, err := json.MarshalIndent(b.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	w, err := b.zip.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return b.zip.Close()
+}
+
+// sanitizedConfig returns a copy of cfg without the AWS profile name
+func sanitizedConfig(cfg *config.CLIConfig) config.CLIConfig {
+	sanitized := *cfg
+	if sanitized.AWS.Profile != "" {
+		sanitized.AWS.Profile = "<redacted>"
+	}
+	return sanitized
+}
+
+var (
+	supportAccessKeyPattern = regexp.MustCompile(`\b(AKIA|ASIA)[A-Z0-9]{16}\b`)
+	supportAccountIDPattern = regexp.MustCompile(`\b\d{12}\b`)
+)
+
+// sanitizeSupportData removes access keys and account IDs from data, and
+// public IP addresses if redactIPs is set
+func sanitizeSupportData(data []byte, redactIPs bool) []byte {
+	data = supportAccessKeyPattern.ReplaceAll(data, []byte("<access-key>"))
+	data = supportAccountIDPattern.ReplaceAll(data, []byte("<account-id>"))
+	if redactIPs {
+		data = []byte(shared.RedactPublicIPs(string(data)))
+	}
+	return data
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+
+	awsclients "github.com/dan-v/lambda-nat-punch-proxy/internal/aws"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/deploy"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/ui"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// upgradeCmd publishes the embedded Lambda behind the live alias
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "Roll out the embedded Lambda as a new version",
+	Long: `Compare the Lambda binary embedded in this build with the deployed one
+and roll it out as a new published version behind the "live" alias.
+
+The first upgrade creates the alias and points the S3 trigger and
+warm-up invocations at it. After that, deploy updates only $LATEST and
+sessions keep running the live version until the next upgrade.
+
+Examples:
+  lambda-nat-proxy upgrade --check      # Compare without changing anything
+  lambda-nat-proxy upgrade              # Move the alias to the new version
+  lambda-nat-proxy upgrade --canary 10  # Send 10% of sessions to it first
+  lambda-nat-proxy upgrade --promote    # Send all sessions to the canary
+  lambda-nat-proxy upgrade --rollback   # Cancel the canary or go back a version`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runUpgrade(cmd)
+	},
+}
+
+func init() {
+	upgradeCmd.Flags().StringP("region", "r", "", "AWS region (overrides config)")
+	upgradeCmd.Flags().StringP("stack-name", "s", "", "CloudFormation stack name")
+	upgradeCmd.Flags().Int("canary", 0, "Percentage of sessions (1-99) to send to the new version; 0 moves all of them")
+	upgradeCmd.Flags().Bool("promote", false, "Send all sessions to the canary version")
+	upgradeCmd.Flags().Bool("rollback", false, "Cancel the canary, or return to the previous version")
+	upgradeCmd.Flags().Bool("check", false, "Show the deployed and embedded versions without changing anything")
+	upgradeCmd.Flags().Bool("force", false, "Publish a new version even if the code is unchanged")
+	upgradeCmd.MarkFlagsMutuallyExclusive("promote", "rollback", "check", "canary")
+	rootCmd.AddCommand(upgradeCmd)
+}
+
+func runUpgrade(cmd *cobra.Command) error {
+	ctx := context.Background()
+
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		return configError(err)
+	}
+
+	if region, _ := cmd.Flags().GetString("region"); cmd.Flags().Changed("region") {
+		cfg.AWS.Region = region
+	}
+	if stackName, _ := cmd.Flags().GetString("stack-name"); cmd.Flags().Changed("stack-name") {
+		cfg.Deployment.StackName = stackName
+	}
+
+	if err := applyStackSelection(ctx, cmd, cfg); err != nil {
+		return err
+	}
+
+	if errors := config.ValidateCLIConfig(cfg); len(errors) > 0 {
+		ui.Printf("Configuration validation errors:\n")
+		for _, err := range errors {
+			ui.Printf("  - %s\n", err.Error())
+		}
+		return configError(fmt.Errorf("configuration validation failed"))
+	}
+
+	canary, _ := cmd.Flags().GetInt("canary")
+	if canary < 0 || canary > 99 {
+		return configError(fmt.Errorf("--canary must be between 1 and 99 percent, or 0 to move all sessions"))
+	}
+	if cfg.Deployment.Coordination == shared.CoordinationFunctionURL {
+		return configError(fmt.Errorf("upgrade needs s3 coordination: the function URL always runs $LATEST, so 'deploy' rolls out changes directly"))
+	}
+
+	clientFactory, err := awsclients.NewClientFactory(cfg)
+	if err != nil {
+		return credentialsError(fmt.Errorf("failed to create AWS clients: %w", err))
+	}
+	if err := clientFactory.ValidateCredentials(ctx); err != nil {
+		return credentialsError(fmt.Errorf("invalid AWS credentials: %w", err))
+	}
+	clients := clientFactory.GetClients()
+
+	lambdaDeployer := deploy.NewLambdaDeployer(clients, cfg)
+	function, err := lambdaDeployer.GetFunctionInfo(ctx)
+	if err != nil {
+		return infraError(fmt.Errorf("Lambda function not found, run 'lambda-nat-proxy deploy' first: %w", err))
+	}
+
+	if rollback, _ := cmd.Flags().GetBool("rollback"); rollback {
+		version, err := lambdaDeployer.RollbackLive(ctx)
+		if err != nil {
+			return err
+		}
+		ui.Printf("%s The %s alias runs version %s\n", ui.OK, shared.LiveAlias, version)
+		return nil
+	}
+	if promote, _ := cmd.Flags().GetBool("promote"); promote {
+		version, err := lambdaDeployer.PromoteLive(ctx)
+		if err != nil {
+			return err
+		}
+		ui.Printf("%s The %s alias runs version %s\n", ui.OK, shared.LiveAlias, version)
+		return nil
+	}
+
+	binary := (&EmbeddedLambdaProvider{}).GetLambdaBinary(function.Architecture)
+	if len(binary) == 0 {
+		return configError(fmt.Errorf("this build has no embedded %s Lambda binary: rebuild with 'make build'", function.Architecture))
+	}
+	embedded := deploy.CodeSHA256(binary)
+
+	live, err := lambdaDeployer.LiveState(ctx)
+	if err != nil {
+		return err
+	}
+	printLiveState(live, embedded)
+
+	if check, _ := cmd.Flags().GetBool("check"); check {
+		return nil
+	}
+	force, _ := cmd.Flags().GetBool("force")
+	if live.CodeHash == embedded && live.Canary == "" && live.Pinned && !force {
+		ui.Printf("\n%s Already up to date\n", ui.OK)
+		return nil
+	}
+
+	if live.LatestHash != embedded {
+		log.Printf("Updating $LATEST with the embedded %s binary...", function.Architecture)
+		buildResult, err := deploy.NewLambdaBuilderWithProvider(cfg, &EmbeddedLambdaProvider{}).BuildLambdaPackage("build", "lambda")
+		if err != nil {
+			return fmt.Errorf("failed to build Lambda package: %w", err)
+		}
+		if _, err := lambdaDeployer.DeployLambdaFunction(ctx, buildResult.ZipPath, ""); err != nil {
+			return fmt.Errorf("failed to update Lambda function: %w", err)
+		}
+	}
+
+	weight := 1.0
+	if canary > 0 {
+		weight = float64(canary) / 100
+	}
+	version, err := lambdaDeployer.PublishLive(ctx, weight)
+	if err != nil {
+		return err
+	}
+
+	// Until the first upgrade, the trigger and warm-up run $LATEST
+	if !live.Pinned {
+		liveArn := deploy.LiveARN(function.FunctionArn)
+		stackOutput, err := deploy.NewStackDeployer(clients, cfg).GetStackOutputs(ctx)
+		if err != nil {
+			return infraError(fmt.Errorf("failed to get stack outputs: %w", err))
+		}
+		if err := deploy.NewTriggerDeployer(clients, cfg).ConfigureS3Triggers(ctx, stackOutput.CoordinationBucketName, liveArn); err != nil {
+			return fmt.Errorf("failed to point the S3 trigger at the %s alias: %w", shared.LiveAlias, err)
+		}
+		if cfg.Deployment.Warmup > 0 {
+			if err := lambdaDeployer.ConfigureWarmup(ctx, liveArn); err != nil {
+				return fmt.Errorf("failed to point warm-up invocations at the %s alias: %w", shared.LiveAlias, err)
+			}
+		}
+	}
+
+	if canary > 0 && live.Pinned && version != live.Version {
+		ui.Printf("\n%s Version %s receives %d%% of sessions\n", ui.OK, version, canary)
+		ui.Printf("%s Run 'lambda-nat-proxy upgrade --promote' to send all of them, or --rollback to cancel\n", ui.Tip)
+		return nil
+	}
+	ui.Printf("\n%s The %s alias runs version %s\n", ui.OK, shared.LiveAlias, version)
+	return nil
+}
+
+// printLiveState shows what the live alias runs next to the embedded code
+func printLiveState(live *deploy.LiveState, embedded string) {
+	ui.Printf("Embedded code: %s\n", deploy.ShortHash(embedded))
+	if !live.Pinned {
+		ui.Printf("Deployed code: %s ($LATEST, no %s alias yet)\n", deploy.ShortHash(live.CodeHash), shared.LiveAlias)
+		return
+	}
+	ui.Printf("Deployed code: %s (version %s)\n", deploy.ShortHash(live.CodeHash), live.Version)
+	if live.Canary != "" {
+		ui.Printf("Canary:        version %s at %.0f%%\n", live.Canary, live.CanaryWeight*100)
+	}
+	if live.Previous != "" {
+		ui.Printf("Rollback to:   version %s\n", live.Previous)
+	}
+	if live.LatestHash != live.CodeHash {
+		ui.Printf("$LATEST:       %s (not yet published)\n", deploy.ShortHash(live.LatestHash))
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+// detachedProcAttr starts the daemon in its own session, so it outlives the
+// terminal and doesn't receive its Ctrl+C
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package main
+
+import "syscall"
+
+// Process creation flags not exported by the syscall package
+const (
+	createNewProcessGroup = 0x00000200
+	detachedProcess       = 0x00000008
+)
+
+// detachedProcAttr starts the daemon without a console, so it outlives the
+// terminal and doesn't receive its Ctrl+C
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: createNewProcessGroup | detachedProcess}
+}
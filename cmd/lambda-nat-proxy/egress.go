@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/manager"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/nat"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/policy"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/quic"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/s3"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/stun"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// egressRegion keeps sessions in a region named by policy egress rules
+type egressRegion struct {
+	cm    *manager.ConnManager
+	coord s3.Coordinator
+}
+
+// newEgressRegions sets up each region proxyPolicy's rules send traffic
+// through, other than cfg's own, to launch sessions on the stack of the
+// same name deployed there. They share the proxy's STUN client and NAT
+// traversal, so they draw from the same punch ports.
+func newEgressRegions(cfg *config.CLIConfig, proxyPolicy *policy.Policy, stunClient stun.Client, natTraversal nat.Traversal, state *manager.SessionState) (map[string]egressRegion, error) {
+	regions := make(map[string]egressRegion)
+	for _, region := range proxyPolicy.EgressRegions() {
+		if region == cfg.AWS.Region {
+			continue
+		}
+		regionCfg := *cfg
+		regionCfg.AWS.Region = region
+
+		stack, err := autoDetectStack(&regionCfg)
+		if err != nil {
+			return nil, infraError(fmt.Errorf("policy rules send traffic through %s, but no stack is usable there; deploy one with: lambda-nat-proxy deploy --region %s\n\nError details: %v", region, region, err))
+		}
+		runtimeCfg := regionCfg.ToConfig(stack.CoordinationBucketName)
+		runtimeCfg.ACL = proxyPolicy.LambdaACL()
+		runtimeCfg.Bandwidth = proxyPolicy.Bandwidth()
+		if runtimeCfg.SessionCredentials {
+			if stack.SessionCredentialsRoleArn == "" {
+				return nil, infraError(fmt.Errorf("deployment.session_credentials is set but stack %s in %s has no session role; run 'lambda-nat-proxy deploy --region %s' again", cfg.Deployment.StackName, region, region))
+			}
+			runtimeCfg.SessionCredentialsRoleArn = stack.SessionCredentialsRoleArn
+			runtimeCfg.EncryptionKeyArn = stack.EncryptionKeyArn
+		}
+
+		awsCfg, err := shared.LoadAWSConfig(context.Background(), region)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load AWS config for %s: %w", region, err)
+		}
+		s3Client := awss3.NewFromConfig(awsCfg)
+		coord, err := newCoordinator(awsCfg, s3Client, runtimeCfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up coordination in %s: %w", region, err)
+		}
+		cleanupStaleCoordination(s3Client, runtimeCfg)
+
+		launcher := internal.NewLauncher(runtimeCfg, stunClient, coord, natTraversal, quic.New())
+		launcher.SetSessionState(state)
+		cm := manager.New(runtimeCfg, launcher)
+		launcher.SetLaunchHistory(cm.LaunchHistory())
+		regions[region] = egressRegion{cm: cm, coord: coord}
+		log.Printf("Keeping sessions in %s for policy egress rules (S3 bucket %s)", region, runtimeCfg.S3BucketName)
+	}
+	return regions, nil
+}
+
+// startEgressRegions launches sessions in the egress regions until ctx is
+// cancelled. A region that fails only affects the rules that name it.
+func startEgressRegions(ctx context.Context, regions map[string]egressRegion) {
+	for region, egress := range regions {
+		go func(region string, cm *manager.ConnManager) {
+			if err := cm.Start(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("❌ Sessions in %s stopped: %v", region, err)
+			}
+		}(region, egress.cm)
+	}
+}
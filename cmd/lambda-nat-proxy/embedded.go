@@ -1,17 +1,29 @@
 package main
 
 import (
-	_ "embed"
+	"embed"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
 )
 
-// Lambda function binary embedded at build time
-//go:embed assets/bootstrap
-var embeddedLambdaBinary []byte
+// Lambda function binaries embedded at build time: assets/bootstrap for
+// x86_64 and, if it was built, assets/bootstrap-arm64 for arm64
+//
+//go:embed assets
+var embeddedLambdaAssets embed.FS
 
 // EmbeddedLambdaProvider implements LambdaBinaryProvider
 type EmbeddedLambdaProvider struct{}
 
-// GetLambdaBinary returns the embedded Lambda function binary
-func (p *EmbeddedLambdaProvider) GetLambdaBinary() []byte {
-	return embeddedLambdaBinary
-}
\ No newline at end of file
+// GetLambdaBinary returns the embedded Lambda function binary for architecture
+func (p *EmbeddedLambdaProvider) GetLambdaBinary(architecture string) []byte {
+	name := "assets/bootstrap"
+	if architecture == shared.ArchitectureARM64 {
+		name = "assets/bootstrap-arm64"
+	}
+	data, err := embeddedLambdaAssets.ReadFile(name)
+	if err != nil {
+		return nil
+	}
+	return data
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// Exit codes returned by lambda-nat-proxy. These are a stable contract for
+// scripts and wrappers: never renumber them, only add new ones.
+const (
+	ExitOK           = 0 // Command succeeded
+	ExitInternal     = 1 // Unexpected or unclassified failure
+	ExitConfig       = 2 // Invalid configuration, flags or arguments
+	ExitCredentials  = 3 // AWS credentials missing, expired or rejected
+	ExitInfraMissing = 4 // CloudFormation stack or its resources not deployed
+	ExitNetwork      = 5 // Tunnel could not be established or network timeout
+)
+
+// exitCodesHelp documents the exit codes in --help output
+const exitCodesHelp = `
+Exit codes:
+  0  success
+  1  internal error
+  2  configuration error (invalid config file, flags or arguments)
+  3  AWS credentials error
+  4  infrastructure missing (run 'lambda-nat-proxy deploy')
+  5  network error (session establishment or timeout)`
+
+// errorClass describes how an exit code is reported on stderr
+type errorClass struct {
+	label string
+	hint  string
+}
+
+// errorCatalog maps exit codes to their stderr label and troubleshooting hint
+var errorCatalog = map[int]errorClass{
+	ExitInternal: {
+		label: "Command failed",
+		hint:  "💡 For help, run: lambda-nat-proxy --help",
+	},
+	ExitConfig: {
+		label: "Configuration error",
+		hint:  "💡 Tip: Run 'lambda-nat-proxy config init' to create a sample configuration file",
+	},
+	ExitCredentials: {
+		label: "AWS credentials error",
+		hint:  "🔧 Troubleshooting:\n- Run 'aws configure' to set up credentials\n- Set AWS_PROFILE environment variable\n- Ensure your AWS credentials have the necessary permissions",
+	},
+	ExitInfraMissing: {
+		label: "Infrastructure error",
+		hint:  "💡 Try: Run 'lambda-nat-proxy deploy' to set up infrastructure",
+	},
+	ExitNetwork: {
+		label: "Network error",
+		hint:  "🔧 Check your internet connection and firewall settings",
+	},
+}
+
+// cliError tags an error with the exit code it should produce
+type cliError struct {
+	code int
+	err  error
+}
+
+func (e *cliError) Error() string { return e.err.Error() }
+func (e *cliError) Unwrap() error { return e.err }
+
+// configError marks err as a configuration error
+func configError(err error) error {
+	return &cliError{code: ExitConfig, err: err}
+}
+
+// credentialsError marks err as an AWS credentials error
+func credentialsError(err error) error {
+	return &cliError{code: ExitCredentials, err: err}
+}
+
+// infraError marks err as missing infrastructure
+func infraError(err error) error {
+	return &cliError{code: ExitInfraMissing, err: err}
+}
+
+// networkError marks err as a network error
+func networkError(err error) error {
+	return &cliError{code: ExitNetwork, err: err}
+}
+
+// credentialErrorCodes are AWS error codes that mean the caller's credentials are unusable
+var credentialErrorCodes = map[string]bool{
+	"ExpiredToken":                true,
+	"ExpiredTokenException":       true,
+	"InvalidClientTokenId":        true,
+	"UnrecognizedClientException": true,
+	"SignatureDoesNotMatch":       true,
+}
+
+// exitCodeFor returns the exit code for an error returned by a command.
+// Errors tagged with a cliError keep their code; untagged AWS credential
+// failures surfacing mid-command are still reported as credential errors.
+func exitCodeFor(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	var ce *cliError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+	if credentialErrorCodes[shared.AWSErrorCode(err)] {
+		return ExitCredentials
+	}
+	return ExitInternal
+}
+
+// reportError writes err to w using the catalog and returns its exit code
+func reportError(w io.Writer, err error) int {
+	code := exitCodeFor(err)
+	class := errorCatalog[code]
+	fmt.Fprintf(w, "❌ %s: %v\n\n%s\n", class.label, err, class.hint)
+	return code
+}
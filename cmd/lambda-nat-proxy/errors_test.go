@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/smithy-go"
+)
+
+func TestExitCodeFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"nil", nil, ExitOK},
+		{"plain", errors.New("boom"), ExitInternal},
+		{"config", configError(errors.New("bad mode")), ExitConfig},
+		{"credentials", credentialsError(errors.New("expired")), ExitCredentials},
+		{"infra", infraError(errors.New("no stack")), ExitInfraMissing},
+		{"network", networkError(errors.New("timeout")), ExitNetwork},
+		{"wrapped", fmt.Errorf("outer: %w", infraError(errors.New("no stack"))), ExitInfraMissing},
+		{"aws credentials", fmt.Errorf("failed to deploy stack: %w", &smithy.GenericAPIError{Code: "ExpiredToken", Message: "token expired"}), ExitCredentials},
+		{"aws other", fmt.Errorf("failed to deploy stack: %w", &smithy.GenericAPIError{Code: "ValidationError", Message: "bad template"}), ExitInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := exitCodeFor(tt.err); code != tt.expected {
+				t.Errorf("Expected exit code %d, got %d", tt.expected, code)
+			}
+		})
+	}
+}
+
+func TestReportError(t *testing.T) {
+	var buf bytes.Buffer
+	code := reportError(&buf, configError(errors.New("configuration validation failed")))
+
+	if code != ExitConfig {
+		t.Errorf("Expected exit code %d, got %d", ExitConfig, code)
+	}
+	if !strings.Contains(buf.String(), "Configuration error") || !strings.Contains(buf.String(), "validation") {
+		t.Errorf("Unexpected error report: %s", buf.String())
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/features"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/manager"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/policy"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/s3"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/socks5"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// reloadDebounce lets an editor finish writing the config file before it is read
+const reloadDebounce = 500 * time.Millisecond
+
+// restartOnlySettings are read once at startup. A reload keeps their
+// current values and says which changed.
+var restartOnlySettings = []struct {
+	field string
+	value func(*config.CLIConfig) interface{} // pointer to the setting
+}{
+	{"aws.region", func(c *config.CLIConfig) interface{} { return &c.AWS.Region }},
+	{"aws.profile", func(c *config.CLIConfig) interface{} { return &c.AWS.Profile }},
+	{"deployment.stack_name", func(c *config.CLIConfig) interface{} { return &c.Deployment.StackName }},
+	{"deployment.mode", func(c *config.CLIConfig) interface{} { return &c.Deployment.Mode }},
+	{"deployment.coordination", func(c *config.CLIConfig) interface{} { return &c.Deployment.Coordination }},
+	{"deployment.session_credentials", func(c *config.CLIConfig) interface{} { return &c.Deployment.SessionCredentials }},
+	{"proxy.port", func(c *config.CLIConfig) interface{} { return &c.Proxy.Port }},
+	{"proxy.listen", func(c *config.CLIConfig) interface{} { return &c.Proxy.Listen }},
+	{"proxy.stun_server", func(c *config.CLIConfig) interface{} { return &c.Proxy.STUNServer }},
+	{"proxy.enable_datagrams", func(c *config.CLIConfig) interface{} { return &c.Proxy.EnableDatagrams }},
+	{"proxy.pipeline_connect", func(c *config.CLIConfig) interface{} { return &c.Proxy.PipelineConnect }},
+	{"proxy.congestion_control", func(c *config.CLIConfig) interface{} { return &c.Proxy.CongestionControl }},
+	{"proxy.initial_window", func(c *config.CLIConfig) interface{} { return &c.Proxy.InitialWindow }},
+	{"proxy.quic_connections", func(c *config.CLIConfig) interface{} { return &c.Proxy.QUICConnections }},
+	{"proxy.session_wait", func(c *config.CLIConfig) interface{} { return &c.Proxy.SessionWait }},
+	{"proxy.invoke_fallback", func(c *config.CLIConfig) interface{} { return &c.Proxy.InvokeFallback }},
+	{"proxy.punch_ports", func(c *config.CLIConfig) interface{} { return &c.Proxy.PunchPorts }},
+	{"proxy.punch_predict_ports", func(c *config.CLIConfig) interface{} { return &c.Proxy.PunchPredictPorts }},
+	{"proxy.keepalive_interval", func(c *config.CLIConfig) interface{} { return &c.Proxy.KeepaliveInterval }},
+	{"proxy.keepalive_min_interval", func(c *config.CLIConfig) interface{} { return &c.Proxy.KeepaliveMinInterval }},
+	{"proxy.max_connections", func(c *config.CLIConfig) interface{} { return &c.Proxy.MaxConnections }},
+	{"proxy.resource_limits", func(c *config.CLIConfig) interface{} { return &c.Proxy.ResourceLimits }},
+	{"proxy.refusal", func(c *config.CLIConfig) interface{} { return &c.Proxy.Refusal }},
+	{"proxy.proxy_protocol", func(c *config.CLIConfig) interface{} { return &c.Proxy.ProxyProtocol }},
+	{"proxy.lambda_dns.pin_ttl", func(c *config.CLIConfig) interface{} { return &c.Proxy.LambdaDNS.PinTTL }},
+	{"proxy.notifications", func(c *config.CLIConfig) interface{} { return &c.Proxy.Notifications }},
+	{"proxy.webhooks", func(c *config.CLIConfig) interface{} { return &c.Proxy.Webhooks }},
+	{"proxy.dns_listen", func(c *config.CLIConfig) interface{} { return &c.Proxy.DNSListen }},
+	{"proxy.audit_log", func(c *config.CLIConfig) interface{} { return &c.Proxy.AuditLog }},
+	{"proxy.anomaly_detection", func(c *config.CLIConfig) interface{} { return &c.Proxy.AnomalyDetection }},
+	{"proxy.budget", func(c *config.CLIConfig) interface{} { return &c.Proxy.Budget }},
+	{"proxy.relay", func(c *config.CLIConfig) interface{} { return &c.Proxy.Relay }},
+	{"tracing", func(c *config.CLIConfig) interface{} { return &c.Tracing }},
+	{"http_proxy", func(c *config.CLIConfig) interface{} { return &c.HTTPProxy }},
+}
+
+// keepRestartOnlySettings copies the restart-only settings of current into
+// next and returns the names of those that differed
+func keepRestartOnlySettings(current, next *config.CLIConfig) []string {
+	var changed []string
+	for _, setting := range restartOnlySettings {
+		old := reflect.ValueOf(setting.value(current)).Elem()
+		updated := reflect.ValueOf(setting.value(next)).Elem()
+		if !reflect.DeepEqual(old.Interface(), updated.Interface()) {
+			changed = append(changed, setting.field)
+			updated.Set(old)
+		}
+	}
+	return changed
+}
+
+// configReloader applies edits to the config file to a running proxy: the
+// rotation timing and session pool, ACL, policy file, resolvers, idle and
+// connect timeouts, stream lifetime, compression, bandwidth caps, log level, privacy mode, feature flags and the settings sent to new Lambdas. Open
+// connections keep the settings they started with.
+type configReloader struct {
+	mu      sync.Mutex
+	cmd     *cobra.Command
+	path    string // config file, "" if the defaults are in use
+	cfg     *config.CLIConfig
+	runtime *config.Config
+	proxy   socks5.Proxy
+	cm      *manager.ConnManager
+	coord   s3.Coordinator
+	egress  map[string]egressRegion // other regions with sessions, for policy egress rules
+}
+
+// newConfigReloader creates a reloader for the proxy started from cfg
+func newConfigReloader(cmd *cobra.Command, cfg *config.CLIConfig, runtimeCfg *config.Config, proxy socks5.Proxy, cm *manager.ConnManager, coord s3.Coordinator, egress map[string]egressRegion) *configReloader {
+	path, _ := cmd.Flags().GetString("config")
+	if path == "" {
+		path, _ = config.FindConfigFile()
+	}
+	if path != "" {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+	}
+	return &configReloader{
+		cmd:     cmd,
+		path:    path,
+		cfg:     cfg,
+		runtime: runtimeCfg,
+		proxy:   proxy,
+		cm:      cm,
+		coord:   coord,
+		egress:  egress,
+	}
+}
+
+// current returns the configuration in effect
+func (r *configReloader) current() (*config.CLIConfig, *config.Config) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cfg, r.runtime
+}
+
+// Reload re-reads the config file and the policy file it names and applies
+// them. Nothing changes if either is invalid.
+func (r *configReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, _, err := loadConfigWithProvenance(r.cmd, r.path)
+	if err != nil {
+		return err
+	}
+	applyRunFlags(r.cmd, cfg)
+	restartOnly := keepRestartOnlySettings(r.cfg, cfg)
+	if errors := config.ValidateCLIConfig(cfg); len(errors) > 0 {
+		messages := make([]string, len(errors))
+		for i, err := range errors {
+			messages[i] = err.Error()
+		}
+		return fmt.Errorf("configuration validation failed: %s", strings.Join(messages, "; "))
+	}
+
+	runtimeCfg := cfg.ToConfig(r.runtime.S3BucketName)
+	runtimeCfg.SessionAlias = r.runtime.SessionAlias // found at startup for the mode, which needs a restart
+	proxyPolicy, err := loadPolicy(runtimeCfg)
+	if err != nil {
+		return err
+	}
+	for _, region := range proxyPolicy.EgressRegions() {
+		if _, ok := r.egress[region]; !ok && region != r.runtime.AWSRegion {
+			return fmt.Errorf("policy rules send traffic through %s, where no sessions are kept; restart the proxy to start them", region)
+		}
+	}
+	acl, err := shared.NewACL(runtimeCfg.ACL)
+	if err != nil {
+		return fmt.Errorf("failed to parse ACL: %w", err)
+	}
+
+	r.proxy.Reconfigure(socks5.Settings{
+		Bandwidth:         runtimeCfg.Bandwidth,
+		IdleTimeout:       runtimeCfg.TunnelIdleTimeout,
+		ConnectTimeout:    runtimeCfg.TunnelConnectTimeout,
+		MaxStreamLifetime: runtimeCfg.TunnelMaxLifetime,
+		Compression:       runtimeCfg.TunnelCompression,
+		ACL:               acl,
+		Resolvers:         runtimeCfg.Resolvers,
+		Policy:            proxyPolicy,
+	})
+	r.cm.SetRotation(runtimeCfg.Rotation)
+	r.coord.SetSettings(runtimeCfg.SessionSettings())
+	for _, egress := range r.egress {
+		egress.coord.SetSettings(runtimeCfg.SessionSettings())
+	}
+	shared.SetLogLevel(runtimeCfg.LogLevel)
+	shared.SetPrivacyMode(runtimeCfg.PrivacyMode)
+	features.Apply(runtimeCfg.Features, "a config reload")
+	r.cfg, r.runtime = cfg, runtimeCfg
+
+	source := r.path
+	if source == "" {
+		source = "defaults"
+	}
+	log.Printf("🔄 Reloaded configuration from %s", source)
+	for _, field := range restartOnly {
+		log.Printf("⚠️  %s changed; restart the proxy to apply it", field)
+	}
+	return nil
+}
+
+// reloadAndLog reloads, logging rather than returning a failure
+func (r *configReloader) reloadAndLog() {
+	if err := r.Reload(); err != nil {
+		log.Printf("❌ Configuration reload failed, the current settings stay in effect: %v", err)
+	}
+}
+
+// Watch reloads on SIGHUP and whenever the config file changes, until ctx is done
+func (r *configReloader) Watch(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	// Watch the directory rather than the file, since editors usually save
+	// by replacing the file
+	var events <-chan fsnotify.Event
+	var watchErrors <-chan error
+	if r.path != "" {
+		watcher, err := fsnotify.NewWatcher()
+		if err == nil {
+			if err = watcher.Add(filepath.Dir(r.path)); err != nil {
+				watcher.Close()
+			}
+		}
+		if err != nil {
+			log.Printf("⚠️  Not watching %s for changes (reload with SIGHUP or 'lambda-nat-proxy config reload'): %v", r.path, err)
+		} else {
+			defer watcher.Close()
+			events = watcher.Events
+			watchErrors = watcher.Errors
+			log.Printf("Watching %s for changes", r.path)
+		}
+	}
+
+	debounce := time.NewTimer(reloadDebounce)
+	debounce.Stop()
+	defer debounce.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			log.Printf("SIGHUP received, reloading configuration")
+			r.reloadAndLog()
+		case event := <-events:
+			if event.Name == r.path && event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+				debounce.Reset(reloadDebounce)
+			}
+		case err := <-watchErrors:
+			log.Printf("⚠️  Config file watch error: %v", err)
+		case <-debounce.C:
+			r.reloadAndLog()
+		}
+	}
+}
+
+// loadPolicy loads the policy file named by cfg, if any. The policy replaces
+// the ACL and bandwidth limits in cfg, and the Lambda enforces its allow and
+// deny rules too.
+func loadPolicy(cfg *config.Config) (*policy.Policy, error) {
+	if cfg.PolicyFile == "" {
+		return nil, nil
+	}
+	proxyPolicy, err := policy.Load(cfg.PolicyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ACL = proxyPolicy.LambdaACL()
+	cfg.Bandwidth = proxyPolicy.Bandwidth()
+	return proxyPolicy, nil
+}
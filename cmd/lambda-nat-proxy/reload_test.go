@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/manager"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/s3"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/socks5"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+func TestConfigReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lambda-nat-proxy.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("deployment:\n  stack_name: lambda-nat-proxy-a1b2c3d4\nproxy:\n  port: 1080\n")
+	cfg, err := config.LoadCLIConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtimeCfg := cfg.ToConfig("bucket")
+	cm := manager.New(runtimeCfg, nil)
+	reloader := &configReloader{
+		cmd:     runCmd,
+		path:    path,
+		cfg:     cfg,
+		runtime: runtimeCfg,
+		proxy:   socks5.New(),
+		cm:      cm,
+		coord:   s3.NewWithSettings(nil, "bucket", runtimeCfg.SessionSettings()),
+	}
+	defer shared.SetLogLevel(shared.LevelInfo)
+
+	write("deployment:\n  stack_name: lambda-nat-proxy-a1b2c3d4\nproxy:\n  port: 9999\n  log_level: debug\n" +
+		"  rate_limit:\n    global: 1MB\n  session_pool:\n    secondaries: 2\n")
+	if err := reloader.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	newCfg, newRuntime := reloader.current()
+	if newRuntime.Bandwidth.Global != 1<<20 || newRuntime.LogLevel != shared.LevelDebug || newRuntime.Rotation.Secondaries != 2 {
+		t.Errorf("Expected reloadable settings applied, got bandwidth %+v, log level %v, secondaries %d",
+			newRuntime.Bandwidth, newRuntime.LogLevel, newRuntime.Rotation.Secondaries)
+	}
+	if newCfg.Proxy.Port != 1080 || newRuntime.SOCKS5Port != 1080 {
+		t.Errorf("Expected the port to need a restart, got %d", newRuntime.SOCKS5Port)
+	}
+
+	write("proxy:\n  log_level: loud\n")
+	if err := reloader.Reload(); err == nil || !strings.Contains(err.Error(), "log level") {
+		t.Errorf("Expected the invalid log level rejected, got %v", err)
+	}
+	if _, current := reloader.current(); current != newRuntime {
+		t.Error("Expected a failed reload to keep the current configuration")
+	}
+}
+
+func TestKeepRestartOnlySettings(t *testing.T) {
+	current := config.DefaultCLIConfig()
+	next := *current
+	next.Proxy.Port = 9999
+	next.Proxy.DNSListen = "127.0.0.1:5300"
+	next.Proxy.IdleTimeout = 42
+
+	changed := keepRestartOnlySettings(current, &next)
+	if strings.Join(changed, ",") != "proxy.port,proxy.dns_listen" {
+		t.Errorf("Unexpected restart-only changes: %v", changed)
+	}
+	if next.Proxy.Port != current.Proxy.Port || next.Proxy.DNSListen != "" || next.Proxy.IdleTimeout != 42 {
+		t.Errorf("Expected restart-only settings kept and others changed, got %+v", next.Proxy)
+	}
+}
@@ -0,0 +1,387 @@
+// Package anomaly watches session health history for degradations that come
+// before a session is marked unhealthy: RTT that stays well above its usual
+// level, pings being lost intermittently, and throughput collapsing while
+// connections are open.
+package anomaly
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/metrics"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// Anomaly kinds
+const (
+	KindRTTSpike           = "rtt_spike"           // RTT above RTTFactor x baseline
+	KindPingLoss           = "ping_loss"           // too many of the recent pings went unanswered
+	KindThroughputCollapse = "throughput_collapse" // byte rate fell below ThroughputDrop x baseline
+)
+
+// Detector tuning defaults
+const (
+	DefaultRTTFactor      = 2.0
+	DefaultSustain        = 3
+	DefaultLossThreshold  = 0.3
+	DefaultThroughputDrop = 0.1
+	DefaultMinThroughput  = 64 * 1024 // bytes per second
+
+	// warmupSamples are averaged into a baseline before anything is flagged
+	warmupSamples = 5
+	// baselineWeight is how much each normal sample moves the baseline
+	baselineWeight = 0.1
+	// throughputInterval is how often Run samples the byte rate
+	throughputInterval = 5 * time.Second
+	// lossWindow is how many recent pings the loss rate covers
+	lossWindow = 10
+	// maxEvents bounds how many anomalies are kept for the dashboard
+	maxEvents = 100
+	// alertTimeout bounds each run of the alert command
+	alertTimeout = 30 * time.Second
+)
+
+// Options tunes the detector; zero fields use the defaults above
+type Options struct {
+	RTTFactor      float64 // flag RTT above this multiple of the session's baseline
+	Sustain        int     // consecutive samples past a threshold before flagging
+	LossThreshold  float64 // flag when this fraction of the last 10 pings were lost
+	ThroughputDrop float64 // flag byte rates below this fraction of the baseline
+	MinThroughput  float64 // ignore throughput while the baseline is below this (bytes/s)
+
+	// AlertCommand is run through the shell when an anomaly starts or ends,
+	// with LNP_ANOMALY_* variables describing it (empty = no alerts)
+	AlertCommand string
+}
+
+// Event is one anomaly, from when it was flagged until it resolved
+type Event struct {
+	Kind       string     `json:"kind"`
+	SessionID  string     `json:"session_id,omitempty"` // empty for throughput, which is proxy-wide
+	At         time.Time  `json:"at"`
+	Value      float64    `json:"value"`    // the sample that confirmed the anomaly
+	Baseline   float64    `json:"baseline"` // what the value is normally
+	Detail     string     `json:"detail"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// baseline is a moving average learned from normal samples
+type baseline struct {
+	value   float64
+	samples int
+	over    int    // consecutive samples past the threshold
+	active  *Event // open anomaly, if any
+}
+
+// ready reports whether enough samples have been seen to judge new ones
+func (b *baseline) ready() bool {
+	return b.samples >= warmupSamples
+}
+
+// learn folds a normal sample into the baseline
+func (b *baseline) learn(v float64) {
+	b.samples++
+	if b.samples <= warmupSamples {
+		b.value += (v - b.value) / float64(b.samples)
+		return
+	}
+	b.value += (v - b.value) * baselineWeight
+}
+
+// sessionHistory is the health history of one session
+type sessionHistory struct {
+	rtt      baseline
+	pings    [lossWindow]bool // true = lost, ring buffer
+	pingNext int
+	pingSeen int
+	loss     *Event
+}
+
+// Detector flags anomalies in RTT, ping loss and throughput history. Its
+// methods are safe on a nil *Detector, so callers need not check whether
+// detection is enabled.
+type Detector struct {
+	opts Options
+
+	mu         sync.Mutex
+	sessions   map[string]*sessionHistory
+	throughput baseline
+	events     []*Event // oldest first
+}
+
+// New creates a detector
+func New(opts Options) *Detector {
+	if opts.RTTFactor <= 0 {
+		opts.RTTFactor = DefaultRTTFactor
+	}
+	if opts.Sustain <= 0 {
+		opts.Sustain = DefaultSustain
+	}
+	if opts.LossThreshold <= 0 {
+		opts.LossThreshold = DefaultLossThreshold
+	}
+	if opts.ThroughputDrop <= 0 {
+		opts.ThroughputDrop = DefaultThroughputDrop
+	}
+	if opts.MinThroughput <= 0 {
+		opts.MinThroughput = DefaultMinThroughput
+	}
+	return &Detector{
+		opts:     opts,
+		sessions: make(map[string]*sessionHistory),
+	}
+}
+
+// session returns the history of sessionID, creating it if needed. The
+// caller holds d.mu.
+func (d *Detector) session(sessionID string) *sessionHistory {
+	history, ok := d.sessions[sessionID]
+	if !ok {
+		history = &sessionHistory{}
+		d.sessions[sessionID] = history
+	}
+	return history
+}
+
+// ObserveRTT records a ping answered after rtt
+func (d *Detector) ObserveRTT(sessionID string, rtt time.Duration) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	history := d.session(sessionID)
+	d.recordPing(sessionID, history, false)
+
+	ms := float64(rtt) / float64(time.Millisecond)
+	b := &history.rtt
+	if !b.ready() || ms <= b.value*d.opts.RTTFactor {
+		b.over = 0
+		if b.active != nil {
+			d.resolve(b.active, fmt.Sprintf("RTT back to %.0fms", ms))
+			b.active = nil
+		}
+		b.learn(ms)
+		return
+	}
+
+	b.over++
+	if b.over >= d.opts.Sustain && b.active == nil {
+		b.active = d.flag(&Event{
+			Kind:      KindRTTSpike,
+			SessionID: sessionID,
+			Value:     ms,
+			Baseline:  b.value,
+			Detail:    fmt.Sprintf("RTT %.0fms for %d pings, usually %.0fms", ms, b.over, b.value),
+		})
+	}
+}
+
+// ObserveMissedPing records a ping that went unanswered
+func (d *Detector) ObserveMissedPing(sessionID string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.recordPing(sessionID, d.session(sessionID), true)
+}
+
+// recordPing adds a ping result to the loss window and flags or resolves
+// loss. The caller holds d.mu.
+func (d *Detector) recordPing(sessionID string, history *sessionHistory, lost bool) {
+	history.pings[history.pingNext] = lost
+	history.pingNext = (history.pingNext + 1) % lossWindow
+	if history.pingSeen < lossWindow {
+		history.pingSeen++
+	}
+
+	var missed int
+	for i := 0; i < history.pingSeen; i++ {
+		if history.pings[i] {
+			missed++
+		}
+	}
+	rate := float64(missed) / float64(lossWindow)
+
+	switch {
+	case history.pingSeen == lossWindow && rate >= d.opts.LossThreshold && history.loss == nil:
+		history.loss = d.flag(&Event{
+			Kind:      KindPingLoss,
+			SessionID: sessionID,
+			Value:     rate,
+			Baseline:  d.opts.LossThreshold,
+			Detail:    fmt.Sprintf("%d of the last %d pings lost", missed, lossWindow),
+		})
+	case rate < d.opts.LossThreshold && history.loss != nil:
+		d.resolve(history.loss, fmt.Sprintf("%d of the last %d pings lost", missed, lossWindow))
+		history.loss = nil
+	}
+}
+
+// ObserveThroughput records the proxy-wide byte rate while active
+// connections were open. Samples with no open connections are ignored,
+// since an idle proxy is not a collapse.
+func (d *Detector) ObserveThroughput(bytesPerSecond float64, active int) {
+	if d == nil || active <= 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	b := &d.throughput
+	collapsed := b.ready() && b.value >= d.opts.MinThroughput && bytesPerSecond < b.value*d.opts.ThroughputDrop
+	if !collapsed {
+		b.over = 0
+		if b.active != nil {
+			d.resolve(b.active, "throughput back to "+formatRate(bytesPerSecond))
+			b.active = nil
+		}
+		b.learn(bytesPerSecond)
+		return
+	}
+
+	b.over++
+	if b.over >= d.opts.Sustain && b.active == nil {
+		b.active = d.flag(&Event{
+			Kind:     KindThroughputCollapse,
+			Value:    bytesPerSecond,
+			Baseline: b.value,
+			Detail: fmt.Sprintf("throughput %s across %d connections, usually %s",
+				formatRate(bytesPerSecond), active, formatRate(b.value)),
+		})
+	}
+}
+
+// Forget drops the history of a session that has closed, resolving any of
+// its open anomalies
+func (d *Detector) Forget(sessionID string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	history, ok := d.sessions[sessionID]
+	if !ok {
+		return
+	}
+	for _, event := range []*Event{history.rtt.active, history.loss} {
+		if event != nil {
+			d.resolve(event, "session closed")
+		}
+	}
+	delete(d.sessions, sessionID)
+}
+
+// Events returns copies of the kept anomalies, newest first
+func (d *Detector) Events() []Event {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	events := make([]Event, 0, len(d.events))
+	for i := len(d.events) - 1; i >= 0; i-- {
+		events = append(events, *d.events[i])
+	}
+	return events
+}
+
+// Run samples the proxy's byte rate until ctx is done
+func (d *Detector) Run(ctx context.Context) {
+	if d == nil {
+		return
+	}
+	ticker := time.NewTicker(throughputInterval)
+	defer ticker.Stop()
+
+	lastBytes := metrics.GetSOCKS5BytesTransferred()
+	lastTime := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			bytes := metrics.GetSOCKS5BytesTransferred()
+			if elapsed := now.Sub(lastTime).Seconds(); elapsed > 0 && bytes >= lastBytes {
+				d.ObserveThroughput(float64(bytes-lastBytes)/elapsed, int(metrics.GetActiveSOCKS5Connections()))
+			}
+			lastBytes, lastTime = bytes, now
+		}
+	}
+}
+
+// flag records a new anomaly. The caller holds d.mu.
+func (d *Detector) flag(event *Event) *Event {
+	event.At = time.Now()
+	d.events = append(d.events, event)
+	if len(d.events) > maxEvents {
+		d.events = d.events[len(d.events)-maxEvents:]
+	}
+	metrics.RecordAnomaly(true)
+
+	if event.SessionID != "" {
+		shared.LogErrorf("Anomaly on session %s: %s", event.SessionID, event.Detail)
+	} else {
+		shared.LogErrorf("Anomaly: %s", event.Detail)
+	}
+	d.alert(*event, "started")
+	return event
+}
+
+// resolve closes an anomaly. The caller holds d.mu.
+func (d *Detector) resolve(event *Event, detail string) {
+	now := time.Now()
+	event.ResolvedAt = &now
+	metrics.RecordAnomaly(false)
+
+	shared.LogInfof("Anomaly %s resolved after %v: %s", event.Kind, now.Sub(event.At).Round(time.Second), detail)
+	d.alert(*event, "resolved")
+}
+
+// alert runs the alert command in the background, if one is configured
+func (d *Detector) alert(event Event, state string) {
+	if d.opts.AlertCommand == "" {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), alertTimeout)
+		defer cancel()
+
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctx, "cmd", "/C", d.opts.AlertCommand)
+		} else {
+			cmd = exec.CommandContext(ctx, "sh", "-c", d.opts.AlertCommand)
+		}
+		cmd.Env = append(os.Environ(),
+			"LNP_ANOMALY_STATE="+state,
+			"LNP_ANOMALY_KIND="+event.Kind,
+			"LNP_ANOMALY_SESSION="+event.SessionID,
+			"LNP_ANOMALY_DETAIL="+event.Detail,
+			fmt.Sprintf("LNP_ANOMALY_VALUE=%g", event.Value),
+			fmt.Sprintf("LNP_ANOMALY_BASELINE=%g", event.Baseline),
+		)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			shared.LogErrorf("Anomaly alert command failed: %v: %s", err, output)
+		}
+	}()
+}
+
+// formatRate formats a byte rate for logs and alerts
+func formatRate(bytesPerSecond float64) string {
+	if bytesPerSecond >= 1024*1024 {
+		return fmt.Sprintf("%.1fMB/s", bytesPerSecond/(1024*1024))
+	}
+	return fmt.Sprintf("%.1fKB/s", bytesPerSecond/1024)
+}
@@ -0,0 +1,180 @@
+package anomaly
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRTTSpike(t *testing.T) {
+	d := New(Options{})
+	for i := 0; i < 10; i++ {
+		d.ObserveRTT("s1", 20*time.Millisecond)
+	}
+
+	// A single slow ping isn't an anomaly
+	d.ObserveRTT("s1", 100*time.Millisecond)
+	d.ObserveRTT("s1", 20*time.Millisecond)
+	if events := d.Events(); len(events) != 0 {
+		t.Fatalf("Expected no anomaly for one slow ping, got %+v", events)
+	}
+
+	for i := 0; i < DefaultSustain; i++ {
+		d.ObserveRTT("s1", 60*time.Millisecond)
+	}
+	events := d.Events()
+	if len(events) != 1 || events[0].Kind != KindRTTSpike || events[0].SessionID != "s1" {
+		t.Fatalf("Expected one RTT spike on s1, got %+v", events)
+	}
+	if events[0].Baseline < 19 || events[0].Baseline > 21 {
+		t.Errorf("Expected baseline near 20ms, got %v", events[0].Baseline)
+	}
+	if events[0].ResolvedAt != nil {
+		t.Error("Expected spike to be open")
+	}
+
+	// Staying slow doesn't flag it again, and recovering resolves it
+	d.ObserveRTT("s1", 60*time.Millisecond)
+	d.ObserveRTT("s1", 20*time.Millisecond)
+	events = d.Events()
+	if len(events) != 1 || events[0].ResolvedAt == nil {
+		t.Fatalf("Expected the spike to be resolved, got %+v", events)
+	}
+}
+
+func TestRTTWarmup(t *testing.T) {
+	d := New(Options{})
+	// Early samples only build the baseline
+	d.ObserveRTT("s1", 10*time.Millisecond)
+	for i := 0; i < warmupSamples; i++ {
+		d.ObserveRTT("s1", 100*time.Millisecond)
+	}
+	if events := d.Events(); len(events) != 0 {
+		t.Errorf("Expected no anomaly during warmup, got %+v", events)
+	}
+}
+
+func TestPingLoss(t *testing.T) {
+	d := New(Options{})
+	for i := 0; i < 7; i++ {
+		d.ObserveRTT("s1", 20*time.Millisecond)
+	}
+	d.ObserveMissedPing("s1")
+	d.ObserveRTT("s1", 20*time.Millisecond)
+	d.ObserveMissedPing("s1")
+	if events := d.Events(); len(events) != 0 {
+		t.Fatalf("Expected no anomaly for 2 of 10 pings lost, got %+v", events)
+	}
+
+	d.ObserveMissedPing("s1")
+	events := d.Events()
+	if len(events) != 1 || events[0].Kind != KindPingLoss {
+		t.Fatalf("Expected ping loss, got %+v", events)
+	}
+
+	// The losses age out of the window
+	for i := 0; i < lossWindow; i++ {
+		d.ObserveRTT("s1", 20*time.Millisecond)
+	}
+	if events := d.Events(); events[0].ResolvedAt == nil {
+		t.Error("Expected ping loss to be resolved")
+	}
+}
+
+func TestThroughputCollapse(t *testing.T) {
+	d := New(Options{})
+	for i := 0; i < 10; i++ {
+		d.ObserveThroughput(1<<20, 4)
+	}
+
+	// Idle periods with no connections are ignored
+	for i := 0; i < 10; i++ {
+		d.ObserveThroughput(0, 0)
+	}
+	if events := d.Events(); len(events) != 0 {
+		t.Fatalf("Expected no anomaly while idle, got %+v", events)
+	}
+
+	for i := 0; i < DefaultSustain; i++ {
+		d.ObserveThroughput(1024, 4)
+	}
+	events := d.Events()
+	if len(events) != 1 || events[0].Kind != KindThroughputCollapse || events[0].SessionID != "" {
+		t.Fatalf("Expected a throughput collapse, got %+v", events)
+	}
+
+	d.ObserveThroughput(1<<20, 4)
+	if events := d.Events(); events[0].ResolvedAt == nil {
+		t.Error("Expected throughput collapse to be resolved")
+	}
+}
+
+func TestThroughputBelowMinimum(t *testing.T) {
+	d := New(Options{})
+	for i := 0; i < 10; i++ {
+		d.ObserveThroughput(8*1024, 1)
+	}
+	for i := 0; i < 10; i++ {
+		d.ObserveThroughput(0, 1)
+	}
+	if events := d.Events(); len(events) != 0 {
+		t.Errorf("Expected light traffic to be ignored, got %+v", events)
+	}
+}
+
+func TestForgetResolves(t *testing.T) {
+	d := New(Options{Sustain: 1})
+	for i := 0; i < warmupSamples; i++ {
+		d.ObserveRTT("s1", 20*time.Millisecond)
+	}
+	d.ObserveRTT("s1", 200*time.Millisecond)
+	d.Forget("s1")
+
+	events := d.Events()
+	if len(events) != 1 || events[0].ResolvedAt == nil {
+		t.Fatalf("Expected the spike to be resolved when the session closed, got %+v", events)
+	}
+	if len(d.sessions) != 0 {
+		t.Error("Expected session history to be dropped")
+	}
+}
+
+func TestNilDetector(t *testing.T) {
+	var d *Detector
+	d.ObserveRTT("s1", time.Second)
+	d.ObserveMissedPing("s1")
+	d.ObserveThroughput(1, 1)
+	d.Forget("s1")
+	d.Run(context.Background())
+	if events := d.Events(); events != nil {
+		t.Errorf("Expected no events, got %+v", events)
+	}
+}
+
+func TestAlertCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("alert command test uses sh")
+	}
+	out := filepath.Join(t.TempDir(), "alert")
+	d := New(Options{Sustain: 1, AlertCommand: `echo "$LNP_ANOMALY_STATE $LNP_ANOMALY_KIND $LNP_ANOMALY_SESSION" >> ` + out})
+	for i := 0; i < warmupSamples; i++ {
+		d.ObserveRTT("s1", 20*time.Millisecond)
+	}
+	d.ObserveRTT("s1", 200*time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := os.ReadFile(out)
+		if strings.TrimSpace(string(data)) == "started rtt_spike s1" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected alert output, got %q", data)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
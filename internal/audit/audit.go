@@ -0,0 +1,234 @@
+// Package audit writes one JSON line per proxied connection, for usage
+// accounting, and one per session lifecycle event, rotating the file by size
+// and age.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/events"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// Close reasons recorded in Entry.CloseReason
+const (
+	ReasonClosed      = "closed"       // either side ended the connection
+	ReasonIdle        = "idle_timeout" // no traffic for the idle timeout
+	ReasonShed        = "shed"         // closed while over a resource limit
+	ReasonShutdown    = "shutdown"     // the proxy is stopping
+	ReasonDenied      = "denied"       // refused by the ACL
+	ReasonFailed      = "failed"       // the destination could not be reached
+	ReasonQuota       = "quota"        // the client used up its policy quota
+	ReasonLifetime    = "max_lifetime" // open for proxy.max_stream_lifetime
+	ReasonClientReset = "client_reset" // the client reset its connection or timed out
+)
+
+// rotatedTimeFormat is inserted before the extension of rotated files
+const rotatedTimeFormat = "20060102T150405.000"
+
+// Entry describes one proxied connection
+type Entry struct {
+	Time        time.Time `json:"time"` // when the connection closed
+	Client      string    `json:"client"`
+	Destination string    `json:"destination"`
+	Route       string    `json:"route,omitempty"` // "tunnel" or "direct"
+	SessionID   string    `json:"session_id,omitempty"`
+	BytesIn     int64     `json:"bytes_in"`  // destination to client
+	BytesOut    int64     `json:"bytes_out"` // client to destination
+	DurationMs  int64     `json:"duration_ms"`
+	CloseReason string    `json:"close_reason"`
+}
+
+// SessionEntry describes a session lifecycle event, so the sessions named by
+// connection entries can be traced from launch to close
+type SessionEntry struct {
+	Time      time.Time `json:"time"`
+	Event     string    `json:"event"` // e.g. "session.launched"
+	SessionID string    `json:"session_id,omitempty"`
+	Role      string    `json:"role,omitempty"`
+	Message   string    `json:"message,omitempty"`
+}
+
+// Options controls rotation. The current file is renamed with a timestamp
+// once it would grow past MaxSize bytes or has been open for MaxAge, and only
+// the newest MaxBackups rotated files are kept. Zero disables each.
+type Options struct {
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+}
+
+// Logger appends entries to a JSONL file. A nil *Logger discards entries.
+type Logger struct {
+	path string
+	opts Options
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	opened  time.Time
+	failing bool // a write failed and has been logged
+}
+
+// New opens path for appending, creating it if needed
+func New(path string, opts Options) (*Logger, error) {
+	l := &Logger{path: path, opts: opts}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Logger) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat audit log: %w", err)
+	}
+	l.file = file
+	l.size = info.Size()
+	l.opened = time.Now()
+	return nil
+}
+
+// Log appends entry, rotating first if needed. Write errors are logged once
+// until a later write succeeds.
+func (l *Logger) Log(entry Entry) {
+	if l == nil {
+		return
+	}
+	l.append(entry)
+}
+
+// LogSession appends a session lifecycle entry like Log
+func (l *Logger) LogSession(entry SessionEntry) {
+	if l == nil {
+		return
+	}
+	l.append(entry)
+}
+
+// Follow records the session lifecycle events published to bus from now on,
+// until ctx is done
+func (l *Logger) Follow(ctx context.Context, bus *events.Bus) {
+	if l == nil {
+		return
+	}
+	bus.Follow(ctx, func(e events.Event) {
+		if !strings.HasPrefix(e.Type, "session.") {
+			return
+		}
+		role, _ := e.Fields["role"].(string)
+		l.LogSession(SessionEntry{
+			Time:      e.Time,
+			Event:     e.Type,
+			SessionID: e.Session,
+			Role:      role,
+			Message:   e.Message,
+		})
+	})
+}
+
+func (l *Logger) append(entry interface{}) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.write(line); err != nil {
+		if !l.failing {
+			shared.LogErrorf("Audit log write failed, entries are being dropped: %v", err)
+			l.failing = true
+		}
+		return
+	}
+	l.failing = false
+}
+
+func (l *Logger) write(line []byte) error {
+	if l.shouldRotate(int64(len(line))) {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	if l.file == nil {
+		if err := l.open(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+func (l *Logger) shouldRotate(next int64) bool {
+	if l.size == 0 {
+		return false
+	}
+	if l.opts.MaxSize > 0 && l.size+next > l.opts.MaxSize {
+		return true
+	}
+	return l.opts.MaxAge > 0 && time.Since(l.opened) >= l.opts.MaxAge
+}
+
+// rotate renames the current file aside and opens a new one
+func (l *Logger) rotate() error {
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	ext := filepath.Ext(l.path)
+	rotated := strings.TrimSuffix(l.path, ext) + "-" + time.Now().Format(rotatedTimeFormat) + ext
+	if err := os.Rename(l.path, rotated); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+	l.prune()
+	return l.open()
+}
+
+// prune removes all but the newest MaxBackups rotated files
+func (l *Logger) prune() {
+	if l.opts.MaxBackups <= 0 {
+		return
+	}
+	ext := filepath.Ext(l.path)
+	matches, err := filepath.Glob(strings.TrimSuffix(l.path, ext) + "-*" + ext)
+	if err != nil || len(matches) <= l.opts.MaxBackups {
+		return
+	}
+	// Timestamps sort lexically, oldest first
+	sort.Strings(matches)
+	for _, old := range matches[:len(matches)-l.opts.MaxBackups] {
+		os.Remove(old)
+	}
+}
+
+// Close closes the current file
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
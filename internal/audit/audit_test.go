@@ -0,0 +1,146 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/events"
+)
+
+func readEntries(t *testing.T, path string) []Entry {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Invalid JSON line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestLoggerWritesJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	logger, err := New(path, Options{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	logger.Log(Entry{Destination: "example.com:443", BytesIn: 100, BytesOut: 20, CloseReason: ReasonClosed})
+	logger.Log(Entry{Destination: "10.0.0.1:22", CloseReason: ReasonDenied})
+	logger.Close()
+
+	entries := readEntries(t, path)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Destination != "example.com:443" || entries[0].BytesIn != 100 || entries[1].CloseReason != ReasonDenied {
+		t.Errorf("Unexpected entries: %+v", entries)
+	}
+}
+
+func TestLoggerRotatesBySizeAndPrunes(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.jsonl")
+	logger, err := New(path, Options{MaxSize: 200, MaxBackups: 2})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer logger.Close()
+
+	for i := 0; i < 10; i++ {
+		logger.Log(Entry{Destination: "example.com:443", CloseReason: ReasonClosed})
+		time.Sleep(2 * time.Millisecond) // distinct rotation timestamps
+	}
+
+	rotated, _ := filepath.Glob(filepath.Join(dir, "audit-*.jsonl"))
+	if len(rotated) != 2 {
+		t.Errorf("Expected 2 rotated files kept, got %v", rotated)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() > 200 {
+		t.Errorf("Expected current file under 200 bytes, got %v (%v)", info.Size(), err)
+	}
+}
+
+func TestLoggerRotatesByAge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.jsonl")
+	logger, err := New(path, Options{MaxAge: time.Hour})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer logger.Close()
+
+	logger.Log(Entry{CloseReason: ReasonClosed})
+	logger.opened = time.Now().Add(-2 * time.Hour)
+	logger.Log(Entry{CloseReason: ReasonClosed})
+
+	rotated, _ := filepath.Glob(filepath.Join(dir, "audit-*.jsonl"))
+	if len(rotated) != 1 || len(readEntries(t, path)) != 1 {
+		t.Errorf("Expected one rotation after max age, got %v", rotated)
+	}
+}
+
+func TestLoggerFollowsSessionEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	logger, err := New(path, Options{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	bus := events.NewBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	logger.Follow(ctx, bus)
+	bus.Publish(events.Event{Type: events.BudgetWarning, Message: "not a session event"})
+	bus.Publish(events.Event{Type: events.SessionLaunched, Session: "a", Fields: map[string]interface{}{"role": "primary"}})
+
+	// The events are written from another goroutine
+	deadline := time.Now().Add(time.Second)
+	for {
+		if data, _ := os.ReadFile(path); len(data) > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	logger.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	line, _, _ := strings.Cut(string(data), "\n")
+	var entry SessionEntry
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		t.Fatalf("Invalid JSON line %q: %v", line, err)
+	}
+	if entry.Event != events.SessionLaunched || entry.SessionID != "a" || entry.Role != "primary" || entry.Time.IsZero() {
+		t.Errorf("Unexpected session entry: %+v", entry)
+	}
+	if strings.Contains(string(data), "not a session event") {
+		t.Errorf("Expected only session events, got %s", data)
+	}
+}
+
+func TestNilLogger(t *testing.T) {
+	var logger *Logger
+	logger.Log(Entry{})
+	logger.LogSession(SessionEntry{})
+	logger.Follow(context.Background(), events.NewBus())
+	if err := logger.Close(); err != nil {
+		t.Errorf("Expected nil logger Close to succeed, got %v", err)
+	}
+}
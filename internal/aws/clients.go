@@ -2,72 +2,103 @@ package aws
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/client"
-	"github.com/aws/aws-sdk-go/aws/request"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/cloudformation"
-	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
-	"github.com/aws/aws-sdk-go/service/lambda"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
 	
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
 )
 
 // CloudFormationAPI defines the interface for CloudFormation operations
 type CloudFormationAPI interface {
-	CreateStackWithContext(ctx context.Context, input *cloudformation.CreateStackInput, opts ...request.Option) (*cloudformation.CreateStackOutput, error)
-	UpdateStackWithContext(ctx context.Context, input *cloudformation.UpdateStackInput, opts ...request.Option) (*cloudformation.UpdateStackOutput, error)
-	DeleteStackWithContext(ctx context.Context, input *cloudformation.DeleteStackInput, opts ...request.Option) (*cloudformation.DeleteStackOutput, error)
-	DescribeStacksWithContext(ctx context.Context, input *cloudformation.DescribeStacksInput, opts ...request.Option) (*cloudformation.DescribeStacksOutput, error)
+	CreateStack(ctx context.Context, input *cloudformation.CreateStackInput, optFns ...func(*cloudformation.Options)) (*cloudformation.CreateStackOutput, error)
+	UpdateStack(ctx context.Context, input *cloudformation.UpdateStackInput, optFns ...func(*cloudformation.Options)) (*cloudformation.UpdateStackOutput, error)
+	DeleteStack(ctx context.Context, input *cloudformation.DeleteStackInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DeleteStackOutput, error)
+	DescribeStacks(ctx context.Context, input *cloudformation.DescribeStacksInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DescribeStacksOutput, error)
+	ValidateTemplate(ctx context.Context, input *cloudformation.ValidateTemplateInput, optFns ...func(*cloudformation.Options)) (*cloudformation.ValidateTemplateOutput, error)
+	CreateChangeSet(ctx context.Context, input *cloudformation.CreateChangeSetInput, optFns ...func(*cloudformation.Options)) (*cloudformation.CreateChangeSetOutput, error)
+	DescribeChangeSet(ctx context.Context, input *cloudformation.DescribeChangeSetInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DescribeChangeSetOutput, error)
+	DeleteChangeSet(ctx context.Context, input *cloudformation.DeleteChangeSetInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DeleteChangeSetOutput, error)
 }
 
 // CloudWatchLogsAPI defines the interface for CloudWatch Logs operations
 type CloudWatchLogsAPI interface {
-	GetLogEventsWithContext(ctx context.Context, input *cloudwatchlogs.GetLogEventsInput, opts ...request.Option) (*cloudwatchlogs.GetLogEventsOutput, error)
-	DescribeLogGroupsWithContext(ctx context.Context, input *cloudwatchlogs.DescribeLogGroupsInput, opts ...request.Option) (*cloudwatchlogs.DescribeLogGroupsOutput, error)
-	DescribeLogStreamsWithContext(ctx context.Context, input *cloudwatchlogs.DescribeLogStreamsInput, opts ...request.Option) (*cloudwatchlogs.DescribeLogStreamsOutput, error)
-	DeleteLogGroupWithContext(ctx context.Context, input *cloudwatchlogs.DeleteLogGroupInput, opts ...request.Option) (*cloudwatchlogs.DeleteLogGroupOutput, error)
+	GetLogEvents(ctx context.Context, input *cloudwatchlogs.GetLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.GetLogEventsOutput, error)
+	DescribeLogGroups(ctx context.Context, input *cloudwatchlogs.DescribeLogGroupsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.DescribeLogGroupsOutput, error)
+	DescribeLogStreams(ctx context.Context, input *cloudwatchlogs.DescribeLogStreamsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.DescribeLogStreamsOutput, error)
+	DeleteLogGroup(ctx context.Context, input *cloudwatchlogs.DeleteLogGroupInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.DeleteLogGroupOutput, error)
+}
+
+// CloudWatchAPI defines the interface for CloudWatch metrics operations
+type CloudWatchAPI interface {
+	GetMetricStatistics(ctx context.Context, input *cloudwatch.GetMetricStatisticsInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricStatisticsOutput, error)
+}
+
+// EventBridgeAPI defines the interface for the EventBridge operations that
+// schedule warm-up invocations
+type EventBridgeAPI interface {
+	PutRule(ctx context.Context, input *eventbridge.PutRuleInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutRuleOutput, error)
+	PutTargets(ctx context.Context, input *eventbridge.PutTargetsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutTargetsOutput, error)
+	RemoveTargets(ctx context.Context, input *eventbridge.RemoveTargetsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.RemoveTargetsOutput, error)
+	DeleteRule(ctx context.Context, input *eventbridge.DeleteRuleInput, optFns ...func(*eventbridge.Options)) (*eventbridge.DeleteRuleOutput, error)
 }
 
 // LambdaAPI defines the interface for Lambda operations
 type LambdaAPI interface {
-	CreateFunctionWithContext(ctx context.Context, input *lambda.CreateFunctionInput, opts ...request.Option) (*lambda.FunctionConfiguration, error)
-	UpdateFunctionCodeWithContext(ctx context.Context, input *lambda.UpdateFunctionCodeInput, opts ...request.Option) (*lambda.FunctionConfiguration, error)
-	UpdateFunctionConfigurationWithContext(ctx context.Context, input *lambda.UpdateFunctionConfigurationInput, opts ...request.Option) (*lambda.FunctionConfiguration, error)
-	DeleteFunctionWithContext(ctx context.Context, input *lambda.DeleteFunctionInput, opts ...request.Option) (*lambda.DeleteFunctionOutput, error)
-	GetFunctionWithContext(ctx context.Context, input *lambda.GetFunctionInput, opts ...request.Option) (*lambda.GetFunctionOutput, error)
-	AddPermissionWithContext(ctx context.Context, input *lambda.AddPermissionInput, opts ...request.Option) (*lambda.AddPermissionOutput, error)
-	RemovePermissionWithContext(ctx context.Context, input *lambda.RemovePermissionInput, opts ...request.Option) (*lambda.RemovePermissionOutput, error)
-	GetPolicyWithContext(ctx context.Context, input *lambda.GetPolicyInput, opts ...request.Option) (*lambda.GetPolicyOutput, error)
+	CreateFunction(ctx context.Context, input *lambda.CreateFunctionInput, optFns ...func(*lambda.Options)) (*lambda.CreateFunctionOutput, error)
+	UpdateFunctionCode(ctx context.Context, input *lambda.UpdateFunctionCodeInput, optFns ...func(*lambda.Options)) (*lambda.UpdateFunctionCodeOutput, error)
+	UpdateFunctionConfiguration(ctx context.Context, input *lambda.UpdateFunctionConfigurationInput, optFns ...func(*lambda.Options)) (*lambda.UpdateFunctionConfigurationOutput, error)
+	DeleteFunction(ctx context.Context, input *lambda.DeleteFunctionInput, optFns ...func(*lambda.Options)) (*lambda.DeleteFunctionOutput, error)
+	GetFunction(ctx context.Context, input *lambda.GetFunctionInput, optFns ...func(*lambda.Options)) (*lambda.GetFunctionOutput, error)
+	AddPermission(ctx context.Context, input *lambda.AddPermissionInput, optFns ...func(*lambda.Options)) (*lambda.AddPermissionOutput, error)
+	RemovePermission(ctx context.Context, input *lambda.RemovePermissionInput, optFns ...func(*lambda.Options)) (*lambda.RemovePermissionOutput, error)
+	GetPolicy(ctx context.Context, input *lambda.GetPolicyInput, optFns ...func(*lambda.Options)) (*lambda.GetPolicyOutput, error)
+	CreateFunctionUrlConfig(ctx context.Context, input *lambda.CreateFunctionUrlConfigInput, optFns ...func(*lambda.Options)) (*lambda.CreateFunctionUrlConfigOutput, error)
+	UpdateFunctionUrlConfig(ctx context.Context, input *lambda.UpdateFunctionUrlConfigInput, optFns ...func(*lambda.Options)) (*lambda.UpdateFunctionUrlConfigOutput, error)
+	GetFunctionUrlConfig(ctx context.Context, input *lambda.GetFunctionUrlConfigInput, optFns ...func(*lambda.Options)) (*lambda.GetFunctionUrlConfigOutput, error)
+	DeleteFunctionUrlConfig(ctx context.Context, input *lambda.DeleteFunctionUrlConfigInput, optFns ...func(*lambda.Options)) (*lambda.DeleteFunctionUrlConfigOutput, error)
+	PublishVersion(ctx context.Context, input *lambda.PublishVersionInput, optFns ...func(*lambda.Options)) (*lambda.PublishVersionOutput, error)
+	GetAlias(ctx context.Context, input *lambda.GetAliasInput, optFns ...func(*lambda.Options)) (*lambda.GetAliasOutput, error)
+	CreateAlias(ctx context.Context, input *lambda.CreateAliasInput, optFns ...func(*lambda.Options)) (*lambda.CreateAliasOutput, error)
+	UpdateAlias(ctx context.Context, input *lambda.UpdateAliasInput, optFns ...func(*lambda.Options)) (*lambda.UpdateAliasOutput, error)
+	DeleteAlias(ctx context.Context, input *lambda.DeleteAliasInput, optFns ...func(*lambda.Options)) (*lambda.DeleteAliasOutput, error)
 }
 
 // S3API defines the interface for S3 operations
 type S3API interface {
-	PutBucketNotificationConfigurationWithContext(ctx context.Context, input *s3.PutBucketNotificationConfigurationInput, opts ...request.Option) (*s3.PutBucketNotificationConfigurationOutput, error)
-	GetBucketNotificationConfigurationWithContext(ctx context.Context, input *s3.GetBucketNotificationConfigurationRequest, opts ...request.Option) (*s3.NotificationConfiguration, error)
-	ListObjectsV2WithContext(ctx context.Context, input *s3.ListObjectsV2Input, opts ...request.Option) (*s3.ListObjectsV2Output, error)
-	DeleteObjectWithContext(ctx context.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error)
-	DeleteObjectsWithContext(ctx context.Context, input *s3.DeleteObjectsInput, opts ...request.Option) (*s3.DeleteObjectsOutput, error)
-	PutObjectWithContext(ctx context.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error)
-	GetObjectWithContext(ctx context.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error)
+	PutBucketNotificationConfiguration(ctx context.Context, input *s3.PutBucketNotificationConfigurationInput, optFns ...func(*s3.Options)) (*s3.PutBucketNotificationConfigurationOutput, error)
+	GetBucketNotificationConfiguration(ctx context.Context, input *s3.GetBucketNotificationConfigurationInput, optFns ...func(*s3.Options)) (*s3.GetBucketNotificationConfigurationOutput, error)
+	ListObjectsV2(ctx context.Context, input *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error)
+	DeleteObject(ctx context.Context, input *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	DeleteObjects(ctx context.Context, input *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+	PutObject(ctx context.Context, input *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	GetObject(ctx context.Context, input *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
 }
 
 // STSAPI defines the interface for STS operations
 type STSAPI interface {
-	GetCallerIdentityWithContext(ctx context.Context, input *sts.GetCallerIdentityInput, opts ...request.Option) (*sts.GetCallerIdentityOutput, error)
+	GetCallerIdentity(ctx context.Context, input *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error)
 }
 
 // ClientFactory creates and manages AWS service clients
 type ClientFactory struct {
-	session   *session.Session
+	cfg       aws.Config
 	accountID string
+	partition string
+	readOnly  bool
 	mu        sync.RWMutex
 }
 
@@ -75,44 +106,32 @@ type ClientFactory struct {
 type Clients struct {
 	CloudFormation CloudFormationAPI
 	CloudWatchLogs CloudWatchLogsAPI
+	CloudWatch     CloudWatchAPI
+	EventBridge    EventBridgeAPI
 	Lambda         LambdaAPI
 	S3             S3API
 	STS            STSAPI
 	AccountID      string
+	Partition      string // "aws", "aws-us-gov" or "aws-cn", for building ARNs
 }
 
-// NewClientFactory creates a new AWS client factory
+// NewClientFactory creates a new AWS client factory. Its clients retry with
+// the SDK's adaptive retryer, backing off further while AWS throttles them.
 func NewClientFactory(cfg *config.CLIConfig) (*ClientFactory, error) {
-	awsConfig := &aws.Config{
-		Region: aws.String(cfg.AWS.Region),
-	}
-	
-	// Add retry configuration
-	awsConfig.Retryer = client.DefaultRetryer{
-		NumMaxRetries:    5,
-		MinRetryDelay:    100 * time.Millisecond,
-		MinThrottleDelay: 500 * time.Millisecond,
-		MaxRetryDelay:    5 * time.Second,
-		MaxThrottleDelay: 30 * time.Second,
-	}
+	var opts []func(*awsconfig.LoadOptions) error
 	
 	// Set profile if specified
-	sessionOpts := session.Options{
-		Config:            *awsConfig,
-		SharedConfigState: session.SharedConfigEnable,
-	}
-	
 	if cfg.AWS.Profile != "" {
-		sessionOpts.Profile = cfg.AWS.Profile
+		opts = append(opts, awsconfig.WithSharedConfigProfile(cfg.AWS.Profile))
 	}
 	
-	sess, err := session.NewSessionWithOptions(sessionOpts)
+	awsConfig, err := shared.LoadAWSConfig(context.Background(), cfg.AWS.Region, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create AWS session: %w", err)
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
 	
 	factory := &ClientFactory{
-		session: sess,
+		cfg: awsConfig,
 	}
 	
 	return factory, nil
@@ -122,15 +141,24 @@ func NewClientFactory(cfg *config.CLIConfig) (*ClientFactory, error) {
 func (f *ClientFactory) GetClients() *Clients {
 	// Get account ID
 	accountID, _ := f.GetAccountID(context.Background())
+	partition := f.GetPartition()
 	
-	return &Clients{
-		CloudFormation: cloudformation.New(f.session),
-		CloudWatchLogs: cloudwatchlogs.New(f.session),
-		Lambda:         lambda.New(f.session),
-		S3:             s3.New(f.session),
-		STS:            sts.New(f.session),
+	clients := &Clients{
+		CloudFormation: cloudformation.NewFromConfig(f.cfg),
+		CloudWatchLogs: cloudwatchlogs.NewFromConfig(f.cfg),
+		CloudWatch:     cloudwatch.NewFromConfig(f.cfg),
+		EventBridge:    eventbridge.NewFromConfig(f.cfg),
+		Lambda:         lambda.NewFromConfig(f.cfg),
+		S3:             s3.NewFromConfig(f.cfg),
+		STS:            sts.NewFromConfig(f.cfg),
 		AccountID:      accountID,
+		Partition:      partition,
 	}
+	
+	if f.readOnly {
+		return readOnlyClients(clients)
+	}
+	return clients
 }
 
 // GetAccountID returns the AWS account ID, caching the result
@@ -151,24 +179,28 @@ func (f *ClientFactory) GetAccountID(ctx context.Context) (string, error) {
 		return f.accountID, nil
 	}
 	
-	stsClient := sts.New(f.session)
+	// Without credentials no call is even sent, so there's no error code to go by
+	if _, err := f.cfg.Credentials.Retrieve(ctx); err != nil && shared.AWSErrorCode(err) == "" {
+		return "", fmt.Errorf("AWS credentials not found. Please run 'aws configure' or set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables")
+	}
+	
+	stsClient := sts.NewFromConfig(f.cfg)
 	input := &sts.GetCallerIdentityInput{}
 	
-	result, err := stsClient.GetCallerIdentityWithContext(ctx, input)
+	result, err := stsClient.GetCallerIdentity(ctx, input)
 	if err != nil {
 		// Check for common AWS credential errors
-		if awsErr, ok := err.(awserr.Error); ok {
-			switch awsErr.Code() {
-			case "NoCredentialsErr":
-				return "", fmt.Errorf("AWS credentials not found. Please run 'aws configure' or set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables")
-			case "TokenRefreshRequired":
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			switch apiErr.ErrorCode() {
+			case "ExpiredToken", "ExpiredTokenException":
 				return "", fmt.Errorf("AWS credentials have expired. Please refresh your credentials or run 'aws sso login' if using SSO")
-			case "UnauthorizedOperation":
+			case "AccessDenied":
 				return "", fmt.Errorf("AWS credentials lack necessary permissions. Ensure your AWS user/role has CloudFormation, Lambda, and S3 permissions")
-			case "InvalidUserID.NotFound":
+			case "InvalidClientTokenId", "SignatureDoesNotMatch":
 				return "", fmt.Errorf("AWS credentials are invalid. Please check your AWS access key and secret key")
 			default:
-				return "", fmt.Errorf("AWS credential validation failed (%s): %v\n\n🔧 Troubleshooting:\n- Verify AWS credentials: aws sts get-caller-identity\n- Check region setting: %s", awsErr.Code(), awsErr.Message(), *f.session.Config.Region)
+				return "", fmt.Errorf("AWS credential validation failed (%s): %v\n\n🔧 Troubleshooting:\n- Verify AWS credentials: aws sts get-caller-identity\n- Check region setting: %s", apiErr.ErrorCode(), apiErr.ErrorMessage(), f.cfg.Region)
 			}
 		}
 		return "", fmt.Errorf("failed to validate AWS credentials: %w\n\n💡 Please check your AWS configuration", err)
@@ -179,9 +211,21 @@ func (f *ClientFactory) GetAccountID(ctx context.Context) (string, error) {
 	}
 	
 	f.accountID = *result.Account
+	f.partition = shared.PartitionOfARN(aws.ToString(result.Arn))
 	return f.accountID, nil
 }
 
+// GetPartition returns the partition of the caller's account, as reported
+// by STS, or of the configured region before the account has been looked up
+func (f *ClientFactory) GetPartition() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.partition != "" {
+		return f.partition
+	}
+	return shared.PartitionForRegion(f.cfg.Region)
+}
+
 // ValidateCredentials checks if AWS credentials are valid
 func (f *ClientFactory) ValidateCredentials(ctx context.Context) error {
 	_, err := f.GetAccountID(ctx)
@@ -190,7 +234,7 @@ func (f *ClientFactory) ValidateCredentials(ctx context.Context) error {
 
 // GetRegion returns the configured AWS region
 func (f *ClientFactory) GetRegion() string {
-	return *f.session.Config.Region
+	return f.cfg.Region
 }
 
 // WaitForOperation waits for an AWS operation to complete using exponential backoff
@@ -226,15 +270,3 @@ func WaitForOperation(ctx context.Context, checkFn func() (bool, error), maxWait
 		}
 	}
 }
-
-// AddRetryHandlers adds custom retry handlers to requests
-func AddRetryHandlers(req *request.Request) {
-	req.Handlers.Retry.PushBack(func(r *request.Request) {
-		if r.RetryCount > 0 {
-			// Add jitter to prevent thundering herd
-			delay := time.Duration(r.RetryCount) * 100 * time.Millisecond
-			jitter := time.Duration(r.RetryCount*50) * time.Millisecond
-			time.Sleep(delay + jitter)
-		}
-	})
-}
\ No newline at end of file
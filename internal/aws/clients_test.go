@@ -24,8 +24,8 @@ func TestNewClientFactory(t *testing.T) {
 		t.Fatal("Expected client factory to be created")
 	}
 	
-	if factory.session == nil {
-		t.Fatal("Expected session to be created")
+	if factory.cfg.Region != "us-west-2" {
+		t.Fatalf("Expected config for us-west-2, got %q", factory.cfg.Region)
 	}
 }
 
@@ -59,6 +59,9 @@ func TestGetClients(t *testing.T) {
 	if clients.CloudWatchLogs == nil {
 		t.Error("Expected CloudWatchLogs client to be created")
 	}
+	if clients.CloudWatch == nil {
+		t.Error("Expected CloudWatch client to be created")
+	}
 }
 
 func TestGetRegion(t *testing.T) {
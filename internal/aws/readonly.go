@@ -0,0 +1,182 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+)
+
+// ErrReadOnly is returned by mutating calls made through read-only clients
+var ErrReadOnly = errors.New("operation not permitted: AWS clients are read-only")
+
+// readOnlySessionName identifies monitoring sessions in the target account's CloudTrail
+const readOnlySessionName = "lambda-nat-proxy-readonly"
+
+// NewReadOnlyClientFactory creates a client factory for monitoring a deployment
+// without being able to modify it. If roleARN is set the role is assumed, so a
+// deployment in another account can be inspected. Regardless of what the
+// credentials allow, the returned clients refuse every mutating call.
+func NewReadOnlyClientFactory(cfg *config.CLIConfig, roleARN string) (*ClientFactory, error) {
+	factory, err := NewClientFactory(cfg)
+	if err != nil {
+		return nil, err
+	}
+	
+	if roleARN != "" {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(factory.cfg), roleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = readOnlySessionName
+		})
+		factory.cfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+	factory.readOnly = true
+	
+	return factory, nil
+}
+
+// IsReadOnly reports whether the factory hands out read-only clients
+func (f *ClientFactory) IsReadOnly() bool {
+	return f.readOnly
+}
+
+// readOnlyClients wraps the mutating APIs of clients so they fail with ErrReadOnly
+func readOnlyClients(clients *Clients) *Clients {
+	clients.CloudFormation = readOnlyCloudFormation{clients.CloudFormation}
+	clients.CloudWatchLogs = readOnlyCloudWatchLogs{clients.CloudWatchLogs}
+	clients.EventBridge = readOnlyEventBridge{clients.EventBridge}
+	clients.Lambda = readOnlyLambda{clients.Lambda}
+	clients.S3 = readOnlyS3{clients.S3}
+	return clients
+}
+
+func readOnlyError(operation string) error {
+	return fmt.Errorf("%s: %w", operation, ErrReadOnly)
+}
+
+type readOnlyCloudFormation struct{ CloudFormationAPI }
+
+func (readOnlyCloudFormation) CreateStack(context.Context, *cloudformation.CreateStackInput, ...func(*cloudformation.Options)) (*cloudformation.CreateStackOutput, error) {
+	return nil, readOnlyError("CreateStack")
+}
+
+func (readOnlyCloudFormation) UpdateStack(context.Context, *cloudformation.UpdateStackInput, ...func(*cloudformation.Options)) (*cloudformation.UpdateStackOutput, error) {
+	return nil, readOnlyError("UpdateStack")
+}
+
+func (readOnlyCloudFormation) DeleteStack(context.Context, *cloudformation.DeleteStackInput, ...func(*cloudformation.Options)) (*cloudformation.DeleteStackOutput, error) {
+	return nil, readOnlyError("DeleteStack")
+}
+
+func (readOnlyCloudFormation) CreateChangeSet(context.Context, *cloudformation.CreateChangeSetInput, ...func(*cloudformation.Options)) (*cloudformation.CreateChangeSetOutput, error) {
+	return nil, readOnlyError("CreateChangeSet")
+}
+
+func (readOnlyCloudFormation) DeleteChangeSet(context.Context, *cloudformation.DeleteChangeSetInput, ...func(*cloudformation.Options)) (*cloudformation.DeleteChangeSetOutput, error) {
+	return nil, readOnlyError("DeleteChangeSet")
+}
+
+type readOnlyCloudWatchLogs struct{ CloudWatchLogsAPI }
+
+func (readOnlyCloudWatchLogs) DeleteLogGroup(context.Context, *cloudwatchlogs.DeleteLogGroupInput, ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.DeleteLogGroupOutput, error) {
+	return nil, readOnlyError("DeleteLogGroup")
+}
+
+type readOnlyEventBridge struct{ EventBridgeAPI }
+
+func (readOnlyEventBridge) PutRule(context.Context, *eventbridge.PutRuleInput, ...func(*eventbridge.Options)) (*eventbridge.PutRuleOutput, error) {
+	return nil, readOnlyError("PutRule")
+}
+
+func (readOnlyEventBridge) PutTargets(context.Context, *eventbridge.PutTargetsInput, ...func(*eventbridge.Options)) (*eventbridge.PutTargetsOutput, error) {
+	return nil, readOnlyError("PutTargets")
+}
+
+func (readOnlyEventBridge) RemoveTargets(context.Context, *eventbridge.RemoveTargetsInput, ...func(*eventbridge.Options)) (*eventbridge.RemoveTargetsOutput, error) {
+	return nil, readOnlyError("RemoveTargets")
+}
+
+func (readOnlyEventBridge) DeleteRule(context.Context, *eventbridge.DeleteRuleInput, ...func(*eventbridge.Options)) (*eventbridge.DeleteRuleOutput, error) {
+	return nil, readOnlyError("DeleteRule")
+}
+
+type readOnlyLambda struct{ LambdaAPI }
+
+func (readOnlyLambda) CreateFunction(context.Context, *lambda.CreateFunctionInput, ...func(*lambda.Options)) (*lambda.CreateFunctionOutput, error) {
+	return nil, readOnlyError("CreateFunction")
+}
+
+func (readOnlyLambda) UpdateFunctionCode(context.Context, *lambda.UpdateFunctionCodeInput, ...func(*lambda.Options)) (*lambda.UpdateFunctionCodeOutput, error) {
+	return nil, readOnlyError("UpdateFunctionCode")
+}
+
+func (readOnlyLambda) UpdateFunctionConfiguration(context.Context, *lambda.UpdateFunctionConfigurationInput, ...func(*lambda.Options)) (*lambda.UpdateFunctionConfigurationOutput, error) {
+	return nil, readOnlyError("UpdateFunctionConfiguration")
+}
+
+func (readOnlyLambda) DeleteFunction(context.Context, *lambda.DeleteFunctionInput, ...func(*lambda.Options)) (*lambda.DeleteFunctionOutput, error) {
+	return nil, readOnlyError("DeleteFunction")
+}
+
+func (readOnlyLambda) AddPermission(context.Context, *lambda.AddPermissionInput, ...func(*lambda.Options)) (*lambda.AddPermissionOutput, error) {
+	return nil, readOnlyError("AddPermission")
+}
+
+func (readOnlyLambda) RemovePermission(context.Context, *lambda.RemovePermissionInput, ...func(*lambda.Options)) (*lambda.RemovePermissionOutput, error) {
+	return nil, readOnlyError("RemovePermission")
+}
+
+func (readOnlyLambda) CreateFunctionUrlConfig(context.Context, *lambda.CreateFunctionUrlConfigInput, ...func(*lambda.Options)) (*lambda.CreateFunctionUrlConfigOutput, error) {
+	return nil, readOnlyError("CreateFunctionUrlConfig")
+}
+
+func (readOnlyLambda) UpdateFunctionUrlConfig(context.Context, *lambda.UpdateFunctionUrlConfigInput, ...func(*lambda.Options)) (*lambda.UpdateFunctionUrlConfigOutput, error) {
+	return nil, readOnlyError("UpdateFunctionUrlConfig")
+}
+
+func (readOnlyLambda) DeleteFunctionUrlConfig(context.Context, *lambda.DeleteFunctionUrlConfigInput, ...func(*lambda.Options)) (*lambda.DeleteFunctionUrlConfigOutput, error) {
+	return nil, readOnlyError("DeleteFunctionUrlConfig")
+}
+
+func (readOnlyLambda) PublishVersion(context.Context, *lambda.PublishVersionInput, ...func(*lambda.Options)) (*lambda.PublishVersionOutput, error) {
+	return nil, readOnlyError("PublishVersion")
+}
+
+func (readOnlyLambda) CreateAlias(context.Context, *lambda.CreateAliasInput, ...func(*lambda.Options)) (*lambda.CreateAliasOutput, error) {
+	return nil, readOnlyError("CreateAlias")
+}
+
+func (readOnlyLambda) UpdateAlias(context.Context, *lambda.UpdateAliasInput, ...func(*lambda.Options)) (*lambda.UpdateAliasOutput, error) {
+	return nil, readOnlyError("UpdateAlias")
+}
+
+func (readOnlyLambda) DeleteAlias(context.Context, *lambda.DeleteAliasInput, ...func(*lambda.Options)) (*lambda.DeleteAliasOutput, error) {
+	return nil, readOnlyError("DeleteAlias")
+}
+
+type readOnlyS3 struct{ S3API }
+
+func (readOnlyS3) PutBucketNotificationConfiguration(context.Context, *s3.PutBucketNotificationConfigurationInput, ...func(*s3.Options)) (*s3.PutBucketNotificationConfigurationOutput, error) {
+	return nil, readOnlyError("PutBucketNotificationConfiguration")
+}
+
+func (readOnlyS3) DeleteObject(context.Context, *s3.DeleteObjectInput, ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	return nil, readOnlyError("DeleteObject")
+}
+
+func (readOnlyS3) DeleteObjects(context.Context, *s3.DeleteObjectsInput, ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	return nil, readOnlyError("DeleteObjects")
+}
+
+func (readOnlyS3) PutObject(context.Context, *s3.PutObjectInput, ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	return nil, readOnlyError("PutObject")
+}
@@ -0,0 +1,51 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+)
+
+func TestNewReadOnlyClientFactory(t *testing.T) {
+	cfg := &config.CLIConfig{
+		AWS: config.AWSConfig{
+			Region: "eu-central-1",
+		},
+	}
+	
+	factory, err := NewReadOnlyClientFactory(cfg, "arn:aws:iam::123456789012:role/proxy-readonly")
+	if err != nil {
+		t.Fatalf("Expected no error creating read-only factory, got %v", err)
+	}
+	if !factory.IsReadOnly() {
+		t.Error("Expected factory to be read-only")
+	}
+	if factory.GetRegion() != "eu-central-1" {
+		t.Errorf("Expected region eu-central-1, got %s", factory.GetRegion())
+	}
+}
+
+func TestReadOnlyClientsRejectMutations(t *testing.T) {
+	ctx := context.Background()
+	clients := readOnlyClients(&Clients{})
+	
+	if _, err := clients.CloudFormation.DeleteStack(ctx, &cloudformation.DeleteStackInput{}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly from DeleteStack, got %v", err)
+	}
+	if _, err := clients.Lambda.UpdateFunctionCode(ctx, &lambda.UpdateFunctionCodeInput{}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly from UpdateFunctionCode, got %v", err)
+	}
+	if _, err := clients.EventBridge.PutRule(ctx, &eventbridge.PutRuleInput{}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly from PutRule, got %v", err)
+	}
+	if _, err := clients.S3.PutObject(ctx, &s3.PutObjectInput{}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Expected ErrReadOnly from PutObject, got %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+// Package changelog embeds the release notes of every release, so the
+// first run after an upgrade can tell the user what changed, most
+// importantly when the Lambda has to be redeployed to keep working with the
+// new proxy.
+package changelog
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed changelog.yaml
+var changelogYAML []byte
+
+// Note is one change in a release
+type Note struct {
+	Text string `yaml:"text" json:"text"`
+
+	// Redeploy is set when existing stacks must be redeployed for the new
+	// proxy to work with them
+	Redeploy bool `yaml:"redeploy,omitempty" json:"redeploy,omitempty"`
+
+	// Breaking is set when something that worked before no longer does
+	Breaking bool `yaml:"breaking,omitempty" json:"breaking,omitempty"`
+}
+
+// Release is the notes of one version
+type Release struct {
+	Version string `yaml:"version" json:"version"`
+
+	// Protocol is the coordination payload schema version the release speaks
+	Protocol int    `yaml:"protocol" json:"protocol"`
+	Notes    []Note `yaml:"notes" json:"notes"`
+}
+
+// NeedsRedeploy reports whether any of the release's notes needs a redeploy
+func (r Release) NeedsRedeploy() bool {
+	for _, note := range r.Notes {
+		if note.Redeploy {
+			return true
+		}
+	}
+	return false
+}
+
+// Releases returns every release's notes, newest first
+func Releases() ([]Release, error) {
+	var releases []Release
+	if err := yaml.Unmarshal(changelogYAML, &releases); err != nil {
+		return nil, fmt.Errorf("failed to parse the embedded changelog: %w", err)
+	}
+	for _, release := range releases {
+		if _, ok := parseVersion(release.Version); !ok {
+			return nil, fmt.Errorf("embedded changelog has invalid version %q", release.Version)
+		}
+	}
+	return releases, nil
+}
+
+// Between returns the notes of the releases newer than previous, up to and
+// including current, newest first. It returns none if either version can't
+// be parsed, or for a downgrade.
+func Between(releases []Release, previous, current string) []Release {
+	if _, ok := parseVersion(previous); !ok {
+		return nil
+	}
+	if _, ok := parseVersion(current); !ok {
+		return nil
+	}
+	var between []Release
+	for _, release := range releases {
+		if Compare(release.Version, previous) > 0 && Compare(release.Version, current) <= 0 {
+			between = append(between, release)
+		}
+	}
+	return between
+}
+
+// Compare compares two vMAJOR.MINOR.PATCH versions, returning -1, 0 or 1.
+// A pre-release sorts before its release, and any version that can't be
+// parsed sorts before every one that can.
+func Compare(a, b string) int {
+	va, okA := parseVersion(a)
+	vb, okB := parseVersion(b)
+	switch {
+	case !okA && !okB:
+		return 0
+	case !okA:
+		return -1
+	case !okB:
+		return 1
+	}
+	for i := range va.numbers {
+		if va.numbers[i] != vb.numbers[i] {
+			if va.numbers[i] < vb.numbers[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case va.pre == vb.pre:
+		return 0
+	case va.pre == "":
+		return 1
+	case vb.pre == "":
+		return -1
+	case va.pre < vb.pre:
+		return -1
+	}
+	return 1
+}
+
+// version is a parsed vMAJOR.MINOR.PATCH[-PRE] version
+type version struct {
+	numbers [3]int
+	pre     string
+}
+
+func parseVersion(s string) (version, bool) {
+	var v version
+	s, ok := strings.CutPrefix(strings.TrimSpace(s), "v")
+	if !ok {
+		return v, false
+	}
+	s, _, _ = strings.Cut(s, "+")
+	s, v.pre, _ = strings.Cut(s, "-")
+	parts := strings.Split(s, ".")
+	if len(parts) != len(v.numbers) {
+		return v, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v.numbers[i] = n
+	}
+	return v, true
+}
+
+// Upgrade records current as the last version run, in the file at path,
+// and returns the version run before it with the notes of the releases
+// since. On the first run there is no previous version and nothing to show.
+// A version that didn't change returns no notes, so they are shown once.
+func Upgrade(path, current string) (string, []Release, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", nil, fmt.Errorf("failed to read the last version run: %w", err)
+	}
+	previous := strings.TrimSpace(string(data))
+	if previous == current {
+		return previous, nil, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", nil, fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(current+"\n"), 0600); err != nil {
+		return "", nil, fmt.Errorf("failed to record the version run: %w", err)
+	}
+	if previous == "" {
+		return "", nil, nil
+	}
+	releases, err := Releases()
+	if err != nil {
+		return previous, nil, err
+	}
+	return previous, Between(releases, previous, current), nil
+}
@@ -0,0 +1,73 @@
+package changelog
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+func TestReleases(t *testing.T) {
+	releases, err := Releases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) == 0 || releases[0].Protocol != shared.PayloadSchemaVersion {
+		t.Fatalf("the newest release should speak protocol %d", shared.PayloadSchemaVersion)
+	}
+	for i := 1; i < len(releases); i++ {
+		if Compare(releases[i-1].Version, releases[i].Version) <= 0 {
+			t.Errorf("%s is listed before %s; releases must be newest first", releases[i-1].Version, releases[i].Version)
+		}
+		if releases[i-1].Protocol != releases[i].Protocol && !releases[i-1].NeedsRedeploy() {
+			t.Errorf("%s changes the protocol but has no redeploy note", releases[i-1].Version)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"v1.0.0", "v1.0.0", 0},
+		{"v1.2.0", "v1.10.0", -1},
+		{"v2.0.0", "v1.9.9", 1},
+		{"v1.0.0-rc.1", "v1.0.0", -1},
+		{"v1.0.0+build", "v1.0.0", 0},
+		{"dev", "v0.0.1", -1},
+	}
+	for _, tt := range tests {
+		if got := Compare(tt.a, tt.b); got != tt.want {
+			t.Errorf("Compare(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "last-version")
+	releases := []Release{
+		{Version: "v1.2.0", Protocol: 2, Notes: []Note{{Text: "protocol v2", Redeploy: true}}},
+		{Version: "v1.1.0", Protocol: 1},
+		{Version: "v1.0.0", Protocol: 1},
+	}
+	if got := Between(releases, "v1.0.0", "v1.2.0"); len(got) != 2 || got[0].Version != "v1.2.0" || !got[0].NeedsRedeploy() {
+		t.Fatalf("Between(v1.0.0, v1.2.0) = %+v, want v1.2.0 and v1.1.0", got)
+	}
+	if got := Between(releases, "v1.2.0", "v1.0.0"); len(got) != 0 {
+		t.Fatalf("a downgrade returned %+v", got)
+	}
+
+	// The first run has nothing to compare with
+	if previous, _, err := Upgrade(path, "v0.9.0"); err != nil || previous != "" {
+		t.Fatalf("first run: previous %q, err %v", previous, err)
+	}
+	previous, notes, err := Upgrade(path, "v1.0.0")
+	if err != nil || previous != "v0.9.0" || len(notes) == 0 || notes[0].Version != "v1.0.0" {
+		t.Fatalf("upgrade: previous %q, notes %+v, err %v", previous, notes, err)
+	}
+	// The notes are shown once
+	if _, notes, err := Upgrade(path, "v1.0.0"); err != nil || len(notes) != 0 {
+		t.Fatalf("second run: notes %+v, err %v", notes, err)
+	}
+}
@@ -2,9 +2,12 @@ package config
 
 import (
 	"log"
+	"log/slog"
 	"os"
 	"time"
 
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/anomaly"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/audit"
 	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
 )
 
@@ -17,6 +20,17 @@ const (
 	ModePerformance PerformanceMode = "performance" // Maximum performance for streaming
 )
 
+// CloudFormation template variants deploy can create
+const (
+	TemplateStandard = "standard" // coordination bucket and Lambda role, tagged for cost tracking
+	TemplateMinimal  = "minimal"  // the same resources with only the Project tag and no exports
+	TemplateHardened = "hardened" // adds a KMS key, TLS-only bucket policy, log retention and alarms
+	TemplateVPC      = "vpc"      // runs the Lambda in a private subnet behind a NAT gateway with a fixed IP
+)
+
+// TemplateVariants lists the template variants in the order they are documented
+var TemplateVariants = []string{TemplateStandard, TemplateMinimal, TemplateHardened, TemplateVPC}
+
 // ModeConfig holds all configuration for a specific performance mode
 type ModeConfig struct {
 	Name           string
@@ -29,6 +43,7 @@ type ModeConfig struct {
 	MaxStreams     int           // Maximum concurrent streams
 	KeepAlive      time.Duration // Connection keep-alive
 	IdleTimeout    time.Duration // Connection idle timeout
+	TunnelIdle     time.Duration // Close SOCKS5 tunnels with no traffic for this long
 }
 
 // RotationConfig holds session rotation configuration
@@ -36,30 +51,224 @@ type RotationConfig struct {
 	OverlapWindow time.Duration
 	DrainTimeout  time.Duration
 	SessionTTL    time.Duration
+	
+	// DrainPolicy picks when a draining session is shut down: after
+	// DrainTimeout ("timer", the default) or once its streams have all
+	// closed, waiting at most MaxDrainWait ("streams"; 0 = default)
+	DrainPolicy  string
+	MaxDrainWait time.Duration
+	
+	// Session pool size: one primary, up to Secondaries secondaries, and
+	// draining sessions, all counted against MaxSessions (0 = defaults)
+	Secondaries int
+	MaxSessions int
+	
+	// MaxGoroutines caps the connection manager's background work: launches,
+	// promotions and drain cleanups (0 = default)
+	MaxGoroutines int
+}
+
+// PoolSize returns the session pool limits with defaults applied. MaxSessions
+// is raised to fit the primary and every secondary.
+func (r RotationConfig) PoolSize() (secondaries, maxSessions int) {
+	secondaries = r.Secondaries
+	if secondaries <= 0 {
+		secondaries = shared.DefaultPoolSecondaries
+	}
+	maxSessions = r.MaxSessions
+	if maxSessions <= 0 {
+		maxSessions = shared.DefaultPoolMaxSessions
+	}
+	if maxSessions < 1+secondaries {
+		maxSessions = 1 + secondaries
+	}
+	return secondaries, maxSessions
+}
+
+// GoroutineLimit returns MaxGoroutines with the default applied
+func (r RotationConfig) GoroutineLimit() int {
+	if r.MaxGoroutines > 0 {
+		return r.MaxGoroutines
+	}
+	return shared.DefaultPoolMaxGoroutines
 }
 
-// Config holds all configuration for the orchestrator
+// Orchestrator-side timeouts. They leave room for a Lambda cold start and for
+// the Lambda's own, shorter hole punching attempt.
+const (
+	lambdaResponseTimeout = 30 * time.Second
+	natHolePunchTimeout   = 30 * time.Second
+)
+
+// Config holds the resolved configuration consumed by the orchestrator's
+// components. It is built either from a CLIConfig by ToConfig or from the
+// environment by New; both start from newConfig, so defaults cannot drift.
 type Config struct {
 	// AWS configuration
 	AWSRegion    string
 	S3BucketName string
+	
+	// Function invoked directly when the S3 notification is late (empty = never)
+	LambdaFunctionName string
+	
+	// Alias of the function published for Mode, invoked for every session
+	// when Mode's memory differs from the deployed function's (empty = none)
+	SessionAlias string
+	
+	// How sessions reach the Lambda, shared.CoordinationS3 or shared.CoordinationFunctionURL
+	Coordination string
+	
+	// Mint each Lambda credentials scoped to its session, by assuming
+	// SessionCredentialsRoleArn (found in the stack outputs)
+	SessionCredentials        bool
+	SessionCredentialsRoleArn string
+
+	// KMS key the stack's bucket is encrypted with, which session
+	// credentials must be able to use (hardened template variant)
+	EncryptionKeyArn string
 
 	// Network configuration
-	STUNServer string
-	SOCKS5Port int
+	STUNServer      string
+	SOCKS5Port      int
+	SOCKS5Listen    string // IP address the SOCKS5 server binds to
+	EnableDatagrams bool // Relay SOCKS5 UDP over QUIC datagrams when possible
+	PipelineConnect bool // Answer CONNECT before the Lambda has connected, saving a round trip
+	
+	// How long SOCKS5 connections wait for a session when none is available
+	SessionWaitTimeout time.Duration
+	QUIC            shared.QUICTuning
+	
+	// Local UDP ports allowed for STUN and hole punching (zero value = any)
+	PunchPorts shared.PortRange
+	
+	// Ports either side of the Lambda's reported port to punch in parallel (0 = off)
+	PunchPredictPorts int
+	
+	// Session ping interval, and the shortest missed pings bring it down to (0 = nat defaults)
+	KeepaliveInterval    time.Duration
+	KeepaliveMinInterval time.Duration
+	
+	// SOCKS5 tunnels with no traffic for this long are closed (0 = never)
+	TunnelIdleTimeout time.Duration
+	
+	// Destination dial timeout sent to the Lambda with each stream (0 = the Lambda's default)
+	TunnelConnectTimeout time.Duration
+	
+	// SOCKS5 tunnels open this long are closed, by the proxy and the Lambda (0 = never)
+	TunnelMaxLifetime time.Duration
+	
+	// Algorithm compressing tunnel payloads, used with Lambdas that support it
+	TunnelCompression shared.Compression
+	
+	// Concurrent SOCKS5 connections allowed before new ones are rejected
+	MaxConnections int
+	
+	// Bandwidth caps for tunnelled traffic (zero value = unlimited)
+	Bandwidth shared.BandwidthLimits
+	
+	// Ceilings on the proxy's own resource use (zero value = unlimited)
+	Resources shared.ResourceLimits
+	
+	// Reply given to refused SOCKS5 connections (zero value = "not allowed by ruleset")
+	Refusal shared.RefusalPolicy
+	
+	// PROXY protocol headers accepted on the SOCKS5 port (zero value = off)
+	ProxyProtocol shared.ProxyProtocolPolicy
+	
+	// Destination rules enforced by the proxy and the Lambda (zero value = allow all)
+	ACL shared.ACLConfig
+	
+	// Policy document replacing ACL and Bandwidth when set (empty = none)
+	PolicyFile string
+	
+	// Domains resolved locally instead of on the Lambda (split-horizon DNS)
+	Resolvers []shared.ResolverRule
+	
+	// Upstream resolver and cache limit used by the Lambda (zero value = Lambda's system resolver)
+	DNS shared.DNSConfig
+	
+	// How long each CONNECT domain keeps the address the Lambda resolved it to (0 = off)
+	DNSPinTTL time.Duration
+	
+	// Local address of the DNS stub that forwards queries to the Lambda (empty = off)
+	DNSListen string
+	
+	// OTLP span export for the proxy and the Lambda (empty endpoint = off)
+	Tracing       shared.TracingConfig
+	LambdaTracing shared.TracingConfig
+	
+	// Per-connection JSONL audit log and its rotation (empty path = off)
+	AuditLogPath string
+	AuditLog     audit.Options
+	
+	// Session health anomaly detection and its tuning (false = off)
+	AnomalyDetection bool
+	Anomaly          anomaly.Options
+	
+	// CloudWatch metrics published by the Lambda (empty namespace = off)
+	LambdaMetrics shared.LambdaMetricsConfig
+	
+	// Lambda log lines forwarded to this proxy's log (empty level = off)
+	LambdaLogs shared.LogForwarding
+	
+	// Storage class and tags of coordination and response objects (zero = bucket defaults)
+	S3Objects shared.ObjectStorage
+	
+	// Transfer and spend caps past which no sessions are launched (zero caps = none)
+	Budget BudgetLimits
+	
+	// Relay sessions run through when UDP is blocked, and when to use it:
+	// shared.RelayModeFallback or shared.RelayModeAlways (empty URL = off)
+	Relay     shared.RelaySettings
+	RelayMode string
 
 	// Timeout configuration
 	LambdaResponseTimeout time.Duration
+	InvokeFallback        time.Duration // invoke the Lambda directly if it hasn't answered this long after the S3 write (0 = never)
 	NATHolePunchTimeout   time.Duration
 	
+	// Level the proxy logs at, changeable while it runs
+	LogLevel slog.Level
+	
+	// Redact public IP addresses from logs and the dashboard
+	PrivacyMode bool
+	
+	// Desktop notifications of new egress IPs, lost sessions and the budget
+	Notifications bool
+	
+	// Webhook URLs that receive the same notifications
+	Webhooks []string
+	
 	// Rotation configuration
 	Rotation RotationConfig
 	
+	// Feature flags by name, applied with features.Apply
+	Features map[string]bool
+	
 	// Performance mode configuration
 	Mode       PerformanceMode
 	ModeConfig ModeConfig
 }
 
+// Budget periods, calendar days and months in UTC
+const (
+	BudgetPeriodDay   = "day"
+	BudgetPeriodMonth = "month"
+)
+
+// BudgetLimits caps the bytes tunnelled and the estimated US dollars spent per
+// Period (0 = no cap)
+type BudgetLimits struct {
+	Period      string
+	MaxTransfer int64
+	MaxSpend    float64
+}
+
+// IsZero reports whether no cap is set
+func (b BudgetLimits) IsZero() bool {
+	return b.MaxTransfer <= 0 && b.MaxSpend <= 0
+}
+
 // GetModeConfigs returns predefined mode configurations
 func GetModeConfigs() map[PerformanceMode]ModeConfig {
 	return map[PerformanceMode]ModeConfig{
@@ -74,6 +283,7 @@ func GetModeConfigs() map[PerformanceMode]ModeConfig {
 			MaxStreams:    100,                     // Limited streams
 			KeepAlive:     10 * time.Second,        // Short keep-alive
 			IdleTimeout:   2 * time.Minute,         // Short idle
+			TunnelIdle:    2 * time.Minute,         // Reap quiet tunnels quickly
 		},
 		ModeNormal: {
 			Name:          "Normal Mode",
@@ -86,6 +296,7 @@ func GetModeConfigs() map[PerformanceMode]ModeConfig {
 			MaxStreams:    500,                     // Good stream count
 			KeepAlive:     30 * time.Second,        // Standard keep-alive
 			IdleTimeout:   5 * time.Minute,         // Standard idle
+			TunnelIdle:    10 * time.Minute,        // Standard tunnel idle
 		},
 		ModePerformance: {
 			Name:          "Performance Mode",
@@ -98,38 +309,36 @@ func GetModeConfigs() map[PerformanceMode]ModeConfig {
 			MaxStreams:    1000,                    // Maximum streams
 			KeepAlive:     30 * time.Second,        // Optimal keep-alive
 			IdleTimeout:   5 * time.Minute,         // Optimal idle
+			TunnelIdle:    15 * time.Minute,        // Tolerate long-quiet tunnels
 		},
 	}
 }
 
-// New creates a new configuration with defaults from environment variables
-func New() *Config {
-	// Determine performance mode from environment
-	modeStr := os.Getenv("MODE")
-	if modeStr == "" {
-		modeStr = "normal" // Default to normal mode
-	}
-	
-	mode := PerformanceMode(modeStr)
-	modeConfigs := GetModeConfigs()
-	
-	// Validate mode
-	modeConfig, exists := modeConfigs[mode]
+// ResolveMode returns mode and its configuration, falling back to normal mode
+// for an empty or unknown mode
+func ResolveMode(mode PerformanceMode) (PerformanceMode, ModeConfig, bool) {
+	modeConfig, exists := GetModeConfigs()[mode]
 	if !exists {
-		log.Printf("⚠️  Invalid mode '%s', using 'normal' mode", modeStr)
-		mode = ModeNormal
-		modeConfig = modeConfigs[ModeNormal]
+		return ModeNormal, GetModeConfigs()[ModeNormal], false
 	}
-	
-	log.Printf("🚀 %s: %s", modeConfig.Name, getModeDescription(mode))
-	
-	config := &Config{
-		// Set defaults
+	return mode, modeConfig, true
+}
+
+// newConfig returns a Config holding the defaults for mode
+func newConfig(mode PerformanceMode) *Config {
+	mode, modeConfig, _ := ResolveMode(mode)
+	return &Config{
 		AWSRegion:             shared.DefaultAWSRegion,
 		STUNServer:            shared.DefaultSTUNServer,
 		SOCKS5Port:            shared.DefaultSOCKS5Port,
-		LambdaResponseTimeout: shared.DefaultLambdaResponseTimeout,
-		NATHolePunchTimeout:   shared.DefaultNATHolePunchTimeout,
+		SOCKS5Listen:          shared.DefaultSOCKS5Listen,
+		LambdaResponseTimeout: lambdaResponseTimeout,
+		InvokeFallback:        shared.DefaultInvokeFallback,
+		Coordination:          shared.CoordinationS3,
+		NATHolePunchTimeout:   natHolePunchTimeout,
+		SessionWaitTimeout:    shared.DefaultSessionWaitTimeout,
+		MaxConnections:        shared.DefaultMaxConnections,
+		TunnelIdleTimeout:     modeConfig.TunnelIdle,
 		
 		// Apply mode configuration
 		Mode:       mode,
@@ -137,9 +346,28 @@ func New() *Config {
 		Rotation: RotationConfig{
 			OverlapWindow: modeConfig.OverlapWindow,
 			DrainTimeout:  modeConfig.DrainTimeout,
+			DrainPolicy:   shared.DrainPolicyTimer,
 			SessionTTL:    modeConfig.SessionTTL,
 		},
 	}
+}
+
+// New creates a new configuration with defaults from environment variables
+func New() *Config {
+	// Determine performance mode from environment
+	modeStr := os.Getenv("MODE")
+	if modeStr == "" {
+		modeStr = "normal" // Default to normal mode
+	}
+	
+	mode, modeConfig, ok := ResolveMode(PerformanceMode(modeStr))
+	if !ok {
+		log.Printf("⚠️  Invalid mode '%s', using 'normal' mode", modeStr)
+	}
+	
+	log.Printf("🚀 %s: %s", modeConfig.Name, getModeDescription(mode))
+	
+	config := newConfig(mode)
 
 	// Override with environment variables
 	config.S3BucketName = os.Getenv("AWS_S3_BUCKET")
@@ -151,6 +379,46 @@ func New() *Config {
 	return config
 }
 
+// SessionSettings returns the settings sent to the Lambda with each coordination request
+func (c *Config) SessionSettings() *shared.SessionSettings {
+	quicTuning := c.QUIC
+	settings := &shared.SessionSettings{
+		QUIC: &quicTuning,
+	}
+	if !c.ACL.IsZero() {
+		acl := c.ACL
+		settings.ACL = &acl
+	}
+	if c.DNS.Upstream != "" {
+		dns := c.DNS
+		settings.DNS = &dns
+	}
+	if c.LambdaTracing.Endpoint != "" {
+		tracing := c.LambdaTracing
+		settings.Tracing = &tracing
+	}
+	if c.LambdaMetrics.Namespace != "" {
+		lambdaMetrics := c.LambdaMetrics
+		settings.Metrics = &lambdaMetrics
+	}
+	if c.LambdaLogs.Level != "" {
+		lambdaLogs := c.LambdaLogs
+		settings.Logs = &lambdaLogs
+	}
+	if !c.S3Objects.IsZero() {
+		storage := c.S3Objects
+		settings.Storage = &storage
+	}
+	if c.SessionAlias != "" {
+		settings.MemoryMB = c.ModeConfig.LambdaMemory
+	}
+	if c.Relay.URL != "" {
+		relay := c.Relay
+		settings.Relay = &relay
+	}
+	return settings
+}
+
 // getModeDescription returns a description for the given mode
 func getModeDescription(mode PerformanceMode) string {
 	switch mode {
@@ -6,6 +6,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+	
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
 )
 
 func TestRotationDefaults(t *testing.T) {
@@ -43,6 +45,9 @@ func TestDefaultCLIConfig(t *testing.T) {
 	if cfg.Proxy.Port != 1080 {
 		t.Errorf("Expected default port 1080, got %d", cfg.Proxy.Port)
 	}
+	if cfg.Proxy.Listen != "127.0.0.1" || cfg.ToConfig("bucket").SOCKS5Listen != "127.0.0.1" {
+		t.Errorf("Expected the proxy to listen on 127.0.0.1 by default, got %q", cfg.Proxy.Listen)
+	}
 	if cfg.Proxy.STUNServer == "" {
 		t.Error("Expected STUN server to be set by default")
 	}
@@ -164,4 +169,797 @@ func TestValidateCLIConfig(t *testing.T) {
 	if err := ValidateCLIConfig(invalidModeCfg); err == nil {
 		t.Error("Expected error for config with invalid mode")
 	}
-}
\ No newline at end of file
+	
+	// Test unsupported congestion controller
+	bbrCfg := DefaultCLIConfig()
+	bbrCfg.Proxy.CongestionControl = "bbr"
+	if err := ValidateCLIConfig(bbrCfg); err == nil {
+		t.Error("Expected error for unsupported congestion controller")
+	}
+	
+	// Test too-small initial window
+	windowCfg := DefaultCLIConfig()
+	windowCfg.Proxy.InitialWindow = 1024
+	if err := ValidateCLIConfig(windowCfg); err == nil {
+		t.Error("Expected error for initial window below minimum")
+	}
+	
+	// Test too many QUIC connections per session
+	stripesCfg := DefaultCLIConfig()
+	stripesCfg.Proxy.QUICConnections = 9
+	if err := ValidateCLIConfig(stripesCfg); err == nil {
+		t.Error("Expected error for more than 8 QUIC connections")
+	}
+	
+	// Test malformed punch port range
+	portsCfg := DefaultCLIConfig()
+	portsCfg.Proxy.PunchPorts = "50000-40000"
+	if err := ValidateCLIConfig(portsCfg); err == nil {
+		t.Error("Expected error for inverted punch port range")
+	}
+	
+	// Test excessive port prediction
+	predictCfg := DefaultCLIConfig()
+	predictCfg.Proxy.PunchPredictPorts = 1000
+	if err := ValidateCLIConfig(predictCfg); err == nil {
+		t.Error("Expected error for punch predict ports above maximum")
+	}
+	
+	// Test negative connection limit
+	connsCfg := DefaultCLIConfig()
+	connsCfg.Proxy.MaxConnections = -1
+	if err := ValidateCLIConfig(connsCfg); err == nil {
+		t.Error("Expected error for negative max connections")
+	}
+	
+	// Test session pool too small for its secondaries
+	poolCfg := DefaultCLIConfig()
+	poolCfg.Proxy.SessionPool = SessionPoolConfig{Secondaries: 3, MaxSessions: 3}
+	if err := ValidateCLIConfig(poolCfg); err == nil {
+		t.Error("Expected error for max sessions below 1 + secondaries")
+	}
+	
+	// Test malformed rate limit
+	rateCfg := DefaultCLIConfig()
+	rateCfg.Proxy.RateLimit.PerClient = "fast"
+	if err := ValidateCLIConfig(rateCfg); err == nil {
+		t.Error("Expected error for malformed per-client rate limit")
+	}
+	
+	// Test malformed ACL rule
+	aclCfg := DefaultCLIConfig()
+	aclCfg.Proxy.ACL.Deny = []string{"10.0.0.0/33"}
+	if err := ValidateCLIConfig(aclCfg); err == nil {
+		t.Error("Expected error for malformed ACL rule")
+	}
+	
+	// Test malformed Lambda guard rule
+	guardCfg := DefaultCLIConfig()
+	guardCfg.Deployment.BlockedTargets = []string{"metadata", "host:0"}
+	if err := ValidateCLIConfig(guardCfg); err == nil {
+		t.Error("Expected error for malformed blocked target")
+	}
+	
+	// Test resolver with an unknown route
+	resolverCfg := DefaultCLIConfig()
+	resolverCfg.Proxy.Resolvers = []ResolverConfig{{Domains: []string{"*.corp.example.com"}, Route: "sideways"}}
+	if err := ValidateCLIConfig(resolverCfg); err == nil {
+		t.Error("Expected error for unknown resolver route")
+	}
+	
+	// Test resource limits with an unparseable memory size
+	limitsCfg := DefaultCLIConfig()
+	limitsCfg.Proxy.ResourceLimits = ResourceLimitsConfig{MaxGoroutines: 5000, MaxMemory: "lots"}
+	if err := ValidateCLIConfig(limitsCfg); err == nil {
+		t.Error("Expected error for invalid max memory")
+	}
+	limitsCfg.Proxy.ResourceLimits.MaxMemory = "512MB"
+	if err := ValidateCLIConfig(limitsCfg); err != nil {
+		t.Errorf("Expected resource limits to be valid, got %v", err)
+	}
+	if got := limitsCfg.ToConfig("bucket").Resources; got.MaxGoroutines != 5000 || got.MaxMemory != 512<<20 {
+		t.Errorf("Unexpected resource limits %+v", got)
+	}
+	
+	// Test an unknown refusal reply
+	refusalCfg := DefaultCLIConfig()
+	refusalCfg.Proxy.Refusal.Reply = "teapot"
+	if err := ValidateCLIConfig(refusalCfg); err == nil {
+		t.Error("Expected error for unknown refusal reply")
+	}
+	refusalCfg.Proxy.Refusal.Reply = "general-failure"
+	if err := ValidateCLIConfig(refusalCfg); err != nil {
+		t.Errorf("Expected general-failure refusal reply to be valid, got %v", err)
+	}
+	if reply := refusalCfg.ToConfig("bucket").Refusal.Reply; reply != 0x01 {
+		t.Errorf("Expected general failure refusal reply, got %d", reply)
+	}
+	
+	// Test an audit log with an invalid rotation size
+	auditCfg := DefaultCLIConfig()
+	auditCfg.Proxy.AuditLog.MaxSize = "lots"
+	if err := ValidateCLIConfig(auditCfg); err == nil {
+		t.Error("Expected error for invalid audit log size")
+	}
+	
+	// Test a tracing endpoint without a scheme, then a Lambda collector
+	traceCfg := DefaultCLIConfig()
+	traceCfg.Tracing.Endpoint = "localhost:4318"
+	if err := ValidateCLIConfig(traceCfg); err == nil {
+		t.Error("Expected error for tracing endpoint without a scheme")
+	}
+	traceCfg.Tracing.Endpoint = ""
+	traceCfg.Tracing.LambdaEndpoint = "https://otel.example.com"
+	if err := ValidateCLIConfig(traceCfg); err != nil {
+		t.Errorf("Expected Lambda tracing endpoint to be valid, got %v", err)
+	}
+	if settings := traceCfg.ToConfig("bucket").SessionSettings(); settings.Tracing == nil || settings.Tracing.ServiceName != "lambda-nat-proxy-lambda" {
+		t.Errorf("Expected Lambda tracing in session settings, got %+v", settings.Tracing)
+	}
+	
+	// Test anomaly detection with an RTT factor that would flag every ping
+	anomalyCfg := DefaultCLIConfig()
+	anomalyCfg.Proxy.AnomalyDetection.RTTFactor = 0.5
+	if err := ValidateCLIConfig(anomalyCfg); err == nil {
+		t.Error("Expected error for RTT factor below 1")
+	}
+	anomalyCfg.Proxy.AnomalyDetection = AnomalyDetectionConfig{Enabled: true, LossThreshold: 0.5}
+	if err := ValidateCLIConfig(anomalyCfg); err != nil {
+		t.Errorf("Expected anomaly detection to be valid, got %v", err)
+	}
+	if runtimeCfg := anomalyCfg.ToConfig("bucket"); !runtimeCfg.AnomalyDetection || runtimeCfg.Anomaly.LossThreshold != 0.5 {
+		t.Errorf("Expected anomaly detection in config, got %v %+v", runtimeCfg.AnomalyDetection, runtimeCfg.Anomaly)
+	}
+	
+	// Test Lambda metrics with a namespace CloudWatch rejects, then the default namespace
+	metricsCfg := DefaultCLIConfig()
+	metricsCfg.Proxy.LambdaMetrics = LambdaMetricsConfig{Enabled: true, Namespace: "bad namespace"}
+	if err := ValidateCLIConfig(metricsCfg); err == nil {
+		t.Error("Expected error for invalid CloudWatch namespace")
+	}
+	metricsCfg.Proxy.LambdaMetrics = LambdaMetricsConfig{Enabled: true}
+	if err := ValidateCLIConfig(metricsCfg); err != nil {
+		t.Errorf("Expected Lambda metrics to be valid, got %v", err)
+	}
+	if settings := metricsCfg.ToConfig("bucket").SessionSettings(); settings.Metrics == nil || settings.Metrics.Namespace != "LambdaNatProxy" {
+		t.Errorf("Expected Lambda metrics in session settings, got %+v", settings.Metrics)
+	}
+	if settings := DefaultCLIConfig().ToConfig("bucket").SessionSettings(); settings.Metrics != nil {
+		t.Errorf("Expected Lambda metrics off by default, got %+v", settings.Metrics)
+	}
+	
+	// Test a goroutine limit too low to rotate a session
+	goroutineCfg := DefaultCLIConfig()
+	goroutineCfg.Proxy.SessionPool.MaxGoroutines = 2
+	if err := ValidateCLIConfig(goroutineCfg); err == nil {
+		t.Error("Expected error for max goroutines below the minimum")
+	}
+	goroutineCfg.Proxy.SessionPool.MaxGoroutines = 20
+	if err := ValidateCLIConfig(goroutineCfg); err != nil {
+		t.Errorf("Expected max goroutines 20 to be valid, got %v", err)
+	}
+	if runtimeCfg := goroutineCfg.ToConfig("bucket"); runtimeCfg.Rotation.GoroutineLimit() != 20 {
+		t.Errorf("Expected goroutine limit 20 in config, got %d", runtimeCfg.Rotation.GoroutineLimit())
+	}
+	
+	// Test Lambda log forwarding with an unknown level, then a valid one
+	logsCfg := DefaultCLIConfig()
+	logsCfg.Proxy.LambdaLogs = LambdaLogsConfig{Level: "verbose"}
+	if err := ValidateCLIConfig(logsCfg); err == nil {
+		t.Error("Expected error for unknown Lambda log level")
+	}
+	logsCfg.Proxy.LambdaLogs = LambdaLogsConfig{Level: "warn", Rate: 5}
+	if err := ValidateCLIConfig(logsCfg); err != nil {
+		t.Errorf("Expected Lambda log forwarding to be valid, got %v", err)
+	}
+	if settings := logsCfg.ToConfig("bucket").SessionSettings(); settings.Logs == nil || settings.Logs.Level != "warn" || settings.Logs.Rate != 5 {
+		t.Errorf("Expected Lambda log forwarding in session settings, got %+v", settings.Logs)
+	}
+	if settings := DefaultCLIConfig().ToConfig("bucket").SessionSettings(); settings.Logs != nil {
+		t.Errorf("Expected Lambda log forwarding off by default, got %+v", settings.Logs)
+	}
+	
+	// Test notification webhooks with a bad URL, then good ones
+	webhookCfg := DefaultCLIConfig()
+	webhookCfg.Proxy.Webhooks = []string{"hooks.slack.com/services/T0/B0/secret"}
+	if err := ValidateCLIConfig(webhookCfg); err == nil {
+		t.Error("Expected error for a webhook URL without a scheme")
+	}
+	webhookCfg.Proxy.Webhooks = []string{"https://hooks.slack.com/services/T0/B0/secret", "http://127.0.0.1:9000/hook"}
+	if err := ValidateCLIConfig(webhookCfg); err != nil {
+		t.Errorf("Expected webhook URLs to be valid, got %v", err)
+	}
+	if runtimeCfg := webhookCfg.ToConfig("bucket"); len(runtimeCfg.Webhooks) != 2 {
+		t.Errorf("Expected both webhooks in the runtime config, got %v", runtimeCfg.Webhooks)
+	}
+	
+	// Test an HTTP proxy URL with an unsupported scheme
+	proxyCfg := DefaultCLIConfig()
+	proxyCfg.HTTPProxy.URL = "ftp://proxy.corp.example:21"
+	if err := ValidateCLIConfig(proxyCfg); err == nil {
+		t.Error("Expected error for unsupported HTTP proxy scheme")
+	}
+	proxyCfg.HTTPProxy.URL = "http://proxy.corp.example:3128"
+	if err := ValidateCLIConfig(proxyCfg); err != nil {
+		t.Errorf("Expected HTTP proxy to be valid, got %v", err)
+	}
+	
+	// Test an unknown drain policy, then the streams policy
+	drainCfg := DefaultCLIConfig()
+	drainCfg.Proxy.Drain.Policy = "forever"
+	if err := ValidateCLIConfig(drainCfg); err == nil {
+		t.Error("Expected error for unknown drain policy")
+	}
+	drainCfg.Proxy.Drain = DrainConfig{Policy: "streams", MaxWait: 5 * time.Minute}
+	if err := ValidateCLIConfig(drainCfg); err != nil {
+		t.Errorf("Expected streams drain policy to be valid, got %v", err)
+	}
+	if rotation := drainCfg.ToConfig("bucket").Rotation; rotation.DrainPolicy != "streams" || rotation.MaxDrainWait != 5*time.Minute {
+		t.Errorf("Expected drain policy in config, got %+v", rotation)
+	}
+	if rotation := DefaultCLIConfig().ToConfig("bucket").Rotation; rotation.DrainPolicy != "timer" {
+		t.Errorf("Expected timer drain policy by default, got %q", rotation.DrainPolicy)
+	}
+	
+	// Test a DNS stub address without a port
+	stubCfg := DefaultCLIConfig()
+	stubCfg.Proxy.DNSListen = "127.0.0.1"
+	if err := ValidateCLIConfig(stubCfg); err == nil {
+		t.Error("Expected error for DNS listen address without a port")
+	}
+	
+	// Test Lambda DNS with an unsupported scheme, then a DoT upstream
+	dnsCfg := DefaultCLIConfig()
+	dnsCfg.Proxy.LambdaDNS.Upstream = "quic://1.1.1.1"
+	if err := ValidateCLIConfig(dnsCfg); err == nil {
+		t.Error("Expected error for unsupported Lambda DNS scheme")
+	}
+	dnsCfg.Proxy.LambdaDNS.Upstream = "tls://1.1.1.1"
+	if err := ValidateCLIConfig(dnsCfg); err != nil {
+		t.Errorf("Expected DoT upstream to be valid, got %v", err)
+	}
+	if settings := dnsCfg.ToConfig("bucket").SessionSettings(); settings.DNS == nil || settings.DNS.Upstream != "tls://1.1.1.1" {
+		t.Errorf("Expected Lambda DNS in session settings, got %+v", settings.DNS)
+	}
+}
+
+func TestToConfigQUICTuning(t *testing.T) {
+	cfg := DefaultCLIConfig()
+	cfg.Proxy.InitialWindow = 8 * 1024 * 1024
+	cfg.Proxy.QUICConnections = 3
+	
+	converted := cfg.ToConfig("bucket")
+	settings := converted.SessionSettings()
+	
+	if settings.QUIC == nil || settings.QUIC.InitialWindow != 8*1024*1024 {
+		t.Errorf("Expected initial window to be passed to session settings, got %+v", settings.QUIC)
+	}
+	if settings.QUIC.Stripes() != 2 {
+		t.Errorf("Expected 2 extra QUIC connections, got %d", settings.QUIC.Stripes())
+	}
+	if settings.QUIC.CongestionControl != "cubic" {
+		t.Errorf("Expected cubic congestion control, got %s", settings.QUIC.CongestionControl)
+	}
+}
+
+func TestLoadCLIConfigSessionWait(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "wait-config.yaml")
+	if err := os.WriteFile(configFile, []byte("proxy:\n  session_wait: \"3s\"\n"), 0644); err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+	
+	cfg, err := LoadCLIConfig(configFile)
+	if err != nil {
+		t.Fatalf("Expected no error loading config file, got %v", err)
+	}
+	if cfg.Proxy.SessionWait != 3*time.Second {
+		t.Errorf("Expected session wait 3s, got %v", cfg.Proxy.SessionWait)
+	}
+	if converted := cfg.ToConfig("bucket"); converted.SessionWaitTimeout != 3*time.Second {
+		t.Errorf("Expected converted session wait 3s, got %v", converted.SessionWaitTimeout)
+	}
+}
+
+func TestToConfigPunchPorts(t *testing.T) {
+	cfg := DefaultCLIConfig()
+	cfg.Proxy.PunchPorts = "40000-40100"
+	
+	converted := cfg.ToConfig("bucket")
+	if converted.PunchPorts.Min != 40000 || converted.PunchPorts.Max != 40100 {
+		t.Errorf("Expected punch ports 40000-40100, got %s", converted.PunchPorts)
+	}
+	
+	if converted := DefaultCLIConfig().ToConfig("bucket"); !converted.PunchPorts.IsZero() {
+		t.Errorf("Expected unconstrained punch ports by default, got %s", converted.PunchPorts)
+	}
+}
+
+func TestLoadCLIConfigRateLimit(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "rate-config.yaml")
+	content := "proxy:\n  rate_limit:\n    global: \"5MB\"\n    per_destination: \"512KB/s\"\n"
+	if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+	
+	cfg, err := LoadCLIConfig(configFile)
+	if err != nil {
+		t.Fatalf("Expected no error loading config file, got %v", err)
+	}
+	
+	converted := cfg.ToConfig("bucket")
+	if converted.Bandwidth.Global != 5*1024*1024 {
+		t.Errorf("Expected global limit of 5MB, got %d", converted.Bandwidth.Global)
+	}
+	if converted.Bandwidth.PerClient != 0 {
+		t.Errorf("Expected no per-client limit, got %d", converted.Bandwidth.PerClient)
+	}
+	if converted.Bandwidth.PerDestination != 512*1024 {
+		t.Errorf("Expected per-destination limit of 512KB, got %d", converted.Bandwidth.PerDestination)
+	}
+}
+
+func TestLoadCLIConfigProvenance(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "provenance-config.yaml")
+	content := "proxy:\n  port: 1090\n  rate_limit:\n    global: \"5MB\"\n"
+	if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+	t.Setenv("AWS_REGION", "eu-west-1")
+	t.Setenv("MODE", "")
+	t.Setenv("LAMBDA_PROXY_PROXY.RATE_LIMIT.GLOBAL", "1MB")
+	
+	cfg, sources, err := LoadCLIConfigWithProvenance(configFile)
+	if err != nil {
+		t.Fatalf("Expected no error loading config file, got %v", err)
+	}
+	if cfg.AWS.Region != "eu-west-1" || cfg.Proxy.RateLimit.Global != "1MB" {
+		t.Errorf("Expected environment overrides applied, got region %q and rate limit %q", cfg.AWS.Region, cfg.Proxy.RateLimit.Global)
+	}
+	
+	want := map[string]Source{
+		"aws.region":              SourceEnv,
+		"proxy.port":              SourceFile,
+		"proxy.rate_limit.global": SourceEnv,
+		"deployment.mode":         SourceDefault,
+		"proxy.stun_server":       SourceDefault,
+	}
+	for key, source := range want {
+		if got := sources.Source(key); got != source {
+			t.Errorf("Expected %s from %s, got %s", key, source, got)
+		}
+	}
+	sources.SetFlag("proxy.port")
+	if got := sources.Source("proxy.port"); got != SourceFlag {
+		t.Errorf("Expected proxy.port from flag, got %s", got)
+	}
+	
+	keys := make(map[string]bool)
+	for _, setting := range Settings(cfg) {
+		keys[setting.Key] = true
+	}
+	for _, key := range []string{"proxy.acl.allow", "proxy.session_pool.secondaries", "tracing.headers", "http_proxy.url"} {
+		if !keys[key] {
+			t.Errorf("Expected setting %s to be listed", key)
+		}
+	}
+}
+
+func TestToConfigIdleTimeout(t *testing.T) {
+	cfg := DefaultCLIConfig()
+	cfg.Deployment.Mode = ModeTest
+	if converted := cfg.ToConfig("bucket"); converted.TunnelIdleTimeout != 2*time.Minute {
+		t.Errorf("Expected test mode tunnel idle timeout of 2m, got %v", converted.TunnelIdleTimeout)
+	}
+	
+	cfg.Proxy.IdleTimeout = 30 * time.Second
+	if converted := cfg.ToConfig("bucket"); converted.TunnelIdleTimeout != 30*time.Second {
+		t.Errorf("Expected configured tunnel idle timeout of 30s, got %v", converted.TunnelIdleTimeout)
+	}
+}
+
+func TestLoadCLIConfigACL(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "acl-config.yaml")
+	content := "proxy:\n  acl:\n    deny:\n      - \"metadata\"\n      - \":25\"\n"
+	if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+	
+	cfg, err := LoadCLIConfig(configFile)
+	if err != nil {
+		t.Fatalf("Expected no error loading config file, got %v", err)
+	}
+	
+	settings := cfg.ToConfig("bucket").SessionSettings()
+	if settings.ACL == nil || len(settings.ACL.Deny) != 2 {
+		t.Fatalf("Expected 2 deny rules in the session settings, got %+v", settings.ACL)
+	}
+	
+	if DefaultCLIConfig().ToConfig("bucket").SessionSettings().ACL != nil {
+		t.Error("Expected no ACL in the session settings by default")
+	}
+}
+
+func TestValidatePolicyFile(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(policyFile, []byte("rules:\n  - match: [\":25\"]\n    action: deny\n"), 0644); err != nil {
+		t.Fatalf("Failed to create policy file: %v", err)
+	}
+	
+	cfg := DefaultCLIConfig()
+	cfg.Proxy.PolicyFile = policyFile
+	if errors := ValidateCLIConfig(cfg); len(errors) > 0 {
+		t.Errorf("Expected a valid policy file to pass, got %v", errors)
+	}
+	if cfg.ToConfig("bucket").PolicyFile != policyFile {
+		t.Error("Expected the policy file in the runtime config")
+	}
+	
+	cfg.Proxy.RateLimit.Global = "5MB"
+	if errors := ValidateCLIConfig(cfg); len(errors) == 0 {
+		t.Error("Expected an error for rate_limit alongside a policy file")
+	}
+	
+	cfg = DefaultCLIConfig()
+	cfg.Proxy.PolicyFile = filepath.Join(t.TempDir(), "missing.yaml")
+	if errors := ValidateCLIConfig(cfg); len(errors) == 0 {
+		t.Error("Expected an error for a missing policy file")
+	}
+}
+
+func TestValidateBudget(t *testing.T) {
+	cfg := DefaultCLIConfig()
+	cfg.Proxy.Budget = BudgetConfig{MaxTransfer: "50GB", MaxSpend: 5}
+	if errors := ValidateCLIConfig(cfg); len(errors) > 0 {
+		t.Errorf("Expected a valid budget to pass, got %v", errors)
+	}
+	if got := cfg.ToConfig("bucket").Budget; got.Period != BudgetPeriodDay || got.MaxTransfer != 50<<30 || got.MaxSpend != 5 {
+		t.Errorf("Unexpected runtime budget %+v", got)
+	}
+	
+	cfg.Proxy.Budget = BudgetConfig{Period: "week", MaxTransfer: "lots", MaxSpend: -1}
+	if errors := ValidateCLIConfig(cfg); len(errors) != 3 {
+		t.Errorf("Expected 3 budget errors, got %v", errors)
+	}
+}
+
+func TestValidateInvokeFallback(t *testing.T) {
+	cfg := DefaultCLIConfig()
+	cfg.Deployment.StackName = "lambda-nat-proxy-a1b2c3d4"
+	if errors := ValidateCLIConfig(cfg); len(errors) > 0 {
+		t.Errorf("Expected the default invoke fallback to pass, got %v", errors)
+	}
+	runtime := cfg.ToConfig("bucket")
+	if runtime.InvokeFallback != shared.DefaultInvokeFallback || runtime.LambdaFunctionName != "lambda-nat-proxy-a1b2c3d4-lambda" {
+		t.Errorf("Unexpected runtime fallback %v for %q", runtime.InvokeFallback, runtime.LambdaFunctionName)
+	}
+	
+	cfg.Proxy.InvokeFallback = 0
+	if errors := ValidateCLIConfig(cfg); len(errors) > 0 {
+		t.Errorf("Expected 0 to turn the fallback off, got %v", errors)
+	}
+	for _, fallback := range []time.Duration{-time.Second, lambdaResponseTimeout} {
+		cfg.Proxy.InvokeFallback = fallback
+		if errors := ValidateCLIConfig(cfg); len(errors) != 1 {
+			t.Errorf("Expected an error for invoke fallback %v, got %v", fallback, errors)
+		}
+	}
+}
+
+func TestValidateLogLevel(t *testing.T) {
+	cfg := DefaultCLIConfig()
+	if errors := ValidateCLIConfig(cfg); len(errors) > 0 {
+		t.Errorf("Expected the default log level to pass, got %v", errors)
+	}
+	
+	cfg.Proxy.LogLevel = "warn"
+	if errors := ValidateCLIConfig(cfg); len(errors) > 0 {
+		t.Errorf("Expected warn to pass, got %v", errors)
+	}
+	if level := cfg.ToConfig("bucket").LogLevel; level != shared.LevelWarn {
+		t.Errorf("Expected runtime log level warn, got %v", level)
+	}
+	
+	cfg.Proxy.LogLevel = "verbose"
+	if errors := ValidateCLIConfig(cfg); len(errors) != 1 {
+		t.Errorf("Expected an error for an unknown log level, got %v", errors)
+	}
+}
+
+func TestValidateCoordination(t *testing.T) {
+	cfg := DefaultCLIConfig()
+	if coordination := cfg.ToConfig("bucket").Coordination; coordination != shared.CoordinationS3 {
+		t.Errorf("Expected S3 coordination by default, got %q", coordination)
+	}
+	
+	cfg.Deployment.Coordination = shared.CoordinationFunctionURL
+	if errors := ValidateCLIConfig(cfg); len(errors) > 0 {
+		t.Errorf("Expected function_url to pass, got %v", errors)
+	}
+	if coordination := cfg.ToConfig("bucket").Coordination; coordination != shared.CoordinationFunctionURL {
+		t.Errorf("Expected function URL coordination, got %q", coordination)
+	}
+	
+	cfg.Deployment.Coordination = "sqs"
+	if errors := ValidateCLIConfig(cfg); len(errors) != 1 {
+		t.Errorf("Expected an error for an unknown coordination, got %v", errors)
+	}
+}
+
+func TestValidateWarmup(t *testing.T) {
+	cfg := DefaultCLIConfig()
+	for _, warmup := range []time.Duration{0, time.Minute, 5 * time.Minute} {
+		cfg.Deployment.Warmup = warmup
+		if errors := ValidateCLIConfig(cfg); len(errors) > 0 {
+			t.Errorf("Expected warmup %v to pass, got %v", warmup, errors)
+		}
+	}
+	for _, warmup := range []time.Duration{-time.Minute, 30 * time.Second, 90 * time.Second} {
+		cfg.Deployment.Warmup = warmup
+		if errors := ValidateCLIConfig(cfg); len(errors) != 1 {
+			t.Errorf("Expected an error for warmup %v, got %v", warmup, errors)
+		}
+	}
+}
+
+func TestValidateRotation(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     PerformanceMode
+		rotation RotationSettings
+		valid    bool
+	}{
+		{"mode presets", ModeNormal, RotationSettings{}, true},
+		{"shorter sessions", ModeNormal, RotationSettings{SessionTTL: 5 * time.Minute, OverlapWindow: time.Minute}, true},
+		{"past the Lambda timeout", ModeNormal, RotationSettings{SessionTTL: 9*time.Minute + 30*time.Second}, false},
+		{"past the test mode timeout", ModeTest, RotationSettings{SessionTTL: 2 * time.Minute}, false},
+		{"overlap longer than TTL", ModeNormal, RotationSettings{SessionTTL: time.Minute, OverlapWindow: 2 * time.Minute}, false},
+		{"TTL too short", ModeNormal, RotationSettings{SessionTTL: 10 * time.Second, OverlapWindow: 5 * time.Second}, false},
+		{"negative drain", ModeNormal, RotationSettings{DrainTimeout: -time.Second}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultCLIConfig()
+			cfg.Deployment.Mode = tt.mode
+			cfg.Rotation = tt.rotation
+			if errors := ValidateCLIConfig(cfg); (len(errors) == 0) != tt.valid {
+				t.Errorf("Expected valid=%v, got %v", tt.valid, errors)
+			}
+		})
+	}
+	
+	cfg := DefaultCLIConfig()
+	cfg.Rotation.SessionTTL = 5 * time.Minute
+	rotation := cfg.ToConfig("bucket").Rotation
+	if rotation.SessionTTL != 5*time.Minute || rotation.DrainTimeout != GetModeConfigs()[ModeNormal].DrainTimeout {
+		t.Errorf("Expected the TTL override with the mode's drain timeout, got %+v", rotation)
+	}
+}
+
+func TestValidateLambdaTimeout(t *testing.T) {
+	for mode, modeConfig := range GetModeConfigs() {
+		if err := validateLambdaTimeout(mode, time.Duration(modeConfig.LambdaTimeout)*time.Second); err != nil {
+			t.Errorf("Expected the %s mode's Lambda timeout to pass, got %v", mode, err)
+		}
+	}
+	
+	// The Lambda would expire as soon as it started
+	for _, timeout := range []time.Duration{10 * time.Second, shared.LambdaExpiryNotice} {
+		if err := validateLambdaTimeout(ModeTest, timeout); err == nil {
+			t.Errorf("Expected a %v Lambda timeout to be rejected", timeout)
+		}
+	}
+}
+
+func TestValidateFeatures(t *testing.T) {
+	cfg := DefaultCLIConfig()
+	cfg.Features = map[string]bool{"compression": false, "Striping": true}
+	if errors := ValidateCLIConfig(cfg); len(errors) > 0 {
+		t.Errorf("Expected known feature flags to pass, got %v", errors)
+	}
+	cfg.Features["warp_drive"] = true
+	if errors := ValidateCLIConfig(cfg); len(errors) != 1 {
+		t.Errorf("Expected one error for an unknown feature flag, got %v", errors)
+	}
+}
+
+func TestValidateSessionModes(t *testing.T) {
+	cfg := DefaultCLIConfig()
+	cfg.Deployment.SessionModes = []PerformanceMode{ModePerformance, ModeTest}
+	if errors := ValidateCLIConfig(cfg); len(errors) > 0 {
+		t.Errorf("Expected valid session modes to pass, got %v", errors)
+	}
+	cfg.Deployment.SessionModes = []PerformanceMode{"turbo"}
+	if errors := ValidateCLIConfig(cfg); len(errors) != 1 {
+		t.Errorf("Expected an error for an unknown session mode, got %v", errors)
+	}
+
+	runtimeCfg := DefaultCLIConfig().ToConfig("bucket")
+	if settings := runtimeCfg.SessionSettings(); settings.MemoryMB != 0 {
+		t.Errorf("Expected no memory request without an alias, got %d", settings.MemoryMB)
+	}
+	runtimeCfg.SessionAlias = string(runtimeCfg.Mode)
+	if settings := runtimeCfg.SessionSettings(); settings.MemoryMB != runtimeCfg.ModeConfig.LambdaMemory {
+		t.Errorf("Expected sessions on an alias to ask for %dMB, got %d", runtimeCfg.ModeConfig.LambdaMemory, settings.MemoryMB)
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "profiles-config.yaml")
+	content := `aws:
+  region: us-west-2
+deployment:
+  stack_name: lambda-nat-proxy-default
+  mode: normal
+profiles:
+  Work-US:
+    aws:
+      region: us-east-1
+      profile: work
+    deployment:
+      stack_name: lambda-nat-proxy-work
+      mode: performance
+  personal-eu:
+    aws:
+      region: eu-west-1
+`
+	if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test config file: %v", err)
+	}
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("MODE", "")
+	
+	cfg, err := LoadCLIConfig(configFile)
+	if err != nil {
+		t.Fatalf("Expected no error loading config file, got %v", err)
+	}
+	if names := cfg.ProfileNames(); len(names) != 2 {
+		t.Fatalf("Expected two profiles, got %v", names)
+	}
+	
+	keys, err := cfg.ApplyProfile("work-us")
+	if err != nil {
+		t.Fatalf("ApplyProfile failed: %v", err)
+	}
+	if cfg.AWS.Region != "us-east-1" || cfg.AWS.Profile != "work" || cfg.Deployment.StackName != "lambda-nat-proxy-work" || cfg.Deployment.Mode != ModePerformance {
+		t.Errorf("Expected the work-us settings, got %+v %+v", cfg.AWS, cfg.Deployment)
+	}
+	if len(keys) != 4 {
+		t.Errorf("Expected the four keys the profile sets, got %v", keys)
+	}
+	
+	cfg, _ = LoadCLIConfig(configFile)
+	if _, err := cfg.ApplyProfile("personal-eu"); err != nil {
+		t.Fatalf("ApplyProfile failed: %v", err)
+	}
+	if cfg.AWS.Region != "eu-west-1" || cfg.Deployment.StackName != "lambda-nat-proxy-default" {
+		t.Errorf("Expected the profile's region and the file's stack name, got %q and %q", cfg.AWS.Region, cfg.Deployment.StackName)
+	}
+	
+	if _, err := cfg.ApplyProfile("staging"); err == nil || !strings.Contains(err.Error(), "personal-eu") {
+		t.Errorf("Expected an unknown profile error listing the profiles, got %v", err)
+	}
+	if keys, err := cfg.ApplyProfile(""); err != nil || keys != nil {
+		t.Errorf("Expected no profile to change nothing, got %v, %v", keys, err)
+	}
+}
+
+func TestToConfigMatchesNew(t *testing.T) {
+	t.Setenv("MODE", "")
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_S3_BUCKET", "bucket")
+	fromEnv := New()
+	
+	cli := DefaultCLIConfig()
+	cli.Proxy.SessionWait = fromEnv.SessionWaitTimeout
+	fromCLI := cli.ToConfig("bucket")
+	
+	if fromCLI.Mode != fromEnv.Mode || fromCLI.ModeConfig != fromEnv.ModeConfig {
+		t.Errorf("Expected matching modes, got %s and %s", fromCLI.Mode, fromEnv.Mode)
+	}
+	if fromCLI.Rotation != fromEnv.Rotation {
+		t.Errorf("Expected matching rotation config, got %+v and %+v", fromCLI.Rotation, fromEnv.Rotation)
+	}
+	if fromCLI.LambdaResponseTimeout != fromEnv.LambdaResponseTimeout || fromCLI.NATHolePunchTimeout != fromEnv.NATHolePunchTimeout {
+		t.Error("Expected matching Lambda response and hole punch timeouts")
+	}
+	if fromCLI.AWSRegion != fromEnv.AWSRegion || fromCLI.STUNServer != fromEnv.STUNServer || fromCLI.SOCKS5Port != fromEnv.SOCKS5Port {
+		t.Error("Expected matching network defaults")
+	}
+	if fromCLI.MaxConnections != fromEnv.MaxConnections || fromCLI.TunnelIdleTimeout != fromEnv.TunnelIdleTimeout {
+		t.Error("Expected matching proxy defaults")
+	}
+}
+
+func TestToConfigModes(t *testing.T) {
+	for mode, modeConfig := range GetModeConfigs() {
+		cli := DefaultCLIConfig()
+		cli.Deployment.Mode = mode
+		cfg := cli.ToConfig("bucket")
+		if cfg.Mode != mode || cfg.ModeConfig != modeConfig {
+			t.Errorf("Expected %s mode config, got %s", mode, cfg.Mode)
+		}
+		if cfg.Rotation.SessionTTL != modeConfig.SessionTTL || cfg.Rotation.OverlapWindow != modeConfig.OverlapWindow ||
+			cfg.Rotation.DrainTimeout != modeConfig.DrainTimeout {
+			t.Errorf("Expected %s rotation timings from the mode, got %+v", mode, cfg.Rotation)
+		}
+	}
+	
+	// A zero CLIConfig still yields a usable config
+	cfg := (&CLIConfig{}).ToConfig("bucket")
+	if cfg.Mode != ModeNormal || cfg.ModeConfig.SessionTTL == 0 {
+		t.Errorf("Expected normal mode fallback, got %q with TTL %v", cfg.Mode, cfg.ModeConfig.SessionTTL)
+	}
+	if cfg.SOCKS5Port != 1080 || cfg.MaxConnections == 0 || cfg.AWSRegion == "" {
+		t.Errorf("Expected defaults for unset fields, got port %d, max connections %d, region %q",
+			cfg.SOCKS5Port, cfg.MaxConnections, cfg.AWSRegion)
+	}
+	if cfg.SessionWaitTimeout != 0 {
+		t.Errorf("Expected session wait of 0 to mean don't wait, got %v", cfg.SessionWaitTimeout)
+	}
+}
+
+func TestValidateProxyProtocol(t *testing.T) {
+	cfg := DefaultCLIConfig()
+	cfg.Proxy.ProxyProtocol = ProxyProtocolConfig{Enabled: true, TrustedProxies: []string{"10.0.0.0/16", "192.0.2.10"}}
+	if errors := ValidateCLIConfig(cfg); len(errors) > 0 {
+		t.Errorf("Expected trusted proxies to pass, got %v", errors)
+	}
+	if policy := cfg.ToConfig("bucket").ProxyProtocol; !policy.Enabled || len(policy.Trusted) != 2 {
+		t.Errorf("Expected an enabled policy with 2 trusted proxies, got %+v", policy)
+	}
+	
+	cfg.Proxy.ProxyProtocol.TrustedProxies = []string{"load-balancer"}
+	if errors := ValidateCLIConfig(cfg); len(errors) != 1 {
+		t.Errorf("Expected an error for an invalid trusted proxy, got %v", errors)
+	}
+}
+
+func TestValidateListen(t *testing.T) {
+	cfg := DefaultCLIConfig()
+	cfg.Proxy.Listen = "0.0.0.0"
+	if errors := ValidateCLIConfig(cfg); len(errors) > 0 {
+		t.Errorf("Expected 0.0.0.0 to pass, got %v", errors)
+	}
+	
+	cfg.Proxy.Listen = "0.0.0.0:1080"
+	if errors := ValidateCLIConfig(cfg); len(errors) != 1 {
+		t.Errorf("Expected an error for a listen address with a port, got %v", errors)
+	}
+}
+
+func TestValidateS3Objects(t *testing.T) {
+	cfg := DefaultCLIConfig()
+	cfg.Proxy.S3Objects = S3ObjectsConfig{StorageClass: "ONEZONE_IA", Tags: map[string]string{"auto-delete": "true"}}
+	if errors := ValidateCLIConfig(cfg); len(errors) > 0 {
+		t.Errorf("Expected a storage class and tag to pass, got %v", errors)
+	}
+	if storage := cfg.ToConfig("bucket").SessionSettings().Storage; storage == nil || storage.StorageClass != "ONEZONE_IA" {
+		t.Errorf("Expected the storage settings sent with sessions, got %+v", storage)
+	}
+	
+	for _, objects := range []S3ObjectsConfig{
+		{StorageClass: "GLACIER"},
+		{Tags: map[string]string{"aws:createdBy": "me"}},
+	} {
+		cfg.Proxy.S3Objects = objects
+		if errors := ValidateCLIConfig(cfg); len(errors) != 1 {
+			t.Errorf("Expected an error for %+v, got %v", objects, errors)
+		}
+	}
+}
+
+func TestValidateRegion(t *testing.T) {
+	cfg := DefaultCLIConfig()
+	for _, region := range []string{"us-gov-west-1", "cn-northwest-1", "il-central-1"} {
+		cfg.AWS.Region = region
+		if errors := ValidateCLIConfig(cfg); len(errors) > 0 {
+			t.Errorf("Expected %s to pass, got %v", region, errors)
+		}
+	}
+	
+	cfg.AWS.Region = "us-west"
+	if errors := ValidateCLIConfig(cfg); len(errors) != 1 {
+		t.Errorf("Expected an error for a malformed region, got %v", errors)
+	}
+}
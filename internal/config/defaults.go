@@ -3,11 +3,32 @@ package config
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"fmt"
+	"net"
 	"strings"
+	"time"
 	
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/features"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/notify"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/policy"
 	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
 )
 
+// minInitialWindow is the smallest QUIC initial receive window accepted in config
+const minInitialWindow = 64 * 1024
+
+// maxPunchPredictPorts caps port prediction; each predicted port is another
+// punch packet per round, so large values just spray the Lambda's address
+const maxPunchPredictPorts = 64
+
+// maxConnectTimeout caps connect_timeout; a longer dial only holds a stream
+// that the client has usually given up on
+const maxConnectTimeout = 2 * time.Minute
+
+// minPoolGoroutines is the fewest connection manager goroutines that can
+// rotate a session: the monitor, a launch, a promotion check and a drain
+const minPoolGoroutines = 4
+
 // DefaultCLIConfig returns a CLIConfig with all default values
 func DefaultCLIConfig() *CLIConfig {
 	return &CLIConfig{
@@ -16,12 +37,25 @@ func DefaultCLIConfig() *CLIConfig {
 			Profile: "", // Use default AWS credential chain
 		},
 		Deployment: DeploymentConfig{
-			StackName: generateDefaultStackName(),
-			Mode:      ModeNormal,
+			StackName:    generateDefaultStackName(),
+			Mode:         ModeNormal,
+			Coordination: shared.CoordinationS3,
+			Architecture: shared.ArchitectureX86_64,
 		},
 		Proxy: ProxyConfig{
-			Port:       shared.DefaultSOCKS5Port,
-			STUNServer: shared.DefaultSTUNServer,
+			Port:              shared.DefaultSOCKS5Port,
+			Listen:            shared.DefaultSOCKS5Listen,
+			STUNServer:        shared.DefaultSTUNServer,
+			CongestionControl: shared.CongestionCubic,
+			SessionWait:       shared.DefaultSessionWaitTimeout,
+			InvokeFallback:    shared.DefaultInvokeFallback,
+			LogLevel:          "info",
+			MaxConnections:    shared.DefaultMaxConnections,
+			AuditLog: AuditLogConfig{
+				MaxSize:    "100MB",
+				MaxAge:     24 * time.Hour,
+				MaxBackups: 7,
+			},
 		},
 	}
 }
@@ -50,21 +84,9 @@ func ValidateCLIConfig(cfg *CLIConfig) []error {
 			Message: "AWS region cannot be empty",
 		})
 	} else {
-		// Validate AWS region format (basic check)
-		validRegions := []string{
-			"us-east-1", "us-east-2", "us-west-1", "us-west-2",
-			"eu-central-1", "eu-west-1", "eu-west-2", "eu-west-3",
-			"ap-southeast-1", "ap-southeast-2", "ap-northeast-1", "ap-northeast-2",
-			"ca-central-1", "sa-east-1", "ap-south-1",
-		}
-		validRegion := false
-		for _, region := range validRegions {
-			if cfg.AWS.Region == region {
-				validRegion = true
-				break
-			}
-		}
-		if !validRegion {
+		// Any well-formed name is accepted, so new regions and the GovCloud
+		// and China partitions work without a list to update
+		if !shared.ValidRegion(cfg.AWS.Region) {
 			errors = append(errors, &ConfigError{
 				Field:   "aws.region",
 				Value:   cfg.AWS.Region,
@@ -106,6 +128,14 @@ func ValidateCLIConfig(cfg *CLIConfig) []error {
 		})
 	}
 	
+	if cfg.Proxy.Listen != "" && net.ParseIP(cfg.Proxy.Listen) == nil {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.listen",
+			Value:   cfg.Proxy.Listen,
+			Message: "listen address must be an IP address, e.g. 127.0.0.1 or 0.0.0.0",
+		})
+	}
+	
 	// Validate STUN server
 	if cfg.Proxy.STUNServer == "" {
 		errors = append(errors, &ConfigError{
@@ -124,6 +154,506 @@ func ValidateCLIConfig(cfg *CLIConfig) []error {
 		}
 	}
 	
+	if cfg.Proxy.SessionWait < 0 {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.session_wait",
+			Value:   cfg.Proxy.SessionWait,
+			Message: "session wait cannot be negative",
+		})
+	}
+	
+	if cfg.Proxy.InvokeFallback < 0 || cfg.Proxy.InvokeFallback >= lambdaResponseTimeout {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.invoke_fallback",
+			Value:   cfg.Proxy.InvokeFallback,
+			Message: fmt.Sprintf("invoke fallback must be 0 (off) or shorter than the %v Lambda response timeout", lambdaResponseTimeout),
+		})
+	}
+	
+	if cfg.Proxy.LogLevel != "" {
+		if _, err := shared.ParseLogLevel(cfg.Proxy.LogLevel); err != nil {
+			errors = append(errors, &ConfigError{
+				Field:   "proxy.log_level",
+				Value:   cfg.Proxy.LogLevel,
+				Message: "log level must be debug, info, warn or error",
+			})
+		}
+	}
+	
+	if _, err := shared.ParsePortRange(cfg.Proxy.PunchPorts); err != nil {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.punch_ports",
+			Value:   cfg.Proxy.PunchPorts,
+			Message: fmt.Sprintf("punch ports must be a port or range like 40000-40100: %v", err),
+		})
+	}
+	
+	if cfg.Proxy.PunchPredictPorts < 0 || cfg.Proxy.PunchPredictPorts > maxPunchPredictPorts {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.punch_predict_ports",
+			Value:   cfg.Proxy.PunchPredictPorts,
+			Message: fmt.Sprintf("punch predict ports must be between 0 and %d", maxPunchPredictPorts),
+		})
+	}
+	
+	if cfg.Proxy.KeepaliveInterval < 0 {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.keepalive_interval",
+			Value:   cfg.Proxy.KeepaliveInterval,
+			Message: "keepalive interval cannot be negative (0 = 10s)",
+		})
+	}
+	if cfg.Proxy.KeepaliveMinInterval < 0 {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.keepalive_min_interval",
+			Value:   cfg.Proxy.KeepaliveMinInterval,
+			Message: "keepalive minimum interval cannot be negative (0 = 2s)",
+		})
+	} else if cfg.Proxy.KeepaliveInterval > 0 && cfg.Proxy.KeepaliveMinInterval > cfg.Proxy.KeepaliveInterval {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.keepalive_min_interval",
+			Value:   cfg.Proxy.KeepaliveMinInterval,
+			Message: "keepalive minimum interval cannot be longer than keepalive_interval",
+		})
+	}
+	
+	if cfg.Proxy.IdleTimeout < 0 {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.idle_timeout",
+			Value:   cfg.Proxy.IdleTimeout,
+			Message: "idle timeout cannot be negative (0 = mode default)",
+		})
+	}
+	
+	if cfg.Proxy.ConnectTimeout < 0 || cfg.Proxy.ConnectTimeout > maxConnectTimeout {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.connect_timeout",
+			Value:   cfg.Proxy.ConnectTimeout,
+			Message: fmt.Sprintf("connect timeout must be between 0 and %v (0 = default)", maxConnectTimeout),
+		})
+	}
+	
+	if cfg.Proxy.MaxStreamLifetime < 0 {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.max_stream_lifetime",
+			Value:   cfg.Proxy.MaxStreamLifetime,
+			Message: "max stream lifetime cannot be negative (0 = unlimited)",
+		})
+	}
+	
+	if _, err := shared.ParseCompression(cfg.Proxy.Compression); err != nil {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.compression",
+			Value:   cfg.Proxy.Compression,
+			Message: err.Error(),
+		})
+	}
+	
+	if cfg.Proxy.MaxConnections < 0 {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.max_connections",
+			Value:   cfg.Proxy.MaxConnections,
+			Message: "max connections cannot be negative (0 = default)",
+		})
+	}
+	
+	limits := cfg.Proxy.ResourceLimits
+	if limits.MaxGoroutines < 0 {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.resource_limits.max_goroutines",
+			Value:   limits.MaxGoroutines,
+			Message: "max goroutines cannot be negative (0 = no limit)",
+		})
+	}
+	if limits.MaxOpenFiles < 0 {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.resource_limits.max_open_files",
+			Value:   limits.MaxOpenFiles,
+			Message: "max open files cannot be negative (0 = no limit)",
+		})
+	}
+	if _, err := shared.ParseByteSize(limits.MaxMemory); err != nil {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.resource_limits.max_memory",
+			Value:   limits.MaxMemory,
+			Message: fmt.Sprintf("max memory must be a size like 512MB or 1GB: %v", err),
+		})
+	}
+	
+	pool := cfg.Proxy.SessionPool
+	if pool.Secondaries < 0 {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.session_pool.secondaries",
+			Value:   pool.Secondaries,
+			Message: "secondaries cannot be negative (0 = default)",
+		})
+	}
+	if pool.MaxSessions < 0 || (pool.MaxSessions > 0 && pool.MaxSessions < 1+pool.Secondaries) {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.session_pool.max_sessions",
+			Value:   pool.MaxSessions,
+			Message: "max sessions must fit the primary and every secondary (0 = default)",
+		})
+	}
+	if pool.MaxGoroutines < 0 || (pool.MaxGoroutines > 0 && pool.MaxGoroutines < minPoolGoroutines) {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.session_pool.max_goroutines",
+			Value:   pool.MaxGoroutines,
+			Message: fmt.Sprintf("max goroutines must be at least %d to rotate a session (0 = default)", minPoolGoroutines),
+		})
+	}
+	
+	drain := cfg.Proxy.Drain
+	if drain.Policy != "" && drain.Policy != shared.DrainPolicyTimer && drain.Policy != shared.DrainPolicyStreams {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.drain.policy",
+			Value:   drain.Policy,
+			Message: "drain policy must be \"timer\" or \"streams\"",
+		})
+	}
+	if drain.MaxWait < 0 {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.drain.max_wait",
+			Value:   drain.MaxWait,
+			Message: "max drain wait cannot be negative (0 = default 10m)",
+		})
+	}
+	
+	errors = append(errors, validateRotation(cfg)...)
+	
+	for name := range cfg.Features {
+		if _, err := features.Parse(name); err != nil {
+			errors = append(errors, &ConfigError{
+				Field:   "features." + name,
+				Value:   name,
+				Message: err.Error(),
+			})
+		}
+	}
+	
+	budget := cfg.Proxy.Budget
+	if budget.Period != "" && budget.Period != BudgetPeriodDay && budget.Period != BudgetPeriodMonth {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.budget.period",
+			Value:   budget.Period,
+			Message: "budget period must be \"day\" or \"month\"",
+		})
+	}
+	if _, err := shared.ParseByteSize(budget.MaxTransfer); err != nil {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.budget.max_transfer",
+			Value:   budget.MaxTransfer,
+			Message: fmt.Sprintf("transfer cap must be a size like 50GB: %v", err),
+		})
+	}
+	if budget.MaxSpend < 0 {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.budget.max_spend",
+			Value:   budget.MaxSpend,
+			Message: "spend cap cannot be negative (0 = no cap)",
+		})
+	}
+	
+	if relay := cfg.Proxy.Relay; relay != (RelayConfig{}) {
+		if err := relay.Settings().Validate(); err != nil {
+			errors = append(errors, &ConfigError{
+				Field:   "proxy.relay",
+				Value:   relay.URL,
+				Message: err.Error(),
+			})
+		}
+		switch relay.Mode {
+		case "", shared.RelayModeFallback, shared.RelayModeAlways:
+		default:
+			errors = append(errors, &ConfigError{
+				Field:   "proxy.relay.mode",
+				Value:   relay.Mode,
+				Message: "relay mode must be \"fallback\" or \"always\"",
+			})
+		}
+	}
+	
+	for _, rate := range []struct {
+		field string
+		value string
+	}{
+		{"proxy.rate_limit.global", cfg.Proxy.RateLimit.Global},
+		{"proxy.rate_limit.per_client", cfg.Proxy.RateLimit.PerClient},
+		{"proxy.rate_limit.per_destination", cfg.Proxy.RateLimit.PerDestination},
+	} {
+		if _, err := shared.ParseByteRate(rate.value); err != nil {
+			errors = append(errors, &ConfigError{
+				Field:   rate.field,
+				Value:   rate.value,
+				Message: fmt.Sprintf("rate limit must be a byte rate like 5MB or 512KB: %v", err),
+			})
+		}
+	}
+	
+	for i, resolver := range cfg.Proxy.Resolvers {
+		if err := resolver.Rule().Validate(); err != nil {
+			errors = append(errors, &ConfigError{
+				Field:   fmt.Sprintf("proxy.resolvers[%d]", i),
+				Value:   resolver,
+				Message: err.Error(),
+			})
+		}
+	}
+	
+	if _, err := shared.ParseRefusalReply(cfg.Proxy.Refusal.Reply); err != nil {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.refusal.reply",
+			Value:   cfg.Proxy.Refusal.Reply,
+			Message: err.Error(),
+		})
+	}
+	if _, err := shared.ParseTrustedProxies(cfg.Proxy.ProxyProtocol.TrustedProxies); err != nil {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.proxy_protocol.trusted_proxies",
+			Value:   cfg.Proxy.ProxyProtocol.TrustedProxies,
+			Message: err.Error(),
+		})
+	}
+	if _, err := shared.NewACL(cfg.Proxy.ACL.Rules()); err != nil {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.acl",
+			Value:   cfg.Proxy.ACL,
+			Message: err.Error(),
+		})
+	}
+	if cfg.Proxy.PolicyFile != "" {
+		if _, err := policy.Load(cfg.Proxy.PolicyFile); err != nil {
+			errors = append(errors, &ConfigError{
+				Field:   "proxy.policy_file",
+				Value:   cfg.Proxy.PolicyFile,
+				Message: err.Error(),
+			})
+		}
+		if !cfg.Proxy.ACL.Rules().IsZero() || cfg.Proxy.RateLimit != (RateLimitConfig{}) {
+			errors = append(errors, &ConfigError{
+				Field:   "proxy.policy_file",
+				Value:   cfg.Proxy.PolicyFile,
+				Message: "the policy file replaces proxy.acl and proxy.rate_limit; move those settings into it",
+			})
+		}
+	}
+	
+	lambdaDNS := shared.DNSConfig{Upstream: cfg.Proxy.LambdaDNS.Upstream, MaxTTL: cfg.Proxy.LambdaDNS.MaxTTL}
+	if _, err := shared.NewDNSResolver(lambdaDNS); err != nil {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.lambda_dns",
+			Value:   cfg.Proxy.LambdaDNS,
+			Message: err.Error(),
+		})
+	}
+	
+	if cfg.Proxy.LambdaDNS.PinTTL < 0 {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.lambda_dns.pin_ttl",
+			Value:   cfg.Proxy.LambdaDNS.PinTTL,
+			Message: "pin TTL cannot be negative",
+		})
+	}
+	
+	if cfg.Proxy.DNSListen != "" {
+		if _, _, err := net.SplitHostPort(cfg.Proxy.DNSListen); err != nil {
+			errors = append(errors, &ConfigError{
+				Field:   "proxy.dns_listen",
+				Value:   cfg.Proxy.DNSListen,
+				Message: "DNS listen address must be host:port, e.g. 127.0.0.1:5300",
+			})
+		}
+	}
+	
+	proxyTracing, lambdaTracing := cfg.Tracing.Split()
+	if err := proxyTracing.Validate(); err != nil {
+		errors = append(errors, &ConfigError{
+			Field:   "tracing.endpoint",
+			Value:   cfg.Tracing.Endpoint,
+			Message: err.Error(),
+		})
+	}
+	if err := lambdaTracing.Validate(); err != nil {
+		errors = append(errors, &ConfigError{
+			Field:   "tracing.lambda_endpoint",
+			Value:   cfg.Tracing.LambdaEndpoint,
+			Message: err.Error(),
+		})
+	}
+	
+	if err := cfg.HTTPProxy.Settings().Validate(); err != nil {
+		errors = append(errors, &ConfigError{
+			Field:   "http_proxy.url",
+			Value:   cfg.HTTPProxy.URL,
+			Message: err.Error(),
+		})
+	}
+	
+	auditLog := cfg.Proxy.AuditLog
+	if _, err := shared.ParseByteSize(auditLog.MaxSize); err != nil {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.audit_log.max_size",
+			Value:   auditLog.MaxSize,
+			Message: fmt.Sprintf("audit log size must be a size like 100MB: %v", err),
+		})
+	}
+	if auditLog.MaxAge < 0 || auditLog.MaxBackups < 0 {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.audit_log",
+			Value:   auditLog,
+			Message: "audit log max_age and max_backups cannot be negative (0 = no limit)",
+		})
+	}
+	
+	anomalyCfg := cfg.Proxy.AnomalyDetection
+	if anomalyCfg.RTTFactor != 0 && anomalyCfg.RTTFactor <= 1 {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.anomaly_detection.rtt_factor",
+			Value:   anomalyCfg.RTTFactor,
+			Message: "RTT factor must be above 1 (0 = default 2)",
+		})
+	}
+	if anomalyCfg.Sustain < 0 {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.anomaly_detection.sustain",
+			Value:   anomalyCfg.Sustain,
+			Message: "sustain cannot be negative (0 = default 3 samples)",
+		})
+	}
+	if anomalyCfg.LossThreshold < 0 || anomalyCfg.LossThreshold > 1 {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.anomaly_detection.loss_threshold",
+			Value:   anomalyCfg.LossThreshold,
+			Message: "loss threshold must be a fraction from 0 to 1 (0 = default 0.3)",
+		})
+	}
+	if anomalyCfg.ThroughputDrop < 0 || anomalyCfg.ThroughputDrop >= 1 {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.anomaly_detection.throughput_drop",
+			Value:   anomalyCfg.ThroughputDrop,
+			Message: "throughput drop must be a fraction below 1 (0 = default 0.1)",
+		})
+	}
+	
+	if lambdaMetrics := cfg.Proxy.LambdaMetrics.Settings(); lambdaMetrics.Namespace != "" {
+		if err := lambdaMetrics.Validate(); err != nil {
+			errors = append(errors, &ConfigError{
+				Field:   "proxy.lambda_metrics",
+				Value:   cfg.Proxy.LambdaMetrics,
+				Message: err.Error(),
+			})
+		}
+	}
+	
+	if cfg.Proxy.LambdaLogs != (LambdaLogsConfig{}) {
+		lambdaLogs := shared.LogForwarding{Level: cfg.Proxy.LambdaLogs.Level, Rate: cfg.Proxy.LambdaLogs.Rate}
+		if err := lambdaLogs.Validate(); err != nil {
+			errors = append(errors, &ConfigError{
+				Field:   "proxy.lambda_logs",
+				Value:   cfg.Proxy.LambdaLogs,
+				Message: err.Error(),
+			})
+		}
+	}
+	
+	if err := cfg.Proxy.S3Objects.Storage().Validate(); err != nil {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.s3_objects",
+			Value:   cfg.Proxy.S3Objects,
+			Message: err.Error(),
+		})
+	}
+	
+	// Validate notification webhooks
+	for _, webhook := range cfg.Proxy.Webhooks {
+		if err := notify.ValidateWebhook(webhook); err != nil {
+			errors = append(errors, &ConfigError{
+				Field:   "proxy.webhooks",
+				Value:   webhook,
+				Message: err.Error(),
+			})
+		}
+	}
+	
+	// Validate QUIC tuning
+	if err := shared.ValidateCongestionControl(cfg.Proxy.CongestionControl); err != nil {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.congestion_control",
+			Value:   cfg.Proxy.CongestionControl,
+			Message: err.Error(),
+		})
+	}
+	if cfg.Proxy.InitialWindow != 0 && cfg.Proxy.InitialWindow < minInitialWindow {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.initial_window",
+			Value:   cfg.Proxy.InitialWindow,
+			Message: fmt.Sprintf("initial window must be 0 (mode default) or at least %d bytes", minInitialWindow),
+		})
+	}
+	if cfg.Proxy.QUICConnections < 0 || cfg.Proxy.QUICConnections > shared.MaxQUICConnections {
+		errors = append(errors, &ConfigError{
+			Field:   "proxy.quic_connections",
+			Value:   cfg.Proxy.QUICConnections,
+			Message: fmt.Sprintf("QUIC connections per session must be between 0 and %d", shared.MaxQUICConnections),
+		})
+	}
+	
+	if _, err := shared.ParseBlockedTargets(strings.Join(cfg.Deployment.BlockedTargets, ",")); err != nil {
+		errors = append(errors, &ConfigError{
+			Field:   "deployment.blocked_targets",
+			Value:   cfg.Deployment.BlockedTargets,
+			Message: err.Error(),
+		})
+	}
+	
+	switch cfg.Deployment.Coordination {
+	case "", shared.CoordinationS3, shared.CoordinationFunctionURL:
+	default:
+		errors = append(errors, &ConfigError{
+			Field:   "deployment.coordination",
+			Value:   cfg.Deployment.Coordination,
+			Message: fmt.Sprintf("coordination must be %q or %q", shared.CoordinationS3, shared.CoordinationFunctionURL),
+		})
+	}
+	
+	switch cfg.Deployment.TemplateVariant {
+	case "", TemplateStandard, TemplateMinimal, TemplateHardened, TemplateVPC:
+	default:
+		errors = append(errors, &ConfigError{
+			Field:   "deployment.template_variant",
+			Value:   cfg.Deployment.TemplateVariant,
+			Message: "template variant must be " + strings.Join(TemplateVariants, ", "),
+		})
+	}
+	
+	switch cfg.Deployment.Architecture {
+	case "", shared.ArchitectureX86_64, shared.ArchitectureARM64:
+	default:
+		errors = append(errors, &ConfigError{
+			Field:   "deployment.architecture",
+			Value:   cfg.Deployment.Architecture,
+			Message: fmt.Sprintf("architecture must be %q or %q", shared.ArchitectureX86_64, shared.ArchitectureARM64),
+		})
+	}
+	
+	if w := cfg.Deployment.Warmup; w < 0 || (w > 0 && (w < time.Minute || w%time.Minute != 0)) {
+		errors = append(errors, &ConfigError{
+			Field:   "deployment.warmup",
+			Value:   w,
+			Message: "warmup must be 0 (off) or a whole number of minutes",
+		})
+	}
+	
+	for _, mode := range cfg.Deployment.SessionModes {
+		if _, ok := GetModeConfigs()[mode]; !ok {
+			errors = append(errors, &ConfigError{
+				Field:   "deployment.session_modes",
+				Value:   string(mode),
+				Message: "session modes must be test, normal or performance",
+			})
+		}
+	}
+	
 	// Validate stack name
 	if cfg.Deployment.StackName == "" {
 		errors = append(errors, &ConfigError{
@@ -186,4 +716,76 @@ func GetDefaultStackName() string {
 // GetDefaultBucketName returns the default S3 bucket name based on stack name and account ID
 func GetDefaultBucketName(stackName, accountID string) string {
 	return stackName + "-coordination-" + accountID
-}
\ No newline at end of file
+}
+
+// minSessionTTL is the shortest session_ttl accepted, leaving a new session
+// time to launch before it is rotated in turn
+const minSessionTTL = 30 * time.Second
+
+// validateLambdaTimeout rejects a mode whose Lambda timeout leaves no time
+// before the Lambda warns that it expires and stops taking streams
+func validateLambdaTimeout(mode PerformanceMode, timeout time.Duration) error {
+	if timeout > shared.LambdaExpiryNotice {
+		return nil
+	}
+	return &ConfigError{
+		Field:   "deployment.mode",
+		Value:   mode,
+		Message: fmt.Sprintf("the %s mode's Lambda timeout (%v) must be longer than the Lambda's %v expiry notice", mode, timeout, shared.LambdaExpiryNotice),
+	}
+}
+
+// validateRotation checks the rotation timings against each other and the
+// Lambda timeout of the deployed mode
+func validateRotation(cfg *CLIConfig) []error {
+	var errors []error
+	rotation := cfg.Rotation
+	for _, setting := range []struct {
+		field string
+		value time.Duration
+	}{
+		{"rotation.session_ttl", rotation.SessionTTL},
+		{"rotation.overlap_window", rotation.OverlapWindow},
+		{"rotation.drain_timeout", rotation.DrainTimeout},
+	} {
+		if setting.value < 0 {
+			errors = append(errors, &ConfigError{
+				Field:   setting.field,
+				Value:   setting.value,
+				Message: "cannot be negative (0 = mode default)",
+			})
+		}
+	}
+	if len(errors) > 0 {
+		return errors
+	}
+
+	mode, modeConfig, _ := ResolveMode(PerformanceMode(cfg.Deployment.Mode))
+	ttl, overlap, drain := rotation.Resolve(modeConfig)
+	lambdaTimeout := time.Duration(modeConfig.LambdaTimeout) * time.Second
+	if err := validateLambdaTimeout(mode, lambdaTimeout); err != nil {
+		errors = append(errors, err)
+	}
+	if ttl < minSessionTTL {
+		errors = append(errors, &ConfigError{
+			Field:   "rotation.session_ttl",
+			Value:   ttl,
+			Message: fmt.Sprintf("session TTL must be at least %v", minSessionTTL),
+		})
+	}
+	if overlap >= ttl {
+		errors = append(errors, &ConfigError{
+			Field:   "rotation.overlap_window",
+			Value:   overlap,
+			Message: fmt.Sprintf("overlap window must be shorter than the session TTL (%v)", ttl),
+		})
+	}
+	if ttl+drain >= lambdaTimeout {
+		errors = append(errors, &ConfigError{
+			Field:   "rotation.session_ttl",
+			Value:   ttl,
+			Message: fmt.Sprintf("session TTL plus drain timeout (%v) must be shorter than the %s mode's Lambda timeout (%v)", ttl+drain, mode, lambdaTimeout),
+		})
+	}
+	return errors
+}
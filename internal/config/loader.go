@@ -11,6 +11,13 @@ import (
 
 // LoadCLIConfig loads configuration from files, environment, and returns a merged config
 func LoadCLIConfig(configPath string) (*CLIConfig, error) {
+	cfg, _, err := LoadCLIConfigWithProvenance(configPath)
+	return cfg, err
+}
+
+// LoadCLIConfigWithProvenance loads configuration like LoadCLIConfig and also
+// returns where each setting came from
+func LoadCLIConfigWithProvenance(configPath string) (*CLIConfig, Provenance, error) {
 	cfg := DefaultCLIConfig()
 	
 	// Initialize viper
@@ -55,26 +62,25 @@ func LoadCLIConfig(configPath string) (*CLIConfig, error) {
 	// Try to read config file (only if one was found)
 	if foundConfig {
 		if err := v.ReadInConfig(); err != nil {
-			return nil, fmt.Errorf("error reading config file: %w", err)
+			return nil, nil, fmt.Errorf("error reading config file: %w", err)
 		}
 	}
 	
 	// Set environment variable prefix
-	v.SetEnvPrefix("LAMBDA_PROXY")
+	v.SetEnvPrefix(envPrefix)
 	v.AutomaticEnv()
 	
 	// Map environment variables to config keys
-	v.BindEnv("aws.region", "AWS_REGION")
-	v.BindEnv("aws.profile", "AWS_PROFILE")
-	v.BindEnv("deployment.mode", "MODE")
-	v.BindEnv("proxy.port", "SOCKS5_PORT")
+	for key, name := range envBindings {
+		v.BindEnv(key, name)
+	}
 	
 	// Unmarshal into our config struct
 	if err := v.Unmarshal(cfg); err != nil {
-		return nil, fmt.Errorf("error unmarshaling config: %w", err)
+		return nil, nil, fmt.Errorf("error unmarshaling config: %w", err)
 	}
 	
-	return cfg, nil
+	return cfg, provenance(v, cfg), nil
 }
 
 // WriteExampleConfig creates an example configuration file
@@ -86,16 +92,145 @@ func WriteExampleConfig(filePath string) error {
 aws:
   region: "us-west-2"           # AWS region to use
   profile: ""                   # AWS profile (leave empty for default credential chain)
+  regions: []                   # More regions searched by 'stacks list' and --stack
 
 # Deployment Configuration  
 deployment:
   stack_name: "lambda-nat-proxy-a1b2c3d4"  # CloudFormation stack name (unique suffix auto-generated)
   mode: "normal"                # Performance mode: test, normal, performance
+  blocked_targets: []           # Destinations the Lambda never dials (empty = loopback, link-local, metadata; ["none"] = off)
+  coordination: "s3"            # How sessions reach the Lambda: s3 (bucket + notification) or function_url (IAM-authenticated URL, lower latency)
+  architecture: "x86_64"        # Lambda instruction set: x86_64, or arm64 (Graviton, about 20% cheaper per GB-second; redeploy)
+  warmup: 0s                    # Invoke the Lambda on an EventBridge schedule this often to avoid cold starts, e.g. 5m (0s = off; redeploy)
+  session_modes: []             # Other modes published as Lambda aliases for 'run --mode', e.g. [performance] (redeploy)
+  session_credentials: false    # Answer each session with STS credentials scoped to it; the execution role loses S3 write access (redeploy)
+  template_overlay: ""          # YAML merged into the CloudFormation template at deploy (extra resources, policies, lifecycle rules)
+  template_variant: "standard"  # Infrastructure flavor: standard, minimal, hardened (KMS, log retention, alarms) or vpc (fixed egress IP; redeploy)
 
 # Proxy Configuration
 proxy:
   port: 1080                    # SOCKS5 proxy port (standard SOCKS port)
+  listen: "127.0.0.1"           # SOCKS5 bind address; "0.0.0.0" or "::" lets other machines use the proxy
   stun_server: "stun.l.google.com:19302"  # STUN server for NAT traversal
+  enable_datagrams: false       # Relay small SOCKS5 UDP packets (e.g. DNS) over QUIC datagrams
+  pipeline_connect: false       # Answer CONNECT before the Lambda connects, saving a round trip per connection
+  congestion_control: "cubic"   # QUIC congestion controller (only cubic is available in the bundled quic-go)
+  initial_window: 0             # Initial QUIC stream receive window in bytes (0 = mode default)
+  quic_connections: 1           # QUIC connections per session, striping tunnels across them (up to 8)
+  session_wait: "10s"           # How long new connections wait for a session during launch/failover (0 = don't wait)
+  invoke_fallback: "5s"         # Invoke the Lambda directly if it hasn't answered this long after the S3 write (0 = never)
+  log_level: "info"             # Log level: debug, info, warn or error (reloaded without a restart)
+  privacy_mode: false           # Show public IPs as <ip> in logs and the dashboard
+  notifications: false          # Desktop notifications when the egress IP changes, a session is lost or the budget runs low
+  webhooks: []                  # URLs that get the same notifications as JSON POSTs; Slack and Discord webhook URLs get their format
+  punch_ports: ""               # Local UDP port or range for STUN/hole punching, e.g. "40000-40100" (empty = any)
+  punch_predict_ports: 0        # Also punch this many ports either side of the Lambda's port, for symmetric NATs (0 = off)
+  keepalive_interval: 10s       # How often sessions are pinged to keep NAT mappings alive
+  keepalive_min_interval: 2s    # Shortest interval missed pings bring it down to (= keepalive_interval to stop adapting)
+  idle_timeout: 0               # Close SOCKS5 tunnels idle this long, e.g. "5m" (0 = mode default: 2m/10m/15m)
+  connect_timeout: 0            # How long the Lambda may spend dialing a destination, e.g. "3s" (0 = 10s)
+  max_stream_lifetime: 0        # Close SOCKS5 tunnels open this long, however busy, e.g. "1h" (0 = unlimited)
+  compression: "off"            # Compress tunnel payloads to save uplink: "off" or "deflate"
+  max_connections: 1024         # Concurrent SOCKS5 connections before new clients are refused
+  session_pool:                 # Lambda sessions kept at once (0 = defaults)
+    secondaries: 0              # Secondaries alongside the primary (default 1)
+    max_sessions: 0             # All sessions including draining ones (default 2)
+    max_goroutines: 0           # Launches, promotions and drains in flight at once (default 50, at least 4)
+  drain:                        # When the previous primary is shut down after a rotation
+    policy: timer               # timer (after the mode's drain timeout) or streams (once its streams close)
+    max_wait: 0s                # Longest a streams drain waits (0 = 10m)
+  budget:                       # Stop launching sessions past a cap until the period ends (empty or 0 = no cap)
+    period: day                 # day or month, in UTC
+    max_transfer: ""            # Bytes tunnelled per period, e.g. "50GB"
+    max_spend: 0                # Estimated US dollars per period, e.g. 5
+  relay:                        # Run sessions over TCP through 'lambda-nat-proxy relay' when UDP is blocked (empty url = off; redeploy)
+    url: ""                     # tcp://host:port or tls://host:port
+    token: ""                   # The token the relay was started with
+    fingerprint: ""             # SHA-256 of a self-signed relay's certificate, as the relay prints it
+    mode: fallback              # fallback (once a launch finds UDP blocked) or always
+  resource_limits:              # Ceilings for this process (0 or empty = no limit); past one, new connections wait and idle tunnels close
+    max_goroutines: 0
+    max_open_files: 0
+    max_memory: ""              # e.g. "512MB"
+  refusal:                      # What clients refused by the ACL, max_connections or a resource limit are told
+    reply: "not-allowed"        # SOCKS5 reply: not-allowed, general-failure, network-unreachable, host-unreachable, connection-refused
+    while_paused: false         # Refuse instead of queueing new connections while over a resource limit
+    http_page: false            # Show HTTP clients a 403 page explaining the refusal
+  proxy_protocol:               # PROXY protocol (v1/v2) headers from a load balancer in front of the SOCKS5 port
+    enabled: false              # Use the header's client address for tracking, policy, limits and audit logs
+    trusted_proxies: []         # Balancer IPs or CIDRs whose headers are believed (empty = any peer)
+  rate_limit:                   # Bandwidth caps per second, upload + download combined (empty = unlimited)
+    global: ""                  # Across all connections, e.g. "5MB"
+    per_client: ""              # Per SOCKS5 client IP
+    per_destination: ""         # Per destination host
+  acl:                          # Destination rules, checked here and again on the Lambda
+    default: "allow"            # Policy for destinations no rule matches ("allow" or "deny")
+    allow: []                   # Exceptions to deny rules, e.g. ["10.1.2.3:443"]
+    deny:                       # IPs, CIDRs, domains ("*.example.com"), ports (":25") or aliases
+      - "metadata"              # 169.254.169.254 and other cloud metadata endpoints
+  policy_file: ""               # Policy document with routing, allow/deny rules, limits and quotas; replaces acl and rate_limit
+  resolvers: []                 # Split-horizon DNS: resolve some domains locally instead of on the Lambda, e.g.
+                                #   - domains: ["*.corp.example.com"]
+                                #     server: "10.0.0.53"   # DNS server (empty = system resolver)
+                                #     route: "direct"       # Dial the answer from here ("direct") or via the Lambda ("tunnel")
+  lambda_dns:                   # Resolver used by the Lambda for target domains
+    upstream: ""                # Empty = Lambda's system resolver; or "1.1.1.1", "tls://1.1.1.1", "https://1.1.1.1/dns-query"
+    max_ttl: 5m                 # Cache answers for their TTL, but never longer than this
+    pin_ttl: 0s                 # Send every connection to a domain to one resolved address for this long (0 = off)
+  dns_listen: ""                # Local DNS server resolving through the Lambda, e.g. "127.0.0.1:5300" (empty = off)
+  audit_log:                    # One JSON line per proxied connection and session event, for usage accounting
+    path: ""                    # e.g. "audit.jsonl" (empty = off)
+    max_size: "100MB"           # Rotate once the file would grow past this (empty = no limit)
+    max_age: 24h                # Rotate once the file has been open this long (0 = no limit)
+    max_backups: 7              # Rotated files to keep (0 = all)
+  anomaly_detection:            # Flag session degradations on the dashboard before sessions go unhealthy
+    enabled: false
+    rtt_factor: 0               # RTT above this multiple of the session's usual RTT (0 = 2)
+    sustain: 0                  # Consecutive samples before flagging (0 = 3)
+    loss_threshold: 0           # Fraction of the last 10 pings lost (0 = 0.3)
+    throughput_drop: 0          # Byte rate below this fraction of usual with connections open (0 = 0.1)
+    alert_command: ""           # Shell command run when an anomaly starts or resolves, with LNP_ANOMALY_* set
+  lambda_metrics:               # CloudWatch metrics from the Lambda, written to its log in embedded metric format
+    enabled: false
+    namespace: ""               # CloudWatch namespace (empty = LambdaNatProxy)
+    interval: 0s                # How often each session publishes (0 = 1m)
+  lambda_logs:                  # The Lambda's log lines, shown in this proxy's log
+    level: ""                   # Lowest level sent: debug, info, warn or error (empty = off; run --debug = debug)
+    rate: 0                     # Lines per second per session (0 = 20)
+  s3_objects:                   # How coordination and response objects are stored (needs s3:PutObjectTagging for tags)
+    storage_class: ""           # STANDARD, STANDARD_IA, ONEZONE_IA, INTELLIGENT_TIERING, GLACIER_IR or REDUCED_REDUNDANCY (empty = bucket default)
+    tags: {}                    # Tags for bucket lifecycle rules to filter on, e.g. {auto-delete: "true"}
+
+tracing:                        # OpenTelemetry spans over OTLP/HTTP (empty endpoint = off)
+  endpoint: ""                  # Collector for the proxy's spans, e.g. "http://localhost:4318"
+  lambda_endpoint: ""           # Collector reachable from AWS for the Lambda's spans
+  service_name: ""              # Default "lambda-nat-proxy"; the Lambda adds "-lambda"
+  sample_rate: 1                # Fraction of new launches and connections traced, 0 to 1 (unset = 1, all)
+
+rotation:                       # Session timings (0 = the deployment mode's preset)
+  session_ttl: 0                # How long each Lambda session is used before it's rotated, e.g. "5m"
+  overlap_window: 0             # How long before a session expires its replacement is launched
+  drain_timeout: 0              # How long a replaced session keeps its open tunnels; TTL plus drain must fit the Lambda timeout
+
+features:                       # Switch experimental subsystems off without a redeploy (all on by default); also at /api/features
+  datagrams: true               # UDP over QUIC datagrams, when proxy.enable_datagrams negotiated them
+  compression: true             # Tunnel payload compression, when proxy.compression is set
+  striping: true                # Extra QUIC connections per session, when proxy.quic_connections is set
+
+http_proxy:                     # Proxy for AWS API calls and other outbound HTTPS (empty = HTTPS_PROXY/NO_PROXY from the environment)
+  url: ""                       # e.g. "http://proxy.corp.example.com:3128"
+  no_proxy: ""                  # Comma-separated hosts reached directly
+
+# Named deployments, selected with --profile <name> or LAMBDA_PROXY_PROFILE.
+# Each may set any aws and deployment setting; the rest come from above.
+profiles: {}
+#  work-us:
+#    aws:
+#      region: "us-east-1"
+#      profile: "work"
+#    deployment:
+#      stack_name: "lambda-nat-proxy-work"
+#      mode: "performance"
 `
 	
 	// Create directory if it doesn't exist
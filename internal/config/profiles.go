@@ -0,0 +1,66 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ProfileEnv selects a profile when --profile isn't given
+const ProfileEnv = "LAMBDA_PROXY_PROFILE"
+
+// ProfileNames returns the names of the configured profiles, sorted
+func (c *CLIConfig) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ApplyProfile overrides the aws and deployment settings with those of the
+// named profile and returns the keys it set. Names match case-insensitively,
+// since the config loader lowercases them. An empty name does nothing.
+func (c *CLIConfig) ApplyProfile(name string) ([]string, error) {
+	if name == "" {
+		return nil, nil
+	}
+	var profile *ProfileConfig
+	for profileName, p := range c.Profiles {
+		if strings.EqualFold(profileName, name) {
+			p := p
+			profile = &p
+			break
+		}
+	}
+	if profile == nil {
+		if len(c.Profiles) == 0 {
+			return nil, fmt.Errorf("unknown profile %q: the config file defines no profiles", name)
+		}
+		return nil, fmt.Errorf("unknown profile %q (available: %s)", name, strings.Join(c.ProfileNames(), ", "))
+	}
+
+	overlay := &CLIConfig{AWS: profile.AWS, Deployment: profile.Deployment}
+	var keys []string
+	for _, setting := range Settings(overlay) {
+		if isSet(reflect.ValueOf(setting.Value)) {
+			keys = append(keys, setting.Key)
+		}
+	}
+	c.Merge(overlay)
+	return keys, nil
+}
+
+// isSet reports whether Merge would take v from the overriding config
+func isSet(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() > 0
+	case reflect.Invalid:
+		return false
+	default:
+		return !v.IsZero()
+	}
+}
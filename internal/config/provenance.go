@@ -0,0 +1,128 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Source says where a configuration value came from
+type Source string
+
+// Configuration sources, in increasing order of precedence
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceEnv     Source = "env"
+	SourceProfile Source = "profile"
+	SourceFlag    Source = "flag"
+)
+
+// envPrefix prefixes the environment variables that override any setting
+const envPrefix = "LAMBDA_PROXY"
+
+// envBindings are settings read from conventional environment variables
+// instead of prefixed ones
+var envBindings = map[string]string{
+	"aws.region":      "AWS_REGION",
+	"aws.profile":     "AWS_PROFILE",
+	"deployment.mode": "MODE",
+	"proxy.port":      "SOCKS5_PORT",
+}
+
+// Provenance records the source of each setting, keyed like "proxy.port"
+type Provenance map[string]Source
+
+// Source returns where key came from, SourceDefault if it wasn't recorded
+func (p Provenance) Source(key string) Source {
+	if source, ok := p[key]; ok {
+		return source
+	}
+	return SourceDefault
+}
+
+// SetProfile records that the selected profile set key
+func (p Provenance) SetProfile(key string) {
+	p[key] = SourceProfile
+}
+
+// SetFlag records that a command line flag set key
+func (p Provenance) SetFlag(key string) {
+	p[key] = SourceFlag
+}
+
+// Setting is one leaf value of a CLIConfig
+type Setting struct {
+	Key   string
+	Value interface{}
+}
+
+// Settings lists every leaf value of cfg in declaration order, keyed by
+// its YAML path. Profiles are listed by name.
+func Settings(cfg *CLIConfig) []Setting {
+	var settings []Setting
+	flattenSettings("", reflect.ValueOf(cfg).Elem(), &settings)
+	return settings
+}
+
+func flattenSettings(prefix string, v reflect.Value, settings *[]Setting) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+		value := v.Field(i)
+		if value.Kind() == reflect.Struct && value.Type() != reflect.TypeOf(time.Time{}) {
+			flattenSettings(key, value, settings)
+			continue
+		}
+		// Maps of sections, like profiles, list each entry's settings by name
+		if value.Kind() == reflect.Map && value.Type().Elem().Kind() == reflect.Struct {
+			names := make([]string, 0, value.Len())
+			for _, name := range value.MapKeys() {
+				names = append(names, name.String())
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				flattenSettings(key+"."+name, value.MapIndex(reflect.ValueOf(name)), settings)
+			}
+			continue
+		}
+		*settings = append(*settings, Setting{Key: key, Value: value.Interface()})
+	}
+}
+
+// envName returns the environment variable that overrides key
+func envName(key string) string {
+	if name, ok := envBindings[key]; ok {
+		return name
+	}
+	return envPrefix + "_" + strings.ToUpper(key)
+}
+
+// provenance works out the source of each of cfg's settings after v has
+// read the config file and environment
+func provenance(v *viper.Viper, cfg *CLIConfig) Provenance {
+	p := make(Provenance)
+	for _, setting := range Settings(cfg) {
+		_, bound := envBindings[setting.Key]
+		inFile := v.InConfig(setting.Key)
+		// Viper only applies prefixed variables to settings in the file
+		if os.Getenv(envName(setting.Key)) != "" && (bound || inFile) {
+			p[setting.Key] = SourceEnv
+		} else if inFile {
+			p[setting.Key] = SourceFile
+		}
+	}
+	return p
+}
@@ -2,6 +2,10 @@ package config
 
 import (
 	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/anomaly"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/audit"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
 )
 
 // CLIConfig represents the complete configuration for lambda-nat-proxy CLI
@@ -14,24 +18,414 @@ type CLIConfig struct {
 	
 	// Proxy configuration
 	Proxy ProxyConfig `yaml:"proxy" json:"proxy"`
+	
+	// Tracing configuration
+	Tracing TracingConfig `yaml:"tracing" json:"tracing"`
+	
+	// HTTPProxy routes AWS API calls and other outbound HTTPS through a proxy
+	HTTPProxy HTTPProxyConfig `yaml:"http_proxy" json:"http_proxy"`
+	
+	// Rotation overrides the performance mode's session lifetime and rotation timings
+	Rotation RotationSettings `yaml:"rotation" json:"rotation"`
+	
+	// Features switches experimental subsystems on or off by flag name;
+	// flags left out are on
+	Features map[string]bool `yaml:"features" json:"features" mapstructure:"features"`
+	
+	// Profiles are named deployments selected with --profile, each
+	// overriding the aws and deployment settings above
+	Profiles map[string]ProfileConfig `yaml:"profiles" json:"profiles" mapstructure:"profiles"`
+}
+
+// ProfileConfig holds the settings of one named profile. Empty fields keep
+// the values from the rest of the file.
+type ProfileConfig struct {
+	AWS        AWSConfig        `yaml:"aws" json:"aws" mapstructure:"aws"`
+	Deployment DeploymentConfig `yaml:"deployment" json:"deployment" mapstructure:"deployment"`
 }
 
 // AWSConfig holds AWS-specific settings
 type AWSConfig struct {
 	Region  string `yaml:"region" json:"region" mapstructure:"region"`
 	Profile string `yaml:"profile" json:"profile" mapstructure:"profile"`
+	
+	// Regions are searched for deployed stacks along with Region, by
+	// 'stacks list' and --stack
+	Regions []string `yaml:"regions" json:"regions" mapstructure:"regions"`
 }
 
 // DeploymentConfig holds deployment settings
 type DeploymentConfig struct {
 	StackName string          `yaml:"stack_name" json:"stack_name" mapstructure:"stack_name"`
 	Mode      PerformanceMode `yaml:"mode" json:"mode" mapstructure:"mode"`
+	
+	// BlockedTargets replaces the deny rules the Lambda applies to every
+	// session (empty = loopback, link-local and metadata; ["none"] = off)
+	BlockedTargets []string `yaml:"blocked_targets" json:"blocked_targets" mapstructure:"blocked_targets"`
+	
+	// Coordination is how sessions reach the Lambda: "s3" writes to the
+	// coordination bucket, "function_url" POSTs to an IAM-authenticated
+	// function URL created by deploy
+	Coordination string `yaml:"coordination" json:"coordination" mapstructure:"coordination"`
+	
+	// Architecture is the Lambda's instruction set, "x86_64" or "arm64"
+	// (Graviton), chosen at deploy time
+	Architecture string `yaml:"architecture" json:"architecture" mapstructure:"architecture"`
+	
+	// Warmup has an EventBridge schedule invoke the Lambda this often so an
+	// initialized execution environment is ready for the next session,
+	// avoiding its cold start (0 = off, otherwise whole minutes)
+	Warmup time.Duration `yaml:"warmup" json:"warmup" mapstructure:"warmup"`
+	
+	// SessionModes are further performance modes published as Lambda
+	// aliases with their own memory and timeout, so 'run --mode' can use
+	// them without redeploying the function
+	SessionModes []PerformanceMode `yaml:"session_modes" json:"session_modes" mapstructure:"session_modes"`
+	
+	// SessionCredentials has the orchestrator mint each Lambda STS credentials
+	// that can only answer its own session, and deploy takes S3 write access
+	// away from the execution role
+	SessionCredentials bool `yaml:"session_credentials" json:"session_credentials" mapstructure:"session_credentials"`
+	
+	// TemplateOverlay is a YAML file merged into the generated CloudFormation
+	// template at deploy time, to add resources, policy statements or rules
+	TemplateOverlay string `yaml:"template_overlay" json:"template_overlay" mapstructure:"template_overlay"`
+	
+	// TemplateVariant picks the flavor of CloudFormation template deploy
+	// creates: TemplateStandard, TemplateMinimal, TemplateHardened or
+	// TemplateVPC (empty = standard)
+	TemplateVariant string `yaml:"template_variant" json:"template_variant" mapstructure:"template_variant"`
+}
+
+// HTTPProxyConfig sends outbound HTTP(S) through URL, except to the
+// comma-separated NoProxy hosts. Empty fields fall back to the HTTP_PROXY,
+// HTTPS_PROXY and NO_PROXY environment variables. The tunnel is UDP and can't
+// use the proxy, so behind one only deploy, status and destroy may work.
+type HTTPProxyConfig struct {
+	URL     string `yaml:"url" json:"url" mapstructure:"url"`
+	NoProxy string `yaml:"no_proxy" json:"no_proxy" mapstructure:"no_proxy"`
+}
+
+// RotationSettings override the performance mode's SessionTTL, OverlapWindow
+// and DrainTimeout (0 = the mode's preset). A session must expire and drain
+// before the Lambda's timeout ends it.
+type RotationSettings struct {
+	SessionTTL    time.Duration `yaml:"session_ttl" json:"session_ttl" mapstructure:"session_ttl"`
+	OverlapWindow time.Duration `yaml:"overlap_window" json:"overlap_window" mapstructure:"overlap_window"`
+	DrainTimeout  time.Duration `yaml:"drain_timeout" json:"drain_timeout" mapstructure:"drain_timeout"`
+}
+
+// Resolve returns the timings in effect for mode, taking its preset for
+// those left at zero
+func (r RotationSettings) Resolve(mode ModeConfig) (ttl, overlap, drain time.Duration) {
+	ttl, overlap, drain = mode.SessionTTL, mode.OverlapWindow, mode.DrainTimeout
+	if r.SessionTTL != 0 {
+		ttl = r.SessionTTL
+	}
+	if r.OverlapWindow != 0 {
+		overlap = r.OverlapWindow
+	}
+	if r.DrainTimeout != 0 {
+		drain = r.DrainTimeout
+	}
+	return ttl, overlap, drain
+}
+
+// Settings converts the proxy settings
+func (h HTTPProxyConfig) Settings() shared.HTTPProxyConfig {
+	return shared.HTTPProxyConfig{URL: h.URL, NoProxy: h.NoProxy}
+}
+
+// TracingConfig sends OpenTelemetry spans to OTLP/HTTP collectors. Endpoint
+// receives the proxy's spans and LambdaEndpoint, which must be reachable from
+// AWS, the Lambda's (empty = off for that side). SampleRate is the fraction
+// of new launches and connections traced, from 0 to 1; unset (0) traces all
+// of them, as shared.DefaultTraceSampleRate. Headers are added to every
+// export and reach the Lambda through the S3 coordination bucket.
+type TracingConfig struct {
+	Endpoint       string            `yaml:"endpoint" json:"endpoint" mapstructure:"endpoint"`
+	LambdaEndpoint string            `yaml:"lambda_endpoint" json:"lambda_endpoint" mapstructure:"lambda_endpoint"`
+	ServiceName    string            `yaml:"service_name" json:"service_name" mapstructure:"service_name"`
+	SampleRate     float64           `yaml:"sample_rate" json:"sample_rate" mapstructure:"sample_rate"`
+	Headers        map[string]string `yaml:"headers" json:"headers" mapstructure:"headers"`
 }
 
 // ProxyConfig holds proxy settings
 type ProxyConfig struct {
-	Port       int    `yaml:"port" json:"port" mapstructure:"port"`
-	STUNServer string `yaml:"stun_server" json:"stun_server" mapstructure:"stun_server"`
+	Port            int    `yaml:"port" json:"port" mapstructure:"port"`
+	STUNServer      string `yaml:"stun_server" json:"stun_server" mapstructure:"stun_server"`
+	EnableDatagrams bool   `yaml:"enable_datagrams" json:"enable_datagrams" mapstructure:"enable_datagrams"`
+
+	// Listen is the IP address the SOCKS5 server binds to; "0.0.0.0" or "::" lets other machines use the proxy (empty = 127.0.0.1)
+	Listen string `yaml:"listen" json:"listen" mapstructure:"listen"`
+
+	// PipelineConnect answers CONNECT as soon as the target is sent, so the client's first request saves a round trip
+	PipelineConnect bool `yaml:"pipeline_connect" json:"pipeline_connect" mapstructure:"pipeline_connect"`
+
+	// QUIC transport tuning, applied to both the orchestrator and the Lambda
+	CongestionControl string `yaml:"congestion_control" json:"congestion_control" mapstructure:"congestion_control"`
+	InitialWindow     uint64 `yaml:"initial_window" json:"initial_window" mapstructure:"initial_window"`
+
+	// QUICConnections is how many QUIC connections each session opens to the Lambda, striping tunnel streams across them (0 or 1 = one)
+	QUICConnections int `yaml:"quic_connections" json:"quic_connections" mapstructure:"quic_connections"`
+
+	// SessionWait is how long new connections wait for a session during launch or failover (0 = don't wait)
+	SessionWait time.Duration `yaml:"session_wait" json:"session_wait" mapstructure:"session_wait"`
+
+	// InvokeFallback invokes the Lambda directly when it hasn't answered this long after the S3 write, in case the S3 notification is late (0 = never)
+	InvokeFallback time.Duration `yaml:"invoke_fallback" json:"invoke_fallback" mapstructure:"invoke_fallback"`
+
+	// LogLevel is the level the proxy logs at: debug, info, warn or error
+	LogLevel string `yaml:"log_level" json:"log_level" mapstructure:"log_level"`
+
+	// PrivacyMode replaces the laptop's and Lambdas' public IP addresses with <ip> in logs and the dashboard
+	PrivacyMode bool `yaml:"privacy_mode" json:"privacy_mode" mapstructure:"privacy_mode"`
+
+	// Notifications shows desktop notifications when the egress IP changes, a session is lost or the budget runs low
+	Notifications bool `yaml:"notifications" json:"notifications" mapstructure:"notifications"`
+
+	// Webhooks receive the same notifications, and a few more, as JSON POSTs; Slack and Discord webhook URLs get their own format
+	Webhooks []string `yaml:"webhooks" json:"webhooks" mapstructure:"webhooks"`
+
+	// PunchPorts pins ("40000") or constrains ("40000-40100") the local UDP port used for STUN and hole punching
+	PunchPorts string `yaml:"punch_ports" json:"punch_ports" mapstructure:"punch_ports"`
+
+	// PunchPredictPorts also punches this many ports either side of the Lambda's reported port (0 = off)
+	PunchPredictPorts int `yaml:"punch_predict_ports" json:"punch_predict_ports" mapstructure:"punch_predict_ports"`
+
+	// KeepaliveInterval is how often sessions are pinged to keep their NAT mappings
+	// alive; missed pings shorten it down to KeepaliveMinInterval (0 = 10s and 2s)
+	KeepaliveInterval    time.Duration `yaml:"keepalive_interval" json:"keepalive_interval" mapstructure:"keepalive_interval"`
+	KeepaliveMinInterval time.Duration `yaml:"keepalive_min_interval" json:"keepalive_min_interval" mapstructure:"keepalive_min_interval"`
+
+	// IdleTimeout closes SOCKS5 tunnels with no traffic in either direction for this long (0 = mode default)
+	IdleTimeout time.Duration `yaml:"idle_timeout" json:"idle_timeout" mapstructure:"idle_timeout"`
+
+	// ConnectTimeout bounds how long the Lambda, or a direct route, spends dialing a destination (0 = 10s default)
+	ConnectTimeout time.Duration `yaml:"connect_timeout" json:"connect_timeout" mapstructure:"connect_timeout"`
+
+	// MaxStreamLifetime closes SOCKS5 tunnels open this long, however busy (0 = unlimited)
+	MaxStreamLifetime time.Duration `yaml:"max_stream_lifetime" json:"max_stream_lifetime" mapstructure:"max_stream_lifetime"`
+
+	// Compression compresses tunnel payloads on the wire: "off" (default) or "deflate"
+	Compression string `yaml:"compression" json:"compression" mapstructure:"compression"`
+
+	// MaxConnections caps concurrent SOCKS5 connections; extra clients get the refusal reply (0 = default)
+	MaxConnections int `yaml:"max_connections" json:"max_connections" mapstructure:"max_connections"`
+
+	// SessionPool sizes the pool of Lambda sessions kept by the connection manager
+	SessionPool SessionPoolConfig `yaml:"session_pool" json:"session_pool" mapstructure:"session_pool"`
+
+	// ResourceLimits caps the proxy's own goroutines, open files and memory, degrading gracefully past them
+	ResourceLimits ResourceLimitsConfig `yaml:"resource_limits" json:"resource_limits" mapstructure:"resource_limits"`
+
+	// Refusal sets what clients refused by the ACL, max_connections or a resource limit are told
+	Refusal RefusalConfig `yaml:"refusal" json:"refusal" mapstructure:"refusal"`
+
+	// ProxyProtocol accepts PROXY protocol headers from load balancers in front of the SOCKS5 port
+	ProxyProtocol ProxyProtocolConfig `yaml:"proxy_protocol" json:"proxy_protocol" mapstructure:"proxy_protocol"`
+
+	// RateLimit caps tunnelled bandwidth to avoid saturating the uplink or running up Lambda egress
+	RateLimit RateLimitConfig `yaml:"rate_limit" json:"rate_limit" mapstructure:"rate_limit"`
+
+	// ACL allows or denies destinations, checked by the proxy and again by the Lambda
+	ACL ACLConfig `yaml:"acl" json:"acl" mapstructure:"acl"`
+
+	// PolicyFile names a policy document combining routing, allow/deny rules, bandwidth limits and quotas; it replaces acl and rate_limit
+	PolicyFile string `yaml:"policy_file" json:"policy_file" mapstructure:"policy_file"`
+
+	// Resolvers resolve matching domains with a local DNS server, for split-horizon DNS
+	Resolvers []ResolverConfig `yaml:"resolvers" json:"resolvers" mapstructure:"resolvers"`
+
+	// LambdaDNS picks the resolver the Lambda uses for target domains (empty = the Lambda's system resolver)
+	LambdaDNS LambdaDNSConfig `yaml:"lambda_dns" json:"lambda_dns" mapstructure:"lambda_dns"`
+
+	// DNSListen starts a local DNS server on this address, e.g. "127.0.0.1:5300", that resolves through the Lambda (empty = off)
+	DNSListen string `yaml:"dns_listen" json:"dns_listen" mapstructure:"dns_listen"`
+
+	// AuditLog writes one JSON line per proxied connection for usage accounting (empty path = off)
+	AuditLog AuditLogConfig `yaml:"audit_log" json:"audit_log" mapstructure:"audit_log"`
+
+	// AnomalyDetection flags sustained RTT spikes, ping loss and throughput collapse before sessions go unhealthy
+	AnomalyDetection AnomalyDetectionConfig `yaml:"anomaly_detection" json:"anomaly_detection" mapstructure:"anomaly_detection"`
+
+	// LambdaMetrics has the Lambda publish CloudWatch metrics, so Lambda-side errors can be alarmed on
+	LambdaMetrics LambdaMetricsConfig `yaml:"lambda_metrics" json:"lambda_metrics" mapstructure:"lambda_metrics"`
+	
+	// LambdaLogs has each session's Lambda send its log lines to this proxy's log
+	LambdaLogs LambdaLogsConfig `yaml:"lambda_logs" json:"lambda_logs" mapstructure:"lambda_logs"`
+	
+	// S3Objects sets the storage class and tags of the coordination and response objects (empty = the bucket's defaults)
+	S3Objects S3ObjectsConfig `yaml:"s3_objects" json:"s3_objects" mapstructure:"s3_objects"`
+
+	// Drain picks when the previous primary is shut down after a rotation
+	Drain DrainConfig `yaml:"drain" json:"drain" mapstructure:"drain"`
+
+	// Budget stops launching sessions once a day's or month's transfer or estimated spend reaches a cap
+	Budget BudgetConfig `yaml:"budget" json:"budget" mapstructure:"budget"`
+
+	// Relay runs sessions over TCP through a relay when UDP is blocked (empty URL = off)
+	Relay RelayConfig `yaml:"relay" json:"relay" mapstructure:"relay"`
+}
+
+// SessionPoolConfig sizes the session pool: one primary, up to Secondaries
+// secondaries and any draining sessions, all within MaxSessions. MaxGoroutines
+// caps the launches, promotions and drains in flight. (0 = defaults)
+type SessionPoolConfig struct {
+	Secondaries   int `yaml:"secondaries" json:"secondaries" mapstructure:"secondaries"`
+	MaxSessions   int `yaml:"max_sessions" json:"max_sessions" mapstructure:"max_sessions"`
+	MaxGoroutines int `yaml:"max_goroutines" json:"max_goroutines" mapstructure:"max_goroutines"`
+}
+
+// DrainConfig picks when a rotated-out session is shut down: after the mode's
+// drain timeout ("timer", the default) or once its last stream closes
+// ("streams"), waiting at most MaxWait (0 = 10m) so long downloads survive
+type DrainConfig struct {
+	Policy  string        `yaml:"policy" json:"policy" mapstructure:"policy"`
+	MaxWait time.Duration `yaml:"max_wait" json:"max_wait" mapstructure:"max_wait"`
+}
+
+// BudgetConfig caps what the proxy may use per Period ("day", the default, or
+// "month", both in UTC as AWS bills): MaxTransfer bytes tunnelled, e.g.
+// "50GB", and MaxSpend estimated US dollars (empty or 0 = no cap). Past a cap
+// no new sessions are launched until the period ends.
+type BudgetConfig struct {
+	Period      string  `yaml:"period" json:"period" mapstructure:"period"`
+	MaxTransfer string  `yaml:"max_transfer" json:"max_transfer" mapstructure:"max_transfer"`
+	MaxSpend    float64 `yaml:"max_spend" json:"max_spend" mapstructure:"max_spend"`
+}
+
+// RelayConfig points sessions at a relay started with 'lambda-nat-proxy
+// relay', at URL (tcp://host:port or tls://host:port) with Token. Mode
+// "fallback", the default, relays sessions once a launch finds UDP blocked;
+// "always" relays every session. Fingerprint pins a self-signed relay's
+// certificate.
+type RelayConfig struct {
+	URL         string `yaml:"url" json:"url" mapstructure:"url"`
+	Token       string `yaml:"token" json:"token" mapstructure:"token"`
+	Fingerprint string `yaml:"fingerprint" json:"fingerprint" mapstructure:"fingerprint"`
+	Mode        string `yaml:"mode" json:"mode" mapstructure:"mode"`
+}
+
+// Settings returns the relay settings sent to the Lambda
+func (r RelayConfig) Settings() shared.RelaySettings {
+	return shared.RelaySettings{URL: r.URL, Token: r.Token, Fingerprint: r.Fingerprint}
+}
+
+// RateLimitConfig holds bandwidth caps per second, e.g. "5MB" (empty = unlimited).
+// Each cap counts upload and download together.
+type RateLimitConfig struct {
+	Global         string `yaml:"global" json:"global" mapstructure:"global"`
+	PerClient      string `yaml:"per_client" json:"per_client" mapstructure:"per_client"`
+	PerDestination string `yaml:"per_destination" json:"per_destination" mapstructure:"per_destination"`
+}
+
+// ResourceLimitsConfig holds process ceilings (0 or empty = no limit). Past
+// one, new SOCKS5 connections wait and idle tunnels are closed until usage
+// falls below 90% of every limit.
+type ResourceLimitsConfig struct {
+	MaxGoroutines int    `yaml:"max_goroutines" json:"max_goroutines" mapstructure:"max_goroutines"`
+	MaxOpenFiles  int    `yaml:"max_open_files" json:"max_open_files" mapstructure:"max_open_files"`
+	MaxMemory     string `yaml:"max_memory" json:"max_memory" mapstructure:"max_memory"` // e.g. "512MB"
+}
+
+// RefusalConfig picks the SOCKS5 reply for refused connections ("not-allowed",
+// the default, "general-failure", "network-unreachable", "host-unreachable" or
+// "connection-refused"), whether to refuse rather than queue clients while
+// over a resource limit, and whether HTTP clients get a 403 page
+type RefusalConfig struct {
+	Reply       string `yaml:"reply" json:"reply" mapstructure:"reply"`
+	WhilePaused bool   `yaml:"while_paused" json:"while_paused" mapstructure:"while_paused"`
+	HTTPPage    bool   `yaml:"http_page" json:"http_page" mapstructure:"http_page"`
+}
+
+// ProxyProtocolConfig reads a PROXY protocol v1 or v2 header, when one is
+// sent, from load balancers such as HAProxy or an NLB. Headers are only
+// believed from TrustedProxies, IP addresses or CIDRs (empty = any peer).
+type ProxyProtocolConfig struct {
+	Enabled        bool     `yaml:"enabled" json:"enabled" mapstructure:"enabled"`
+	TrustedProxies []string `yaml:"trusted_proxies" json:"trusted_proxies" mapstructure:"trusted_proxies"`
+}
+
+// AuditLogConfig names the audit log file and when it's rotated: once it
+// would exceed MaxSize (e.g. "100MB") or has been open for MaxAge, keeping
+// MaxBackups rotated files (0 or empty = no limit)
+type AuditLogConfig struct {
+	Path       string        `yaml:"path" json:"path" mapstructure:"path"`
+	MaxSize    string        `yaml:"max_size" json:"max_size" mapstructure:"max_size"`
+	MaxAge     time.Duration `yaml:"max_age" json:"max_age" mapstructure:"max_age"`
+	MaxBackups int           `yaml:"max_backups" json:"max_backups" mapstructure:"max_backups"`
+}
+
+// AnomalyDetectionConfig tunes the session health anomaly detector (zero =
+// default). Anomalies show on the dashboard and in the log, and AlertCommand,
+// if set, is run through the shell each time one starts or resolves.
+type AnomalyDetectionConfig struct {
+	Enabled        bool    `yaml:"enabled" json:"enabled" mapstructure:"enabled"`
+	RTTFactor      float64 `yaml:"rtt_factor" json:"rtt_factor" mapstructure:"rtt_factor"`
+	Sustain        int     `yaml:"sustain" json:"sustain" mapstructure:"sustain"`
+	LossThreshold  float64 `yaml:"loss_threshold" json:"loss_threshold" mapstructure:"loss_threshold"`
+	ThroughputDrop float64 `yaml:"throughput_drop" json:"throughput_drop" mapstructure:"throughput_drop"`
+	AlertCommand   string  `yaml:"alert_command" json:"alert_command" mapstructure:"alert_command"`
+}
+
+// LambdaMetricsConfig has each session's Lambda write CloudWatch embedded
+// metric format records to its log every Interval and when the session ends
+// (empty namespace = "LambdaNatProxy", 0 interval = 1m)
+type LambdaMetricsConfig struct {
+	Enabled   bool          `yaml:"enabled" json:"enabled" mapstructure:"enabled"`
+	Namespace string        `yaml:"namespace" json:"namespace" mapstructure:"namespace"`
+	Interval  time.Duration `yaml:"interval" json:"interval" mapstructure:"interval"`
+}
+
+// LambdaLogsConfig has each session's Lambda forward its log lines at Level
+// and above over the control stream, at most Rate a second (empty level =
+// off, 0 rate = 20)
+type LambdaLogsConfig struct {
+	Level string `yaml:"level" json:"level" mapstructure:"level"`
+	Rate  int    `yaml:"rate" json:"rate" mapstructure:"rate"`
+}
+
+// S3ObjectsConfig writes each session's coordination and response objects
+// with StorageClass (empty = the bucket's default, STANDARD) and Tags, which
+// bucket lifecycle rules can filter on
+type S3ObjectsConfig struct {
+	StorageClass string            `yaml:"storage_class" json:"storage_class" mapstructure:"storage_class"`
+	Tags         map[string]string `yaml:"tags" json:"tags" mapstructure:"tags"`
+}
+
+// Storage returns the settings sent with each session
+func (c S3ObjectsConfig) Storage() shared.ObjectStorage {
+	return shared.ObjectStorage{StorageClass: c.StorageClass, Tags: c.Tags}
+}
+
+// ResolverConfig resolves Domains ("corp.example.com" or "*.corp.example.com")
+// with Server (empty = system resolver) and dials the answer by IP through the
+// tunnel (Route "tunnel", the default) or from this machine ("direct")
+type ResolverConfig struct {
+	Domains []string `yaml:"domains" json:"domains" mapstructure:"domains"`
+	Server  string   `yaml:"server" json:"server" mapstructure:"server"`
+	Route   string   `yaml:"route" json:"route" mapstructure:"route"`
+}
+
+// LambdaDNSConfig selects the Lambda's upstream resolver: "1.1.1.1",
+// "tcp://1.1.1.1", "tls://1.1.1.1" (DoT) or "https://1.1.1.1/dns-query" (DoH).
+// Answers are cached for their TTL, at most MaxTTL (0 = 5m). PinTTL above
+// zero resolves each CONNECT domain once and sends every connection to it to
+// the same address for that long.
+type LambdaDNSConfig struct {
+	Upstream string        `yaml:"upstream" json:"upstream" mapstructure:"upstream"`
+	MaxTTL   time.Duration `yaml:"max_ttl" json:"max_ttl" mapstructure:"max_ttl"`
+	PinTTL   time.Duration `yaml:"pin_ttl" json:"pin_ttl" mapstructure:"pin_ttl"`
+}
+
+// ACLConfig holds destination rules such as "private", "169.254.169.254",
+// ":25" or "*.example.com:22". Allow rules win over deny rules; destinations
+// matching neither get Default ("allow" or "deny", empty = allow).
+type ACLConfig struct {
+	Default string   `yaml:"default" json:"default" mapstructure:"default"`
+	Allow   []string `yaml:"allow" json:"allow" mapstructure:"allow"`
+	Deny    []string `yaml:"deny" json:"deny" mapstructure:"deny"`
 }
 
 
@@ -43,6 +437,9 @@ func (c *CLIConfig) Merge(other *CLIConfig) {
 	if other.AWS.Profile != "" {
 		c.AWS.Profile = other.AWS.Profile
 	}
+	if len(other.AWS.Regions) > 0 {
+		c.AWS.Regions = other.AWS.Regions
+	}
 	
 	if other.Deployment.StackName != "" {
 		c.Deployment.StackName = other.Deployment.StackName
@@ -50,35 +447,512 @@ func (c *CLIConfig) Merge(other *CLIConfig) {
 	if other.Deployment.Mode != "" {
 		c.Deployment.Mode = other.Deployment.Mode
 	}
+	if len(other.Deployment.BlockedTargets) > 0 {
+		c.Deployment.BlockedTargets = other.Deployment.BlockedTargets
+	}
+	if other.Deployment.Coordination != "" {
+		c.Deployment.Coordination = other.Deployment.Coordination
+	}
+	if other.Deployment.Architecture != "" {
+		c.Deployment.Architecture = other.Deployment.Architecture
+	}
+	if other.Deployment.Warmup != 0 {
+		c.Deployment.Warmup = other.Deployment.Warmup
+	}
+	if other.Deployment.SessionModes != nil {
+		c.Deployment.SessionModes = other.Deployment.SessionModes
+	}
+	if other.Deployment.SessionCredentials {
+		c.Deployment.SessionCredentials = true
+	}
+	if other.Deployment.TemplateOverlay != "" {
+		c.Deployment.TemplateOverlay = other.Deployment.TemplateOverlay
+	}
+	if other.Deployment.TemplateVariant != "" {
+		c.Deployment.TemplateVariant = other.Deployment.TemplateVariant
+	}
 	
 	if other.Proxy.Port != 0 {
 		c.Proxy.Port = other.Proxy.Port
 	}
+	if other.Proxy.Listen != "" {
+		c.Proxy.Listen = other.Proxy.Listen
+	}
 	if other.Proxy.STUNServer != "" {
 		c.Proxy.STUNServer = other.Proxy.STUNServer
 	}
+	if other.Proxy.EnableDatagrams {
+		c.Proxy.EnableDatagrams = true
+	}
+	if other.Proxy.PipelineConnect {
+		c.Proxy.PipelineConnect = true
+	}
+	if other.Proxy.CongestionControl != "" {
+		c.Proxy.CongestionControl = other.Proxy.CongestionControl
+	}
+	if other.Proxy.InitialWindow != 0 {
+		c.Proxy.InitialWindow = other.Proxy.InitialWindow
+	}
+	if other.Proxy.QUICConnections != 0 {
+		c.Proxy.QUICConnections = other.Proxy.QUICConnections
+	}
+	if other.Proxy.SessionWait != 0 {
+		c.Proxy.SessionWait = other.Proxy.SessionWait
+	}
+	if other.Proxy.InvokeFallback != 0 {
+		c.Proxy.InvokeFallback = other.Proxy.InvokeFallback
+	}
+	if other.Proxy.LogLevel != "" {
+		c.Proxy.LogLevel = other.Proxy.LogLevel
+	}
+	if other.Proxy.PrivacyMode {
+		c.Proxy.PrivacyMode = true
+	}
+	if other.Proxy.Notifications {
+		c.Proxy.Notifications = true
+	}
+	if len(other.Proxy.Webhooks) > 0 {
+		c.Proxy.Webhooks = other.Proxy.Webhooks
+	}
+	if other.Proxy.PunchPorts != "" {
+		c.Proxy.PunchPorts = other.Proxy.PunchPorts
+	}
+	if other.Proxy.PunchPredictPorts != 0 {
+		c.Proxy.PunchPredictPorts = other.Proxy.PunchPredictPorts
+	}
+	if other.Proxy.KeepaliveInterval != 0 {
+		c.Proxy.KeepaliveInterval = other.Proxy.KeepaliveInterval
+	}
+	if other.Proxy.KeepaliveMinInterval != 0 {
+		c.Proxy.KeepaliveMinInterval = other.Proxy.KeepaliveMinInterval
+	}
+	if other.Proxy.IdleTimeout != 0 {
+		c.Proxy.IdleTimeout = other.Proxy.IdleTimeout
+	}
+	if other.Proxy.ConnectTimeout != 0 {
+		c.Proxy.ConnectTimeout = other.Proxy.ConnectTimeout
+	}
+	if other.Proxy.MaxStreamLifetime != 0 {
+		c.Proxy.MaxStreamLifetime = other.Proxy.MaxStreamLifetime
+	}
+	if other.Proxy.Compression != "" {
+		c.Proxy.Compression = other.Proxy.Compression
+	}
+	if other.Proxy.MaxConnections != 0 {
+		c.Proxy.MaxConnections = other.Proxy.MaxConnections
+	}
+	if other.Proxy.SessionPool.Secondaries != 0 {
+		c.Proxy.SessionPool.Secondaries = other.Proxy.SessionPool.Secondaries
+	}
+	if other.Proxy.SessionPool.MaxSessions != 0 {
+		c.Proxy.SessionPool.MaxSessions = other.Proxy.SessionPool.MaxSessions
+	}
+	if other.Proxy.SessionPool.MaxGoroutines != 0 {
+		c.Proxy.SessionPool.MaxGoroutines = other.Proxy.SessionPool.MaxGoroutines
+	}
+	if other.Proxy.RateLimit.Global != "" {
+		c.Proxy.RateLimit.Global = other.Proxy.RateLimit.Global
+	}
+	if other.Proxy.RateLimit.PerClient != "" {
+		c.Proxy.RateLimit.PerClient = other.Proxy.RateLimit.PerClient
+	}
+	if other.Proxy.RateLimit.PerDestination != "" {
+		c.Proxy.RateLimit.PerDestination = other.Proxy.RateLimit.PerDestination
+	}
+	if other.Proxy.ACL.Default != "" {
+		c.Proxy.ACL.Default = other.Proxy.ACL.Default
+	}
+	if len(other.Proxy.ACL.Allow) > 0 {
+		c.Proxy.ACL.Allow = other.Proxy.ACL.Allow
+	}
+	if len(other.Proxy.ACL.Deny) > 0 {
+		c.Proxy.ACL.Deny = other.Proxy.ACL.Deny
+	}
+	if other.Proxy.ResourceLimits.MaxGoroutines != 0 {
+		c.Proxy.ResourceLimits.MaxGoroutines = other.Proxy.ResourceLimits.MaxGoroutines
+	}
+	if other.Proxy.ResourceLimits.MaxOpenFiles != 0 {
+		c.Proxy.ResourceLimits.MaxOpenFiles = other.Proxy.ResourceLimits.MaxOpenFiles
+	}
+	if other.Proxy.ResourceLimits.MaxMemory != "" {
+		c.Proxy.ResourceLimits.MaxMemory = other.Proxy.ResourceLimits.MaxMemory
+	}
+	if other.Proxy.Refusal.Reply != "" {
+		c.Proxy.Refusal.Reply = other.Proxy.Refusal.Reply
+	}
+	if other.Proxy.Refusal.WhilePaused {
+		c.Proxy.Refusal.WhilePaused = true
+	}
+	if other.Proxy.Refusal.HTTPPage {
+		c.Proxy.Refusal.HTTPPage = true
+	}
+	if other.Proxy.ProxyProtocol.Enabled {
+		c.Proxy.ProxyProtocol.Enabled = true
+	}
+	if len(other.Proxy.ProxyProtocol.TrustedProxies) > 0 {
+		c.Proxy.ProxyProtocol.TrustedProxies = other.Proxy.ProxyProtocol.TrustedProxies
+	}
+	if len(other.Proxy.Resolvers) > 0 {
+		c.Proxy.Resolvers = other.Proxy.Resolvers
+	}
+	if other.Proxy.PolicyFile != "" {
+		c.Proxy.PolicyFile = other.Proxy.PolicyFile
+	}
+	if other.Proxy.LambdaDNS.Upstream != "" {
+		c.Proxy.LambdaDNS.Upstream = other.Proxy.LambdaDNS.Upstream
+	}
+	if other.Proxy.LambdaDNS.MaxTTL != 0 {
+		c.Proxy.LambdaDNS.MaxTTL = other.Proxy.LambdaDNS.MaxTTL
+	}
+	if other.Proxy.LambdaDNS.PinTTL != 0 {
+		c.Proxy.LambdaDNS.PinTTL = other.Proxy.LambdaDNS.PinTTL
+	}
+	if other.Proxy.DNSListen != "" {
+		c.Proxy.DNSListen = other.Proxy.DNSListen
+	}
+	if other.Tracing.Endpoint != "" {
+		c.Tracing.Endpoint = other.Tracing.Endpoint
+	}
+	if other.Tracing.LambdaEndpoint != "" {
+		c.Tracing.LambdaEndpoint = other.Tracing.LambdaEndpoint
+	}
+	if other.Tracing.ServiceName != "" {
+		c.Tracing.ServiceName = other.Tracing.ServiceName
+	}
+	if other.Tracing.SampleRate != 0 {
+		c.Tracing.SampleRate = other.Tracing.SampleRate
+	}
+	if len(other.Tracing.Headers) > 0 {
+		c.Tracing.Headers = other.Tracing.Headers
+	}
+	if other.Rotation.SessionTTL != 0 {
+		c.Rotation.SessionTTL = other.Rotation.SessionTTL
+	}
+	if other.Rotation.OverlapWindow != 0 {
+		c.Rotation.OverlapWindow = other.Rotation.OverlapWindow
+	}
+	if other.Rotation.DrainTimeout != 0 {
+		c.Rotation.DrainTimeout = other.Rotation.DrainTimeout
+	}
+	for name, enabled := range other.Features {
+		if c.Features == nil {
+			c.Features = make(map[string]bool)
+		}
+		c.Features[name] = enabled
+	}
+	if other.HTTPProxy.URL != "" {
+		c.HTTPProxy.URL = other.HTTPProxy.URL
+	}
+	if other.HTTPProxy.NoProxy != "" {
+		c.HTTPProxy.NoProxy = other.HTTPProxy.NoProxy
+	}
+	if other.Proxy.AuditLog.Path != "" {
+		c.Proxy.AuditLog.Path = other.Proxy.AuditLog.Path
+	}
+	if other.Proxy.AuditLog.MaxSize != "" {
+		c.Proxy.AuditLog.MaxSize = other.Proxy.AuditLog.MaxSize
+	}
+	if other.Proxy.AuditLog.MaxAge != 0 {
+		c.Proxy.AuditLog.MaxAge = other.Proxy.AuditLog.MaxAge
+	}
+	if other.Proxy.AuditLog.MaxBackups != 0 {
+		c.Proxy.AuditLog.MaxBackups = other.Proxy.AuditLog.MaxBackups
+	}
+	if other.Proxy.AnomalyDetection.Enabled {
+		c.Proxy.AnomalyDetection.Enabled = true
+	}
+	if other.Proxy.AnomalyDetection.RTTFactor != 0 {
+		c.Proxy.AnomalyDetection.RTTFactor = other.Proxy.AnomalyDetection.RTTFactor
+	}
+	if other.Proxy.AnomalyDetection.Sustain != 0 {
+		c.Proxy.AnomalyDetection.Sustain = other.Proxy.AnomalyDetection.Sustain
+	}
+	if other.Proxy.AnomalyDetection.LossThreshold != 0 {
+		c.Proxy.AnomalyDetection.LossThreshold = other.Proxy.AnomalyDetection.LossThreshold
+	}
+	if other.Proxy.AnomalyDetection.ThroughputDrop != 0 {
+		c.Proxy.AnomalyDetection.ThroughputDrop = other.Proxy.AnomalyDetection.ThroughputDrop
+	}
+	if other.Proxy.AnomalyDetection.AlertCommand != "" {
+		c.Proxy.AnomalyDetection.AlertCommand = other.Proxy.AnomalyDetection.AlertCommand
+	}
+	if other.Proxy.LambdaMetrics.Enabled {
+		c.Proxy.LambdaMetrics.Enabled = true
+	}
+	if other.Proxy.LambdaMetrics.Namespace != "" {
+		c.Proxy.LambdaMetrics.Namespace = other.Proxy.LambdaMetrics.Namespace
+	}
+	if other.Proxy.LambdaMetrics.Interval != 0 {
+		c.Proxy.LambdaMetrics.Interval = other.Proxy.LambdaMetrics.Interval
+	}
+	if other.Proxy.LambdaLogs.Level != "" {
+		c.Proxy.LambdaLogs.Level = other.Proxy.LambdaLogs.Level
+	}
+	if other.Proxy.LambdaLogs.Rate != 0 {
+		c.Proxy.LambdaLogs.Rate = other.Proxy.LambdaLogs.Rate
+	}
+	if other.Proxy.S3Objects.StorageClass != "" {
+		c.Proxy.S3Objects.StorageClass = other.Proxy.S3Objects.StorageClass
+	}
+	if other.Proxy.S3Objects.Tags != nil {
+		c.Proxy.S3Objects.Tags = other.Proxy.S3Objects.Tags
+	}
+	if other.Proxy.Drain.Policy != "" {
+		c.Proxy.Drain.Policy = other.Proxy.Drain.Policy
+	}
+	if other.Proxy.Drain.MaxWait != 0 {
+		c.Proxy.Drain.MaxWait = other.Proxy.Drain.MaxWait
+	}
+	if other.Proxy.Budget.Period != "" {
+		c.Proxy.Budget.Period = other.Proxy.Budget.Period
+	}
+	if other.Proxy.Budget.MaxTransfer != "" {
+		c.Proxy.Budget.MaxTransfer = other.Proxy.Budget.MaxTransfer
+	}
+	if other.Proxy.Budget.MaxSpend != 0 {
+		c.Proxy.Budget.MaxSpend = other.Proxy.Budget.MaxSpend
+	}
+	if other.Proxy.Relay.URL != "" {
+		c.Proxy.Relay.URL = other.Proxy.Relay.URL
+	}
+	if other.Proxy.Relay.Token != "" {
+		c.Proxy.Relay.Token = other.Proxy.Relay.Token
+	}
+	if other.Proxy.Relay.Fingerprint != "" {
+		c.Proxy.Relay.Fingerprint = other.Proxy.Relay.Fingerprint
+	}
+	if other.Proxy.Relay.Mode != "" {
+		c.Proxy.Relay.Mode = other.Proxy.Relay.Mode
+	}
 }
 
-// ToLegacyConfig converts CLIConfig to the legacy Config format
-// The S3 bucket name should be passed separately since it's auto-detected
-func (c *CLIConfig) ToLegacyConfig(s3BucketName string) *Config {
-	// Get mode configuration
-	modeConfigs := GetModeConfigs()
-	modeConfig := modeConfigs[c.Deployment.Mode]
-	
-	return &Config{
-		AWSRegion:             c.AWS.Region,
-		S3BucketName:          s3BucketName,
-		STUNServer:            c.Proxy.STUNServer,
-		SOCKS5Port:            c.Proxy.Port,
-		LambdaResponseTimeout: 30 * time.Second, // Keep existing defaults
-		NATHolePunchTimeout:   30 * time.Second,
-		Rotation: RotationConfig{
-			OverlapWindow: modeConfig.OverlapWindow,
-			DrainTimeout:  modeConfig.DrainTimeout,
-			SessionTTL:    modeConfig.SessionTTL,
-		},
-		Mode:       c.Deployment.Mode,
-		ModeConfig: modeConfig,
-	}
-}
\ No newline at end of file
+// ToConfig resolves the CLI configuration into the Config used by the
+// launcher, connection manager, QUIC server and proxy. Settings the CLI
+// leaves at zero keep the defaults from newConfig, except where zero has a
+// meaning of its own (session_wait = don't wait). The S3 bucket name is
+// passed separately since it's auto-detected.
+func (c *CLIConfig) ToConfig(s3BucketName string) *Config {
+	cfg := newConfig(c.Deployment.Mode)
+	cfg.S3BucketName = s3BucketName
+	
+	if c.AWS.Region != "" {
+		cfg.AWSRegion = c.AWS.Region
+	}
+	if c.Proxy.STUNServer != "" {
+		cfg.STUNServer = c.Proxy.STUNServer
+	}
+	if c.Proxy.Port != 0 {
+		cfg.SOCKS5Port = c.Proxy.Port
+	}
+	if c.Proxy.Listen != "" {
+		cfg.SOCKS5Listen = c.Proxy.Listen
+	}
+	if c.Proxy.IdleTimeout != 0 {
+		cfg.TunnelIdleTimeout = c.Proxy.IdleTimeout
+	}
+	cfg.TunnelConnectTimeout = c.Proxy.ConnectTimeout
+	cfg.TunnelMaxLifetime = c.Proxy.MaxStreamLifetime
+	cfg.TunnelCompression, _ = shared.ParseCompression(c.Proxy.Compression)
+	if c.Proxy.MaxConnections != 0 {
+		cfg.MaxConnections = c.Proxy.MaxConnections
+	}
+	
+	// Invalid ranges are rejected by ValidateCLIConfig; fall back to any port
+	cfg.PunchPorts, _ = shared.ParsePortRange(c.Proxy.PunchPorts)
+	cfg.PunchPredictPorts = c.Proxy.PunchPredictPorts
+	cfg.KeepaliveInterval = c.Proxy.KeepaliveInterval
+	cfg.KeepaliveMinInterval = c.Proxy.KeepaliveMinInterval
+	cfg.EnableDatagrams = c.Proxy.EnableDatagrams
+	cfg.PipelineConnect = c.Proxy.PipelineConnect
+	cfg.SessionWaitTimeout = c.Proxy.SessionWait
+	cfg.InvokeFallback = c.Proxy.InvokeFallback
+	if c.Proxy.LogLevel != "" {
+		// Unknown levels are rejected by ValidateCLIConfig
+		cfg.LogLevel, _ = shared.ParseLogLevel(c.Proxy.LogLevel)
+	}
+	cfg.PrivacyMode = c.Proxy.PrivacyMode
+	cfg.Notifications = c.Proxy.Notifications
+	cfg.Webhooks = c.Proxy.Webhooks
+	cfg.LambdaFunctionName = c.Deployment.StackName + "-lambda"
+	if c.Deployment.Coordination != "" {
+		cfg.Coordination = c.Deployment.Coordination
+	}
+	cfg.SessionCredentials = c.Deployment.SessionCredentials
+	cfg.Bandwidth = c.Proxy.RateLimit.Limits()
+	cfg.Resources = c.Proxy.ResourceLimits.Limits()
+	cfg.Refusal = c.Proxy.Refusal.Policy()
+	cfg.ProxyProtocol = c.Proxy.ProxyProtocol.Policy()
+	cfg.ACL = c.Proxy.ACL.Rules()
+	cfg.PolicyFile = c.Proxy.PolicyFile
+	cfg.Budget = c.Proxy.Budget.Limits()
+	if c.Proxy.Relay.URL != "" {
+		cfg.Relay = c.Proxy.Relay.Settings()
+		cfg.RelayMode = c.Proxy.Relay.Mode
+		if cfg.RelayMode == "" {
+			cfg.RelayMode = shared.RelayModeFallback
+		}
+	}
+	for _, resolver := range c.Proxy.Resolvers {
+		cfg.Resolvers = append(cfg.Resolvers, resolver.Rule())
+	}
+	cfg.DNSListen = c.Proxy.DNSListen
+	cfg.Tracing, cfg.LambdaTracing = c.Tracing.Split()
+	cfg.AuditLogPath = c.Proxy.AuditLog.Path
+	cfg.AuditLog = c.Proxy.AuditLog.Options()
+	cfg.AnomalyDetection = c.Proxy.AnomalyDetection.Enabled
+	cfg.Anomaly = c.Proxy.AnomalyDetection.Options()
+	cfg.LambdaMetrics = c.Proxy.LambdaMetrics.Settings()
+	cfg.LambdaLogs = shared.LogForwarding{Level: c.Proxy.LambdaLogs.Level, Rate: c.Proxy.LambdaLogs.Rate}
+	cfg.S3Objects = c.Proxy.S3Objects.Storage()
+	cfg.DNS = shared.DNSConfig{
+		Upstream: c.Proxy.LambdaDNS.Upstream,
+		MaxTTL:   c.Proxy.LambdaDNS.MaxTTL,
+	}
+	cfg.DNSPinTTL = c.Proxy.LambdaDNS.PinTTL
+	cfg.QUIC = shared.QUICTuning{
+		CongestionControl: c.Proxy.CongestionControl,
+		InitialWindow:     c.Proxy.InitialWindow,
+		Connections:       c.Proxy.QUICConnections,
+	}
+	cfg.Rotation.SessionTTL, cfg.Rotation.OverlapWindow, cfg.Rotation.DrainTimeout = c.Rotation.Resolve(cfg.ModeConfig)
+	cfg.Rotation.Secondaries = c.Proxy.SessionPool.Secondaries
+	cfg.Rotation.MaxSessions = c.Proxy.SessionPool.MaxSessions
+	cfg.Rotation.MaxGoroutines = c.Proxy.SessionPool.MaxGoroutines
+	if c.Proxy.Drain.Policy != "" {
+		cfg.Rotation.DrainPolicy = c.Proxy.Drain.Policy
+	}
+	cfg.Rotation.MaxDrainWait = c.Proxy.Drain.MaxWait
+	cfg.Features = c.Features
+	
+	return cfg
+}
+
+// Options converts the rotation settings. An invalid size is rejected by
+// ValidateCLIConfig and treated as no limit here.
+func (a AuditLogConfig) Options() audit.Options {
+	maxSize, _ := shared.ParseByteSize(a.MaxSize)
+	return audit.Options{
+		MaxSize:    maxSize,
+		MaxAge:     a.MaxAge,
+		MaxBackups: a.MaxBackups,
+	}
+}
+
+// Options converts the detector tuning
+func (a AnomalyDetectionConfig) Options() anomaly.Options {
+	return anomaly.Options{
+		RTTFactor:      a.RTTFactor,
+		Sustain:        a.Sustain,
+		LossThreshold:  a.LossThreshold,
+		ThroughputDrop: a.ThroughputDrop,
+		AlertCommand:   a.AlertCommand,
+	}
+}
+
+// Settings converts the Lambda metrics settings, returning a zero namespace
+// when they're off
+func (m LambdaMetricsConfig) Settings() shared.LambdaMetricsConfig {
+	if !m.Enabled {
+		return shared.LambdaMetricsConfig{}
+	}
+	namespace := m.Namespace
+	if namespace == "" {
+		namespace = shared.DefaultLambdaMetricsNamespace
+	}
+	return shared.LambdaMetricsConfig{Namespace: namespace, Interval: m.Interval}
+}
+
+// Split returns the tracing settings for the proxy and for the Lambda. The
+// Lambda reports as "<service_name>-lambda".
+func (t TracingConfig) Split() (proxy, lambda shared.TracingConfig) {
+	service := t.ServiceName
+	if service == "" {
+		service = "lambda-nat-proxy"
+	}
+	proxy = shared.TracingConfig{
+		Endpoint:    t.Endpoint,
+		ServiceName: service,
+		SampleRate:  t.SampleRate,
+		Headers:     t.Headers,
+	}
+	lambda = proxy
+	lambda.Endpoint = t.LambdaEndpoint
+	lambda.ServiceName = service + "-lambda"
+	return proxy, lambda
+}
+
+// Rule converts the resolver to the form used by the proxy
+func (r ResolverConfig) Rule() shared.ResolverRule {
+	return shared.ResolverRule{
+		Domains: r.Domains,
+		Server:  r.Server,
+		Route:   r.Route,
+	}
+}
+
+// Policy converts the refusal settings. An unknown reply is rejected by
+// ValidateCLIConfig and treated as "not-allowed" here.
+func (r RefusalConfig) Policy() shared.RefusalPolicy {
+	reply, _ := shared.ParseRefusalReply(r.Reply)
+	return shared.RefusalPolicy{
+		Reply:       reply,
+		WhilePaused: r.WhilePaused,
+		HTTPPage:    r.HTTPPage,
+	}
+}
+
+// Policy converts the PROXY protocol settings. Invalid trusted proxies are
+// rejected by ValidateCLIConfig and skipped here.
+func (p ProxyProtocolConfig) Policy() shared.ProxyProtocolPolicy {
+	policy := shared.ProxyProtocolPolicy{Enabled: p.Enabled}
+	for _, entry := range p.TrustedProxies {
+		if trusted, err := shared.ParseTrustedProxies([]string{entry}); err == nil {
+			policy.Trusted = append(policy.Trusted, trusted...)
+		}
+	}
+	return policy
+}
+
+// Rules converts the ACL to the form shared with the Lambda
+func (a ACLConfig) Rules() shared.ACLConfig {
+	return shared.ACLConfig{
+		Default: a.Default,
+		Allow:   a.Allow,
+		Deny:    a.Deny,
+	}
+}
+
+// Limits converts the configured ceilings. An invalid memory size is
+// rejected by ValidateCLIConfig and treated as no limit here.
+func (r ResourceLimitsConfig) Limits() shared.ResourceLimits {
+	memory, _ := shared.ParseByteSize(r.MaxMemory)
+	return shared.ResourceLimits{
+		MaxGoroutines: r.MaxGoroutines,
+		MaxOpenFiles:  r.MaxOpenFiles,
+		MaxMemory:     memory,
+	}
+}
+
+// Limits converts the budget to bytes and dollars. An invalid transfer cap is
+// rejected by ValidateCLIConfig and treated as no cap here.
+func (b BudgetConfig) Limits() BudgetLimits {
+	transfer, _ := shared.ParseByteSize(b.MaxTransfer)
+	period := b.Period
+	if period == "" {
+		period = BudgetPeriodDay
+	}
+	return BudgetLimits{Period: period, MaxTransfer: transfer, MaxSpend: b.MaxSpend}
+}
+
+// Limits converts the configured rates to bytes per second. Invalid rates are
+// rejected by ValidateCLIConfig and treated as unlimited here.
+func (r RateLimitConfig) Limits() shared.BandwidthLimits {
+	global, _ := shared.ParseByteRate(r.Global)
+	perClient, _ := shared.ParseByteRate(r.PerClient)
+	perDestination, _ := shared.ParseByteRate(r.PerDestination)
+	return shared.BandwidthLimits{
+		Global:         global,
+		PerClient:      perClient,
+		PerDestination: perDestination,
+	}
+}
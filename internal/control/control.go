@@ -0,0 +1,418 @@
+// Package control lets CLI commands manage a running proxy over a local
+// Unix socket: query its status, reload its configuration and stop it.
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adrg/xdg"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// ErrNotRunning is returned by a Client when no proxy answers on its socket
+var ErrNotRunning = errors.New("no proxy is running")
+
+// DefaultSocketPath is where a proxy listens for control requests unless told otherwise
+func DefaultSocketPath() string {
+	return filepath.Join(xdg.RuntimeDir, "lambda-nat-proxy", "control.sock")
+}
+
+// DefaultPIDPath is where a proxy in daemon mode writes its process ID
+func DefaultPIDPath() string {
+	return filepath.Join(xdg.RuntimeDir, "lambda-nat-proxy", "lambda-nat-proxy.pid")
+}
+
+// DefaultStatePath is where a proxy on port using stack keeps the sessions
+// it has launched, for the next run to stop if it crashes
+func DefaultStatePath(stack string, port int) string {
+	return filepath.Join(xdg.StateHome, "lambda-nat-proxy", fmt.Sprintf("sessions-%s-%d.json", stack, port))
+}
+
+// DefaultVersionPath is where the last version run is recorded, to show
+// the release notes since after an upgrade
+func DefaultVersionPath() string {
+	return filepath.Join(xdg.StateHome, "lambda-nat-proxy", "last-version")
+}
+
+// DefaultLogPath is where a proxy in daemon mode writes its log
+func DefaultLogPath() string {
+	return filepath.Join(xdg.StateHome, "lambda-nat-proxy", "proxy.log")
+}
+
+// Status describes a running proxy
+type Status struct {
+	PID         int             `json:"pid"`
+	Version     string          `json:"version"`
+	StartedAt   time.Time       `json:"started_at"`
+	StackName   string          `json:"stack_name"`
+	Region      string          `json:"region"`
+	Mode        string          `json:"mode"`
+	SOCKS5Port  int             `json:"socks5_port"`
+	PolicyFile  string          `json:"policy_file,omitempty"`
+	Connections int64           `json:"connections"`
+	Sessions    []SessionStatus `json:"sessions"`
+
+	// Evacuated holds when the evacuation of each evacuated region ends
+	Evacuated map[string]time.Time `json:"evacuated,omitempty"`
+}
+
+// SessionStatus describes one of a running proxy's sessions
+type SessionStatus struct {
+	ID            string        `json:"id"`
+	Role          string        `json:"role"`
+	Region        string        `json:"region,omitempty"` // set for sessions kept for policy egress rules
+	Healthy       bool          `json:"healthy"`
+	Uptime        time.Duration `json:"uptime"`
+	TTL           time.Duration `json:"ttl"`
+	ActiveStreams int64         `json:"active_streams"`
+
+	Crypto shared.TunnelCrypto `json:"crypto"`
+}
+
+// LaunchReset describes the launch state cleared by a launch reset
+type LaunchReset struct {
+	FailedAttempts int           `json:"failed_attempts"`
+	CooldownLeft   time.Duration `json:"cooldown_left"`
+	LaunchPending  bool          `json:"launch_pending"`
+	HasPrimary     bool          `json:"has_primary"`
+	OverBudget     bool          `json:"over_budget"`
+}
+
+// SessionAction names the session a rotate, drain or kill request acted on
+type SessionAction struct {
+	SessionID string `json:"session_id"`
+	Region    string `json:"region,omitempty"` // set for sessions kept for policy egress rules
+}
+
+// Evacuation describes a region a running proxy evacuated or stopped evacuating
+type Evacuation struct {
+	Region  string    `json:"region"`
+	Until   time.Time `json:"until,omitempty"`   // zero once the evacuation ends
+	Drained []string  `json:"drained,omitempty"` // IDs of the sessions drained
+}
+
+// Handlers implement the control requests. Stop should return at once and
+// shut the proxy down in the background. The session handlers return why
+// they couldn't act, such as an unknown session ID, and so do the region
+// handlers.
+type Handlers struct {
+	Status         func() Status
+	Reload         func() error
+	Stop           func()
+	ResetLaunch    func() LaunchReset
+	RotateSession  func(region string) (SessionAction, error)
+	DrainSession   func(id string) (SessionAction, error)
+	KillSession    func(id string) (SessionAction, error)
+	EvacuateRegion func(region string, d time.Duration) (Evacuation, error)
+	EndEvacuation  func(region string) (Evacuation, error)
+}
+
+// Server answers control requests on a Unix socket
+type Server struct {
+	path     string
+	listener net.Listener
+	server   *http.Server
+}
+
+// Listen starts answering control requests on the socket at path, readable
+// only by the current user. It fails if another proxy already answers there,
+// and replaces a socket left behind by one that exited.
+func Listen(path string, handlers Handlers) (*Server, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create control socket directory: %w", err)
+	}
+	if _, err := NewClient(path).Status(context.Background()); err == nil {
+		return nil, fmt.Errorf("another proxy is already running (control socket %s)", path)
+	}
+	os.Remove(path)
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict control socket: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, handlers.Status())
+	})
+	mux.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := handlers.Reload(); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/launch/reset", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if handlers.ResetLaunch == nil {
+			http.Error(w, "launch reset not supported", http.StatusNotImplemented)
+			return
+		}
+		writeJSON(w, http.StatusOK, handlers.ResetLaunch())
+	})
+	sessionAction := func(handler func(string) (SessionAction, error), param string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if handler == nil {
+				http.Error(w, "session control not supported", http.StatusNotImplemented)
+				return
+			}
+			action, err := handler(r.URL.Query().Get(param))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			writeJSON(w, http.StatusOK, action)
+		}
+	}
+	mux.HandleFunc("/sessions/rotate", sessionAction(handlers.RotateSession, "region"))
+	mux.HandleFunc("/sessions/drain", sessionAction(handlers.DrainSession, "id"))
+	mux.HandleFunc("/sessions/kill", sessionAction(handlers.KillSession, "id"))
+	regionAction := func(handler func(region string, r *http.Request) (Evacuation, error)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if handlers.EvacuateRegion == nil || handlers.EndEvacuation == nil {
+				http.Error(w, "region evacuation not supported", http.StatusNotImplemented)
+				return
+			}
+			evacuation, err := handler(r.URL.Query().Get("region"), r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			writeJSON(w, http.StatusOK, evacuation)
+		}
+	}
+	mux.HandleFunc("/regions/evacuate", regionAction(func(region string, r *http.Request) (Evacuation, error) {
+		d, err := time.ParseDuration(r.URL.Query().Get("for"))
+		if err != nil || d <= 0 {
+			return Evacuation{}, fmt.Errorf("invalid evacuation duration %q", r.URL.Query().Get("for"))
+		}
+		return handlers.EvacuateRegion(region, d)
+	}))
+	mux.HandleFunc("/regions/return", regionAction(func(region string, _ *http.Request) (Evacuation, error) {
+		return handlers.EndEvacuation(region)
+	}))
+	mux.HandleFunc("/stop", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		handlers.Stop()
+	})
+
+	s := &Server{
+		path:     path,
+		listener: listener,
+		server:   &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second},
+	}
+	go s.server.Serve(listener)
+	return s, nil
+}
+
+// Close stops answering control requests and removes the socket
+func (s *Server) Close() error {
+	err := s.server.Close()
+	os.Remove(s.path)
+	return err
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Client sends control requests to the proxy listening on a socket
+type Client struct {
+	http *http.Client
+}
+
+// NewClient returns a client for the control socket at path
+func NewClient(path string) *Client {
+	return &Client{http: &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}}
+}
+
+// do sends a request and returns the response body of a successful one
+func (c *Client) do(ctx context.Context, method, endpoint string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, "http://proxy"+endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return nil, ErrNotRunning
+		}
+		return nil, fmt.Errorf("control request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read control response: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		return nil, errors.New(strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// Status returns the running proxy's status
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	body, err := c.do(ctx, http.MethodGet, "/status")
+	if err != nil {
+		return nil, err
+	}
+	var status Status
+	if err := json.Unmarshal(body, &status); err != nil {
+		return nil, fmt.Errorf("failed to parse status: %w", err)
+	}
+	return &status, nil
+}
+
+// Reload asks the running proxy to reload its configuration, returning why it couldn't
+func (c *Client) Reload(ctx context.Context) error {
+	_, err := c.do(ctx, http.MethodPost, "/reload")
+	return err
+}
+
+// ResetLaunch asks the running proxy to forget failed launch attempts and
+// their cooldown and launch a session at once if it has none
+func (c *Client) ResetLaunch(ctx context.Context) (*LaunchReset, error) {
+	body, err := c.do(ctx, http.MethodPost, "/launch/reset")
+	if err != nil {
+		return nil, err
+	}
+	var reset LaunchReset
+	if err := json.Unmarshal(body, &reset); err != nil {
+		return nil, fmt.Errorf("failed to parse launch reset: %w", err)
+	}
+	return &reset, nil
+}
+
+// sessionAction posts a session control request with one query parameter
+func (c *Client) sessionAction(ctx context.Context, endpoint, param, value string) (*SessionAction, error) {
+	body, err := c.do(ctx, http.MethodPost, endpoint+"?"+url.Values{param: {value}}.Encode())
+	if err != nil {
+		return nil, err
+	}
+	var action SessionAction
+	if err := json.Unmarshal(body, &action); err != nil {
+		return nil, fmt.Errorf("failed to parse session action: %w", err)
+	}
+	return &action, nil
+}
+
+// RotateSession asks the running proxy to rotate the primary session of
+// region ("" for its own region) now rather than at the end of its TTL
+func (c *Client) RotateSession(ctx context.Context, region string) (*SessionAction, error) {
+	return c.sessionAction(ctx, "/sessions/rotate", "region", region)
+}
+
+// DrainSession asks the running proxy to stop opening tunnels on session id
+// and shut it down once its open tunnels finish
+func (c *Client) DrainSession(ctx context.Context, id string) (*SessionAction, error) {
+	return c.sessionAction(ctx, "/sessions/drain", "id", id)
+}
+
+// KillSession asks the running proxy to close session id at once
+func (c *Client) KillSession(ctx context.Context, id string) (*SessionAction, error) {
+	return c.sessionAction(ctx, "/sessions/kill", "id", id)
+}
+
+// regionAction posts a region evacuation request
+func (c *Client) regionAction(ctx context.Context, endpoint string, query url.Values) (*Evacuation, error) {
+	body, err := c.do(ctx, http.MethodPost, endpoint+"?"+query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	var evacuation Evacuation
+	if err := json.Unmarshal(body, &evacuation); err != nil {
+		return nil, fmt.Errorf("failed to parse evacuation: %w", err)
+	}
+	return &evacuation, nil
+}
+
+// EvacuateRegion asks the running proxy to drain every session in region
+// and launch none there for d, sending its traffic through another region
+// meanwhile
+func (c *Client) EvacuateRegion(ctx context.Context, region string, d time.Duration) (*Evacuation, error) {
+	return c.regionAction(ctx, "/regions/evacuate", url.Values{"region": {region}, "for": {d.String()}})
+}
+
+// EndEvacuation asks the running proxy to launch sessions in region again
+// before its evacuation expires
+func (c *Client) EndEvacuation(ctx context.Context, region string) (*Evacuation, error) {
+	return c.regionAction(ctx, "/regions/return", url.Values{"region": {region}})
+}
+
+// Stop asks the running proxy to shut down. It returns once the request is
+// accepted; use WaitStopped to wait for the proxy to exit.
+func (c *Client) Stop(ctx context.Context) error {
+	_, err := c.do(ctx, http.MethodPost, "/stop")
+	return err
+}
+
+// WaitStopped waits until the proxy no longer answers
+func (c *Client) WaitStopped(ctx context.Context) error {
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if _, err := c.Status(ctx); errors.Is(err, ErrNotRunning) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("proxy still running: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// WritePIDFile records the current process ID at path
+func WritePIDFile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create PID file directory: %w", err)
+	}
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0600)
+}
@@ -0,0 +1,161 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// socketPath returns a socket path short enough for the platform's limit
+func socketPath(t *testing.T) string {
+	dir, err := os.MkdirTemp("", "ctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "c.sock")
+}
+
+func TestControlServer(t *testing.T) {
+	path := socketPath(t)
+	reloadErr := errors.New("policy.yaml: unknown field")
+	reloads := 0
+	stopped := make(chan struct{})
+
+	server, err := Listen(path, Handlers{
+		Status: func() Status {
+			return Status{PID: 42, Mode: "normal", Sessions: []SessionStatus{{ID: "s1", Role: "primary", Healthy: true}}}
+		},
+		Reload: func() error {
+			reloads++
+			if reloads > 1 {
+				return reloadErr
+			}
+			return nil
+		},
+		Stop: func() { close(stopped) },
+		ResetLaunch: func() LaunchReset {
+			return LaunchReset{FailedAttempts: 4, CooldownLeft: 30 * time.Second}
+		},
+		RotateSession: func(region string) (SessionAction, error) {
+			return SessionAction{SessionID: "s1", Region: region}, nil
+		},
+		KillSession: func(id string) (SessionAction, error) {
+			if id != "s1" {
+				return SessionAction{}, errors.New("session not found: " + id)
+			}
+			return SessionAction{SessionID: id}, nil
+		},
+		EvacuateRegion: func(region string, d time.Duration) (Evacuation, error) {
+			return Evacuation{Region: region, Until: time.Unix(0, 0).Add(d), Drained: []string{"s1"}}, nil
+		},
+		EndEvacuation: func(region string) (Evacuation, error) {
+			return Evacuation{}, errors.New(region + " is not evacuated")
+		},
+	})
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer server.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("socket missing: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("socket permissions = %v, want 0600", perm)
+	}
+
+	if _, err := Listen(path, Handlers{}); err == nil {
+		t.Error("second Listen on a live socket should fail")
+	}
+
+	ctx := context.Background()
+	client := NewClient(path)
+	status, err := client.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if status.PID != 42 || len(status.Sessions) != 1 || status.Sessions[0].ID != "s1" {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	if err := client.Reload(ctx); err != nil {
+		t.Errorf("first Reload failed: %v", err)
+	}
+	if err := client.Reload(ctx); err == nil || !strings.Contains(err.Error(), "unknown field") {
+		t.Errorf("second Reload error = %v, want the handler's error", err)
+	}
+
+	reset, err := client.ResetLaunch(ctx)
+	if err != nil || reset.FailedAttempts != 4 || reset.CooldownLeft != 30*time.Second {
+		t.Errorf("ResetLaunch = %+v, %v", reset, err)
+	}
+
+	if action, err := client.RotateSession(ctx, "eu-west-1"); err != nil || action.SessionID != "s1" || action.Region != "eu-west-1" {
+		t.Errorf("RotateSession = %+v, %v", action, err)
+	}
+	if action, err := client.KillSession(ctx, "s1"); err != nil || action.SessionID != "s1" {
+		t.Errorf("KillSession = %+v, %v", action, err)
+	}
+	if _, err := client.KillSession(ctx, "s9"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("KillSession of an unknown session error = %v", err)
+	}
+	if _, err := client.DrainSession(ctx, "s1"); err == nil {
+		t.Error("DrainSession without a handler should fail")
+	}
+
+	evacuation, err := client.EvacuateRegion(ctx, "us-east-1", 30*time.Minute)
+	if err != nil || evacuation.Region != "us-east-1" || !evacuation.Until.Equal(time.Unix(1800, 0)) || len(evacuation.Drained) != 1 {
+		t.Errorf("EvacuateRegion = %+v, %v", evacuation, err)
+	}
+	if _, err := client.EndEvacuation(ctx, "us-east-1"); err == nil || !strings.Contains(err.Error(), "not evacuated") {
+		t.Errorf("EndEvacuation error = %v, want the handler's error", err)
+	}
+
+	if err := client.Stop(ctx); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop handler not called")
+	}
+
+	server.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Close should remove the socket, stat error = %v", err)
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := client.WaitStopped(waitCtx); err != nil {
+		t.Errorf("WaitStopped after Close: %v", err)
+	}
+}
+
+func TestClientNotRunning(t *testing.T) {
+	path := socketPath(t)
+	if _, err := NewClient(path).Status(context.Background()); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Status with no socket = %v, want ErrNotRunning", err)
+	}
+
+	// A socket left behind by a proxy that exited is replaced
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewClient(path).Status(context.Background()); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Status with stale socket = %v, want ErrNotRunning", err)
+	}
+	server, err := Listen(path, Handlers{Status: func() Status { return Status{PID: 1} }})
+	if err != nil {
+		t.Fatalf("Listen over stale socket failed: %v", err)
+	}
+	defer server.Close()
+	if _, err := NewClient(path).Status(context.Background()); err != nil {
+		t.Errorf("Status after replacing stale socket: %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+package cost
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+)
+
+// BudgetStatus is what the proxy has used in the current budget period
+type BudgetStatus struct {
+	Period        string    `json:"period"`
+	PeriodStart   time.Time `json:"period_start"`
+	ResetsAt      time.Time `json:"resets_at"`
+	Bytes         int64     `json:"bytes"`
+	MaxTransfer   int64     `json:"max_transfer,omitempty"`
+	Sessions      int       `json:"sessions"`
+	LambdaSeconds float64   `json:"lambda_seconds"`
+	Spend         float64   `json:"spend"`
+	MaxSpend      float64   `json:"max_spend,omitempty"`
+	Exceeded      bool      `json:"exceeded"`
+	Reason        string    `json:"reason,omitempty"` // which cap was hit
+}
+
+// BudgetWarningFraction is the share of a cap past which the budget is
+// reported as nearly used up, matching the dashboard's banner
+const BudgetWarningFraction = 0.8
+
+// Used returns the largest share of a cap used so far, 1 or more once a cap
+// is reached, or 0 without caps
+func (s BudgetStatus) Used() float64 {
+	var used float64
+	if s.MaxTransfer > 0 {
+		used = float64(s.Bytes) / float64(s.MaxTransfer)
+	}
+	if s.MaxSpend > 0 && s.Spend/s.MaxSpend > used {
+		used = s.Spend / s.MaxSpend
+	}
+	return used
+}
+
+// BudgetTracker adds up the bytes tunnelled and the sessions launched in the
+// current day or month and estimates their cost. A nil *BudgetTracker has no
+// caps and is never exceeded.
+type BudgetTracker struct {
+	limits   config.BudgetLimits
+	pricing  Pricing
+	memoryMB int
+	now      func() time.Time
+
+	mu            sync.Mutex
+	periodStart   time.Time
+	bytes         int64
+	lastTotal     int64
+	sessions      int
+	lambdaSeconds float64
+}
+
+// NewBudgetTracker tracks usage against limits, pricing Lambda run time at
+// memoryMB. It returns nil (no budget) when limits sets no cap.
+func NewBudgetTracker(limits config.BudgetLimits, pricing Pricing, memoryMB int) *BudgetTracker {
+	if limits.IsZero() {
+		return nil
+	}
+	return &BudgetTracker{
+		limits:   limits,
+		pricing:  pricing,
+		memoryMB: memoryMB,
+		now:      time.Now,
+	}
+}
+
+// periodBounds returns the start of the period containing t and the start of the next
+func (b *BudgetTracker) periodBounds(t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	if b.limits.Period == config.BudgetPeriodMonth {
+		start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0)
+	}
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 0, 1)
+}
+
+// roll starts a new period once the current one has ended. Callers hold mu.
+func (b *BudgetTracker) roll() {
+	start, _ := b.periodBounds(b.now())
+	if start.Equal(b.periodStart) {
+		return
+	}
+	b.periodStart = start
+	b.bytes = 0
+	b.sessions = 0
+	b.lambdaSeconds = 0
+}
+
+// ObserveBytes counts the bytes moved since the last call, given a running
+// total such as the proxy's transferred-bytes counter
+func (b *BudgetTracker) ObserveBytes(total int64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.roll()
+	if total > b.lastTotal {
+		b.bytes += total - b.lastTotal
+	}
+	b.lastTotal = total
+}
+
+// AddSession counts a launched session whose Lambda runs for up to runtime
+func (b *BudgetTracker) AddSession(runtime time.Duration) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.roll()
+	b.sessions++
+	b.lambdaSeconds += runtime.Seconds()
+}
+
+// Status returns usage in the current period and whether a cap has been reached
+func (b *BudgetTracker) Status() BudgetStatus {
+	if b == nil {
+		return BudgetStatus{}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.roll()
+
+	_, resetsAt := b.periodBounds(b.periodStart)
+	status := BudgetStatus{
+		Period:        b.limits.Period,
+		PeriodStart:   b.periodStart,
+		ResetsAt:      resetsAt,
+		Bytes:         b.bytes,
+		MaxTransfer:   b.limits.MaxTransfer,
+		Sessions:      b.sessions,
+		LambdaSeconds: b.lambdaSeconds,
+		MaxSpend:      b.limits.MaxSpend,
+	}
+	// A usage period of a whole Month is projected to itself, unscaled
+	status.Spend = b.pricing.Monthly(Usage{
+		Period:           Month,
+		Invocations:      float64(b.sessions),
+		DurationSeconds:  b.lambdaSeconds,
+		BytesTransferred: float64(b.bytes),
+	}, b.memoryMB).Total
+
+	switch {
+	case status.MaxTransfer > 0 && status.Bytes >= status.MaxTransfer:
+		status.Exceeded = true
+		status.Reason = fmt.Sprintf("transfer cap of %.2f GB per %s reached", float64(status.MaxTransfer)/(1<<30), status.Period)
+	case status.MaxSpend > 0 && status.Spend >= status.MaxSpend:
+		status.Exceeded = true
+		status.Reason = fmt.Sprintf("estimated spend cap of $%.2f per %s reached", status.MaxSpend, status.Period)
+	}
+	return status
+}
@@ -0,0 +1,119 @@
+package cost
+
+import (
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// Month is the billing month estimates are projected to, as AWS bills it
+const Month = 730 * time.Hour
+
+// S3 requests made per session. The proxy writes the coordination object
+// and polls for the response; the Lambda reads the one and writes the other.
+const (
+	S3PutsPerSession = 2
+	S3GetsPerSession = 1 + 4 // the Lambda's read, plus ~2s of response polling
+)
+
+// Pricing holds on-demand AWS prices in USD
+type Pricing struct {
+	LambdaGBSecond       float64 `json:"lambda_gb_second" yaml:"lambda_gb_second"`
+	LambdaPerMillion     float64 `json:"lambda_per_million_requests" yaml:"lambda_per_million_requests"`
+	DataTransferOutPerGB float64 `json:"data_transfer_out_per_gb" yaml:"data_transfer_out_per_gb"`
+	S3PutPerThousand     float64 `json:"s3_put_per_thousand" yaml:"s3_put_per_thousand"`
+	S3GetPerThousand     float64 `json:"s3_get_per_thousand" yaml:"s3_get_per_thousand"`
+}
+
+// DefaultPricing returns us-east-1 x86 prices. Most other commercial regions
+// are within a few percent; the free tier is not taken into account.
+func DefaultPricing() Pricing {
+	return Pricing{
+		LambdaGBSecond:       0.0000166667,
+		LambdaPerMillion:     0.20,
+		DataTransferOutPerGB: 0.09,
+		S3PutPerThousand:     0.005,
+		S3GetPerThousand:     0.0004,
+	}
+}
+
+// armGBSecondDiscount is how much cheaper arm64 (Graviton) compute is than
+// x86_64 per GB-second: $0.0000133334 against $0.0000166667 in us-east-1
+const armGBSecondDiscount = 0.8
+
+// ForArchitecture returns p with compute priced for a Lambda architecture.
+// p is assumed to hold x86_64 prices.
+func (p Pricing) ForArchitecture(architecture string) Pricing {
+	if architecture == shared.ArchitectureARM64 {
+		p.LambdaGBSecond *= armGBSecondDiscount
+	}
+	return p
+}
+
+// Usage is what a deployment used over Period, read from CloudWatch
+type Usage struct {
+	Period           time.Duration `json:"period" yaml:"period"`
+	Invocations      float64       `json:"invocations" yaml:"invocations"`
+	DurationSeconds  float64       `json:"duration_seconds" yaml:"duration_seconds"`
+	BytesTransferred float64       `json:"bytes_transferred" yaml:"bytes_transferred"`
+	BytesMeasured    bool          `json:"bytes_measured" yaml:"bytes_measured"` // false if the Lambda doesn't publish BytesTransferred
+}
+
+// Estimate is a projected monthly bill, broken down by what is charged
+type Estimate struct {
+	Mode           string  `json:"mode" yaml:"mode"`
+	MemoryMB       int     `json:"memory_mb" yaml:"memory_mb"`
+	Invocations    float64 `json:"invocations" yaml:"invocations"`
+	LambdaCompute  float64 `json:"lambda_compute" yaml:"lambda_compute"`
+	LambdaRequests float64 `json:"lambda_requests" yaml:"lambda_requests"`
+	DataTransfer   float64 `json:"data_transfer" yaml:"data_transfer"`
+	S3Requests     float64 `json:"s3_requests" yaml:"s3_requests"`
+	Total          float64 `json:"total" yaml:"total"`
+}
+
+// Monthly projects usage to a month on a Lambda with memoryMB of memory
+func (p Pricing) Monthly(usage Usage, memoryMB int) Estimate {
+	scale := 0.0
+	if usage.Period > 0 {
+		scale = float64(Month) / float64(usage.Period)
+	}
+	invocations := usage.Invocations * scale
+	gbSeconds := usage.DurationSeconds * scale * float64(memoryMB) / 1024
+	dataGB := usage.BytesTransferred * scale / (1 << 30)
+
+	estimate := Estimate{
+		MemoryMB:       memoryMB,
+		Invocations:    invocations,
+		LambdaCompute:  gbSeconds * p.LambdaGBSecond,
+		LambdaRequests: invocations / 1e6 * p.LambdaPerMillion,
+		DataTransfer:   dataGB * p.DataTransferOutPerGB,
+		S3Requests: invocations * (S3PutsPerSession*p.S3PutPerThousand +
+			S3GetsPerSession*p.S3GetPerThousand) / 1000,
+	}
+	estimate.Total = estimate.LambdaCompute + estimate.LambdaRequests + estimate.DataTransfer + estimate.S3Requests
+	return estimate
+}
+
+// ProjectModes estimates the monthly bill of usage, measured while running in
+// current mode, for every performance mode. The proxy is assumed to stay up
+// as long, so Lambda run time and data transfer are unchanged, while the
+// memory billed follows each mode and sessions start as often as its TTL
+// rotates them.
+func (p Pricing) ProjectModes(usage Usage, current config.PerformanceMode) []Estimate {
+	modes := config.GetModeConfigs()
+	_, currentConfig, _ := config.ResolveMode(current)
+
+	var estimates []Estimate
+	for _, mode := range []config.PerformanceMode{config.ModeTest, config.ModeNormal, config.ModePerformance} {
+		modeConfig := modes[mode]
+		projected := usage
+		if modeConfig.SessionTTL > 0 {
+			projected.Invocations = usage.Invocations * float64(currentConfig.SessionTTL) / float64(modeConfig.SessionTTL)
+		}
+		estimate := p.Monthly(projected, modeConfig.LambdaMemory)
+		estimate.Mode = string(mode)
+		estimates = append(estimates, estimate)
+	}
+	return estimates
+}
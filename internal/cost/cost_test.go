@@ -0,0 +1,177 @@
+package cost
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestMonthly(t *testing.T) {
+	pricing := Pricing{
+		LambdaGBSecond:       1,
+		LambdaPerMillion:     1,
+		DataTransferOutPerGB: 1,
+		S3PutPerThousand:     1,
+		S3GetPerThousand:     1,
+	}
+	// One month of usage needs no scaling
+	usage := Usage{
+		Period:           Month,
+		Invocations:      1000,
+		DurationSeconds:  100,
+		BytesTransferred: 2 << 30,
+		BytesMeasured:    true,
+	}
+
+	estimate := pricing.Monthly(usage, 512)
+	if !approxEqual(estimate.LambdaCompute, 50) {
+		t.Errorf("Expected 50 GB-seconds, got %v", estimate.LambdaCompute)
+	}
+	if !approxEqual(estimate.LambdaRequests, 0.001) {
+		t.Errorf("Expected requests cost 0.001, got %v", estimate.LambdaRequests)
+	}
+	if !approxEqual(estimate.DataTransfer, 2) {
+		t.Errorf("Expected 2 GB of transfer, got %v", estimate.DataTransfer)
+	}
+	if !approxEqual(estimate.S3Requests, S3PutsPerSession+S3GetsPerSession) {
+		t.Errorf("Expected S3 cost %d, got %v", S3PutsPerSession+S3GetsPerSession, estimate.S3Requests)
+	}
+	wantTotal := estimate.LambdaCompute + estimate.LambdaRequests + estimate.DataTransfer + estimate.S3Requests
+	if !approxEqual(estimate.Total, wantTotal) {
+		t.Errorf("Expected total %v, got %v", wantTotal, estimate.Total)
+	}
+
+	// arm64 compute is billed at 80% of the x86_64 rate
+	if got := pricing.ForArchitecture(shared.ArchitectureARM64).Monthly(usage, 512).LambdaCompute; !approxEqual(got, 40) {
+		t.Errorf("Expected arm64 compute cost 40, got %v", got)
+	}
+	if got := pricing.ForArchitecture(shared.ArchitectureX86_64).LambdaGBSecond; got != pricing.LambdaGBSecond {
+		t.Errorf("Expected x86_64 prices unchanged, got %v", got)
+	}
+
+	// A week of usage is scaled up to a month
+	usage.Period = 7 * 24 * time.Hour
+	if got := pricing.Monthly(usage, 512).Invocations; !approxEqual(got, 1000*730.0/168) {
+		t.Errorf("Expected invocations scaled to a month, got %v", got)
+	}
+}
+
+func TestProjectModes(t *testing.T) {
+	usage := Usage{Period: Month, Invocations: 100, DurationSeconds: 3600}
+	estimates := DefaultPricing().ProjectModes(usage, config.ModeNormal)
+	if len(estimates) != 3 {
+		t.Fatalf("Expected an estimate per mode, got %d", len(estimates))
+	}
+
+	byMode := make(map[string]Estimate)
+	for _, estimate := range estimates {
+		byMode[estimate.Mode] = estimate
+	}
+	if got := byMode["normal"].Invocations; !approxEqual(got, 100) {
+		t.Errorf("Expected the current mode to keep its invocations, got %v", got)
+	}
+	// 12 minute sessions rotate 1.5x less often than 8 minute ones
+	if got := byMode["performance"].Invocations; !approxEqual(got, 100*8.0/12) {
+		t.Errorf("Expected fewer performance mode invocations, got %v", got)
+	}
+	if byMode["performance"].LambdaCompute != 2*byMode["normal"].LambdaCompute {
+		t.Errorf("Expected double memory to double compute cost, got %v and %v",
+			byMode["performance"].LambdaCompute, byMode["normal"].LambdaCompute)
+	}
+}
+
+// fakeCloudWatch returns one datapoint per namespace and metric
+type fakeCloudWatch struct {
+	sums map[string]float64
+}
+
+func (f fakeCloudWatch) GetMetricStatistics(ctx context.Context, input *cloudwatch.GetMetricStatisticsInput, optFns ...func(*cloudwatch.Options)) (*cloudwatch.GetMetricStatisticsOutput, error) {
+	sum, ok := f.sums[aws.ToString(input.Namespace)+"/"+aws.ToString(input.MetricName)]
+	if !ok {
+		return &cloudwatch.GetMetricStatisticsOutput{}, nil
+	}
+	return &cloudwatch.GetMetricStatisticsOutput{Datapoints: []cwtypes.Datapoint{{Sum: aws.Float64(sum)}}}, nil
+}
+
+func TestReadUsage(t *testing.T) {
+	cw := fakeCloudWatch{sums: map[string]float64{
+		"AWS/Lambda/Invocations":          12,
+		"AWS/Lambda/Duration":             90000,
+		"LambdaNatProxy/BytesTransferred": 4096,
+	}}
+
+	usage, err := ReadUsage(context.Background(), cw, "proxy-fn", "", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("ReadUsage failed: %v", err)
+	}
+	if usage.Invocations != 12 || usage.DurationSeconds != 90 {
+		t.Errorf("Expected 12 invocations over 90s, got %+v", usage)
+	}
+	if usage.BytesMeasured {
+		t.Error("Expected bytes unmeasured without a metrics namespace")
+	}
+
+	usage, err = ReadUsage(context.Background(), cw, "proxy-fn", "LambdaNatProxy", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("ReadUsage failed: %v", err)
+	}
+	if !usage.BytesMeasured || usage.BytesTransferred != 4096 {
+		t.Errorf("Expected 4096 bytes measured, got %+v", usage)
+	}
+}
+
+func TestBudgetTracker(t *testing.T) {
+	now := time.Date(2024, 3, 10, 23, 0, 0, 0, time.UTC)
+	pricing := Pricing{DataTransferOutPerGB: 1, LambdaGBSecond: 1}
+	budget := NewBudgetTracker(config.BudgetLimits{Period: config.BudgetPeriodDay, MaxTransfer: 4 << 30, MaxSpend: 10}, pricing, 1024)
+	budget.now = func() time.Time { return now }
+
+	budget.ObserveBytes(1 << 30)
+	budget.AddSession(2 * time.Second)
+	status := budget.Status()
+	if status.Exceeded || status.Bytes != 1<<30 || !approxEqual(status.Spend, 3) {
+		t.Fatalf("Expected 1 GB and $3 under the caps, got %+v", status)
+	}
+	if !approxEqual(status.Used(), 0.3) {
+		t.Errorf("Expected 30%% of the spend cap used, got %v", status.Used())
+	}
+
+	// Only the bytes since the last observation count
+	budget.ObserveBytes(4 << 30)
+	if status := budget.Status(); !status.Exceeded || status.Bytes != 4<<30 {
+		t.Errorf("Expected the transfer cap reached at 4 GB, got %+v", status)
+	}
+
+	// A new UTC day starts a new budget
+	now = now.Add(2 * time.Hour)
+	status = budget.Status()
+	if status.Exceeded || status.Bytes != 0 || !status.ResetsAt.Equal(time.Date(2024, 3, 12, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected a fresh budget on the next day, got %+v", status)
+	}
+
+	budget.AddSession(10 * time.Second)
+	if status := budget.Status(); !status.Exceeded || status.Reason == "" {
+		t.Errorf("Expected the $10 spend cap reached, got %+v", status)
+	}
+
+	if NewBudgetTracker(config.BudgetLimits{Period: config.BudgetPeriodMonth}, pricing, 1024) != nil {
+		t.Error("Expected no tracker without a cap")
+	}
+	var none *BudgetTracker
+	none.AddSession(time.Hour)
+	if none.Status().Exceeded {
+		t.Error("Expected a nil tracker never to be exceeded")
+	}
+}
@@ -0,0 +1,71 @@
+package cost
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+
+	awsclients "github.com/dan-v/lambda-nat-punch-proxy/internal/aws"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// ReadUsage sums the function's invocations and run time over the period
+// ending now from AWS/Lambda metrics, and its relayed bytes from the
+// BytesTransferred metric the Lambda publishes to metricsNamespace. An empty
+// namespace leaves bytes unmeasured.
+func ReadUsage(ctx context.Context, cw awsclients.CloudWatchAPI, functionName, metricsNamespace string, period time.Duration) (Usage, error) {
+	usage := Usage{Period: period}
+	end := time.Now()
+	start := end.Add(-period)
+
+	invocations, err := sumMetric(ctx, cw, "AWS/Lambda", "Invocations", functionName, start, end)
+	if err != nil {
+		return usage, err
+	}
+	durationMs, err := sumMetric(ctx, cw, "AWS/Lambda", "Duration", functionName, start, end)
+	if err != nil {
+		return usage, err
+	}
+	usage.Invocations = invocations
+	usage.DurationSeconds = durationMs / 1000
+
+	if metricsNamespace != "" {
+		bytes, err := sumMetric(ctx, cw, metricsNamespace, shared.MetricBytesTransferred, functionName, start, end)
+		if err != nil {
+			return usage, err
+		}
+		usage.BytesTransferred = bytes
+		usage.BytesMeasured = true
+	}
+	return usage, nil
+}
+
+// sumMetric adds up a function's metric between start and end in daily
+// datapoints, which keeps long periods well under the 1440-datapoint limit
+func sumMetric(ctx context.Context, cw awsclients.CloudWatchAPI, namespace, metric, functionName string, start, end time.Time) (float64, error) {
+	result, err := cw.GetMetricStatistics(ctx, &cloudwatch.GetMetricStatisticsInput{
+		Namespace:  aws.String(namespace),
+		MetricName: aws.String(metric),
+		Dimensions: []cwtypes.Dimension{{
+			Name:  aws.String("FunctionName"),
+			Value: aws.String(functionName),
+		}},
+		StartTime:  aws.Time(start),
+		EndTime:    aws.Time(end),
+		Period:     aws.Int32(int32((24 * time.Hour).Seconds())),
+		Statistics: []cwtypes.Statistic{cwtypes.StatisticSum},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get %s/%s metric: %w", namespace, metric, err)
+	}
+
+	var sum float64
+	for _, point := range result.Datapoints {
+		sum += aws.ToFloat64(point.Sum)
+	}
+	return sum, nil
+}
@@ -1,12 +1,20 @@
 package dashboard
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"path"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/anomaly"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/events"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/features"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/manager"
 	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
 )
@@ -20,12 +28,25 @@ type DashboardServer struct {
 	clientsMu sync.RWMutex
 	broadcast chan []byte
 	shutdown  chan struct{}
+	stopOnce  sync.Once
+	events    *events.Bus // streamed by /api/events (nil = not found)
 }
 
 // NewDashboardServer creates a new dashboard server
 func NewDashboardServer(cm *manager.ConnManager) *DashboardServer {
+	return NewDashboardServerWithDeploymentSource(cm, nil)
+}
+
+// NewDashboardServerWithDeploymentSource creates a dashboard server that also
+// reports the status of the deployment described by source
+func NewDashboardServerWithDeploymentSource(cm *manager.ConnManager, source DeploymentSource) *DashboardServer {
+	collector := NewDashboardCollector(cm)
+	if source != nil {
+		collector.deployment = &deploymentMonitor{source: source}
+	}
+	
 	server := &DashboardServer{
-		collector: NewDashboardCollector(cm),
+		collector: collector,
 		mux:       http.NewServeMux(),
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
@@ -42,6 +63,22 @@ func NewDashboardServer(cm *manager.ConnManager) *DashboardServer {
 	return server
 }
 
+// SetConnectionTracker shows the connections followed by tracker instead of
+// those in GlobalConnectionTracker
+func (ds *DashboardServer) SetConnectionTracker(tracker *ConnectionTracker) {
+	ds.collector.tracker = tracker
+}
+
+// SetAnomalyDetector shows the anomalies flagged by detector
+func (ds *DashboardServer) SetAnomalyDetector(detector *anomaly.Detector) {
+	ds.collector.anomalies = detector
+}
+
+// SetEventBus streams the events published to bus at /api/events
+func (ds *DashboardServer) SetEventBus(bus *events.Bus) {
+	ds.events = bus
+}
+
 // setupRoutes configures all API routes
 func (ds *DashboardServer) setupRoutes() {
 	// API endpoints
@@ -49,6 +86,15 @@ func (ds *DashboardServer) setupRoutes() {
 	ds.mux.HandleFunc("/api/connections", ds.handleConnections)
 	ds.mux.HandleFunc("/api/sessions", ds.handleSessions)
 	ds.mux.HandleFunc("/api/destinations", ds.handleDestinations)
+	ds.mux.HandleFunc("/api/rotations", ds.handleRotations)
+	ds.mux.HandleFunc("/api/anomalies", ds.handleAnomalies)
+	ds.mux.HandleFunc("/api/dns/flush", ds.handleDNSFlush)
+	ds.mux.HandleFunc("/api/launch/reset", ds.handleLaunchReset)
+	ds.mux.HandleFunc("/api/features", ds.handleFeatures)
+	ds.mux.HandleFunc("/api/sessions/rotate", ds.handleSessionAction)
+	ds.mux.HandleFunc("/api/sessions/drain", ds.handleSessionAction)
+	ds.mux.HandleFunc("/api/sessions/kill", ds.handleSessionAction)
+	ds.mux.HandleFunc("/api/events", ds.handleEvents)
 	ds.mux.HandleFunc("/ws", ds.handleWebSocket)
 	
 	// Static files - we'll serve our React app here
@@ -60,7 +106,7 @@ func (ds *DashboardServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Add CORS headers for development
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Last-Event-ID")
 	
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
@@ -94,7 +140,7 @@ func (ds *DashboardServer) handleConnections(w http.ResponseWriter, r *http.Requ
 		return
 	}
 	
-	connections := GlobalConnectionTracker.GetActiveConnections()
+	connections := ds.collector.collectConnections()
 	
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(connections); err != nil {
@@ -104,14 +150,20 @@ func (ds *DashboardServer) handleConnections(w http.ResponseWriter, r *http.Requ
 	}
 }
 
-// handleSessions serves session information
+// handleSessions serves session information and recent launch timings
 func (ds *DashboardServer) handleSessions(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "GET" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 	
-	sessions := ds.collector.collectSessionInfo()
+	sessions := SessionsResponse{
+		Sessions: ds.collector.collectSessionInfo(),
+		Launches: ds.collector.collectLaunches(),
+	}
+	if sessions.Sessions == nil {
+		sessions.Sessions = []SessionInfo{}
+	}
 	
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(sessions); err != nil {
@@ -128,7 +180,7 @@ func (ds *DashboardServer) handleDestinations(w http.ResponseWriter, r *http.Req
 		return
 	}
 	
-	connections := GlobalConnectionTracker.GetActiveConnections()
+	connections := ds.collector.collectConnections()
 	destinations := ds.collector.calculateDestinationStats(connections)
 	
 	w.Header().Set("Content-Type", "application/json")
@@ -139,6 +191,169 @@ func (ds *DashboardServer) handleDestinations(w http.ResponseWriter, r *http.Req
 	}
 }
 
+// handleRotations serves the rotation timeline
+func (ds *DashboardServer) handleRotations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	
+	rotations := ds.collector.collectRotations()
+	
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(rotations); err != nil {
+		shared.LogErrorf("Failed to encode rotations data: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleAnomalies serves recent session health anomalies
+func (ds *DashboardServer) handleAnomalies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	
+	anomalies := ds.collector.collectAnomalies()
+	
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(anomalies); err != nil {
+		shared.LogErrorf("Failed to encode anomalies data: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleDNSFlush asks every session's Lambda to empty its DNS cache
+func (ds *DashboardServer) handleDNSFlush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	
+	cm := ds.collector.connectionManager
+	if cm == nil {
+		http.Error(w, "No connection manager", http.StatusServiceUnavailable)
+		return
+	}
+	
+	flushed, err := cm.FlushDNS()
+	response := map[string]interface{}{"sessions": flushed}
+	if err != nil {
+		shared.LogErrorf("DNS flush incomplete: %v", err)
+		response["error"] = err.Error()
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		shared.LogErrorf("Failed to encode DNS flush response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleLaunchReset clears wedged launch backoff and launches a session at once if none is up
+func (ds *DashboardServer) handleLaunchReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	
+	cm := ds.collector.connectionManager
+	if cm == nil {
+		http.Error(w, "No connection manager", http.StatusServiceUnavailable)
+		return
+	}
+	
+	reset := cm.ResetLaunchState("the dashboard API (" + r.RemoteAddr + ")")
+	
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(reset); err != nil {
+		shared.LogErrorf("Failed to encode launch reset response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleSessionAction rotates the primary session early, or drains or kills
+// the session named by the id query parameter
+func (ds *DashboardServer) handleSessionAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	
+	cm := ds.collector.connectionManager
+	if cm == nil {
+		http.Error(w, "No connection manager", http.StatusServiceUnavailable)
+		return
+	}
+	
+	source := "the dashboard API (" + r.RemoteAddr + ")"
+	id := r.URL.Query().Get("id")
+	var sessionID string
+	var err error
+	switch path.Base(r.URL.Path) {
+	case "rotate":
+		sessionID, err = cm.RotateNow(source)
+	case "drain":
+		sessionID, err = cm.DrainSession(id, source)
+	case "kill":
+		sessionID, err = cm.KillSession(id, source)
+	}
+	if errors.Is(err, manager.ErrSessionNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"session_id": sessionID}); err != nil {
+		shared.LogErrorf("Failed to encode session action response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// featureToggle is the body of a POST to /api/features
+type featureToggle struct {
+	Flag    string `json:"flag"`
+	Enabled bool   `json:"enabled"`
+}
+
+// handleFeatures lists the feature flags, or switches one on POST
+func (ds *DashboardServer) handleFeatures(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		var toggle featureToggle
+		if err := json.NewDecoder(r.Body).Decode(&toggle); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		flag, err := features.Parse(toggle.Flag)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		features.Set(flag, toggle.Enabled, "the dashboard API ("+r.RemoteAddr+")")
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(features.States()); err != nil {
+		shared.LogErrorf("Failed to encode feature flags: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+}
+
 // handleWebSocket handles WebSocket connections for real-time updates
 func (ds *DashboardServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	conn, err := ds.upgrader.Upgrade(w, r, nil)
@@ -204,17 +419,22 @@ func (ds *DashboardServer) startBroadcaster() {
 		}
 	}()
 	
-	// Start periodic updates
+	// Start periodic updates, further apart when there are many connections
+	// or collecting takes long, so the dashboard stays off the data path
 	go func() {
-		ticker := time.NewTicker(1 * time.Second) // Update every second
-		defer ticker.Stop()
+		timer := time.NewTimer(minUpdateInterval)
+		defer timer.Stop()
 		
 		for {
 			select {
-			case <-ticker.C:
-				if len(ds.clients) > 0 {
+			case <-timer.C:
+				interval := minUpdateInterval
+				if ds.clientCount() > 0 {
+					start := time.Now()
 					data := ds.collector.CollectDashboardData()
-					if jsonData, err := json.Marshal(data); err == nil {
+					jsonData, err := json.Marshal(data)
+					interval = updateInterval(data.TotalConnections, time.Since(start))
+					if err == nil {
 						select {
 						case ds.broadcast <- jsonData:
 						case <-ds.shutdown:
@@ -222,6 +442,7 @@ func (ds *DashboardServer) startBroadcaster() {
 						}
 					}
 				}
+				timer.Reset(interval)
 			case <-ds.shutdown:
 				shared.LogInfof("Dashboard periodic updater shutting down")
 				return
@@ -230,19 +451,67 @@ func (ds *DashboardServer) startBroadcaster() {
 	}()
 }
 
-// Shutdown gracefully shuts down the dashboard server
+// Dashboard update pacing
+const (
+	minUpdateInterval      = time.Second
+	maxUpdateInterval      = 10 * time.Second
+	connectionsPerInterval = 1000 // each this many connections adds minUpdateInterval
+	collectionBudget       = 50   // the interval is at least this many times a collection's duration
+)
+
+// updateInterval returns how long to wait before the next dashboard update,
+// given the connections shown and how long the last update took to collect
+func updateInterval(connections int, took time.Duration) time.Duration {
+	interval := minUpdateInterval * time.Duration(1+connections/connectionsPerInterval)
+	if budget := took * collectionBudget; budget > interval {
+		interval = budget
+	}
+	if interval > maxUpdateInterval {
+		interval = maxUpdateInterval
+	}
+	return interval
+}
+
+// clientCount returns the number of connected WebSocket clients
+func (ds *DashboardServer) clientCount() int {
+	ds.clientsMu.RLock()
+	defer ds.clientsMu.RUnlock()
+	return len(ds.clients)
+}
+
+// Shutdown stops the dashboard's updates and closes its WebSocket clients.
+// It is safe to call more than once.
 func (ds *DashboardServer) Shutdown() {
-	close(ds.shutdown)
-	
-	// Close all WebSocket connections
-	ds.clientsMu.Lock()
-	for client := range ds.clients {
-		client.Close()
+	ds.stopOnce.Do(func() {
+		close(ds.shutdown)
+		
+		// Close all WebSocket connections
+		ds.clientsMu.Lock()
+		for client := range ds.clients {
+			client.Close()
+		}
+		ds.clients = make(map[*websocket.Conn]bool)
+		ds.clientsMu.Unlock()
+		
+		shared.LogInfof("Dashboard server shutdown complete")
+	})
+}
+
+// ListenAndServe serves the dashboard on addr until ctx is done, then shuts
+// the HTTP server down along with the dashboard's updates and WebSockets,
+// which the HTTP server no longer tracks once upgraded
+func (ds *DashboardServer) ListenAndServe(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to start dashboard server: %w", err)
 	}
-	ds.clients = make(map[*websocket.Conn]bool)
-	ds.clientsMu.Unlock()
-	
-	shared.LogInfof("Dashboard server shutdown complete")
+	httpServer := &http.Server{
+		Handler:      ds,
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+	}
+	httpServer.RegisterOnShutdown(ds.Shutdown)
+	return shared.ServeHTTPUntilDone(ctx, httpServer, listener)
 }
 
 // StartDashboardServer starts the dashboard HTTP server (legacy function for compatibility)
@@ -252,12 +521,5 @@ func StartDashboardServer(addr string, cm *manager.ConnManager) error {
 	shared.LogInfof("Starting dashboard server on %s", addr)
 	shared.LogInfof("Dashboard available at: http://localhost%s", addr)
 	
-	httpServer := &http.Server{
-		Addr:         addr,
-		Handler:      server,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-	}
-	
-	return httpServer.ListenAndServe()
+	return server.ListenAndServe(context.Background(), addr)
 }
\ No newline at end of file
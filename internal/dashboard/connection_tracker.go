@@ -1,9 +1,11 @@
 package dashboard
 
 import (
-	"fmt"
+	"context"
 	"sync"
 	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/ui"
 )
 
 // TrackedConnection represents a monitored connection
@@ -17,6 +19,25 @@ type TrackedConnection struct {
 	LastActivity  time.Time `json:"last_activity"`
 	Latency       float64   `json:"latency_ms"`
 	State         string    `json:"state"` // active, closing, error
+	
+	version uint64 // tracker version of the last change
+}
+
+// maxRemovalLog bounds how many removals a tracker remembers for ChangesSince
+const maxRemovalLog = 4096
+
+// removal records a connection deleted from a tracker
+type removal struct {
+	id      string
+	version uint64
+}
+
+// ConnectionChanges is what changed in a ConnectionTracker after a version
+type ConnectionChanges struct {
+	Version uint64              // pass to the next ChangesSince call
+	Full    bool                // Changed holds every connection; forget any others
+	Changed []TrackedConnection // connections added or updated after the version
+	Removed []string            // IDs of connections deleted after the version
 }
 
 // ConnectionTracker manages active connections for dashboard monitoring
@@ -25,6 +46,11 @@ type ConnectionTracker struct {
 	connections map[string]*TrackedConnection
 	// Historical data for graphs (ring buffer)
 	history     *MetricHistory
+	
+	// version counts changes, so readers can copy only what changed
+	version     uint64
+	removals    []removal
+	removedUpTo uint64 // version of the newest removal dropped from removals
 }
 
 // MetricHistory stores time-series data in a ring buffer
@@ -62,7 +88,7 @@ func (ct *ConnectionTracker) AddConnection(id, clientAddr, destination string) {
 	ct.mu.Lock()
 	defer ct.mu.Unlock()
 	
-	ct.connections[id] = &TrackedConnection{
+	conn := &TrackedConnection{
 		ID:           id,
 		ClientAddr:   clientAddr,
 		Destination:  destination,
@@ -70,9 +96,11 @@ func (ct *ConnectionTracker) AddConnection(id, clientAddr, destination string) {
 		LastActivity: time.Now(),
 		State:        "active",
 	}
+	ct.connections[id] = conn
+	ct.touch(conn)
 	
 	// Debug logging
-	fmt.Printf("🔗 Dashboard: Added connection %s: %s -> %s (total: %d)\n", id, clientAddr, destination, len(ct.connections))
+	ui.Printf("🔗 Dashboard: Added connection %s: %s -> %s (total: %d)\n", id, clientAddr, destination, len(ct.connections))
 }
 
 // UpdateConnection updates connection metrics
@@ -87,6 +115,7 @@ func (ct *ConnectionTracker) UpdateConnection(id string, bytesIn, bytesOut int64
 		if latency > 0 {
 			conn.Latency = latency
 		}
+		ct.touch(conn)
 	}
 }
 
@@ -97,13 +126,14 @@ func (ct *ConnectionTracker) RemoveConnection(id string) {
 	
 	if conn, exists := ct.connections[id]; exists {
 		conn.State = "closing"
-		fmt.Printf("🔚 Dashboard: Closing connection %s: %s -> %s\n", id, conn.ClientAddr, conn.Destination)
+		ct.touch(conn)
+		ui.Printf("🔚 Dashboard: Closing connection %s: %s -> %s\n", id, conn.ClientAddr, conn.Destination)
 		// Keep it for a short time for UI transitions
 		go func() {
 			time.Sleep(2 * time.Second)
 			ct.mu.Lock()
-			delete(ct.connections, id)
-			fmt.Printf("🗑️  Dashboard: Removed connection %s (remaining: %d)\n", id, len(ct.connections))
+			ct.remove(id)
+			ui.Printf("🗑️  Dashboard: Removed connection %s (remaining: %d)\n", id, len(ct.connections))
 			ct.mu.Unlock()
 		}()
 	}
@@ -116,9 +146,60 @@ func (ct *ConnectionTracker) SetConnectionError(id string) {
 	
 	if conn, exists := ct.connections[id]; exists {
 		conn.State = "error"
+		ct.touch(conn)
+	}
+}
+
+// touch records a change to conn. ct.mu must be held.
+func (ct *ConnectionTracker) touch(conn *TrackedConnection) {
+	ct.version++
+	conn.version = ct.version
+}
+
+// remove deletes a connection and logs the removal for ChangesSince. ct.mu
+// must be held.
+func (ct *ConnectionTracker) remove(id string) {
+	if _, exists := ct.connections[id]; !exists {
+		return
+	}
+	delete(ct.connections, id)
+	ct.version++
+	ct.removals = append(ct.removals, removal{id: id, version: ct.version})
+	if len(ct.removals) > maxRemovalLog {
+		dropped := len(ct.removals) - maxRemovalLog
+		ct.removedUpTo = ct.removals[dropped-1].version
+		ct.removals = append([]removal(nil), ct.removals[dropped:]...)
 	}
 }
 
+// ChangesSince returns copies of the connections changed after version, and
+// the IDs of those removed, so a reader keeping its own copy of the
+// connections needn't copy them all each time. Version 0, or one older than
+// the removals remembered, returns every connection with Full set.
+func (ct *ConnectionTracker) ChangesSince(version uint64) ConnectionChanges {
+	ct.mu.RLock()
+	defer ct.mu.RUnlock()
+	
+	changes := ConnectionChanges{Version: ct.version}
+	changes.Full = version == 0 || version < ct.removedUpTo || version > ct.version
+	if !changes.Full && version == ct.version {
+		return changes
+	}
+	for _, conn := range ct.connections {
+		if changes.Full || conn.version > version {
+			changes.Changed = append(changes.Changed, *conn)
+		}
+	}
+	if !changes.Full {
+		for _, removed := range ct.removals {
+			if removed.version > version {
+				changes.Removed = append(changes.Removed, removed.id)
+			}
+		}
+	}
+	return changes
+}
+
 // GetActiveConnections returns all currently tracked connections
 func (ct *ConnectionTracker) GetActiveConnections() []*TrackedConnection {
 	ct.mu.RLock()
@@ -221,63 +302,70 @@ func (ct *ConnectionTracker) GetHistory() ([]time.Time, []int, []float64, []floa
 	return timestamps, connCounts, byteRates, latencies
 }
 
-// Global instance
+// Run records a data point in the tracker's history every second until ctx is done
+func (ct *ConnectionTracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	
+	var lastTotalBytes int64
+	var lastTime time.Time = time.Now()
+	
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			totalIn, totalOut := ct.GetTotalBytes()
+			currentTotalBytes := totalIn + totalOut
+			
+			now := time.Now()
+			duration := now.Sub(lastTime).Seconds()
+			
+			var byteRate float64
+			if duration > 0 && currentTotalBytes >= lastTotalBytes {
+				byteRate = float64(currentTotalBytes-lastTotalBytes) / duration
+			}
+			
+			ct.RecordMetrics(byteRate)
+			
+			lastTotalBytes = currentTotalBytes
+			lastTime = now
+		}
+	}
+}
+
+// GlobalConnectionTracker is used by proxies and dashboards not given a
+// tracker of their own. New code should create one with NewConnectionTracker.
 var GlobalConnectionTracker = NewConnectionTracker()
 
-// Metrics collection control
+// Metrics collection control for GlobalConnectionTracker
 var (
-	metricsStopCh = make(chan struct{})
-	metricsRunning = false
+	metricsMu     sync.Mutex
+	metricsCancel context.CancelFunc
 )
 
-// StartMetricsCollection begins collecting metrics at regular intervals
+// StartMetricsCollection begins collecting metrics for GlobalConnectionTracker.
+//
+// Deprecated: run ConnectionTracker.Run with a context instead.
 func StartMetricsCollection() {
-	if metricsRunning {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	if metricsCancel != nil {
 		return
 	}
-	metricsRunning = true
 	
-	go func() {
-		ticker := time.NewTicker(1 * time.Second)
-		defer ticker.Stop()
-		
-		var lastTotalBytes int64
-		var lastTime time.Time = time.Now()
-		
-		for {
-			select {
-			case <-metricsStopCh:
-				return
-			case <-ticker.C:
-				totalIn, totalOut := GlobalConnectionTracker.GetTotalBytes()
-				currentTotalBytes := totalIn + totalOut
-				
-				now := time.Now()
-				duration := now.Sub(lastTime).Seconds()
-				
-				var byteRate float64
-				if duration > 0 && currentTotalBytes >= lastTotalBytes {
-					byteRate = float64(currentTotalBytes-lastTotalBytes) / duration
-				}
-				
-				GlobalConnectionTracker.RecordMetrics(byteRate)
-				
-				lastTotalBytes = currentTotalBytes
-				lastTime = now
-			}
-		}
-	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	metricsCancel = cancel
+	go GlobalConnectionTracker.Run(ctx)
 }
 
-// StopMetricsCollection stops the metrics collection goroutine
+// StopMetricsCollection stops collection started by StartMetricsCollection
 func StopMetricsCollection() {
-	if !metricsRunning {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	if metricsCancel == nil {
 		return
 	}
-	metricsRunning = false
-	
-	select {
-	case metricsStopCh <- struct{}{}:
-	default: // Channel might be full, that's ok
-	}
-}
\ No newline at end of file
+	metricsCancel()
+	metricsCancel = nil
+}
@@ -0,0 +1,132 @@
+package dashboard
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestConnectionTrackersAreIndependent(t *testing.T) {
+	first := NewConnectionTracker()
+	second := NewConnectionTracker()
+
+	first.AddConnection("c1", "127.0.0.1:5000", "example.com:443")
+	first.UpdateConnection("c1", 100, 50, 12)
+	if got := first.GetConnectionCount(); got != 1 {
+		t.Errorf("Expected 1 connection in the first tracker, got %d", got)
+	}
+	if got := second.GetConnectionCount(); got != 0 {
+		t.Errorf("Expected the second tracker to be empty, got %d", got)
+	}
+	if got := GlobalConnectionTracker.GetConnectionCount(); got != 0 {
+		t.Errorf("Expected the global tracker untouched, got %d", got)
+	}
+
+	collector := NewDashboardCollector(nil)
+	collector.tracker = first
+	if got := len(collector.CollectDashboardData().Connections); got != 1 {
+		t.Errorf("Expected the dashboard to show the injected tracker's connection, got %d", got)
+	}
+}
+
+func TestConnectionTrackerRunStopsWithContext(t *testing.T) {
+	tracker := NewConnectionTracker()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		tracker.Run(ctx)
+		close(done)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after its context was cancelled")
+	}
+}
+
+func TestChangesSince(t *testing.T) {
+	tracker := NewConnectionTracker()
+	tracker.AddConnection("c1", "127.0.0.1:5000", "example.com:443")
+	tracker.AddConnection("c2", "127.0.0.1:5001", "example.org:443")
+
+	changes := tracker.ChangesSince(0)
+	if !changes.Full || len(changes.Changed) != 2 {
+		t.Fatalf("Expected every connection first, got %+v", changes)
+	}
+	if again := tracker.ChangesSince(changes.Version); len(again.Changed) != 0 || again.Full {
+		t.Errorf("Expected no changes without activity, got %+v", again)
+	}
+
+	tracker.UpdateConnection("c2", 10, 0, 0)
+	tracker.mu.Lock()
+	tracker.remove("c1")
+	tracker.mu.Unlock()
+	changes = tracker.ChangesSince(changes.Version)
+	if changes.Full || len(changes.Changed) != 1 || changes.Changed[0].ID != "c2" || changes.Changed[0].BytesIn != 10 {
+		t.Errorf("Expected only c2 to have changed, got %+v", changes.Changed)
+	}
+	if len(changes.Removed) != 1 || changes.Removed[0] != "c1" {
+		t.Errorf("Expected c1 removed, got %v", changes.Removed)
+	}
+
+	// A reader further behind than the removal log starts over
+	stale := changes.Version
+	tracker.mu.Lock()
+	for i := 0; i <= maxRemovalLog; i++ {
+		id := fmt.Sprintf("x%d", i)
+		tracker.connections[id] = &TrackedConnection{ID: id}
+		tracker.remove(id)
+	}
+	tracker.mu.Unlock()
+	if changes = tracker.ChangesSince(stale); !changes.Full || len(changes.Changed) != 1 {
+		t.Errorf("Expected a full reload with the one remaining connection, got %d changed, full %v", len(changes.Changed), changes.Full)
+	}
+}
+
+func TestCollectorAppliesChanges(t *testing.T) {
+	tracker := NewConnectionTracker()
+	collector := NewDashboardCollector(nil)
+	collector.tracker = tracker
+
+	tracker.AddConnection("c1", "127.0.0.1:5000", "example.com:443")
+	tracker.AddConnection("c2", "127.0.0.1:5001", "example.com:443")
+	if got := len(collector.collectConnections()); got != 2 {
+		t.Fatalf("Expected 2 connections, got %d", got)
+	}
+
+	tracker.UpdateConnection("c1", 100, 0, 0)
+	tracker.mu.Lock()
+	tracker.remove("c2")
+	tracker.mu.Unlock()
+	connections := collector.collectConnections()
+	if len(connections) != 1 || connections[0].ID != "c1" || connections[0].BytesIn != 100 {
+		t.Errorf("Expected c1 alone with its update, got %+v", connections)
+	}
+
+	// A replaced tracker is read afresh
+	collector.tracker = NewConnectionTracker()
+	if got := len(collector.collectConnections()); got != 0 {
+		t.Errorf("Expected the new tracker's connections only, got %d", got)
+	}
+}
+
+func TestUpdateInterval(t *testing.T) {
+	tests := []struct {
+		connections int
+		took        time.Duration
+		want        time.Duration
+	}{
+		{0, time.Millisecond, time.Second},
+		{2500, time.Millisecond, 3 * time.Second},
+		{10, 100 * time.Millisecond, 5 * time.Second},
+		{100000, time.Millisecond, maxUpdateInterval},
+	}
+	for _, tt := range tests {
+		if got := updateInterval(tt.connections, tt.took); got != tt.want {
+			t.Errorf("updateInterval(%d, %v) = %v, expected %v", tt.connections, tt.took, got, tt.want)
+		}
+	}
+}
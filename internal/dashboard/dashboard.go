@@ -3,10 +3,14 @@ package dashboard
 import (
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/anomaly"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/cost"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/manager"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/metrics"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
 )
 
 // DestinationStats aggregates metrics for a specific destination
@@ -29,6 +33,17 @@ type SessionInfo struct {
 	TimeToLive     time.Duration `json:"ttl"`              // Remaining time before rotation
 	Status         string        `json:"status"`           // healthy, degraded, unhealthy
 	LambdaPublicIP string        `json:"lambda_public_ip"` // Lambda public IP address
+	ActiveStreams  int64         `json:"active_streams"`   // Tunnel streams open; a draining session waits for these
+	Crypto         shared.TunnelCrypto `json:"crypto"`     // Negotiated TLS and QUIC versions
+	Expiring       bool          `json:"expiring,omitempty"` // The Lambda is near its function timeout and takes no new streams
+	Relayed        bool          `json:"relayed,omitempty"`  // Tunnelled over TCP through the relay
+}
+
+// SessionsResponse is served by /api/sessions: the live sessions and the
+// phase breakdowns of recent launches, newest first
+type SessionsResponse struct {
+	Sessions []SessionInfo          `json:"sessions"`
+	Launches []manager.LaunchRecord `json:"launches"`
 }
 
 // DashboardData is the main data structure sent to the frontend
@@ -41,9 +56,27 @@ type DashboardData struct {
 	AvgLatency       float64 `json:"avg_latency"`
 	PublicIP         string  `json:"public_ip"`        // Current public IP address
 	
+	// Tunnels closed by the idle reaper since startup
+	ReapedConnections int64 `json:"reaped_connections"`
+	
+	// Deployment being monitored, if a deployment source is configured
+	Deployment *DeploymentStatus `json:"deployment,omitempty"`
+	
+	// Usage against the budget, if one is configured
+	Budget *cost.BudgetStatus `json:"budget,omitempty"`
+	
 	// Session information
 	Sessions []SessionInfo `json:"sessions"`
 	
+	// Recent rotation attempts, newest first
+	Rotations []manager.RotationRecord `json:"rotations"`
+	
+	// Phase breakdowns of recent session launches, newest first
+	Launches []manager.LaunchRecord `json:"launches"`
+	
+	// Recent session health anomalies, newest first
+	Anomalies []anomaly.Event `json:"anomalies"`
+	
 	// Connection details
 	Connections []TrackedConnection `json:"connections"`
 	
@@ -69,13 +102,24 @@ type DashboardData struct {
 // DashboardCollector aggregates data from various sources
 type DashboardCollector struct {
 	connectionManager *manager.ConnManager
+	deployment        *deploymentMonitor
+	anomalies         *anomaly.Detector
+	tracker           *ConnectionTracker
 	startTime         time.Time
+	
+	// connections mirrors the tracker's, updated from its changes so a
+	// collection copies only the connections that changed
+	connMu            sync.Mutex
+	connTracker       *ConnectionTracker
+	connVersion       uint64
+	connections       map[string]TrackedConnection
 }
 
 // NewDashboardCollector creates a new dashboard data collector
 func NewDashboardCollector(cm *manager.ConnManager) *DashboardCollector {
 	return &DashboardCollector{
 		connectionManager: cm,
+		tracker:           GlobalConnectionTracker,
 		startTime:         time.Now(),
 	}
 }
@@ -88,15 +132,19 @@ func (dc *DashboardCollector) CollectDashboardData() *DashboardData {
 	data.Uptime = time.Since(dc.startTime).String()
 	data.Status = dc.getSystemStatus()
 	data.PublicIP = dc.getPublicIP()
+	if dc.deployment != nil {
+		data.Deployment = dc.deployment.current()
+	}
+	if dc.connectionManager != nil {
+		data.Budget = dc.connectionManager.BudgetStatus()
+	}
 	
 	// Connection metrics
-	connections := GlobalConnectionTracker.GetActiveConnections()
-	data.Connections = make([]TrackedConnection, len(connections))
-	for i, conn := range connections {
-		data.Connections[i] = *conn
-	}
+	connections := dc.collectConnections()
+	data.Connections = connections
 	
 	data.TotalConnections = len(data.Connections)
+	data.ReapedConnections = metrics.GetSOCKS5IdleReaped()
 	
 	// Use session RTT if available and meaningful, otherwise use connection tracker
 	sessionRTT := 0.0
@@ -104,7 +152,7 @@ func (dc *DashboardCollector) CollectDashboardData() *DashboardData {
 		sessionRTT = data.Sessions[0].RTT
 	}
 	
-	connLatency := GlobalConnectionTracker.GetAverageLatency()
+	connLatency := dc.tracker.GetAverageLatency()
 	
 	// Use whichever latency is more meaningful (non-zero and reasonable)
 	if sessionRTT > 0 && sessionRTT < 1000 { // Less than 1 second seems reasonable
@@ -121,6 +169,9 @@ func (dc *DashboardCollector) CollectDashboardData() *DashboardData {
 	
 	// Session information
 	data.Sessions = dc.collectSessionInfo()
+	data.Rotations = dc.collectRotations()
+	data.Launches = dc.collectLaunches()
+	data.Anomalies = dc.collectAnomalies()
 	
 	// Top destinations
 	data.TopDestinations = dc.calculateDestinationStats(connections)
@@ -134,6 +185,35 @@ func (dc *DashboardCollector) CollectDashboardData() *DashboardData {
 	return data
 }
 
+// collectConnections returns the tracker's connections, applying only what
+// changed since the last collection to the collector's copy
+func (dc *DashboardCollector) collectConnections() []TrackedConnection {
+	dc.connMu.Lock()
+	defer dc.connMu.Unlock()
+	
+	if dc.connTracker != dc.tracker {
+		dc.connTracker = dc.tracker
+		dc.connVersion = 0
+	}
+	changes := dc.tracker.ChangesSince(dc.connVersion)
+	if changes.Full || dc.connections == nil {
+		dc.connections = make(map[string]TrackedConnection, len(changes.Changed))
+	}
+	for _, conn := range changes.Changed {
+		dc.connections[conn.ID] = conn
+	}
+	for _, id := range changes.Removed {
+		delete(dc.connections, id)
+	}
+	dc.connVersion = changes.Version
+	
+	connections := make([]TrackedConnection, 0, len(dc.connections))
+	for _, conn := range dc.connections {
+		connections = append(connections, conn)
+	}
+	return connections
+}
+
 // getSystemStatus determines overall system health
 func (dc *DashboardCollector) getSystemStatus() string {
 	// Check if we have healthy sessions
@@ -150,7 +230,7 @@ func (dc *DashboardCollector) getSystemStatus() string {
 
 // calculateCurrentByteRate estimates current byte transfer rate
 func (dc *DashboardCollector) calculateCurrentByteRate() float64 {
-	timestamps, _, byteRates, _ := GlobalConnectionTracker.GetHistory()
+	timestamps, _, byteRates, _ := dc.tracker.GetHistory()
 	
 	// Get average of last 10 data points (10 seconds)
 	var sum float64
@@ -189,7 +269,11 @@ func (dc *DashboardCollector) collectSessionInfo() []SessionInfo {
 			Duration:       time.Since(session.StartedAt),
 			RTT:            float64(metrics.GetLastRTT().Milliseconds()),
 			TimeToLive:     session.RemainingTTL(),
-			LambdaPublicIP: session.LambdaPublicIP,
+			LambdaPublicIP: shared.RedactIP(session.LambdaPublicIP),
+			ActiveStreams:  session.ActiveStreams(),
+			Crypto:         session.Crypto,
+			Expiring:       session.IsExpiring(),
+			Relayed:        session.Relayed,
 		}
 		
 		// Calculate health score (0-100)
@@ -202,6 +286,30 @@ func (dc *DashboardCollector) collectSessionInfo() []SessionInfo {
 	return sessions
 }
 
+// collectRotations gathers the rotation timeline from the connection manager
+func (dc *DashboardCollector) collectRotations() []manager.RotationRecord {
+	if dc.connectionManager == nil {
+		return []manager.RotationRecord{}
+	}
+	return dc.connectionManager.Rotations()
+}
+
+// collectLaunches gathers recent launch timings from the connection manager
+func (dc *DashboardCollector) collectLaunches() []manager.LaunchRecord {
+	if dc.connectionManager == nil {
+		return []manager.LaunchRecord{}
+	}
+	return dc.connectionManager.Launches()
+}
+
+// collectAnomalies gathers recent anomalies from the detector, if enabled
+func (dc *DashboardCollector) collectAnomalies() []anomaly.Event {
+	if dc.anomalies == nil {
+		return []anomaly.Event{}
+	}
+	return dc.anomalies.Events()
+}
+
 // calculateSessionHealth computes a 0-100 health score for a session
 func (dc *DashboardCollector) calculateSessionHealth(session *manager.Session) float64 {
 	if !session.IsHealthy() {
@@ -248,7 +356,7 @@ func (dc *DashboardCollector) getSessionStatus(health float64) string {
 }
 
 // calculateDestinationStats aggregates connection data by destination
-func (dc *DashboardCollector) calculateDestinationStats(connections []*TrackedConnection) []DestinationStats {
+func (dc *DashboardCollector) calculateDestinationStats(connections []TrackedConnection) []DestinationStats {
 	destMap := make(map[string]*DestinationStats)
 	
 	// Aggregate by destination
@@ -321,7 +429,7 @@ func (dc *DashboardCollector) extractHostname(destination string) string {
 
 // collectHistoryData gathers historical metrics
 func (dc *DashboardCollector) collectHistoryData(data *DashboardData) {
-	timestamps, connCounts, byteRates, latencies := GlobalConnectionTracker.GetHistory()
+	timestamps, connCounts, byteRates, latencies := dc.tracker.GetHistory()
 	
 	// Convert timestamps to Unix milliseconds and filter out zero values
 	data.History.Timestamps = make([]int64, 0, len(timestamps))
@@ -358,7 +466,7 @@ func (dc *DashboardCollector) getPublicIP() string {
 	// Get Lambda public IP from current session
 	if currentSession := dc.connectionManager.GetCurrent(); currentSession != nil {
 		if currentSession.LambdaPublicIP != "" {
-			return currentSession.LambdaPublicIP
+			return shared.RedactIP(currentSession.LambdaPublicIP)
 		}
 	}
 	
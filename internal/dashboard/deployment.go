@@ -0,0 +1,79 @@
+package dashboard
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// deploymentRefreshInterval limits how often the deployment source is polled.
+// The dashboard broadcasts every second; AWS describe calls don't need to.
+const deploymentRefreshInterval = 30 * time.Second
+
+// deploymentProbeTimeout bounds a single deployment status check
+const deploymentProbeTimeout = 15 * time.Second
+
+// DeploymentStatus summarizes the AWS deployment shown on the dashboard
+type DeploymentStatus struct {
+	StackName   string    `json:"stack_name"`
+	StackStatus string    `json:"stack_status"`
+	Region      string    `json:"region"`
+	AccountID   string    `json:"account_id,omitempty"`
+	LambdaName  string    `json:"lambda_name,omitempty"`
+	LambdaState string    `json:"lambda_state,omitempty"`
+	ReadOnly    bool      `json:"read_only"`
+	Error       string    `json:"error,omitempty"`
+	CheckedAt   time.Time `json:"checked_at"`
+}
+
+// DeploymentSource reports the current status of a deployment
+type DeploymentSource func(ctx context.Context) (*DeploymentStatus, error)
+
+// deploymentMonitor caches the status reported by a DeploymentSource and
+// refreshes it in the background so dashboard collection never blocks on AWS
+type deploymentMonitor struct {
+	source DeploymentSource
+	
+	mu         sync.Mutex
+	status     *DeploymentStatus
+	refreshing bool
+}
+
+// current returns the last known status, starting a refresh if it is stale
+func (m *deploymentMonitor) current() *DeploymentStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	
+	stale := m.status == nil || time.Since(m.status.CheckedAt) >= deploymentRefreshInterval
+	if stale && !m.refreshing {
+		m.refreshing = true
+		go m.refresh()
+	}
+	
+	if m.status == nil {
+		return nil
+	}
+	status := *m.status
+	return &status
+}
+
+func (m *deploymentMonitor) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), deploymentProbeTimeout)
+	defer cancel()
+	
+	status, err := m.source(ctx)
+	if status == nil {
+		status = &DeploymentStatus{}
+	}
+	if err != nil {
+		log.Printf("Dashboard: deployment status check failed: %v", err)
+		status.Error = err.Error()
+	}
+	status.CheckedAt = time.Now()
+	
+	m.mu.Lock()
+	m.status = status
+	m.refreshing = false
+	m.mu.Unlock()
+}
@@ -0,0 +1,123 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/events"
+)
+
+// eventKeepalive is how often an idle event stream gets a comment, so
+// proxies and clients between don't time it out
+const eventKeepalive = 15 * time.Second
+
+// handleEvents streams the event bus as Server-Sent Events, one JSON event
+// per message with its ID as the SSE id. A client reconnecting with the
+// Last-Event-ID header, or the since query parameter, first gets the kept
+// events it missed. The type query parameter keeps only the listed event
+// types or categories, e.g. type=session,connection.closed. A client too
+// slow to keep up is disconnected and can resume the same way.
+func (ds *DashboardServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if ds.events == nil {
+		http.Error(w, "Event stream not enabled", http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("since")
+	}
+	var sub *events.Subscription
+	var missed []events.Event
+	if lastID != "" {
+		id, err := strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid event ID %q", lastID), http.StatusBadRequest)
+			return
+		}
+		sub, missed = ds.events.Resume(id)
+	} else {
+		sub = ds.events.Subscribe()
+	}
+	defer sub.Close()
+	wanted := eventFilter(r.URL.Query().Get("type"))
+
+	// The server's write timeout would end the stream
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(e events.Event) error {
+		if !wanted(e.Type) {
+			return nil
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", e.ID, data)
+		return err
+	}
+	for _, e := range missed {
+		if send(e) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return // fell behind; the client resumes from its last ID
+			}
+			if send(e) != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-ds.shutdown:
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// eventFilter returns whether an event type is among the comma-separated
+// types or categories in list; an empty list keeps every type
+func eventFilter(list string) func(string) bool {
+	if list == "" {
+		return func(string) bool { return true }
+	}
+	wanted := strings.Split(list, ",")
+	return func(eventType string) bool {
+		category, _, _ := strings.Cut(eventType, ".")
+		for _, w := range wanted {
+			w = strings.TrimSpace(w)
+			if w == eventType || w == category {
+				return true
+			}
+		}
+		return false
+	}
+}
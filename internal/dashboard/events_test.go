@@ -0,0 +1,65 @@
+package dashboard
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/events"
+)
+
+func TestHandleEvents(t *testing.T) {
+	bus := events.NewBus()
+	ds := &DashboardServer{shutdown: make(chan struct{}), events: bus}
+	server := httptest.NewServer(http.HandlerFunc(ds.handleEvents))
+	defer server.Close()
+	defer close(ds.shutdown)
+
+	bus.Publish(events.Event{Type: events.SessionLaunched, Session: "a"})
+	bus.Publish(events.Event{Type: events.ConnectionOpened, Session: "a"})
+
+	// Resuming after event 1 replays the session events after it, then streams new ones
+	req, _ := http.NewRequest("GET", server.URL+"?type=session", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+	bus.Publish(events.Event{Type: events.SessionClosed, Session: "a"})
+
+	lines := bufio.NewScanner(resp.Body)
+	var got []events.Event
+	var ids []string
+	for len(got) < 1 && lines.Scan() {
+		line := lines.Text()
+		if id, ok := strings.CutPrefix(line, "id: "); ok {
+			ids = append(ids, id)
+		}
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			var e events.Event
+			if err := json.Unmarshal([]byte(data), &e); err != nil {
+				t.Fatalf("bad event %q: %v", data, err)
+			}
+			got = append(got, e)
+		}
+	}
+	if len(got) != 1 || got[0].ID != 3 || got[0].Type != events.SessionClosed || ids[0] != "3" {
+		t.Fatalf("got events %+v with IDs %v, want only event 3 (%s)", got, ids, events.SessionClosed)
+	}
+}
+
+func TestHandleEventsBadID(t *testing.T) {
+	ds := &DashboardServer{shutdown: make(chan struct{}), events: events.NewBus()}
+	rec := httptest.NewRecorder()
+	ds.handleEvents(rec, httptest.NewRequest("GET", "/api/events?since=abc", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
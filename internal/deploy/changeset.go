@@ -0,0 +1,129 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	cftypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+
+	awsclients "github.com/dan-v/lambda-nat-punch-proxy/internal/aws"
+)
+
+// changeSetPrefix names the change sets created to preview an update
+const changeSetPrefix = "lambda-nat-proxy-preview-"
+
+// ResourceChange is one resource a stack update would touch
+type ResourceChange struct {
+	Action      string // Add, Modify, Remove, Import or Dynamic
+	LogicalID   string
+	Type        string
+	Replacement string // True, False or Conditional for modifications
+}
+
+// ChangePreview is what deploying the template would do to the stack
+type ChangePreview struct {
+	StackExists bool // false if deploying would create the stack
+	Changes     []ResourceChange
+}
+
+// PreviewChanges creates a change set for updating the stack to
+// templateBody, reads the resource changes from it and deletes it again, so
+// nothing is applied. If the stack does not exist yet, the preview reports
+// that instead.
+func (s *StackDeployer) PreviewChanges(ctx context.Context, templateBody string) (*ChangePreview, error) {
+	stackName := s.getFullStackName()
+
+	exists, err := s.stackExists(ctx, stackName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if stack exists: %w", err)
+	}
+	if !exists {
+		return &ChangePreview{}, nil
+	}
+
+	changeSetName := fmt.Sprintf("%s%d", changeSetPrefix, time.Now().Unix())
+	_, err = s.clients.CloudFormation.CreateChangeSet(ctx, &cloudformation.CreateChangeSetInput{
+		StackName:     aws.String(stackName),
+		ChangeSetName: aws.String(changeSetName),
+		ChangeSetType: cftypes.ChangeSetTypeUpdate,
+		TemplateBody:  aws.String(templateBody),
+		Parameters:    s.buildStackParameters(),
+		Capabilities: []cftypes.Capability{
+			cftypes.CapabilityCapabilityNamedIam,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create change set: %w", err)
+	}
+	defer func() {
+		// The change set is only a preview; a leftover one is harmless but clutters the console
+		if _, err := s.clients.CloudFormation.DeleteChangeSet(context.Background(), &cloudformation.DeleteChangeSetInput{
+			StackName:     aws.String(stackName),
+			ChangeSetName: aws.String(changeSetName),
+		}); err != nil {
+			log.Printf("⚠️  Failed to delete change set %s: %v", changeSetName, err)
+		}
+	}()
+
+	var noChanges bool
+	checkFn := func() (bool, error) {
+		result, err := s.clients.CloudFormation.DescribeChangeSet(ctx, &cloudformation.DescribeChangeSetInput{
+			StackName:     aws.String(stackName),
+			ChangeSetName: aws.String(changeSetName),
+		})
+		if err != nil {
+			return false, err
+		}
+		switch result.Status {
+		case cftypes.ChangeSetStatusCreateComplete:
+			return true, nil
+		case cftypes.ChangeSetStatusFailed:
+			// CloudFormation fails a change set that would change nothing
+			reason := aws.ToString(result.StatusReason)
+			if strings.Contains(reason, "didn't contain changes") || strings.Contains(reason, "No updates are to be performed") {
+				noChanges = true
+				return true, nil
+			}
+			return false, fmt.Errorf("change set failed: %s", reason)
+		}
+		return false, nil
+	}
+	if err := awsclients.WaitForOperation(ctx, checkFn, 5*time.Minute); err != nil {
+		return nil, fmt.Errorf("failed to create change set: %w", err)
+	}
+
+	preview := &ChangePreview{StackExists: true}
+	if noChanges {
+		return preview, nil
+	}
+	input := &cloudformation.DescribeChangeSetInput{
+		StackName:     aws.String(stackName),
+		ChangeSetName: aws.String(changeSetName),
+	}
+	for {
+		result, err := s.clients.CloudFormation.DescribeChangeSet(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe change set: %w", err)
+		}
+		for _, change := range result.Changes {
+			if change.ResourceChange == nil {
+				continue
+			}
+			preview.Changes = append(preview.Changes, ResourceChange{
+				Action:      string(change.ResourceChange.Action),
+				LogicalID:   aws.ToString(change.ResourceChange.LogicalResourceId),
+				Type:        aws.ToString(change.ResourceChange.ResourceType),
+				Replacement: string(change.ResourceChange.Replacement),
+			})
+		}
+		if result.NextToken == nil {
+			return preview, nil
+		}
+		input.NextToken = result.NextToken
+	}
+}
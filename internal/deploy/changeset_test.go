@@ -0,0 +1,124 @@
+package deploy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	cftypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/aws/smithy-go"
+
+	awsclients "github.com/dan-v/lambda-nat-punch-proxy/internal/aws"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+)
+
+// changeSetCloudFormation serves one change set with its changes over two pages
+type changeSetCloudFormation struct {
+	awsclients.CloudFormationAPI
+	exists  bool
+	reason  string // set to fail the change set
+	changes []cftypes.Change
+	created string
+	deleted string
+}
+
+func (f *changeSetCloudFormation) DescribeStacks(ctx context.Context, input *cloudformation.DescribeStacksInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DescribeStacksOutput, error) {
+	if !f.exists {
+		return nil, &smithy.GenericAPIError{Code: "ValidationError", Message: "Stack does not exist"}
+	}
+	return &cloudformation.DescribeStacksOutput{Stacks: []cftypes.Stack{{StackName: input.StackName}}}, nil
+}
+
+func (f *changeSetCloudFormation) CreateChangeSet(ctx context.Context, input *cloudformation.CreateChangeSetInput, optFns ...func(*cloudformation.Options)) (*cloudformation.CreateChangeSetOutput, error) {
+	f.created = aws.ToString(input.ChangeSetName)
+	return &cloudformation.CreateChangeSetOutput{}, nil
+}
+
+func (f *changeSetCloudFormation) DescribeChangeSet(ctx context.Context, input *cloudformation.DescribeChangeSetInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DescribeChangeSetOutput, error) {
+	if f.reason != "" {
+		return &cloudformation.DescribeChangeSetOutput{
+			Status:       cftypes.ChangeSetStatusFailed,
+			StatusReason: aws.String(f.reason),
+		}, nil
+	}
+	output := &cloudformation.DescribeChangeSetOutput{Status: cftypes.ChangeSetStatusCreateComplete}
+	if input.NextToken == nil {
+		output.Changes = f.changes[:1]
+		output.NextToken = aws.String("2")
+	} else {
+		output.Changes = f.changes[1:]
+	}
+	return output, nil
+}
+
+func (f *changeSetCloudFormation) DeleteChangeSet(ctx context.Context, input *cloudformation.DeleteChangeSetInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DeleteChangeSetOutput, error) {
+	f.deleted = aws.ToString(input.ChangeSetName)
+	return &cloudformation.DeleteChangeSetOutput{}, nil
+}
+
+func resourceChange(action, id, resourceType, replacement string) cftypes.Change {
+	change := &cftypes.ResourceChange{
+		Action:            cftypes.ChangeAction(action),
+		LogicalResourceId: aws.String(id),
+		ResourceType:      aws.String(resourceType),
+	}
+	if replacement != "" {
+		change.Replacement = cftypes.Replacement(replacement)
+	}
+	return cftypes.Change{Type: cftypes.ChangeTypeResource, ResourceChange: change}
+}
+
+func TestPreviewChanges(t *testing.T) {
+	cfg := config.DefaultCLIConfig()
+	cfg.Deployment.StackName = "proxy"
+	cf := &changeSetCloudFormation{exists: true, changes: []cftypes.Change{
+		resourceChange("Modify", "CoordinationBucket", "AWS::S3::Bucket", "False"),
+		resourceChange("Add", "WarmupRule", "AWS::Events::Rule", ""),
+	}}
+	deployer := NewStackDeployer(&awsclients.Clients{CloudFormation: cf}, cfg)
+
+	preview, err := deployer.PreviewChanges(context.Background(), "{}")
+	if err != nil {
+		t.Fatalf("PreviewChanges failed: %v", err)
+	}
+	if !preview.StackExists || len(preview.Changes) != 2 {
+		t.Fatalf("Expected 2 changes to the existing stack, got %+v", preview)
+	}
+	if got := preview.Changes[0]; got.Action != "Modify" || got.LogicalID != "CoordinationBucket" || got.Replacement != "False" {
+		t.Errorf("Unexpected first change %+v", got)
+	}
+	if preview.Changes[1].Type != "AWS::Events::Rule" {
+		t.Errorf("Expected the second page's change, got %+v", preview.Changes[1])
+	}
+	if cf.created == "" || cf.deleted != cf.created {
+		t.Errorf("Expected the change set %q to be deleted, deleted %q", cf.created, cf.deleted)
+	}
+}
+
+func TestPreviewChangesNoChanges(t *testing.T) {
+	cfg := config.DefaultCLIConfig()
+	cfg.Deployment.StackName = "proxy"
+	cf := &changeSetCloudFormation{exists: true, reason: "The submitted information didn't contain changes. Submit different information to create a change set."}
+	deployer := NewStackDeployer(&awsclients.Clients{CloudFormation: cf}, cfg)
+
+	preview, err := deployer.PreviewChanges(context.Background(), "{}")
+	if err != nil {
+		t.Fatalf("PreviewChanges failed: %v", err)
+	}
+	if !preview.StackExists || len(preview.Changes) != 0 || cf.deleted == "" {
+		t.Errorf("Expected no changes and a deleted change set, got %+v", preview)
+	}
+
+	cf.reason = "Template format error"
+	if _, err := deployer.PreviewChanges(context.Background(), "{}"); err == nil {
+		t.Error("Expected a failed change set to be an error")
+	}
+
+	cf.exists = false
+	cf.created = ""
+	preview, err = deployer.PreviewChanges(context.Background(), "{}")
+	if err != nil || preview.StackExists || cf.created != "" {
+		t.Errorf("Expected no change set for a missing stack, got %+v (%v)", preview, err)
+	}
+}
@@ -0,0 +1,59 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdatypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+)
+
+// ConfigureFunctionURL gives the function a URL for function_url
+// coordination and returns it. Only IAM identities allowed
+// lambda:InvokeFunctionUrl can call it, and responses are streamed so the
+// Lambda can answer before its session ends.
+func (d *LambdaDeployer) ConfigureFunctionURL(ctx context.Context) (string, error) {
+	functionName := d.getFunctionName()
+
+	created, err := d.clients.Lambda.CreateFunctionUrlConfig(ctx, &lambda.CreateFunctionUrlConfigInput{
+		FunctionName: aws.String(functionName),
+		AuthType:     lambdatypes.FunctionUrlAuthTypeAwsIam,
+		InvokeMode:   lambdatypes.InvokeModeResponseStream,
+	})
+	if err == nil {
+		log.Printf("Created function URL: %s", aws.ToString(created.FunctionUrl))
+		return aws.ToString(created.FunctionUrl), nil
+	}
+	if !isConflict(err) {
+		return "", fmt.Errorf("failed to create function URL: %w", err)
+	}
+
+	// The URL already exists; make sure it is still protected and streaming
+	updated, err := d.clients.Lambda.UpdateFunctionUrlConfig(ctx, &lambda.UpdateFunctionUrlConfigInput{
+		FunctionName: aws.String(functionName),
+		AuthType:     lambdatypes.FunctionUrlAuthTypeAwsIam,
+		InvokeMode:   lambdatypes.InvokeModeResponseStream,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to update function URL: %w", err)
+	}
+	return aws.ToString(updated.FunctionUrl), nil
+}
+
+// RemoveFunctionURL deletes the function's URL, if it has one, so a stack
+// switched back to S3 coordination no longer exposes an endpoint
+func (d *LambdaDeployer) RemoveFunctionURL(ctx context.Context) error {
+	_, err := d.clients.Lambda.DeleteFunctionUrlConfig(ctx, &lambda.DeleteFunctionUrlConfigInput{
+		FunctionName: aws.String(d.getFunctionName()),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete function URL: %w", err)
+	}
+	log.Printf("Removed function URL")
+	return nil
+}
@@ -1,18 +1,25 @@
 package deploy
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdatypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
 	
 	awsclients "github.com/dan-v/lambda-nat-punch-proxy/internal/aws"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
 )
 
 // LambdaDeployerAPI defines the interface for Lambda deployment operations
@@ -26,6 +33,7 @@ type LambdaDeployerAPI interface {
 type LambdaDeployer struct {
 	clients *awsclients.Clients
 	cfg     *config.CLIConfig
+	vpc     *lambdatypes.VpcConfig // nil leaves the function's VPC placement as is
 }
 
 // NewLambdaDeployer creates a new Lambda deployer
@@ -36,11 +44,25 @@ func NewLambdaDeployer(clients *awsclients.Clients, cfg *config.CLIConfig) *Lamb
 	}
 }
 
+// SetVPCConfig places the function in subnetIDs with securityGroupID when
+// it is next deployed, for stacks of the vpc template variant. No subnets
+// take the function out of any VPC it's in.
+func (d *LambdaDeployer) SetVPCConfig(subnetIDs []string, securityGroupID string) {
+	d.vpc = &lambdatypes.VpcConfig{
+		SubnetIds:        subnetIDs,
+		SecurityGroupIds: []string{},
+	}
+	if len(subnetIDs) > 0 && securityGroupID != "" {
+		d.vpc.SecurityGroupIds = []string{securityGroupID}
+	}
+}
+
 // LambdaDeployResult contains information about a Lambda deployment
 type LambdaDeployResult struct {
 	FunctionName    string
 	FunctionArn     string
 	Runtime         string
+	Architecture    string
 	MemorySize      int64
 	Timeout         int64
 	LastModified    string
@@ -72,6 +94,38 @@ func (d *LambdaDeployer) DeployLambdaFunction(ctx context.Context, zipPath, role
 	return d.createFunction(ctx, functionName, zipData, roleArn)
 }
 
+// CodeSHA256 returns the hex SHA-256 of a Lambda bootstrap binary, as
+// recorded in shared.CodeHashEnv
+func CodeSHA256(binary []byte) string {
+	sum := sha256.Sum256(binary)
+	return hex.EncodeToString(sum[:])
+}
+
+// packageCodeHash returns the CodeSHA256 of the bootstrap binary in a
+// deployment package. The package's own hash changes with every build.
+func packageCodeHash(zipData []byte) (string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
+	if err != nil {
+		return "", fmt.Errorf("failed to open deployment package: %w", err)
+	}
+	for _, file := range archive.File {
+		if file.Name != "bootstrap" {
+			continue
+		}
+		reader, err := file.Open()
+		if err != nil {
+			return "", fmt.Errorf("failed to read bootstrap: %w", err)
+		}
+		defer reader.Close()
+		binary, err := io.ReadAll(reader)
+		if err != nil {
+			return "", fmt.Errorf("failed to read bootstrap: %w", err)
+		}
+		return CodeSHA256(binary), nil
+	}
+	return "", fmt.Errorf("deployment package has no bootstrap")
+}
+
 // DeleteLambdaFunction deletes a Lambda function
 func (d *LambdaDeployer) DeleteLambdaFunction(ctx context.Context) error {
 	functionName := d.getFunctionName()
@@ -82,13 +136,11 @@ func (d *LambdaDeployer) DeleteLambdaFunction(ctx context.Context) error {
 		FunctionName: aws.String(functionName),
 	}
 	
-	_, err := d.clients.Lambda.DeleteFunctionWithContext(ctx, input)
+	_, err := d.clients.Lambda.DeleteFunction(ctx, input)
 	if err != nil {
-		if awsErr, ok := err.(awserr.Error); ok {
-			if awsErr.Code() == lambda.ErrCodeResourceNotFoundException {
-				log.Printf("Function %s does not exist", functionName)
-				return nil
-			}
+		if isNotFound(err) {
+			log.Printf("Function %s does not exist", functionName)
+			return nil
 		}
 		return fmt.Errorf("failed to delete function: %w", err)
 	}
@@ -105,12 +157,10 @@ func (d *LambdaDeployer) GetFunctionInfo(ctx context.Context) (*LambdaDeployResu
 		FunctionName: aws.String(functionName),
 	}
 	
-	result, err := d.clients.Lambda.GetFunctionWithContext(ctx, input)
+	result, err := d.clients.Lambda.GetFunction(ctx, input)
 	if err != nil {
-		if awsErr, ok := err.(awserr.Error); ok {
-			if awsErr.Code() == lambda.ErrCodeResourceNotFoundException {
-				return nil, fmt.Errorf("function not found: %s", functionName)
-			}
+		if isNotFound(err) {
+			return nil, fmt.Errorf("function not found: %s", functionName)
 		}
 		return nil, fmt.Errorf("failed to get function: %w", err)
 	}
@@ -121,37 +171,31 @@ func (d *LambdaDeployer) GetFunctionInfo(ctx context.Context) (*LambdaDeployResu
 func (d *LambdaDeployer) createFunction(ctx context.Context, functionName string, zipData []byte, roleArn string) (*LambdaDeployResult, error) {
 	log.Printf("Creating new Lambda function...")
 	
+	codeHash, err := packageCodeHash(zipData)
+	if err != nil {
+		return nil, err
+	}
+	
 	modeConfig := config.GetModeConfigs()[d.cfg.Deployment.Mode]
 	
 	input := &lambda.CreateFunctionInput{
-		FunctionName: aws.String(functionName),
-		Runtime:      aws.String(lambda.RuntimeProvidedAl2),
+		FunctionName:  aws.String(functionName),
+		Architectures: []lambdatypes.Architecture{lambdatypes.Architecture(d.architecture())},
+		Runtime:       lambdatypes.RuntimeProvidedal2,
 		Role:         aws.String(roleArn),
 		Handler:      aws.String("bootstrap"),
-		Code: &lambda.FunctionCode{
+		Code: &lambdatypes.FunctionCode{
 			ZipFile: zipData,
 		},
-		Timeout:     aws.Int64(int64(modeConfig.LambdaTimeout)),
-		MemorySize:  aws.Int64(int64(modeConfig.LambdaMemory)),
+		Timeout:     aws.Int32(int32(modeConfig.LambdaTimeout)),
+		MemorySize:  aws.Int32(int32(modeConfig.LambdaMemory)),
 		Description: aws.String(fmt.Sprintf("QUIC NAT Proxy Lambda (%s mode)", d.cfg.Deployment.Mode)),
-		Environment: &lambda.Environment{
-			Variables: map[string]*string{
-				"MODE": aws.String(string(d.cfg.Deployment.Mode)),
-			},
-		},
-		Tags: map[string]*string{
-			"Project":     aws.String("lambda-nat-proxy"),
-			"Component":   aws.String("lambda-function"),
-			"Mode":        aws.String(string(d.cfg.Deployment.Mode)),
-			"ManagedBy":   aws.String("lambda-nat-proxy-cli"),
-			"Environment": aws.String("production"),
-			"CostCenter":  aws.String("lambda-nat-proxy"),
-			"Owner":       aws.String("lambda-nat-proxy-cli"),
-			"Runtime":     aws.String(lambda.RuntimeProvidedAl2),
-		},
+		Environment: d.environment(codeHash),
+		VpcConfig:   d.vpc,
+		Tags:        d.tags(),
 	}
 	
-	result, err := d.clients.Lambda.CreateFunctionWithContext(ctx, input)
+	result, err := d.clients.Lambda.CreateFunction(ctx, input)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create function: %w", err)
 	}
@@ -164,19 +208,72 @@ func (d *LambdaDeployer) createFunction(ctx context.Context, functionName string
 	}
 	
 	log.Printf("Lambda function created successfully")
-	return d.extractFunctionInfo(result), nil
+	return d.GetFunctionInfo(ctx)
+}
+
+// tags returns the function's tags; the minimal template variant only
+// tags it with the project
+func (d *LambdaDeployer) tags() map[string]string {
+	if d.cfg.Deployment.TemplateVariant == config.TemplateMinimal {
+		return map[string]string{"Project": "lambda-nat-proxy"}
+	}
+	return map[string]string{
+		"Project":     "lambda-nat-proxy",
+		"Component":   "lambda-function",
+		"Mode":        string(d.cfg.Deployment.Mode),
+		"ManagedBy":   "lambda-nat-proxy-cli",
+		"Environment": "production",
+		"CostCenter":  "lambda-nat-proxy",
+		"Owner":       "lambda-nat-proxy-cli",
+		"Runtime":     string(lambdatypes.RuntimeProvidedal2),
+		"Architecture": d.architecture(),
+	}
+}
+
+// architecture returns the instruction set to deploy, x86_64 unless configured
+func (d *LambdaDeployer) architecture() string {
+	return LambdaArchitecture(d.cfg)
+}
+
+// LambdaArchitecture returns cfg's Lambda instruction set, x86_64 unless
+// arm64 is configured
+func LambdaArchitecture(cfg *config.CLIConfig) string {
+	if cfg.Deployment.Architecture == shared.ArchitectureARM64 {
+		return shared.ArchitectureARM64
+	}
+	return shared.ArchitectureX86_64
+}
+
+// environment returns the function's environment variables. BLOCKED_TARGETS
+// is only set when configured, so the Lambda falls back to its defaults.
+func (d *LambdaDeployer) environment(codeHash string) *lambdatypes.Environment {
+	variables := map[string]string{
+		"MODE":             string(d.cfg.Deployment.Mode),
+		shared.CodeHashEnv: codeHash,
+	}
+	if len(d.cfg.Deployment.BlockedTargets) > 0 {
+		variables[shared.BlockedTargetsEnv] = strings.Join(d.cfg.Deployment.BlockedTargets, ",")
+	}
+	return &lambdatypes.Environment{Variables: variables}
 }
 
 func (d *LambdaDeployer) updateFunction(ctx context.Context, functionName string, zipData []byte) (*LambdaDeployResult, error) {
 	log.Printf("Updating existing Lambda function...")
 	
-	// Update function code
+	codeHash, err := packageCodeHash(zipData)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Update function code. The architecture can only change along with
+	// the code, since the binary is built for one.
 	codeInput := &lambda.UpdateFunctionCodeInput{
-		FunctionName: aws.String(functionName),
-		ZipFile:      zipData,
+		FunctionName:  aws.String(functionName),
+		Architectures: []lambdatypes.Architecture{lambdatypes.Architecture(d.architecture())},
+		ZipFile:       zipData,
 	}
 	
-	_, err := d.clients.Lambda.UpdateFunctionCodeWithContext(ctx, codeInput)
+	_, err = d.clients.Lambda.UpdateFunctionCode(ctx, codeInput)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update function code: %w", err)
 	}
@@ -191,16 +288,13 @@ func (d *LambdaDeployer) updateFunction(ctx context.Context, functionName string
 	
 	configInput := &lambda.UpdateFunctionConfigurationInput{
 		FunctionName: aws.String(functionName),
-		Timeout:      aws.Int64(int64(modeConfig.LambdaTimeout)),
-		MemorySize:   aws.Int64(int64(modeConfig.LambdaMemory)),
-		Environment: &lambda.Environment{
-			Variables: map[string]*string{
-				"MODE": aws.String(string(d.cfg.Deployment.Mode)),
-			},
-		},
+		Timeout:      aws.Int32(int32(modeConfig.LambdaTimeout)),
+		MemorySize:   aws.Int32(int32(modeConfig.LambdaMemory)),
+		Environment:  d.environment(codeHash),
+		VpcConfig:    d.vpc,
 	}
 	
-	configResult, err := d.clients.Lambda.UpdateFunctionConfigurationWithContext(ctx, configInput)
+	_, err = d.clients.Lambda.UpdateFunctionConfiguration(ctx, configInput)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update function configuration: %w", err)
 	}
@@ -212,8 +306,7 @@ func (d *LambdaDeployer) updateFunction(ctx context.Context, functionName string
 	
 	log.Printf("Lambda function updated successfully")
 	
-	// Return the configuration result since it's more recent
-	return d.extractFunctionInfo(configResult), nil
+	return d.GetFunctionInfo(ctx)
 }
 
 func (d *LambdaDeployer) functionExists(ctx context.Context, functionName string) (bool, error) {
@@ -221,12 +314,10 @@ func (d *LambdaDeployer) functionExists(ctx context.Context, functionName string
 		FunctionName: aws.String(functionName),
 	}
 	
-	_, err := d.clients.Lambda.GetFunctionWithContext(ctx, input)
+	_, err := d.clients.Lambda.GetFunction(ctx, input)
 	if err != nil {
-		if awsErr, ok := err.(awserr.Error); ok {
-			if awsErr.Code() == lambda.ErrCodeResourceNotFoundException {
-				return false, nil
-			}
+		if isNotFound(err) {
+			return false, nil
 		}
 		return false, err
 	}
@@ -242,19 +333,19 @@ func (d *LambdaDeployer) waitForFunctionActive(ctx context.Context, functionName
 			FunctionName: aws.String(functionName),
 		}
 		
-		result, err := d.clients.Lambda.GetFunctionWithContext(ctx, input)
+		result, err := d.clients.Lambda.GetFunction(ctx, input)
 		if err != nil {
 			return false, err
 		}
 		
-		state := *result.Configuration.State
+		state := result.Configuration.State
 		log.Printf("Function state: %s", state)
 		
-		if state == lambda.StateFailed {
+		if state == lambdatypes.StateFailed {
 			return false, fmt.Errorf("function is in failed state")
 		}
 		
-		return state == lambda.StateActive, nil
+		return state == lambdatypes.StateActive, nil
 	}
 	
 	return awsclients.WaitForOperation(ctx, checkFn, 5*time.Minute)
@@ -268,34 +359,38 @@ func (d *LambdaDeployer) waitForFunctionUpdated(ctx context.Context, functionNam
 			FunctionName: aws.String(functionName),
 		}
 		
-		result, err := d.clients.Lambda.GetFunctionWithContext(ctx, input)
+		result, err := d.clients.Lambda.GetFunction(ctx, input)
 		if err != nil {
 			return false, err
 		}
 		
-		lastUpdateStatus := *result.Configuration.LastUpdateStatus
+		lastUpdateStatus := result.Configuration.LastUpdateStatus
 		log.Printf("Function update status: %s", lastUpdateStatus)
 		
-		if lastUpdateStatus == lambda.LastUpdateStatusFailed {
+		if lastUpdateStatus == lambdatypes.LastUpdateStatusFailed {
 			return false, fmt.Errorf("function update failed")
 		}
 		
-		return lastUpdateStatus == lambda.LastUpdateStatusSuccessful, nil
+		return lastUpdateStatus == lambdatypes.LastUpdateStatusSuccessful, nil
 	}
 	
 	return awsclients.WaitForOperation(ctx, checkFn, 5*time.Minute)
 }
 
-func (d *LambdaDeployer) extractFunctionInfo(config *lambda.FunctionConfiguration) *LambdaDeployResult {
+func (d *LambdaDeployer) extractFunctionInfo(config *lambdatypes.FunctionConfiguration) *LambdaDeployResult {
 	result := &LambdaDeployResult{
-		FunctionName: *config.FunctionName,
-		FunctionArn:  *config.FunctionArn,
-		Runtime:      *config.Runtime,
-		MemorySize:   *config.MemorySize,
-		Timeout:      *config.Timeout,
-		LastModified: *config.LastModified,
-		CodeSize:     *config.CodeSize,
-		State:        *config.State,
+		FunctionName: aws.ToString(config.FunctionName),
+		FunctionArn:  aws.ToString(config.FunctionArn),
+		Runtime:      string(config.Runtime),
+		MemorySize:   int64(aws.ToInt32(config.MemorySize)),
+		Timeout:      int64(aws.ToInt32(config.Timeout)),
+		LastModified: aws.ToString(config.LastModified),
+		CodeSize:     config.CodeSize,
+		State:        string(config.State),
+		Architecture: shared.ArchitectureX86_64,
+	}
+	if len(config.Architectures) > 0 {
+		result.Architecture = string(config.Architectures[0])
 	}
 	
 	return result
@@ -11,11 +11,14 @@ import (
 	"time"
 	
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
 )
 
 // LambdaBinaryProvider provides access to Lambda binary data
 type LambdaBinaryProvider interface {
-	GetLambdaBinary() []byte
+	// GetLambdaBinary returns the binary built for a Lambda architecture,
+	// shared.ArchitectureX86_64 or shared.ArchitectureARM64, or nil if none was
+	GetLambdaBinary(architecture string) []byte
 }
 
 // LambdaBuilder handles building Lambda deployment packages
@@ -126,13 +129,13 @@ func (b *LambdaBuilder) BuildLambdaPackageFromSource(buildDir, lambdaDir string)
 	}, nil
 }
 
-// buildLambdaBinary builds the Lambda binary for linux/amd64
+// buildLambdaBinary builds the Lambda binary for linux on the configured architecture
 func (b *LambdaBuilder) buildLambdaBinary(lambdaDir, outputPath string) error {
 	cmd := exec.Command("go", "build", "-o", outputPath, ".")
 	cmd.Dir = lambdaDir
 	cmd.Env = append(os.Environ(),
 		"GOOS=linux",
-		"GOARCH=amd64",
+		"GOARCH="+GOARCH(LambdaArchitecture(b.cfg)),
 		"CGO_ENABLED=0",
 	)
 	
@@ -198,9 +201,10 @@ func (b *LambdaBuilder) addEmbeddedBinaryToZip(zipWriter *zip.Writer, nameInZip
 	}
 	
 	// Get the embedded binary
-	binaryData := b.binaryProvider.GetLambdaBinary()
+	architecture := LambdaArchitecture(b.cfg)
+	binaryData := b.binaryProvider.GetLambdaBinary(architecture)
 	if len(binaryData) == 0 {
-		return fmt.Errorf("embedded Lambda binary is empty - was it built before CLI compilation?")
+		return fmt.Errorf("embedded %s Lambda binary is empty - was it built before CLI compilation?", architecture)
 	}
 	
 	// Create zip file header
@@ -227,6 +231,14 @@ func (b *LambdaBuilder) addEmbeddedBinaryToZip(zipWriter *zip.Writer, nameInZip
 	return nil
 }
 
+// GOARCH returns the Go architecture to build for a Lambda architecture
+func GOARCH(architecture string) string {
+	if architecture == shared.ArchitectureARM64 {
+		return "arm64"
+	}
+	return "amd64"
+}
+
 // addFileToZip adds a file to a zip archive
 func (b *LambdaBuilder) addFileToZip(zipWriter *zip.Writer, filePath, nameInZip string) error {
 	file, err := os.Open(filePath)
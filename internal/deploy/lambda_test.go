@@ -0,0 +1,74 @@
+package deploy
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"testing"
+	
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+func TestLambdaEnvironmentBlockedTargets(t *testing.T) {
+	cfg := config.DefaultCLIConfig()
+	deployer := NewLambdaDeployer(nil, cfg)
+	
+	env := deployer.environment("abc123").Variables
+	if _, ok := env[shared.BlockedTargetsEnv]; ok {
+		t.Errorf("Expected %s to be unset so the Lambda uses its defaults", shared.BlockedTargetsEnv)
+	}
+	if env["MODE"] != string(config.ModeNormal) {
+		t.Errorf("Expected MODE=normal, got %v", env["MODE"])
+	}
+	if got := env[shared.CodeHashEnv]; got != "abc123" {
+		t.Errorf("Expected %s=abc123, got %v", shared.CodeHashEnv, got)
+	}
+	
+	cfg.Deployment.BlockedTargets = []string{"metadata", ":25"}
+	env = deployer.environment("abc123").Variables
+	if got := env[shared.BlockedTargetsEnv]; got != "metadata,:25" {
+		t.Errorf("Expected %s=metadata,:25, got %v", shared.BlockedTargetsEnv, got)
+	}
+}
+
+// archProvider serves a fake binary for each architecture it has
+type archProvider map[string][]byte
+
+func (p archProvider) GetLambdaBinary(architecture string) []byte {
+	return p[architecture]
+}
+
+func TestBuildLambdaPackageArchitecture(t *testing.T) {
+	cfg := config.DefaultCLIConfig()
+	cfg.Deployment.Architecture = shared.ArchitectureARM64
+	provider := archProvider{shared.ArchitectureX86_64: []byte("amd64"), shared.ArchitectureARM64: []byte("arm64")}
+	
+	result, err := NewLambdaBuilderWithProvider(cfg, provider).BuildLambdaPackage(t.TempDir(), "lambda")
+	if err != nil {
+		t.Fatalf("BuildLambdaPackage failed: %v", err)
+	}
+	archive, err := zip.OpenReader(result.ZipPath)
+	if err != nil {
+		t.Fatalf("Failed to open package: %v", err)
+	}
+	defer archive.Close()
+	if len(archive.File) != 1 || archive.File[0].Name != "bootstrap" {
+		t.Fatalf("Expected a single bootstrap entry, got %v", archive.File)
+	}
+	entry, _ := archive.File[0].Open()
+	body, _ := io.ReadAll(entry)
+	entry.Close()
+	if string(body) != "arm64" {
+		t.Errorf("Expected the arm64 binary to be packaged, got %q", body)
+	}
+	zipData, _ := os.ReadFile(result.ZipPath)
+	if hash, err := packageCodeHash(zipData); err != nil || hash != CodeSHA256([]byte("arm64")) {
+		t.Errorf("Expected the package's code hash to be the binary's, got %q (%v)", hash, err)
+	}
+	
+	delete(provider, shared.ArchitectureARM64)
+	if _, err := NewLambdaBuilderWithProvider(cfg, provider).BuildLambdaPackage(t.TempDir(), "lambda"); err == nil {
+		t.Error("Expected an error when the arm64 binary wasn't embedded")
+	}
+}
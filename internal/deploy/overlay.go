@@ -0,0 +1,161 @@
+package deploy
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// templateSections are the top-level sections a CloudFormation template may have
+var templateSections = map[string]bool{
+	"AWSTemplateFormatVersion": true,
+	"Description":              true,
+	"Metadata":                 true,
+	"Parameters":               true,
+	"Rules":                    true,
+	"Mappings":                 true,
+	"Conditions":               true,
+	"Transform":                true,
+	"Resources":                true,
+	"Outputs":                  true,
+}
+
+// requiredResources and requiredOutputs are what deploy and run read from
+// the stack, so an overlay may change them but not remove them
+var (
+	requiredResources = []string{"CoordinationBucket", "LambdaExecutionRole"}
+	requiredOutputs   = []string{"CoordinationBucketName", "LambdaExecutionRoleArn"}
+)
+
+// ApplyTemplateOverlay merges the YAML file at overlayPath into
+// templateContent. Mappings are merged key by key, lists are appended to
+// (so policy statements and lifecycle rules are added, not replaced) and
+// any other value replaces the template's. The overlay may use the same
+// {{.StackName}} parameters as the template.
+func ApplyTemplateOverlay(templateContent, overlayPath string, params TemplateParams) (string, error) {
+	content, err := os.ReadFile(overlayPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read template overlay %s: %w", overlayPath, err)
+	}
+	overlayContent, err := substituteTemplateParams(string(content), params)
+	if err != nil {
+		return "", fmt.Errorf("failed to substitute template overlay parameters: %w", err)
+	}
+
+	var base, overlay yaml.Node
+	if err := yaml.Unmarshal([]byte(templateContent), &base); err != nil {
+		return "", fmt.Errorf("failed to parse template: %w", err)
+	}
+	if err := yaml.Unmarshal([]byte(overlayContent), &overlay); err != nil {
+		return "", fmt.Errorf("failed to parse template overlay %s: %w", overlayPath, err)
+	}
+	if len(overlay.Content) == 0 {
+		return templateContent, nil
+	}
+
+	overlayRoot := overlay.Content[0]
+	if overlayRoot.Kind != yaml.MappingNode {
+		return "", fmt.Errorf("template overlay %s must be a mapping of template sections", overlayPath)
+	}
+	for i := 0; i < len(overlayRoot.Content); i += 2 {
+		key := overlayRoot.Content[i]
+		if !templateSections[key.Value] {
+			return "", fmt.Errorf("template overlay %s line %d: unknown template section %q", overlayPath, key.Line, key.Value)
+		}
+	}
+	mergeNodes(base.Content[0], overlayRoot)
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&base); err != nil {
+		return "", fmt.Errorf("failed to encode merged template: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return "", fmt.Errorf("failed to encode merged template: %w", err)
+	}
+	return out.String(), nil
+}
+
+// mergeNodes merges overlay into base in place
+func mergeNodes(base, overlay *yaml.Node) {
+	switch {
+	case base.Kind == yaml.MappingNode && overlay.Kind == yaml.MappingNode:
+		for i := 0; i < len(overlay.Content); i += 2 {
+			key, value := overlay.Content[i], overlay.Content[i+1]
+			if existing := mappingValue(base, key.Value); existing != nil {
+				mergeNodes(existing, value)
+			} else {
+				base.Content = append(base.Content, key, value)
+			}
+		}
+	case base.Kind == yaml.SequenceNode && overlay.Kind == yaml.SequenceNode:
+		base.Content = append(base.Content, overlay.Content...)
+	default:
+		*base = *overlay
+	}
+}
+
+// mappingValue returns the value of key in the mapping node, or nil
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// ValidateMergedTemplate checks that a template still has what deploy
+// needs: every resource has a Type, and the resources and outputs read
+// from the stack are present
+func ValidateMergedTemplate(templateContent string) error {
+	if err := ValidateTemplate(templateContent); err != nil {
+		return err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(templateContent), &doc); err != nil {
+		return fmt.Errorf("template is not valid YAML: %w", err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return fmt.Errorf("template must be a mapping of template sections")
+	}
+	root := doc.Content[0]
+
+	resources := mappingValue(root, "Resources")
+	if resources == nil || resources.Kind != yaml.MappingNode {
+		return fmt.Errorf("template Resources must be a mapping")
+	}
+	for i := 0; i < len(resources.Content); i += 2 {
+		name, resource := resources.Content[i], resources.Content[i+1]
+		if resource.Kind != yaml.MappingNode {
+			return fmt.Errorf("resource %s (line %d) must be a mapping", name.Value, name.Line)
+		}
+		if resourceType := mappingValue(resource, "Type"); resourceType == nil || resourceType.Value == "" {
+			return fmt.Errorf("resource %s (line %d) has no Type", name.Value, name.Line)
+		}
+	}
+	for _, name := range requiredResources {
+		if mappingValue(resources, name) == nil {
+			return fmt.Errorf("template is missing the %s resource", name)
+		}
+	}
+
+	outputs := mappingValue(root, "Outputs")
+	for _, name := range requiredOutputs {
+		var output *yaml.Node
+		if outputs != nil {
+			output = mappingValue(outputs, name)
+		}
+		if output == nil {
+			return fmt.Errorf("template is missing the %s output", name)
+		}
+		if output.Kind != yaml.MappingNode || mappingValue(output, "Value") == nil {
+			return fmt.Errorf("output %s must be a mapping with a Value", name)
+		}
+	}
+	return nil
+}
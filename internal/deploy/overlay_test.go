@@ -0,0 +1,103 @@
+package deploy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+func writeOverlay(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "overlay.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write overlay: %v", err)
+	}
+	return path
+}
+
+func TestTemplateOverlay(t *testing.T) {
+	cfg := &config.CLIConfig{
+		Deployment: config.DeploymentConfig{
+			StackName: "test-stack",
+			TemplateOverlay: writeOverlay(t, `Resources:
+  LambdaExecutionRole:
+    Properties:
+      Policies:
+        - PolicyName: ExtraAccess
+          PolicyDocument:
+            Statement:
+              - Effect: Allow
+                Action: ssm:GetParameter
+                Resource: !Sub 'arn:aws:ssm:${AWS::Region}:${AWS::AccountId}:parameter/{{.StackName}}/*'
+  AlarmTopic:
+    Type: AWS::SNS::Topic
+Outputs:
+  AlarmTopicArn:
+    Value: !Ref AlarmTopic
+`),
+		},
+	}
+
+	template, err := GetCloudFormationTemplate(cfg, "")
+	if err != nil {
+		t.Fatalf("Expected the overlay to apply, got %v", err)
+	}
+
+	var merged struct {
+		Resources map[string]struct {
+			Type       string `yaml:"Type"`
+			Properties struct {
+				Policies []struct {
+					PolicyName string `yaml:"PolicyName"`
+				} `yaml:"Policies"`
+			} `yaml:"Properties"`
+		} `yaml:"Resources"`
+		Outputs map[string]interface{} `yaml:"Outputs"`
+	}
+	if err := yaml.Unmarshal([]byte(template), &merged); err != nil {
+		t.Fatalf("Merged template is not YAML: %v", err)
+	}
+
+	role := merged.Resources["LambdaExecutionRole"]
+	if role.Type != "AWS::IAM::Role" || len(role.Properties.Policies) != 2 || role.Properties.Policies[1].PolicyName != "ExtraAccess" {
+		t.Errorf("Expected the overlay policy to be appended to the role, got %+v", role)
+	}
+	if merged.Resources["AlarmTopic"].Type != "AWS::SNS::Topic" || merged.Outputs["AlarmTopicArn"] == nil {
+		t.Error("Expected the overlay's resource and output to be added")
+	}
+	if merged.Resources["CoordinationBucket"].Type != "AWS::S3::Bucket" {
+		t.Error("Expected the generated resources to be kept")
+	}
+	if !strings.Contains(template, "parameter/test-stack/*") || !strings.Contains(template, "!Sub") {
+		t.Error("Expected the overlay's parameters substituted and its tags kept")
+	}
+}
+
+func TestTemplateOverlayValidation(t *testing.T) {
+	for overlay, want := range map[string]string{
+		"Resourcez:\n  Extra:\n    Type: AWS::SNS::Topic\n": "unknown template section",
+		"Resources:\n  Extra:\n    Properties: {}\n":        "has no Type",
+		"Outputs:\n  CoordinationBucketName: null\n":        "must be a mapping",
+		"- Resources\n":  "must be a mapping",
+		"Resources: [\n": "failed to parse",
+	} {
+		cfg := &config.CLIConfig{
+			Deployment: config.DeploymentConfig{
+				StackName:       "test-stack",
+				TemplateOverlay: writeOverlay(t, overlay),
+			},
+		}
+		_, err := GetCloudFormationTemplate(cfg, "")
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Overlay %q: expected an error mentioning %q, got %v", overlay, want, err)
+		}
+	}
+
+	if err := ValidateMergedTemplate("AWSTemplateFormatVersion: '2010-09-09'\nResources:\n  CoordinationBucket:\n    Type: AWS::S3::Bucket\n"); err == nil || !strings.Contains(err.Error(), "LambdaExecutionRole") {
+		t.Errorf("Expected an error for a missing required resource, got %v", err)
+	}
+}
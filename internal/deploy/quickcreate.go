@@ -0,0 +1,46 @@
+package deploy
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// consoleHost returns the AWS console's host for region's partition
+func consoleHost(region string) string {
+	switch shared.PartitionForRegion(region) {
+	case shared.PartitionAWSUSGov:
+		return "console.amazonaws-us-gov.com"
+	case shared.PartitionAWSCN:
+		return "console.amazonaws.cn"
+	}
+	return region + ".console.aws.amazon.com"
+}
+
+// QuickCreateURL returns a console link that opens the stack creation form
+// for the template at templateURL (an S3 HTTPS URL), with the stack name and
+// parameters filled in. Without templateURL the link opens the form to
+// upload a template file instead, as the quick-create form needs one in S3.
+func QuickCreateURL(region, stackName, templateURL string, parameters map[string]string) string {
+	base := fmt.Sprintf("https://%s/cloudformation/home?region=%s#/stacks/", consoleHost(region), url.QueryEscape(region))
+	if templateURL == "" {
+		return base + "create/template"
+	}
+
+	query := []string{
+		"templateURL=" + url.QueryEscape(templateURL),
+		"stackName=" + url.QueryEscape(stackName),
+	}
+	names := make([]string, 0, len(parameters))
+	for name := range parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		query = append(query, "param_"+name+"="+url.QueryEscape(parameters[name]))
+	}
+	return base + "quickcreate?" + strings.Join(query, "&")
+}
@@ -0,0 +1,63 @@
+package deploy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+)
+
+func TestQuickCreateURL(t *testing.T) {
+	got := QuickCreateURL("eu-west-1", "proxy", "https://bucket.s3.amazonaws.com/proxy.yaml", map[string]string{
+		"StackName": "proxy",
+		"CodeKey":   "proxy.zip",
+	})
+	want := "https://eu-west-1.console.aws.amazon.com/cloudformation/home?region=eu-west-1#/stacks/quickcreate?" +
+		"templateURL=https%3A%2F%2Fbucket.s3.amazonaws.com%2Fproxy.yaml&stackName=proxy&param_CodeKey=proxy.zip&param_StackName=proxy"
+	if got != want {
+		t.Errorf("QuickCreateURL = %s, expected %s", got, want)
+	}
+
+	if got := QuickCreateURL("cn-north-1", "proxy", "", nil); got != "https://console.amazonaws.cn/cloudformation/home?region=cn-north-1#/stacks/create/template" {
+		t.Errorf("Expected the upload form in the China console, got %s", got)
+	}
+}
+
+func TestGetDelegatedTemplate(t *testing.T) {
+	cfg := config.DefaultCLIConfig()
+	cfg.Deployment.StackName = "proxy"
+	cfg.Deployment.Architecture = "arm64"
+
+	template, err := GetDelegatedTemplate(cfg, "abc123")
+	if err != nil {
+		t.Fatalf("GetDelegatedTemplate failed: %v", err)
+	}
+	for _, want := range []string{
+		"Description: '" + DelegatedTemplateDescription + "'",
+		"AllowedValues: ['test', 'normal', 'performance']",
+		"Type: AWS::Lambda::Function",
+		"- arm64",
+		"LAMBDA_CODE_SHA256: 'abc123'",
+		"DependsOn: S3InvokePermission",
+		"Function: !GetAtt LambdaFunction.Arn",
+	} {
+		if !strings.Contains(template, want) {
+			t.Errorf("Expected the delegated template to contain %q", want)
+		}
+	}
+	// The role can't reference the bucket, which depends on it through the function
+	if strings.Contains(template, "${CoordinationBucket.Arn}") {
+		t.Error("Expected the role to name the bucket's ARN rather than reference it")
+	}
+	if err := ValidateMergedTemplate(template); err != nil {
+		t.Errorf("Expected a valid template, got %v", err)
+	}
+
+	standard, err := GetCloudFormationTemplate(cfg, "")
+	if err != nil {
+		t.Fatalf("GetCloudFormationTemplate failed: %v", err)
+	}
+	if strings.Contains(standard, "AWS::Lambda::Function") || !strings.Contains(standard, "${CoordinationBucket.Arn}") {
+		t.Error("Expected the standard template to leave the function to the SDK")
+	}
+}
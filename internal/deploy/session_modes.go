@@ -0,0 +1,147 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+)
+
+// PublishSessionModes publishes a version of the function for each of
+// deployment.session_modes other than the deployed mode, with that mode's
+// memory and timeout, behind an alias named after the mode. 'run --mode'
+// invokes the alias for its sessions. Aliases of modes no longer listed are
+// deleted, and the function keeps the deployed mode's configuration.
+func (d *LambdaDeployer) PublishSessionModes(ctx context.Context) (err error) {
+	functionName := d.getFunctionName()
+	deployed := d.cfg.Deployment.Mode
+
+	published := make(map[config.PerformanceMode]bool)
+	defer func() {
+		if len(published) == 0 {
+			return
+		}
+		if restoreErr := d.configureMode(ctx, functionName, deployed); restoreErr != nil && err == nil {
+			err = fmt.Errorf("failed to restore the %s mode configuration: %w", deployed, restoreErr)
+		}
+	}()
+	for _, mode := range d.cfg.Deployment.SessionModes {
+		if mode == deployed || published[mode] {
+			continue
+		}
+		published[mode] = true
+		if err := d.publishMode(ctx, functionName, mode); err != nil {
+			return err
+		}
+	}
+
+	for mode := range config.GetModeConfigs() {
+		if published[mode] {
+			continue
+		}
+		if err := d.deleteModeAlias(ctx, functionName, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configureMode gives the function mode's memory and timeout
+func (d *LambdaDeployer) configureMode(ctx context.Context, functionName string, mode config.PerformanceMode) error {
+	modeConfig := config.GetModeConfigs()[mode]
+	_, err := d.clients.Lambda.UpdateFunctionConfiguration(ctx, &lambda.UpdateFunctionConfigurationInput{
+		FunctionName: aws.String(functionName),
+		Timeout:      aws.Int32(int32(modeConfig.LambdaTimeout)),
+		MemorySize:   aws.Int32(int32(modeConfig.LambdaMemory)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update function configuration: %w", err)
+	}
+	return d.waitForFunctionUpdated(ctx, functionName)
+}
+
+// publishMode publishes the function with mode's configuration and points
+// mode's alias at the new version, deleting the version it replaces
+func (d *LambdaDeployer) publishMode(ctx context.Context, functionName string, mode config.PerformanceMode) error {
+	if err := d.configureMode(ctx, functionName, mode); err != nil {
+		return fmt.Errorf("failed to configure %s mode: %w", mode, err)
+	}
+	version, err := d.clients.Lambda.PublishVersion(ctx, &lambda.PublishVersionInput{
+		FunctionName: aws.String(functionName),
+		Description:  aws.String(fmt.Sprintf("%s mode", mode)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish %s mode: %w", mode, err)
+	}
+
+	alias := string(mode)
+	current, err := d.clients.Lambda.GetAlias(ctx, &lambda.GetAliasInput{
+		FunctionName: aws.String(functionName),
+		Name:         aws.String(alias),
+	})
+	switch {
+	case err == nil:
+		_, err = d.clients.Lambda.UpdateAlias(ctx, &lambda.UpdateAliasInput{
+			FunctionName:    aws.String(functionName),
+			Name:            aws.String(alias),
+			FunctionVersion: version.Version,
+		})
+	case isNotFound(err):
+		_, err = d.clients.Lambda.CreateAlias(ctx, &lambda.CreateAliasInput{
+			FunctionName:    aws.String(functionName),
+			Name:            aws.String(alias),
+			FunctionVersion: version.Version,
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to point the %s alias at version %s: %w", alias, aws.ToString(version.Version), err)
+	}
+	log.Printf("Published %s mode (%dMB) as %s:%s", mode, aws.ToInt32(version.MemorySize), functionName, alias)
+
+	if current != nil && aws.ToString(current.FunctionVersion) != aws.ToString(version.Version) {
+		d.deleteVersion(ctx, functionName, aws.ToString(current.FunctionVersion))
+	}
+	return nil
+}
+
+// deleteModeAlias deletes mode's alias and its version, if there is one
+func (d *LambdaDeployer) deleteModeAlias(ctx context.Context, functionName string, mode config.PerformanceMode) error {
+	alias := string(mode)
+	current, err := d.clients.Lambda.GetAlias(ctx, &lambda.GetAliasInput{
+		FunctionName: aws.String(functionName),
+		Name:         aws.String(alias),
+	})
+	if isNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the %s alias: %w", alias, err)
+	}
+	if _, err := d.clients.Lambda.DeleteAlias(ctx, &lambda.DeleteAliasInput{
+		FunctionName: aws.String(functionName),
+		Name:         aws.String(alias),
+	}); err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to delete the %s alias: %w", alias, err)
+	}
+	d.deleteVersion(ctx, functionName, aws.ToString(current.FunctionVersion))
+	log.Printf("Removed the %s mode alias", mode)
+	return nil
+}
+
+// deleteVersion deletes a published version that no alias uses any more.
+// Failures are only logged; a leftover version costs nothing but storage.
+func (d *LambdaDeployer) deleteVersion(ctx context.Context, functionName, version string) {
+	if version == "" || version == "$LATEST" {
+		return
+	}
+	if _, err := d.clients.Lambda.DeleteFunction(ctx, &lambda.DeleteFunctionInput{
+		FunctionName: aws.String(functionName),
+		Qualifier:    aws.String(version),
+	}); err != nil && !isNotFound(err) {
+		log.Printf("⚠️  Failed to delete version %s of %s: %v", version, functionName, err)
+	}
+}
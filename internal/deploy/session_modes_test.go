@@ -0,0 +1,105 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdatypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/aws/smithy-go"
+
+	awsclients "github.com/dan-v/lambda-nat-punch-proxy/internal/aws"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+)
+
+// versionedLambda publishes versions of one function and keeps its aliases
+type versionedLambda struct {
+	awsclients.LambdaAPI
+	memory   int32
+	versions map[string]int32  // version -> memory
+	aliases  map[string]string // alias -> version
+	next     int
+}
+
+func (l *versionedLambda) UpdateFunctionConfiguration(ctx context.Context, input *lambda.UpdateFunctionConfigurationInput, optFns ...func(*lambda.Options)) (*lambda.UpdateFunctionConfigurationOutput, error) {
+	l.memory = aws.ToInt32(input.MemorySize)
+	return &lambda.UpdateFunctionConfigurationOutput{MemorySize: input.MemorySize}, nil
+}
+
+func (l *versionedLambda) GetFunction(ctx context.Context, input *lambda.GetFunctionInput, optFns ...func(*lambda.Options)) (*lambda.GetFunctionOutput, error) {
+	return &lambda.GetFunctionOutput{Configuration: &lambdatypes.FunctionConfiguration{
+		MemorySize:       aws.Int32(l.memory),
+		LastUpdateStatus: lambdatypes.LastUpdateStatusSuccessful,
+	}}, nil
+}
+
+func (l *versionedLambda) PublishVersion(ctx context.Context, input *lambda.PublishVersionInput, optFns ...func(*lambda.Options)) (*lambda.PublishVersionOutput, error) {
+	l.next++
+	version := fmt.Sprint(l.next)
+	l.versions[version] = l.memory
+	return &lambda.PublishVersionOutput{Version: aws.String(version), MemorySize: aws.Int32(l.memory)}, nil
+}
+
+func (l *versionedLambda) GetAlias(ctx context.Context, input *lambda.GetAliasInput, optFns ...func(*lambda.Options)) (*lambda.GetAliasOutput, error) {
+	version, ok := l.aliases[aws.ToString(input.Name)]
+	if !ok {
+		return nil, &smithy.GenericAPIError{Code: "ResourceNotFoundException", Message: "no such alias"}
+	}
+	return &lambda.GetAliasOutput{Name: input.Name, FunctionVersion: aws.String(version)}, nil
+}
+
+func (l *versionedLambda) CreateAlias(ctx context.Context, input *lambda.CreateAliasInput, optFns ...func(*lambda.Options)) (*lambda.CreateAliasOutput, error) {
+	l.aliases[aws.ToString(input.Name)] = aws.ToString(input.FunctionVersion)
+	return &lambda.CreateAliasOutput{}, nil
+}
+
+func (l *versionedLambda) UpdateAlias(ctx context.Context, input *lambda.UpdateAliasInput, optFns ...func(*lambda.Options)) (*lambda.UpdateAliasOutput, error) {
+	l.aliases[aws.ToString(input.Name)] = aws.ToString(input.FunctionVersion)
+	return &lambda.UpdateAliasOutput{}, nil
+}
+
+func (l *versionedLambda) DeleteAlias(ctx context.Context, input *lambda.DeleteAliasInput, optFns ...func(*lambda.Options)) (*lambda.DeleteAliasOutput, error) {
+	delete(l.aliases, aws.ToString(input.Name))
+	return &lambda.DeleteAliasOutput{}, nil
+}
+
+func (l *versionedLambda) DeleteFunction(ctx context.Context, input *lambda.DeleteFunctionInput, optFns ...func(*lambda.Options)) (*lambda.DeleteFunctionOutput, error) {
+	delete(l.versions, aws.ToString(input.Qualifier))
+	return &lambda.DeleteFunctionOutput{}, nil
+}
+
+func TestPublishSessionModes(t *testing.T) {
+	cfg := config.DefaultCLIConfig()
+	cfg.Deployment.StackName = "proxy"
+	cfg.Deployment.Mode = config.ModeNormal
+	cfg.Deployment.SessionModes = []config.PerformanceMode{config.ModePerformance, config.ModeNormal}
+	fn := &versionedLambda{memory: 256, versions: map[string]int32{}, aliases: map[string]string{"test": "0"}}
+	deployer := NewLambdaDeployer(&awsclients.Clients{Lambda: fn}, cfg)
+
+	if err := deployer.PublishSessionModes(context.Background()); err != nil {
+		t.Fatalf("PublishSessionModes failed: %v", err)
+	}
+	version, ok := fn.aliases["performance"]
+	if !ok || fn.versions[version] != 512 {
+		t.Errorf("Expected a performance alias on a 512MB version, got %v %v", fn.aliases, fn.versions)
+	}
+	if _, ok := fn.aliases["normal"]; ok {
+		t.Error("Expected no alias for the deployed mode")
+	}
+	if _, ok := fn.aliases["test"]; ok {
+		t.Error("Expected the alias of an unlisted mode to be removed")
+	}
+	if fn.memory != 256 {
+		t.Errorf("Expected the function to keep the deployed mode's 256MB, got %d", fn.memory)
+	}
+
+	// Redeploying moves the alias and deletes the version it replaced
+	if err := deployer.PublishSessionModes(context.Background()); err != nil {
+		t.Fatalf("PublishSessionModes failed: %v", err)
+	}
+	if _, ok := fn.versions[version]; ok || fn.aliases["performance"] == version {
+		t.Errorf("Expected the old version to be replaced, got %v %v", fn.aliases, fn.versions)
+	}
+}
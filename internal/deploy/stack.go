@@ -2,17 +2,20 @@ package deploy
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	cftypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/aws/smithy-go"
 	
 	awsclients "github.com/dan-v/lambda-nat-punch-proxy/internal/aws"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
 )
 
 // StackDeployerAPI defines the interface for stack deployment operations
@@ -42,6 +45,11 @@ func (s *StackDeployer) DeployStack(ctx context.Context, templateBody string) (*
 	
 	log.Printf("Deploying CloudFormation stack: %s", stackName)
 	
+	// Catch template errors, such as a bad overlay, before touching the stack
+	if err := s.validateTemplate(ctx, templateBody); err != nil {
+		return nil, err
+	}
+	
 	exists, err := s.stackExists(ctx, stackName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to check if stack exists: %w", err)
@@ -56,6 +64,17 @@ func (s *StackDeployer) DeployStack(ctx context.Context, templateBody string) (*
 	return s.createStack(ctx, stackName, templateBody, parameters)
 }
 
+// validateTemplate has CloudFormation check the template's syntax and references
+func (s *StackDeployer) validateTemplate(ctx context.Context, templateBody string) error {
+	_, err := s.clients.CloudFormation.ValidateTemplate(ctx, &cloudformation.ValidateTemplateInput{
+		TemplateBody: aws.String(templateBody),
+	})
+	if err != nil {
+		return fmt.Errorf("CloudFormation rejected the template: %w", err)
+	}
+	return nil
+}
+
 // DeleteStack deletes a CloudFormation stack
 func (s *StackDeployer) DeleteStack(ctx context.Context) error {
 	stackName := s.getFullStackName()
@@ -66,14 +85,14 @@ func (s *StackDeployer) DeleteStack(ctx context.Context) error {
 		StackName: aws.String(stackName),
 	}
 	
-	_, err := s.clients.CloudFormation.DeleteStackWithContext(ctx, input)
+	_, err := s.clients.CloudFormation.DeleteStack(ctx, input)
 	if err != nil {
 		return fmt.Errorf("failed to delete stack: %w", err)
 	}
 	
 	// Wait for deletion to complete
 	log.Printf("Waiting for stack deletion to complete...")
-	err = s.waitForStackOperation(ctx, stackName, cloudformation.StackStatusDeleteComplete, 20*time.Minute)
+	err = s.waitForStackOperation(ctx, stackName, cftypes.StackStatusDeleteComplete, 20*time.Minute)
 	if err != nil {
 		return fmt.Errorf("stack deletion failed: %w", err)
 	}
@@ -90,7 +109,7 @@ func (s *StackDeployer) GetStackOutputs(ctx context.Context) (*StackOutput, erro
 		StackName: aws.String(stackName),
 	}
 	
-	result, err := s.clients.CloudFormation.DescribeStacksWithContext(ctx, input)
+	result, err := s.clients.CloudFormation.DescribeStacks(ctx, input)
 	if err != nil {
 		return nil, fmt.Errorf("failed to describe stack: %w", err)
 	}
@@ -99,31 +118,40 @@ func (s *StackDeployer) GetStackOutputs(ctx context.Context) (*StackOutput, erro
 		return nil, fmt.Errorf("stack not found: %s", stackName)
 	}
 	
-	stack := result.Stacks[0]
-	return s.extractStackOutputs(stack), nil
+	return s.extractStackOutputs(&result.Stacks[0]), nil
 }
 
 // StackOutput holds important outputs from the CloudFormation stack
 type StackOutput struct {
-	StackName                string
-	CoordinationBucketName   string
-	LambdaExecutionRoleArn   string
-	StackStatus              string
-	CreationTime             *time.Time
-	LastUpdatedTime          *time.Time
+	StackName                 string
+	CoordinationBucketName    string
+	LambdaExecutionRoleArn    string
+	SessionCredentialsRoleArn string
+	
+	// LambdaSubnetIDs and LambdaSecurityGroupID place the function in the
+	// stack's VPC, for the vpc template variant
+	LambdaSubnetIDs       []string
+	LambdaSecurityGroupID string
+	
+	// EncryptionKeyArn is the KMS key of a hardened stack's bucket
+	EncryptionKeyArn string
+	
+	StackStatus               string
+	CreationTime              *time.Time
+	LastUpdatedTime           *time.Time
 }
 
-func (s *StackDeployer) createStack(ctx context.Context, stackName, templateBody string, parameters []*cloudformation.Parameter) (*StackOutput, error) {
+func (s *StackDeployer) createStack(ctx context.Context, stackName, templateBody string, parameters []cftypes.Parameter) (*StackOutput, error) {
 	log.Printf("Creating new stack...")
 	
 	input := &cloudformation.CreateStackInput{
 		StackName:    aws.String(stackName),
 		TemplateBody: aws.String(templateBody),
 		Parameters:   parameters,
-		Capabilities: []*string{
-			aws.String(cloudformation.CapabilityCapabilityNamedIam),
+		Capabilities: []cftypes.Capability{
+			cftypes.CapabilityCapabilityNamedIam,
 		},
-		Tags: []*cloudformation.Tag{
+		Tags: []cftypes.Tag{
 			{
 				Key:   aws.String("Project"),
 				Value: aws.String("lambda-nat-proxy"),
@@ -155,7 +183,7 @@ func (s *StackDeployer) createStack(ctx context.Context, stackName, templateBody
 		},
 	}
 	
-	result, err := s.clients.CloudFormation.CreateStackWithContext(ctx, input)
+	result, err := s.clients.CloudFormation.CreateStack(ctx, input)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create stack: %w", err)
 	}
@@ -164,7 +192,7 @@ func (s *StackDeployer) createStack(ctx context.Context, stackName, templateBody
 	
 	// Wait for creation to complete
 	log.Printf("Waiting for stack creation to complete...")
-	err = s.waitForStackOperation(ctx, stackName, cloudformation.StackStatusCreateComplete, 10*time.Minute)
+	err = s.waitForStackOperation(ctx, stackName, cftypes.StackStatusCreateComplete, 10*time.Minute)
 	if err != nil {
 		return nil, fmt.Errorf("stack creation failed: %w", err)
 	}
@@ -173,22 +201,23 @@ func (s *StackDeployer) createStack(ctx context.Context, stackName, templateBody
 	return s.GetStackOutputs(ctx)
 }
 
-func (s *StackDeployer) updateStack(ctx context.Context, stackName, templateBody string, parameters []*cloudformation.Parameter) (*StackOutput, error) {
+func (s *StackDeployer) updateStack(ctx context.Context, stackName, templateBody string, parameters []cftypes.Parameter) (*StackOutput, error) {
 	log.Printf("Updating existing stack...")
 	
 	input := &cloudformation.UpdateStackInput{
 		StackName:    aws.String(stackName),
 		TemplateBody: aws.String(templateBody),
 		Parameters:   parameters,
-		Capabilities: []*string{
-			aws.String(cloudformation.CapabilityCapabilityNamedIam),
+		Capabilities: []cftypes.Capability{
+			cftypes.CapabilityCapabilityNamedIam,
 		},
 	}
 	
-	_, err := s.clients.CloudFormation.UpdateStackWithContext(ctx, input)
+	_, err := s.clients.CloudFormation.UpdateStack(ctx, input)
 	if err != nil {
-		if awsErr, ok := err.(awserr.Error); ok {
-			if awsErr.Code() == "ValidationError" && strings.Contains(awsErr.Message(), "No updates are to be performed") {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			if apiErr.ErrorCode() == "ValidationError" && strings.Contains(apiErr.ErrorMessage(), "No updates are to be performed") {
 				log.Printf("No updates needed for stack")
 				return s.GetStackOutputs(ctx)
 			}
@@ -198,7 +227,7 @@ func (s *StackDeployer) updateStack(ctx context.Context, stackName, templateBody
 	
 	// Wait for update to complete
 	log.Printf("Waiting for stack update to complete...")
-	err = s.waitForStackOperation(ctx, stackName, cloudformation.StackStatusUpdateComplete, 10*time.Minute)
+	err = s.waitForStackOperation(ctx, stackName, cftypes.StackStatusUpdateComplete, 10*time.Minute)
 	if err != nil {
 		return nil, fmt.Errorf("stack update failed: %w", err)
 	}
@@ -212,12 +241,10 @@ func (s *StackDeployer) stackExists(ctx context.Context, stackName string) (bool
 		StackName: aws.String(stackName),
 	}
 	
-	_, err := s.clients.CloudFormation.DescribeStacksWithContext(ctx, input)
+	_, err := s.clients.CloudFormation.DescribeStacks(ctx, input)
 	if err != nil {
-		if awsErr, ok := err.(awserr.Error); ok {
-			if awsErr.Code() == "ValidationError" {
-				return false, nil
-			}
+		if shared.AWSErrorCode(err) == "ValidationError" {
+			return false, nil
 		}
 		return false, err
 	}
@@ -225,18 +252,18 @@ func (s *StackDeployer) stackExists(ctx context.Context, stackName string) (bool
 	return true, nil
 }
 
-func (s *StackDeployer) waitForStackOperation(ctx context.Context, stackName, targetStatus string, timeout time.Duration) error {
+func (s *StackDeployer) waitForStackOperation(ctx context.Context, stackName string, targetStatus cftypes.StackStatus, timeout time.Duration) error {
 	checkFn := func() (bool, error) {
 		input := &cloudformation.DescribeStacksInput{
 			StackName: aws.String(stackName),
 		}
 		
-		result, err := s.clients.CloudFormation.DescribeStacksWithContext(ctx, input)
+		result, err := s.clients.CloudFormation.DescribeStacks(ctx, input)
 		if err != nil {
 			// If stack is being deleted and we're waiting for DELETE_COMPLETE, 
 			// a ValidationError means deletion succeeded
-			if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "ValidationError" {
-				if targetStatus == cloudformation.StackStatusDeleteComplete {
+			if shared.AWSErrorCode(err) == "ValidationError" {
+				if targetStatus == cftypes.StackStatusDeleteComplete {
 					return true, nil
 				}
 			}
@@ -248,7 +275,7 @@ func (s *StackDeployer) waitForStackOperation(ctx context.Context, stackName, ta
 		}
 		
 		stack := result.Stacks[0]
-		currentStatus := *stack.StackStatus
+		currentStatus := string(stack.StackStatus)
 		
 		log.Printf("Stack status: %s", currentStatus)
 		
@@ -258,14 +285,14 @@ func (s *StackDeployer) waitForStackOperation(ctx context.Context, stackName, ta
 			return false, fmt.Errorf("stack operation failed with status: %s", currentStatus)
 		}
 		
-		return currentStatus == targetStatus, nil
+		return currentStatus == string(targetStatus), nil
 	}
 	
 	return awsclients.WaitForOperation(ctx, checkFn, timeout)
 }
 
-func (s *StackDeployer) buildStackParameters() []*cloudformation.Parameter {
-	return []*cloudformation.Parameter{
+func (s *StackDeployer) buildStackParameters() []cftypes.Parameter {
+	return []cftypes.Parameter{
 		{
 			ParameterKey:   aws.String("StackName"),
 			ParameterValue: aws.String(s.cfg.Deployment.StackName),
@@ -273,10 +300,10 @@ func (s *StackDeployer) buildStackParameters() []*cloudformation.Parameter {
 	}
 }
 
-func (s *StackDeployer) extractStackOutputs(stack *cloudformation.Stack) *StackOutput {
+func (s *StackDeployer) extractStackOutputs(stack *cftypes.Stack) *StackOutput {
 	output := &StackOutput{
 		StackName:       *stack.StackName,
-		StackStatus:     *stack.StackStatus,
+		StackStatus:     string(stack.StackStatus),
 		CreationTime:    stack.CreationTime,
 		LastUpdatedTime: stack.LastUpdatedTime,
 	}
@@ -291,6 +318,14 @@ func (s *StackDeployer) extractStackOutputs(stack *cloudformation.Stack) *StackO
 			output.CoordinationBucketName = *stackOutput.OutputValue
 		case "LambdaExecutionRoleArn":
 			output.LambdaExecutionRoleArn = *stackOutput.OutputValue
+		case "SessionCredentialsRoleArn":
+			output.SessionCredentialsRoleArn = *stackOutput.OutputValue
+		case "LambdaSubnetIds":
+			output.LambdaSubnetIDs = strings.Split(*stackOutput.OutputValue, ",")
+		case "LambdaSecurityGroupId":
+			output.LambdaSecurityGroupID = *stackOutput.OutputValue
+		case "EncryptionKeyArn":
+			output.EncryptionKeyArn = *stackOutput.OutputValue
 		}
 	}
 	
@@ -0,0 +1,122 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	cftypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+
+	awsclients "github.com/dan-v/lambda-nat-punch-proxy/internal/aws"
+)
+
+// ProjectTag is the tag value every stack created by deploy carries under the "Project" key
+const ProjectTag = "lambda-nat-proxy"
+
+// DeployedStack describes a lambda-nat-proxy stack found in an account
+type DeployedStack struct {
+	Name      string     `json:"name" yaml:"name"`
+	Region    string     `json:"region" yaml:"region"`
+	Mode      string     `json:"mode" yaml:"mode"`
+	Status    string     `json:"status" yaml:"status"`
+	CreatedAt *time.Time `json:"created_at,omitempty" yaml:"created_at,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty" yaml:"updated_at,omitempty"`
+}
+
+// Age returns how long ago the stack was created, relative to now
+func (s DeployedStack) Age(now time.Time) time.Duration {
+	if s.CreatedAt == nil {
+		return 0
+	}
+	return now.Sub(*s.CreatedAt)
+}
+
+// ListProjectStacks returns the stacks in region tagged Project=lambda-nat-proxy,
+// oldest first. Deleted stacks are left out.
+func ListProjectStacks(ctx context.Context, cf awsclients.CloudFormationAPI, region string) ([]DeployedStack, error) {
+	var stacks []DeployedStack
+	input := &cloudformation.DescribeStacksInput{}
+	for {
+		result, err := cf.DescribeStacks(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list stacks in %s: %w", region, err)
+		}
+
+		for _, stack := range result.Stacks {
+			if stack.StackStatus == cftypes.StackStatusDeleteComplete {
+				continue
+			}
+			tags := make(map[string]string, len(stack.Tags))
+			for _, tag := range stack.Tags {
+				tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+			}
+			mode := tags["Mode"]
+			if tags["Project"] != ProjectTag {
+				// A stack created in the console from a delegated template has no tags
+				if aws.ToString(stack.Description) != DelegatedTemplateDescription {
+					continue
+				}
+				for _, parameter := range stack.Parameters {
+					if aws.ToString(parameter.ParameterKey) == "Mode" {
+						mode = aws.ToString(parameter.ParameterValue)
+					}
+				}
+			}
+			stacks = append(stacks, DeployedStack{
+				Name:      aws.ToString(stack.StackName),
+				Region:    region,
+				Mode:      mode,
+				Status:    string(stack.StackStatus),
+				CreatedAt: stack.CreationTime,
+				UpdatedAt: stack.LastUpdatedTime,
+			})
+		}
+
+		if aws.ToString(result.NextToken) == "" {
+			break
+		}
+		input.NextToken = result.NextToken
+	}
+
+	now := time.Now()
+	sort.SliceStable(stacks, func(i, j int) bool {
+		return stacks[i].Age(now) > stacks[j].Age(now)
+	})
+	return stacks, nil
+}
+
+// FindStack picks the stack called name from stacks. A name that matches no
+// stack exactly may be the end of one, such as the random suffix of
+// "lambda-nat-proxy-a1b2c3d4", as long as only one stack ends with it.
+func FindStack(stacks []DeployedStack, name string) (DeployedStack, error) {
+	var exact, suffix []DeployedStack
+	for _, stack := range stacks {
+		switch {
+		case stack.Name == name:
+			exact = append(exact, stack)
+		case strings.HasSuffix(stack.Name, name):
+			suffix = append(suffix, stack)
+		}
+	}
+
+	matches := exact
+	if len(matches) == 0 {
+		matches = suffix
+	}
+	switch len(matches) {
+	case 0:
+		return DeployedStack{}, fmt.Errorf("no lambda-nat-proxy stack named %q", name)
+	case 1:
+		return matches[0], nil
+	default:
+		var names []string
+		for _, stack := range matches {
+			names = append(names, stack.Name+" ("+stack.Region+")")
+		}
+		return DeployedStack{}, fmt.Errorf("stack name %q is ambiguous, it matches %s", name, strings.Join(names, ", "))
+	}
+}
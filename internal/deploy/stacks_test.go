@@ -0,0 +1,104 @@
+package deploy
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	cftypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+
+	awsclients "github.com/dan-v/lambda-nat-punch-proxy/internal/aws"
+)
+
+// pagedCloudFormation serves DescribeStacks from pages of stacks
+type pagedCloudFormation struct {
+	awsclients.CloudFormationAPI
+	pages [][]cftypes.Stack
+}
+
+func (f *pagedCloudFormation) DescribeStacks(ctx context.Context, input *cloudformation.DescribeStacksInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DescribeStacksOutput, error) {
+	page := 0
+	if input.NextToken != nil {
+		page, _ = strconv.Atoi(*input.NextToken)
+	}
+	output := &cloudformation.DescribeStacksOutput{Stacks: f.pages[page]}
+	if page+1 < len(f.pages) {
+		output.NextToken = aws.String(strconv.Itoa(page + 1))
+	}
+	return output, nil
+}
+
+func testStack(name, status, project, mode string, created time.Time) cftypes.Stack {
+	stack := cftypes.Stack{
+		StackName:    aws.String(name),
+		StackStatus:  cftypes.StackStatus(status),
+		CreationTime: aws.Time(created),
+	}
+	if project != "" {
+		stack.Tags = []cftypes.Tag{
+			{Key: aws.String("Project"), Value: aws.String(project)},
+			{Key: aws.String("Mode"), Value: aws.String(mode)},
+		}
+	}
+	return stack
+}
+
+func TestListProjectStacks(t *testing.T) {
+	now := time.Now()
+	delegated := testStack("console-proxy", "CREATE_COMPLETE", "", "", now.Add(-time.Minute))
+	delegated.Description = aws.String(DelegatedTemplateDescription)
+	delegated.Parameters = []cftypes.Parameter{{ParameterKey: aws.String("Mode"), ParameterValue: aws.String("test")}}
+	cf := &pagedCloudFormation{pages: [][]cftypes.Stack{
+		{
+			testStack("lambda-nat-proxy-newer", "CREATE_COMPLETE", ProjectTag, "test", now.Add(-time.Hour)),
+			testStack("unrelated", "CREATE_COMPLETE", "", "", now),
+			delegated,
+		},
+		{
+			testStack("lambda-nat-proxy-gone", "DELETE_COMPLETE", ProjectTag, "normal", now),
+			testStack("lambda-nat-proxy-older", "UPDATE_COMPLETE", ProjectTag, "performance", now.Add(-48*time.Hour)),
+		},
+	}}
+
+	stacks, err := ListProjectStacks(context.Background(), cf, "eu-west-1")
+	if err != nil {
+		t.Fatalf("ListProjectStacks failed: %v", err)
+	}
+	if len(stacks) != 3 {
+		t.Fatalf("Expected 3 stacks, got %+v", stacks)
+	}
+	if stacks[0].Name != "lambda-nat-proxy-older" || stacks[0].Mode != "performance" || stacks[0].Region != "eu-west-1" {
+		t.Errorf("Expected the older stack first with its mode and region, got %+v", stacks[0])
+	}
+	if age := stacks[1].Age(now); age != time.Hour {
+		t.Errorf("Expected an age of 1h, got %v", age)
+	}
+	if stacks[2].Name != "console-proxy" || stacks[2].Mode != "test" {
+		t.Errorf("Expected the untagged delegated stack with its Mode parameter, got %+v", stacks[2])
+	}
+}
+
+func TestFindStack(t *testing.T) {
+	stacks := []DeployedStack{
+		{Name: "lambda-nat-proxy-a1b2c3d4", Region: "us-west-2"},
+		{Name: "lambda-nat-proxy-ffff0000", Region: "us-east-1"},
+		{Name: "team-ffff0000", Region: "us-east-1"},
+	}
+
+	if stack, err := FindStack(stacks, "a1b2c3d4"); err != nil || stack.Region != "us-west-2" {
+		t.Errorf("Expected the suffix to select the stack, got %+v, %v", stack, err)
+	}
+	if stack, err := FindStack(stacks, "team-ffff0000"); err != nil || stack.Name != "team-ffff0000" {
+		t.Errorf("Expected an exact name to win, got %+v, %v", stack, err)
+	}
+	if _, err := FindStack(stacks, "ffff0000"); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("Expected an ambiguous suffix to fail, got %v", err)
+	}
+	if _, err := FindStack(stacks, "missing"); err == nil {
+		t.Error("Expected an unknown name to fail")
+	}
+}
@@ -13,13 +13,98 @@ import (
 //go:embed infrastructure.yaml
 var embeddedTemplate string
 
+// TemplateDescription is the Description of the stack deploy creates
+const TemplateDescription = "QUIC NAT Traversal SOCKS5 Proxy Infrastructure"
+
+// DelegatedTemplateDescription is the Description of the stack created from a
+// delegated template, which stacks are recognised by in place of the tag
+// deploy adds
+const DelegatedTemplateDescription = TemplateDescription + " (delegated)"
+
 // TemplateParams holds parameters for CloudFormation template substitution
 type TemplateParams struct {
-	StackName   string
+	StackName          string
+	SessionCredentials bool
+	Description        string
+	
+	// RoleBucketArn is the bucket ARN in the Lambda role's policy. A delegated
+	// template builds it from the bucket's name, as referencing the bucket
+	// would make a cycle through its notification.
+	RoleBucketArn string
+	
+	// Delegated adds the Lambda function, created from a package in S3, and
+	// its S3 trigger, so the stack needs nothing but the console
+	Delegated      bool
+	Mode           string
+	Modes          []ModeSettings
+	Architecture   string
+	CodeHash       string
+	BlockedTargets string
+	
+	// Variant is the flavor of infrastructure, one of config.TemplateVariants
+	// (empty = config.TemplateStandard)
+	Variant string
+}
+
+// Minimal reports whether the template leaves out cost-tracking tags and exports
+func (p TemplateParams) Minimal() bool {
+	return p.Variant == config.TemplateMinimal
+}
+
+// Hardened reports whether the template adds encryption, log retention and alarms
+func (p TemplateParams) Hardened() bool {
+	return p.Variant == config.TemplateHardened
+}
+
+// VPC reports whether the Lambda runs in a VPC behind a NAT gateway
+func (p TemplateParams) VPC() bool {
+	return p.Variant == config.TemplateVPC
+}
+
+// ModeSettings are the Lambda settings of a performance mode
+type ModeSettings struct {
+	Name    string
+	Memory  int
+	Timeout int
 }
 
 // GetCloudFormationTemplate returns the CloudFormation template content
 func GetCloudFormationTemplate(cfg *config.CLIConfig, customTemplatePath string) (string, error) {
+	return renderTemplate(cfg, customTemplatePath, TemplateParams{
+		StackName:          cfg.Deployment.StackName,
+		SessionCredentials: cfg.Deployment.SessionCredentials,
+		Description:        TemplateDescription,
+		RoleBucketArn:      "${CoordinationBucket.Arn}",
+		Variant:            cfg.Deployment.TemplateVariant,
+	})
+}
+
+// GetDelegatedTemplate returns a template that also creates the Lambda
+// function from a package uploaded to S3, for someone with only console
+// access to create the stack. codeHash is the SHA-256 of the package's
+// bootstrap binary.
+func GetDelegatedTemplate(cfg *config.CLIConfig, codeHash string) (string, error) {
+	var modes []ModeSettings
+	for _, mode := range []config.PerformanceMode{config.ModeTest, config.ModeNormal, config.ModePerformance} {
+		modeConfig := config.GetModeConfigs()[mode]
+		modes = append(modes, ModeSettings{Name: string(mode), Memory: modeConfig.LambdaMemory, Timeout: modeConfig.LambdaTimeout})
+	}
+	return renderTemplate(cfg, "", TemplateParams{
+		StackName:          cfg.Deployment.StackName,
+		SessionCredentials: cfg.Deployment.SessionCredentials,
+		Description:        DelegatedTemplateDescription,
+		RoleBucketArn:      "arn:${AWS::Partition}:s3:::${StackName}-coordination-${AWS::AccountId}",
+		Delegated:          true,
+		Mode:               string(cfg.Deployment.Mode),
+		Modes:              modes,
+		Architecture:       LambdaArchitecture(cfg),
+		CodeHash:           codeHash,
+		BlockedTargets:     strings.Join(cfg.Deployment.BlockedTargets, ","),
+		Variant:            cfg.Deployment.TemplateVariant,
+	})
+}
+
+func renderTemplate(cfg *config.CLIConfig, customTemplatePath string, params TemplateParams) (string, error) {
 	var templateContent string
 	
 	// Use custom template file if provided
@@ -35,16 +120,24 @@ func GetCloudFormationTemplate(cfg *config.CLIConfig, customTemplatePath string)
 	}
 	
 	// Perform parameter substitution
-	params := TemplateParams{
-		StackName:   cfg.Deployment.StackName,
-	}
-	
 	substitutedTemplate, err := substituteTemplateParams(templateContent, params)
 	if err != nil {
 		return "", fmt.Errorf("failed to substitute template parameters: %w", err)
 	}
 	
-	return substitutedTemplate, nil
+	if cfg.Deployment.TemplateOverlay == "" {
+		return substitutedTemplate, nil
+	}
+	
+	mergedTemplate, err := ApplyTemplateOverlay(substitutedTemplate, cfg.Deployment.TemplateOverlay, params)
+	if err != nil {
+		return "", err
+	}
+	if err := ValidateMergedTemplate(mergedTemplate); err != nil {
+		return "", fmt.Errorf("template overlay %s: %w", cfg.Deployment.TemplateOverlay, err)
+	}
+	
+	return mergedTemplate, nil
 }
 
 // substituteTemplateParams performs basic parameter substitution in the template
@@ -1,6 +1,8 @@
 package deploy
 
 import (
+	"bytes"
+	"flag"
 	"os"
 	"path/filepath"
 	"strings"
@@ -9,6 +11,8 @@ import (
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
 )
 
+var updateGolden = flag.Bool("update", false, "rewrite the golden templates in testdata")
+
 func TestGetCloudFormationTemplate(t *testing.T) {
 	cfg := &config.CLIConfig{
 		Deployment: config.DeploymentConfig{
@@ -115,4 +119,83 @@ func TestSubstituteTemplateParams(t *testing.T) {
 	if !strings.Contains(result, "my-stack") {
 		t.Error("Expected result to contain stack name")
 	}
-}
\ No newline at end of file
+}
+func TestGetCloudFormationTemplateWithSessionCredentials(t *testing.T) {
+	cfg := &config.CLIConfig{
+		Deployment: config.DeploymentConfig{
+			StackName: "test-stack",
+		},
+	}
+	
+	template, err := GetCloudFormationTemplate(cfg, "")
+	if err != nil {
+		t.Fatalf("Expected no error getting template, got %v", err)
+	}
+	if strings.Contains(template, "SessionCredentialsRole") {
+		t.Error("Expected no session role unless session credentials are enabled")
+	}
+	
+	cfg.Deployment.SessionCredentials = true
+	template, err = GetCloudFormationTemplate(cfg, "")
+	if err != nil {
+		t.Fatalf("Expected no error getting template, got %v", err)
+	}
+	if !strings.Contains(template, "SessionCredentialsRoleArn:") {
+		t.Error("Expected the session role ARN to be an output")
+	}
+	if !strings.Contains(template, "${CoordinationBucket.Arn}/coordination/*") {
+		t.Error("Expected the execution role to be limited to reading coordination objects")
+	}
+	if err := ValidateTemplate(template); err != nil {
+		t.Errorf("Expected a valid template, got %v", err)
+	}
+}
+
+// TestTemplateVariantsGolden renders every template variant, plain and
+// delegated, and compares it with testdata/<variant>[-delegated].yaml. Run
+// with -update after changing infrastructure.yaml and review the diff.
+func TestTemplateVariantsGolden(t *testing.T) {
+	for _, variant := range config.TemplateVariants {
+		for _, delegated := range []bool{false, true} {
+			name := variant
+			cfg := &config.CLIConfig{
+				Deployment: config.DeploymentConfig{
+					StackName:          "golden-stack",
+					SessionCredentials: true,
+					TemplateVariant:    variant,
+					Mode:               config.ModeNormal,
+				},
+			}
+			
+			var template string
+			var err error
+			if delegated {
+				name += "-delegated"
+				template, err = GetDelegatedTemplate(cfg, "0123456789abcdef")
+			} else {
+				template, err = GetCloudFormationTemplate(cfg, "")
+			}
+			if err != nil {
+				t.Fatalf("%s: failed to render template: %v", name, err)
+			}
+			if err := ValidateMergedTemplate(template); err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+			
+			path := filepath.Join("testdata", name+".yaml")
+			if *updateGolden {
+				if err := os.WriteFile(path, []byte(template), 0644); err != nil {
+					t.Fatalf("failed to write %s: %v", path, err)
+				}
+				continue
+			}
+			golden, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("failed to read %s (run with -update to create it): %v", path, err)
+			}
+			if !bytes.Equal(golden, []byte(template)) {
+				t.Errorf("%s differs from the rendered template; run go test ./internal/deploy -run TestTemplateVariantsGolden -update and review the diff", path)
+			}
+		}
+	}
+}
@@ -7,13 +7,14 @@ import (
 	"log"
 	"strings"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/service/lambda"
-	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 	
 	awsclients "github.com/dan-v/lambda-nat-punch-proxy/internal/aws"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
 )
 
 // TriggerDeployerAPI defines the interface for S3 trigger operations
@@ -21,7 +22,7 @@ type TriggerDeployerAPI interface {
 	ConfigureS3Triggers(ctx context.Context, bucketName, functionArn string) error
 	RemoveS3Triggers(ctx context.Context, bucketName, functionArn string) error
 	ValidateTriggerConfiguration(ctx context.Context, bucketName, functionArn string) error
-	GetBucketNotifications(ctx context.Context, bucketName string) (*s3.NotificationConfiguration, error)
+	GetBucketNotifications(ctx context.Context, bucketName string) (*s3.GetBucketNotificationConfigurationOutput, error)
 }
 
 // TriggerDeployer handles S3 trigger configuration
@@ -70,7 +71,7 @@ func (t *TriggerDeployer) RemoveS3Triggers(ctx context.Context, bucketName, func
 
 func (t *TriggerDeployer) addLambdaPermission(ctx context.Context, functionArn, bucketName string) error {
 	statementId := fmt.Sprintf("s3-trigger-%s", t.cfg.Deployment.StackName)
-	sourceArn := fmt.Sprintf("arn:aws:s3:::%s", bucketName)
+	sourceArn := shared.BucketARN(t.clients.Partition, bucketName)
 	
 	input := &lambda.AddPermissionInput{
 		FunctionName: aws.String(functionArn),
@@ -80,14 +81,12 @@ func (t *TriggerDeployer) addLambdaPermission(ctx context.Context, functionArn,
 		SourceArn:    aws.String(sourceArn),
 	}
 	
-	_, err := t.clients.Lambda.AddPermissionWithContext(ctx, input)
+	_, err := t.clients.Lambda.AddPermission(ctx, input)
 	if err != nil {
-		if awsErr, ok := err.(awserr.Error); ok {
-			if awsErr.Code() == lambda.ErrCodeResourceConflictException {
-				// Permission already exists, which is fine
-				log.Printf("Lambda permission already exists")
-				return nil
-			}
+		if isConflict(err) {
+			// Permission already exists, which is fine
+			log.Printf("Lambda permission already exists")
+			return nil
 		}
 		return err
 	}
@@ -104,13 +103,11 @@ func (t *TriggerDeployer) removeLambdaPermission(ctx context.Context, functionAr
 		StatementId:  aws.String(statementId),
 	}
 	
-	_, err := t.clients.Lambda.RemovePermissionWithContext(ctx, input)
+	_, err := t.clients.Lambda.RemovePermission(ctx, input)
 	if err != nil {
-		if awsErr, ok := err.(awserr.Error); ok {
-			if awsErr.Code() == lambda.ErrCodeResourceNotFoundException {
-				// Permission doesn't exist, which is fine
-				return nil
-			}
+		if isNotFound(err) {
+			// Permission doesn't exist, which is fine
+			return nil
 		}
 		return err
 	}
@@ -121,19 +118,19 @@ func (t *TriggerDeployer) removeLambdaPermission(ctx context.Context, functionAr
 
 func (t *TriggerDeployer) configureBucketNotification(ctx context.Context, bucketName, functionArn string) error {
 	// Create notification configuration
-	notificationConfig := &s3.NotificationConfiguration{
-		LambdaFunctionConfigurations: []*s3.LambdaFunctionConfiguration{
+	notificationConfig := &s3types.NotificationConfiguration{
+		LambdaFunctionConfigurations: []s3types.LambdaFunctionConfiguration{
 			{
 				Id:          aws.String("HolePunchTrigger"),
 				LambdaFunctionArn: aws.String(functionArn),
-				Events: []*string{
-					aws.String("s3:ObjectCreated:*"),
+				Events: []s3types.Event{
+					s3types.EventS3ObjectCreated,
 				},
-				Filter: &s3.NotificationConfigurationFilter{
-					Key: &s3.KeyFilter{
-						FilterRules: []*s3.FilterRule{
+				Filter: &s3types.NotificationConfigurationFilter{
+					Key: &s3types.S3KeyFilter{
+						FilterRules: []s3types.FilterRule{
 							{
-								Name:  aws.String("prefix"),
+								Name:  s3types.FilterRuleNamePrefix,
 								Value: aws.String("coordination/"),
 							},
 						},
@@ -148,7 +145,7 @@ func (t *TriggerDeployer) configureBucketNotification(ctx context.Context, bucke
 		NotificationConfiguration: notificationConfig,
 	}
 	
-	_, err := t.clients.S3.PutBucketNotificationConfigurationWithContext(ctx, input)
+	_, err := t.clients.S3.PutBucketNotificationConfiguration(ctx, input)
 	if err != nil {
 		return err
 	}
@@ -161,10 +158,10 @@ func (t *TriggerDeployer) removeBucketNotification(ctx context.Context, bucketNa
 	// Set empty notification configuration to remove all notifications
 	input := &s3.PutBucketNotificationConfigurationInput{
 		Bucket: aws.String(bucketName),
-		NotificationConfiguration: &s3.NotificationConfiguration{},
+		NotificationConfiguration: &s3types.NotificationConfiguration{},
 	}
 	
-	_, err := t.clients.S3.PutBucketNotificationConfigurationWithContext(ctx, input)
+	_, err := t.clients.S3.PutBucketNotificationConfiguration(ctx, input)
 	if err != nil {
 		return err
 	}
@@ -174,12 +171,12 @@ func (t *TriggerDeployer) removeBucketNotification(ctx context.Context, bucketNa
 }
 
 // GetBucketNotifications retrieves current bucket notification configuration
-func (t *TriggerDeployer) GetBucketNotifications(ctx context.Context, bucketName string) (*s3.NotificationConfiguration, error) {
-	input := &s3.GetBucketNotificationConfigurationRequest{
+func (t *TriggerDeployer) GetBucketNotifications(ctx context.Context, bucketName string) (*s3.GetBucketNotificationConfigurationOutput, error) {
+	input := &s3.GetBucketNotificationConfigurationInput{
 		Bucket: aws.String(bucketName),
 	}
 	
-	result, err := t.clients.S3.GetBucketNotificationConfigurationWithContext(ctx, input)
+	result, err := t.clients.S3.GetBucketNotificationConfiguration(ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -221,12 +218,10 @@ func (t *TriggerDeployer) validateLambdaPermission(ctx context.Context, function
 		FunctionName: aws.String(functionArn),
 	}
 	
-	result, err := t.clients.Lambda.GetPolicyWithContext(ctx, input)
+	result, err := t.clients.Lambda.GetPolicy(ctx, input)
 	if err != nil {
-		if awsErr, ok := err.(awserr.Error); ok {
-			if awsErr.Code() == lambda.ErrCodeResourceNotFoundException {
-				return fmt.Errorf("no resource policy found on Lambda function")
-			}
+		if isNotFound(err) {
+			return fmt.Errorf("no resource policy found on Lambda function")
 		}
 		return err
 	}
@@ -241,7 +236,7 @@ func (t *TriggerDeployer) validateLambdaPermission(ctx context.Context, function
 	// This is a simplified check - a more robust implementation would
 	// parse the policy structure more thoroughly
 	policyStr := *result.Policy
-	sourceArn := fmt.Sprintf("arn:aws:s3:::%s", bucketName)
+	sourceArn := shared.BucketARN(t.clients.Partition, bucketName)
 	
 	if !strings.Contains(policyStr, "s3.amazonaws.com") || !strings.Contains(policyStr, sourceArn) {
 		return fmt.Errorf("Lambda policy does not allow S3 bucket %s to invoke function", bucketName)
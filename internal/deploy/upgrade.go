@@ -0,0 +1,212 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdatypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// previousPrefix marks the version a rollback returns to in the live alias's
+// description
+const previousPrefix = "previous="
+
+// LiveState is what the live alias runs, compared with $LATEST
+type LiveState struct {
+	Pinned       bool    // the live alias exists
+	Version      string  // version the alias runs; empty if not pinned
+	CodeHash     string  // code hash of Version, or of $LATEST if not pinned
+	LatestHash   string  // code hash of $LATEST
+	Canary       string  // version receiving CanaryWeight of invocations; empty if none
+	CanaryWeight float64 // 0 to 1
+	Previous     string  // version RollbackLive returns to; empty if none
+}
+
+// LiveARN returns the ARN of functionArn's live alias
+func LiveARN(functionArn string) string {
+	return functionArn + ":" + shared.LiveAlias
+}
+
+// LiveState reads the live alias and the code hashes of what it and $LATEST
+// run. Code deployed before hashes were recorded has an empty hash.
+func (d *LambdaDeployer) LiveState(ctx context.Context) (*LiveState, error) {
+	functionName := d.getFunctionName()
+	state := &LiveState{}
+
+	latest, err := d.codeHash(ctx, functionName, "")
+	if err != nil {
+		return nil, err
+	}
+	state.LatestHash = latest
+	state.CodeHash = latest
+
+	alias, err := d.clients.Lambda.GetAlias(ctx, &lambda.GetAliasInput{
+		FunctionName: aws.String(functionName),
+		Name:         aws.String(shared.LiveAlias),
+	})
+	if isNotFound(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the %s alias: %w", shared.LiveAlias, err)
+	}
+
+	state.Pinned = true
+	state.Version = aws.ToString(alias.FunctionVersion)
+	state.Previous = strings.TrimPrefix(aws.ToString(alias.Description), previousPrefix)
+	if alias.RoutingConfig != nil {
+		for version, weight := range alias.RoutingConfig.AdditionalVersionWeights {
+			state.Canary, state.CanaryWeight = version, weight
+		}
+	}
+	if state.CodeHash, err = d.codeHash(ctx, functionName, state.Version); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// codeHash returns shared.CodeHashEnv of a version of the function ("" = $LATEST)
+func (d *LambdaDeployer) codeHash(ctx context.Context, functionName, version string) (string, error) {
+	input := &lambda.GetFunctionInput{FunctionName: aws.String(functionName)}
+	if version != "" {
+		input.Qualifier = aws.String(version)
+	}
+	function, err := d.clients.Lambda.GetFunction(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", functionName, err)
+	}
+	if env := function.Configuration.Environment; env != nil {
+		return env.Variables[shared.CodeHashEnv], nil
+	}
+	return "", nil
+}
+
+// PublishLive publishes $LATEST as a new version and sends weight (above 0,
+// up to 1) of the live alias's invocations to it. A weight of 1 moves the
+// alias to the new version; less starts a canary that PromoteLive completes.
+// The first publish creates the alias on the new version whatever the weight.
+func (d *LambdaDeployer) PublishLive(ctx context.Context, weight float64) (string, error) {
+	functionName := d.getFunctionName()
+	state, err := d.LiveState(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	published, err := d.clients.Lambda.PublishVersion(ctx, &lambda.PublishVersionInput{
+		FunctionName: aws.String(functionName),
+		Description:  aws.String(fmt.Sprintf("code %s", ShortHash(state.LatestHash))),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to publish a version: %w", err)
+	}
+	version := aws.ToString(published.Version)
+
+	switch {
+	case !state.Pinned:
+		_, err = d.clients.Lambda.CreateAlias(ctx, &lambda.CreateAliasInput{
+			FunctionName:    aws.String(functionName),
+			Name:            aws.String(shared.LiveAlias),
+			FunctionVersion: aws.String(version),
+		})
+		if err == nil {
+			log.Printf("Created the %s alias on version %s", shared.LiveAlias, version)
+		}
+	case version == state.Version:
+		// $LATEST had nothing new, so the alias already runs it; drop any canary
+		err = d.updateLive(ctx, state.Version, "", 0, state.Previous)
+	case weight < 1:
+		err = d.updateLive(ctx, state.Version, version, weight, state.Previous)
+		if err == nil {
+			log.Printf("Sending %.0f%% of invocations to version %s, the rest to version %s", weight*100, version, state.Version)
+		}
+	default:
+		err = d.updateLive(ctx, version, "", 0, state.Version)
+		if err == nil {
+			log.Printf("Moved the %s alias from version %s to %s", shared.LiveAlias, state.Version, version)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to point the %s alias at version %s: %w", shared.LiveAlias, version, err)
+	}
+	return version, nil
+}
+
+// PromoteLive moves the live alias to its canary version
+func (d *LambdaDeployer) PromoteLive(ctx context.Context) (string, error) {
+	state, err := d.LiveState(ctx)
+	if err != nil {
+		return "", err
+	}
+	if state.Canary == "" {
+		return "", fmt.Errorf("no canary to promote: the %s alias runs version %s only", shared.LiveAlias, state.Version)
+	}
+	if err := d.updateLive(ctx, state.Canary, "", 0, state.Version); err != nil {
+		return "", fmt.Errorf("failed to promote version %s: %w", state.Canary, err)
+	}
+	log.Printf("Promoted version %s; version %s is kept for rollback", state.Canary, state.Version)
+	return state.Canary, nil
+}
+
+// RollbackLive cancels a canary, or otherwise moves the live alias back to
+// the version it ran before. Rolling back twice returns to where it started.
+func (d *LambdaDeployer) RollbackLive(ctx context.Context) (string, error) {
+	state, err := d.LiveState(ctx)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case !state.Pinned:
+		return "", fmt.Errorf("nothing to roll back: there is no %s alias yet", shared.LiveAlias)
+	case state.Canary != "":
+		err = d.updateLive(ctx, state.Version, "", 0, state.Previous)
+		if err == nil {
+			log.Printf("Cancelled the canary of version %s", state.Canary)
+		}
+		return state.Version, err
+	case state.Previous == "":
+		return "", fmt.Errorf("nothing to roll back to: version %s is the only one the %s alias has run", state.Version, shared.LiveAlias)
+	}
+	if err := d.updateLive(ctx, state.Previous, "", 0, state.Version); err != nil {
+		return "", fmt.Errorf("failed to roll back to version %s: %w", state.Previous, err)
+	}
+	log.Printf("Rolled back from version %s to %s", state.Version, state.Previous)
+	return state.Previous, nil
+}
+
+// updateLive points the live alias at version, sending weight of
+// invocations to canary if set, and records previous for rollbacks
+func (d *LambdaDeployer) updateLive(ctx context.Context, version, canary string, weight float64, previous string) error {
+	routing := &lambdatypes.AliasRoutingConfiguration{AdditionalVersionWeights: map[string]float64{}}
+	if canary != "" {
+		routing.AdditionalVersionWeights[canary] = weight
+	}
+	input := &lambda.UpdateAliasInput{
+		FunctionName:    aws.String(d.getFunctionName()),
+		Name:            aws.String(shared.LiveAlias),
+		FunctionVersion: aws.String(version),
+		RoutingConfig:   routing,
+		Description:     aws.String(""),
+	}
+	if previous != "" {
+		input.Description = aws.String(previousPrefix + previous)
+	}
+	_, err := d.clients.Lambda.UpdateAlias(ctx, input)
+	return err
+}
+
+// ShortHash abbreviates a code hash for display
+func ShortHash(hash string) string {
+	if hash == "" {
+		return "unknown"
+	}
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
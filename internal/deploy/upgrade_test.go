@@ -0,0 +1,125 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdatypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/aws/smithy-go"
+
+	awsclients "github.com/dan-v/lambda-nat-punch-proxy/internal/aws"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// liveLambda publishes versions of $LATEST's code hash and keeps the live alias
+type liveLambda struct {
+	awsclients.LambdaAPI
+	latest   string            // code hash of $LATEST
+	versions map[string]string // version -> code hash
+	alias    *lambdatypes.AliasConfiguration
+}
+
+func (l *liveLambda) GetFunction(ctx context.Context, input *lambda.GetFunctionInput, optFns ...func(*lambda.Options)) (*lambda.GetFunctionOutput, error) {
+	hash := l.latest
+	if input.Qualifier != nil {
+		hash = l.versions[aws.ToString(input.Qualifier)]
+	}
+	return &lambda.GetFunctionOutput{Configuration: &lambdatypes.FunctionConfiguration{
+		Environment: &lambdatypes.EnvironmentResponse{Variables: map[string]string{shared.CodeHashEnv: hash}},
+	}}, nil
+}
+
+func (l *liveLambda) PublishVersion(ctx context.Context, input *lambda.PublishVersionInput, optFns ...func(*lambda.Options)) (*lambda.PublishVersionOutput, error) {
+	// Like Lambda, publishing unchanged code returns the last version
+	last := fmt.Sprint(len(l.versions))
+	if l.versions[last] != l.latest {
+		last = fmt.Sprint(len(l.versions) + 1)
+		l.versions[last] = l.latest
+	}
+	return &lambda.PublishVersionOutput{Version: aws.String(last)}, nil
+}
+
+func (l *liveLambda) GetAlias(ctx context.Context, input *lambda.GetAliasInput, optFns ...func(*lambda.Options)) (*lambda.GetAliasOutput, error) {
+	if l.alias == nil {
+		return nil, &smithy.GenericAPIError{Code: "ResourceNotFoundException", Message: "no such alias"}
+	}
+	return &lambda.GetAliasOutput{FunctionVersion: l.alias.FunctionVersion, RoutingConfig: l.alias.RoutingConfig, Description: l.alias.Description}, nil
+}
+
+func (l *liveLambda) CreateAlias(ctx context.Context, input *lambda.CreateAliasInput, optFns ...func(*lambda.Options)) (*lambda.CreateAliasOutput, error) {
+	l.alias = &lambdatypes.AliasConfiguration{FunctionVersion: input.FunctionVersion}
+	return &lambda.CreateAliasOutput{FunctionVersion: input.FunctionVersion}, nil
+}
+
+func (l *liveLambda) UpdateAlias(ctx context.Context, input *lambda.UpdateAliasInput, optFns ...func(*lambda.Options)) (*lambda.UpdateAliasOutput, error) {
+	l.alias = &lambdatypes.AliasConfiguration{FunctionVersion: input.FunctionVersion, RoutingConfig: input.RoutingConfig, Description: input.Description}
+	return &lambda.UpdateAliasOutput{FunctionVersion: input.FunctionVersion, RoutingConfig: input.RoutingConfig, Description: input.Description}, nil
+}
+
+func TestPublishLiveCanaryAndRollback(t *testing.T) {
+	cfg := config.DefaultCLIConfig()
+	cfg.Deployment.StackName = "proxy"
+	fn := &liveLambda{latest: "aaa", versions: map[string]string{}}
+	deployer := NewLambdaDeployer(&awsclients.Clients{Lambda: fn}, cfg)
+	ctx := context.Background()
+
+	if _, err := deployer.RollbackLive(ctx); err == nil {
+		t.Error("Expected no rollback before the alias exists")
+	}
+	if version, err := deployer.PublishLive(ctx, 0.1); err != nil || version != "1" {
+		t.Fatalf("PublishLive = %s, %v", version, err)
+	}
+	state, _ := deployer.LiveState(ctx)
+	if !state.Pinned || state.Version != "1" || state.Canary != "" || state.CodeHash != "aaa" {
+		t.Fatalf("Expected the first publish to pin version 1 outright, got %+v", state)
+	}
+
+	// A canary of new code keeps version 1 as the main version
+	fn.latest = "bbb"
+	if _, err := deployer.PublishLive(ctx, 0.1); err != nil {
+		t.Fatalf("PublishLive failed: %v", err)
+	}
+	state, _ = deployer.LiveState(ctx)
+	if state.Version != "1" || state.Canary != "2" || state.CanaryWeight != 0.1 || state.LatestHash != "bbb" {
+		t.Fatalf("Expected a 10%% canary of version 2, got %+v", state)
+	}
+
+	// Rolling back cancels the canary
+	if version, err := deployer.RollbackLive(ctx); err != nil || version != "1" {
+		t.Fatalf("RollbackLive = %s, %v", version, err)
+	}
+	if state, _ = deployer.LiveState(ctx); state.Canary != "" {
+		t.Fatalf("Expected the canary cancelled, got %+v", state)
+	}
+
+	// Promoting a new canary keeps the old version for a rollback
+	deployer.PublishLive(ctx, 0.5)
+	if version, err := deployer.PromoteLive(ctx); err != nil || version != "2" {
+		t.Fatalf("PromoteLive = %s, %v", version, err)
+	}
+	if state, _ = deployer.LiveState(ctx); state.Version != "2" || state.Previous != "1" || state.CodeHash != "bbb" {
+		t.Fatalf("Expected version 2 with 1 kept, got %+v", state)
+	}
+	if _, err := deployer.PromoteLive(ctx); err == nil {
+		t.Error("Expected nothing to promote without a canary")
+	}
+	if version, err := deployer.RollbackLive(ctx); err != nil || version != "1" {
+		t.Fatalf("RollbackLive = %s, %v", version, err)
+	}
+	if state, _ = deployer.LiveState(ctx); state.Version != "1" || state.Previous != "2" {
+		t.Errorf("Expected version 1 with 2 kept, got %+v", state)
+	}
+
+	// A full publish moves the alias at once
+	fn.latest = "ccc"
+	if version, err := deployer.PublishLive(ctx, 1); err != nil || version != "3" {
+		t.Fatalf("PublishLive = %s, %v", version, err)
+	}
+	if state, _ = deployer.LiveState(ctx); state.Version != "3" || state.Previous != "1" || state.Canary != "" {
+		t.Errorf("Expected version 3 with 1 kept, got %+v", state)
+	}
+}
@@ -0,0 +1,121 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// warmupTargetID identifies the function among the warm-up rule's targets
+const warmupTargetID = "lambda"
+
+// ConfigureWarmup has an EventBridge rule invoke the function every
+// deployment.warmup with shared.WarmupPayload, which it answers at once, so
+// a session launched later finds an initialized execution environment
+func (d *LambdaDeployer) ConfigureWarmup(ctx context.Context, functionArn string) error {
+	ruleName := d.warmupRuleName()
+
+	rule, err := d.clients.EventBridge.PutRule(ctx, &eventbridge.PutRuleInput{
+		Name:               aws.String(ruleName),
+		ScheduleExpression: aws.String(warmupSchedule(d.cfg.Deployment.Warmup)),
+		State:              ebtypes.RuleStateEnabled,
+		Description:        aws.String(fmt.Sprintf("Keeps %s warm between sessions", d.getFunctionName())),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create warm-up rule: %w", err)
+	}
+
+	_, err = d.clients.Lambda.AddPermission(ctx, &lambda.AddPermissionInput{
+		FunctionName: aws.String(functionArn),
+		StatementId:  aws.String(ruleName),
+		Action:       aws.String("lambda:InvokeFunction"),
+		Principal:    aws.String("events.amazonaws.com"),
+		SourceArn:    rule.RuleArn,
+	})
+	if err != nil && !isConflict(err) {
+		return fmt.Errorf("failed to allow the warm-up rule to invoke the function: %w", err)
+	}
+
+	output, err := d.clients.EventBridge.PutTargets(ctx, &eventbridge.PutTargetsInput{
+		Rule: aws.String(ruleName),
+		Targets: []ebtypes.Target{{
+			Id:    aws.String(warmupTargetID),
+			Arn:   aws.String(functionArn),
+			Input: aws.String(shared.WarmupPayload),
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to target the function from the warm-up rule: %w", err)
+	}
+	if output.FailedEntryCount > 0 {
+		return fmt.Errorf("failed to target the function from the warm-up rule: %s", aws.ToString(output.FailedEntries[0].ErrorMessage))
+	}
+
+	log.Printf("Scheduled warm-up invocations every %v", d.cfg.Deployment.Warmup)
+	return nil
+}
+
+// RemoveWarmup deletes the warm-up rule and its permission, if they exist
+func (d *LambdaDeployer) RemoveWarmup(ctx context.Context, functionArn string) error {
+	ruleName := d.warmupRuleName()
+
+	_, err := d.clients.EventBridge.RemoveTargets(ctx, &eventbridge.RemoveTargetsInput{
+		Rule: aws.String(ruleName),
+		Ids:  []string{warmupTargetID},
+	})
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to remove warm-up target: %w", err)
+	}
+	removed := err == nil
+	if _, err := d.clients.EventBridge.DeleteRule(ctx, &eventbridge.DeleteRuleInput{
+		Name: aws.String(ruleName),
+	}); err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to delete warm-up rule: %w", err)
+	}
+	_, err = d.clients.Lambda.RemovePermission(ctx, &lambda.RemovePermissionInput{
+		FunctionName: aws.String(functionArn),
+		StatementId:  aws.String(ruleName),
+	})
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("failed to remove warm-up permission: %w", err)
+	}
+
+	if removed || err == nil {
+		log.Printf("Removed warm-up schedule")
+	}
+	return nil
+}
+
+func (d *LambdaDeployer) warmupRuleName() string {
+	return fmt.Sprintf("%s-warmup", d.cfg.Deployment.StackName)
+}
+
+// warmupSchedule returns the EventBridge schedule expression for interval,
+// which validation keeps to whole minutes
+func warmupSchedule(interval time.Duration) string {
+	minutes := int(interval / time.Minute)
+	if minutes <= 1 {
+		return "rate(1 minute)"
+	}
+	return fmt.Sprintf("rate(%d minutes)", minutes)
+}
+
+// isNotFound reports whether err is a Lambda or EventBridge missing resource
+// error; both services use the same code
+func isNotFound(err error) bool {
+	return shared.AWSErrorCode(err) == "ResourceNotFoundException"
+}
+
+// isConflict reports whether err is a Lambda error for a resource, such as a
+// permission statement, that already exists
+func isConflict(err error) bool {
+	return shared.AWSErrorCode(err) == "ResourceConflictException"
+}
@@ -0,0 +1,104 @@
+package deploy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/smithy-go"
+
+	awsclients "github.com/dan-v/lambda-nat-punch-proxy/internal/aws"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// fakeEventBridge keeps the rules and targets put to it
+type fakeEventBridge struct {
+	rules   map[string]string // name -> schedule
+	targets map[string][]ebtypes.Target
+}
+
+func (f *fakeEventBridge) PutRule(ctx context.Context, input *eventbridge.PutRuleInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutRuleOutput, error) {
+	f.rules[aws.ToString(input.Name)] = aws.ToString(input.ScheduleExpression)
+	return &eventbridge.PutRuleOutput{RuleArn: aws.String("arn:aws:events:us-west-2:123456789012:rule/" + aws.ToString(input.Name))}, nil
+}
+
+func (f *fakeEventBridge) PutTargets(ctx context.Context, input *eventbridge.PutTargetsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.PutTargetsOutput, error) {
+	f.targets[aws.ToString(input.Rule)] = input.Targets
+	return &eventbridge.PutTargetsOutput{}, nil
+}
+
+func (f *fakeEventBridge) RemoveTargets(ctx context.Context, input *eventbridge.RemoveTargetsInput, optFns ...func(*eventbridge.Options)) (*eventbridge.RemoveTargetsOutput, error) {
+	if _, ok := f.rules[aws.ToString(input.Rule)]; !ok {
+		return nil, &smithy.GenericAPIError{Code: "ResourceNotFoundException", Message: "no such rule"}
+	}
+	delete(f.targets, aws.ToString(input.Rule))
+	return &eventbridge.RemoveTargetsOutput{}, nil
+}
+
+func (f *fakeEventBridge) DeleteRule(ctx context.Context, input *eventbridge.DeleteRuleInput, optFns ...func(*eventbridge.Options)) (*eventbridge.DeleteRuleOutput, error) {
+	delete(f.rules, aws.ToString(input.Name))
+	return &eventbridge.DeleteRuleOutput{}, nil
+}
+
+// permissionLambda keeps the statements added to the function's policy
+type permissionLambda struct {
+	awsclients.LambdaAPI
+	statements map[string]string // statement ID -> principal
+}
+
+func (l *permissionLambda) AddPermission(ctx context.Context, input *lambda.AddPermissionInput, optFns ...func(*lambda.Options)) (*lambda.AddPermissionOutput, error) {
+	l.statements[aws.ToString(input.StatementId)] = aws.ToString(input.Principal)
+	return &lambda.AddPermissionOutput{}, nil
+}
+
+func (l *permissionLambda) RemovePermission(ctx context.Context, input *lambda.RemovePermissionInput, optFns ...func(*lambda.Options)) (*lambda.RemovePermissionOutput, error) {
+	if _, ok := l.statements[aws.ToString(input.StatementId)]; !ok {
+		return nil, &smithy.GenericAPIError{Code: "ResourceNotFoundException", Message: "no such statement"}
+	}
+	delete(l.statements, aws.ToString(input.StatementId))
+	return &lambda.RemovePermissionOutput{}, nil
+}
+
+func TestWarmupSchedule(t *testing.T) {
+	cfg := config.DefaultCLIConfig()
+	cfg.Deployment.StackName = "proxy"
+	cfg.Deployment.Warmup = 5 * time.Minute
+	events := &fakeEventBridge{rules: map[string]string{}, targets: map[string][]ebtypes.Target{}}
+	fn := &permissionLambda{statements: map[string]string{}}
+	deployer := NewLambdaDeployer(&awsclients.Clients{EventBridge: events, Lambda: fn}, cfg)
+	functionArn := "arn:aws:lambda:us-west-2:123456789012:function:proxy-lambda"
+
+	if err := deployer.ConfigureWarmup(context.Background(), functionArn); err != nil {
+		t.Fatalf("ConfigureWarmup failed: %v", err)
+	}
+	if got := events.rules["proxy-warmup"]; got != "rate(5 minutes)" {
+		t.Errorf("Expected a 5 minute schedule, got %q", got)
+	}
+	targets := events.targets["proxy-warmup"]
+	if len(targets) != 1 || aws.ToString(targets[0].Arn) != functionArn || aws.ToString(targets[0].Input) != shared.WarmupPayload {
+		t.Errorf("Expected the function targeted with the warm-up payload, got %v", targets)
+	}
+	if fn.statements["proxy-warmup"] != "events.amazonaws.com" {
+		t.Errorf("Expected EventBridge allowed to invoke the function, got %v", fn.statements)
+	}
+
+	if err := deployer.RemoveWarmup(context.Background(), functionArn); err != nil {
+		t.Fatalf("RemoveWarmup failed: %v", err)
+	}
+	if len(events.rules) != 0 || len(fn.statements) != 0 {
+		t.Errorf("Expected the rule and permission removed, got %v and %v", events.rules, fn.statements)
+	}
+	// Removing a schedule that doesn't exist is not an error
+	if err := deployer.RemoveWarmup(context.Background(), functionArn); err != nil {
+		t.Errorf("Expected no error removing a missing schedule, got %v", err)
+	}
+
+	if got := warmupSchedule(time.Minute); got != "rate(1 minute)" {
+		t.Errorf("Expected the singular unit for one minute, got %q", got)
+	}
+}
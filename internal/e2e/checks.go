@@ -0,0 +1,108 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// WaitForProxy waits until something accepts TCP connections on addr. The
+// proxy only listens once its first session is up, so this also waits for
+// the tunnel.
+func WaitForProxy(ctx context.Context, addr string, exited <-chan struct{}) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("proxy not listening on %s: %w", addr, ctx.Err())
+		case <-exited:
+			return fmt.Errorf("proxy exited before listening on %s", addr)
+		case <-ticker.C:
+		}
+	}
+}
+
+// proxyClient returns an HTTP client that tunnels through the SOCKS5 proxy at addr
+func proxyClient(addr string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:             http.ProxyURL(&url.URL{Scheme: "socks5", Host: addr}),
+			DisableKeepAlives: true,
+		},
+	}
+}
+
+// Fetch GETs target through the proxy at addr and returns the body, which
+// must come with a 200
+func Fetch(ctx context.Context, addr, target string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := proxyClient(addr).Do(req)
+	if err != nil {
+		return "", fmt.Errorf("request through proxy failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// Throughput is the result of a download benchmark
+type Throughput struct {
+	Bytes         int64
+	TimeToFirst   time.Duration // until the response headers arrived
+	Duration      time.Duration // of the whole download
+	BitsPerSecond float64
+}
+
+// Download GETs target through the proxy at addr, discarding the body, and
+// measures how fast it arrived
+func Download(ctx context.Context, addr, target string) (Throughput, error) {
+	var result Throughput
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return result, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	start := time.Now()
+	resp, err := proxyClient(addr).Do(req)
+	if err != nil {
+		return result, fmt.Errorf("request through proxy failed: %w", err)
+	}
+	defer resp.Body.Close()
+	result.TimeToFirst = time.Since(start)
+	if resp.StatusCode != http.StatusOK {
+		return result, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	result.Bytes, err = io.Copy(io.Discard, resp.Body)
+	result.Duration = time.Since(start)
+	if err != nil {
+		return result, fmt.Errorf("download failed after %d bytes: %w", result.Bytes, err)
+	}
+	if result.Bytes == 0 {
+		return result, fmt.Errorf("empty response")
+	}
+	if transfer := result.Duration - result.TimeToFirst; transfer > 0 {
+		result.BitsPerSecond = float64(result.Bytes*8) / transfer.Seconds()
+	}
+	return result, nil
+}
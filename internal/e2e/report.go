@@ -0,0 +1,206 @@
+package e2e
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Result is the outcome of one step of a run
+type Result struct {
+	Name     string             `json:"name"`
+	Duration time.Duration      `json:"duration"`
+	Passed   bool               `json:"passed"`
+	Skipped  bool               `json:"skipped,omitempty"`
+	Error    string             `json:"error,omitempty"`
+	Output   string             `json:"output,omitempty"`  // e.g. the egress IP a smoke check saw
+	Metrics  map[string]float64 `json:"metrics,omitempty"` // e.g. throughput of a benchmark
+}
+
+// Step is handed to a running step so it can attach output and metrics
+type Step struct {
+	result *Result
+}
+
+// Log sets the step's output
+func (s *Step) Log(format string, args ...interface{}) {
+	s.result.Output = fmt.Sprintf(format, args...)
+}
+
+// Metric records a measurement taken by the step
+func (s *Step) Metric(name string, value float64) {
+	if s.result.Metrics == nil {
+		s.result.Metrics = make(map[string]float64)
+	}
+	s.result.Metrics[name] = value
+}
+
+// Report collects the results of a run, in the order the steps ran
+type Report struct {
+	Suite     string        `json:"suite"`
+	StackName string        `json:"stack_name"`
+	Region    string        `json:"region"`
+	Mode      string        `json:"mode"`
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"duration"`
+	Results   []Result      `json:"results"`
+
+	mu sync.Mutex
+}
+
+// NewReport starts a report for the named suite
+func NewReport(suite string) *Report {
+	return &Report{Suite: suite, StartedAt: time.Now()}
+}
+
+// Run times fn as the named step and records its result. It returns fn's error.
+func (r *Report) Run(name string, fn func(step *Step) error) error {
+	result := Result{Name: name}
+	start := time.Now()
+	err := fn(&Step{result: &result})
+	result.Duration = time.Since(start)
+	result.Passed = err == nil
+	if err != nil {
+		result.Error = err.Error()
+	}
+	r.add(result)
+	return err
+}
+
+// Skip records the named step as skipped for reason
+func (r *Report) Skip(name, reason string) {
+	r.add(Result{Name: name, Skipped: true, Output: reason})
+}
+
+func (r *Report) add(result Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Results = append(r.Results, result)
+	r.Duration = time.Since(r.StartedAt)
+}
+
+// Failed returns the number of steps that failed
+func (r *Report) Failed() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	failed := 0
+	for _, result := range r.Results {
+		if !result.Passed && !result.Skipped {
+			failed++
+		}
+	}
+	return failed
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// JUnit XML, as read by most CI systems
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Cases      []junitCase     `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitCase struct {
+	Name       string          `xml:"name,attr"`
+	Classname  string          `xml:"classname,attr"`
+	Time       string          `xml:"time,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Failure    *junitFailure   `xml:"failure,omitempty"`
+	Skipped    *junitSkipped   `xml:"skipped,omitempty"`
+	SystemOut  string          `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// WriteJUnit writes the report as a JUnit XML test suite, one test case per
+// step, with step metrics as test case properties
+func (r *Report) WriteJUnit(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	suite := junitSuite{
+		Name:      r.Suite,
+		Tests:     len(r.Results),
+		Time:      seconds(r.Duration),
+		Timestamp: r.StartedAt.UTC().Format(time.RFC3339),
+		Properties: []junitProperty{
+			{Name: "stack_name", Value: r.StackName},
+			{Name: "region", Value: r.Region},
+			{Name: "mode", Value: r.Mode},
+		},
+	}
+	for _, result := range r.Results {
+		tc := junitCase{
+			Name:      result.Name,
+			Classname: r.Suite,
+			Time:      seconds(result.Duration),
+			SystemOut: result.Output,
+		}
+		names := make([]string, 0, len(result.Metrics))
+		for name := range result.Metrics {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			tc.Properties = append(tc.Properties, junitProperty{Name: name, Value: fmt.Sprintf("%g", result.Metrics[name])})
+		}
+		switch {
+		case result.Skipped:
+			suite.Skipped++
+			tc.Skipped = &junitSkipped{Message: result.Output}
+		case !result.Passed:
+			suite.Failures++
+			tc.Failure = &junitFailure{Message: result.Error}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(junitSuites{Suites: []junitSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// seconds formats d as JUnit expects, in fractional seconds
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
@@ -0,0 +1,53 @@
+package e2e
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReportJUnit(t *testing.T) {
+	report := NewReport("suite")
+	report.StackName = "lambda-nat-proxy-ci-1234"
+	report.Run("deploy", func(step *Step) error { return nil })
+	report.Run("benchmark", func(step *Step) error {
+		step.Metric("throughput_mbps", 42.5)
+		return errors.New("too slow")
+	})
+	report.Skip("destroy", "--keep")
+
+	if report.Failed() != 1 {
+		t.Errorf("Expected 1 failed step, got %d", report.Failed())
+	}
+
+	var out bytes.Buffer
+	if err := report.WriteJUnit(&out); err != nil {
+		t.Fatalf("WriteJUnit failed: %v", err)
+	}
+	xml := out.String()
+	for _, want := range []string{
+		`<testsuite name="suite" tests="3" failures="1" skipped="1"`,
+		`<property name="stack_name" value="lambda-nat-proxy-ci-1234">`,
+		`<failure message="too slow">`,
+		`<property name="throughput_mbps" value="42.5">`,
+		`<skipped message="--keep">`,
+	} {
+		if !strings.Contains(xml, want) {
+			t.Errorf("Expected JUnit output to contain %s, got:\n%s", want, xml)
+		}
+	}
+
+	out.Reset()
+	if err := report.WriteJSON(&out); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("Failed to decode JSON report: %v", err)
+	}
+	if len(decoded.Results) != 3 || decoded.Results[1].Metrics["throughput_mbps"] != 42.5 {
+		t.Errorf("Unexpected JSON results %+v", decoded.Results)
+	}
+}
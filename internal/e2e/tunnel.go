@@ -0,0 +1,144 @@
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"time"
+
+	"golang.org/x/net/proxy"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// throughputTargetPort is sent with the throughput targets, which ignore it
+const throughputTargetPort = "9"
+
+// dialTarget connects to one of the Lambda's throughput targets through the
+// SOCKS5 proxy at addr
+func dialTarget(ctx context.Context, addr, target string) (net.Conn, error) {
+	dialer, err := proxy.SOCKS5("tcp", addr, nil, proxy.Direct)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SOCKS5 dialer: %w", err)
+	}
+	conn, err := dialer.(proxy.ContextDialer).DialContext(ctx, "tcp", net.JoinHostPort(target, throughputTargetPort))
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s through proxy (is the Lambda up to date?): %w", target, err)
+	}
+	return conn, nil
+}
+
+// TunnelDownload reads from the Lambda's source target through the proxy at
+// addr for duration and measures how fast the data arrived. Nothing outside
+// the tunnel is involved.
+func TunnelDownload(ctx context.Context, addr string, duration time.Duration) (Throughput, error) {
+	var result Throughput
+	start := time.Now()
+	conn, err := dialTarget(ctx, addr, shared.SourceTarget)
+	if err != nil {
+		return result, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(duration))
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	buf := make([]byte, shared.OptimizedBufferSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return result, fmt.Errorf("no data from the source target: %w", err)
+	}
+	result.TimeToFirst = time.Since(start)
+	rest, _ := io.CopyBuffer(io.Discard, conn, buf)
+	result.Bytes = int64(n) + rest
+	return finish(ctx, result, start)
+}
+
+// TunnelUpload writes to the Lambda's discard target through the proxy at
+// addr for duration and measures how fast the data was accepted
+func TunnelUpload(ctx context.Context, addr string, duration time.Duration) (Throughput, error) {
+	var result Throughput
+	start := time.Now()
+	conn, err := dialTarget(ctx, addr, shared.DiscardTarget)
+	if err != nil {
+		return result, err
+	}
+	defer conn.Close()
+	result.TimeToFirst = time.Since(start)
+	conn.SetDeadline(time.Now().Add(duration))
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	buf := make([]byte, shared.OptimizedBufferSize)
+	for {
+		n, err := conn.Write(buf)
+		result.Bytes += int64(n)
+		if err != nil {
+			break
+		}
+	}
+	return finish(ctx, result, start)
+}
+
+// finish completes a timed transfer, failing it if ctx was cancelled or
+// nothing was transferred
+func finish(ctx context.Context, result Throughput, start time.Time) (Throughput, error) {
+	result.Duration = time.Since(start)
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	if result.Bytes == 0 {
+		return result, fmt.Errorf("no data transferred")
+	}
+	if transfer := result.Duration - result.TimeToFirst; transfer > 0 {
+		result.BitsPerSecond = float64(result.Bytes*8) / transfer.Seconds()
+	}
+	return result, nil
+}
+
+// Latency is the result of an echo round-trip test
+type Latency struct {
+	Samples int
+	Min     time.Duration
+	Median  time.Duration
+	Max     time.Duration
+}
+
+// TunnelLatency sends samples small messages to the Lambda's echo target
+// through the proxy at addr, one at a time, and times each round trip
+func TunnelLatency(ctx context.Context, addr string, samples int) (Latency, error) {
+	var result Latency
+	conn, err := dialTarget(ctx, addr, shared.EchoTarget)
+	if err != nil {
+		return result, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	ping := []byte("ping")
+	pong := make([]byte, len(ping))
+	rtts := make([]time.Duration, 0, samples)
+	for i := 0; i < samples; i++ {
+		start := time.Now()
+		if _, err := conn.Write(ping); err != nil {
+			return result, fmt.Errorf("echo failed: %w", err)
+		}
+		if _, err := io.ReadFull(conn, pong); err != nil {
+			return result, fmt.Errorf("echo failed: %w", err)
+		}
+		rtts = append(rtts, time.Since(start))
+	}
+	if len(rtts) == 0 {
+		return result, fmt.Errorf("no samples")
+	}
+
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	result.Samples = len(rtts)
+	result.Min = rtts[0]
+	result.Median = rtts[len(rtts)/2]
+	result.Max = rtts[len(rtts)-1]
+	return result, nil
+}
@@ -0,0 +1,203 @@
+// Package events carries the proxy's session lifecycle, tunnel health,
+// budget, evacuation, connection and error events to subscribers such as the
+// log, notifications, the audit log and the admin API's event stream.
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	SessionLaunched     = "session.launched"          // a session's tunnel is up
+	SessionLaunchFailed = "session.launch_failed"     // a launch attempt failed
+	HolePunchFailed     = "session.hole_punch_failed" // a launch couldn't punch through the NAT
+	SessionPromoted     = "session.promoted"          // a secondary became primary
+	SessionDraining     = "session.draining"          // a replaced primary drains its streams
+	SessionUnhealthy    = "session.unhealthy"         // a session started missing health checks
+	SessionClosed       = "session.closed"            // a session's tunnel closed
+	TunnelDown          = "tunnel.down"               // the primary was lost with no other session up
+	TunnelRestored      = "tunnel.restored"           // a session took over after the tunnel was down
+	RotationFailing     = "rotation.failing"          // several rotations in a row failed
+	RelayFallback       = "relay.fallback"            // UDP looks blocked, so sessions go through the relay
+	BudgetWarning       = "budget.warning"            // most of the period's budget is used
+	BudgetExceeded      = "budget.exceeded"           // no sessions launch until the period ends
+	BudgetReset         = "budget.reset"              // a new period started after the budget ran out
+	RegionEvacuated     = "region.evacuated"          // a region's sessions drain and none launch
+	EvacuationEnded     = "region.evacuation_ended"   // an evacuated region launches sessions again
+	ConnectionOpened    = "connection.opened"         // a client asked for a destination
+	ConnectionClosed    = "connection.closed"         // a client connection ended
+	Warning             = "warning"                   // a warning was logged
+	Error               = "error"                     // an error was logged
+)
+
+// Bus limits
+const (
+	recentEvents    = 512 // events kept for subscribers resuming a stream
+	subscriberQueue = 256 // events a subscriber may fall behind by
+)
+
+// Event is one thing that happened in the proxy
+type Event struct {
+	ID      uint64                 `json:"id"` // increases by one per event
+	Time    time.Time              `json:"time"`
+	Type    string                 `json:"type"`
+	Session string                 `json:"session_id,omitempty"`
+	Message string                 `json:"message,omitempty"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// Bus hands each published event to every subscriber and keeps the most
+// recent ones, so a subscriber that disconnects can resume where it left
+// off. Publishing never blocks: a subscriber that falls too far behind is
+// dropped and must resume. A nil *Bus discards events.
+type Bus struct {
+	mu     sync.Mutex
+	lastID uint64
+	recent []Event // ring of the last recentEvents events
+	next   int     // where the next event goes in recent once it is full
+	subs   map[*Subscription]struct{}
+}
+
+// NewBus creates an event bus without subscribers
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Publish numbers and timestamps e and sends it to the subscribers
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastID++
+	e.ID = b.lastID
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	if len(b.recent) < recentEvents {
+		b.recent = append(b.recent, e)
+	} else {
+		b.recent[b.next] = e
+		b.next = (b.next + 1) % recentEvents
+	}
+
+	for sub := range b.subs {
+		select {
+		case sub.c <- e:
+		default:
+			b.drop(sub)
+		}
+	}
+}
+
+// Subscribe returns a subscription to the events published from now on
+func (b *Bus) Subscribe() *Subscription {
+	sub, _ := b.subscribe(0, false)
+	return sub
+}
+
+// Resume returns a subscription to the events published from now on and
+// the kept events numbered after lastID, oldest first. Events older than
+// the ones kept are lost.
+func (b *Bus) Resume(lastID uint64) (*Subscription, []Event) {
+	return b.subscribe(lastID, true)
+}
+
+func (b *Bus) subscribe(lastID uint64, replay bool) (*Subscription, []Event) {
+	c := make(chan Event, subscriberQueue)
+	sub := &Subscription{C: c, c: c, bus: b}
+	if b == nil {
+		close(c)
+		return sub, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[sub] = struct{}{}
+
+	var missed []Event
+	if replay {
+		for i := range b.recent {
+			e := b.recent[(b.next+i)%len(b.recent)]
+			if e.ID > lastID {
+				missed = append(missed, e)
+			}
+		}
+	}
+	return sub, missed
+}
+
+// Follow calls handle with each event published from now on, from a
+// goroutine, until ctx is done. If handle falls too far behind it resumes
+// after the last event it handled; events older than the kept ones are lost.
+func (b *Bus) Follow(ctx context.Context, handle func(Event)) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	lastID := b.lastID
+	b.mu.Unlock()
+	sub := b.Subscribe()
+	go func() {
+		for {
+			lastID = follow(ctx, sub, lastID, handle)
+			sub.Close()
+			if ctx.Err() != nil {
+				return
+			}
+			var missed []Event
+			sub, missed = b.Resume(lastID)
+			for _, e := range missed {
+				handle(e)
+				lastID = e.ID
+			}
+		}
+	}()
+}
+
+// follow hands sub's events to handle until ctx is done or sub is dropped,
+// returning the ID of the last one, or lastID if there was none
+func follow(ctx context.Context, sub *Subscription, lastID uint64, handle func(Event)) uint64 {
+	for {
+		select {
+		case <-ctx.Done():
+			return lastID
+		case e, ok := <-sub.C:
+			if !ok {
+				return lastID
+			}
+			handle(e)
+			lastID = e.ID
+		}
+	}
+}
+
+// drop closes sub's channel and forgets it. The caller must hold b.mu.
+func (b *Bus) drop(sub *Subscription) {
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.c)
+	}
+}
+
+// Subscription receives a bus's events on C, which is closed once the
+// subscription is closed or has fallen too far behind
+type Subscription struct {
+	C   <-chan Event
+	c   chan Event
+	bus *Bus
+}
+
+// Close ends the subscription
+func (s *Subscription) Close() {
+	if s.bus == nil {
+		return
+	}
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.drop(s)
+}
@@ -0,0 +1,149 @@
+package events
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestBusPublishAndResume(t *testing.T) {
+	bus := NewBus()
+	bus.Publish(Event{Type: SessionLaunched, Session: "a"})
+
+	sub := bus.Subscribe()
+	defer sub.Close()
+	bus.Publish(Event{Type: SessionClosed, Session: "a"})
+	if e := <-sub.C; e.ID != 2 || e.Type != SessionClosed || e.Time.IsZero() {
+		t.Fatalf("got %+v, want event 2 of type %s", e, SessionClosed)
+	}
+
+	resumed, missed := bus.Resume(1)
+	defer resumed.Close()
+	if len(missed) != 1 || missed[0].ID != 2 {
+		t.Fatalf("resuming after event 1 replayed %+v, want event 2", missed)
+	}
+
+	// Only the most recent events are kept, oldest first
+	for i := 0; i < recentEvents; i++ {
+		bus.Publish(Event{Type: ConnectionOpened})
+	}
+	_, missed = bus.Resume(0)
+	if len(missed) != recentEvents || missed[0].ID != 3 || missed[len(missed)-1].ID != recentEvents+2 {
+		t.Fatalf("replayed %d events from %d to %d, want %d from 3", len(missed), missed[0].ID, missed[len(missed)-1].ID, recentEvents)
+	}
+}
+
+func TestBusDropsSlowSubscriber(t *testing.T) {
+	bus := NewBus()
+	sub := bus.Subscribe()
+	for i := 0; i < subscriberQueue+1; i++ {
+		bus.Publish(Event{Type: SessionUnhealthy})
+	}
+
+	received := 0
+	for range sub.C {
+		received++
+	}
+	if received != subscriberQueue {
+		t.Fatalf("received %d events before the channel closed, want %d", received, subscriberQueue)
+	}
+	sub.Close() // closing a dropped subscription is harmless
+}
+
+func TestBusFollow(t *testing.T) {
+	bus := NewBus()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	handled := make(chan Event, 1)
+	bus.Follow(ctx, func(e Event) { handled <- e })
+	bus.Publish(Event{Type: SessionPromoted, Session: "b"})
+	if e := <-handled; e.Type != SessionPromoted || e.Session != "b" {
+		t.Fatalf("handled %+v, want the promotion of b", e)
+	}
+
+	// Once ctx is done the subscription ends
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for {
+		bus.mu.Lock()
+		subscribed := len(bus.subs)
+		bus.mu.Unlock()
+		if subscribed == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Follow kept its subscription after ctx was done")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBusFollowResumes(t *testing.T) {
+	bus := NewBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The handler is stuck until more events than the queue holds are published
+	release := make(chan struct{})
+	handled := make(chan uint64, subscriberQueue+10)
+	bus.Follow(ctx, func(e Event) {
+		<-release
+		handled <- e.ID
+	})
+	for i := 0; i < subscriberQueue+10; i++ {
+		bus.Publish(Event{Type: ConnectionOpened})
+	}
+	close(release)
+
+	// Dropped for falling behind, it resumes from the kept events
+	for want := uint64(1); want <= subscriberQueue+10; want++ {
+		select {
+		case id := <-handled:
+			if id != want {
+				t.Fatalf("handled event %d, want %d", id, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event %d was never handled", want)
+		}
+	}
+}
+
+func TestNilBus(t *testing.T) {
+	var bus *Bus
+	bus.Publish(Event{Type: SessionClosed})
+	sub := bus.Subscribe()
+	if _, ok := <-sub.C; ok {
+		t.Fatal("a nil bus's subscription should be closed")
+	}
+	sub.Close()
+	bus.Follow(context.Background(), func(Event) { t.Fatal("a nil bus has no events") })
+}
+
+func TestLogHandler(t *testing.T) {
+	var h LogHandler
+	if h.Enabled(context.Background(), slog.LevelError) {
+		t.Fatal("handler without a bus should be disabled")
+	}
+
+	bus := NewBus()
+	h.Attach(bus)
+	sub := bus.Subscribe()
+	defer sub.Close()
+	logger := slog.New(&h)
+	logger.Info("not published")
+	logger.Error("❌ Failed to dial: refused", slog.String("formatted_message", "Failed to dial: refused"), slog.String("operation", "dial"))
+
+	e := <-sub.C
+	if e.Type != Error || e.Message != "Failed to dial: refused" {
+		t.Fatalf("got %+v, want the error without its prefix", e)
+	}
+	if len(e.Fields) != 1 || e.Fields["operation"] != "dial" {
+		t.Fatalf("fields = %v, want only operation", e.Fields)
+	}
+	select {
+	case e := <-sub.C:
+		t.Fatalf("unexpected event %+v", e)
+	default:
+	}
+}
@@ -0,0 +1,58 @@
+package events
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+)
+
+// LogHandler is a slog.Handler that publishes warnings and errors to a bus.
+// It is installed in the logger once, before the bus exists, and ignores
+// records until Attach gives it one.
+type LogHandler struct {
+	bus atomic.Pointer[Bus]
+}
+
+// Attach publishes logged warnings and errors to bus from now on (nil = stop)
+func (h *LogHandler) Attach(bus *Bus) {
+	h.bus.Store(bus)
+}
+
+func (h *LogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slog.LevelWarn && h.bus.Load() != nil
+}
+
+func (h *LogHandler) Handle(_ context.Context, r slog.Record) error {
+	bus := h.bus.Load()
+	if bus == nil {
+		return nil
+	}
+	e := Event{
+		Time:    r.Time.UTC(),
+		Type:    Warning,
+		Message: strings.TrimSpace(strings.TrimPrefix(r.Message, "❌")),
+	}
+	if r.Level >= slog.LevelError {
+		e.Type = Error
+	}
+	r.Attrs(func(attr slog.Attr) bool {
+		// The message already holds these
+		if attr.Key == "formatted_message" || attr.Key == "timestamp" {
+			return true
+		}
+		if e.Fields == nil {
+			e.Fields = make(map[string]interface{})
+		}
+		e.Fields[attr.Key] = attr.Value.Resolve().Any()
+		return true
+	})
+	bus.Publish(e)
+	return nil
+}
+
+// WithAttrs drops the logger's fixed attributes, such as the service name,
+// which say nothing about the event
+func (h *LogHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *LogHandler) WithGroup(string) slog.Handler { return h }
@@ -0,0 +1,121 @@
+// Package features holds runtime flags that switch experimental subsystems
+// on and off without a redeploy. A flag only gates a subsystem the config
+// already enabled: turning one off stops new tunnels from using it, and
+// turning it back on restores what the config asked for.
+package features
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// Flag names a subsystem that can be switched at runtime
+type Flag string
+
+const (
+	// Datagrams relays SOCKS5 UDP over QUIC datagrams on sessions that negotiated them
+	Datagrams Flag = "datagrams"
+	// Compression compresses the payload of new tunnel streams, per proxy.compression
+	Compression Flag = "compression"
+	// Striping spreads new tunnel streams across a session's extra QUIC connections
+	Striping Flag = "striping"
+)
+
+// descriptions lists every known flag. All are enabled by default.
+var descriptions = map[Flag]string{
+	Datagrams:   "relay SOCKS5 UDP over QUIC datagrams",
+	Compression: "compress tunnel payloads",
+	Striping:    "spread tunnel streams across a session's QUIC connections",
+}
+
+// disabled holds the flags that are switched off. The map is never written
+// after init, so only the values need to be atomic.
+var disabled = func() map[Flag]*atomic.Bool {
+	flags := make(map[Flag]*atomic.Bool, len(descriptions))
+	for flag := range descriptions {
+		flags[flag] = new(atomic.Bool)
+	}
+	return flags
+}()
+
+// State is a flag and whether it is enabled
+type State struct {
+	Flag        Flag   `json:"flag"`
+	Enabled     bool   `json:"enabled"`
+	Description string `json:"description"`
+}
+
+// Parse returns the flag called name
+func Parse(name string) (Flag, error) {
+	flag := Flag(strings.ToLower(strings.TrimSpace(name)))
+	if _, ok := descriptions[flag]; !ok {
+		return "", fmt.Errorf("unknown feature flag %q (known: %s)", name, strings.Join(names(), ", "))
+	}
+	return flag, nil
+}
+
+// Enabled reports whether flag is switched on. Unknown flags are off.
+func Enabled(flag Flag) bool {
+	off, ok := disabled[flag]
+	return ok && !off.Load()
+}
+
+// Set switches flag on or off. by says who asked, for the log.
+func Set(flag Flag, enabled bool, by string) error {
+	off, ok := disabled[flag]
+	if !ok {
+		return fmt.Errorf("unknown feature flag %q", flag)
+	}
+	if off.Swap(!enabled) == !enabled {
+		return nil
+	}
+	state := "enabled"
+	if !enabled {
+		state = "disabled"
+	}
+	shared.LogInfof("Feature %s %s by %s", flag, state, by)
+	return nil
+}
+
+// Apply sets every flag from configured, enabling those it leaves out
+func Apply(configured map[string]bool, by string) error {
+	values := make(map[Flag]bool, len(disabled))
+	for flag := range disabled {
+		values[flag] = true
+	}
+	for name, enabled := range configured {
+		flag, err := Parse(name)
+		if err != nil {
+			return err
+		}
+		values[flag] = enabled
+	}
+	for flag, enabled := range values {
+		Set(flag, enabled, by)
+	}
+	return nil
+}
+
+// States returns every flag, sorted by name
+func States() []State {
+	states := make([]State, 0, len(descriptions))
+	for _, name := range names() {
+		flag := Flag(name)
+		states = append(states, State{Flag: flag, Enabled: Enabled(flag), Description: descriptions[flag]})
+	}
+	return states
+}
+
+// names returns the known flag names, sorted
+func names() []string {
+	list := make([]string, 0, len(descriptions))
+	for flag := range descriptions {
+		list = append(list, string(flag))
+	}
+	sort.Strings(list)
+	return list
+}
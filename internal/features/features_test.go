@@ -0,0 +1,43 @@
+package features
+
+import "testing"
+
+func TestSetAndApply(t *testing.T) {
+	defer Apply(nil, "the test")
+
+	if !Enabled(Compression) || !Enabled(Striping) || !Enabled(Datagrams) {
+		t.Fatal("Expected every flag to start enabled")
+	}
+	if Enabled("warp_drive") {
+		t.Error("Expected an unknown flag to be disabled")
+	}
+	if err := Set(Striping, false, "the test"); err != nil || Enabled(Striping) {
+		t.Fatalf("Expected striping disabled, err=%v", err)
+	}
+	if err := Set("warp_drive", true, "the test"); err == nil {
+		t.Error("Expected an error setting an unknown flag")
+	}
+
+	// Apply resets the flags it leaves out
+	if err := Apply(map[string]bool{"Compression": false}, "the test"); err != nil {
+		t.Fatal(err)
+	}
+	if Enabled(Compression) || !Enabled(Striping) {
+		t.Errorf("Expected only compression disabled, got %+v", States())
+	}
+
+	// An unknown name changes nothing
+	if err := Apply(map[string]bool{"compression": true, "warp_drive": true}, "the test"); err == nil {
+		t.Error("Expected an error for an unknown flag")
+	}
+	if Enabled(Compression) {
+		t.Error("Expected a failed Apply to leave the flags alone")
+	}
+}
+
+func TestStatesSorted(t *testing.T) {
+	states := States()
+	if len(states) != 3 || states[0].Flag != Compression || states[1].Flag != Datagrams || states[2].Flag != Striping {
+		t.Errorf("Expected the flags sorted by name, got %+v", states)
+	}
+}
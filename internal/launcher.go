@@ -2,12 +2,20 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"net"
+	"sync/atomic"
 	"time"
 
+	quicgo "github.com/quic-go/quic-go"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/anomaly"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/events"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/manager"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/metrics"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/nat"
@@ -24,8 +32,31 @@ type Launcher struct {
 	s3Coord      s3.Coordinator
 	natTraversal nat.Traversal
 	quicServer   *quic.Server
+	anomalies    *anomaly.Detector
+	launches     *manager.LaunchHistory
+	invoker      s3.Invoker
+	events       *events.Bus
+	state        *manager.SessionState
+	keepalive    *nat.Keepalive
+	
+	// idCheckFailed is set once a session ID uniqueness check has failed and been logged
+	idCheckFailed atomic.Bool
+	
+	// cleanupFailed is set once deleting a session's S3 objects has failed and been logged
+	cleanupFailed atomic.Bool
+	
+	// udpFailedAt is when a launch last found UDP blocked (Unix nanoseconds,
+	// 0 = never, or UDP worked again since)
+	udpFailedAt atomic.Int64
 }
 
+// errUDPUnavailable marks launch failures suggesting the network blocks UDP
+var errUDPUnavailable = errors.New("UDP may be blocked")
+
+// relayRetryInterval is how long launches in relay fallback mode go through
+// the relay after one found UDP blocked, before trying UDP again
+const relayRetryInterval = 10 * time.Minute
+
 // NewLauncher creates a new Launcher instance
 func NewLauncher(cfg *config.Config, stunClient stun.Client, s3Coord s3.Coordinator, natTraversal nat.Traversal, quicServer *quic.Server) *Launcher {
 	return &Launcher{
@@ -34,95 +65,381 @@ func NewLauncher(cfg *config.Config, stunClient stun.Client, s3Coord s3.Coordina
 		s3Coord:      s3Coord,
 		natTraversal: natTraversal,
 		quicServer:   quicServer,
+		keepalive:    nat.NewKeepalive(cfg.KeepaliveInterval, cfg.KeepaliveMinInterval),
 	}
 }
 
-// Launch creates a new session by performing the NAT traversal workflow
+// SetAnomalyDetector feeds every session's health checks to detector
+func (l *Launcher) SetAnomalyDetector(detector *anomaly.Detector) {
+	l.anomalies = detector
+}
+
+// SetLaunchHistory records the phase timings of every launch in history
+func (l *Launcher) SetLaunchHistory(history *manager.LaunchHistory) {
+	l.launches = history
+}
+
+// SetEventBus publishes failed hole punches and sessions that start missing
+// health checks to bus
+func (l *Launcher) SetEventBus(bus *events.Bus) {
+	l.events = bus
+}
+
+// publish sends an event about sessionID to the event bus, if one is set
+func (l *Launcher) publish(eventType, sessionID, message string) {
+	l.events.Publish(events.Event{
+		Type:    eventType,
+		Session: sessionID,
+		Message: message,
+		Fields:  map[string]interface{}{"region": l.config.AWSRegion},
+	})
+}
+
+// SetInvoker lets launches invoke the Lambda directly when its S3 notification
+// is later than the configured fallback
+func (l *Launcher) SetInvoker(invoker s3.Invoker) {
+	l.invoker = invoker
+}
+
+// SetSessionState records every session in state from its launch until its
+// tunnel closes, so a proxy started after a crash can stop its Lambda
+func (l *Launcher) SetSessionState(state *manager.SessionState) {
+	l.state = state
+}
+
+// sessionIDAttempts bounds how many session IDs a launch tries before
+// giving up on finding an unused one
+const sessionIDAttempts = 3
+
+// newSessionID generates a session ID that, where the coordinator can tell,
+// no earlier session used. A failed check is logged once and the ID used
+// anyway, since its random bits make a clash unlikely.
+func (l *Launcher) newSessionID(ctx context.Context) (string, error) {
+	checker, canCheck := l.s3Coord.(s3.SessionIDChecker)
+	for attempt := 1; ; attempt++ {
+		sessionID := shared.GenerateSessionID()
+		if !canCheck {
+			return sessionID, nil
+		}
+		inUse, err := checker.SessionIDInUse(ctx, sessionID)
+		if err != nil {
+			if l.idCheckFailed.CompareAndSwap(false, true) {
+				log.Printf("⚠️  Launcher: Can't check that session IDs are unused, later failures are not logged: %v", err)
+			}
+			return sessionID, nil
+		}
+		if !inUse {
+			return sessionID, nil
+		}
+		log.Printf("⚠️  Launcher: Session ID %s is already in use, generating another", sessionID)
+		if attempt == sessionIDAttempts {
+			return "", fmt.Errorf("failed to generate an unused session ID in %d attempts", attempt)
+		}
+	}
+}
+
+// sessionCleanupTimeout bounds deleting a finished session's S3 objects
+const sessionCleanupTimeout = 10 * time.Second
+
+// deleteSessionObjects deletes a finished session's coordination and
+// response objects, if the coordinator keeps them in S3. Only the first
+// failure is logged; the startup cleanup and the bucket's lifecycle rules
+// remove what is left.
+func (l *Launcher) deleteSessionObjects(sessionID string) {
+	cleaner, ok := l.s3Coord.(s3.SessionCleaner)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sessionCleanupTimeout)
+	defer cancel()
+	if err := cleaner.DeleteSession(ctx, sessionID); err != nil && l.cleanupFailed.CompareAndSwap(false, true) {
+		log.Printf("⚠️  Launcher: Can't delete finished sessions' S3 objects, later failures are not logged: %v", err)
+	}
+}
+
+// Launch creates a new session by performing the NAT traversal workflow.
+// With a relay configured, the session goes through it instead in
+// shared.RelayModeAlways, or in fallback mode when the launch finds UDP
+// blocked; launches then keep using the relay for relayRetryInterval. Falling
+// back is published as an events.RelayFallback until UDP works again.
 func (l *Launcher) Launch(ctx context.Context) (*manager.Session, error) {
-	log.Println("Launcher: Starting new session launch")
+	if l.relayPreferred() {
+		return l.launch(ctx, true)
+	}
+	session, err := l.launch(ctx, false)
+	if err == nil {
+		l.udpFailedAt.Store(0)
+	}
+	if err == nil || l.config.Relay.URL == "" || !errors.Is(err, errUDPUnavailable) || ctx.Err() != nil {
+		return session, err
+	}
+	if l.udpFailedAt.Swap(time.Now().UnixNano()) == 0 {
+		l.publish(events.RelayFallback, "", fmt.Sprintf("%v; sessions go through the relay at %s", err, l.config.Relay.URL))
+	}
+	log.Printf("⚠️  Launcher: %v; retrying through the relay at %s", err, l.config.Relay.URL)
+	return l.launch(ctx, true)
+}
+
+// relayPreferred reports whether the next launch should go straight to the relay
+func (l *Launcher) relayPreferred() bool {
+	if l.config.Relay.URL == "" {
+		return false
+	}
+	if l.config.RelayMode == shared.RelayModeAlways {
+		return true
+	}
+	failedAt := l.udpFailedAt.Load()
+	return failedAt != 0 && time.Since(time.Unix(0, failedAt)) < relayRetryInterval
+}
+
+// launch runs one launch, over a punched UDP path or, if relayed, through the relay
+func (l *Launcher) launch(ctx context.Context, relayed bool) (session *manager.Session, err error) {
+	if relayed {
+		log.Println("Launcher: Starting new session launch through the relay")
+	} else {
+		log.Println("Launcher: Starting new session launch")
+	}
 	
-	// 1. Discover public IP via STUN
-	stunStart := time.Now()
-	publicIP, err := l.stunClient.DiscoverPublicIP(ctx, l.config.STUNServer)
-	stunLatency := time.Since(stunStart)
-	metrics.RecordSTUNLatency(stunLatency)
+	ctx, span := shared.StartSpan(ctx, "session.launch")
+	timer := l.launches.Start()
+	coordinated := "" // the session whose coordination was written
+	defer func() {
+		span.RecordError(err)
+		span.End()
+		timer.Finish(err)
+		
+		// Nothing waits for a failed launch's objects
+		if err != nil && coordinated != "" {
+			go l.deleteSessionObjects(coordinated)
+		}
+	}()
 	
-	if err != nil {
-		return nil, fmt.Errorf("failed to discover public IP: %w", err)
+	// 1. Discover public IP via STUN and create the UDP socket for hole
+	// punching; a relayed session's coordination carries no endpoint
+	var publicIP string
+	var udpConn *net.UDPConn
+	var localPort int
+	closeUDP := func() {
+		if udpConn != nil {
+			udpConn.Close()
+		}
+	}
+	if !relayed {
+		stunStart := time.Now()
+		endPhase := timer.Phase(manager.LaunchPhaseSTUN)
+		_, stunSpan := shared.StartSpan(ctx, "stun.discover", shared.Attr("stun.server", l.config.STUNServer))
+		publicIP, err = l.stunClient.DiscoverPublicIP(ctx, l.config.STUNServer)
+		endPhase()
+		stunLatency := time.Since(stunStart)
+		metrics.RecordSTUNLatency(stunLatency)
+		stunSpan.RecordError(err)
+		stunSpan.End()
+		
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover public IP: %w (%w)", err, errUDPUnavailable)
+		}
+		log.Printf("Launcher: Public IP: %s", publicIP)
+		
+		// 2. Create UDP socket for hole punching
+		udpConn, localPort, err = l.natTraversal.CreateUDPSocket()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create UDP socket: %w", err)
+		}
+		// Note: udpConn ownership will be transferred to QUIC server
 	}
-	log.Printf("Launcher: Public IP: %s", publicIP)
 	
-	// 2. Create UDP socket for hole punching
-	udpConn, localPort, err := l.natTraversal.CreateUDPSocket()
+	// 3. Send coordination through S3 or the function URL (starts the Lambda)
+	endPhase := timer.Phase(manager.LaunchPhaseS3Put)
+	sessionID, err := l.newSessionID(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create UDP socket: %w", err)
+		endPhase()
+		closeUDP()
+		return nil, err
 	}
-	// Note: udpConn ownership will be transferred to QUIC server
-	
-	// 3. Write coordination to S3 (triggers Lambda)
-	sessionID := shared.GenerateSessionID()
-	if err := l.s3Coord.WriteCoordination(ctx, sessionID, publicIP, localPort); err != nil {
-		udpConn.Close()
-		return nil, fmt.Errorf("failed to write coordination to S3: %w", err)
+	span.SetAttributes(shared.Attr("session.id", sessionID))
+	timer.SetSession(sessionID)
+	launchedAt := time.Now()
+	record := manager.SessionRecord{
+		ID:         sessionID,
+		Region:     l.config.AWSRegion,
+		LaunchedAt: launchedAt,
+		ExpiresAt:  launchedAt.Add(time.Duration(l.config.ModeConfig.LambdaTimeout) * time.Second),
+	}
+	if stopper, ok := l.s3Coord.(s3.SessionStopper); ok {
+		record.IntegrityKey = stopper.SessionKey(sessionID)
+	}
+	l.state.Add(record)
+	_, s3Span := shared.StartSpan(ctx, "s3.write_coordination")
+	err = l.s3Coord.WriteCoordination(ctx, sessionID, publicIP, localPort)
+	endPhase()
+	s3Span.RecordError(err)
+	s3Span.End()
+	if err != nil {
+		closeUDP()
+		return nil, fmt.Errorf("failed to send coordination: %w", err)
 	}
 	log.Printf("Launcher: Coordination written for session: %s", sessionID)
+	coordinated = sessionID
 	
-	// 4. Wait for Lambda response
+	// 4. Wait for Lambda response, invoking it directly if the S3 notification is late
+	endPhase = timer.Phase(manager.LaunchPhaseLambdaWait)
+	_, waitSpan := shared.StartSpan(ctx, "lambda.wait_response")
+	stopFallback := l.scheduleInvokeFallback(ctx, sessionID)
 	lambdaResp, err := l.s3Coord.WaitForLambdaResponse(ctx, sessionID, l.config.LambdaResponseTimeout)
+	stopFallback()
+	endPhase()
+	waitSpan.RecordError(err)
+	waitSpan.End()
 	if err != nil {
-		udpConn.Close()
+		closeUDP()
 		return nil, fmt.Errorf("failed to get Lambda response: %w", err)
 	}
-	log.Printf("Launcher: Lambda endpoint: %s:%d", lambdaResp.LambdaPublicIP, lambdaResp.LambdaPublicPort)
-	
-	// 5. Perform NAT hole punching
-	lambdaAddr := &net.UDPAddr{
-		IP:   net.ParseIP(lambdaResp.LambdaPublicIP),
-		Port: lambdaResp.LambdaPublicPort,
+	log.Printf("Launcher: Lambda endpoint for session %s: %s:%d", sessionID, lambdaResp.LambdaPublicIP, lambdaResp.LambdaPublicPort)
+	if lambdaResp.Trigger != "" {
+		triggerLatency := time.Duration(lambdaResp.TriggerDelayMs) * time.Millisecond
+		metrics.RecordLambdaTrigger(lambdaResp.Trigger, triggerLatency)
+		timer.SetTrigger(lambdaResp.Trigger, triggerLatency)
+		log.Printf("Launcher: Lambda started by %s %v after the coordination was sent", lambdaResp.Trigger, triggerLatency)
 	}
 	
-	natStart := time.Now()
-	if err := l.natTraversal.PerformHolePunch(udpConn, sessionID, lambdaAddr, l.config.NATHolePunchTimeout); err != nil {
-		udpConn.Close()
-		return nil, fmt.Errorf("NAT hole punching failed: %w", err)
+	// 5. Perform NAT hole punching, or meet the Lambda at the relay
+	var relayConn net.Conn
+	if relayed {
+		_, relaySpan := shared.StartSpan(ctx, "relay.dial", shared.Attr("relay.url", l.config.Relay.URL))
+		endPhase = timer.Phase(manager.LaunchPhaseRelay)
+		relayCtx, cancel := context.WithTimeout(ctx, shared.RelayPairTimeout)
+		relayConn, err = shared.DialRelay(relayCtx, l.config.Relay, shared.RelayRoleOrchestrator, sessionID)
+		cancel()
+		endPhase()
+		relaySpan.RecordError(err)
+		relaySpan.End()
+		if err != nil {
+			return nil, fmt.Errorf("failed to meet the Lambda at the relay: %w", err)
+		}
+		log.Printf("Launcher: Session %s paired with the Lambda at the relay", sessionID)
+	} else {
+		lambdaAddr := &net.UDPAddr{
+			IP:   net.ParseIP(lambdaResp.LambdaPublicIP),
+			Port: lambdaResp.LambdaPublicPort,
+		}
+		
+		natStart := time.Now()
+		_, punchSpan := shared.StartSpan(ctx, "nat.hole_punch", shared.Attr("lambda.addr", lambdaAddr.String()))
+		endPhase = timer.Phase(manager.LaunchPhaseHolePunch)
+		err = l.natTraversal.PerformHolePunch(udpConn, sessionID, lambdaAddr, l.config.NATHolePunchTimeout)
+		endPhase()
+		punchSpan.RecordError(err)
+		punchSpan.End()
+		if err != nil {
+			udpConn.Close()
+			l.publish(events.HolePunchFailed, sessionID, err.Error())
+			return nil, fmt.Errorf("NAT hole punching failed: %w (%w)", err, errUDPUnavailable)
+		}
+		natTraversalTime := time.Since(natStart)
+		metrics.RecordNATTraversalTime(natTraversalTime)
+		log.Printf("Launcher: NAT hole punched successfully for session %s!", sessionID)
 	}
-	natTraversalTime := time.Since(natStart)
-	metrics.RecordNATTraversalTime(natTraversalTime)
-	log.Println("Launcher: NAT hole punched successfully!")
 	
 	// 6. Start QUIC server and wait for Lambda connection
 	quicStart := time.Now()
-	quicConn, err := l.quicServer.StartAndAccept(ctx, udpConn, l.config)
+	_, quicSpan := shared.StartSpan(ctx, "quic.handshake")
+	endPhase = timer.Phase(manager.LaunchPhaseQUICHandshake)
+	var listener *quicgo.Listener
+	if relayed {
+		listener, err = l.quicServer.ListenPacket(ctx, shared.NewRelayPacketConn(relayConn), l.config)
+	} else {
+		listener, err = l.quicServer.Listen(ctx, udpConn, l.config)
+	}
+	var quicConn quicgo.Connection
+	if err == nil {
+		quicConn, err = quic.Accept(ctx, listener)
+	}
+	endPhase()
+	quicSpan.RecordError(err)
+	quicSpan.End()
 	if err != nil {
 		metrics.RecordQUICConnectionError()
+		if relayConn != nil {
+			relayConn.Close()
+		}
 		return nil, fmt.Errorf("failed to start QUIC server: %w", err)
 	}
 	quicHandshakeTime := time.Since(quicStart)
 	metrics.RecordQUICHandshakeTime(quicHandshakeTime)
 	
-	log.Printf("Launcher: Session %s established with QUIC connection", sessionID)
+	// The Lambda exits when the tunnel closes, however the session ends
+	go func() {
+		<-quicConn.Context().Done()
+		if relayConn != nil {
+			relayConn.Close()
+		}
+		l.state.Remove(sessionID)
+		l.deleteSessionObjects(sessionID)
+	}()
+	
+	crypto := shared.DescribeTunnelCrypto(quicConn.ConnectionState())
+	log.Printf("Launcher: Session %s established with QUIC connection (%s)", sessionID, crypto)
+	for _, warning := range crypto.Warnings {
+		log.Printf("⚠️  Session %s: %s", sessionID, warning)
+	}
 	
 	// Open control stream (stream 0)
+	endPhase = timer.Phase(manager.LaunchPhaseControlStream)
 	controlStream, err := quicConn.OpenStreamSync(ctx)
+	endPhase()
 	if err != nil {
 		metrics.RecordQUICConnectionError()
 		quicConn.CloseWithError(0, "failed to open control stream")
 		return nil, fmt.Errorf("failed to open control stream: %w", err)
 	}
 	
+	// Agree on the wire protocol before any tunnel stream uses it
+	endPhase = timer.Phase(manager.LaunchPhaseHello)
+	hello, err := quic.Negotiate(controlStream, shared.HelloTimeout)
+	endPhase()
+	if err != nil {
+		quicConn.CloseWithError(0, "protocol version mismatch")
+		return nil, err
+	}
+	
+	// The Lambda opens its extra connections once it has the control stream
+	var stripes []quicgo.Connection
+	if n := l.config.QUIC.Stripes(); n > 0 {
+		if hello.Supports(shared.CapStripes) {
+			stripes = quic.AcceptStripes(ctx, listener, n, shared.QUICHandshakeTimeout)
+			log.Printf("Launcher: Session %s striping streams across %d QUIC connections", sessionID, len(stripes)+1)
+		} else {
+			log.Printf("Launcher: ⚠️  The Lambda doesn't support extra QUIC connections; run 'lambda-nat-proxy deploy' to update it")
+		}
+	}
+	
+	if l.config.LambdaLogs.Level != "" && !hello.Supports(shared.CapLogForwarding) {
+		log.Printf("Launcher: ⚠️  The Lambda can't forward its logs; run 'lambda-nat-proxy deploy' to update it")
+	}
+	
 	// Record QUIC stream creation
 	metrics.IncrementActiveQUICStreams()
 	
 	// Create the session
-	session := &manager.Session{
+	session = &manager.Session{
 		ID:            sessionID,
 		QuicConn:      quicConn,
 		StartedAt:     time.Now(),
 		ControlStream: controlStream,
+		Protocol:      hello,
+		Crypto:        crypto,
 		TTL:           l.config.Rotation.SessionTTL,
 		LambdaPublicIP: lambdaResp.LambdaPublicIP,
+		Relayed:       relayed,
+		Stripes:       stripes,
+	}
+	if relayed {
+		metrics.RecordRelaySession()
 	}
 	session.SetHealthy(true) // Start as healthy
+	if hello.Supports(shared.CapByteCounts) {
+		session.Bytes = manager.NewByteReconciler()
+	}
 	
 	// Start health check loop
 	go l.startHealthCheck(ctx, session)
@@ -130,6 +447,30 @@ func (l *Launcher) Launch(ctx context.Context) (*manager.Session, error) {
 	return session, nil
 }
 
+// scheduleInvokeFallback invokes the Lambda for sessionID unless it answers
+// within the configured fallback. Call the returned function once it has.
+// Sessions on a mode alias are invoked at once, since the S3 notification
+// only reaches the function, which leaves them to the alias.
+func (l *Launcher) scheduleInvokeFallback(ctx context.Context, sessionID string) func() {
+	if l.invoker != nil && l.config.SessionAlias != "" {
+		if err := l.invoker.Invoke(ctx, sessionID); err != nil {
+			log.Printf("Launcher: ⚠️  Failed to invoke the %s alias: %v", l.config.SessionAlias, err)
+		}
+		return func() {}
+	}
+	if l.invoker == nil || l.config.InvokeFallback <= 0 {
+		return func() {}
+	}
+	fallback := time.AfterFunc(l.config.InvokeFallback, func() {
+		log.Printf("Launcher: ⚠️  No Lambda response %v after the S3 write, invoking the Lambda directly", l.config.InvokeFallback)
+		metrics.RecordLambdaInvokeFallback()
+		if err := l.invoker.Invoke(ctx, sessionID); err != nil {
+			log.Printf("Launcher: ⚠️  Direct invoke failed, still waiting for the S3 notification: %v", err)
+		}
+	})
+	return func() { fallback.Stop() }
+}
+
 // startHealthCheck runs the health check loop for a session
 func (l *Launcher) startHealthCheck(ctx context.Context, session *manager.Session) {
 	defer func() {
@@ -138,12 +479,33 @@ func (l *Launcher) startHealthCheck(ctx context.Context, session *manager.Sessio
 			session.SetHealthy(false)
 		}
 		shared.LogInfof("Health check for session %s stopped", session.ID)
+		l.anomalies.Forget(session.ID)
+		metrics.ForgetSession(session.ID)
 	}()
 	
-	ticker := time.NewTicker(10 * time.Second)
+	// Pings double as keepalive traffic for the session's NAT mapping
+	interval := l.keepalive.Interval()
+	metrics.SetKeepaliveInterval(interval)
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
+	adapt := func(next time.Duration, reason string) {
+		if next == interval {
+			return
+		}
+		if reason != "" {
+			shared.LogInfof("Keepalive interval now %v (was %v) %s", next, interval, reason)
+		}
+		interval = next
+		metrics.SetKeepaliveInterval(interval)
+		ticker.Reset(interval)
+	}
 	defer session.ControlStream.Close()
 	
+	readCtx, stopReading := context.WithCancel(ctx)
+	defer stopReading()
+	messages := make(chan controlMessage)
+	go readControl(readCtx, session, messages)
+	
 	var nonce uint64
 	
 	for {
@@ -157,6 +519,9 @@ func (l *Launcher) startHealthCheck(ctx context.Context, session *manager.Sessio
 		case <-ticker.C:
 			nonce++
 			
+			// Another session may have changed the interval since the last ping
+			adapt(l.keepalive.Interval(), "")
+			
 			// Record ping start time for RTT calculation
 			pingStart := time.Now()
 			
@@ -170,21 +535,18 @@ func (l *Launcher) startHealthCheck(ctx context.Context, session *manager.Sessio
 			
 			// Send ping
 			metrics.RecordPingSent()
-			if err := shared.WritePing(session.ControlStream, nonce); err != nil {
+			err := session.WriteControl(func(w io.Writer) error {
+				return shared.WritePing(w, nonce)
+			})
+			if err != nil {
 				shared.LogErrorf("Failed to send ping to session %s: %v", session.ID, err)
 				session.SetHealthy(false)
 				metrics.SetSessionHealthy(false)
 				return
 			}
 			
-			// Set read deadline for pong with shorter timeout to be more responsive
-			session.ControlStream.SetReadDeadline(time.Now().Add(3 * time.Second))
-			
-			// Read response with context check
-			opcode, receivedNonce, err := shared.ReadControlMessage(session.ControlStream)
-			
-			// Always clear read deadline first
-			session.ControlStream.SetReadDeadline(time.Time{})
+			// Wait for the pong with a short timeout to be more responsive
+			msg, err := nextControlMessage(ctx, messages)
 			
 			// Check context again after read
 			select {
@@ -197,7 +559,16 @@ func (l *Launcher) startHealthCheck(ctx context.Context, session *manager.Sessio
 			if err != nil {
 				missedCount := session.IncrementMissedPings()
 				metrics.RecordMissedPing()
+				l.anomalies.ObserveMissedPing(session.ID)
 				shared.LogErrorf("Failed to receive pong from session %s (missed: %d): %v", session.ID, missedCount, err)
+				if missedCount == 1 {
+					l.publish(events.SessionUnhealthy, session.ID, fmt.Sprintf("missed a health check: %v", err))
+				}
+				
+				// The NAT may have dropped the idle mapping; a relayed path has none
+				if !session.Relayed {
+					adapt(l.keepalive.Missed(), "after a missed ping")
+				}
 				
 				if missedCount >= 3 {
 					shared.LogErrorf("Session %s marked unhealthy after 3 missed pings", session.ID)
@@ -208,17 +579,30 @@ func (l *Launcher) startHealthCheck(ctx context.Context, session *manager.Sessio
 				continue
 			}
 			
-			if opcode == shared.OpPong && receivedNonce == nonce {
+			if msg.opcode == shared.OpPong && msg.nonce == nonce {
 				// Calculate and record RTT
 				rtt := time.Since(pingStart)
-				metrics.RecordRTT(rtt)
+				metrics.RecordRTT(session.ID, session.Role, rtt)
+				l.anomalies.ObserveRTT(session.ID, rtt)
 				
 				session.ResetMissedPings()
 				session.SetHealthy(true)
 				metrics.SetSessionHealthy(true)
+				if !session.Relayed {
+					adapt(l.keepalive.Answered(), "after answered pings")
+				}
 				
 				shared.LogInfof("Session %s health check: RTT %v", session.ID, rtt)
-			} else if opcode == shared.OpShutdown {
+				
+				if session.Bytes != nil && nonce%byteReportInterval == 0 {
+					if err := l.reconcileBytes(ctx, session, messages); err != nil {
+						shared.LogErrorf("Failed to reconcile byte counts with session %s: %v", session.ID, err)
+					}
+				}
+			} else if msg.opcode == shared.OpByteCounts && session.Bytes != nil {
+				// The answer to a byte report that timed out
+				recordReconciliation(session, session.Bytes.Reconcile(msg.counts))
+			} else if msg.opcode == shared.OpShutdown {
 				// Handle shutdown signal gracefully during health check
 				shared.LogInfof("Session %s received shutdown signal during health check", session.ID)
 				session.SetHealthy(false)
@@ -226,8 +610,115 @@ func (l *Launcher) startHealthCheck(ctx context.Context, session *manager.Sessio
 				return
 			} else {
 				shared.LogErrorf("Unexpected control message from session %s: opcode=%02x, nonce=%d (expected %d)", 
-					session.ID, opcode, receivedNonce, nonce)
+					session.ID, msg.opcode, msg.nonce, nonce)
+			}
+		}
+	}
+}
+
+// controlTimeout bounds the wait for the Lambda's answer to a control message
+const controlTimeout = 3 * time.Second
+
+// controlMessage is a message read from a session's control stream
+type controlMessage struct {
+	opcode byte
+	nonce  uint64
+	counts []shared.StreamBytes // for OpByteCounts
+	err    error
+}
+
+// readControl reads the session's control stream until it fails or ctx is
+// done. Log lines the Lambda forwards are logged, and its expiring notice
+// recorded, here as they arrive; every other message goes to messages.
+func readControl(ctx context.Context, session *manager.Session, messages chan<- controlMessage) {
+	for {
+		var msg controlMessage
+		msg.opcode, msg.nonce, msg.err = shared.ReadControlMessage(session.ControlStream)
+		switch {
+		case msg.err != nil:
+		case msg.opcode == shared.OpLog:
+			level, line, err := shared.ReadLogLine(session.ControlStream)
+			if err != nil {
+				msg.err = err
+				break
 			}
+			// Forwarded debug lines show at info, since the Lambda only sends
+			// the levels that were asked for
+			shared.LogWithContext(ctx, max(level, slog.LevelInfo), fmt.Sprintf("Lambda %s: %s", session.ID, line))
+			continue
+		case msg.opcode == shared.OpExpiring:
+			remaining, err := shared.ReadExpiring(session.ControlStream)
+			if err != nil {
+				msg.err = err
+				break
+			}
+			// The manager moves traffic off the session on its next check
+			if session.MarkExpiring() {
+				metrics.RecordSessionExpiring()
+				shared.LogInfof("Session %s: Lambda times out in %v and takes no new streams", session.ID, remaining.Round(time.Second))
+			}
+			continue
+		case msg.opcode == shared.OpByteCounts:
+			msg.counts, msg.err = shared.ReadByteCounts(session.ControlStream)
+		}
+		
+		select {
+		case messages <- msg:
+		case <-ctx.Done():
+			return
+		}
+		if msg.err != nil {
+			return
 		}
 	}
-}
\ No newline at end of file
+}
+
+// nextControlMessage waits up to controlTimeout for the next message
+// readControl hands on
+func nextControlMessage(ctx context.Context, messages <-chan controlMessage) (controlMessage, error) {
+	timer := time.NewTimer(controlTimeout)
+	defer timer.Stop()
+	select {
+	case msg := <-messages:
+		return msg, msg.err
+	case <-timer.C:
+		return controlMessage{}, fmt.Errorf("no control message within %v", controlTimeout)
+	case <-ctx.Done():
+		return controlMessage{}, ctx.Err()
+	}
+}
+
+// byteReportInterval is how many health checks pass between asking the
+// Lambda for its byte counts
+const byteReportInterval = 3
+
+// reconcileBytes asks the Lambda for the byte counts of its finished
+// streams and reconciles them with the session's own
+func (l *Launcher) reconcileBytes(ctx context.Context, session *manager.Session, messages <-chan controlMessage) error {
+	err := session.WriteControl(func(w io.Writer) error {
+		return shared.WriteByteReport(w)
+	})
+	if err != nil {
+		return err
+	}
+	
+	msg, err := nextControlMessage(ctx, messages)
+	if err != nil {
+		return err
+	}
+	if msg.opcode != shared.OpByteCounts {
+		return fmt.Errorf("unexpected control message %02x waiting for byte counts", msg.opcode)
+	}
+	recordReconciliation(session, session.Bytes.Reconcile(msg.counts))
+	return nil
+}
+
+// recordReconciliation logs and records what reconciling a report settled
+func recordReconciliation(session *manager.Session, result manager.Reconciliation) {
+	for _, m := range result.Mismatches {
+		shared.LogErrorf("Byte count mismatch on session %s stream %d (%s): sent %d, received %d",
+			session.ID, m.StreamID, m.Direction, m.Sent, m.Received)
+		metrics.RecordStreamByteMismatch(m.Direction, int64(m.Sent)-int64(m.Received))
+	}
+	metrics.RecordStreamBytesReconciled(result.Matched, result.Unmatched)
+}
@@ -0,0 +1,140 @@
+// Package leakcheck periodically compares counters that should agree with
+// each other (open SOCKS5 connections, the dashboard's tracked connections,
+// tunnel streams open on sessions and the number of goroutines) and reports
+// when they drift apart for long enough to suggest a copy or close path left
+// something behind.
+package leakcheck
+
+import (
+	"context"
+	"runtime"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/metrics"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// Suspected leak kinds
+const (
+	KindStreams    = "streams"    // more tunnel streams open than SOCKS5 connections
+	KindTracked    = "tracked"    // tracked connections with no SOCKS5 connection
+	KindGoroutines = "goroutines" // more goroutines than connections and sessions account for
+)
+
+// Checker tuning
+const (
+	// DefaultInterval is how often Run takes a sample
+	DefaultInterval = 30 * time.Second
+	// sustain is how many consecutive samples must disagree before a leak is
+	// suspected, so connections opening or closing mid-sample don't count
+	sustain = 3
+	// goroutinesPerConnection and goroutinesPerSession are generous upper
+	// bounds on what one SOCKS5 connection or one session runs
+	goroutinesPerConnection = 8
+	goroutinesPerSession    = 64
+	// goroutineSlack absorbs timers, HTTP servers and other background work
+	goroutineSlack = 100
+)
+
+// Sample is one reading of the counters
+type Sample struct {
+	Connections int // open SOCKS5 connections
+	Tracked     int // connections in the dashboard tracker
+	Streams     int // tunnel streams open across all sessions
+	Sessions    int
+	Goroutines  int
+}
+
+// Checker samples the counters and flags kinds that stay inconsistent
+type Checker struct {
+	sample   func() Sample
+	interval time.Duration
+
+	// baseline is the fewest goroutines seen while idle, less what the
+	// sessions of the time account for; valid once haveBaseline is set
+	baseline     int
+	haveBaseline bool
+
+	over      map[string]int // consecutive disagreeing samples per kind
+	suspected map[string]bool
+}
+
+// New returns a checker that reads the counters with sample. Goroutines is
+// filled in from the runtime when sample leaves it zero.
+func New(sample func() Sample) *Checker {
+	return &Checker{
+		sample:    sample,
+		interval:  DefaultInterval,
+		over:      make(map[string]int),
+		suspected: make(map[string]bool),
+	}
+}
+
+// Run checks every interval until ctx is done. A nil *Checker does nothing.
+func (c *Checker) Run(ctx context.Context) {
+	if c == nil {
+		return
+	}
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.Check()
+		}
+	}
+}
+
+// Check takes one sample, logs and records leaks that start or clear, and
+// returns the kinds currently suspected
+func (c *Checker) Check() []string {
+	s := c.sample()
+	if s.Goroutines == 0 {
+		s.Goroutines = runtime.NumGoroutine()
+	}
+
+	idle := s.Connections == 0 && s.Streams == 0
+	if base := s.Goroutines - s.Sessions*goroutinesPerSession; idle && (!c.haveBaseline || base < c.baseline) {
+		c.baseline, c.haveBaseline = base, true
+	}
+	expected := c.baseline + s.Sessions*goroutinesPerSession + s.Connections*goroutinesPerConnection + goroutineSlack
+
+	c.observe(KindStreams, s.Streams > s.Connections,
+		"%d tunnel streams open but only %d SOCKS5 connections", s.Streams, s.Connections)
+	c.observe(KindTracked, s.Tracked > s.Connections,
+		"%d tracked connections but only %d SOCKS5 connections", s.Tracked, s.Connections)
+	c.observe(KindGoroutines, c.haveBaseline && s.Goroutines > expected,
+		"%d goroutines, expected at most %d for %d connections and %d sessions", s.Goroutines, expected, s.Connections, s.Sessions)
+
+	var suspected []string
+	for _, kind := range []string{KindStreams, KindTracked, KindGoroutines} {
+		if c.suspected[kind] {
+			suspected = append(suspected, kind)
+		}
+	}
+	return suspected
+}
+
+// observe updates kind with whether this sample disagrees
+func (c *Checker) observe(kind string, disagrees bool, format string, args ...interface{}) {
+	if !disagrees {
+		c.over[kind] = 0
+		if c.suspected[kind] {
+			c.suspected[kind] = false
+			metrics.RecordLeakSuspected(kind, false)
+			shared.LogSuccessf("Leak check: %s consistent again", kind)
+		}
+		return
+	}
+
+	c.over[kind]++
+	if c.over[kind] == sustain && !c.suspected[kind] {
+		c.suspected[kind] = true
+		metrics.RecordLeakSuspected(kind, true)
+		args = append(args, sustain)
+		shared.LogErrorf("Leak check: suspected %s leak: "+format+" for %d samples in a row", append([]interface{}{kind}, args...)...)
+	}
+}
@@ -0,0 +1,61 @@
+package leakcheck
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckStreams(t *testing.T) {
+	sample := Sample{Connections: 2, Tracked: 2, Streams: 2, Sessions: 1, Goroutines: 50}
+	c := New(func() Sample { return sample })
+
+	if got := c.Check(); len(got) != 0 {
+		t.Fatalf("Expected consistent counters, got %v", got)
+	}
+
+	// A stream left open after its connection closed
+	sample.Connections, sample.Tracked = 1, 1
+	for i := 1; i < sustain; i++ {
+		if got := c.Check(); len(got) != 0 {
+			t.Fatalf("Expected no suspicion after %d samples, got %v", i, got)
+		}
+	}
+	if got := c.Check(); strings.Join(got, ",") != KindStreams {
+		t.Fatalf("Expected a suspected stream leak, got %v", got)
+	}
+
+	sample.Streams = 1
+	if got := c.Check(); len(got) != 0 {
+		t.Errorf("Expected the suspicion to clear, got %v", got)
+	}
+}
+
+func TestCheckTrackedAndGoroutines(t *testing.T) {
+	sample := Sample{Sessions: 1, Goroutines: 100}
+	c := New(func() Sample { return sample })
+	c.Check() // idle: the baseline is 100 less one session's allowance
+
+	// Goroutines within the allowance for the connections
+	sample = Sample{Connections: 10, Tracked: 10, Streams: 10, Sessions: 1, Goroutines: 100 + 10*goroutinesPerConnection}
+	for i := 0; i < sustain; i++ {
+		if got := c.Check(); len(got) != 0 {
+			t.Fatalf("Expected goroutines within the allowance, got %v", got)
+		}
+	}
+
+	// Connections closed, but tracker entries and goroutines stayed behind
+	sample = Sample{Tracked: 3, Sessions: 1, Goroutines: 100 + goroutineSlack + 1}
+	var got []string
+	for i := 0; i < sustain; i++ {
+		got = c.Check()
+	}
+	if strings.Join(got, ",") != KindTracked+","+KindGoroutines {
+		t.Errorf("Expected tracked and goroutine leaks, got %v", got)
+	}
+
+	// A new session accounts for more goroutines
+	sample = Sample{Sessions: 2, Goroutines: 100 + goroutineSlack + 1}
+	if got := c.Check(); len(got) != 0 {
+		t.Errorf("Expected the second session to account for the goroutines, got %v", got)
+	}
+}
@@ -0,0 +1,153 @@
+package manager
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/events"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// SetEventBus publishes the manager's events to bus instead of a bus of its
+// own, so managers and other subscribers can share one, and has the launcher
+// publish hole punching and health check failures there if it can. Call it
+// before Start.
+func (cm *ConnManager) SetEventBus(bus *events.Bus) {
+	cm.events = bus
+	if launcher, ok := cm.launcher.(interface{ SetEventBus(*events.Bus) }); ok {
+		launcher.SetEventBus(bus)
+	}
+}
+
+// publish sends a lifecycle event for session, or a manager-wide event such
+// as the budget running out if session is nil
+func (cm *ConnManager) publish(eventType string, session *Session, message string) {
+	cm.events.Publish(cm.event(eventType, session, message))
+}
+
+// event builds an event for publish
+func (cm *ConnManager) event(eventType string, session *Session, message string) events.Event {
+	e := events.Event{
+		Type:    eventType,
+		Message: message,
+		Fields:  map[string]interface{}{"region": cm.cfg.AWSRegion},
+	}
+	if session != nil {
+		e.Session = session.ID
+		e.Fields["role"] = session.Role
+		e.Fields["lambda_ip"] = shared.RedactIP(session.LambdaPublicIP)
+	}
+	return e
+}
+
+// launchFailed publishes a failed launch of a session for role
+func (cm *ConnManager) launchFailed(role string, err error) {
+	cm.events.Publish(events.Event{
+		Type:    events.SessionLaunchFailed,
+		Message: err.Error(),
+		Fields:  map[string]interface{}{"region": cm.cfg.AWSRegion, "role": role},
+	})
+}
+
+// handleEvent logs the manager's own events and sends the notifications they
+// call for. Managers of other regions on the same bus handle theirs.
+func (cm *ConnManager) handleEvent(e events.Event) {
+	if region, _ := e.Fields["region"].(string); region != cm.cfg.AWSRegion {
+		return
+	}
+	logEvent(e)
+	if cm.notifier == nil {
+		return
+	}
+	if title, message, ok := notification(e); ok {
+		cm.notifier.Notify(title, message)
+	}
+}
+
+// logEvent logs a lifecycle or budget event. Hole punching and health check
+// failures and the fallback to the relay are left out, since the launcher logs
+// them as they happen.
+func logEvent(e events.Event) {
+	role, _ := e.Fields["role"].(string)
+	switch e.Type {
+	case events.SessionLaunched:
+		shared.LogSuccessf("ConnManager: Successfully launched %s session %s%s", role, e.Session, detail(e.Message))
+	case events.SessionLaunchFailed:
+		shared.LogErrorf("ConnManager: Failed to launch %s session: %s", role, e.Message)
+	case events.SessionPromoted:
+		shared.LogInfof("ConnManager: Session %s promoted to primary%s", e.Session, detail(e.Message))
+	case events.SessionDraining:
+		shared.LogInfof("ConnManager: Session %s demoted to draining%s", e.Session, detail(e.Message))
+	case events.SessionClosed:
+		shared.LogInfof("ConnManager: Session %s (%s) %s", e.Session, role, e.Message)
+	case events.TunnelDown:
+		shared.LogErrorf("ConnManager: All sessions down: %s", e.Message)
+	case events.TunnelRestored:
+		shared.LogSuccessf("ConnManager: Tunnel restored by session %s%s", e.Session, detail(e.Message))
+	case events.RotationFailing:
+		shared.LogErrorf("ConnManager: %s", e.Message)
+	case events.BudgetExceeded:
+		shared.LogErrorf("ConnManager: ⚠️  Budget exceeded: %s", e.Message)
+	case events.BudgetWarning, events.BudgetReset, events.RegionEvacuated, events.EvacuationEnded:
+		shared.LogInfof("ConnManager: %s", e.Message)
+	}
+}
+
+// notification returns the notification for an event, if it calls for one:
+// a new egress IP, a lost primary, an outage and its end, failing rotations,
+// the fallback to the relay, the budget running out or an evacuation
+func notification(e events.Event) (title, message string, ok bool) {
+	role, _ := e.Fields["role"].(string)
+	switch e.Type {
+	case events.SessionLaunched:
+		if role == RolePrimary && e.Message != "" {
+			return "New session", capitalize(e.Message), true
+		}
+	case events.SessionPromoted:
+		if e.Message != "" {
+			return "Session rotated", capitalize(e.Message), true
+		}
+	case events.SessionClosed:
+		// Without another session up it's an outage, reported as TunnelDown
+		if sessionsUp, _ := e.Fields["sessions_up"].(bool); role == RolePrimary && sessionsUp {
+			lambdaIP, _ := e.Fields["lambda_ip"].(string)
+			return "Session lost", fmt.Sprintf("Lambda at %s %s; reconnecting", lambdaIP, e.Message), true
+		}
+	case events.TunnelDown:
+		return "All sessions down", e.Message, true
+	case events.TunnelRestored:
+		message := "A new session is up"
+		if e.Message != "" {
+			message = capitalize(e.Message)
+		}
+		return "Tunnel restored", message, true
+	case events.RotationFailing:
+		return "Rotation failing", e.Message, true
+	case events.RelayFallback:
+		return "Relay mode active", capitalize(e.Message), true
+	case events.BudgetWarning:
+		return "Budget nearly reached", e.Message, true
+	case events.BudgetExceeded:
+		return "Budget reached", e.Message, true
+	case events.RegionEvacuated:
+		return "Region evacuated", e.Message, true
+	case events.EvacuationEnded:
+		return "Region evacuation ended", e.Message, true
+	}
+	return "", "", false
+}
+
+// detail formats an event's message to follow a log line
+func detail(message string) string {
+	if message == "" {
+		return ""
+	}
+	return ", " + message
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
@@ -0,0 +1,140 @@
+package manager
+
+import (
+	"sync"
+	"time"
+)
+
+// Launch phase constants, in the order a launch runs them
+const (
+	LaunchPhaseSTUN          = "stun"
+	LaunchPhaseS3Put         = "s3_put"
+	LaunchPhaseLambdaWait    = "lambda_wait"
+	LaunchPhaseHolePunch     = "hole_punch"
+	LaunchPhaseRelay         = "relay"
+	LaunchPhaseQUICHandshake = "quic_handshake"
+	LaunchPhaseControlStream = "control_stream"
+	LaunchPhaseHello         = "hello"
+)
+
+// maxLaunchRecords bounds how many launches the history keeps
+const maxLaunchRecords = 20
+
+// LaunchPhase is how long one phase of a launch took
+type LaunchPhase struct {
+	Name     string        `json:"name"`
+	Duration time.Duration `json:"duration"`
+}
+
+// LaunchRecord breaks one session launch down by phase. A failed launch ends
+// with the phase that failed.
+type LaunchRecord struct {
+	SessionID string        `json:"session_id,omitempty"` // empty if the launch failed before S3
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"duration"`
+	Phases    []LaunchPhase `json:"phases"`
+	Error     string        `json:"error,omitempty"`
+
+	// Trigger is how the Lambda was started ("s3" or "invoke") and
+	// TriggerLatency how long after the S3 write, as the Lambda reported
+	Trigger        string        `json:"trigger,omitempty"`
+	TriggerLatency time.Duration `json:"trigger_latency,omitempty"`
+}
+
+// Phase returns the duration of the named phase, or zero if it didn't run
+func (r LaunchRecord) Phase(name string) time.Duration {
+	for _, phase := range r.Phases {
+		if phase.Name == name {
+			return phase.Duration
+		}
+	}
+	return 0
+}
+
+// LaunchTimer times the phases of one launch. A nil *LaunchTimer ignores
+// all calls, so callers need not check for it.
+type LaunchTimer struct {
+	history *LaunchHistory
+	record  LaunchRecord
+}
+
+// Phase starts timing the named phase; call the returned function when it ends
+func (t *LaunchTimer) Phase(name string) func() {
+	if t == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		t.record.Phases = append(t.record.Phases, LaunchPhase{Name: name, Duration: time.Since(start)})
+	}
+}
+
+// SetSession notes the ID of the session being launched
+func (t *LaunchTimer) SetSession(sessionID string) {
+	if t == nil {
+		return
+	}
+	t.record.SessionID = sessionID
+}
+
+// SetTrigger notes how the Lambda was started and how long after the S3 write
+func (t *LaunchTimer) SetTrigger(trigger string, latency time.Duration) {
+	if t == nil {
+		return
+	}
+	t.record.Trigger = trigger
+	t.record.TriggerLatency = latency
+}
+
+// Finish adds the launch to its history, with err if it failed
+func (t *LaunchTimer) Finish(err error) {
+	if t == nil {
+		return
+	}
+	t.record.Duration = time.Since(t.record.StartedAt)
+	if err != nil {
+		t.record.Error = err.Error()
+	}
+	t.history.add(t.record)
+}
+
+// LaunchHistory keeps the phase breakdowns of the most recent launches
+type LaunchHistory struct {
+	mu      sync.Mutex
+	records []LaunchRecord // oldest first
+}
+
+// Start begins timing a launch. A nil history returns a nil timer.
+func (h *LaunchHistory) Start() *LaunchTimer {
+	if h == nil {
+		return nil
+	}
+	return &LaunchTimer{history: h, record: LaunchRecord{StartedAt: time.Now()}}
+}
+
+func (h *LaunchHistory) add(record LaunchRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.records = append(h.records, record)
+	if len(h.records) > maxLaunchRecords {
+		h.records = h.records[len(h.records)-maxLaunchRecords:]
+	}
+}
+
+// Snapshot returns copies of the kept records, newest first
+func (h *LaunchHistory) Snapshot() []LaunchRecord {
+	if h == nil {
+		return []LaunchRecord{}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	records := make([]LaunchRecord, 0, len(h.records))
+	for i := len(h.records) - 1; i >= 0; i-- {
+		record := h.records[i]
+		record.Phases = append([]LaunchPhase(nil), record.Phases...)
+		records = append(records, record)
+	}
+	return records
+}
@@ -0,0 +1,62 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLaunchHistory_RecordsPhases(t *testing.T) {
+	history := &LaunchHistory{}
+
+	timer := history.Start()
+	timer.Phase(LaunchPhaseSTUN)()
+	timer.SetSession("s1")
+	end := timer.Phase(LaunchPhaseS3Put)
+	time.Sleep(5 * time.Millisecond)
+	end()
+	timer.Finish(nil)
+
+	failed := history.Start()
+	failed.Phase(LaunchPhaseSTUN)()
+	failed.Finish(errors.New("stun timeout"))
+
+	records := history.Snapshot()
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 launches, got %d", len(records))
+	}
+	if records[0].Error != "stun timeout" || records[0].SessionID != "" {
+		t.Errorf("Expected newest record to be the failed launch, got %+v", records[0])
+	}
+
+	ok := records[1]
+	if ok.SessionID != "s1" || len(ok.Phases) != 2 || ok.Phases[1].Name != LaunchPhaseS3Put {
+		t.Fatalf("Unexpected launch record: %+v", ok)
+	}
+	if d := ok.Phase(LaunchPhaseS3Put); d < 5*time.Millisecond || d > ok.Duration {
+		t.Errorf("Expected S3 put phase between 5ms and the total %v, got %v", ok.Duration, d)
+	}
+	if d := ok.Phase(LaunchPhaseQUICHandshake); d != 0 {
+		t.Errorf("Expected a phase that didn't run to be zero, got %v", d)
+	}
+}
+
+func TestLaunchHistory_KeepsRecentRecords(t *testing.T) {
+	history := &LaunchHistory{}
+	for i := 0; i < maxLaunchRecords+5; i++ {
+		history.Start().Finish(nil)
+	}
+	if records := history.Snapshot(); len(records) != maxLaunchRecords {
+		t.Errorf("Expected %d records, got %d", maxLaunchRecords, len(records))
+	}
+
+	// Launchers without a history time nothing
+	var none *LaunchHistory
+	timer := none.Start()
+	timer.Phase(LaunchPhaseSTUN)()
+	timer.SetSession("x")
+	timer.Finish(nil)
+	if records := none.Snapshot(); len(records) != 0 {
+		t.Errorf("Expected no records, got %+v", records)
+	}
+}
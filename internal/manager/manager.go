@@ -2,12 +2,19 @@ package manager
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/cost"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/events"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/features"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/metrics"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/notify"
 	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
 	"github.com/quic-go/quic-go"
 )
@@ -31,12 +38,43 @@ type Session struct {
 	Cancel        context.CancelFunc
 	StartedAt     time.Time
 	ControlStream quic.Stream
+	Protocol      shared.Hello // the Lambda's protocol versions and capabilities
+	Crypto        shared.TunnelCrypto // TLS and QUIC versions negotiated with the Lambda
 	Role          string
 	TTL           time.Duration
 	healthy       bool
 	healthMutex   sync.RWMutex
 	missedPings   int
 	LambdaPublicIP string
+	Relayed       bool // tunnelled over TCP through the relay instead of a punched UDP path
+	
+	// activeStreams counts tunnel streams open on the session
+	activeStreams atomic.Int64
+	
+	// expiring is set once the Lambda warns it is near its function timeout;
+	// it takes no new streams from then on
+	expiring atomic.Bool
+	
+	// Stripes are extra QUIC connections to the same Lambda that tunnel
+	// streams are spread across with QuicConn (nil = QuicConn only)
+	Stripes    []quic.Connection
+	nextStripe atomic.Uint64
+	
+	// Bytes reconciles the byte counts of finished tunnel streams with the
+	// Lambda's (nil unless the Lambda supports shared.CapByteCounts)
+	Bytes *ByteReconciler
+	
+	// controlMu serializes messages written to ControlStream
+	controlMu sync.Mutex
+	
+	// promotionPending is set while checkForPromotion watches a new secondary (guarded by ConnManager.mu)
+	promotionPending bool
+	
+	// rotation is the rotation this secondary is taking part in (guarded by ConnManager.mu)
+	rotation *rotation
+	
+	// rotateEarly makes the primary due for rotation before its TTL (guarded by ConnManager.mu)
+	rotateEarly bool
 }
 
 // LaunchState tracks the state of session launches to prevent race conditions
@@ -59,33 +97,135 @@ type ConnManager struct {
 	shutdownOnce     sync.Once
 	shutdownCh       chan struct{}
 	
-	// Resource limits
-	maxSessions     int
-	maxGoroutines   int
-	currentSessions int
+	// kick makes the monitor check the sessions without waiting for its next tick
+	kick chan struct{}
+	
+	// Background goroutines started by startGoroutine, at most maxGoroutines
+	// at once (guarded by goroutineMu)
+	goroutineMu   sync.Mutex
+	goroutines    int
+	maxGoroutines int
+	
+	// Session pool size: one primary, up to poolSecondaries secondaries and
+	// any draining sessions, together at most poolMaxSessions (guarded by mu)
+	poolSecondaries int
+	poolMaxSessions int
+	
+	// rotation starts as cfg.Rotation and is replaced by SetRotation
+	rotation atomic.Pointer[config.RotationConfig]
 	
 	sessions    []*Session
 	launchState *LaunchState
+	rotations   *rotationTimeline
+	launches    *LaunchHistory
+	
+	// Transfer and spend caps; once one is hit no sessions are launched until the period ends
+	budget         *cost.BudgetTracker
+	budgetExceeded bool // guarded by mu
+	budgetWarned   time.Time // start of the period the budget warning was given for (guarded by mu)
+	
+	// evacuatedUntil stops launches in this region until then, after Evacuate (guarded by mu)
+	evacuatedUntil time.Time
+	
+	// Session lifecycle and budget events, which the manager logs and turns
+	// into desktop and webhook notifications (nil notifier = off), and which
+	// the admin API streams
+	events     *events.Bus
+	notifier   notify.Notifier
+	egressIP   string // the last primary's public IP (guarded by mu)
+	tunnelDown bool   // the primary was lost with no session left to use (guarded by mu)
 }
 
 // New creates a new ConnManager instance
 func New(cfg *config.Config, launcher SessionLauncher) *ConnManager {
-	return &ConnManager{
+	secondaries, maxSessions := cfg.Rotation.PoolSize()
+	cm := &ConnManager{
 		cfg:         cfg,
 		launcher:    launcher,
 		launchState: &LaunchState{},
+		rotations:   &rotationTimeline{},
+		launches:    &LaunchHistory{},
+		budget:      cost.NewBudgetTracker(cfg.Budget, cost.DefaultPricing(), cfg.ModeConfig.LambdaMemory),
 		
 		// Resource management
 		shutdownCh:    make(chan struct{}),
-		maxSessions:   10, // Configurable limit
-		maxGoroutines: 50, // Prevent goroutine explosion
+		kick:          make(chan struct{}, 1),
+		maxGoroutines: cfg.Rotation.GoroutineLimit(),
+		
+		poolSecondaries: secondaries,
+		poolMaxSessions: maxSessions,
+	}
+	rotation := cfg.Rotation
+	cm.rotation.Store(&rotation)
+	cm.SetEventBus(events.NewBus())
+	cm.rotations.onFailures = func(failed int, detail string) {
+		cm.publish(events.RotationFailing, nil, fmt.Sprintf("%d session rotations in a row failed, the last with: %s", failed, detail))
 	}
+	metrics.SetManagerLimits(maxSessions, cm.maxGoroutines)
+	return cm
 }
 
-// startGoroutine safely starts a goroutine with resource tracking
-func (cm *ConnManager) startGoroutine(name string, fn func()) error {
+// SetRotation replaces the rotation timing, drain policy, pool size and
+// goroutine limit. Sessions launched from now on get the new TTL; running
+// ones keep theirs.
+func (cm *ConnManager) SetRotation(rotation config.RotationConfig) {
+	secondaries, maxSessions := rotation.PoolSize()
 	cm.mu.Lock()
-	defer cm.mu.Unlock()
+	cm.poolSecondaries = secondaries
+	cm.poolMaxSessions = maxSessions
+	cm.mu.Unlock()
+	cm.goroutineMu.Lock()
+	cm.maxGoroutines = rotation.GoroutineLimit()
+	cm.goroutineMu.Unlock()
+	cm.rotation.Store(&rotation)
+	metrics.SetManagerLimits(maxSessions, rotation.GoroutineLimit())
+}
+
+// SetNotifier sends notifications of new egress IPs, lost sessions and the
+// budget running out to n. Call it before Start.
+func (cm *ConnManager) SetNotifier(n notify.Notifier) {
+	cm.notifier = n
+}
+
+// primaryChanged notes session's egress IP on becoming primary and describes
+// the change for its event. After an outage it publishes the tunnel being
+// restored instead, describing the change there. The caller must hold cm.mu.
+func (cm *ConnManager) primaryChanged(session *Session) string {
+	previous := cm.egressIP
+	cm.egressIP = session.LambdaPublicIP
+	if cm.tunnelDown {
+		cm.tunnelDown = false
+		cm.publish(events.TunnelRestored, session, describeEgress(previous, session.LambdaPublicIP))
+		return ""
+	}
+	return describeEgress(previous, session.LambdaPublicIP)
+}
+
+// describeEgress describes the egress IP changing from previous to current,
+// which is no change for the first session
+func describeEgress(previous, current string) string {
+	if previous == "" {
+		return ""
+	}
+	if current == previous {
+		return fmt.Sprintf("egress IP unchanged: %s", shared.RedactIP(previous))
+	}
+	return fmt.Sprintf("egress IP is now %s (was %s)", shared.RedactIP(current), shared.RedactIP(previous))
+}
+
+// rotationConfig returns the rotation settings in effect
+func (cm *ConnManager) rotationConfig() config.RotationConfig {
+	return *cm.rotation.Load()
+}
+
+// ErrTooManyGoroutines is returned by startGoroutine while maxGoroutines are running
+var ErrTooManyGoroutines = errors.New("too many connection manager goroutines")
+
+// startGoroutine safely starts a goroutine with resource tracking. It doesn't
+// take cm.mu, so it may be called with it held.
+func (cm *ConnManager) startGoroutine(name string, fn func()) error {
+	cm.goroutineMu.Lock()
+	defer cm.goroutineMu.Unlock()
 	
 	// Check if we're shutting down
 	select {
@@ -95,11 +235,22 @@ func (cm *ConnManager) startGoroutine(name string, fn func()) error {
 	}
 	
 	// Check goroutine limit
-	// Track goroutine creation for monitoring
+	if cm.goroutines >= cm.maxGoroutines {
+		metrics.RecordManagerGoroutineRefused()
+		return fmt.Errorf("%w: %d of %d running, cannot start %s", ErrTooManyGoroutines, cm.goroutines, cm.maxGoroutines, name)
+	}
+	cm.goroutines++
+	metrics.SetManagerGoroutines(cm.goroutines)
 	
 	cm.activeGoroutines.Add(1)
 	go func() {
 		defer cm.activeGoroutines.Done()
+		defer func() {
+			cm.goroutineMu.Lock()
+			cm.goroutines--
+			metrics.SetManagerGoroutines(cm.goroutines)
+			cm.goroutineMu.Unlock()
+		}()
 		defer func() {
 			if r := recover(); r != nil {
 				shared.LogErrorf("Goroutine %s panicked: %v", name, r)
@@ -137,6 +288,9 @@ func (cm *ConnManager) cleanupSession(session *Session) error {
 			shared.LogErrorf("Failed to close QUIC connection for session %s: %v", session.ID, err)
 		}
 	}
+	for _, stripe := range session.Stripes {
+		stripe.CloseWithError(0, "session cleanup")
+	}
 	
 	return nil
 }
@@ -144,6 +298,7 @@ func (cm *ConnManager) cleanupSession(session *Session) error {
 // Start launches the first session and monitors it, blocking on the provided context
 func (cm *ConnManager) Start(ctx context.Context) error {
 	shared.LogInfo("ConnManager: Starting session management")
+	cm.events.Follow(ctx, cm.handleEvent)
 	
 	// Launch initial session
 	session, err := cm.launchSession(ctx)
@@ -157,6 +312,7 @@ func (cm *ConnManager) Start(ctx context.Context) error {
 	cm.mu.Lock()
 	cm.sessions = []*Session{session}
 	metrics.SetActiveSessions(len(cm.sessions))
+	cm.publish(events.SessionLaunched, session, cm.primaryChanged(session))
 	cm.mu.Unlock()
 	
 	// Start monitoring in background
@@ -179,7 +335,9 @@ func (cm *ConnManager) shutdown() error {
 		shared.LogInfo("ConnManager: Beginning graceful shutdown")
 		
 		// Signal shutdown to prevent new goroutines
+		cm.goroutineMu.Lock()
 		close(cm.shutdownCh)
+		cm.goroutineMu.Unlock()
 		
 		// Clean up all sessions
 		cm.mu.Lock()
@@ -229,6 +387,8 @@ func (cm *ConnManager) monitor(ctx context.Context) {
 			return
 		case <-ticker.C:
 			cm.checkSessions(ctx)
+		case <-cm.kick:
+			cm.checkSessions(ctx)
 		}
 	}
 }
@@ -241,23 +401,43 @@ func (cm *ConnManager) checkSessions(ctx context.Context) {
 	// Remove closed or unhealthy sessions
 	activeSessions := make([]*Session, 0, len(cm.sessions))
 	var primarySession *Session
+	var closed []events.Event // published once it's known whether any session is left
 	
 	for _, session := range cm.sessions {
 		// Check if session is closed
 		select {
 		case <-session.QuicConn.Context().Done():
-			shared.LogInfof("ConnManager: Session %s (%s) closed", session.ID, session.Role)
+			closed = append(closed, cm.event(events.SessionClosed, session, "closed"))
+			session.rotation.event(RotationFailed, "secondary closed")
 			continue
 		default:
 		}
 		
 		// Check if session is unhealthy
 		if !session.IsHealthy() && !session.IsDraining() {
-			shared.LogInfof("ConnManager: Session %s (%s) unhealthy, removing", session.ID, session.Role)
+			closed = append(closed, cm.event(events.SessionClosed, session, "stopped answering health checks"))
+			session.rotation.event(RotationFailed, "secondary unhealthy")
 			session.Cancel()
 			continue
 		}
 		
+		// A Lambda near its function timeout takes no new streams: rotate
+		// the primary now and drain any other session
+		if session.IsExpiring() {
+			switch {
+			case session.IsPrimary() && !session.rotateEarly:
+				shared.LogInfof("ConnManager: Primary session %s is near its function timeout, rotating early", session.ID)
+				session.rotateEarly = true
+			case session.IsSecondary():
+				shared.LogInfof("ConnManager: Secondary session %s is near its function timeout, draining", session.ID)
+				session.Role = RoleDraining
+				cm.publish(events.SessionDraining, session, "near its function timeout")
+				session.rotation.event(RotationFailed, "secondary near its function timeout")
+				session.rotation = nil
+				cm.startDrainCleanup(session, nil)
+			}
+		}
+		
 		activeSessions = append(activeSessions, session)
 		if session.IsPrimary() {
 			primarySession = session
@@ -267,37 +447,149 @@ func (cm *ConnManager) checkSessions(ctx context.Context) {
 	cm.sessions = activeSessions
 	metrics.SetActiveSessions(len(cm.sessions))
 	
+	// A lost primary is news; losing it with nothing left to tunnel through
+	// is an outage, reported once until a new primary takes over
+	sessionsUp := cm.hasUsableSession()
+	for _, e := range closed {
+		e.Fields["sessions_up"] = sessionsUp
+		cm.events.Publish(e)
+		if e.Fields["role"] == RolePrimary && !sessionsUp && !cm.tunnelDown {
+			cm.tunnelDown = true
+			cm.publish(events.TunnelDown, nil, fmt.Sprintf("Lambda at %s %s and no other session is up; connections wait for a new one",
+				e.Fields["lambda_ip"], e.Message))
+		}
+	}
+	
+	// Past the budget, let the running sessions expire without replacing them
+	if cm.overBudget() {
+		return
+	}
+	
+	// While the region is evacuated its sessions drain and none replace them
+	if cm.evacuated() {
+		return
+	}
+	
 	// If no primary session, launch one (but only if we don't have too many sessions)
 	if primarySession == nil {
-		if len(activeSessions) < 2 && cm.canLaunchPrimary() {
+		if len(activeSessions) < cm.poolMaxSessions && cm.canLaunchPrimary() {
 			shared.LogInfo("ConnManager: No primary session, launching new one")
-			go cm.launchPrimarySession(ctx)
+			if err := cm.startGoroutine("launch-primary", func() { cm.launchPrimarySession(ctx) }); err != nil {
+				shared.LogErrorf("ConnManager: Cannot launch primary session: %v", err)
+				cm.abortLaunch(true)
+			}
 		} else {
 			shared.LogInfof("ConnManager: No primary session but %d sessions exist, waiting for cleanup", len(activeSessions))
 		}
 	} else {
 		// Check if primary needs rotation based on TTL
 		remaining := primarySession.RemainingTTL()
-		if remaining <= cm.cfg.Rotation.OverlapWindow {
-			// Check if we already have a secondary
-			hasSecondary := false
-			for _, session := range cm.sessions {
-				if session.IsSecondary() {
-					hasSecondary = true
-					break
+		if cm.dueForRotation(primarySession) {
+			// Hand over to a warm secondary if one can outlive the overlap window
+			if successor := cm.rotationCandidate(); successor != nil {
+				if !successor.promotionPending && successor.rotation == nil && successor.IsHealthy() {
+					successor.rotation = cm.rotations.start(primarySession.ID)
+					successor.rotation.setSession(successor.ID)
+					successor.rotation.event(RotationSecondaryHealthy, "warm secondary")
+					if err := cm.startGoroutine("promote-"+successor.ID, func() { cm.promoteSecondary(successor) }); err != nil {
+						shared.LogErrorf("ConnManager: Cannot promote secondary session %s: %v", successor.ID, err)
+						successor.rotation.event(RotationFailed, err.Error())
+						successor.rotation = nil
+					}
 				}
+				return
 			}
 			
 			// Use atomic launch state check to prevent race conditions
-			if !hasSecondary && len(cm.sessions) < 2 && cm.canLaunchSecondary() {
+			if cm.canAddSecondary() && cm.canLaunchSecondary() {
 				shared.LogInfof("ConnManager: Primary session %s TTL %v <= overlap window %v, launching secondary", 
-					primarySession.ID, remaining, cm.cfg.Rotation.OverlapWindow)
-				go cm.launchSecondarySession(ctx)
+					primarySession.ID, remaining, cm.rotationConfig().OverlapWindow)
+				r := cm.rotations.start(primarySession.ID)
+				if err := cm.startGoroutine("launch-secondary", func() { cm.launchSecondarySession(ctx, r) }); err != nil {
+					shared.LogErrorf("ConnManager: Cannot launch secondary session: %v", err)
+					r.event(RotationFailed, err.Error())
+					cm.abortLaunch(false)
+				}
 			}
 		}
 	}
 }
 
+// hasUsableSession reports whether a healthy session that isn't draining
+// remains. The caller must hold cm.mu.
+func (cm *ConnManager) hasUsableSession() bool {
+	for _, session := range cm.sessions {
+		if session.IsHealthy() && !session.IsDraining() && !session.IsExpiring() {
+			return true
+		}
+	}
+	return false
+}
+
+// overBudget reports whether a budget cap has been reached, publishing when
+// the manager stops and resumes launching sessions. The caller must hold cm.mu.
+func (cm *ConnManager) overBudget() bool {
+	if cm.budget == nil {
+		return false
+	}
+	cm.budget.ObserveBytes(metrics.GetSOCKS5BytesTransferred())
+	status := cm.budget.Status()
+	if status.Exceeded && !cm.budgetExceeded {
+		cm.publish(events.BudgetExceeded, nil, fmt.Sprintf("%s; no new sessions until %s",
+			status.Reason, status.ResetsAt.Local().Format(time.RFC1123)))
+	} else if !status.Exceeded && status.Used() >= cost.BudgetWarningFraction && !cm.budgetWarned.Equal(status.PeriodStart) {
+		cm.budgetWarned = status.PeriodStart
+		cm.publish(events.BudgetWarning, nil, fmt.Sprintf("%.0f%% of the budget for this %s used", status.Used()*100, status.Period))
+	} else if !status.Exceeded && cm.budgetExceeded {
+		cm.publish(events.BudgetReset, nil, fmt.Sprintf("New budget %s started, launching sessions again", status.Period))
+	}
+	cm.budgetExceeded = status.Exceeded
+	return status.Exceeded
+}
+
+// BudgetStatus returns usage against the budget, or nil if no budget is set
+func (cm *ConnManager) BudgetStatus() *cost.BudgetStatus {
+	if cm.budget == nil {
+		return nil
+	}
+	status := cm.budget.Status()
+	return &status
+}
+
+// canAddSecondary reports whether a secondary slot and pool capacity are free.
+// The caller must hold cm.mu.
+func (cm *ConnManager) canAddSecondary() bool {
+	secondaries := 0
+	for _, session := range cm.sessions {
+		if session.IsSecondary() {
+			secondaries++
+		}
+	}
+	return secondaries < cm.poolSecondaries && len(cm.sessions) < cm.poolMaxSessions
+}
+
+// dueForRotation reports whether primary is within the overlap window of its
+// TTL or was asked to rotate early. The caller must hold cm.mu.
+func (cm *ConnManager) dueForRotation(primary *Session) bool {
+	return primary.rotateEarly || primary.RemainingTTL() <= cm.rotationConfig().OverlapWindow
+}
+
+// rotationCandidate returns the secondary best placed to take over from the
+// primary: the one with the most TTL left beyond the overlap window, or nil.
+// The caller must hold cm.mu.
+func (cm *ConnManager) rotationCandidate() *Session {
+	var best *Session
+	for _, session := range cm.sessions {
+		if !session.IsSecondary() || session.IsExpiring() || session.RemainingTTL() <= cm.rotationConfig().OverlapWindow {
+			continue
+		}
+		if best == nil || session.RemainingTTL() > best.RemainingTTL() {
+			best = session
+		}
+	}
+	return best
+}
+
 // launchSession creates a new session using the launcher
 func (cm *ConnManager) launchSession(ctx context.Context) (*Session, error) {
 	sessionCtx, cancel := context.WithCancel(ctx)
@@ -310,6 +602,16 @@ func (cm *ConnManager) launchSession(ctx context.Context) (*Session, error) {
 	
 	// Store the cancel function in the session
 	session.Cancel = cancel
+	if ttl := cm.rotationConfig().SessionTTL; ttl > 0 {
+		session.TTL = ttl
+	}
+	
+	// The Lambda is billed until its session expires, however long it's used
+	runtime := session.TTL
+	if runtime == 0 {
+		runtime = cm.cfg.ModeConfig.SessionTTL
+	}
+	cm.budget.AddSession(runtime)
 	
 	return session, nil
 }
@@ -321,9 +623,10 @@ func (cm *ConnManager) GetCurrent() *Session {
 	
 	var selectedSession *Session
 	
-	// First, look for a healthy primary session
+	// First, look for a healthy primary session. Sessions near their
+	// function timeout take no new streams, so they are never chosen.
 	for _, session := range cm.sessions {
-		if session.IsPrimary() && session.IsHealthy() {
+		if session.IsPrimary() && session.IsHealthy() && !session.IsExpiring() {
 			selectedSession = session
 			break
 		}
@@ -332,7 +635,7 @@ func (cm *ConnManager) GetCurrent() *Session {
 	// If no healthy primary, look for any healthy secondary (during transition)
 	if selectedSession == nil {
 		for _, session := range cm.sessions {
-			if session.IsSecondary() && session.IsHealthy() {
+			if session.IsSecondary() && session.IsHealthy() && !session.IsExpiring() {
 				selectedSession = session
 				break
 			}
@@ -342,7 +645,7 @@ func (cm *ConnManager) GetCurrent() *Session {
 	// Last resort: return any healthy session (but not draining)
 	if selectedSession == nil {
 		for _, session := range cm.sessions {
-			if session.IsHealthy() && !session.IsDraining() {
+			if session.IsHealthy() && !session.IsDraining() && !session.IsExpiring() {
 				selectedSession = session
 				break
 			}
@@ -373,6 +676,35 @@ func (cm *ConnManager) GetAllSessions() []*Session {
 	return sessionsCopy
 }
 
+// Rotations returns the most recent rotation attempts, newest first
+func (cm *ConnManager) Rotations() []RotationRecord {
+	return cm.rotations.snapshot()
+}
+
+// LaunchHistory returns the history the launcher records phase timings in
+func (cm *ConnManager) LaunchHistory() *LaunchHistory {
+	return cm.launches
+}
+
+// Launches returns the phase breakdowns of recent launches, newest first
+func (cm *ConnManager) Launches() []LaunchRecord {
+	return cm.launches.Snapshot()
+}
+
+// SessionsByRole returns the sessions currently in role
+func (cm *ConnManager) SessionsByRole(role string) []*Session {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	
+	var sessions []*Session
+	for _, session := range cm.sessions {
+		if session.Role == role {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions
+}
+
 // Primary returns the primary session (alias for GetCurrent)
 func (cm *ConnManager) Primary() *Session {
 	return cm.GetCurrent()
@@ -424,6 +756,51 @@ func (s *Session) ResetMissedPings() {
 	s.missedPings = 0
 }
 
+// WriteControl runs write against the control stream, keeping concurrent
+// control messages from interleaving
+func (s *Session) WriteControl(write func(w io.Writer) error) error {
+	if s.ControlStream == nil {
+		return fmt.Errorf("session %s has no control stream", s.ID)
+	}
+	s.controlMu.Lock()
+	defer s.controlMu.Unlock()
+	return write(s.ControlStream)
+}
+
+// TrackStream counts a tunnel stream as open on the session until the
+// returned function is called
+func (s *Session) TrackStream() func() {
+	s.activeStreams.Add(1)
+	var once sync.Once
+	return func() {
+		once.Do(func() { s.activeStreams.Add(-1) })
+	}
+}
+
+// StreamConn returns the connection the next tunnel stream should open on,
+// taking QuicConn and the stripes in turn and skipping stripes that closed.
+// Only QuicConn is used while the striping feature is off.
+func (s *Session) StreamConn() quic.Connection {
+	n := uint64(len(s.Stripes) + 1)
+	if n == 1 || !features.Enabled(features.Striping) {
+		return s.QuicConn
+	}
+	i := s.nextStripe.Add(1) % n
+	if i == 0 {
+		return s.QuicConn
+	}
+	stripe := s.Stripes[i-1]
+	if stripe.Context().Err() != nil {
+		return s.QuicConn
+	}
+	return stripe
+}
+
+// ActiveStreams returns the number of tunnel streams open on the session
+func (s *Session) ActiveStreams() int64 {
+	return s.activeStreams.Load()
+}
+
 // RemainingTTL returns the remaining time to live for the session
 func (s *Session) RemainingTTL() time.Duration {
 	elapsed := time.Since(s.StartedAt)
@@ -449,6 +826,17 @@ func (s *Session) IsDraining() bool {
 	return s.Role == RoleDraining
 }
 
+// MarkExpiring records that the session's Lambda is near its function
+// timeout and takes no new streams. It reports whether this is news.
+func (s *Session) MarkExpiring() bool {
+	return !s.expiring.Swap(true)
+}
+
+// IsExpiring reports whether the session's Lambda is near its function timeout
+func (s *Session) IsExpiring() bool {
+	return s.expiring.Load()
+}
+
 // launchPrimarySession launches a new primary session
 func (cm *ConnManager) launchPrimarySession(ctx context.Context) {
 	defer func() {
@@ -473,7 +861,7 @@ func (cm *ConnManager) launchPrimarySession(ctx context.Context) {
 	
 	session, err := cm.launchSession(ctx)
 	if err != nil {
-		shared.LogErrorf("ConnManager: Failed to launch primary session: %v", err)
+		cm.launchFailed(RolePrimary, err)
 		metrics.RecordSessionFailure()
 		return
 	}
@@ -493,15 +881,30 @@ func (cm *ConnManager) launchPrimarySession(ctx context.Context) {
 			return
 		}
 	}
+	if len(cm.sessions) >= cm.poolMaxSessions {
+		cm.mu.Unlock()
+		shared.LogInfof("ConnManager: Session pool full (%d sessions), discarding new session %s", len(cm.sessions), session.ID)
+		cm.cleanupSession(session)
+		cm.clearLaunchState(true, true) // Not a failure, the pool will free up
+		return
+	}
+	if cm.evacuated() {
+		cm.mu.Unlock()
+		shared.LogInfof("ConnManager: Region evacuated during launch, discarding new session %s", session.ID)
+		cm.cleanupSession(session)
+		cm.clearLaunchState(true, true) // Not a failure, the evacuation stopped it
+		return
+	}
 	cm.sessions = append(cm.sessions, session)
+	metrics.SetActiveSessions(len(cm.sessions))
+	cm.publish(events.SessionLaunched, session, cm.primaryChanged(session))
 	cm.mu.Unlock()
 	
 	cm.clearLaunchState(true, true) // Success
-	shared.LogSuccessf("ConnManager: Successfully launched primary session %s", session.ID)
 }
 
-// launchSecondarySession launches a new secondary session for rotation
-func (cm *ConnManager) launchSecondarySession(ctx context.Context) {
+// launchSecondarySession launches a new secondary session for rotation r
+func (cm *ConnManager) launchSecondarySession(ctx context.Context, r *rotation) {
 	defer func() {
 		if r := recover(); r != nil {
 			shared.LogErrorf("ConnManager: Panic in launchSecondarySession: %v", r)
@@ -510,18 +913,12 @@ func (cm *ConnManager) launchSecondarySession(ctx context.Context) {
 	}()
 	defer cm.clearLaunchState(false, false) // Default to failure, update on success
 	
-	// Check if we already have a secondary or are at max sessions (race condition guard)
+	// Check if the secondary slots or the pool are full (race condition guard)
 	cm.mu.Lock()
-	hasSecondary := false
-	for _, session := range cm.sessions {
-		if session.IsSecondary() {
-			hasSecondary = true
-			break
-		}
-	}
-	if hasSecondary || len(cm.sessions) >= 2 {
+	if !cm.canAddSecondary() {
 		cm.mu.Unlock()
-		shared.LogInfof("ConnManager: Secondary already exists or at max sessions (%d), skipping launch", len(cm.sessions))
+		shared.LogInfof("ConnManager: Secondaries or pool full (%d sessions), skipping launch", len(cm.sessions))
+		r.event(RotationFailed, "session pool full")
 		cm.clearLaunchState(false, true) // Not a failure, just redundant
 		return
 	}
@@ -529,8 +926,9 @@ func (cm *ConnManager) launchSecondarySession(ctx context.Context) {
 	
 	session, err := cm.launchSession(ctx)
 	if err != nil {
-		shared.LogErrorf("ConnManager: Failed to launch secondary session: %v", err)
+		cm.launchFailed(RoleSecondary, err)
 		metrics.RecordSessionFailure()
+		r.event(RotationFailed, fmt.Sprintf("launch failed: %v", err))
 		return
 	}
 	
@@ -540,28 +938,42 @@ func (cm *ConnManager) launchSecondarySession(ctx context.Context) {
 	
 	cm.mu.Lock()
 	// Double-check after acquiring lock (race condition guard)
-	hasSecondary = false
-	for _, existingSession := range cm.sessions {
-		if existingSession.IsSecondary() {
-			hasSecondary = true
-			break
-		}
-	}
-	if hasSecondary || len(cm.sessions) >= 2 {
+	if !cm.canAddSecondary() {
 		cm.mu.Unlock()
-		shared.LogInfof("ConnManager: Secondary already exists or at max sessions (%d), discarding new session %s", len(cm.sessions), session.ID)
+		shared.LogInfof("ConnManager: Secondaries or pool full (%d sessions), discarding new session %s", len(cm.sessions), session.ID)
+		r.event(RotationFailed, "session pool full")
 		session.Cancel()
 		cm.clearLaunchState(false, true) // Not a failure, just redundant
 		return
 	}
+	if cm.evacuated() {
+		cm.mu.Unlock()
+		shared.LogInfof("ConnManager: Region evacuated during launch, discarding new session %s", session.ID)
+		r.event(RotationFailed, "region evacuated")
+		session.Cancel()
+		cm.clearLaunchState(false, true) // Not a failure, the evacuation stopped it
+		return
+	}
 	cm.sessions = append(cm.sessions, session)
+	cm.publish(events.SessionLaunched, session, "")
+	
+	r.setSession(session.ID)
+	r.event(RotationSecondaryLaunched, session.ID)
+	session.rotation = r
 	
 	// Check if secondary is healthy and promote it to primary
-	go cm.checkForPromotion(ctx, session)
+	session.promotionPending = true
+	if err := cm.startGoroutine("promotion-check-"+session.ID, func() { cm.checkForPromotion(ctx, session) }); err != nil {
+		// Keep it as a secondary; it can still take over at the next rotation
+		shared.LogErrorf("ConnManager: Cannot check secondary session %s for promotion: %v", session.ID, err)
+		session.promotionPending = false
+		r.event(RotationFailed, err.Error())
+		session.rotation = nil
+	}
+	metrics.SetActiveSessions(len(cm.sessions))
 	cm.mu.Unlock()
 	
 	cm.clearLaunchState(false, true) // Success
-	shared.LogInfof("ConnManager: Successfully launched secondary session %s", session.ID)
 }
 
 // checkForPromotion monitors a secondary session and promotes it when ready
@@ -571,6 +983,19 @@ func (cm *ConnManager) checkForPromotion(ctx context.Context, secondary *Session
 			shared.LogErrorf("ConnManager: Panic in checkForPromotion: %v", r)
 		}
 	}()
+	defer func() {
+		cm.mu.Lock()
+		secondary.promotionPending = false
+		cm.mu.Unlock()
+	}()
+	
+	// failRotation ends the secondary's rotation, leaving it free to take over later
+	failRotation := func(detail string) {
+		cm.mu.Lock()
+		defer cm.mu.Unlock()
+		secondary.rotation.event(RotationFailed, detail)
+		secondary.rotation = nil
+	}
 	
 	// Wait longer for the secondary to establish health and verify multiple health checks
 	healthCheckCount := 0
@@ -584,11 +1009,14 @@ func (cm *ConnManager) checkForPromotion(ctx context.Context, secondary *Session
 		select {
 		case <-timeout.C:
 			shared.LogInfof("ConnManager: Secondary session %s promotion timeout reached", secondary.ID)
+			failRotation("secondary not healthy before promotion timeout")
 			return
 		case <-ctx.Done():
+			failRotation("shutting down")
 			return
 		case <-secondary.QuicConn.Context().Done():
 			shared.LogInfof("ConnManager: Secondary session %s closed before promotion", secondary.ID)
+			failRotation("secondary closed before promotion")
 			return
 		case <-ticker.C:
 			if secondary.IsHealthy() {
@@ -597,6 +1025,9 @@ func (cm *ConnManager) checkForPromotion(ctx context.Context, secondary *Session
 				
 				// Require 3 consecutive successful health checks before promotion
 				if healthCheckCount >= 3 {
+					cm.mu.Lock()
+					secondary.rotation.event(RotationSecondaryHealthy, "3 consecutive health checks passed")
+					cm.mu.Unlock()
 					shared.LogInfof("ConnManager: Promoting secondary session %s to primary", secondary.ID)
 					cm.promoteSecondary(secondary)
 					return
@@ -614,6 +1045,8 @@ func (cm *ConnManager) checkForPromotion(ctx context.Context, secondary *Session
 // promoteSecondary promotes a secondary session to primary
 func (cm *ConnManager) promoteSecondary(secondary *Session) {
 	var oldPrimary *Session
+	var r *rotation
+	promoted := false
 	
 	// Critical section: promote sessions atomically
 	func() {
@@ -621,8 +1054,11 @@ func (cm *ConnManager) promoteSecondary(secondary *Session) {
 		defer cm.mu.Unlock()
 		
 		// Verify the secondary is still healthy before promotion
-		if !secondary.IsHealthy() {
-			shared.LogInfof("ConnManager: Secondary session %s no longer healthy, skipping promotion", secondary.ID)
+		r = secondary.rotation
+		secondary.rotation = nil
+		if !secondary.IsSecondary() || !secondary.IsHealthy() || secondary.IsExpiring() {
+			shared.LogInfof("ConnManager: Session %s no longer a healthy secondary, skipping promotion", secondary.ID)
+			r.event(RotationFailed, "secondary no longer healthy")
 			return
 		}
 		
@@ -634,22 +1070,36 @@ func (cm *ConnManager) promoteSecondary(secondary *Session) {
 			}
 		}
 		
+		// With several secondaries another may already have taken over; only
+		// replace a primary that is due for rotation or unhealthy
+		if oldPrimary != nil && oldPrimary.IsHealthy() && !cm.dueForRotation(oldPrimary) {
+			shared.LogInfof("ConnManager: Primary session %s is not due for rotation, keeping %s as secondary", oldPrimary.ID, secondary.ID)
+			r.event(RotationFailed, fmt.Sprintf("primary %s already replaced", oldPrimary.ID))
+			return
+		}
+		
 		// Promote secondary to primary first (atomic operation)
 		secondary.Role = RolePrimary
-		shared.LogInfof("ConnManager: Session %s promoted to primary", secondary.ID)
+		promoted = true
+		r.event(RotationPromoted, secondary.ID)
+		cm.publish(events.SessionPromoted, secondary, cm.primaryChanged(secondary))
 		
 		// Then demote old primary to draining
 		if oldPrimary != nil {
 			oldPrimary.Role = RoleDraining
-			shared.LogInfof("ConnManager: Session %s demoted to draining", oldPrimary.ID)
+			cm.publish(events.SessionDraining, oldPrimary, "replaced by "+secondary.ID)
 		}
 	}()
 	
+	if !promoted {
+		return
+	}
+	
 	// Start drain cleanup AFTER releasing the lock to avoid deadlock
 	if oldPrimary != nil {
-		cm.startGoroutine(fmt.Sprintf("drain-cleanup-%s", oldPrimary.ID), func() {
-			cm.scheduleDrainCleanup(oldPrimary)
-		})
+		cm.startDrainCleanup(oldPrimary, r)
+	} else {
+		r.event(RotationDrained, "no previous primary to drain")
 	}
 	
 	metrics.RecordSessionRotation()
@@ -663,7 +1113,7 @@ func (cm *ConnManager) sendShutdownSignal(session *Session) {
 	}
 	
 	shared.LogInfof("ConnManager: Sending SHUTDOWN signal to session %s", session.ID)
-	if err := shared.WriteShutdown(session.ControlStream); err != nil {
+	if err := session.WriteControl(shared.WriteShutdown); err != nil {
 		shared.LogErrorf("ConnManager: Failed to send SHUTDOWN to session %s: %v", session.ID, err)
 		return
 	}
@@ -671,26 +1121,101 @@ func (cm *ConnManager) sendShutdownSignal(session *Session) {
 	shared.LogInfof("ConnManager: SHUTDOWN signal sent to session %s", session.ID)
 }
 
-// scheduleDrainCleanup schedules cleanup of a draining session
-func (cm *ConnManager) scheduleDrainCleanup(session *Session) {
-	shared.LogInfof("ConnManager: Starting drain cleanup for session %s (timeout: %v)", session.ID, cm.cfg.Rotation.DrainTimeout)
-	timer := time.NewTimer(cm.cfg.Rotation.DrainTimeout)
+// FlushDNS asks the Lambda of every live session to empty its DNS cache and
+// returns how many sessions were reached
+func (cm *ConnManager) FlushDNS() (int, error) {
+	var flushed int
+	var errs []error
+	for _, session := range cm.GetAllSessions() {
+		if !session.Protocol.Supports(shared.CapFlushDNS) {
+			continue
+		}
+		if err := session.WriteControl(shared.WriteFlushDNS); err != nil {
+			errs = append(errs, fmt.Errorf("session %s: %w", session.ID, err))
+			continue
+		}
+		flushed++
+	}
+	if flushed > 0 {
+		shared.LogInfof("ConnManager: Sent DNS flush to %d sessions", flushed)
+	}
+	return flushed, errors.Join(errs...)
+}
+
+// drainPollInterval is how often a streams drain checks for open streams
+var drainPollInterval = time.Second
+
+// startDrainCleanup runs scheduleDrainCleanup for session in the background.
+// Past the goroutine limit the session is shut down at once instead, so it
+// can't linger until its Lambda times out.
+func (cm *ConnManager) startDrainCleanup(session *Session, r *rotation) {
+	err := cm.startGoroutine(fmt.Sprintf("drain-cleanup-%s", session.ID), func() {
+		cm.scheduleDrainCleanup(session, r)
+	})
+	if err == nil {
+		return
+	}
+	shared.LogErrorf("ConnManager: Shutting down session %s without draining (%d streams open): %v", session.ID, session.ActiveStreams(), err)
+	cm.sendShutdownSignal(session)
+	session.Cancel()
+	r.event(RotationDrained, "shut down without draining: "+err.Error())
+}
+
+// scheduleDrainCleanup schedules cleanup of a draining session, completing
+// rotation r. Under the streams policy the session is shut down as soon as
+// its last stream closes, so rotation doesn't cut off long transfers.
+func (cm *ConnManager) scheduleDrainCleanup(session *Session, r *rotation) {
+	rotation := cm.rotationConfig()
+	timeout := rotation.DrainTimeout
+	var poll <-chan time.Time
+	if rotation.DrainPolicy == shared.DrainPolicyStreams {
+		timeout = rotation.MaxDrainWait
+		if timeout <= 0 {
+			timeout = shared.DefaultMaxDrainWait
+		}
+		ticker := time.NewTicker(drainPollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+	shared.LogInfof("ConnManager: Starting drain cleanup for session %s (timeout: %v, %d streams open)", session.ID, timeout, session.ActiveStreams())
+	timer := time.NewTimer(timeout)
 	defer timer.Stop()
 	
-	select {
-	case <-timer.C:
-		shared.LogInfof("ConnManager: Drain timeout reached for session %s, sending shutdown signal", session.ID)
-		// Send shutdown signal to Lambda after drain timeout
+	shutdown := func(detail string) {
+		// Send shutdown signal to Lambda
 		cm.sendShutdownSignal(session)
 		// Give Lambda a moment to exit cleanly
 		time.Sleep(500 * time.Millisecond)
 		// Then cancel the session
 		shared.LogInfof("ConnManager: Cancelling draining session %s", session.ID)
 		session.Cancel()
-	case <-session.QuicConn.Context().Done():
-		// Session closed naturally before timeout
-		shared.LogInfof("ConnManager: Session %s closed naturally during drain", session.ID)
-		return
+		r.event(RotationDrained, detail)
+	}
+	
+	for {
+		select {
+		case <-timer.C:
+			open := session.ActiveStreams()
+			shared.LogInfof("ConnManager: Drain timeout reached for session %s with %d streams open, sending shutdown signal", session.ID, open)
+			detail := "drain timeout reached"
+			if open > 0 {
+				detail = fmt.Sprintf("drain timeout reached, closing %d streams", open)
+			}
+			shutdown(detail)
+			return
+		case <-poll:
+			if session.ActiveStreams() > 0 {
+				continue
+			}
+			shared.LogInfof("ConnManager: All streams on draining session %s finished, sending shutdown signal", session.ID)
+			shutdown("all streams finished")
+			return
+		case <-session.QuicConn.Context().Done():
+			// Session closed naturally before timeout
+			shared.LogInfof("ConnManager: Session %s closed naturally during drain", session.ID)
+			r.event(RotationDrained, "closed during drain")
+			return
+		}
 	}
 }
 
@@ -704,13 +1229,7 @@ func (cm *ConnManager) canLaunchPrimary() bool {
 		return false
 	}
 	
-	// Add cooldown period to prevent rapid retries
-	cooldown := 5 * time.Second
-	if cm.launchState.failedAttempts > 2 {
-		cooldown = time.Duration(cm.launchState.failedAttempts) * 10 * time.Second
-	}
-	
-	if time.Since(cm.launchState.lastLaunchAttempt) < cooldown {
+	if time.Since(cm.launchState.lastLaunchAttempt) < cm.launchState.primaryCooldown() {
 		return false
 	}
 	
@@ -720,6 +1239,59 @@ func (cm *ConnManager) canLaunchPrimary() bool {
 	return true
 }
 
+// primaryCooldown is how long after a launch attempt the next primary launch
+// waits, growing with consecutive failures. The caller must hold ls.mu.
+func (ls *LaunchState) primaryCooldown() time.Duration {
+	// Add cooldown period to prevent rapid retries
+	if ls.failedAttempts > 2 {
+		return time.Duration(ls.failedAttempts) * 10 * time.Second
+	}
+	return 5 * time.Second
+}
+
+// LaunchReset describes the launch state cleared by ResetLaunchState
+type LaunchReset struct {
+	FailedAttempts int           `json:"failed_attempts"` // consecutive failed launches forgotten
+	CooldownLeft   time.Duration `json:"cooldown_left"`   // how long the next primary launch would have waited
+	LaunchPending  bool          `json:"launch_pending"`  // a launch was marked in progress
+	HasPrimary     bool          `json:"has_primary"`     // a primary is up, so nothing is launched now
+	OverBudget     bool          `json:"over_budget"`     // launches stay paused until the budget period ends
+}
+
+// ResetLaunchState forgets failed launch attempts, their cooldown and any
+// launch still marked in progress, then has the monitor launch a primary at
+// once if none is up. It's for launches wedged by a transient AWS outage;
+// source says who asked, for the log.
+func (cm *ConnManager) ResetLaunchState(source string) LaunchReset {
+	cm.launchState.mu.Lock()
+	reset := LaunchReset{
+		FailedAttempts: cm.launchState.failedAttempts,
+		LaunchPending:  cm.launchState.launchingPrimary || cm.launchState.launchingSecondary,
+	}
+	if left := cm.launchState.primaryCooldown() - time.Since(cm.launchState.lastLaunchAttempt); left > 0 {
+		reset.CooldownLeft = left
+	}
+	cm.launchState.failedAttempts = 0
+	cm.launchState.lastLaunchAttempt = time.Time{}
+	cm.launchState.launchingPrimary = false
+	cm.launchState.launchingSecondary = false
+	cm.launchState.mu.Unlock()
+	
+	cm.mu.RLock()
+	for _, session := range cm.sessions {
+		if session.IsPrimary() {
+			reset.HasPrimary = true
+		}
+	}
+	reset.OverBudget = cm.budgetExceeded
+	cm.mu.RUnlock()
+	
+	shared.LogInfof("ConnManager: Launch state reset by %s (%d failed attempts, %v cooldown left, launch pending: %v)",
+		source, reset.FailedAttempts, reset.CooldownLeft.Round(time.Second), reset.LaunchPending)
+	cm.kickMonitor()
+	return reset
+}
+
 // canLaunchSecondary checks if we can launch a secondary session (with cooldown)
 func (cm *ConnManager) canLaunchSecondary() bool {
 	cm.launchState.mu.Lock()
@@ -746,6 +1318,20 @@ func (cm *ConnManager) canLaunchSecondary() bool {
 	return true
 }
 
+// abortLaunch clears the launching flag set by canLaunchPrimary or
+// canLaunchSecondary for a launch that never started, without counting it
+// as a failure
+func (cm *ConnManager) abortLaunch(isPrimary bool) {
+	cm.launchState.mu.Lock()
+	defer cm.launchState.mu.Unlock()
+	
+	if isPrimary {
+		cm.launchState.launchingPrimary = false
+	} else {
+		cm.launchState.launchingSecondary = false
+	}
+}
+
 // clearLaunchState clears the launching state flags
 func (cm *ConnManager) clearLaunchState(isPrimary bool, success bool) {
 	cm.launchState.mu.Lock()
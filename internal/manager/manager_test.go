@@ -1,9 +1,16 @@
 package manager
 
 import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/config"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/cost"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/events"
 	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
 )
 
@@ -84,4 +91,367 @@ func TestGenerateSessionID(t *testing.T) {
 	if len(id1) == 0 {
 		t.Error("Expected non-empty session ID")
 	}
-}
\ No newline at end of file
+	
+	// IDs are UUIDv7s that sort in the order they were made, even within a millisecond
+	previous := id2
+	for i := 0; i < 5000; i++ {
+		id := shared.GenerateSessionID()
+		if id <= previous {
+			t.Fatalf("Expected %s to sort after %s", id, previous)
+		}
+		previous = id
+	}
+	if len(id1) != 36 || id1[14] != '7' || !strings.ContainsRune("89ab", rune(id1[19])) {
+		t.Errorf("Expected a UUIDv7, got %s", id1)
+	}
+	if created := time.UnixMilli(mustParseHex(t, id1[0:8]+id1[9:13])); time.Since(created) > time.Minute || time.Since(created) < 0 {
+		t.Errorf("Expected %s to carry the current time, got %v", id1, created)
+	}
+}
+
+// mustParseHex parses a hex number for a test
+func mustParseHex(t *testing.T, s string) int64 {
+	t.Helper()
+	n, err := strconv.ParseInt(s, 16, 64)
+	if err != nil {
+		t.Fatalf("Failed to parse %q: %v", s, err)
+	}
+	return n
+}
+
+func newPoolTestManager(secondaries, maxSessions int) *ConnManager {
+	cfg := &config.Config{
+		Rotation: config.RotationConfig{
+			OverlapWindow: time.Minute,
+			SessionTTL:    5 * time.Minute,
+			Secondaries:   secondaries,
+			MaxSessions:   maxSessions,
+		},
+	}
+	return New(cfg, nil)
+}
+
+func TestConnManager_PoolSizeDefaults(t *testing.T) {
+	cm := newPoolTestManager(0, 0)
+	if cm.poolSecondaries != shared.DefaultPoolSecondaries || cm.poolMaxSessions != shared.DefaultPoolMaxSessions {
+		t.Errorf("Expected default pool %d/%d, got %d/%d", shared.DefaultPoolSecondaries, shared.DefaultPoolMaxSessions,
+			cm.poolSecondaries, cm.poolMaxSessions)
+	}
+	
+	// MaxSessions is raised to fit the primary and every secondary
+	if cm := newPoolTestManager(3, 2); cm.poolMaxSessions != 4 {
+		t.Errorf("Expected max sessions raised to 4, got %d", cm.poolMaxSessions)
+	}
+}
+
+func TestConnManager_SetRotation(t *testing.T) {
+	cm := newPoolTestManager(1, 2)
+	rotation := cm.cfg.Rotation
+	rotation.Secondaries = 2
+	rotation.MaxSessions = 0
+	rotation.OverlapWindow = 30 * time.Second
+	cm.SetRotation(rotation)
+	
+	secondaries, maxSessions := rotation.PoolSize()
+	if cm.poolSecondaries != secondaries || cm.poolMaxSessions != maxSessions {
+		t.Errorf("Expected the reloaded pool size %d/%d, got %d/%d", secondaries, maxSessions, cm.poolSecondaries, cm.poolMaxSessions)
+	}
+	if got := cm.rotationConfig().OverlapWindow; got != 30*time.Second {
+		t.Errorf("Expected the reloaded overlap window, got %v", got)
+	}
+	if cm.cfg.Rotation.OverlapWindow != time.Minute {
+		t.Error("Expected SetRotation to leave the shared config alone")
+	}
+}
+
+func TestConnManager_GoroutineLimit(t *testing.T) {
+	cm := newPoolTestManager(0, 0)
+	if cm.maxGoroutines != shared.DefaultPoolMaxGoroutines {
+		t.Errorf("Expected default goroutine limit %d, got %d", shared.DefaultPoolMaxGoroutines, cm.maxGoroutines)
+	}
+	rotation := cm.cfg.Rotation
+	rotation.MaxGoroutines = 2
+	cm.SetRotation(rotation)
+	
+	release := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		if err := cm.startGoroutine("blocked", func() { <-release }); err != nil {
+			t.Fatalf("Expected goroutine %d to start, got %v", i+1, err)
+		}
+	}
+	if err := cm.startGoroutine("extra", func() {}); !errors.Is(err, ErrTooManyGoroutines) {
+		t.Errorf("Expected ErrTooManyGoroutines past the limit, got %v", err)
+	}
+	
+	// Finished goroutines free their slots
+	close(release)
+	cm.activeGoroutines.Wait()
+	if err := cm.startGoroutine("after", func() {}); err != nil {
+		t.Errorf("Expected a goroutine to start once the others finished, got %v", err)
+	}
+	cm.activeGoroutines.Wait()
+}
+
+func TestConnManager_CanAddSecondary(t *testing.T) {
+	primary := &Session{ID: "p", Role: RolePrimary}
+	secondary := &Session{ID: "s", Role: RoleSecondary}
+	draining := &Session{ID: "d", Role: RoleDraining}
+	
+	// Default pool: one secondary, and a draining session blocks a new one
+	cm := newPoolTestManager(0, 0)
+	cm.sessions = []*Session{primary}
+	if !cm.canAddSecondary() {
+		t.Error("Expected room for a secondary next to a lone primary")
+	}
+	cm.sessions = []*Session{primary, secondary}
+	if cm.canAddSecondary() {
+		t.Error("Expected default pool to allow only one secondary")
+	}
+	cm.sessions = []*Session{primary, draining}
+	if cm.canAddSecondary() {
+		t.Error("Expected draining session to use the default pool's last slot")
+	}
+	
+	// Larger pool: two secondaries plus a draining session
+	cm = newPoolTestManager(2, 4)
+	cm.sessions = []*Session{primary, secondary, draining}
+	if !cm.canAddSecondary() {
+		t.Error("Expected room for a second secondary")
+	}
+	cm.sessions = append(cm.sessions, &Session{ID: "s2", Role: RoleSecondary})
+	if cm.canAddSecondary() {
+		t.Error("Expected full pool to refuse another secondary")
+	}
+}
+
+func TestConnManager_ResetLaunchState(t *testing.T) {
+	cm := newPoolTestManager(0, 0)
+	cm.launchState.failedAttempts = 6
+	cm.launchState.lastLaunchAttempt = time.Now()
+	cm.launchState.launchingPrimary = true
+	if cm.canLaunchPrimary() {
+		t.Fatal("Expected a launch in progress to block another")
+	}
+	
+	reset := cm.ResetLaunchState("test")
+	if reset.FailedAttempts != 6 || !reset.LaunchPending || reset.CooldownLeft < 50*time.Second || reset.HasPrimary {
+		t.Errorf("Unexpected reset: %+v", reset)
+	}
+	select {
+	case <-cm.kick:
+	default:
+		t.Error("Expected the monitor to be asked to check the sessions")
+	}
+	if !cm.canLaunchPrimary() {
+		t.Error("Expected a primary launch to be allowed at once after the reset")
+	}
+	
+	// Resetting again while the monitor hasn't caught up doesn't block
+	cm.ResetLaunchState("test")
+	cm.ResetLaunchState("test")
+}
+
+func TestConnManager_RotationCandidate(t *testing.T) {
+	cm := newPoolTestManager(2, 3)
+	now := time.Now()
+	older := &Session{ID: "older", Role: RoleSecondary, StartedAt: now.Add(-2 * time.Minute), TTL: 5 * time.Minute}
+	newer := &Session{ID: "newer", Role: RoleSecondary, StartedAt: now, TTL: 5 * time.Minute}
+	expiring := &Session{ID: "expiring", Role: RoleSecondary, StartedAt: now.Add(-4*time.Minute - 30*time.Second), TTL: 5 * time.Minute}
+	
+	cm.sessions = []*Session{{ID: "p", Role: RolePrimary}, expiring, older, newer}
+	if got := cm.rotationCandidate(); got != newer {
+		t.Errorf("Expected the secondary with most TTL left, got %v", got)
+	}
+	
+	cm.sessions = []*Session{{ID: "p", Role: RolePrimary}, expiring}
+	if got := cm.rotationCandidate(); got != nil {
+		t.Errorf("Expected no candidate inside the overlap window, got %s", got.ID)
+	}
+}
+
+func TestConnManager_PromoteSecondaryKeepsFreshPrimary(t *testing.T) {
+	cm := newPoolTestManager(2, 3)
+	primary := &Session{ID: "p", Role: RolePrimary, StartedAt: time.Now(), TTL: 5 * time.Minute}
+	primary.SetHealthy(true)
+	secondary := &Session{ID: "s", Role: RoleSecondary, StartedAt: time.Now(), TTL: 5 * time.Minute}
+	secondary.SetHealthy(true)
+	cm.sessions = []*Session{primary, secondary}
+	
+	cm.promoteSecondary(secondary)
+	if !primary.IsPrimary() || !secondary.IsSecondary() {
+		t.Errorf("Expected a primary not due for rotation to be kept, got roles %s/%s", primary.Role, secondary.Role)
+	}
+	
+	// Without a primary the secondary takes over
+	cm.sessions = []*Session{secondary}
+	cm.promoteSecondary(secondary)
+	if !secondary.IsPrimary() {
+		t.Errorf("Expected secondary to be promoted when there is no primary, got %s", secondary.Role)
+	}
+	
+	if got := cm.SessionsByRole(RolePrimary); len(got) != 1 || got[0] != secondary {
+		t.Errorf("Expected SessionsByRole to return the new primary, got %v", got)
+	}
+}
+
+// recordingNotifier keeps the notifications sent to it
+type recordingNotifier struct {
+	sent []string
+}
+
+func (n *recordingNotifier) Notify(title, message string) {
+	n.sent = append(n.sent, title+": "+message)
+}
+
+// subscribeHandler returns a function that hands the events cm published
+// since the last call to cm's handler, as its bus subscription would
+func subscribeHandler(t *testing.T, cm *ConnManager) func() {
+	sub := cm.events.Subscribe()
+	t.Cleanup(sub.Close)
+	return func() {
+		for {
+			select {
+			case e := <-sub.C:
+				cm.handleEvent(e)
+			default:
+				return
+			}
+		}
+	}
+}
+
+func TestConnManager_Notifications(t *testing.T) {
+	cm := newPoolTestManager(1, 2)
+	notifier := &recordingNotifier{}
+	cm.SetNotifier(notifier)
+	handlePublished := subscribeHandler(t, cm)
+	
+	// The first primary isn't a change; later ones report the new egress IP
+	first := &Session{ID: "first", Role: RolePrimary, LambdaPublicIP: "203.0.113.1"}
+	cm.publish(events.SessionLaunched, first, cm.primaryChanged(first))
+	second := &Session{ID: "second", Role: RolePrimary, LambdaPublicIP: "203.0.113.2"}
+	cm.publish(events.SessionPromoted, second, cm.primaryChanged(second))
+	cm.publish(events.SessionDraining, first, "replaced by second")
+	handlePublished()
+	if len(notifier.sent) != 1 || notifier.sent[0] != "Session rotated: Egress IP is now 203.0.113.2 (was 203.0.113.1)" {
+		t.Errorf("Expected one rotation notification, got %q", notifier.sent)
+	}
+	
+	// Losing the primary while another session is up is reported, losing a
+	// secondary isn't
+	notifier.sent = nil
+	for _, e := range []events.Event{
+		cm.event(events.SessionClosed, &Session{ID: "third", Role: RoleSecondary, LambdaPublicIP: "203.0.113.3"}, "closed"),
+		cm.event(events.SessionClosed, second, "stopped answering health checks"),
+	} {
+		e.Fields["sessions_up"] = true
+		cm.events.Publish(e)
+	}
+	handlePublished()
+	if len(notifier.sent) != 1 || notifier.sent[0] != "Session lost: Lambda at 203.0.113.2 stopped answering health checks; reconnecting" {
+		t.Errorf("Expected one lost session notification, got %q", notifier.sent)
+	}
+	
+	// The budget warning is given once per period, then the cap is reported
+	notifier.sent = nil
+	cm.budget = cost.NewBudgetTracker(config.BudgetLimits{Period: config.BudgetPeriodDay, MaxSpend: 100}, cost.Pricing{LambdaGBSecond: 1}, 1024)
+	cm.budget.AddSession(85 * time.Second)
+	cm.overBudget()
+	cm.overBudget()
+	cm.budget.AddSession(20 * time.Second)
+	cm.overBudget()
+	handlePublished()
+	if len(notifier.sent) != 2 || !strings.HasPrefix(notifier.sent[0], "Budget nearly reached: 85%") || !strings.HasPrefix(notifier.sent[1], "Budget reached") {
+		t.Errorf("Expected a budget warning and then the cap, got %q", notifier.sent)
+	}
+	
+	// Falling back to the relay is reported
+	notifier.sent = nil
+	cm.events.Publish(events.Event{Type: events.RelayFallback, Message: "NAT hole punching failed (UDP may be blocked); sessions go through the relay at wss://relay.example.com", Fields: map[string]interface{}{"region": cm.cfg.AWSRegion}})
+	handlePublished()
+	if len(notifier.sent) != 1 || !strings.HasPrefix(notifier.sent[0], "Relay mode active: NAT hole punching failed") {
+		t.Errorf("Expected a relay mode notification, got %q", notifier.sent)
+	}
+	
+	// Events of other regions on a shared bus are left to their managers
+	notifier.sent = nil
+	cm.events.Publish(events.Event{Type: events.BudgetExceeded, Fields: map[string]interface{}{"region": "elsewhere"}})
+	handlePublished()
+	if len(notifier.sent) != 0 {
+		t.Errorf("Expected no notification for another region, got %q", notifier.sent)
+	}
+}
+
+func TestConnManager_OutageNotifications(t *testing.T) {
+	cm := newPoolTestManager(1, 3)
+	notifier := &recordingNotifier{}
+	cm.SetNotifier(notifier)
+	handlePublished := subscribeHandler(t, cm)
+	cm.primaryChanged(&Session{ID: "first", LambdaPublicIP: "203.0.113.1"})
+	// Keep checkSessions from launching replacements, which needs a launcher
+	cm.evacuatedUntil = time.Now().Add(time.Minute)
+	
+	// Losing the primary with a healthy secondary left is a lost session
+	primary, _ := newControlTestSession("primary-1", RolePrimary)
+	secondary, _ := newControlTestSession("secondary-1", RoleSecondary)
+	primary.Cancel()
+	cm.sessions = []*Session{primary, secondary}
+	cm.checkSessions(context.Background())
+	handlePublished()
+	if len(notifier.sent) != 1 || !strings.HasPrefix(notifier.sent[0], "Session lost") {
+		t.Errorf("Expected a lost session, got %q", notifier.sent)
+	}
+	
+	// Losing it with nothing left is an outage, reported once
+	notifier.sent = nil
+	for i := 0; i < 2; i++ {
+		lost, _ := newControlTestSession("primary-2", RolePrimary)
+		lost.Cancel()
+		cm.sessions = []*Session{lost}
+		cm.checkSessions(context.Background())
+	}
+	handlePublished()
+	if len(notifier.sent) != 1 || !strings.HasPrefix(notifier.sent[0], "All sessions down") {
+		t.Errorf("Expected one outage notification, got %q", notifier.sent)
+	}
+	
+	// The next primary ends it
+	notifier.sent = nil
+	third := &Session{ID: "third", Role: RolePrimary, LambdaPublicIP: "203.0.113.3"}
+	cm.publish(events.SessionLaunched, third, cm.primaryChanged(third))
+	handlePublished()
+	if len(notifier.sent) != 1 || notifier.sent[0] != "Tunnel restored: Egress IP is now 203.0.113.3 (was 203.0.113.1)" || cm.tunnelDown {
+		t.Errorf("Expected the tunnel to be restored, got %q", notifier.sent)
+	}
+	
+	// Only the third rotation failing in a row is reported
+	notifier.sent = nil
+	for i := 0; i < 4; i++ {
+		cm.rotations.start("third").event(RotationFailed, "launch failed")
+	}
+	handlePublished()
+	if len(notifier.sent) != 1 || !strings.HasPrefix(notifier.sent[0], "Rotation failing: 3 session rotations") {
+		t.Errorf("Expected one rotation failure notification, got %q", notifier.sent)
+	}
+}
+
+func TestConnManager_TunnelPool(t *testing.T) {
+	primary := &Session{ID: "p", Role: RolePrimary, healthy: true}
+	draining := &Session{ID: "d", Role: RoleDraining, healthy: true}
+	cm := newPoolTestManager(0, 0)
+	cm.sessions = []*Session{draining, primary}
+	
+	pool := cm.TunnelPool()
+	if got := pool.Primary(); got == nil || got.SessionID() != "p" || !got.Usable() {
+		t.Fatalf("Expected usable primary p, got %v", got)
+	}
+	sessions := pool.Sessions()
+	if len(sessions) != 2 || sessions[0].SessionID() != "d" || sessions[0].Usable() {
+		t.Errorf("Expected draining session d to be listed as unusable, got %v", sessions)
+	}
+	
+	primary.MarkExpiring()
+	if pool.Primary() != nil {
+		t.Error("Expected no primary once the only usable session is expiring")
+	}
+}
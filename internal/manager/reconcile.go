@@ -0,0 +1,122 @@
+package manager
+
+import (
+	"sync"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// unmatchedReports is how many Lambda reports a finished stream waits in
+// for its other end before the reconciler gives up on it
+const unmatchedReports = 10
+
+// maxPendingCounts bounds the streams waiting for their other end, so a
+// Lambda that never reports can't grow the reconciler without limit
+const maxPendingCounts = 8192
+
+// Direction names which way a tunnel's bytes went
+const (
+	DirectionUp   = "up"   // orchestrator to Lambda
+	DirectionDown = "down" // Lambda to orchestrator
+)
+
+// Mismatch is a direction of a stream whose two ends counted different bytes
+type Mismatch struct {
+	StreamID  uint64
+	Direction string
+	Sent      uint64 // bytes the sending end wrote
+	Received  uint64 // bytes the receiving end read
+}
+
+// Reconciliation is what one Lambda report settled
+type Reconciliation struct {
+	Matched    int        // streams whose counts were compared
+	Mismatches []Mismatch // directions whose counts differed
+	Unmatched  int        // streams given up on without their other end
+}
+
+// pendingCount is one end's counts of a stream still waiting for the other
+type pendingCount struct {
+	bytes shared.StreamBytes
+	age   int // reports seen since it was added
+}
+
+// ByteReconciler compares the byte counts each end of a session kept for
+// its finished tunnel streams. A direction is only compared when its
+// receiver saw the sender's FIN, since a reset stream drops bytes in flight.
+type ByteReconciler struct {
+	mu     sync.Mutex
+	local  map[uint64]*pendingCount
+	remote map[uint64]*pendingCount
+}
+
+// NewByteReconciler creates an empty reconciler
+func NewByteReconciler() *ByteReconciler {
+	return &ByteReconciler{
+		local:  make(map[uint64]*pendingCount),
+		remote: make(map[uint64]*pendingCount),
+	}
+}
+
+// Finished records the orchestrator's counts for a tunnel stream that ended
+func (r *ByteReconciler) Finished(local shared.StreamBytes) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.local) >= maxPendingCounts {
+		return
+	}
+	r.local[local.StreamID] = &pendingCount{bytes: local}
+}
+
+// Reconcile compares the Lambda's reported counts with the orchestrator's,
+// keeping either side's counts that have no match yet for later reports
+func (r *ByteReconciler) Reconcile(remote []shared.StreamBytes) Reconciliation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result Reconciliation
+	for _, counts := range remote {
+		if len(r.remote) < maxPendingCounts {
+			r.remote[counts.StreamID] = &pendingCount{bytes: counts}
+		}
+	}
+	for id, theirs := range r.remote {
+		ours, ok := r.local[id]
+		if !ok {
+			continue
+		}
+		result.Matched++
+		result.Mismatches = append(result.Mismatches, compareCounts(ours.bytes, theirs.bytes)...)
+		delete(r.local, id)
+		delete(r.remote, id)
+	}
+	result.Unmatched += expire(r.local)
+	result.Unmatched += expire(r.remote)
+	return result
+}
+
+// compareCounts returns the directions in which ours and theirs disagree
+func compareCounts(ours, theirs shared.StreamBytes) []Mismatch {
+	var mismatches []Mismatch
+	if theirs.ReceivedEOF && theirs.Received != ours.Sent {
+		mismatches = append(mismatches, Mismatch{StreamID: ours.StreamID, Direction: DirectionUp, Sent: ours.Sent, Received: theirs.Received})
+	}
+	if ours.ReceivedEOF && ours.Received != theirs.Sent {
+		mismatches = append(mismatches, Mismatch{StreamID: ours.StreamID, Direction: DirectionDown, Sent: theirs.Sent, Received: ours.Received})
+	}
+	return mismatches
+}
+
+// expire ages the counts in pending by one report and drops those that
+// waited too long, returning how many it dropped
+func expire(pending map[uint64]*pendingCount) int {
+	dropped := 0
+	for id, p := range pending {
+		p.age++
+		if p.age > unmatchedReports {
+			delete(pending, id)
+			dropped++
+		}
+	}
+	return dropped
+}
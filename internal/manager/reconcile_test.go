@@ -0,0 +1,47 @@
+package manager
+
+import (
+	"testing"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+func TestByteReconcilerMatchesStreams(t *testing.T) {
+	r := NewByteReconciler()
+	r.Finished(shared.StreamBytes{StreamID: 0, Sent: 100, Received: 2000, ReceivedEOF: true})
+	r.Finished(shared.StreamBytes{StreamID: 4, Sent: 50, Received: 700, ReceivedEOF: true})
+	r.Finished(shared.StreamBytes{StreamID: 8, Sent: 10, Received: 5}) // reset before the Lambda's FIN
+
+	result := r.Reconcile([]shared.StreamBytes{
+		{StreamID: 0, Received: 100, Sent: 2000, ReceivedEOF: true},
+		{StreamID: 4, Received: 50, Sent: 900, ReceivedEOF: true},
+		{StreamID: 8, Received: 10, Sent: 90, ReceivedEOF: true},
+		{StreamID: 12, Received: 1, Sent: 1}, // not finished here yet
+	})
+	if result.Matched != 3 {
+		t.Errorf("Expected 3 matched streams, got %d", result.Matched)
+	}
+	want := Mismatch{StreamID: 4, Direction: DirectionDown, Sent: 900, Received: 700}
+	if len(result.Mismatches) != 1 || result.Mismatches[0] != want {
+		t.Fatalf("Expected mismatch %+v, got %+v", want, result.Mismatches)
+	}
+
+	// The Lambda reported stream 12 before it finished here
+	r.Finished(shared.StreamBytes{StreamID: 12, Sent: 1, Received: 1})
+	if result := r.Reconcile(nil); result.Matched != 1 || len(result.Mismatches) != 0 {
+		t.Errorf("Expected stream 12 to match on the next report, got %+v", result)
+	}
+}
+
+func TestByteReconcilerDropsUnmatched(t *testing.T) {
+	r := NewByteReconciler()
+	r.Finished(shared.StreamBytes{StreamID: 16, Sent: 1})
+
+	unmatched := 0
+	for i := 0; i <= unmatchedReports; i++ {
+		unmatched += r.Reconcile(nil).Unmatched
+	}
+	if unmatched != 1 {
+		t.Errorf("Expected the stream to be dropped once, got %d", unmatched)
+	}
+}
@@ -0,0 +1,155 @@
+package manager
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/metrics"
+)
+
+// Rotation stage constants, in the order a successful rotation passes them
+const (
+	RotationStarted           = "started"
+	RotationSecondaryLaunched = "secondary_launched"
+	RotationSecondaryHealthy  = "secondary_healthy"
+	RotationPromoted          = "promoted"
+	RotationDrained           = "drained"
+	RotationFailed            = "failed"
+)
+
+// Rotation outcome constants
+const (
+	RotationInProgress = "in_progress"
+	RotationSucceeded  = "succeeded"
+	RotationFailure    = "failed"
+)
+
+// maxRotationRecords bounds how many rotations the timeline keeps
+const maxRotationRecords = 50
+
+// rotationFailureAlert is how many rotations must fail in a row before the
+// timeline's onFailures is called
+const rotationFailureAlert = 3
+
+// RotationEvent is one stage reached by a rotation attempt
+type RotationEvent struct {
+	Stage   string        `json:"stage"`
+	At      time.Time     `json:"at"`
+	Elapsed time.Duration `json:"elapsed"` // since the rotation started
+	Detail  string        `json:"detail,omitempty"`
+}
+
+// RotationRecord describes one attempt to replace the primary session
+type RotationRecord struct {
+	ID         int             `json:"id"`
+	OldPrimary string          `json:"old_primary"`
+	NewSession string          `json:"new_session,omitempty"`
+	StartedAt  time.Time       `json:"started_at"`
+	Outcome    string          `json:"outcome"`
+	Duration   time.Duration   `json:"duration"` // until success or failure; zero while in progress
+	Events     []RotationEvent `json:"events"`
+}
+
+// rotationTimeline keeps the most recent rotation attempts
+type rotationTimeline struct {
+	mu      sync.Mutex
+	nextID  int
+	records []*RotationRecord // oldest first
+
+	// failedInRow counts rotations failed since the last success. When it
+	// reaches rotationFailureAlert, onFailures is called with the last
+	// failure's detail.
+	failedInRow int
+	onFailures  func(failed int, detail string)
+}
+
+// rotation is a handle used to add events to one record in a timeline. A nil
+// *rotation ignores all events, so callers need not check for it.
+type rotation struct {
+	timeline *rotationTimeline
+	record   *RotationRecord
+}
+
+// start records a new rotation attempt away from oldPrimary
+func (t *rotationTimeline) start(oldPrimary string) *rotation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.nextID++
+	now := time.Now()
+	record := &RotationRecord{
+		ID:         t.nextID,
+		OldPrimary: oldPrimary,
+		StartedAt:  now,
+		Outcome:    RotationInProgress,
+		Events:     []RotationEvent{{Stage: RotationStarted, At: now}},
+	}
+	t.records = append(t.records, record)
+	if len(t.records) > maxRotationRecords {
+		t.records = t.records[len(t.records)-maxRotationRecords:]
+	}
+	metrics.RecordRotationAttempt()
+
+	return &rotation{timeline: t, record: record}
+}
+
+// event adds stage to the rotation, finishing it on drained or failed
+func (r *rotation) event(stage, detail string) {
+	if r == nil {
+		return
+	}
+	r.timeline.mu.Lock()
+	defer r.timeline.mu.Unlock()
+
+	record := r.record
+	if record.Outcome != RotationInProgress {
+		return
+	}
+	now := time.Now()
+	record.Events = append(record.Events, RotationEvent{
+		Stage:   stage,
+		At:      now,
+		Elapsed: now.Sub(record.StartedAt),
+		Detail:  detail,
+	})
+
+	switch stage {
+	case RotationDrained:
+		record.Outcome = RotationSucceeded
+		record.Duration = now.Sub(record.StartedAt)
+		metrics.RecordRotationDuration(record.Duration)
+		r.timeline.failedInRow = 0
+	case RotationFailed:
+		record.Outcome = RotationFailure
+		record.Duration = now.Sub(record.StartedAt)
+		metrics.RecordRotationFailure()
+		r.timeline.failedInRow++
+		if r.timeline.failedInRow == rotationFailureAlert && r.timeline.onFailures != nil {
+			r.timeline.onFailures(r.timeline.failedInRow, detail)
+		}
+	}
+}
+
+// setSession notes the secondary session taking over in the rotation
+func (r *rotation) setSession(sessionID string) {
+	if r == nil {
+		return
+	}
+	r.timeline.mu.Lock()
+	r.record.NewSession = sessionID
+	r.timeline.mu.Unlock()
+}
+
+// snapshot returns copies of the kept records, newest first
+func (t *rotationTimeline) snapshot() []RotationRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	records := make([]RotationRecord, 0, len(t.records))
+	for i := len(t.records) - 1; i >= 0; i-- {
+		record := *t.records[i]
+		record.Events = append([]RotationEvent(nil), record.Events...)
+		records = append(records, record)
+	}
+	return records
+}
@@ -0,0 +1,217 @@
+package manager
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/features"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+	"github.com/quic-go/quic-go"
+)
+
+func TestRotationTimeline_Outcomes(t *testing.T) {
+	timeline := &rotationTimeline{}
+	
+	ok := timeline.start("old")
+	ok.setSession("new")
+	ok.event(RotationSecondaryLaunched, "new")
+	ok.event(RotationSecondaryHealthy, "")
+	ok.event(RotationPromoted, "new")
+	ok.event(RotationDrained, "closed during drain")
+	ok.event(RotationFailed, "ignored once finished")
+	
+	failed := timeline.start("new")
+	failed.event(RotationFailed, "launch failed")
+	
+	records := timeline.snapshot()
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+	if records[0].ID != 2 || records[0].Outcome != RotationFailure {
+		t.Errorf("Expected newest record to be the failed rotation, got %+v", records[0])
+	}
+	
+	succeeded := records[1]
+	if succeeded.Outcome != RotationSucceeded || succeeded.NewSession != "new" || succeeded.OldPrimary != "old" {
+		t.Errorf("Unexpected successful rotation record: %+v", succeeded)
+	}
+	stages := []string{RotationStarted, RotationSecondaryLaunched, RotationSecondaryHealthy, RotationPromoted, RotationDrained}
+	if len(succeeded.Events) != len(stages) {
+		t.Fatalf("Expected %d events, got %+v", len(stages), succeeded.Events)
+	}
+	for i, stage := range stages {
+		if succeeded.Events[i].Stage != stage {
+			t.Errorf("Event %d: expected stage %s, got %s", i, stage, succeeded.Events[i].Stage)
+		}
+	}
+	if succeeded.Duration != succeeded.Events[len(stages)-1].Elapsed {
+		t.Errorf("Expected duration to match the drained event, got %v", succeeded.Duration)
+	}
+	
+	// Nil rotations ignore events
+	var none *rotation
+	none.setSession("x")
+	none.event(RotationFailed, "")
+}
+
+func TestRotationTimeline_KeepsRecentRecords(t *testing.T) {
+	timeline := &rotationTimeline{}
+	for i := 0; i < maxRotationRecords+5; i++ {
+		timeline.start("p")
+	}
+	
+	records := timeline.snapshot()
+	if len(records) != maxRotationRecords {
+		t.Fatalf("Expected %d records, got %d", maxRotationRecords, len(records))
+	}
+	if records[0].ID != maxRotationRecords+5 {
+		t.Errorf("Expected newest record first, got ID %d", records[0].ID)
+	}
+}
+
+func TestConnManager_PromotionRecordsRotation(t *testing.T) {
+	cm := newPoolTestManager(1, 2)
+	secondary := &Session{ID: "s", Role: RoleSecondary, StartedAt: time.Now(), TTL: 5 * time.Minute}
+	secondary.SetHealthy(true)
+	secondary.rotation = cm.rotations.start("gone")
+	cm.sessions = []*Session{secondary}
+	
+	cm.promoteSecondary(secondary)
+	
+	records := cm.Rotations()
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 rotation, got %d", len(records))
+	}
+	last := records[0].Events[len(records[0].Events)-1]
+	if records[0].Outcome != RotationSucceeded || last.Stage != RotationDrained {
+		t.Errorf("Expected promotion without a primary to complete the rotation, got %+v", records[0])
+	}
+	if secondary.rotation != nil {
+		t.Error("Expected the promoted session to release its rotation")
+	}
+}
+
+// drainConn is a QUIC connection that only reports its context
+type drainConn struct {
+	quic.Connection
+	ctx context.Context
+}
+
+func (c drainConn) Context() context.Context { return c.ctx }
+
+func TestSession_TrackStream(t *testing.T) {
+	session := &Session{ID: "s"}
+	done := session.TrackStream()
+	session.TrackStream()
+	if n := session.ActiveStreams(); n != 2 {
+		t.Fatalf("Expected 2 active streams, got %d", n)
+	}
+	done()
+	done()
+	if n := session.ActiveStreams(); n != 1 {
+		t.Errorf("Expected a stream to be released once, got %d active", n)
+	}
+}
+
+func TestSession_StreamConnSkipsClosedStripes(t *testing.T) {
+	closed, cancel := context.WithCancel(context.Background())
+	cancel()
+	primaryCtx, stopPrimary := context.WithCancel(context.Background())
+	defer stopPrimary()
+	openCtx, stopOpen := context.WithCancel(context.Background())
+	defer stopOpen()
+	primary := drainConn{ctx: primaryCtx}
+	open := drainConn{ctx: openCtx}
+	session := &Session{
+		ID:       "s",
+		QuicConn: primary,
+		Stripes:  []quic.Connection{open, drainConn{ctx: closed}},
+	}
+	
+	used := map[quic.Connection]int{}
+	for i := 0; i < 6; i++ {
+		used[session.StreamConn()]++
+	}
+	if used[primary] != 4 || used[open] != 2 {
+		t.Errorf("Expected the closed stripe's turns to go to the primary, got %v", used)
+	}
+	
+	features.Set(features.Striping, false, "the test")
+	defer features.Set(features.Striping, true, "the test")
+	for i := 0; i < 3; i++ {
+		if session.StreamConn() != primary {
+			t.Fatal("Expected only the primary connection with striping off")
+		}
+	}
+}
+
+func TestConnManager_StreamsDrainWaitsForStreams(t *testing.T) {
+	oldInterval := drainPollInterval
+	drainPollInterval = 10 * time.Millisecond
+	defer func() { drainPollInterval = oldInterval }()
+
+	cm := newPoolTestManager(1, 2)
+	rotation := cm.cfg.Rotation
+	rotation.DrainTimeout = 10 * time.Millisecond
+	rotation.DrainPolicy = shared.DrainPolicyStreams
+	rotation.MaxDrainWait = time.Minute
+	cm.SetRotation(rotation)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	session := &Session{ID: "old", QuicConn: drainConn{ctx: ctx}, Cancel: cancel}
+	release := session.TrackStream()
+	r := cm.rotations.start("old")
+
+	finished := make(chan struct{})
+	go func() {
+		cm.scheduleDrainCleanup(session, r)
+		close(finished)
+	}()
+
+	// The open stream outlives the timer drain timeout
+	select {
+	case <-finished:
+		t.Fatal("Expected the drain to wait for the open stream")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	release()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the drain to finish once the stream closed")
+	}
+	if ctx.Err() == nil {
+		t.Error("Expected the drained session to be cancelled")
+	}
+	records := cm.Rotations()
+	last := records[0].Events[len(records[0].Events)-1]
+	if last.Stage != RotationDrained || last.Detail != "all streams finished" {
+		t.Errorf("Unexpected drained event: %+v", last)
+	}
+}
+
+func TestConnManager_StreamsDrainMaxWait(t *testing.T) {
+	cm := newPoolTestManager(1, 2)
+	rotation := cm.cfg.Rotation
+	rotation.DrainPolicy = shared.DrainPolicyStreams
+	rotation.MaxDrainWait = 20 * time.Millisecond
+	cm.SetRotation(rotation)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	session := &Session{ID: "old", QuicConn: drainConn{ctx: ctx}, Cancel: cancel}
+	session.TrackStream()
+	session.TrackStream()
+
+	cm.scheduleDrainCleanup(session, cm.rotations.start("old"))
+
+	records := cm.Rotations()
+	last := records[0].Events[len(records[0].Events)-1]
+	if !strings.HasPrefix(last.Detail, "drain timeout reached") || !strings.Contains(last.Detail, "2 streams") {
+		t.Errorf("Expected the timeout to report the streams cut off, got %+v", last)
+	}
+}
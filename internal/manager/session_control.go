@@ -0,0 +1,208 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/events"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/metrics"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// ErrSessionNotFound is returned for a session ID the manager doesn't hold
+var ErrSessionNotFound = errors.New("session not found")
+
+// findSession returns the session whose ID is id, or starts or ends with
+// it: IDs made close together share their leading timestamp, so the random
+// end is often the shorter way to name one. The caller must hold cm.mu.
+func (cm *ConnManager) findSession(id string) (*Session, error) {
+	if id == "" {
+		return nil, ErrSessionNotFound
+	}
+	var found *Session
+	for _, session := range cm.sessions {
+		if session.ID == id {
+			return session, nil
+		}
+		if strings.HasPrefix(session.ID, id) || strings.HasSuffix(session.ID, id) {
+			if found != nil {
+				return nil, fmt.Errorf("session ID %q is ambiguous", id)
+			}
+			found = session
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+	return found, nil
+}
+
+// kickMonitor makes the monitor check the sessions now
+func (cm *ConnManager) kickMonitor() {
+	select {
+	case cm.kick <- struct{}{}:
+	default:
+	}
+}
+
+// RotateNow rotates the primary session without waiting for its TTL: a
+// replacement is launched, or a warm secondary promoted, and the primary
+// drains once it takes over. It returns the primary's ID. source says who
+// asked, for the log.
+func (cm *ConnManager) RotateNow(source string) (string, error) {
+	cm.mu.Lock()
+	var primary *Session
+	for _, session := range cm.sessions {
+		if session.IsPrimary() {
+			primary = session
+			break
+		}
+	}
+	if primary == nil {
+		cm.mu.Unlock()
+		return "", errors.New("no primary session to rotate")
+	}
+	primary.rotateEarly = true
+	cm.mu.Unlock()
+
+	shared.LogInfof("ConnManager: Early rotation of primary session %s requested by %s", primary.ID, source)
+	cm.kickMonitor()
+	return primary.ID, nil
+}
+
+// DrainSession stops new tunnels using session id and shuts it down once its
+// open tunnels finish, under the drain policy. Draining the primary rotates
+// it early instead, so there is always a session to use. It returns the
+// session's full ID.
+func (cm *ConnManager) DrainSession(id, source string) (string, error) {
+	cm.mu.Lock()
+	session, err := cm.findSession(id)
+	if err != nil {
+		cm.mu.Unlock()
+		return "", err
+	}
+	switch {
+	case session.IsDraining():
+		cm.mu.Unlock()
+		return session.ID, fmt.Errorf("session %s is already draining", session.ID)
+	case session.IsPrimary():
+		cm.mu.Unlock()
+		return cm.RotateNow(source)
+	}
+	session.Role = RoleDraining
+	r := session.rotation
+	session.rotation = nil
+	cm.mu.Unlock()
+
+	r.event(RotationFailed, "secondary drained by "+source)
+	shared.LogInfof("ConnManager: Session %s drained by %s (%d streams open)", session.ID, source, session.ActiveStreams())
+	cm.startDrainCleanup(session, nil)
+	return session.ID, nil
+}
+
+// KillSession closes session id at once, cutting off its open tunnels. It's
+// for a session that is stuck; the monitor replaces a killed primary as it
+// would a lost one. It returns the session's full ID.
+func (cm *ConnManager) KillSession(id, source string) (string, error) {
+	cm.mu.Lock()
+	session, err := cm.findSession(id)
+	if err != nil {
+		cm.mu.Unlock()
+		return "", err
+	}
+	remaining := make([]*Session, 0, len(cm.sessions)-1)
+	for _, s := range cm.sessions {
+		if s != session {
+			remaining = append(remaining, s)
+		}
+	}
+	cm.sessions = remaining
+	metrics.SetActiveSessions(len(cm.sessions))
+	session.rotation.event(RotationFailed, "secondary killed by "+source)
+	session.rotation = nil
+	cm.mu.Unlock()
+
+	shared.LogInfof("ConnManager: Session %s (%s) killed by %s, closing %d streams", session.ID, session.Role, source, session.ActiveStreams())
+	cm.cleanupSession(session)
+	cm.kickMonitor()
+	return session.ID, nil
+}
+
+// Evacuate drains every session, the primary included, and launches none
+// in the manager's region until d passes or EndEvacuation is called. It's
+// for a regional outage, with traffic sent through another region's
+// sessions meanwhile. It returns the IDs of the sessions it drained.
+func (cm *ConnManager) Evacuate(d time.Duration, source string) []string {
+	until := time.Now().Add(d)
+	cm.mu.Lock()
+	cm.evacuatedUntil = until
+	var drained []*Session
+	for _, session := range cm.sessions {
+		if session.IsDraining() {
+			continue
+		}
+		session.Role = RoleDraining
+		cm.publish(events.SessionDraining, session, "region evacuated by "+source)
+		session.rotation.event(RotationFailed, "region evacuated by "+source)
+		session.rotation = nil
+		drained = append(drained, session)
+	}
+	cm.mu.Unlock()
+
+	cm.publish(events.RegionEvacuated, nil, fmt.Sprintf("No sessions in %s until %s; evacuated by %s, draining %d sessions",
+		cm.cfg.AWSRegion, until.Local().Format(time.Kitchen), source, len(drained)))
+	ids := make([]string, 0, len(drained))
+	for _, session := range drained {
+		cm.startDrainCleanup(session, nil)
+		ids = append(ids, session.ID)
+	}
+	return ids
+}
+
+// EndEvacuation lets the manager launch sessions again before its
+// evacuation expires. It reports whether the region was evacuated.
+func (cm *ConnManager) EndEvacuation(source string) bool {
+	cm.mu.Lock()
+	evacuated := time.Now().Before(cm.evacuatedUntil)
+	cm.evacuatedUntil = time.Time{}
+	cm.mu.Unlock()
+	if !evacuated {
+		return false
+	}
+
+	cm.publish(events.EvacuationEnded, nil, fmt.Sprintf("Evacuation of %s ended by %s, launching sessions again", cm.cfg.AWSRegion, source))
+	cm.kickMonitor()
+	return true
+}
+
+// EvacuatedUntil returns when the manager's evacuation ends, or the zero
+// time if its region isn't evacuated
+func (cm *ConnManager) EvacuatedUntil() time.Time {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	if time.Now().Before(cm.evacuatedUntil) {
+		return cm.evacuatedUntil
+	}
+	return time.Time{}
+}
+
+// Evacuated reports whether the manager's region is evacuated
+func (cm *ConnManager) Evacuated() bool {
+	return !cm.EvacuatedUntil().IsZero()
+}
+
+// evacuated reports whether an evacuation stops launches, publishing when
+// one expires. The caller must hold cm.mu.
+func (cm *ConnManager) evacuated() bool {
+	if cm.evacuatedUntil.IsZero() {
+		return false
+	}
+	if time.Now().Before(cm.evacuatedUntil) {
+		return true
+	}
+	cm.evacuatedUntil = time.Time{}
+	cm.publish(events.EvacuationEnded, nil, fmt.Sprintf("Evacuation of %s expired, launching sessions again", cm.cfg.AWSRegion))
+	return false
+}
@@ -0,0 +1,170 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// closeConn is a drainConn that records being closed
+type closeConn struct {
+	drainConn
+	closed *bool
+}
+
+func (c closeConn) CloseWithError(quic.ApplicationErrorCode, string) error {
+	*c.closed = true
+	return nil
+}
+
+func newControlTestSession(id, role string) (*Session, *bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	closed := new(bool)
+	session := &Session{
+		ID:        id,
+		Role:      role,
+		QuicConn:  closeConn{drainConn{ctx: ctx}, closed},
+		Cancel:    cancel,
+		StartedAt: time.Now(),
+		TTL:       5 * time.Minute,
+		healthy:   true,
+	}
+	return session, closed
+}
+
+func TestConnManager_RotateNow(t *testing.T) {
+	cm := newPoolTestManager(1, 3)
+	if _, err := cm.RotateNow("the test"); err == nil {
+		t.Error("Expected an error with no primary")
+	}
+
+	primary, _ := newControlTestSession("primary-1", RolePrimary)
+	cm.sessions = []*Session{primary}
+	if cm.dueForRotation(primary) {
+		t.Fatal("Expected a fresh primary not to be due for rotation")
+	}
+	id, err := cm.RotateNow("the test")
+	if err != nil || id != "primary-1" {
+		t.Fatalf("RotateNow = %q, %v", id, err)
+	}
+	if !cm.dueForRotation(primary) {
+		t.Error("Expected the primary to be due for rotation after RotateNow")
+	}
+}
+
+func TestConnManager_DrainSession(t *testing.T) {
+	cm := newPoolTestManager(2, 4)
+	primary, _ := newControlTestSession("primary-1", RolePrimary)
+	first, _ := newControlTestSession("secondary-1", RoleSecondary)
+	second, _ := newControlTestSession("secondary-2", RoleSecondary)
+	cm.sessions = []*Session{primary, first, second}
+
+	if _, err := cm.DrainSession("secondary", "the test"); err == nil {
+		t.Error("Expected an ambiguous ID to be refused")
+	}
+	if _, err := cm.DrainSession("nope", "the test"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound, got %v", err)
+	}
+
+	id, err := cm.DrainSession("secondary-2", "the test")
+	if err != nil || id != "secondary-2" || !second.IsDraining() {
+		t.Fatalf("DrainSession = %q, %v (role %s)", id, err, second.Role)
+	}
+	if _, err := cm.DrainSession("secondary-2", "the test"); err == nil {
+		t.Error("Expected draining a draining session to fail")
+	}
+
+	// Draining the primary rotates it instead
+	if id, err := cm.DrainSession("primary", "the test"); err != nil || id != "primary-1" || !primary.IsPrimary() || !primary.rotateEarly {
+		t.Errorf("DrainSession of the primary = %q, %v (role %s)", id, err, primary.Role)
+	}
+}
+
+func TestConnManager_KillSession(t *testing.T) {
+	cm := newPoolTestManager(1, 3)
+	primary, closed := newControlTestSession("primary-1", RolePrimary)
+	secondary, _ := newControlTestSession("secondary-1", RoleSecondary)
+	cm.sessions = []*Session{primary, secondary}
+
+	id, err := cm.KillSession("prim", "the test")
+	if err != nil || id != "primary-1" {
+		t.Fatalf("KillSession = %q, %v", id, err)
+	}
+	if !*closed {
+		t.Error("Expected the killed session's connection to be closed")
+	}
+	if sessions := cm.GetAllSessions(); len(sessions) != 1 || sessions[0] != secondary {
+		t.Errorf("Expected only the secondary left, got %v", sessions)
+	}
+	if _, err := cm.KillSession("primary-1", "the test"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound killing a killed session, got %v", err)
+	}
+}
+
+func TestConnManager_Evacuate(t *testing.T) {
+	cm := newPoolTestManager(1, 3)
+	cm.cfg.AWSRegion = "us-east-1"
+	primary, _ := newControlTestSession("primary-1", RolePrimary)
+	secondary, _ := newControlTestSession("secondary-1", RoleSecondary)
+	cm.sessions = []*Session{primary, secondary}
+
+	drained := cm.Evacuate(time.Minute, "the test")
+	if len(drained) != 2 || !primary.IsDraining() || !secondary.IsDraining() {
+		t.Fatalf("Evacuate drained %v (roles %s, %s)", drained, primary.Role, secondary.Role)
+	}
+	if !cm.Evacuated() || cm.GetCurrent() != nil {
+		t.Error("Expected an evacuated manager with no usable session")
+	}
+
+	// No replacement is launched while evacuated; there is no launcher to call
+	cm.checkSessions(context.Background())
+	if cm.launchState.launchingPrimary {
+		t.Error("Expected no launch while evacuated")
+	}
+
+	if !cm.EndEvacuation("the test") || cm.Evacuated() {
+		t.Error("Expected EndEvacuation to end the evacuation")
+	}
+	if cm.EndEvacuation("the test") {
+		t.Error("Expected a second EndEvacuation to report no evacuation")
+	}
+
+	// An evacuation that runs out ends by itself
+	cm.mu.Lock()
+	cm.evacuatedUntil = time.Now().Add(-time.Second)
+	expired := !cm.evacuated() && cm.evacuatedUntil.IsZero()
+	cm.mu.Unlock()
+	if !expired {
+		t.Error("Expected an expired evacuation to be cleared")
+	}
+}
+
+func TestConnManager_ExpiringSessions(t *testing.T) {
+	cm := newPoolTestManager(1, 2)
+	primary, _ := newControlTestSession("primary-1", RolePrimary)
+	secondary, _ := newControlTestSession("secondary-1", RoleSecondary)
+	cm.sessions = []*Session{primary, secondary}
+
+	// An expiring secondary drains and is no rotation candidate
+	if !secondary.MarkExpiring() || secondary.MarkExpiring() {
+		t.Fatal("Expected MarkExpiring to report only the first notice")
+	}
+	cm.checkSessions(context.Background())
+	if !secondary.IsDraining() || cm.rotationCandidate() != nil {
+		t.Fatalf("Expected the expiring secondary to drain, role %s", secondary.Role)
+	}
+
+	// An expiring primary takes no new streams and rotates early; the pool
+	// is full, so no secondary is launched
+	primary.MarkExpiring()
+	if cm.GetCurrent() != nil {
+		t.Error("Expected no usable session while the primary is expiring")
+	}
+	cm.checkSessions(context.Background())
+	if !primary.IsPrimary() || !cm.dueForRotation(primary) {
+		t.Errorf("Expected the expiring primary to be due for rotation, role %s", primary.Role)
+	}
+}
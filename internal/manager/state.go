@@ -0,0 +1,154 @@
+package manager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// SessionRecord is a session whose Lambda may still be running
+type SessionRecord struct {
+	ID         string    `json:"id"`
+	Region     string    `json:"region"`
+	LaunchedAt time.Time `json:"launched_at"`
+
+	// ExpiresAt is when the Lambda's timeout ends the session at the latest
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// IntegrityKey checksums the notice that stops the session, which its
+	// Lambda accepts only with the key it was launched with
+	IntegrityKey string `json:"integrity_key,omitempty"`
+}
+
+// SessionState keeps the sessions a proxy has launched in a file, from the
+// launch until their tunnel closes. A proxy that crashes leaves its Lambdas
+// running until they time out; the next proxy started with the same file
+// finds them there and can stop them before launching its own. A nil
+// SessionState records nothing.
+type SessionState struct {
+	path string
+
+	mu         sync.Mutex
+	sessions   map[string]SessionRecord
+	saveFailed bool // a save has failed and been logged
+}
+
+// OpenSessionState takes over the state file at path. It returns the
+// sessions an earlier run left there that may still be running, which stay
+// in the file until they expire. An unreadable file is reported and
+// replaced.
+func OpenSessionState(path string) (*SessionState, []SessionRecord, error) {
+	s := &SessionState{path: path, sessions: make(map[string]SessionRecord)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil, nil
+	}
+	if err != nil {
+		return s, nil, fmt.Errorf("failed to read session state: %w", err)
+	}
+	var records []SessionRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return s, nil, fmt.Errorf("failed to parse session state %s: %w", path, err)
+	}
+
+	var orphans []SessionRecord
+	now := time.Now()
+	for _, record := range records {
+		if record.ID != "" && record.ExpiresAt.After(now) {
+			s.sessions[record.ID] = record
+			orphans = append(orphans, record)
+		}
+	}
+	return s, orphans, nil
+}
+
+// Add records a session before its Lambda is started
+func (s *SessionState) Add(record SessionRecord) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[record.ID] = record
+	s.save()
+}
+
+// Remove forgets a session whose Lambda has stopped
+func (s *SessionState) Remove(id string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.sessions[id]; !ok {
+		return
+	}
+	delete(s.sessions, id)
+	s.save()
+}
+
+// Close removes the file after a clean shutdown, which leaves no Lambda running
+func (s *SessionState) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions = make(map[string]SessionRecord)
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove session state: %w", err)
+	}
+	return nil
+}
+
+// save writes the unexpired sessions to the file, replacing it whole so a
+// crash mid-write can't leave it truncated. The caller must hold s.mu.
+func (s *SessionState) save() {
+	now := time.Now()
+	records := make([]SessionRecord, 0, len(s.sessions))
+	for id, record := range s.sessions {
+		if !record.ExpiresAt.After(now) {
+			delete(s.sessions, id)
+			continue
+		}
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].LaunchedAt.Before(records[j].LaunchedAt) })
+
+	if err := writeFileAtomic(s.path, records); err != nil && !s.saveFailed {
+		s.saveFailed = true
+		shared.LogErrorf("Failed to save session state, a crash now would leave Lambdas running until they time out: %v", err)
+	}
+}
+
+// writeFileAtomic writes v as JSON to a temporary file and renames it to path
+func writeFileAtomic(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,55 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSessionState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "sessions.json")
+	state, orphans, err := OpenSessionState(path)
+	if err != nil || len(orphans) != 0 {
+		t.Fatalf("Expected a fresh state, got %v, %v", orphans, err)
+	}
+
+	now := time.Now()
+	state.Add(SessionRecord{ID: "live", Region: "us-west-2", LaunchedAt: now, ExpiresAt: now.Add(time.Minute)})
+	state.Add(SessionRecord{ID: "closed", Region: "us-west-2", LaunchedAt: now, ExpiresAt: now.Add(time.Minute)})
+	state.Add(SessionRecord{ID: "expired", Region: "us-west-2", LaunchedAt: now, ExpiresAt: now.Add(-time.Second)})
+	state.Remove("closed")
+
+	// A crash leaves the file behind; the next run finds what may still be running
+	_, orphans, err = OpenSessionState(path)
+	if err != nil {
+		t.Fatalf("OpenSessionState failed: %v", err)
+	}
+	if len(orphans) != 1 || orphans[0].ID != "live" || orphans[0].Region != "us-west-2" {
+		t.Errorf("Expected only the live session left behind, got %+v", orphans)
+	}
+
+	// A clean shutdown leaves nothing to stop
+	if err := state.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the state file removed, got %v", err)
+	}
+
+	// A damaged file is reported and replaced
+	os.WriteFile(path, []byte("{"), 0600)
+	state, _, err = OpenSessionState(path)
+	if err == nil {
+		t.Error("Expected an error for a damaged state file")
+	}
+	state.Add(SessionRecord{ID: "next", LaunchedAt: now, ExpiresAt: now.Add(time.Minute)})
+	if _, orphans, err := OpenSessionState(path); err != nil || len(orphans) != 1 {
+		t.Errorf("Expected the damaged file replaced, got %v, %v", orphans, err)
+	}
+
+	// Without a state file nothing is recorded
+	var none *SessionState
+	none.Add(SessionRecord{ID: "ignored"})
+	none.Remove("ignored")
+}
@@ -0,0 +1,45 @@
+package manager
+
+import (
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/tunnel"
+)
+
+// TunnelPool returns cm's sessions as a tunnel.Pool, so a tunnel.Dialer
+// built with tunnel.NewPoolSelector can dial through a running proxy
+func (cm *ConnManager) TunnelPool() tunnel.Pool {
+	return tunnelPool{cm: cm}
+}
+
+type tunnelPool struct {
+	cm *ConnManager
+}
+
+func (p tunnelPool) Sessions() []tunnel.Session {
+	sessions := p.cm.GetAllSessions()
+	pool := make([]tunnel.Session, len(sessions))
+	for i, session := range sessions {
+		pool[i] = tunnelSession{session}
+	}
+	return pool
+}
+
+func (p tunnelPool) Primary() tunnel.Session {
+	session := p.cm.Primary()
+	if session == nil {
+		return nil
+	}
+	return tunnelSession{session}
+}
+
+// tunnelSession is a Session as a tunnel.Session
+type tunnelSession struct {
+	s *Session
+}
+
+func (t tunnelSession) SessionID() string { return t.s.ID }
+
+func (t tunnelSession) Usable() bool {
+	return t.s.IsHealthy() && !t.s.IsDraining() && !t.s.IsExpiring()
+}
+
+func (t tunnelSession) Conn() tunnel.StreamOpener { return t.s.StreamConn() }
@@ -1,91 +1,247 @@
 package metrics
 
 import (
+	"context"
 	"expvar"
 	"fmt"
+	"net"
 	"net/http"
 	"runtime"
+	"strconv"
 	"sync"
-	"sync/atomic"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// registry holds every metric served on /metrics. It's private so tests and
+// embedding programs don't collide with the default Prometheus registry.
+var (
+	registry = prometheus.NewRegistry()
+	factory  = promauto.With(registry)
+)
+
+// Histogram buckets
+var (
+	rttBuckets      = []float64{5, 10, 25, 50, 75, 100, 150, 250, 500, 1000, 2500}
+	latencyBuckets  = []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	durationBuckets = prometheus.ExponentialBuckets(0.1, 4, 8)
+	sizeBuckets     = prometheus.ExponentialBuckets(256, 2, 8)
+	rotationBuckets = []float64{1, 2, 5, 10, 20, 30, 60, 120, 300}
 )
 
 var (
 	// Session Metrics
-	SessionRTTMs         = expvar.NewFloat("session_rtt_ms")
-	sessionHealthy       = expvar.NewInt("session_healthy")
-	sessionPingsSent     = expvar.NewInt("session_pings_sent")
-	sessionPongsReceived = expvar.NewInt("session_pongs_received")
-	sessionMissedPings   = expvar.NewInt("session_missed_pings")
-	sessionRotations     = expvar.NewInt("session_rotations")
-	sessionLaunches      = expvar.NewInt("session_launches")
-	sessionFailures      = expvar.NewInt("session_failures")
-	activeSessions       = expvar.NewInt("active_sessions")
-	
+	sessionRTTMs = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "session_rtt_ms", Help: "Current session RTT in milliseconds"})
+	sessionRTT = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "session_rtt_milliseconds", Help: "Session health check RTT in milliseconds by session role",
+		Buckets: rttBuckets}, []string{"role"})
+	sessionLastRTT = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "session_last_rtt_milliseconds", Help: "Last health check RTT of each live session in milliseconds"},
+		[]string{"session_id"})
+	sessionHealthy = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "session_healthy", Help: "Whether the current session is healthy (1) or not (0)"})
+	sessionPingsSent = factory.NewCounter(prometheus.CounterOpts{
+		Name: "session_pings_sent_total", Help: "Total number of pings sent"})
+	sessionPongsReceived = factory.NewCounter(prometheus.CounterOpts{
+		Name: "session_pongs_received_total", Help: "Total number of pongs received"})
+	sessionMissedPings = factory.NewCounter(prometheus.CounterOpts{
+		Name: "session_missed_pings_total", Help: "Total number of missed pings"})
+	sessionRotations = factory.NewCounter(prometheus.CounterOpts{
+		Name: "session_rotations_total", Help: "Total number of session rotations"})
+	sessionsExpiring = factory.NewCounter(prometheus.CounterOpts{
+		Name: "sessions_expiring_total", Help: "Sessions whose Lambda warned it was near its function timeout"})
+	rotationAttempts = factory.NewCounter(prometheus.CounterOpts{
+		Name: "rotation_attempts_total", Help: "Total number of rotation attempts started"})
+	rotationFailures = factory.NewCounter(prometheus.CounterOpts{
+		Name: "rotation_failures_total", Help: "Rotation attempts that failed before the old primary drained"})
+	rotationLastSeconds = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "rotation_last_duration_seconds", Help: "Duration of the last successful rotation"})
+	rotationDuration = factory.NewHistogram(prometheus.HistogramOpts{
+		Name: "rotation_duration_seconds", Help: "Duration of successful rotations from start to drained",
+		Buckets: rotationBuckets})
+	sessionLaunches = factory.NewCounter(prometheus.CounterOpts{
+		Name: "session_launches_total", Help: "Sessions launched successfully"})
+	sessionFailures = factory.NewCounter(prometheus.CounterOpts{
+		Name: "session_failures_total", Help: "Session launches that failed"})
+	sessionsRelayed = factory.NewCounter(prometheus.CounterOpts{
+		Name: "sessions_relayed_total", Help: "Sessions launched over the TCP relay instead of a punched UDP path"})
+	activeSessions = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "active_sessions", Help: "Number of currently active sessions"})
+	keepaliveInterval = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "keepalive_interval_seconds", Help: "Interval sessions are pinged at, shortened by missed pings"})
+	managerGoroutines = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "manager_goroutines", Help: "Connection manager goroutines running: the monitor, launches, promotions and drains"})
+	managerGoroutinesRefused = factory.NewCounter(prometheus.CounterOpts{
+		Name: "manager_goroutines_refused_total", Help: "Connection manager goroutines not started because max_goroutines were running"})
+	managerLimit = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "manager_limit", Help: "Connection manager limits in effect, by kind (sessions or goroutines)"},
+		[]string{"kind"})
+	anomaliesDetected = factory.NewCounter(prometheus.CounterOpts{
+		Name: "anomalies_detected_total", Help: "Session health anomalies flagged (RTT spikes, ping loss, throughput collapse)"})
+	anomaliesActive = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "anomalies_active", Help: "Session health anomalies not yet resolved"})
+
 	// SOCKS5 Proxy Metrics
-	socks5Connections    = expvar.NewInt("socks5_connections_total")
-	socks5ActiveConns    = expvar.NewInt("socks5_active_connections")
-	socks5BytesTransferred = expvar.NewInt("socks5_bytes_transferred")
-	socks5FailedConns    = expvar.NewInt("socks5_failed_connections")
-	socks5AvgLatencyMs   = expvar.NewFloat("socks5_avg_latency_ms")
-	
+	socks5Connections = factory.NewCounter(prometheus.CounterOpts{
+		Name: "socks5_connections_total", Help: "Total number of SOCKS5 connections"})
+	socks5ActiveConns = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "socks5_active_connections", Help: "Number of currently active SOCKS5 connections"})
+	socks5BytesTransferred = factory.NewCounter(prometheus.CounterOpts{
+		Name: "socks5_bytes_transferred_total", Help: "Total bytes transferred through SOCKS5 proxy"})
+	socks5FailedConns = factory.NewCounter(prometheus.CounterOpts{
+		Name: "socks5_failed_connections_total", Help: "SOCKS5 connections that failed before or while tunneling"})
+	socks5ConnectLatency = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "socks5_connect_latency_seconds", Help: "Time from accepting a SOCKS5 connection to replying that its tunnel is up",
+		Buckets: latencyBuckets}, []string{"route", "destination_class"})
+	socks5ConnDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "socks5_connection_duration_seconds", Help: "Lifetime of SOCKS5 tunnels from accept to close",
+		Buckets: durationBuckets}, []string{"route", "destination_class"})
+	socks5QueuedConns = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "socks5_queued_connections", Help: "Number of SOCKS5 connections waiting for a session"})
+	socks5QueueTimeouts = factory.NewCounter(prometheus.CounterOpts{
+		Name: "socks5_queue_timeouts_total", Help: "SOCKS5 connections closed after waiting too long for a session"})
+	socks5RejectedConns = factory.NewCounter(prometheus.CounterOpts{
+		Name: "socks5_rejected_connections_total", Help: "SOCKS5 connections refused because the concurrent connection limit was reached"})
+	socks5IdleReaped = factory.NewCounter(prometheus.CounterOpts{
+		Name: "socks5_idle_reaped_connections_total", Help: "SOCKS5 tunnels closed after carrying no traffic for the idle timeout"})
+	socks5ACLDenied = factory.NewCounter(prometheus.CounterOpts{
+		Name: "socks5_acl_denied_total", Help: "SOCKS5 requests refused by the destination ACL, here or on the Lambda"})
+	socks5AcceptPaused = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "socks5_accept_paused", Help: "Whether new SOCKS5 connections are paused because a resource limit is exceeded"})
+	socks5Shed = factory.NewCounter(prometheus.CounterOpts{
+		Name: "socks5_shed_connections_total", Help: "Idle SOCKS5 tunnels closed to get back under a resource limit"})
+	dnsStubQueries = factory.NewCounter(prometheus.CounterOpts{
+		Name: "dns_stub_queries_total", Help: "DNS queries received by the local DNS stub"})
+	dnsStubFailures = factory.NewCounter(prometheus.CounterOpts{
+		Name: "dns_stub_failures_total", Help: "DNS stub queries answered with SERVFAIL because forwarding failed"})
+
 	// QUIC Metrics
-	quicStreamsActive    = expvar.NewInt("quic_streams_active")
-	quicStreamsTotal     = expvar.NewInt("quic_streams_total")
-	quicBytesTransferred = expvar.NewInt("quic_bytes_transferred")
-	quicConnErrors       = expvar.NewInt("quic_connection_errors")
-	quicHandshakeTime    = expvar.NewFloat("quic_handshake_time_ms")
-	
+	quicStreamsActive = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "quic_streams_active", Help: "Number of currently active QUIC streams"})
+	quicStreamsTotal = factory.NewCounter(prometheus.CounterOpts{
+		Name: "quic_streams_total", Help: "Total number of QUIC streams created"})
+	quicBytesTransferred = factory.NewCounter(prometheus.CounterOpts{
+		Name: "quic_bytes_transferred_total", Help: "Bytes carried over QUIC connections"})
+	quicConnErrors = factory.NewCounter(prometheus.CounterOpts{
+		Name: "quic_connection_errors_total", Help: "QUIC connections or control streams that failed to open"})
+	quicHandshakeTime = factory.NewHistogram(prometheus.HistogramOpts{
+		Name: "quic_handshake_seconds", Help: "QUIC handshake duration with the Lambda",
+		Buckets: latencyBuckets})
+	streamBytesReconciled = factory.NewCounter(prometheus.CounterOpts{
+		Name: "stream_bytes_reconciled_total", Help: "Finished tunnel streams whose byte counts were compared with the Lambda's"})
+	streamByteMismatches = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "stream_byte_mismatches_total", Help: "Tunnel stream directions whose two ends counted different bytes"},
+		[]string{"direction"})
+	streamBytesUnaccounted = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "stream_bytes_unaccounted_total", Help: "Bytes sent on tunnel streams that the receiving end never read"},
+		[]string{"direction"})
+	streamBytesUnmatched = factory.NewCounter(prometheus.CounterOpts{
+		Name: "stream_byte_reports_unmatched_total", Help: "Finished tunnel streams whose counts only one end reported"})
+
 	// AWS Service Metrics
-	s3Operations         = expvar.NewInt("s3_operations_total")
-	s3Errors            = expvar.NewInt("s3_errors_total")
-	lambdaInvocations   = expvar.NewInt("lambda_invocations_total")
-	lambdaErrors        = expvar.NewInt("lambda_errors_total")
-	awsAPILatency       = expvar.NewFloat("aws_api_latency_ms")
-	
+	s3Operations = factory.NewCounter(prometheus.CounterOpts{
+		Name: "s3_operations_total", Help: "Total number of S3 operations"})
+	s3Errors = factory.NewCounter(prometheus.CounterOpts{
+		Name: "s3_errors_total", Help: "S3 operations that failed"})
+	s3StaleFound = factory.NewCounter(prometheus.CounterOpts{
+		Name: "s3_stale_objects_found_total", Help: "Stale coordination/response objects found at startup"})
+	s3StaleDeleted = factory.NewCounter(prometheus.CounterOpts{
+		Name: "s3_stale_objects_deleted_total", Help: "Stale coordination/response objects deleted at startup"})
+	s3ObjectSize = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "s3_object_size_bytes", Help: "Size of the coordination objects written and response objects read, by object",
+		Buckets: sizeBuckets}, []string{"object"})
+	lambdaInvocations = factory.NewCounter(prometheus.CounterOpts{
+		Name: "lambda_invocations_total", Help: "Total number of Lambda invocations"})
+	lambdaErrors = factory.NewCounter(prometheus.CounterOpts{
+		Name: "lambda_errors_total", Help: "Lambda invocations that failed"})
+	lambdaTriggerLatency = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "lambda_trigger_latency_seconds", Help: "Time from writing a session's coordination object to the Lambda starting on it, by trigger (s3 or invoke)",
+		Buckets: latencyBuckets}, []string{"trigger"})
+	lambdaInvokeFallbacks = factory.NewCounter(prometheus.CounterOpts{
+		Name: "lambda_invoke_fallbacks_total", Help: "Launches that invoked the Lambda directly because the S3 notification was late"})
+	awsAPILatency = factory.NewHistogram(prometheus.HistogramOpts{
+		Name: "aws_api_latency_seconds", Help: "Latency of AWS API calls",
+		Buckets: latencyBuckets})
+
 	// System Metrics
-	systemGoroutines     = expvar.NewInt("system_goroutines")
-	systemMemoryAlloc    = expvar.NewInt("system_memory_alloc_bytes")
-	systemMemoryTotal    = expvar.NewInt("system_memory_total_bytes")
-	systemMemorySys      = expvar.NewInt("system_memory_sys_bytes")
-	systemGCPauses       = expvar.NewFloat("system_gc_pause_ns")
-	
+	systemGoroutines = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "system_goroutines", Help: "Number of active goroutines"})
+	systemMemoryAlloc = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "system_memory_alloc_bytes", Help: "Currently allocated memory in bytes"})
+	systemMemorySys = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "system_memory_sys_bytes", Help: "Memory obtained from the OS in bytes"})
+	systemOpenFiles = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "system_open_fds", Help: "Open file descriptors, sampled while resource limits are set"})
+	resourceLimitHits = factory.NewCounter(prometheus.CounterOpts{
+		Name: "resource_limit_exceeded_total", Help: "Times the proxy went over a configured resource limit"})
+	leakSuspected = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "leak_suspected", Help: "1 while the leak check suspects a leak of this kind"}, []string{"kind"})
+	leakDetections = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "leak_suspected_total", Help: "Times the leak check started suspecting a leak"}, []string{"kind"})
+	_ = factory.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "uptime_seconds", Help: "Process uptime in seconds"},
+		func() float64 { return time.Since(startTime).Seconds() })
+
 	// Performance Metrics
-	networkLatencyMs     = expvar.NewFloat("network_latency_ms")
-	stunLatencyMs        = expvar.NewFloat("stun_latency_ms")
-	natTraversalTime     = expvar.NewFloat("nat_traversal_time_ms")
-	
+	networkLatencyMs = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "network_latency_ms", Help: "Last measured network latency in milliseconds"})
+	stunLatency = factory.NewHistogram(prometheus.HistogramOpts{
+		Name: "stun_latency_seconds", Help: "STUN public address discovery latency",
+		Buckets: latencyBuckets})
+	natTraversalTime = factory.NewHistogram(prometheus.HistogramOpts{
+		Name: "nat_traversal_seconds", Help: "Time to punch through NAT to a new Lambda",
+		Buckets: latencyBuckets})
+
 	// Internal tracking
-	rttMutex            sync.RWMutex
-	lastRTT             time.Duration
-	latencyMutex        sync.RWMutex
-	latencySum          float64
-	latencyCount        int64
-	
-	// Atomic counters for high-frequency updates
-	bytesTransferredAtomic int64
-	connectionsAtomic      int64
-	
+	rttMutex sync.RWMutex
+	lastRTT  time.Duration
+
 	// Start time for uptime calculation
 	startTime = time.Now()
 )
 
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	expvar.Publish("metrics", expvar.Func(snapshot))
+}
+
 // Session Metrics Functions
-func RecordRTT(rtt time.Duration) {
+
+// RecordRTT records a health check round trip for a session in role
+func RecordRTT(sessionID, role string, rtt time.Duration) {
 	rttMutex.Lock()
-	defer rttMutex.Unlock()
-	
 	lastRTT = rtt
-	SessionRTTMs.Set(float64(rtt.Milliseconds()))
-	sessionPongsReceived.Add(1)
+	rttMutex.Unlock()
+
+	ms := float64(rtt) / float64(time.Millisecond)
+	sessionRTTMs.Set(float64(rtt.Milliseconds()))
+	sessionRTT.WithLabelValues(role).Observe(ms)
+	sessionLastRTT.WithLabelValues(sessionID).Set(ms)
+	sessionPongsReceived.Inc()
+}
+
+// ForgetSession drops the per-session series of a session that has ended
+func ForgetSession(sessionID string) {
+	sessionLastRTT.DeleteLabelValues(sessionID)
 }
 
 func RecordPingSent() {
-	sessionPingsSent.Add(1)
+	sessionPingsSent.Inc()
 }
 
 func RecordMissedPing() {
-	sessionMissedPings.Add(1)
+	sessionMissedPings.Inc()
 }
 
 func SetSessionHealthy(healthy bool) {
@@ -97,19 +253,61 @@ func SetSessionHealthy(healthy bool) {
 }
 
 func RecordSessionRotation() {
-	sessionRotations.Add(1)
+	sessionRotations.Inc()
+}
+
+func RecordSessionExpiring() {
+	sessionsExpiring.Inc()
+}
+
+func RecordRotationAttempt() {
+	rotationAttempts.Inc()
+}
+
+func RecordRotationFailure() {
+	rotationFailures.Inc()
+}
+
+// RecordRotationDuration records how long a successful rotation took from start to drained
+func RecordRotationDuration(d time.Duration) {
+	rotationLastSeconds.Set(d.Seconds())
+	rotationDuration.Observe(d.Seconds())
 }
 
 func RecordSessionLaunch() {
-	sessionLaunches.Add(1)
+	sessionLaunches.Inc()
 }
 
 func RecordSessionFailure() {
-	sessionFailures.Add(1)
+	sessionFailures.Inc()
+}
+
+func RecordRelaySession() {
+	sessionsRelayed.Inc()
 }
 
 func SetActiveSessions(count int) {
-	activeSessions.Set(int64(count))
+	activeSessions.Set(float64(count))
+}
+
+func SetKeepaliveInterval(interval time.Duration) {
+	keepaliveInterval.Set(interval.Seconds())
+}
+
+// SetManagerGoroutines records how many connection manager goroutines are running
+func SetManagerGoroutines(count int) {
+	managerGoroutines.Set(float64(count))
+}
+
+// RecordManagerGoroutineRefused counts a goroutine the connection manager's limit refused
+func RecordManagerGoroutineRefused() {
+	managerGoroutinesRefused.Inc()
+}
+
+// SetManagerLimits records the connection manager's session and goroutine limits
+func SetManagerLimits(sessions, goroutines int) {
+	managerLimit.WithLabelValues("sessions").Set(float64(sessions))
+	managerLimit.WithLabelValues("goroutines").Set(float64(goroutines))
 }
 
 func GetLastRTT() time.Duration {
@@ -120,78 +318,228 @@ func GetLastRTT() time.Duration {
 
 // SOCKS5 Proxy Metrics Functions
 func RecordSOCKS5Connection() {
-	socks5Connections.Add(1)
-	atomic.AddInt64(&connectionsAtomic, 1)
+	socks5Connections.Inc()
 }
 
 func IncrementActiveSOCKS5Connections() {
-	socks5ActiveConns.Add(1)
+	socks5ActiveConns.Inc()
 }
 
 func DecrementActiveSOCKS5Connections() {
-	socks5ActiveConns.Add(-1)
+	socks5ActiveConns.Dec()
 }
 
 func RecordSOCKS5BytesTransferred(bytes int64) {
-	socks5BytesTransferred.Add(bytes)
-	atomic.AddInt64(&bytesTransferredAtomic, bytes)
+	socks5BytesTransferred.Add(float64(bytes))
+}
+
+func IncrementQueuedSOCKS5Connections() {
+	socks5QueuedConns.Inc()
+}
+
+func DecrementQueuedSOCKS5Connections() {
+	socks5QueuedConns.Dec()
+}
+
+func RecordSOCKS5QueueTimeout() {
+	socks5QueueTimeouts.Inc()
+}
+
+func RecordSOCKS5RejectedConnection() {
+	socks5RejectedConns.Inc()
+}
+
+func RecordSOCKS5IdleReaped() {
+	socks5IdleReaped.Inc()
+}
+
+func RecordSOCKS5ACLDenied() {
+	socks5ACLDenied.Inc()
+}
+
+// SetSOCKS5AcceptPaused records whether new SOCKS5 connections are paused by a resource limit
+func SetSOCKS5AcceptPaused(paused bool) {
+	if paused {
+		socks5AcceptPaused.Set(1)
+	} else {
+		socks5AcceptPaused.Set(0)
+	}
+}
+
+func RecordSOCKS5Shed() {
+	socks5Shed.Inc()
+}
+
+func RecordDNSStubQuery() {
+	dnsStubQueries.Inc()
+}
+
+func RecordDNSStubFailure() {
+	dnsStubFailures.Inc()
+}
+
+// RecordResourceSample records a resource sample, counting each time a limit is newly exceeded
+func RecordResourceSample(goroutines, openFiles int, memory int64, exceeded bool) {
+	systemGoroutines.Set(float64(goroutines))
+	systemOpenFiles.Set(float64(openFiles))
+	systemMemorySys.Set(float64(memory))
+	if exceeded {
+		resourceLimitHits.Inc()
+	}
+}
+
+// RecordLeakSuspected records the leak check starting or, when suspected is
+// false, ending its suspicion of a leak of kind
+func RecordLeakSuspected(kind string, suspected bool) {
+	if suspected {
+		leakDetections.WithLabelValues(kind).Inc()
+		leakSuspected.WithLabelValues(kind).Set(1)
+	} else {
+		leakSuspected.WithLabelValues(kind).Set(0)
+	}
+}
+
+// RecordAnomaly counts an anomaly starting, or one resolving when started is false
+func RecordAnomaly(started bool) {
+	if started {
+		anomaliesDetected.Inc()
+		anomaliesActive.Inc()
+	} else {
+		anomaliesActive.Dec()
+	}
+}
+
+// GetSOCKS5BytesTransferred returns the bytes relayed by SOCKS5 tunnels since startup
+func GetSOCKS5BytesTransferred() int64 {
+	return int64(value(socks5BytesTransferred))
+}
+
+// GetActiveSOCKS5Connections returns the number of open SOCKS5 connections
+func GetActiveSOCKS5Connections() int64 {
+	return int64(value(socks5ActiveConns))
+}
+
+// GetSOCKS5IdleReaped returns how many tunnels were closed for being idle
+func GetSOCKS5IdleReaped() int64 {
+	return int64(value(socks5IdleReaped))
 }
 
 func RecordSOCKS5FailedConnection() {
-	socks5FailedConns.Add(1)
+	socks5FailedConns.Inc()
+}
+
+// RecordSOCKS5ConnectLatency records how long a tunnel took to come up, by
+// route ("tunnel" or "direct") and the class of its destination
+func RecordSOCKS5ConnectLatency(route, target string, latency time.Duration) {
+	socks5ConnectLatency.WithLabelValues(route, DestinationClass(target)).Observe(latency.Seconds())
 }
 
-func RecordSOCKS5Latency(latency time.Duration) {
-	latencyMutex.Lock()
-	defer latencyMutex.Unlock()
-	
-	latencySum += float64(latency.Milliseconds())
-	latencyCount++
-	avgLatency := latencySum / float64(latencyCount)
-	socks5AvgLatencyMs.Set(avgLatency)
+// RecordSOCKS5ConnectionDuration records the lifetime of a tunnel once it closes
+func RecordSOCKS5ConnectionDuration(route, target string, duration time.Duration) {
+	socks5ConnDuration.WithLabelValues(route, DestinationClass(target)).Observe(duration.Seconds())
+}
+
+// DestinationClass buckets a host:port target by its port, keeping label
+// cardinality fixed no matter how many hosts are visited
+func DestinationClass(target string) string {
+	_, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return "unknown"
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "unknown"
+	}
+	switch port {
+	case 80, 443, 8080, 8443:
+		return "web"
+	case 53, 853:
+		return "dns"
+	case 25, 110, 143, 465, 587, 993, 995:
+		return "mail"
+	case 22:
+		return "ssh"
+	default:
+		return "other"
+	}
 }
 
 // QUIC Metrics Functions
 func IncrementActiveQUICStreams() {
-	quicStreamsActive.Add(1)
-	quicStreamsTotal.Add(1)
+	quicStreamsActive.Inc()
+	quicStreamsTotal.Inc()
 }
 
 func DecrementActiveQUICStreams() {
-	quicStreamsActive.Add(-1)
+	quicStreamsActive.Dec()
 }
 
 func RecordQUICBytesTransferred(bytes int64) {
-	quicBytesTransferred.Add(bytes)
+	quicBytesTransferred.Add(float64(bytes))
+}
+
+// RecordStreamBytesReconciled counts streams whose byte counts were
+// compared, and those dropped because only one end reported them
+func RecordStreamBytesReconciled(matched, unmatched int) {
+	streamBytesReconciled.Add(float64(matched))
+	streamBytesUnmatched.Add(float64(unmatched))
+}
+
+// RecordStreamByteMismatch counts a stream direction whose ends disagreed,
+// by how many more bytes were sent than received (negative for fewer)
+func RecordStreamByteMismatch(direction string, unaccounted int64) {
+	streamByteMismatches.WithLabelValues(direction).Inc()
+	if unaccounted > 0 {
+		streamBytesUnaccounted.WithLabelValues(direction).Add(float64(unaccounted))
+	}
 }
 
 func RecordQUICConnectionError() {
-	quicConnErrors.Add(1)
+	quicConnErrors.Inc()
 }
 
 func RecordQUICHandshakeTime(duration time.Duration) {
-	quicHandshakeTime.Set(float64(duration.Milliseconds()))
+	quicHandshakeTime.Observe(duration.Seconds())
 }
 
 // AWS Service Metrics Functions
 func RecordS3Operation() {
-	s3Operations.Add(1)
+	s3Operations.Inc()
 }
 
 func RecordS3Error() {
-	s3Errors.Add(1)
+	s3Errors.Inc()
+}
+
+func RecordS3StaleObjects(found, deleted int) {
+	s3StaleFound.Add(float64(found))
+	s3StaleDeleted.Add(float64(deleted))
+}
+
+// RecordS3ObjectSize records the size of a coordination or response object
+func RecordS3ObjectSize(object string, size int) {
+	s3ObjectSize.WithLabelValues(object).Observe(float64(size))
 }
 
 func RecordLambdaInvocation() {
-	lambdaInvocations.Add(1)
+	lambdaInvocations.Inc()
 }
 
 func RecordLambdaError() {
-	lambdaErrors.Add(1)
+	lambdaErrors.Inc()
+}
+
+// RecordLambdaTrigger records how a Lambda was started and how long after its coordination write
+func RecordLambdaTrigger(trigger string, latency time.Duration) {
+	lambdaTriggerLatency.WithLabelValues(trigger).Observe(latency.Seconds())
+}
+
+func RecordLambdaInvokeFallback() {
+	lambdaInvokeFallbacks.Inc()
 }
 
 func RecordAWSAPILatency(latency time.Duration) {
-	awsAPILatency.Set(float64(latency.Milliseconds()))
+	awsAPILatency.Observe(latency.Seconds())
 }
 
 // Performance Metrics Functions
@@ -200,43 +548,31 @@ func RecordNetworkLatency(latency time.Duration) {
 }
 
 func RecordSTUNLatency(latency time.Duration) {
-	stunLatencyMs.Set(float64(latency.Milliseconds()))
+	stunLatency.Observe(latency.Seconds())
 }
 
 func RecordNATTraversalTime(duration time.Duration) {
-	natTraversalTime.Set(float64(duration.Milliseconds()))
+	natTraversalTime.Observe(duration.Seconds())
 }
 
 // System Metrics Functions
 func UpdateSystemMetrics() {
 	var m runtime.MemStats
 	runtime.ReadMemStats(&m)
-	
-	systemGoroutines.Set(int64(runtime.NumGoroutine()))
-	systemMemoryAlloc.Set(int64(m.Alloc))
-	systemMemoryTotal.Set(int64(m.TotalAlloc))
-	systemMemorySys.Set(int64(m.Sys))
-	
-	if len(m.PauseNs) > 0 {
-		systemGCPauses.Set(float64(m.PauseNs[(m.NumGC+255)%256]))
-	}
+
+	systemGoroutines.Set(float64(runtime.NumGoroutine()))
+	systemMemoryAlloc.Set(float64(m.Alloc))
+	systemMemorySys.Set(float64(m.Sys))
 }
 
-// Metrics Server Functions
-func StartMetricsServer(addr string) error {
-	// Add custom metrics to expvar
-	expvar.Publish("uptime_seconds", expvar.Func(func() interface{} {
-		return time.Since(startTime).Seconds()
-	}))
-	
-	expvar.Publish("total_connections", expvar.Func(func() interface{} {
-		return atomic.LoadInt64(&connectionsAtomic)
-	}))
-	
-	expvar.Publish("total_bytes_transferred", expvar.Func(func() interface{} {
-		return atomic.LoadInt64(&bytesTransferredAtomic)
-	}))
-	
+// StartMetricsServer serves /metrics and /debug/vars on addr until ctx is
+// done, then shuts the server down and stops refreshing the system metrics
+func StartMetricsServer(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to start metrics server: %w", err)
+	}
+
 	// Start system metrics update routine
 	go func() {
 		ticker := time.NewTicker(5 * time.Second)
@@ -245,103 +581,70 @@ func StartMetricsServer(addr string) error {
 			select {
 			case <-ticker.C:
 				UpdateSystemMetrics()
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
-	
+
 	// Create HTTP server for metrics
 	mux := http.NewServeMux()
-	mux.Handle("/metrics", http.HandlerFunc(metricsHandler))
+	mux.Handle("/metrics", Handler())
 	mux.Handle("/debug/vars", expvar.Handler())
-	
+
 	server := &http.Server{
-		Addr:    addr,
-		Handler: mux,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
 	}
-	
-	return server.ListenAndServe()
-}
-
-// Custom metrics handler that provides Prometheus-compatible output
-func metricsHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	
-	// Write Prometheus-style metrics
-	fmt.Fprintf(w, "# HELP session_rtt_ms Current session RTT in milliseconds\n")
-	fmt.Fprintf(w, "# TYPE session_rtt_ms gauge\n")
-	fmt.Fprintf(w, "session_rtt_ms %v\n", SessionRTTMs.Value())
-	
-	fmt.Fprintf(w, "# HELP session_healthy Whether the current session is healthy (1) or not (0)\n")
-	fmt.Fprintf(w, "# TYPE session_healthy gauge\n")
-	fmt.Fprintf(w, "session_healthy %v\n", sessionHealthy.Value())
-	
-	fmt.Fprintf(w, "# HELP session_pings_sent_total Total number of pings sent\n")
-	fmt.Fprintf(w, "# TYPE session_pings_sent_total counter\n")
-	fmt.Fprintf(w, "session_pings_sent_total %v\n", sessionPingsSent.Value())
-	
-	fmt.Fprintf(w, "# HELP session_pongs_received_total Total number of pongs received\n")
-	fmt.Fprintf(w, "# TYPE session_pongs_received_total counter\n")
-	fmt.Fprintf(w, "session_pongs_received_total %v\n", sessionPongsReceived.Value())
-	
-	fmt.Fprintf(w, "# HELP session_missed_pings_total Total number of missed pings\n")
-	fmt.Fprintf(w, "# TYPE session_missed_pings_total counter\n")
-	fmt.Fprintf(w, "session_missed_pings_total %v\n", sessionMissedPings.Value())
-	
-	fmt.Fprintf(w, "# HELP session_rotations_total Total number of session rotations\n")
-	fmt.Fprintf(w, "# TYPE session_rotations_total counter\n")
-	fmt.Fprintf(w, "session_rotations_total %v\n", sessionRotations.Value())
-	
-	fmt.Fprintf(w, "# HELP active_sessions Number of currently active sessions\n")
-	fmt.Fprintf(w, "# TYPE active_sessions gauge\n")
-	fmt.Fprintf(w, "active_sessions %v\n", activeSessions.Value())
-	
-	fmt.Fprintf(w, "# HELP socks5_connections_total Total number of SOCKS5 connections\n")
-	fmt.Fprintf(w, "# TYPE socks5_connections_total counter\n")
-	fmt.Fprintf(w, "socks5_connections_total %v\n", socks5Connections.Value())
-	
-	fmt.Fprintf(w, "# HELP socks5_active_connections Number of currently active SOCKS5 connections\n")
-	fmt.Fprintf(w, "# TYPE socks5_active_connections gauge\n")
-	fmt.Fprintf(w, "socks5_active_connections %v\n", socks5ActiveConns.Value())
-	
-	fmt.Fprintf(w, "# HELP socks5_bytes_transferred_total Total bytes transferred through SOCKS5 proxy\n")
-	fmt.Fprintf(w, "# TYPE socks5_bytes_transferred_total counter\n")
-	fmt.Fprintf(w, "socks5_bytes_transferred_total %v\n", socks5BytesTransferred.Value())
-	
-	fmt.Fprintf(w, "# HELP quic_streams_active Number of currently active QUIC streams\n")
-	fmt.Fprintf(w, "# TYPE quic_streams_active gauge\n")
-	fmt.Fprintf(w, "quic_streams_active %v\n", quicStreamsActive.Value())
-	
-	fmt.Fprintf(w, "# HELP quic_streams_total Total number of QUIC streams created\n")
-	fmt.Fprintf(w, "# TYPE quic_streams_total counter\n")
-	fmt.Fprintf(w, "quic_streams_total %v\n", quicStreamsTotal.Value())
-	
-	fmt.Fprintf(w, "# HELP s3_operations_total Total number of S3 operations\n")
-	fmt.Fprintf(w, "# TYPE s3_operations_total counter\n")
-	fmt.Fprintf(w, "s3_operations_total %v\n", s3Operations.Value())
-	
-	fmt.Fprintf(w, "# HELP lambda_invocations_total Total number of Lambda invocations\n")
-	fmt.Fprintf(w, "# TYPE lambda_invocations_total counter\n")
-	fmt.Fprintf(w, "lambda_invocations_total %v\n", lambdaInvocations.Value())
-	
-	fmt.Fprintf(w, "# HELP system_goroutines Number of active goroutines\n")
-	fmt.Fprintf(w, "# TYPE system_goroutines gauge\n")
-	fmt.Fprintf(w, "system_goroutines %v\n", systemGoroutines.Value())
-	
-	fmt.Fprintf(w, "# HELP system_memory_alloc_bytes Currently allocated memory in bytes\n")
-	fmt.Fprintf(w, "# TYPE system_memory_alloc_bytes gauge\n")
-	fmt.Fprintf(w, "system_memory_alloc_bytes %v\n", systemMemoryAlloc.Value())
-	
-	uptime := time.Since(startTime).Seconds()
-	fmt.Fprintf(w, "# HELP uptime_seconds Process uptime in seconds\n")
-	fmt.Fprintf(w, "# TYPE uptime_seconds gauge\n")
-	fmt.Fprintf(w, "uptime_seconds %v\n", uptime)
+
+	return shared.ServeHTTPUntilDone(ctx, server, listener)
+}
+
+// Handler serves the registry in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// snapshot returns every unlabeled counter and gauge for /debug/vars
+func snapshot() interface{} {
+	families, err := registry.Gather()
+	if err != nil {
+		return err.Error()
+	}
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			if len(m.GetLabel()) > 0 {
+				continue
+			}
+			switch {
+			case m.Counter != nil:
+				values[family.GetName()] = m.GetCounter().GetValue()
+			case m.Gauge != nil:
+				values[family.GetName()] = m.GetGauge().GetValue()
+			}
+		}
+	}
+	return values
+}
+
+// value reads the current value of a counter or gauge
+func value(metric prometheus.Metric) float64 {
+	var m dto.Metric
+	if err := metric.Write(&m); err != nil {
+		return 0
+	}
+	if m.Counter != nil {
+		return m.GetCounter().GetValue()
+	}
+	return m.GetGauge().GetValue()
 }
 
 // Getter functions for dashboard
 func GetSystemMemoryAlloc() int64 {
-	return systemMemoryAlloc.Value()
+	return int64(value(systemMemoryAlloc))
 }
 
 func GetSystemGoroutines() int {
-	return int(systemGoroutines.Value())
-}
\ No newline at end of file
+	return int(value(systemGoroutines))
+}
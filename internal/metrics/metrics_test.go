@@ -1,13 +1,19 @@
 package metrics
 
 import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
 
 func TestGetLastRTT(t *testing.T) {
 	testRTT := 123 * time.Millisecond
-	RecordRTT(testRTT)
+	RecordRTT("s1", "primary", testRTT)
 	
 	if got := GetLastRTT(); got != testRTT {
 		t.Errorf("Expected last RTT %v, got %v", testRTT, got)
@@ -17,11 +23,116 @@ func TestGetLastRTT(t *testing.T) {
 func TestMetricsRecording(t *testing.T) {
 	// Test basic metric recording without HTTP server overhead
 	RecordPingSent()
-	RecordRTT(50 * time.Millisecond)
+	RecordRTT("s1", "primary", 50*time.Millisecond)
 	SetSessionHealthy(true)
 	
 	// Verify RTT was recorded
 	if got := GetLastRTT(); got != 50*time.Millisecond {
 		t.Errorf("Expected RTT 50ms, got %v", got)
 	}
-}
\ No newline at end of file
+}
+
+func TestDestinationClass(t *testing.T) {
+	tests := map[string]string{
+		"example.com:443":  "web",
+		"10.0.0.1:8080":    "web",
+		"1.1.1.1:53":       "dns",
+		"smtp.example:587": "mail",
+		"[::1]:22":         "ssh",
+		"db.internal:5432": "other",
+		"no-port":          "unknown",
+	}
+	for target, want := range tests {
+		if got := DestinationClass(target); got != want {
+			t.Errorf("DestinationClass(%q) = %q, want %q", target, got, want)
+		}
+	}
+}
+
+func TestHandlerOutput(t *testing.T) {
+	RecordRTT("handler-session", "secondary", 42*time.Millisecond)
+	RecordSOCKS5Connection()
+	RecordSOCKS5ConnectLatency("tunnel", "example.com:443", 80*time.Millisecond)
+	RecordRotationDuration(3 * time.Second)
+	
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	out := string(body)
+	
+	// Names scraped before the move to client_golang are still served
+	for _, line := range []string{
+		"# TYPE session_rtt_ms gauge",
+		"# TYPE socks5_connections_total counter",
+		"# TYPE active_sessions gauge",
+		"rotation_duration_seconds_sum ",
+		"rotation_duration_seconds_count ",
+		"uptime_seconds ",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("Expected %q in /metrics output", line)
+		}
+	}
+	
+	for _, line := range []string{
+		`session_rtt_milliseconds_bucket{role="secondary",le="50"} 1`,
+		`session_last_rtt_milliseconds{session_id="handler-session"} 42`,
+		`socks5_connect_latency_seconds_bucket{destination_class="web",route="tunnel",le="0.1"} 1`,
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("Expected %q in /metrics output", line)
+		}
+	}
+	
+	// Ended sessions drop their series
+	ForgetSession("handler-session")
+	rec = httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if strings.Contains(rec.Body.String(), `session_id="handler-session"`) {
+		t.Error("Expected the ended session's RTT gauge to be removed")
+	}
+}
+
+func TestMetricsServerShutdown(t *testing.T) {
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	addr := probe.Addr().String()
+	probe.Close()
+	
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- StartMetricsServer(ctx, addr)
+	}()
+	
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = http.Get("http://" + addr + "/metrics"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Metrics server never answered: %v", err)
+	}
+	resp.Body.Close()
+	
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Metrics server did not stop with its context")
+	}
+	
+	// The port is free again once the server has returned
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("Expected the metrics port to be released: %v", err)
+	}
+	ln.Close()
+}
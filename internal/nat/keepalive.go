@@ -0,0 +1,93 @@
+package nat
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultKeepaliveInterval is how often sessions are pinged while no ping
+	// has gone unanswered
+	DefaultKeepaliveInterval = 10 * time.Second
+
+	// DefaultKeepaliveMinInterval is the shortest interval missed pings
+	// bring it down to
+	DefaultKeepaliveMinInterval = 2 * time.Second
+
+	// keepaliveProbeAfter is how many pings in a row must be answered before
+	// a shortened interval is lengthened again
+	keepaliveProbeAfter = 30
+)
+
+// Keepalive adapts how often the orchestrator pings its sessions, which
+// keeps the NAT mappings of their punched paths alive while they are idle.
+// Some NATs drop a UDP mapping sooner than the configured interval, so a
+// missed ping halves the interval, down to the minimum, and marks the
+// interval that was too long. Once enough pings in a row are answered it
+// grows by a quarter again, but stays below the interval that last lost one.
+// It is shared by all sessions, since they go through the same NAT; a nil
+// *Keepalive keeps DefaultKeepaliveInterval.
+type Keepalive struct {
+	min, max time.Duration
+
+	mu       sync.Mutex
+	interval time.Duration
+	ceiling  time.Duration // the interval a ping was last missed at (max = none)
+	answered int           // pings answered in a row at the current interval
+}
+
+// NewKeepalive starts at interval and adapts down to minInterval (0 = the defaults)
+func NewKeepalive(interval, minInterval time.Duration) *Keepalive {
+	if interval <= 0 {
+		interval = DefaultKeepaliveInterval
+	}
+	if minInterval <= 0 {
+		minInterval = DefaultKeepaliveMinInterval
+	}
+	minInterval = min(minInterval, interval)
+	return &Keepalive{min: minInterval, max: interval, interval: interval, ceiling: interval}
+}
+
+// Interval returns how long to wait before the next ping
+func (k *Keepalive) Interval() time.Duration {
+	if k == nil {
+		return DefaultKeepaliveInterval
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.interval
+}
+
+// Missed shortens the interval after a ping went unanswered and returns it
+func (k *Keepalive) Missed() time.Duration {
+	if k == nil {
+		return DefaultKeepaliveInterval
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.answered = 0
+	k.ceiling = k.interval
+	k.interval = max(k.interval/2, k.min)
+	return k.interval
+}
+
+// Answered counts an answered ping, lengthening a shortened interval after
+// keepaliveProbeAfter in a row, and returns the interval
+func (k *Keepalive) Answered() time.Duration {
+	if k == nil {
+		return DefaultKeepaliveInterval
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.answered++
+	if k.answered < keepaliveProbeAfter || k.interval >= k.max {
+		return k.interval
+	}
+	k.answered = 0
+	limit := k.max
+	if k.ceiling < k.max {
+		limit = k.ceiling * 3 / 4
+	}
+	k.interval = max(k.interval, min(k.interval*5/4, limit))
+	return k.interval
+}
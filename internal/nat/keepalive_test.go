@@ -0,0 +1,46 @@
+package nat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeepaliveAdapts(t *testing.T) {
+	k := NewKeepalive(16*time.Second, 2*time.Second)
+	if got := k.Interval(); got != 16*time.Second {
+		t.Fatalf("initial interval = %v, want 16s", got)
+	}
+
+	// Each missed ping halves it, down to the minimum
+	for _, want := range []time.Duration{8 * time.Second, 4 * time.Second, 2 * time.Second, 2 * time.Second} {
+		if got := k.Missed(); got != want {
+			t.Fatalf("after a missed ping = %v, want %v", got, want)
+		}
+	}
+
+	// Answered pings grow it by a quarter at a time, below 3/4 of the
+	// interval that last lost a ping
+	k = NewKeepalive(16*time.Second, 2*time.Second)
+	k.Missed() // lost at 16s, now 8s
+	k.Missed() // lost at 8s, now 4s
+	for i := 0; i < keepaliveProbeAfter-1; i++ {
+		k.Answered()
+	}
+	if got := k.Interval(); got != 4*time.Second {
+		t.Fatalf("interval grew before %d answered pings: %v", keepaliveProbeAfter, got)
+	}
+	if got := k.Answered(); got != 5*time.Second {
+		t.Fatalf("after %d answered pings = %v, want 5s", keepaliveProbeAfter, got)
+	}
+	for i := 0; i < 10*keepaliveProbeAfter; i++ {
+		k.Answered()
+	}
+	if got := k.Interval(); got != 6*time.Second {
+		t.Fatalf("grown interval = %v, want 6s (3/4 of the 8s that lost a ping)", got)
+	}
+
+	var none *Keepalive
+	if none.Interval() != DefaultKeepaliveInterval || none.Missed() != DefaultKeepaliveInterval {
+		t.Fatal("a nil Keepalive should keep the default interval")
+	}
+}
@@ -13,20 +13,44 @@ type Traversal interface {
 	PerformHolePunch(conn *net.UDPConn, sessionID string, lambdaAddr *net.UDPAddr, timeout time.Duration) error
 }
 
+// Options configures a DefaultTraversal
+type Options struct {
+	// Ports constrains the local UDP port used for hole punching (zero value = any)
+	Ports shared.PortRange
+
+	// PredictPorts also punches towards this many ports on either side of the
+	// Lambda's reported port, for NATs that don't preserve it (0 = disabled)
+	PredictPorts int
+}
+
 // DefaultTraversal implements Traversal
-type DefaultTraversal struct{}
+type DefaultTraversal struct {
+	opts Options
+}
 
 // New creates a new NAT traversal client
 func New() Traversal {
-	return &DefaultTraversal{}
+	return NewWithOptions(Options{})
+}
+
+// NewWithPortRange creates a NAT traversal client that binds hole punching
+// sockets to a port in ports
+func NewWithPortRange(ports shared.PortRange) Traversal {
+	return NewWithOptions(Options{Ports: ports})
+}
+
+// NewWithOptions creates a NAT traversal client with the given options
+func NewWithOptions(opts Options) Traversal {
+	return &DefaultTraversal{opts: opts}
 }
 
 // CreateUDPSocket creates a UDP socket for hole punching
 func (n *DefaultTraversal) CreateUDPSocket() (*net.UDPConn, int, error) {
-	return shared.CreateUDPSocket()
+	return shared.CreateUDPSocketInRange(n.opts.Ports)
 }
 
 // PerformHolePunch performs NAT hole punching with the Lambda
 func (n *DefaultTraversal) PerformHolePunch(conn *net.UDPConn, sessionID string, lambdaAddr *net.UDPAddr, timeout time.Duration) error {
-	return shared.PerformNATHolePunch(conn, sessionID, lambdaAddr, timeout, true)
-}
\ No newline at end of file
+	_, err := shared.PerformNATHolePunchWithPrediction(conn, sessionID, lambdaAddr, timeout, true, n.opts.PredictPorts)
+	return err
+}
@@ -0,0 +1,19 @@
+//go:build darwin
+
+package notify
+
+import (
+	"context"
+	"os/exec"
+)
+
+// desktopCommand posts a Notification Center banner titled with the app
+// name. The text is passed as script arguments rather than spliced into the
+// script, so it needs no quoting.
+func desktopCommand(ctx context.Context, title, message string) (*exec.Cmd, error) {
+	return exec.CommandContext(ctx, "osascript",
+		"-e", "on run argv",
+		"-e", "display notification (item 3 of argv) with title (item 1 of argv) subtitle (item 2 of argv)",
+		"-e", "end run",
+		appName, title, message), nil
+}
@@ -0,0 +1,15 @@
+//go:build !darwin && !windows && !linux && !freebsd && !netbsd && !openbsd && !dragonfly
+
+package notify
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// desktopCommand reports that this OS has no supported notification center
+func desktopCommand(ctx context.Context, title, message string) (*exec.Cmd, error) {
+	return nil, fmt.Errorf("desktop notifications are not supported on %s", runtime.GOOS)
+}
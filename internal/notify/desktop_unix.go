@@ -0,0 +1,14 @@
+//go:build linux || freebsd || netbsd || openbsd || dragonfly
+
+package notify
+
+import (
+	"context"
+	"os/exec"
+)
+
+// desktopCommand sends a freedesktop notification, which needs notify-send
+// (libnotify) and a running notification daemon
+func desktopCommand(ctx context.Context, title, message string) (*exec.Cmd, error) {
+	return exec.CommandContext(ctx, "notify-send", "--app-name="+appName, title, message), nil
+}
@@ -0,0 +1,28 @@
+//go:build windows
+
+package notify
+
+import (
+	"context"
+	"os"
+	"os/exec"
+)
+
+// toastScript shows a toast under PowerShell's app ID, which Windows lets
+// unpackaged programs use. The text arrives in environment variables so it
+// needs no quoting.
+const toastScript = `$ErrorActionPreference = 'Stop'
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] | Out-Null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $template.GetElementsByTagName('text')
+$text.Item(0).AppendChild($template.CreateTextNode($env:LNP_NOTIFY_TITLE)) | Out-Null
+$text.Item(1).AppendChild($template.CreateTextNode($env:LNP_NOTIFY_MESSAGE)) | Out-Null
+$appID = '{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe'
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier($appID).Show([Windows.UI.Notifications.ToastNotification]::new($template))`
+
+// desktopCommand shows a toast notification with PowerShell
+func desktopCommand(ctx context.Context, title, message string) (*exec.Cmd, error) {
+	cmd := exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", toastScript)
+	cmd.Env = append(os.Environ(), "LNP_NOTIFY_TITLE="+appName+": "+title, "LNP_NOTIFY_MESSAGE="+message)
+	return cmd, nil
+}
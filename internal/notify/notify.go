@@ -0,0 +1,70 @@
+// Package notify shows desktop notifications for events a user running the
+// proxy in the background should know about, such as a new egress IP.
+package notify
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// notifyTimeout bounds how long the notification command may run
+const notifyTimeout = 10 * time.Second
+
+// appName is shown as the sender of notifications where the OS supports it
+const appName = "lambda-nat-proxy"
+
+// Notifier shows a short message to the user
+type Notifier interface {
+	Notify(title, message string)
+}
+
+// Desktop shows notifications with the operating system's notification
+// center: osascript on macOS, notify-send on Linux and the BSDs, and a
+// PowerShell toast on Windows
+type Desktop struct {
+	failed atomic.Bool // a notification has failed and been logged
+}
+
+// NewDesktop creates a desktop notifier
+func NewDesktop() *Desktop {
+	return &Desktop{}
+}
+
+// Notify shows title and message in the background. Public IPs are redacted
+// in privacy mode. Only the first failure is logged, so a machine without a
+// notification center doesn't fill the log.
+func (d *Desktop) Notify(title, message string) {
+	message = shared.RedactPublicIPs(message)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		defer cancel()
+
+		cmd, err := desktopCommand(ctx, title, message)
+		if err == nil {
+			var output []byte
+			if output, err = cmd.CombinedOutput(); err != nil && len(output) > 0 {
+				err = &commandError{err: err, output: output}
+			}
+		}
+		if err != nil && d.failed.CompareAndSwap(false, true) {
+			shared.LogErrorf("Desktop notification failed, later failures are not logged: %v", err)
+		}
+	}()
+}
+
+// commandError is a failed notification command with its output
+type commandError struct {
+	err    error
+	output []byte
+}
+
+func (e *commandError) Error() string {
+	return e.err.Error() + ": " + string(e.output)
+}
+
+func (e *commandError) Unwrap() error {
+	return e.err
+}
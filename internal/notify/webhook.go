@@ -0,0 +1,155 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// Webhook formats, picked from the webhook URL's host
+const (
+	FormatJSON    = "json"
+	FormatSlack   = "slack"
+	FormatDiscord = "discord"
+)
+
+// WebhookFormat returns the message format a webhook URL expects: Slack's
+// and Discord's incoming webhooks take their own, and anything else gets
+// the generic JSON body
+func WebhookFormat(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return FormatJSON
+	}
+	host := strings.ToLower(u.Hostname())
+	switch {
+	case host == "hooks.slack.com":
+		return FormatSlack
+	case (host == "discord.com" || host == "discordapp.com") && strings.HasPrefix(u.Path, "/api/webhooks/"):
+		return FormatDiscord
+	}
+	return FormatJSON
+}
+
+// ValidateWebhook checks that rawURL can receive notifications
+func ValidateWebhook(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL %q: %w", rawURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid webhook URL %q: scheme must be http or https", rawURL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid webhook URL %q: missing host", rawURL)
+	}
+	return nil
+}
+
+// webhookMessage is the generic JSON body posted to a webhook
+type webhookMessage struct {
+	App     string    `json:"app"`
+	Title   string    `json:"title"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// Webhook posts notifications to a URL as JSON, in Slack's or Discord's
+// format for their incoming webhooks. It goes through the configured HTTP
+// proxy, like the proxy's other outbound HTTPS.
+type Webhook struct {
+	url    string
+	format string
+	client *http.Client
+	failed atomic.Bool // a notification has failed and been logged
+}
+
+// NewWebhook creates a notifier posting to rawURL
+func NewWebhook(rawURL string) *Webhook {
+	return &Webhook{
+		url:    rawURL,
+		format: WebhookFormat(rawURL),
+		client: &http.Client{Timeout: notifyTimeout},
+	}
+}
+
+// body returns the request body for a notification
+func (w *Webhook) body(title, message string) ([]byte, error) {
+	switch w.format {
+	case FormatSlack:
+		return json.Marshal(map[string]string{"text": fmt.Sprintf("*%s: %s*\n%s", appName, title, message)})
+	case FormatDiscord:
+		return json.Marshal(map[string]string{"content": fmt.Sprintf("**%s: %s**\n%s", appName, title, message)})
+	}
+	return json.Marshal(webhookMessage{App: appName, Title: title, Message: message, Time: time.Now().UTC()})
+}
+
+// Notify posts title and message in the background. Public IPs are
+// redacted in privacy mode. Only the first failure is logged, so an
+// unreachable endpoint doesn't fill the log.
+func (w *Webhook) Notify(title, message string) {
+	message = shared.RedactPublicIPs(message)
+	go func() {
+		if err := w.post(title, message); err != nil && w.failed.CompareAndSwap(false, true) {
+			shared.LogErrorf("Webhook notification to %s failed, later failures are not logged: %v", w.host(), err)
+		}
+	}()
+}
+
+// post sends one notification and waits for the answer
+func (w *Webhook) post(title, message string) error {
+	body, err := w.body(title, message)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		// The request error repeats the URL, and with it the webhook's token
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		answer, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(answer)))
+	}
+	return nil
+}
+
+// host names the webhook in logs without the secret token its path holds
+func (w *Webhook) host() string {
+	if u, err := url.Parse(w.url); err == nil {
+		return u.Host
+	}
+	return "webhook"
+}
+
+// Multi sends every notification to each of its notifiers
+type Multi []Notifier
+
+// Notify passes title and message to each notifier
+func (m Multi) Notify(title, message string) {
+	for _, n := range m {
+		n.Notify(title, message)
+	}
+}
@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhookFormat(t *testing.T) {
+	tests := map[string]string{
+		"https://hooks.slack.com/services/T0/B0/secret": FormatSlack,
+		"https://discord.com/api/webhooks/1/secret":     FormatDiscord,
+		"https://discordapp.com/api/webhooks/1/secret":  FormatDiscord,
+		"https://discord.com/channels/1":                FormatJSON,
+		"https://ntfy.example.com/lambda-nat-proxy":     FormatJSON,
+		"http://127.0.0.1:9000/hook":                    FormatJSON,
+	}
+	for rawURL, want := range tests {
+		if got := WebhookFormat(rawURL); got != want {
+			t.Errorf("WebhookFormat(%q) = %s, want %s", rawURL, got, want)
+		}
+	}
+
+	for _, bad := range []string{"ftp://example.com/hook", "https://", "://nope"} {
+		if err := ValidateWebhook(bad); err == nil {
+			t.Errorf("Expected %q to be refused", bad)
+		}
+	}
+}
+
+func TestWebhookPost(t *testing.T) {
+	var got map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Expected a JSON body, got %s", r.Header.Get("Content-Type"))
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		if strings.HasSuffix(r.URL.Path, "/fail") {
+			http.Error(w, "invalid token", http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	if err := NewWebhook(server.URL+"/hook").post("All sessions down", "reconnecting"); err != nil {
+		t.Fatalf("post failed: %v", err)
+	}
+	if got["app"] != appName || got["title"] != "All sessions down" || got["message"] != "reconnecting" {
+		t.Errorf("Unexpected generic body: %v", got)
+	}
+
+	slack := NewWebhook(server.URL + "/hook")
+	slack.format = FormatSlack
+	if err := slack.post("Rotation failing", "3 in a row"); err != nil {
+		t.Fatalf("post failed: %v", err)
+	}
+	if text, _ := got["text"].(string); !strings.Contains(text, "Rotation failing") || !strings.Contains(text, "3 in a row") {
+		t.Errorf("Unexpected Slack body: %v", got)
+	}
+
+	err := NewWebhook(server.URL+"/fail").post("title", "message")
+	if err == nil || !strings.Contains(err.Error(), "invalid token") {
+		t.Errorf("Expected the endpoint's error, got %v", err)
+	}
+}
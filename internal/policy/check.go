@@ -0,0 +1,67 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/tunnel"
+)
+
+// ErrRefused matches the errors Check returns when the ACL, a deny rule or
+// a used-up quota refuses a connection
+var ErrRefused = errors.New("connection refused by policy")
+
+// ErrQuotaUsedUp matches the error Check returns when client's quota is used up
+var ErrQuotaUsedUp = errors.New("policy quota used up")
+
+// refusal is a connection Check refused, logged as its reason alone
+type refusal struct {
+	err error
+}
+
+func (r *refusal) Error() string        { return r.err.Error() }
+func (r *refusal) Unwrap() error        { return r.err }
+func (r *refusal) Is(target error) bool { return target == ErrRefused }
+
+// Check applies acl, then p's first matching rule and client's quota, to a
+// connection to target. Refusals match ErrRefused; other errors mean the
+// policy couldn't be evaluated. An empty client skips the quota, for
+// connections no quota counts.
+func (p *Policy) Check(ctx context.Context, acl *shared.ACL, client, target string) (Decision, error) {
+	if err := acl.Check(target); err != nil {
+		return Decision{}, &refusal{err}
+	}
+	decision, err := p.EvaluateContext(ctx, target)
+	if err != nil {
+		return decision, err
+	}
+	if decision.Action == ActionDeny {
+		return decision, &refusal{fmt.Errorf("%s denied by policy rule %s", target, decision.Rule)}
+	}
+	if client != "" && p.Quotas().Exceeded(client) {
+		return decision, &refusal{ErrQuotaUsedUp}
+	}
+	return decision, nil
+}
+
+// DialerPolicy returns the check a tunnel.Dialer applies before opening a
+// stream: the same acl and rules as the SOCKS5 listener. The Dialer only
+// tunnels through the session its selector picks, so it also refuses targets
+// a rule sends directly or through another region.
+func (p *Policy) DialerPolicy(acl *shared.ACL) tunnel.Policy {
+	return tunnel.PolicyFunc(func(ctx context.Context, target string) error {
+		decision, err := p.Check(ctx, acl, "", target)
+		if err != nil {
+			return err
+		}
+		switch {
+		case decision.Action == ActionDirect:
+			return &refusal{fmt.Errorf("policy rule %s sends %s directly, which the tunnel dialer can't", decision.Rule, target)}
+		case decision.Egress != "":
+			return &refusal{fmt.Errorf("policy rule %s sends %s through %s, which the tunnel dialer can't", decision.Rule, target, decision.Egress)}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,363 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// Rule actions
+const (
+	ActionTunnel = "tunnel" // through the Lambda (the default)
+	ActionDirect = "direct" // from this machine, bypassing the tunnel
+	ActionDeny   = "deny"   // refused
+)
+
+// DefaultQuotaPeriod is how long a quota lasts before it resets, if unset
+const DefaultQuotaPeriod = 24 * time.Hour
+
+// Document is a policy file. Rules are checked in order and the first whose
+// match list covers a destination decides its action; destinations matching
+// no rule get Default.
+//
+//	default: tunnel
+//	rules:
+//	  - name: corp
+//	    match: ["*.corp.example.com", "10.0.0.0/8"]
+//	    action: direct
+//	  - name: no-mail
+//	    match: [":25", ":465", ":587"]
+//	    action: deny
+//	  - name: video
+//	    match: ["*.googlevideo.com"]
+//	    rate_limit: 2MB
+//	  - name: eu
+//	    match: ["*.example.eu"]
+//	    egress: eu-west-1
+//	limits:
+//	  global: 10MB
+//	  per_client: 5MB
+//	quotas:
+//	  per_client: 20GB
+//	  period: 24h
+//	resolve:
+//	  enabled: true
+type Document struct {
+	Default string      `yaml:"default"`
+	Rules   []RuleSpec  `yaml:"rules"`
+	Limits  LimitsSpec  `yaml:"limits"`
+	Quotas  QuotaSpec   `yaml:"quotas"`
+	Resolve ResolveSpec `yaml:"resolve"`
+}
+
+// RuleSpec matches destinations in ACL syntax ("*.example.com", "10.0.0.0/8",
+// ":25", "private" ...) and gives them an action (empty = tunnel). RateLimit
+// caps the bandwidth shared by every connection the rule matches. Egress
+// names the AWS region whose sessions tunnel rules use (empty = the proxy's
+// own region).
+type RuleSpec struct {
+	Name      string   `yaml:"name"`
+	Match     []string `yaml:"match"`
+	Action    string   `yaml:"action"`
+	RateLimit string   `yaml:"rate_limit"`
+	Egress    string   `yaml:"egress"`
+}
+
+// LimitsSpec holds bandwidth caps per second, e.g. "5MB" (empty = unlimited)
+type LimitsSpec struct {
+	Global         string `yaml:"global"`
+	PerClient      string `yaml:"per_client"`
+	PerDestination string `yaml:"per_destination"`
+}
+
+// QuotaSpec caps the bytes each client IP may move per Period (empty = no quota)
+type QuotaSpec struct {
+	PerClient string        `yaml:"per_client"`
+	Period    time.Duration `yaml:"period"`
+}
+
+// ResolveSpec has domains that match no rule by name checked against the IP
+// rules with the address they resolve to on this machine. Answers are reused
+// for their DNS TTL, capped at MaxTTL, and then resolved and checked again.
+type ResolveSpec struct {
+	Enabled bool          `yaml:"enabled"`
+	Server  string        `yaml:"server"`  // DNS server, "10.0.0.53" or "tls://1.1.1.1" (empty = the system's)
+	MaxTTL  time.Duration `yaml:"max_ttl"` // longest an answer is trusted (0 = 5m)
+}
+
+// Decision is what a policy says to do with a destination
+type Decision struct {
+	Action    string
+	Rule      string              // the rule that matched; empty for the default
+	RateLimit int64               // the rule's bandwidth cap in bytes per second (0 = none)
+	Limiter   *shared.RateLimiter // shared by connections matching the rule; nil = none
+	Address   string              // the resolved address the rule matched; empty if it matched the name
+	Egress    string              // the region to tunnel through; empty for the proxy's own
+}
+
+// Policy is a parsed policy file. A nil *Policy tunnels everything.
+type Policy struct {
+	defaultAction string
+	rules         []rule
+	limits        shared.BandwidthLimits
+	quotas        *QuotaTracker
+	quotaSpec     QuotaSpec
+	resolver      hostResolver // nil = domains are matched by name only
+}
+
+// hostResolver looks up a domain's addresses, caching them for their TTL
+type hostResolver interface {
+	LookupIP(ctx context.Context, host string) ([]net.IP, error)
+}
+
+type rule struct {
+	name      string
+	matchers  []*shared.DestinationRule
+	action    string
+	rateLimit int64
+	limiter   *shared.RateLimiter
+	egress    string
+}
+
+// Load reads and parses the policy file at path
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file: %w", err)
+	}
+	policy, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %w", path, err)
+	}
+	return policy, nil
+}
+
+// Parse parses a policy document
+func Parse(data []byte) (*Policy, error) {
+	var doc Document
+	if err := yaml.UnmarshalStrict(data, &doc); err != nil {
+		return nil, err
+	}
+	return New(doc)
+}
+
+// New compiles doc into a policy
+func New(doc Document) (*Policy, error) {
+	defaultAction, err := parseAction(doc.Default)
+	if err != nil {
+		return nil, fmt.Errorf("default: %w", err)
+	}
+	p := &Policy{defaultAction: defaultAction}
+
+	for i, spec := range doc.Rules {
+		r := rule{name: spec.Name}
+		if r.name == "" {
+			r.name = fmt.Sprintf("rules[%d]", i)
+		}
+		if r.action, err = parseAction(spec.Action); err != nil {
+			return nil, fmt.Errorf("rule %s: %w", r.name, err)
+		}
+		if len(spec.Match) == 0 {
+			return nil, fmt.Errorf("rule %s: match is empty", r.name)
+		}
+		for _, text := range spec.Match {
+			matcher, err := shared.ParseDestinationRule(text)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: %w", r.name, err)
+			}
+			r.matchers = append(r.matchers, matcher)
+		}
+		if r.rateLimit, err = shared.ParseByteRate(spec.RateLimit); err != nil {
+			return nil, fmt.Errorf("rule %s: %w", r.name, err)
+		}
+		if r.rateLimit > 0 && r.action == ActionDeny {
+			return nil, fmt.Errorf("rule %s: a deny rule cannot have a rate limit", r.name)
+		}
+		r.limiter = shared.NewRateLimiter(r.rateLimit)
+		if r.egress = strings.ToLower(strings.TrimSpace(spec.Egress)); r.egress != "" {
+			if r.action != ActionTunnel {
+				return nil, fmt.Errorf("rule %s: only a tunnel rule can have an egress", r.name)
+			}
+			if !shared.ValidRegion(r.egress) {
+				return nil, fmt.Errorf("rule %s: egress %q is not an AWS region", r.name, spec.Egress)
+			}
+		}
+		p.rules = append(p.rules, r)
+	}
+
+	for _, limit := range []struct {
+		field string
+		value string
+		dest  *int64
+	}{
+		{"limits.global", doc.Limits.Global, &p.limits.Global},
+		{"limits.per_client", doc.Limits.PerClient, &p.limits.PerClient},
+		{"limits.per_destination", doc.Limits.PerDestination, &p.limits.PerDestination},
+	} {
+		if *limit.dest, err = shared.ParseByteRate(limit.value); err != nil {
+			return nil, fmt.Errorf("%s: %w", limit.field, err)
+		}
+	}
+
+	quota, err := shared.ParseByteSize(doc.Quotas.PerClient)
+	if err != nil {
+		return nil, fmt.Errorf("quotas.per_client: %w", err)
+	}
+	if doc.Quotas.Period < 0 {
+		return nil, fmt.Errorf("quotas.period cannot be negative")
+	}
+	p.quotaSpec = doc.Quotas
+	if p.quotaSpec.Period == 0 {
+		p.quotaSpec.Period = DefaultQuotaPeriod
+	}
+	p.quotas = NewQuotaTracker(quota, p.quotaSpec.Period)
+
+	if doc.Resolve.Enabled {
+		server := doc.Resolve.Server
+		if server == "" {
+			if server, err = shared.SystemDNSUpstream(); err != nil {
+				return nil, fmt.Errorf("resolve: %w", err)
+			}
+		}
+		resolver, err := shared.NewDNSResolver(shared.DNSConfig{Upstream: server, MaxTTL: doc.Resolve.MaxTTL})
+		if err != nil {
+			return nil, fmt.Errorf("resolve: %w", err)
+		}
+		p.resolver = resolver
+	}
+	return p, nil
+}
+
+// parseAction checks a rule action, defaulting an empty one to tunnel
+func parseAction(action string) (string, error) {
+	switch strings.ToLower(action) {
+	case "", ActionTunnel:
+		return ActionTunnel, nil
+	case ActionDirect:
+		return ActionDirect, nil
+	case ActionDeny:
+		return ActionDeny, nil
+	default:
+		return "", fmt.Errorf("invalid action %q (must be %q, %q or %q)", action, ActionTunnel, ActionDirect, ActionDeny)
+	}
+}
+
+// Evaluate decides what to do with a connection to target ("host:port")
+func (p *Policy) Evaluate(target string) (Decision, error) {
+	if p == nil {
+		return Decision{Action: ActionTunnel}, nil
+	}
+	for _, r := range p.rules {
+		for _, matcher := range r.matchers {
+			matched, err := matcher.Matches(target)
+			if err != nil {
+				return Decision{}, err
+			}
+			if matched {
+				return Decision{Action: r.action, Rule: r.name, RateLimit: r.rateLimit, Limiter: r.limiter, Egress: r.egress}, nil
+			}
+		}
+	}
+	return Decision{Action: p.defaultAction}, nil
+}
+
+// EvaluateContext is Evaluate, except that with resolve enabled a domain no
+// rule matches by name is checked again with its first resolved address, so
+// a name that moves into or out of a direct range follows it once its DNS
+// record expires. A failed lookup leaves the name's own decision.
+func (p *Policy) EvaluateContext(ctx context.Context, target string) (Decision, error) {
+	decision, err := p.Evaluate(target)
+	if err != nil || decision.Rule != "" || p == nil || p.resolver == nil {
+		return decision, err
+	}
+	host, port, err := net.SplitHostPort(target)
+	if err != nil || net.ParseIP(host) != nil {
+		return decision, nil
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, shared.DNSQueryTimeout)
+	defer cancel()
+	ips, err := p.resolver.LookupIP(lookupCtx, host)
+	if err != nil || len(ips) == 0 {
+		shared.LogNetworkf("Policy: matching %s by name only, lookup failed: %v", host, err)
+		return decision, nil
+	}
+	resolved, err := p.Evaluate(net.JoinHostPort(ips[0].String(), port))
+	if err != nil || resolved.Rule == "" {
+		return decision, err
+	}
+	resolved.Address = ips[0].String()
+	return resolved, nil
+}
+
+// EgressRegions returns the regions rules send traffic through, in rule order
+func (p *Policy) EgressRegions() []string {
+	if p == nil {
+		return nil
+	}
+	var regions []string
+	seen := make(map[string]bool)
+	for _, r := range p.rules {
+		if r.egress != "" && !seen[r.egress] {
+			seen[r.egress] = true
+			regions = append(regions, r.egress)
+		}
+	}
+	return regions
+}
+
+// Bandwidth returns the policy's global, per-client and per-destination caps
+func (p *Policy) Bandwidth() shared.BandwidthLimits {
+	if p == nil {
+		return shared.BandwidthLimits{}
+	}
+	return p.limits
+}
+
+// Quotas returns the per-client quota tracker, nil if there is no quota
+func (p *Policy) Quotas() *QuotaTracker {
+	if p == nil {
+		return nil
+	}
+	return p.quotas
+}
+
+// QuotaSpec returns the quota as written, with the period defaulted
+func (p *Policy) QuotaSpec() QuotaSpec {
+	if p == nil {
+		return QuotaSpec{}
+	}
+	return p.quotaSpec
+}
+
+// LambdaACL returns destination rules for the Lambda to enforce as well. The
+// Lambda's ACL lets allow rules win regardless of order, so every tunnel and
+// direct rule becomes an allow rule and every deny rule a deny rule. It then
+// never refuses what the policy tunnels, and refuses what the policy denies
+// unless a tunnel or direct rule also matches it.
+func (p *Policy) LambdaACL() shared.ACLConfig {
+	var acl shared.ACLConfig
+	if p == nil {
+		return acl
+	}
+	if p.defaultAction == ActionDeny {
+		acl.Default = shared.ACLDeny
+	}
+	for _, r := range p.rules {
+		for _, matcher := range r.matchers {
+			if r.action == ActionDeny {
+				acl.Deny = append(acl.Deny, matcher.String())
+			} else {
+				acl.Allow = append(acl.Allow, matcher.String())
+			}
+		}
+	}
+	return acl
+}
@@ -0,0 +1,246 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+const testPolicy = `
+default: tunnel
+rules:
+  - name: corp
+    match: ["*.corp.example.com", "10.0.0.0/8"]
+    action: direct
+  - name: no-mail
+    match: [":25"]
+    action: deny
+  - name: video
+    match: ["*.video.example.com"]
+    rate_limit: 2MB
+  - match: ["private"]
+    action: deny
+  - name: eu
+    match: ["*.example.eu"]
+    egress: EU-West-1
+limits:
+  global: 10MB
+quotas:
+  per_client: 1KB
+`
+
+func TestEvaluate(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	tests := []struct {
+		target string
+		action string
+		rule   string
+	}{
+		{"git.corp.example.com:443", ActionDirect, "corp"},
+		{"10.1.2.3:22", ActionDirect, "corp"},             // corp wins over the later private rule
+		{"git.corp.example.com:25", ActionDirect, "corp"}, // first match wins
+		{"mail.example.org:25", ActionDeny, "no-mail"},
+		{"cdn.video.example.com:443", ActionTunnel, "video"},
+		{"192.168.1.1:80", ActionDeny, "rules[3]"},
+		{"example.org:443", ActionTunnel, ""},
+		{"bank.example.eu:443", ActionTunnel, "eu"},
+	}
+	for _, tt := range tests {
+		decision, err := p.Evaluate(tt.target)
+		if err != nil {
+			t.Fatalf("Evaluate(%s) failed: %v", tt.target, err)
+		}
+		if decision.Action != tt.action || decision.Rule != tt.rule {
+			t.Errorf("Evaluate(%s) = %s by %q, expected %s by %q", tt.target, decision.Action, decision.Rule, tt.action, tt.rule)
+		}
+	}
+
+	decision, _ := p.Evaluate("cdn.video.example.com:443")
+	if decision.RateLimit != 2<<20 || decision.Limiter == nil {
+		t.Errorf("Expected the video rule's 2MB limiter, got %+v", decision)
+	}
+	if decision, _ := p.Evaluate("bank.example.eu:443"); decision.Egress != "eu-west-1" {
+		t.Errorf("Expected the eu rule to egress through eu-west-1, got %q", decision.Egress)
+	}
+	if regions := p.EgressRegions(); len(regions) != 1 || regions[0] != "eu-west-1" {
+		t.Errorf("Expected egress regions [eu-west-1], got %v", regions)
+	}
+	if p.Bandwidth().Global != 10<<20 {
+		t.Errorf("Expected a 10MB global limit, got %d", p.Bandwidth().Global)
+	}
+	if p.QuotaSpec().Period != DefaultQuotaPeriod {
+		t.Errorf("Expected the default quota period, got %v", p.QuotaSpec().Period)
+	}
+
+	var nilPolicy *Policy
+	if decision, _ := nilPolicy.Evaluate("example.org:443"); decision.Action != ActionTunnel {
+		t.Errorf("Expected a nil policy to tunnel, got %s", decision.Action)
+	}
+}
+
+// fakeResolver answers lookups from a map
+type fakeResolver map[string]string
+
+func (r fakeResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	ip, ok := r[host]
+	if !ok {
+		return nil, fmt.Errorf("no such host: %s", host)
+	}
+	return []net.IP{net.ParseIP(ip)}, nil
+}
+
+func TestEvaluateContextResolve(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	answers := fakeResolver{"intranet.example.net": "10.4.5.6", "mail.example.org": "10.0.0.25"}
+	p.resolver = answers
+
+	ctx := context.Background()
+	decision, err := p.EvaluateContext(ctx, "intranet.example.net:443")
+	if err != nil || decision.Action != ActionDirect || decision.Rule != "corp" || decision.Address != "10.4.5.6" {
+		t.Errorf("Expected direct by corp for its address, got %+v (%v)", decision, err)
+	}
+
+	// Once the name resolves outside the range it is tunneled again
+	answers["intranet.example.net"] = "203.0.113.7"
+	if decision, _ := p.EvaluateContext(ctx, "intranet.example.net:443"); decision.Action != ActionTunnel || decision.Address != "" {
+		t.Errorf("Expected the moved name to be tunneled, got %+v", decision)
+	}
+
+	// A rule matching the name wins over its address
+	if decision, _ := p.EvaluateContext(ctx, "mail.example.org:25"); decision.Rule != "no-mail" {
+		t.Errorf("Expected the name's own rule, got %+v", decision)
+	}
+	// A failed lookup keeps the name's decision
+	if decision, _ := p.EvaluateContext(ctx, "unknown.example.net:443"); decision.Action != ActionTunnel {
+		t.Errorf("Expected a failed lookup to tunnel, got %+v", decision)
+	}
+
+	var nilPolicy *Policy
+	if decision, _ := nilPolicy.EvaluateContext(ctx, "example.org:443"); decision.Action != ActionTunnel {
+		t.Errorf("Expected a nil policy to tunnel, got %s", decision.Action)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	acl, err := shared.NewACL(shared.ACLConfig{Deny: []string{"*.blocked.example.com"}})
+	if err != nil {
+		t.Fatalf("NewACL failed: %v", err)
+	}
+	ctx := context.Background()
+
+	if decision, err := p.Check(ctx, acl, "10.0.0.1", "www.example.org:443"); err != nil || decision.Action != ActionTunnel {
+		t.Errorf("Expected an allowed tunnel, got %+v (%v)", decision, err)
+	}
+	if _, err := p.Check(ctx, acl, "10.0.0.1", "www.blocked.example.com:443"); !errors.Is(err, ErrRefused) || !errors.Is(err, shared.ErrACLDenied) {
+		t.Errorf("Expected an ACL refusal, got %v", err)
+	}
+	if _, err := p.Check(ctx, acl, "10.0.0.1", "mail.example.org:25"); !errors.Is(err, ErrRefused) || errors.Is(err, ErrQuotaUsedUp) {
+		t.Errorf("Expected a refusal by rule no-mail, got %v", err)
+	}
+
+	p.Quotas().Add("10.0.0.1", 1024)
+	if _, err := p.Check(ctx, acl, "10.0.0.1", "www.example.org:443"); !errors.Is(err, ErrRefused) || !errors.Is(err, ErrQuotaUsedUp) {
+		t.Errorf("Expected a quota refusal, got %v", err)
+	}
+	if _, err := p.Check(ctx, acl, "", "www.example.org:443"); err != nil {
+		t.Errorf("Expected no quota without a client, got %v", err)
+	}
+}
+
+func TestDialerPolicy(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	check := p.DialerPolicy(nil)
+	ctx := context.Background()
+
+	if err := check.CheckTarget(ctx, "www.example.org:443"); err != nil {
+		t.Errorf("Expected a tunnelled target to be allowed, got %v", err)
+	}
+	// The dialer can only tunnel through the session it was given
+	for _, target := range []string{"mail.example.org:25", "db.corp.example.com:5432", "shop.example.eu:443"} {
+		if err := check.CheckTarget(ctx, target); !errors.Is(err, ErrRefused) {
+			t.Errorf("Expected %s to be refused, got %v", target, err)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		doc  string
+		want string
+	}{
+		{"default: maybe", "invalid action"},
+		{"rules: [{name: empty}]", "match is empty"},
+		{"rules: [{match: ['*']}]", "matches every destination"},
+		{"rules: [{match: [':25'], action: deny, rate_limit: 1MB}]", "cannot have a rate limit"},
+		{"rules: [{match: [':25'], action: direct, egress: eu-west-1}]", "only a tunnel rule"},
+		{"rules: [{match: [':25'], egress: europe}]", "not an AWS region"},
+		{"limits: {global: fast}", "limits.global"},
+		{"quotas: {per_client: lots}", "quotas.per_client"},
+		{"rule: []", "not found"},
+	}
+	for _, tt := range tests {
+		if _, err := Parse([]byte(tt.doc)); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) = %v, expected an error containing %q", tt.doc, err, tt.want)
+		}
+	}
+}
+
+func TestLambdaACL(t *testing.T) {
+	p, err := Parse([]byte("default: deny\nrules:\n  - {match: ['*.example.com'], action: direct}\n  - {match: [':25'], action: deny}\n  - {match: ['example.org']}\n"))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	acl := p.LambdaACL()
+	if acl.Default != "deny" {
+		t.Errorf("Expected default deny, got %q", acl.Default)
+	}
+	if strings.Join(acl.Allow, ",") != "*.example.com,example.org" || strings.Join(acl.Deny, ",") != ":25" {
+		t.Errorf("Unexpected Lambda ACL %+v", acl)
+	}
+}
+
+func TestQuotaTracker(t *testing.T) {
+	now := time.Now()
+	q := NewQuotaTracker(100, time.Hour)
+	q.now = func() time.Time { return now }
+
+	if !q.Add("10.0.0.1", 60) {
+		t.Error("Expected quota left after 60 of 100 bytes")
+	}
+	if q.Add("10.0.0.1", 40) || !q.Exceeded("10.0.0.1") {
+		t.Error("Expected the quota used up at 100 bytes")
+	}
+	if q.Exceeded("10.0.0.2") {
+		t.Error("Expected other clients to have their own quota")
+	}
+
+	now = now.Add(time.Hour)
+	if q.Exceeded("10.0.0.1") {
+		t.Error("Expected the quota to reset after the period")
+	}
+
+	var none *QuotaTracker
+	if none.Exceeded("10.0.0.1") || !none.Add("10.0.0.1", 1<<40) {
+		t.Error("Expected a nil tracker never to run out")
+	}
+}
@@ -0,0 +1,71 @@
+package policy
+
+import (
+	"sync"
+	"time"
+)
+
+// QuotaTracker counts the bytes each client moves in fixed periods. A
+// client's period starts with its first byte. A nil *QuotaTracker never
+// runs out.
+type QuotaTracker struct {
+	limit  int64
+	period time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	clients map[string]*quotaUsage
+}
+
+type quotaUsage struct {
+	start time.Time
+	used  int64
+}
+
+// NewQuotaTracker allows limit bytes per client per period. It returns nil
+// (no quota) when limit is not positive.
+func NewQuotaTracker(limit int64, period time.Duration) *QuotaTracker {
+	if limit <= 0 {
+		return nil
+	}
+	return &QuotaTracker{
+		limit:   limit,
+		period:  period,
+		now:     time.Now,
+		clients: make(map[string]*quotaUsage),
+	}
+}
+
+// usage returns client's usage in the current period. Callers hold mu.
+func (q *QuotaTracker) usage(client string) *quotaUsage {
+	now := q.now()
+	u, ok := q.clients[client]
+	if !ok || now.Sub(u.start) >= q.period {
+		u = &quotaUsage{start: now}
+		q.clients[client] = u
+	}
+	return u
+}
+
+// Exceeded reports whether client has used up its quota for this period
+func (q *QuotaTracker) Exceeded(client string) bool {
+	if q == nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.usage(client).used >= q.limit
+}
+
+// Add counts n bytes against client's quota and reports whether it still
+// has quota left
+func (q *QuotaTracker) Add(client string, n int64) bool {
+	if q == nil {
+		return true
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	u := q.usage(client)
+	u.used += n
+	return u.used < q.limit
+}
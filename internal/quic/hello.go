@@ -0,0 +1,59 @@
+package quic
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// ControlStream is the part of a control stream the hello exchange uses
+type ControlStream interface {
+	io.ReadWriter
+	SetReadDeadline(t time.Time) error
+}
+
+// Negotiate sends this build's hello on a new control stream and returns
+// the Lambda's. A Lambda whose protocol versions don't overlap ours, or that
+// doesn't answer, is refused with an error saying which side to update.
+func Negotiate(stream ControlStream, timeout time.Duration) (shared.Hello, error) {
+	local := shared.LocalHello()
+	if err := shared.WriteHello(stream, local); err != nil {
+		return shared.Hello{}, err
+	}
+
+	stream.SetReadDeadline(time.Now().Add(timeout))
+	defer stream.SetReadDeadline(time.Time{})
+
+	opcode, _, err := shared.ReadControlMessage(stream)
+	if err == nil && opcode != shared.OpHello {
+		err = fmt.Errorf("got control opcode %02x", opcode)
+	}
+	var hello shared.Hello
+	if err == nil {
+		hello, err = shared.ReadHello(stream)
+	}
+	if err != nil {
+		// A Lambda from before the hello exchange closes the stream on the unknown opcode
+		return shared.Hello{}, fmt.Errorf("the Lambda did not answer the protocol hello (%w); if it was deployed by an older version, run 'lambda-nat-proxy deploy' to update it", err)
+	}
+
+	var mismatch *shared.ProtocolMismatchError
+	if err := shared.CheckHello(local, hello); errors.As(err, &mismatch) {
+		if mismatch.PeerOutdated() {
+			return hello, fmt.Errorf("the Lambda speaks protocol version %d, but this proxy needs version %d or later: run 'lambda-nat-proxy deploy' to update the Lambda", hello.Version, local.MinVersion)
+		}
+		return hello, fmt.Errorf("the Lambda needs protocol version %d or later, but this proxy speaks version %d: update lambda-nat-proxy", hello.MinVersion, local.Version)
+	}
+
+	switch {
+	case hello.Version < local.Version:
+		log.Printf("⚠️  The Lambda speaks protocol version %d, older than this proxy's %d; run 'lambda-nat-proxy deploy' to update it", hello.Version, local.Version)
+	case hello.Version > local.Version:
+		log.Printf("⚠️  The Lambda speaks protocol version %d, newer than this proxy's %d; consider updating lambda-nat-proxy", hello.Version, local.Version)
+	}
+	return hello, nil
+}
@@ -0,0 +1,60 @@
+package quic
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// lambdaSide answers the orchestrator's hello with hello, or closes the
+// stream on it like a Lambda from before the hello exchange if hello is nil
+func lambdaSide(t *testing.T, conn net.Conn, hello *shared.Hello) {
+	defer conn.Close()
+	opcode, _, err := shared.ReadControlMessage(conn)
+	if err != nil || opcode != shared.OpHello {
+		t.Errorf("Expected a hello first, got 0x%02x (%v)", opcode, err)
+		return
+	}
+	if _, err := shared.ReadHello(conn); err != nil {
+		t.Errorf("ReadHello failed: %v", err)
+		return
+	}
+	if hello != nil {
+		shared.WriteHello(conn, *hello)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name    string
+		lambda  *shared.Hello
+		wantErr string
+	}{
+		{"same build", &shared.Hello{Version: shared.ProtocolVersion, MinVersion: shared.MinProtocolVersion, Capabilities: shared.Capabilities}, ""},
+		{"newer compatible Lambda", &shared.Hello{Version: shared.ProtocolVersion + 1, MinVersion: shared.MinProtocolVersion}, ""},
+		{"outdated Lambda", &shared.Hello{Version: shared.MinProtocolVersion - 1, MinVersion: 1}, "run 'lambda-nat-proxy deploy'"},
+		{"outdated proxy", &shared.Hello{Version: shared.ProtocolVersion + 2, MinVersion: shared.ProtocolVersion + 1}, "update lambda-nat-proxy"},
+		{"Lambda without hello", nil, "did not answer the protocol hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orchestrator, lambda := net.Pipe()
+			defer orchestrator.Close()
+			go lambdaSide(t, lambda, tt.lambda)
+
+			hello, err := Negotiate(orchestrator, time.Second)
+			if tt.wantErr == "" {
+				if err != nil || hello != *tt.lambda {
+					t.Errorf("Negotiate = %+v, %v", hello, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
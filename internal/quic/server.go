@@ -2,6 +2,7 @@ package quic
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
@@ -27,6 +28,16 @@ func New() *Server {
 
 // StartAndAccept starts QUIC server and waits for Lambda connection
 func (s *Server) StartAndAccept(ctx context.Context, udpConn *net.UDPConn, cfg *config.Config) (quic.Connection, error) {
+	listener, err := s.Listen(ctx, udpConn, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return Accept(ctx, listener)
+}
+
+// Listen starts the QUIC server on udpConn's port, where the hole was
+// punched. The listener is closed when ctx is cancelled.
+func (s *Server) Listen(ctx context.Context, udpConn *net.UDPConn, cfg *config.Config) (*quic.Listener, error) {
 	// Get the local address from our UDP socket (same port used for hole punching)
 	localAddr := udpConn.LocalAddr().(*net.UDPAddr)
 
@@ -36,16 +47,59 @@ func (s *Server) StartAndAccept(ctx context.Context, udpConn *net.UDPConn, cfg *
 	// Small delay to ensure port is released
 	time.Sleep(shared.DefaultSocketReleaseDelay)
 
-	// Generate TLS config for server
-	tlsConfig, err := shared.GenerateTLSConfig(shared.TLSConfigOptions{
-		Organization: "Orchestrator QUIC Server",
-		DNSNames:     []string{"orchestrator.local"},
-	})
+	log.Printf("🔗 Starting QUIC server on %s (same port as hole punch)", localAddr.String())
+
+	tlsConfig, quicConfig, err := serverConfigs(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate TLS config: %w", err)
+		return nil, err
 	}
 
-	log.Printf("🔗 Starting QUIC server on %s (same port as hole punch)", localAddr.String())
+	// Create QUIC listener on the same port with optimized config
+	listener, err := quic.ListenAddr(localAddr.String(), tlsConfig, quicConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create QUIC listener: %w", err)
+	}
+	
+	// Set up graceful shutdown of listener on context cancellation
+	go func() {
+		<-ctx.Done()
+		shared.LogNetwork("Shutting down QUIC listener")
+		listener.Close()
+	}()
+
+	shared.LogNetwork("QUIC server ready to accept Lambda connection")
+	return listener, nil
+}
+
+// ListenPacket starts the QUIC server on conn, such as a relay's packet
+// conn. The listener is closed when ctx is cancelled, closing conn with it.
+func (s *Server) ListenPacket(ctx context.Context, conn net.PacketConn, cfg *config.Config) (*quic.Listener, error) {
+	tlsConfig, quicConfig, err := serverConfigs(cfg)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := quic.Listen(conn, tlsConfig, quicConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create QUIC listener: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		shared.LogNetwork("Shutting down QUIC listener")
+		listener.Close()
+		conn.Close()
+	}()
+
+	shared.LogNetwork("QUIC server ready to accept Lambda connection")
+	return listener, nil
+}
+
+// serverConfigs builds the orchestrator's TLS and mode-based QUIC configuration
+func serverConfigs(cfg *config.Config) (*tls.Config, *quic.Config, error) {
+	// Generate TLS config for server
+	tlsConfig, err := serverTLSConfig()
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// Get mode-based QUIC configuration
 	streamWindow, connWindow, maxIncomingStreams, maxIncomingUniStreams := shared.GetQUICConfig(
@@ -75,24 +129,31 @@ func (s *Server) StartAndAccept(ctx context.Context, udpConn *net.UDPConn, cfg *
 		
 		// Enable connection migration for better reliability
 		DisablePathMTUDiscovery: false,
-		EnableDatagrams:         false, // Focus on stream performance
+		EnableDatagrams:         cfg.EnableDatagrams, // Opt-in fast path for small UDP payloads
 	}
 
-	// Create QUIC listener on the same port with optimized config
-	listener, err := quic.ListenAddr(localAddr.String(), tlsConfig, quicConfig)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create QUIC listener: %w", err)
+	// Apply user transport tuning on top of the mode defaults
+	if err := cfg.QUIC.Apply(quicConfig); err != nil {
+		return nil, nil, fmt.Errorf("invalid QUIC tuning: %w", err)
 	}
-	
-	// Set up graceful shutdown of listener on context cancellation
-	go func() {
-		<-ctx.Done()
-		shared.LogNetwork("Shutting down QUIC listener")
-		listener.Close()
-	}()
 
-	shared.LogNetwork("QUIC server ready to accept Lambda connection")
+	return tlsConfig, quicConfig, nil
+}
+
+// serverTLSConfig generates the orchestrator's self-signed TLS configuration
+func serverTLSConfig() (*tls.Config, error) {
+	tlsConfig, err := shared.GenerateTLSConfig(shared.TLSConfigOptions{
+		Organization: "Orchestrator QUIC Server",
+		DNSNames:     []string{"orchestrator.local"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate TLS config: %w", err)
+	}
+	return tlsConfig, nil
+}
 
+// Accept waits for the Lambda to connect to listener
+func Accept(ctx context.Context, listener *quic.Listener) (quic.Connection, error) {
 	// Wait for Lambda to connect
 	quicConn, err := listener.Accept(ctx)
 	if err != nil {
@@ -106,4 +167,23 @@ func (s *Server) StartAndAccept(ctx context.Context, udpConn *net.UDPConn, cfg *
 	log.Printf("✅ Lambda connected from %s!", quicConn.RemoteAddr())
 
 	return quicConn, nil
-}
\ No newline at end of file
+}
+
+// AcceptStripes waits up to timeout for the Lambda to open n more
+// connections to listener, and returns those that arrived. The Lambda opens
+// them from the same socket as the first, so they share its punched path.
+func AcceptStripes(ctx context.Context, listener *quic.Listener, n int, timeout time.Duration) []quic.Connection {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stripes []quic.Connection
+	for len(stripes) < n {
+		conn, err := listener.Accept(ctx)
+		if err != nil {
+			log.Printf("⚠️  Only %d of %d extra QUIC connections arrived: %v", len(stripes), n, err)
+			break
+		}
+		stripes = append(stripes, conn)
+	}
+	return stripes
+}
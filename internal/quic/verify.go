@@ -0,0 +1,45 @@
+package quic
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/quic-go/quic-go"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// VerifyLoopback completes a tunnel handshake on the loopback interface,
+// with the orchestrator's TLS configuration on one side and the Lambda's on
+// the other, and reports what it negotiated
+func VerifyLoopback(ctx context.Context) (shared.TunnelCrypto, error) {
+	tlsConfig, err := serverTLSConfig()
+	if err != nil {
+		return shared.TunnelCrypto{}, err
+	}
+	listener, err := quic.ListenAddr("127.0.0.1:0", tlsConfig, &quic.Config{HandshakeIdleTimeout: shared.QUICHandshakeTimeout})
+	if err != nil {
+		return shared.TunnelCrypto{}, fmt.Errorf("failed to listen on loopback: %w", err)
+	}
+	defer listener.Close()
+
+	// Accept returns once the handshake completes
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept(ctx)
+		if err == nil {
+			defer conn.CloseWithError(0, "")
+		}
+		accepted <- err
+	}()
+
+	conn, err := quic.DialAddr(ctx, listener.Addr().String(), shared.ClientTLSConfig(), &quic.Config{HandshakeIdleTimeout: shared.QUICHandshakeTimeout})
+	if err != nil {
+		return shared.TunnelCrypto{}, fmt.Errorf("failed to complete loopback handshake: %w", err)
+	}
+	defer conn.CloseWithError(0, "")
+	if err := <-accepted; err != nil {
+		return shared.TunnelCrypto{}, fmt.Errorf("failed to accept loopback handshake: %w", err)
+	}
+	return shared.DescribeTunnelCrypto(conn.ConnectionState()), nil
+}
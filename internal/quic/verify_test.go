@@ -0,0 +1,23 @@
+package quic
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestVerifyLoopback(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	crypto, err := VerifyLoopback(ctx)
+	if err != nil {
+		t.Fatalf("VerifyLoopback failed: %v", err)
+	}
+	if crypto.TLSVersion != "TLS 1.3" {
+		t.Errorf("Expected TLS 1.3, got %q", crypto.TLSVersion)
+	}
+	if len(crypto.Warnings) > 0 {
+		t.Errorf("Expected no warnings for the default configuration, got %v", crypto.Warnings)
+	}
+}
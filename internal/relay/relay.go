@@ -0,0 +1,194 @@
+// Package relay pairs the orchestrator's and the Lambda's TCP connections
+// for sessions that can't use UDP, and copies bytes between them. It runs
+// wherever both can reach it, started with 'lambda-nat-proxy relay'.
+package relay
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// Server pairs relay connections by session ID. Both sides must present
+// its token.
+type Server struct {
+	token       string
+	pairTimeout time.Duration
+
+	mu      sync.Mutex
+	waiting map[string]*waiter // by session ID
+	paired  int                // sessions being relayed
+}
+
+// waiter is a connection waiting for its session's other side
+type waiter struct {
+	role byte
+	conn net.Conn
+	peer chan net.Conn // receives the other side, once
+}
+
+// New creates a relay that admits connections presenting token
+func New(token string) *Server {
+	return NewWithTimeout(token, shared.RelayPairTimeout)
+}
+
+// NewWithTimeout creates a relay that holds a connection up to pairTimeout
+// for its session's other side
+func NewWithTimeout(token string, pairTimeout time.Duration) *Server {
+	return &Server{
+		token:       token,
+		pairTimeout: pairTimeout,
+		waiting:     make(map[string]*waiter),
+	}
+}
+
+// Serve relays the connections accepted from listener until ctx is done
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			log.Printf("⚠️  Relay: accept failed: %v", err)
+			continue
+		}
+		go s.handle(ctx, conn)
+	}
+}
+
+// Active returns how many sessions are being relayed
+func (s *Server) Active() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paired
+}
+
+func (s *Server) handle(ctx context.Context, conn net.Conn) {
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetNoDelay(true)
+	}
+	conn.SetReadDeadline(time.Now().Add(shared.RelayHelloTimeout))
+	role, sessionID, token, err := shared.ReadRelayHello(conn)
+	if err != nil {
+		log.Printf("⚠️  Relay: bad hello from %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		log.Printf("⚠️  Relay: wrong token from %s", conn.RemoteAddr())
+		answer(conn, shared.RelayStatusDenied)
+		conn.Close()
+		return
+	}
+
+	s.mu.Lock()
+	other, ok := s.waiting[sessionID]
+	switch {
+	case ok && other.role == role:
+		s.mu.Unlock()
+		answer(conn, shared.RelayStatusBusy)
+		conn.Close()
+		return
+	case ok:
+		// The waiting side's handler relays
+		delete(s.waiting, sessionID)
+		s.paired++
+		s.mu.Unlock()
+		other.peer <- conn
+		return
+	}
+	w := &waiter{role: role, conn: conn, peer: make(chan net.Conn, 1)}
+	s.waiting[sessionID] = w
+	s.mu.Unlock()
+
+	// Until the other side arrives, give up on a timeout, a hang-up or
+	// shutdown. A waiting side sends nothing, so a read returns only when it
+	// hangs up or its deadline passes.
+	gone := make(chan struct{})
+	go func() {
+		var b [1]byte
+		conn.Read(b[:])
+		close(gone)
+	}()
+	timer := time.NewTimer(s.pairTimeout)
+	defer timer.Stop()
+	var peer net.Conn
+	select {
+	case peer = <-w.peer:
+	case <-timer.C:
+	case <-gone:
+	case <-ctx.Done():
+	}
+	if peer == nil {
+		s.mu.Lock()
+		if s.waiting[sessionID] == w {
+			delete(s.waiting, sessionID)
+			s.mu.Unlock()
+			answer(conn, shared.RelayStatusTimeout)
+			conn.Close()
+			return
+		}
+		// Paired as it gave up
+		s.mu.Unlock()
+		peer = <-w.peer
+	}
+
+	// Stop the watcher before the connection carries datagrams
+	conn.SetReadDeadline(time.Now())
+	<-gone
+	conn.SetReadDeadline(time.Time{})
+	s.relay(sessionID, conn, peer)
+}
+
+// relay answers both sides and copies between them until either closes
+func (s *Server) relay(sessionID string, a, b net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		s.paired--
+		s.mu.Unlock()
+	}()
+	if answer(a, shared.RelayStatusPaired) != nil || answer(b, shared.RelayStatusPaired) != nil {
+		a.Close()
+		b.Close()
+		return
+	}
+	log.Printf("Relay: session %s paired (%s ↔ %s)", sessionID, a.RemoteAddr(), b.RemoteAddr())
+
+	start := time.Now()
+	done := make(chan int64, 2)
+	copyHalf := func(dst, src net.Conn) {
+		n, _ := io.Copy(dst, src)
+		// Closing both ends the other direction too
+		dst.Close()
+		src.Close()
+		done <- n
+	}
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+	total := <-done + <-done
+	log.Printf("Relay: session %s closed after %v (%d bytes)", sessionID, time.Since(start).Round(time.Second), total)
+}
+
+// answer writes the relay's one-byte answer to a hello
+func answer(conn net.Conn, status byte) error {
+	conn.SetWriteDeadline(time.Now().Add(shared.RelayHelloTimeout))
+	defer conn.SetWriteDeadline(time.Time{})
+	_, err := conn.Write([]byte{status})
+	return err
+}
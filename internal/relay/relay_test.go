@@ -0,0 +1,174 @@
+package relay
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// startRelay serves a relay with token on a loopback port until the test ends
+func startRelay(t *testing.T, token string, pairTimeout time.Duration) shared.RelaySettings {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go NewWithTimeout(token, pairTimeout).Serve(ctx, listener)
+	return shared.RelaySettings{URL: "tcp://" + listener.Addr().String(), Token: token}
+}
+
+// dialPair connects both sides of sessionID through the relay
+func dialPair(t *testing.T, settings shared.RelaySettings, sessionID string) (orchestrator, lambda net.Conn) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dialed := make(chan error, 1)
+	go func() {
+		var err error
+		lambda, err = shared.DialRelay(ctx, settings, shared.RelayRoleLambda, sessionID)
+		dialed <- err
+	}()
+	orchestrator, err := shared.DialRelay(ctx, settings, shared.RelayRoleOrchestrator, sessionID)
+	if err != nil {
+		t.Fatalf("orchestrator side: %v", err)
+	}
+	if err := <-dialed; err != nil {
+		t.Fatalf("Lambda side: %v", err)
+	}
+	t.Cleanup(func() {
+		orchestrator.Close()
+		lambda.Close()
+	})
+	return orchestrator, lambda
+}
+
+func TestRelayPairsDatagrams(t *testing.T) {
+	settings := startRelay(t, "secret", time.Minute)
+	orchestrator, lambda := dialPair(t, settings, "s1")
+
+	a, b := shared.NewRelayPacketConn(orchestrator), shared.NewRelayPacketConn(lambda)
+	for _, payload := range []string{"first", "", strings.Repeat("x", 1400)} {
+		if _, err := a.WriteTo([]byte(payload), nil); err != nil {
+			t.Fatalf("WriteTo failed: %v", err)
+		}
+		buf := make([]byte, 2048)
+		n, _, err := b.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("ReadFrom failed: %v", err)
+		}
+		if string(buf[:n]) != payload {
+			t.Fatalf("got %d bytes, want %q", n, payload[:min(len(payload), 10)])
+		}
+	}
+
+	// A datagram longer than the buffer is truncated, and the next one intact
+	a.WriteTo([]byte("truncated"), nil)
+	a.WriteTo([]byte("next"), nil)
+	small := make([]byte, 4)
+	if n, _, _ := b.ReadFrom(small); string(small[:n]) != "trun" {
+		t.Fatalf("truncated read = %q", small[:n])
+	}
+	if n, _, _ := b.ReadFrom(small); string(small[:n]) != "next" {
+		t.Fatalf("read after truncation = %q", small[:n])
+	}
+}
+
+func TestRelayRefusals(t *testing.T) {
+	settings := startRelay(t, "secret", 200*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	wrong := settings
+	wrong.Token = "guess"
+	if _, err := shared.DialRelay(ctx, wrong, shared.RelayRoleOrchestrator, "s1"); err == nil || !strings.Contains(err.Error(), "token") {
+		t.Errorf("wrong token: got %v", err)
+	}
+	if _, err := shared.DialRelay(ctx, settings, shared.RelayRoleOrchestrator, "s2"); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("unpaired session: got %v", err)
+	}
+}
+
+func TestRelayPinsTLSCertificate(t *testing.T) {
+	tlsConfig, err := shared.GenerateTLSConfig(shared.TLSConfigOptions{Organization: "test"})
+	if err != nil {
+		t.Fatalf("failed to generate TLS config: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go NewWithTimeout("secret", time.Minute).Serve(ctx, tls.NewListener(listener, tlsConfig))
+
+	settings := shared.RelaySettings{
+		URL:         "tls://" + listener.Addr().String(),
+		Token:       "secret",
+		Fingerprint: shared.CertificateFingerprint(tlsConfig.Certificates[0].Certificate[0]),
+	}
+	dialPair(t, settings, "s1")
+
+	wrong := settings
+	wrong.Fingerprint = strings.Repeat("00", 32)
+	if _, err := shared.DialRelay(ctx, wrong, shared.RelayRoleOrchestrator, "s2"); err == nil || !strings.Contains(err.Error(), "fingerprint") {
+		t.Errorf("wrong fingerprint: got %v", err)
+	}
+}
+
+func TestRelayCarriesQUIC(t *testing.T) {
+	settings := startRelay(t, "secret", time.Minute)
+	orchestrator, lambda := dialPair(t, settings, "s1")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tlsConfig, err := shared.GenerateTLSConfig(shared.TLSConfigOptions{Organization: "test", DNSNames: []string{"orchestrator.local"}})
+	if err != nil {
+		t.Fatalf("failed to generate TLS config: %v", err)
+	}
+	listener, err := quic.Listen(shared.NewRelayPacketConn(orchestrator), tlsConfig, nil)
+	if err != nil {
+		t.Fatalf("failed to listen over the relay: %v", err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept(ctx)
+		if err != nil {
+			accepted <- err.Error()
+			return
+		}
+		stream, err := conn.AcceptStream(ctx)
+		if err != nil {
+			accepted <- err.Error()
+			return
+		}
+		buf := make([]byte, 5)
+		n, _ := stream.Read(buf)
+		accepted <- string(buf[:n])
+	}()
+
+	packetConn := shared.NewRelayPacketConn(lambda)
+	conn, err := quic.Dial(ctx, packetConn, orchestrator.LocalAddr(), shared.ClientTLSConfig(), nil)
+	if err != nil {
+		t.Fatalf("failed to dial over the relay: %v", err)
+	}
+	defer conn.CloseWithError(0, "")
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	stream.Write([]byte("hello"))
+	if got := <-accepted; got != "hello" {
+		t.Fatalf("orchestrator read %q, want hello", got)
+	}
+}
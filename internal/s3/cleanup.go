@@ -0,0 +1,109 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	awsclients "github.com/dan-v/lambda-nat-punch-proxy/internal/aws"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/metrics"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// maxDeleteBatch is the most keys S3 accepts in one DeleteObjects call
+const maxDeleteBatch = 1000
+
+// CleanupResult reports what CleanupStale found and removed
+type CleanupResult struct {
+	Found   int
+	Deleted int
+}
+
+// CleanupStale deletes coordination and punch-response objects last modified
+// more than maxAge ago. Anything older than the Lambda timeout belongs to a
+// session whose Lambda has already exited, so it can never be used again.
+func CleanupStale(ctx context.Context, s3Client awsclients.S3API, bucketName string, maxAge time.Duration) (*CleanupResult, error) {
+	result := &CleanupResult{}
+	cutoff := time.Now().Add(-maxAge)
+	
+	var stale []s3types.ObjectIdentifier
+	for _, prefix := range []string{shared.CoordinationKeyPrefix, shared.ResponseKeyPrefix} {
+		input := &s3.ListObjectsV2Input{
+			Bucket: aws.String(bucketName),
+			Prefix: aws.String(prefix),
+		}
+		for {
+			metrics.RecordS3Operation()
+			page, err := s3Client.ListObjectsV2(ctx, input)
+			if err != nil {
+				metrics.RecordS3Error()
+				return result, fmt.Errorf("failed to list %s objects: %w", prefix, err)
+			}
+			
+			for _, obj := range page.Contents {
+				if obj.LastModified != nil && obj.LastModified.Before(cutoff) {
+					stale = append(stale, s3types.ObjectIdentifier{Key: obj.Key})
+				}
+			}
+			
+			if !aws.ToBool(page.IsTruncated) {
+				break
+			}
+			input.ContinuationToken = page.NextContinuationToken
+		}
+	}
+	result.Found = len(stale)
+	
+	for start := 0; start < len(stale); start += maxDeleteBatch {
+		end := start + maxDeleteBatch
+		if end > len(stale) {
+			end = len(stale)
+		}
+		
+		metrics.RecordS3Operation()
+		out, err := s3Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucketName),
+			Delete: &s3types.Delete{
+				Objects: stale[start:end],
+				Quiet:   aws.Bool(true),
+			},
+		})
+		if err != nil {
+			metrics.RecordS3Error()
+			metrics.RecordS3StaleObjects(result.Found, result.Deleted)
+			return result, fmt.Errorf("failed to delete stale objects: %w", err)
+		}
+		result.Deleted += end - start - len(out.Errors)
+	}
+	
+	metrics.RecordS3StaleObjects(result.Found, result.Deleted)
+	return result, nil
+}
+
+// DeleteSession deletes the session's coordination and response objects
+// once its Lambda is done with them. The bucket's lifecycle rules and
+// CleanupStale remove any that are missed.
+func (c *DefaultCoordinator) DeleteSession(ctx context.Context, sessionID string) error {
+	metrics.RecordS3Operation()
+	out, err := c.s3Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(c.bucketName),
+		Delete: &s3types.Delete{
+			Objects: []s3types.ObjectIdentifier{
+				{Key: aws.String(fmt.Sprintf(shared.CoordinationKeyPattern, sessionID))},
+				{Key: aws.String(fmt.Sprintf(shared.ResponseKeyPattern, sessionID))},
+			},
+			Quiet: aws.Bool(true),
+		},
+	})
+	if err == nil && len(out.Errors) > 0 {
+		err = fmt.Errorf("%s: %s", aws.ToString(out.Errors[0].Key), aws.ToString(out.Errors[0].Message))
+	}
+	if err != nil {
+		metrics.RecordS3Error()
+		return fmt.Errorf("failed to delete objects of session %s: %w", sessionID, err)
+	}
+	return nil
+}
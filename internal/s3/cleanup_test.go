@@ -0,0 +1,78 @@
+package s3
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	awsclients "github.com/dan-v/lambda-nat-punch-proxy/internal/aws"
+)
+
+// fakeS3 serves a fixed object listing and records deletions
+type fakeS3 struct {
+	awsclients.S3API
+	objects map[string]time.Time
+	deleted []string
+}
+
+func (f *fakeS3) ListObjectsV2(ctx context.Context, input *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	out := &s3.ListObjectsV2Output{IsTruncated: aws.Bool(false)}
+	for key, modified := range f.objects {
+		if strings.HasPrefix(key, aws.ToString(input.Prefix)) {
+			out.Contents = append(out.Contents, s3types.Object{
+				Key:          aws.String(key),
+				LastModified: aws.Time(modified),
+			})
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeS3) DeleteObjects(ctx context.Context, input *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	for _, obj := range input.Delete.Objects {
+		f.deleted = append(f.deleted, aws.ToString(obj.Key))
+	}
+	return &s3.DeleteObjectsOutput{}, nil
+}
+
+func TestCleanupStale(t *testing.T) {
+	old := time.Now().Add(-time.Hour)
+	client := &fakeS3{objects: map[string]time.Time{
+		"coordination/old.json":   old,
+		"punch-response/old.json": old,
+		"coordination/new.json":   time.Now(),
+		"unrelated/old.json":      old,
+	}}
+	
+	result, err := CleanupStale(context.Background(), client, "bucket", 10*time.Minute)
+	if err != nil {
+		t.Fatalf("CleanupStale failed: %v", err)
+	}
+	
+	if result.Found != 2 || result.Deleted != 2 {
+		t.Errorf("Expected 2 found and deleted, got %+v", result)
+	}
+	for _, key := range client.deleted {
+		if key == "coordination/new.json" || key == "unrelated/old.json" {
+			t.Errorf("Unexpected deletion of %s", key)
+		}
+	}
+}
+
+func TestDeleteSession(t *testing.T) {
+	client := &fakeS3{}
+	cleaner, ok := New(client, "bucket").(SessionCleaner)
+	if !ok {
+		t.Fatal("Expected the S3 coordinator to delete session objects")
+	}
+	if err := cleaner.DeleteSession(context.Background(), "done"); err != nil {
+		t.Fatalf("DeleteSession failed: %v", err)
+	}
+	if len(client.deleted) != 2 || client.deleted[0] != "coordination/done.json" || client.deleted[1] != "punch-response/done.json" {
+		t.Errorf("Expected the session's coordination and response deleted, got %v", client.deleted)
+	}
+}
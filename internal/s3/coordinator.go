@@ -3,82 +3,141 @@ package s3
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"sync/atomic"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
 	awsclients "github.com/dan-v/lambda-nat-punch-proxy/internal/aws"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/metrics"
 	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
 )
 
-// Coordinator handles coordination with Lambda via S3
+// Coordinator hands sessions to the Lambda and waits for its endpoint, via
+// S3 or the Lambda's function URL
 type Coordinator interface {
 	WriteCoordination(ctx context.Context, sessionID, publicIP string, port int) error
 	WaitForLambdaResponse(ctx context.Context, sessionID string, timeout time.Duration) (*shared.LambdaResponse, error)
+	SetSettings(settings *shared.SessionSettings)
+}
+
+// SessionStopper is a Coordinator that can stop a session's Lambda without
+// its tunnel, e.g. one a crashed proxy left running. The stop notice is
+// checksummed with the integrity key the session was launched with, which
+// SessionKey returns for the coordinator's own sessions.
+type SessionStopper interface {
+	StopSession(ctx context.Context, sessionID, integrityKey string) error
+	SessionKey(sessionID string) string
+}
+
+// SessionCleaner is a Coordinator that can delete a finished session's
+// coordination and response objects
+type SessionCleaner interface {
+	DeleteSession(ctx context.Context, sessionID string) error
+}
+
+// SessionIDChecker is a Coordinator that can tell whether a session ID was
+// already used, so a launch can avoid reusing one
+type SessionIDChecker interface {
+	SessionIDInUse(ctx context.Context, sessionID string) (bool, error)
 }
 
 // DefaultCoordinator implements Coordinator
 type DefaultCoordinator struct {
-	s3Client   awsclients.S3API
-	bucketName string
+	s3Client    awsclients.S3API
+	bucketName  string
+	settings    atomic.Pointer[shared.SessionSettings]
+	credentials CredentialIssuer
+	keys        integrityKeys
 }
 
 // New creates a new S3 coordinator
 func New(s3Client awsclients.S3API, bucketName string) Coordinator {
-	return &DefaultCoordinator{
+	return NewWithSettings(s3Client, bucketName, nil)
+}
+
+// NewWithSettings creates a new S3 coordinator that sends the given session
+// settings to the Lambda with every coordination request
+func NewWithSettings(s3Client awsclients.S3API, bucketName string, settings *shared.SessionSettings) Coordinator {
+	c := &DefaultCoordinator{
 		s3Client:   s3Client,
 		bucketName: bucketName,
+		keys:       newIntegrityKeys(),
 	}
+	c.settings.Store(settings)
+	return c
+}
+
+// SetSettings replaces the settings sent to Lambdas launched from now on
+func (c *DefaultCoordinator) SetSettings(settings *shared.SessionSettings) {
+	c.settings.Store(settings)
+}
+
+// SetCredentialIssuer sends each Lambda credentials minted by issuer for
+// its session alone, to answer with instead of its execution role
+func (c *DefaultCoordinator) SetCredentialIssuer(issuer CredentialIssuer) {
+	c.credentials = issuer
 }
 
 // WriteCoordination writes coordination data to S3 to trigger Lambda
 func (c *DefaultCoordinator) WriteCoordination(ctx context.Context, sessionID, publicIP string, port int) error {
-	coord := shared.CoordinationData{
-		SessionID:        sessionID,
-		LaptopPublicIP:   publicIP,
-		LaptopPublicPort: port,
-		Timestamp:        time.Now().Unix(),
+	settings := c.settings.Load()
+	coord := newCoordinationData(ctx, sessionID, publicIP, port, c.keys.session(sessionID), settings)
+	if c.credentials != nil {
+		creds, err := c.credentials.Issue(ctx, sessionID)
+		if err != nil {
+			return err
+		}
+		coord.Credentials = creds
 	}
 
-	coordData, err := json.Marshal(coord)
+	coordData, err := shared.MarshalCoordination(coord)
 	if err != nil {
 		return fmt.Errorf("failed to marshal coordination data: %w", err)
 	}
 
 	s3Key := fmt.Sprintf(shared.CoordinationKeyPattern, sessionID)
 
-	start := time.Now()
-	_, err = c.s3Client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+	input := &s3.PutObjectInput{
 		Bucket: aws.String(c.bucketName),
 		Key:    aws.String(s3Key),
 		Body:   bytes.NewReader(coordData),
-	})
+	}
+	if settings != nil && settings.Storage != nil {
+		settings.Storage.Apply(input)
+	}
+
+	start := time.Now()
+	_, err = c.s3Client.PutObject(ctx, input)
 	
 	// Record S3 operation metrics
 	metrics.RecordS3Operation()
 	metrics.RecordAWSAPILatency(time.Since(start))
+	metrics.RecordS3ObjectSize("coordination", len(coordData))
 
 	if err != nil {
 		metrics.RecordS3Error()
-		if awsErr, ok := err.(awserr.Error); ok {
-			switch awsErr.Code() {
-			case s3.ErrCodeNoSuchBucket:
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			switch apiErr.ErrorCode() {
+			case "NoSuchBucket":
 				return fmt.Errorf("S3 bucket '%s' does not exist. Please run 'lambda-nat-proxy deploy' to create infrastructure", c.bucketName)
 			case "AccessDenied":
 				return fmt.Errorf("access denied to S3 bucket '%s'. Please check AWS credentials have S3 permissions:\n\n"+
 					"Required permissions:\n"+
 					"- s3:PutObject\n"+
 					"- s3:GetObject\n"+
-					"- s3:DeleteObject", c.bucketName)
+					"- s3:DeleteObject\n"+
+					"- s3:PutObjectTagging (with proxy.s3_objects.tags)", c.bucketName)
 			case "InvalidBucketName":
 				return fmt.Errorf("invalid S3 bucket name '%s'. Bucket names must be DNS-compliant", c.bucketName)
 			default:
 				return fmt.Errorf("S3 operation failed (%s): %v\nBucket: %s\nKey: coordination/%s", 
-					awsErr.Code(), awsErr.Message(), c.bucketName, sessionID)
+					apiErr.ErrorCode(), apiErr.ErrorMessage(), c.bucketName, sessionID)
 			}
 		}
 		return fmt.Errorf("failed to write to S3: %w", err)
@@ -87,6 +146,77 @@ func (c *DefaultCoordinator) WriteCoordination(ctx context.Context, sessionID, p
 	return nil
 }
 
+// SessionIDInUse reports whether the bucket holds a response for sessionID.
+// A launch reusing the ID would take that response for its own Lambda's;
+// rewriting the coordination object does no harm.
+func (c *DefaultCoordinator) SessionIDInUse(ctx context.Context, sessionID string) (bool, error) {
+	start := time.Now()
+	output, err := c.s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(c.bucketName),
+		Prefix:  aws.String(fmt.Sprintf(shared.ResponseKeyPattern, sessionID)),
+		MaxKeys: aws.Int32(1),
+	})
+	metrics.RecordS3Operation()
+	metrics.RecordAWSAPILatency(time.Since(start))
+	if err != nil {
+		metrics.RecordS3Error()
+		return false, fmt.Errorf("failed to list session responses: %w", err)
+	}
+	return len(output.Contents) > 0, nil
+}
+
+// SessionKey returns the integrity key of a session this coordinator launches
+func (c *DefaultCoordinator) SessionKey(sessionID string) string {
+	return c.keys.session(sessionID)
+}
+
+// StopSession replaces the session's response with a shutdown notice. A
+// Lambda that hasn't answered yet takes it as already answered and exits;
+// a running one checks its response while connected and exits on it.
+func (c *DefaultCoordinator) StopSession(ctx context.Context, sessionID, integrityKey string) error {
+	data, err := shared.MarshalResponse(shared.LambdaResponse{
+		SessionID: sessionID,
+		Status:    shared.ResponseStatusShutdown,
+		Timestamp: time.Now().Unix(),
+	}, integrityKey)
+	if err != nil {
+		return fmt.Errorf("failed to marshal shutdown notice: %w", err)
+	}
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(c.bucketName),
+		Key:    aws.String(fmt.Sprintf(shared.ResponseKeyPattern, sessionID)),
+		Body:   bytes.NewReader(data),
+	}
+	if settings := c.settings.Load(); settings != nil && settings.Storage != nil {
+		settings.Storage.Apply(input)
+	}
+
+	start := time.Now()
+	_, err = c.s3Client.PutObject(ctx, input)
+	metrics.RecordS3Operation()
+	metrics.RecordAWSAPILatency(time.Since(start))
+	if err != nil {
+		metrics.RecordS3Error()
+		return fmt.Errorf("failed to write shutdown notice for session %s: %w", sessionID, err)
+	}
+	return nil
+}
+
+// newCoordinationData describes a session to its Lambda, joining the launch
+// trace in ctx if there is one
+func newCoordinationData(ctx context.Context, sessionID, publicIP string, port int, integrityKey string, settings *shared.SessionSettings) shared.CoordinationData {
+	coord := shared.CoordinationData{
+		SessionID:        sessionID,
+		IntegrityKey:     integrityKey,
+		LaptopPublicIP:   publicIP,
+		LaptopPublicPort: port,
+		Timestamp:        time.Now().Unix(),
+		Settings:         settings,
+	}
+	coord.Traceparent = shared.Traceparent(ctx)
+	return coord
+}
+
 // WaitForLambdaResponse polls S3 for Lambda response
 func (c *DefaultCoordinator) WaitForLambdaResponse(ctx context.Context, sessionID string, timeout time.Duration) (*shared.LambdaResponse, error) {
 	deadline := time.Now().Add(timeout)
@@ -99,7 +229,7 @@ func (c *DefaultCoordinator) WaitForLambdaResponse(ctx context.Context, sessionI
 		default:
 		}
 		start := time.Now()
-		obj, err := c.s3Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		obj, err := c.s3Client.GetObject(ctx, &s3.GetObjectInput{
 			Bucket: aws.String(c.bucketName),
 			Key:    aws.String(responseKey),
 		})
@@ -110,15 +240,22 @@ func (c *DefaultCoordinator) WaitForLambdaResponse(ctx context.Context, sessionI
 
 		if err == nil {
 			defer obj.Body.Close()
+			metrics.RecordS3ObjectSize("response", int(aws.ToInt64(obj.ContentLength)))
 
-			var response shared.LambdaResponse
-			if err := json.NewDecoder(obj.Body).Decode(&response); err == nil {
+			// S3 objects are written whole, so one that doesn't check out
+			// won't on the next read either
+			data, err := io.ReadAll(obj.Body)
+			if err == nil {
+				response, err := shared.UnmarshalResponse(data, c.keys.session(sessionID))
+				if err != nil {
+					return nil, fmt.Errorf("rejected the response of session %s: %w", sessionID, err)
+				}
 				metrics.RecordLambdaInvocation()
-				return &response, nil
+				return response, nil
 			}
 		} else {
 			// Only record S3 error for actual errors, not "not found" which is expected
-			if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() != s3.ErrCodeNoSuchKey {
+			if code := shared.AWSErrorCode(err); code != "" && code != "NoSuchKey" {
 				metrics.RecordS3Error()
 			}
 		}
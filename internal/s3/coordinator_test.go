@@ -1,9 +1,14 @@
 package s3
 
 import (
+	"context"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 	awsclients "github.com/dan-v/lambda-nat-punch-proxy/internal/aws"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
 )
 
 func TestNew(t *testing.T) {
@@ -26,4 +31,72 @@ func TestCoordinatorInterface(t *testing.T) {
 	
 	// This will compile only if DefaultCoordinator implements Coordinator
 	var _ Coordinator = coord
-}
\ No newline at end of file
+}
+func TestSessionIDInUse(t *testing.T) {
+	client := &fakeS3{objects: map[string]time.Time{
+		"coordination/written.json":   time.Now(),
+		"punch-response/answered.json": time.Now(),
+	}}
+	checker, ok := New(client, "test-bucket").(SessionIDChecker)
+	if !ok {
+		t.Fatal("Expected the S3 coordinator to check session IDs")
+	}
+	
+	// Only an answered session would mislead a launch reusing its ID
+	for id, want := range map[string]bool{"answered": true, "written": false, "answer": false} {
+		inUse, err := checker.SessionIDInUse(context.Background(), id)
+		if err != nil || inUse != want {
+			t.Errorf("SessionIDInUse(%q) = %v, %v; want %v", id, inUse, err, want)
+		}
+	}
+}
+
+func TestWriteCoordinationStorage(t *testing.T) {
+	client := &putRecorder{}
+	coord := New(client, "test-bucket")
+	if err := coord.WriteCoordination(context.Background(), "plain", "203.0.113.1", 4000); err != nil {
+		t.Fatalf("WriteCoordination failed: %v", err)
+	}
+	if put := client.input; put.StorageClass != "" || put.Tagging != nil {
+		t.Errorf("Expected the bucket's defaults without settings, got %v and %v", put.StorageClass, put.Tagging)
+	}
+	
+	coord.SetSettings(&shared.SessionSettings{Storage: &shared.ObjectStorage{
+		StorageClass: string(s3types.StorageClassOnezoneIa),
+		Tags:         map[string]string{"auto-delete": "true", "owner": "ops team"},
+	}})
+	if err := coord.WriteCoordination(context.Background(), "stored", "203.0.113.1", 4000); err != nil {
+		t.Fatalf("WriteCoordination failed: %v", err)
+	}
+	put := client.input
+	if got := put.StorageClass; got != s3types.StorageClassOnezoneIa {
+		t.Errorf("Expected storage class %s, got %q", s3types.StorageClassOnezoneIa, got)
+	}
+	if got := aws.ToString(put.Tagging); got != "auto-delete=true&owner=ops+team" {
+		t.Errorf("Expected encoded tags, got %q", got)
+	}
+}
+
+func TestStopSession(t *testing.T) {
+	client := &putRecorder{}
+	stopper, ok := New(client, "test-bucket").(SessionStopper)
+	if !ok {
+		t.Fatal("Expected the S3 coordinator to stop sessions")
+	}
+	key := stopper.SessionKey("orphan")
+	if err := stopper.StopSession(context.Background(), "orphan", key); err != nil {
+		t.Fatalf("StopSession failed: %v", err)
+	}
+	if key := aws.ToString(client.input.Key); key != "punch-response/orphan.json" {
+		t.Errorf("Expected the session's response replaced, got %s", key)
+	}
+	response, err := shared.UnmarshalResponse(client.body, key)
+	if err != nil || response.Status != shared.ResponseStatusShutdown {
+		t.Errorf("Expected a shutdown notice checksummed with the session's key, got %s (%v)", client.body, err)
+	}
+	
+	// The Lambda wouldn't trust a notice without the key
+	if err := stopper.StopSession(context.Background(), "orphan", ""); err == nil {
+		t.Error("Expected StopSession to refuse a session without an integrity key")
+	}
+}
@@ -0,0 +1,112 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/metrics"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// sessionCredentialsDuration is the shortest lifetime STS allows. The Lambda
+// only needs the credentials for the seconds it takes to answer.
+const sessionCredentialsDuration = 15 * time.Minute
+
+// AssumeRoleAPI is the part of the STS API used to mint session credentials
+type AssumeRoleAPI interface {
+	AssumeRole(ctx context.Context, input *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error)
+}
+
+// CredentialIssuer mints the credentials a Lambda answers one session with
+type CredentialIssuer interface {
+	Issue(ctx context.Context, sessionID string) (*shared.SessionCredentials, error)
+}
+
+// STSCredentialIssuer assumes the stack's session role with a session policy
+// that only allows reading and writing the session's response object, so a
+// compromised function can't answer, or read the answers of, other sessions
+type STSCredentialIssuer struct {
+	client     AssumeRoleAPI
+	roleArn    string
+	bucketName string
+	partition  string
+
+	// encryptionKeyArn is the KMS key the bucket is encrypted with, if any
+	encryptionKeyArn string
+}
+
+// NewCredentialIssuer creates a CredentialIssuer that assumes roleArn for
+// sessions coordinated through bucketName. The bucket is taken to be in the
+// role's partition.
+func NewCredentialIssuer(client AssumeRoleAPI, roleArn, bucketName string) CredentialIssuer {
+	partition := shared.PartitionOfARN(roleArn)
+	if partition == "" {
+		partition = shared.PartitionAWS
+	}
+	return &STSCredentialIssuer{
+		client:     client,
+		roleArn:    roleArn,
+		bucketName: bucketName,
+		partition:  partition,
+	}
+}
+
+// SetEncryptionKey lets the credentials use keyArn, the KMS key a hardened
+// stack encrypts its bucket with, to write the response
+func (i *STSCredentialIssuer) SetEncryptionKey(keyArn string) {
+	i.encryptionKeyArn = keyArn
+}
+
+// sessionPolicy limits the assumed role to the response object of sessionID
+func (i *STSCredentialIssuer) sessionPolicy(sessionID string) (string, error) {
+	statements := []map[string]interface{}{{
+		"Effect":   "Allow",
+		"Action":   []string{"s3:GetObject", "s3:PutObject", "s3:PutObjectTagging"},
+		"Resource": shared.BucketARN(i.partition, i.bucketName) + "/" + fmt.Sprintf(shared.ResponseKeyPattern, sessionID),
+	}}
+	if i.encryptionKeyArn != "" {
+		statements = append(statements, map[string]interface{}{
+			"Effect":   "Allow",
+			"Action":   []string{"kms:Decrypt", "kms:GenerateDataKey"},
+			"Resource": i.encryptionKeyArn,
+		})
+	}
+	policy := map[string]interface{}{
+		"Version":   "2012-10-17",
+		"Statement": statements,
+	}
+	data, err := json.Marshal(policy)
+	return string(data), err
+}
+
+// Issue returns credentials that expire in 15 minutes and can only answer sessionID
+func (i *STSCredentialIssuer) Issue(ctx context.Context, sessionID string) (*shared.SessionCredentials, error) {
+	policy, err := i.sessionPolicy(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build session policy: %w", err)
+	}
+
+	start := time.Now()
+	output, err := i.client.AssumeRole(ctx, &sts.AssumeRoleInput{
+		RoleArn:         aws.String(i.roleArn),
+		RoleSessionName: aws.String("session-" + sessionID),
+		Policy:          aws.String(policy),
+		DurationSeconds: aws.Int32(int32(sessionCredentialsDuration.Seconds())),
+	})
+	metrics.RecordAWSAPILatency(time.Since(start))
+	if err != nil {
+		return nil, fmt.Errorf("failed to assume session role %s (check sts:AssumeRole is allowed): %w", i.roleArn, err)
+	}
+
+	creds := output.Credentials
+	return &shared.SessionCredentials{
+		AccessKeyID:     aws.ToString(creds.AccessKeyId),
+		SecretAccessKey: aws.ToString(creds.SecretAccessKey),
+		SessionToken:    aws.ToString(creds.SessionToken),
+		Expiration:      aws.ToTime(creds.Expiration),
+	}, nil
+}
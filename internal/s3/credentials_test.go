@@ -0,0 +1,96 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// fakeSTS records the role assumption and returns fixed credentials
+type fakeSTS struct {
+	input *sts.AssumeRoleInput
+}
+
+func (f *fakeSTS) AssumeRole(ctx context.Context, input *sts.AssumeRoleInput, optFns ...func(*sts.Options)) (*sts.AssumeRoleOutput, error) {
+	f.input = input
+	return &sts.AssumeRoleOutput{Credentials: &ststypes.Credentials{
+		AccessKeyId:     aws.String("ASIASESSION"),
+		SecretAccessKey: aws.String("secret"),
+		SessionToken:    aws.String("token"),
+		Expiration:      aws.Time(time.Now().Add(sessionCredentialsDuration)),
+	}}, nil
+}
+
+// putRecorder keeps the last object written and its body
+type putRecorder struct {
+	fakeS3
+	input *s3.PutObjectInput
+	body  []byte
+}
+
+func (p *putRecorder) PutObject(ctx context.Context, input *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	p.input = input
+	p.body, _ = io.ReadAll(input.Body)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func TestSessionCredentials(t *testing.T) {
+	client := &fakeSTS{}
+	coord := New(&putRecorder{}, "bucket").(*DefaultCoordinator)
+	coord.SetCredentialIssuer(NewCredentialIssuer(client, "arn:aws:iam::123456789012:role/stack-session-role", "bucket"))
+
+	if err := coord.WriteCoordination(context.Background(), "abc123", "203.0.113.7", 40000); err != nil {
+		t.Fatalf("WriteCoordination failed: %v", err)
+	}
+
+	if aws.ToString(client.input.RoleSessionName) != "session-abc123" {
+		t.Errorf("Expected the role session to be named after the session, got %q", aws.ToString(client.input.RoleSessionName))
+	}
+	if aws.ToInt32(client.input.DurationSeconds) != 900 {
+		t.Errorf("Expected 15 minute credentials, got %d seconds", aws.ToInt32(client.input.DurationSeconds))
+	}
+	policy := aws.ToString(client.input.Policy)
+	if !strings.Contains(policy, `"arn:aws:s3:::bucket/punch-response/abc123.json"`) || strings.Contains(policy, "*") {
+		t.Errorf("Expected the policy to allow only the session's response, got %s", policy)
+	}
+
+	var data shared.CoordinationData
+	if err := json.Unmarshal(coord.s3Client.(*putRecorder).body, &data); err != nil {
+		t.Fatalf("Coordination object is not JSON: %v", err)
+	}
+	if data.Credentials == nil || data.Credentials.AccessKeyID != "ASIASESSION" || data.Credentials.SessionToken != "token" {
+		t.Errorf("Expected the credentials in the coordination object, got %+v", data.Credentials)
+	}
+}
+
+func TestSessionCredentialsPartition(t *testing.T) {
+	issuer := NewCredentialIssuer(&fakeSTS{}, "arn:aws-us-gov:iam::123456789012:role/stack-session-role", "bucket").(*STSCredentialIssuer)
+	policy, err := issuer.sessionPolicy("abc123")
+	if err != nil {
+		t.Fatalf("sessionPolicy failed: %v", err)
+	}
+	if !strings.Contains(policy, `"arn:aws-us-gov:s3:::bucket/punch-response/abc123.json"`) {
+		t.Errorf("Expected the policy in the role's partition, got %s", policy)
+	}
+	if strings.Contains(policy, "kms:") {
+		t.Errorf("Expected no KMS access without an encryption key, got %s", policy)
+	}
+
+	issuer.SetEncryptionKey("arn:aws-us-gov:kms:us-gov-west-1:123456789012:key/k1")
+	policy, err = issuer.sessionPolicy("abc123")
+	if err != nil {
+		t.Fatalf("sessionPolicy failed: %v", err)
+	}
+	if !strings.Contains(policy, `"Resource":"arn:aws-us-gov:kms:us-gov-west-1:123456789012:key/k1"`) {
+		t.Errorf("Expected the policy to allow the bucket's key, got %s", policy)
+	}
+}
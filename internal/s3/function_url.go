@@ -0,0 +1,197 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/metrics"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// FunctionURLAPI is the part of the Lambda API used to find the function URL
+type FunctionURLAPI interface {
+	GetFunctionUrlConfig(ctx context.Context, input *lambda.GetFunctionUrlConfigInput, optFns ...func(*lambda.Options)) (*lambda.GetFunctionUrlConfigOutput, error)
+}
+
+// LookupFunctionURL returns the URL deploy created for the named function
+func LookupFunctionURL(ctx context.Context, client FunctionURLAPI, functionName string) (string, error) {
+	output, err := client.GetFunctionUrlConfig(ctx, &lambda.GetFunctionUrlConfigInput{
+		FunctionName: aws.String(functionName),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to look up the function URL of %s (deploy again with deployment.coordination: function_url): %w", functionName, err)
+	}
+	return aws.ToString(output.FunctionUrl), nil
+}
+
+// FunctionURLCoordinator POSTs coordination data to the Lambda's function
+// URL and reads its endpoint from the response, skipping the S3 write, the
+// bucket notification and the response polling. The Lambda streams its
+// answer and holds the response open until its session ends, which keeps
+// the invocation alive.
+type FunctionURLCoordinator struct {
+	url         string
+	region      string
+	credentials aws.CredentialsProvider
+	signer      *v4.Signer
+	client      *http.Client
+	settings    atomic.Pointer[shared.SessionSettings]
+	keys        integrityKeys
+
+	mu      sync.Mutex
+	pending map[string]*functionURLRequest
+}
+
+// functionURLRequest is a coordination request whose answer hasn't been collected
+type functionURLRequest struct {
+	result chan functionURLResult
+	cancel context.CancelFunc
+}
+
+type functionURLResult struct {
+	response *shared.LambdaResponse
+	err      error
+}
+
+// NewFunctionURL creates a coordinator that signs its requests to url for
+// region with credentials
+func NewFunctionURL(url, region string, credentials aws.CredentialsProvider, settings *shared.SessionSettings) Coordinator {
+	c := &FunctionURLCoordinator{
+		url:         url,
+		region:      region,
+		credentials: credentials,
+		signer:      v4.NewSigner(),
+		client:      &http.Client{},
+		keys:        newIntegrityKeys(),
+		pending:     make(map[string]*functionURLRequest),
+	}
+	c.settings.Store(settings)
+	return c
+}
+
+// SetSettings replaces the settings sent to Lambdas launched from now on
+func (c *FunctionURLCoordinator) SetSettings(settings *shared.SessionSettings) {
+	c.settings.Store(settings)
+}
+
+// WriteCoordination sends the session to the function URL, which starts the
+// Lambda. The answer is collected by WaitForLambdaResponse.
+func (c *FunctionURLCoordinator) WriteCoordination(ctx context.Context, sessionID, publicIP string, port int) error {
+	key := c.keys.session(sessionID)
+	body, err := shared.MarshalCoordination(newCoordinationData(ctx, sessionID, publicIP, port, key, c.settings.Load()))
+	if err != nil {
+		return fmt.Errorf("failed to marshal coordination data: %w", err)
+	}
+
+	// The response outlives the launch, so only WaitForLambdaResponse cancels it
+	requestCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	req, err := http.NewRequestWithContext(requestCtx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return fmt.Errorf("failed to create function URL request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := c.sign(ctx, req, body); err != nil {
+		cancel()
+		return fmt.Errorf("failed to sign function URL request: %w", err)
+	}
+
+	pending := &functionURLRequest{result: make(chan functionURLResult, 1), cancel: cancel}
+	c.mu.Lock()
+	c.pending[sessionID] = pending
+	c.mu.Unlock()
+	go c.post(req, key, pending)
+	return nil
+}
+
+// sign adds a SigV4 signature for lambda to req, whose payload is body
+func (c *FunctionURLCoordinator) sign(ctx context.Context, req *http.Request, body []byte) error {
+	creds, err := c.credentials.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(body)
+	return c.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(hash[:]), "lambda", c.region, time.Now())
+}
+
+// post sends req, reports the Lambda's answer, checked against the
+// session's integrity key, and then holds the response open until the
+// Lambda ends it
+func (c *FunctionURLCoordinator) post(req *http.Request, key string, pending *functionURLRequest) {
+	defer pending.cancel()
+
+	start := time.Now()
+	resp, err := c.client.Do(req)
+	metrics.RecordAWSAPILatency(time.Since(start))
+	if err != nil {
+		pending.result <- functionURLResult{err: fmt.Errorf("function URL request failed: %w", err)}
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		err := fmt.Errorf("function URL returned %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+		if resp.StatusCode == http.StatusForbidden {
+			err = fmt.Errorf("%w (check the AWS credentials are allowed lambda:InvokeFunctionUrl)", err)
+		}
+		pending.result <- functionURLResult{err: err}
+		return
+	}
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = fmt.Errorf("the Lambda ended the response without an endpoint, check its logs")
+		}
+		pending.result <- functionURLResult{err: fmt.Errorf("failed to read Lambda response: %w", err)}
+		return
+	}
+	response, err := shared.UnmarshalResponse(raw, key)
+	if err != nil {
+		pending.result <- functionURLResult{err: fmt.Errorf("rejected the Lambda response: %w", err)}
+		return
+	}
+	metrics.RecordLambdaInvocation()
+	pending.result <- functionURLResult{response: response}
+
+	io.Copy(io.Discard, resp.Body)
+}
+
+// WaitForLambdaResponse waits for the function URL to answer sessionID
+func (c *FunctionURLCoordinator) WaitForLambdaResponse(ctx context.Context, sessionID string, timeout time.Duration) (*shared.LambdaResponse, error) {
+	c.mu.Lock()
+	pending, ok := c.pending[sessionID]
+	delete(c.pending, sessionID)
+	c.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no coordination request was sent for session %s", sessionID)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case result := <-pending.result:
+		return result.response, result.err
+	case <-ctx.Done():
+		pending.cancel()
+		return nil, ctx.Err()
+	case <-timer.C:
+		pending.cancel()
+		return nil, fmt.Errorf("timeout waiting for Lambda response")
+	}
+}
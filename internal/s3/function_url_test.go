@@ -0,0 +1,117 @@
+package s3
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+func testCredentials() credentials.StaticCredentialsProvider {
+	return credentials.NewStaticCredentialsProvider("AKID", "SECRET", "")
+}
+
+func TestFunctionURLCoordinator(t *testing.T) {
+	sessionEnded := make(chan struct{})
+	closed := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(closed)
+		if r.Method != http.MethodPost {
+			t.Errorf("Expected POST, got %s", r.Method)
+		}
+		if auth := r.Header.Get("Authorization"); !strings.Contains(auth, "/us-west-2/lambda/aws4_request") {
+			t.Errorf("Expected a SigV4 signature for lambda in us-west-2, got %q", auth)
+		}
+		body, _ := io.ReadAll(r.Body)
+		coord, err := shared.UnmarshalCoordination(body)
+		if err != nil {
+			t.Errorf("Body is not coordination data: %v", err)
+			return
+		}
+		if coord.LaptopPublicIP != "203.0.113.7" || coord.LaptopPublicPort != 40000 || coord.Settings == nil {
+			t.Errorf("Unexpected coordination data: %+v", coord)
+		}
+
+		response, _ := shared.MarshalResponse(shared.LambdaResponse{
+			SessionID:        coord.SessionID,
+			LambdaPublicIP:   "198.51.100.9",
+			LambdaPublicPort: 50000,
+			Status:           "ready",
+			Trigger:          shared.TriggerFunctionURL,
+		}, coord.IntegrityKey)
+		w.Write(append(response, '\n'))
+		w.(http.Flusher).Flush()
+		// The Lambda holds the response open for its session
+		<-sessionEnded
+	}))
+	defer server.Close()
+
+	coord := NewFunctionURL(server.URL, "us-west-2", testCredentials(), &shared.SessionSettings{})
+	ctx := context.Background()
+	if err := coord.WriteCoordination(ctx, "abc123", "203.0.113.7", 40000); err != nil {
+		t.Fatalf("WriteCoordination failed: %v", err)
+	}
+	response, err := coord.WaitForLambdaResponse(ctx, "abc123", 5*time.Second)
+	if err != nil {
+		t.Fatalf("WaitForLambdaResponse failed: %v", err)
+	}
+	if response.LambdaPublicIP != "198.51.100.9" || response.LambdaPublicPort != 50000 || response.Trigger != shared.TriggerFunctionURL {
+		t.Errorf("Unexpected response: %+v", response)
+	}
+
+	select {
+	case <-closed:
+		t.Fatal("The response was closed before the session ended")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(sessionEnded)
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The request didn't finish after the session ended")
+	}
+
+	if _, err := coord.WaitForLambdaResponse(ctx, "abc123", time.Second); err == nil {
+		t.Error("Expected an error waiting twice for the same session")
+	}
+}
+
+func TestFunctionURLCoordinatorErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server notices the client going away once the body is read
+		io.Copy(io.Discard, r.Body)
+		switch r.URL.Path {
+		case "/forbidden":
+			http.Error(w, `{"Message":"Forbidden"}`, http.StatusForbidden)
+		case "/empty":
+			w.WriteHeader(http.StatusOK)
+		case "/unsigned":
+			w.Write([]byte(`{"schema_version":1,"session_id":"s1","status":"ready"}` + "\n"))
+		case "/slow":
+			<-r.Context().Done()
+		}
+	}))
+	defer server.Close()
+
+	for path, want := range map[string]string{
+		"/forbidden": "lambda:InvokeFunctionUrl",
+		"/empty":     "without an endpoint",
+		"/unsigned":  "no checksum",
+		"/slow":      "timeout",
+	} {
+		coord := NewFunctionURL(server.URL+path, "us-west-2", testCredentials(), nil)
+		if err := coord.WriteCoordination(context.Background(), "s1", "203.0.113.7", 40000); err != nil {
+			t.Fatalf("%s: WriteCoordination failed: %v", path, err)
+		}
+		_, err := coord.WaitForLambdaResponse(context.Background(), "s1", 200*time.Millisecond)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected an error mentioning %q, got %v", path, want, err)
+		}
+	}
+}
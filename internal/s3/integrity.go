@@ -0,0 +1,27 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// integrityKeys derives each session's integrity key from a secret kept in
+// memory, so a coordinator can check any of its sessions' responses without
+// remembering their keys
+type integrityKeys struct {
+	secret []byte
+}
+
+func newIntegrityKeys() integrityKeys {
+	return integrityKeys{secret: []byte(shared.NewIntegrityKey())}
+}
+
+// session returns the integrity key of sessionID
+func (k integrityKeys) session(sessionID string) string {
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write([]byte(sessionID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
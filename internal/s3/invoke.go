@@ -0,0 +1,148 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdatypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/metrics"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// LambdaInvokeAPI is the part of the Lambda API used to start the function directly
+type LambdaInvokeAPI interface {
+	Invoke(ctx context.Context, input *lambda.InvokeInput, optFns ...func(*lambda.Options)) (*lambda.InvokeOutput, error)
+}
+
+// Invoker starts the Lambda for a session without waiting for the bucket's
+// S3 notification, which is usually delivered within a second but can lag
+type Invoker interface {
+	Invoke(ctx context.Context, sessionID string) error
+}
+
+// LambdaInvoker invokes the function asynchronously with the S3 event the
+// notification would have delivered for the session's coordination object
+type LambdaInvoker struct {
+	client       LambdaInvokeAPI
+	functionName string
+	qualifier    string // alias invoked instead of the function itself (empty = none)
+	bucketName   string
+}
+
+// NewInvoker creates an Invoker for the named function and coordination bucket
+func NewInvoker(client LambdaInvokeAPI, functionName, bucketName string) Invoker {
+	return NewAliasInvoker(client, functionName, "", bucketName)
+}
+
+// NewAliasInvoker creates an Invoker for an alias of the named function, such
+// as a performance mode published with its own memory size
+func NewAliasInvoker(client LambdaInvokeAPI, functionName, alias, bucketName string) Invoker {
+	return &LambdaInvoker{
+		client:       client,
+		functionName: functionName,
+		qualifier:    alias,
+		bucketName:   bucketName,
+	}
+}
+
+// s3Event mirrors the fields of an S3 notification the Lambda reads
+type s3Event struct {
+	Records []s3EventRecord `json:"Records"`
+}
+
+type s3EventRecord struct {
+	EventSource string    `json:"eventSource"`
+	EventName   string    `json:"eventName"`
+	EventTime   time.Time `json:"eventTime"`
+	S3          struct {
+		Bucket struct {
+			Name string `json:"name"`
+		} `json:"bucket"`
+		Object struct {
+			Key string `json:"key"`
+		} `json:"object"`
+	} `json:"s3"`
+}
+
+// Invoke starts the Lambda for sessionID and returns once Lambda has queued
+// the invocation. The Lambda ignores it if the notification got there first.
+func (i *LambdaInvoker) Invoke(ctx context.Context, sessionID string) error {
+	record := s3EventRecord{
+		EventSource: shared.DirectInvokeEventSource,
+		EventName:   "ObjectCreated:Put",
+		EventTime:   time.Now().UTC(),
+	}
+	record.S3.Bucket.Name = i.bucketName
+	record.S3.Object.Key = fmt.Sprintf(shared.CoordinationKeyPattern, sessionID)
+	payload, err := json.Marshal(s3Event{Records: []s3EventRecord{record}})
+	if err != nil {
+		return fmt.Errorf("failed to marshal invocation event: %w", err)
+	}
+
+	start := time.Now()
+	input := &lambda.InvokeInput{
+		FunctionName:   aws.String(i.functionName),
+		InvocationType: lambdatypes.InvocationTypeEvent,
+		Payload:        payload,
+	}
+	name := i.functionName
+	if i.qualifier != "" {
+		input.Qualifier = aws.String(i.qualifier)
+		name += ":" + i.qualifier
+	}
+	_, err = i.client.Invoke(ctx, input)
+	metrics.RecordAWSAPILatency(time.Since(start))
+	if err != nil {
+		return fmt.Errorf("failed to invoke Lambda %s: %w", name, err)
+	}
+	return nil
+}
+
+// FunctionConfigAPI is the part of the Lambda API used to read the memory of
+// the function and its aliases
+type FunctionConfigAPI interface {
+	GetFunction(ctx context.Context, input *lambda.GetFunctionInput, optFns ...func(*lambda.Options)) (*lambda.GetFunctionOutput, error)
+}
+
+// LookupSessionAlias returns where sessions that need memoryMB must run: ""
+// if the function itself has that much memory, otherwise alias, which deploy
+// publishes for each of deployment.session_modes. It fails if neither fits.
+func LookupSessionAlias(ctx context.Context, client FunctionConfigAPI, functionName, alias string, memoryMB int) (string, error) {
+	function, err := client.GetFunction(ctx, &lambda.GetFunctionInput{
+		FunctionName: aws.String(functionName),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read the configuration of %s: %w", functionName, err)
+	}
+	if int(aws.ToInt32(function.Configuration.MemorySize)) == memoryMB {
+		return "", nil
+	}
+
+	published, err := client.GetFunction(ctx, &lambda.GetFunctionInput{
+		FunctionName: aws.String(functionName),
+		Qualifier:    aws.String(alias),
+	})
+	if err != nil || int(aws.ToInt32(published.Configuration.MemorySize)) != memoryMB {
+		return "", fmt.Errorf("%s is deployed with %dMB and has no %s alias with %dMB; add %s to deployment.session_modes and deploy again",
+			functionName, aws.ToInt32(function.Configuration.MemorySize), alias, memoryMB, alias)
+	}
+	return alias, nil
+}
+
+// LiveQualifier returns shared.LiveAlias if upgrade has published
+// functionName's code behind it, so direct invocations run the same version
+// as the S3 trigger, and "" otherwise
+func LiveQualifier(ctx context.Context, client FunctionConfigAPI, functionName string) string {
+	_, err := client.GetFunction(ctx, &lambda.GetFunctionInput{
+		FunctionName: aws.String(functionName),
+		Qualifier:    aws.String(shared.LiveAlias),
+	})
+	if err != nil {
+		return ""
+	}
+	return shared.LiveAlias
+}
@@ -0,0 +1,97 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	lambdatypes "github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/aws/smithy-go"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+type fakeLambda struct {
+	input *lambda.InvokeInput
+}
+
+func (f *fakeLambda) Invoke(ctx context.Context, input *lambda.InvokeInput, optFns ...func(*lambda.Options)) (*lambda.InvokeOutput, error) {
+	f.input = input
+	return &lambda.InvokeOutput{StatusCode: 202}, nil
+}
+
+func TestInvokerSendsS3Event(t *testing.T) {
+	client := &fakeLambda{}
+	if err := NewInvoker(client, "stack-lambda", "stack-bucket").Invoke(context.Background(), "abc123"); err != nil {
+		t.Fatalf("Invoke failed: %v", err)
+	}
+
+	if aws.ToString(client.input.FunctionName) != "stack-lambda" {
+		t.Errorf("Expected function stack-lambda, got %s", aws.ToString(client.input.FunctionName))
+	}
+	if client.input.InvocationType != lambdatypes.InvocationTypeEvent {
+		t.Errorf("Expected an asynchronous invocation, got %s", client.input.InvocationType)
+	}
+
+	var event s3Event
+	if err := json.Unmarshal(client.input.Payload, &event); err != nil {
+		t.Fatalf("Payload is not an S3 event: %v", err)
+	}
+	if len(event.Records) != 1 {
+		t.Fatalf("Expected one record, got %d", len(event.Records))
+	}
+	record := event.Records[0]
+	if record.EventSource != shared.DirectInvokeEventSource || record.EventTime.IsZero() {
+		t.Errorf("Expected a timestamped direct invoke record, got %+v", record)
+	}
+	if record.S3.Bucket.Name != "stack-bucket" || record.S3.Object.Key != "coordination/abc123.json" {
+		t.Errorf("Expected the session's coordination object, got %s/%s", record.S3.Bucket.Name, record.S3.Object.Key)
+	}
+}
+
+func TestAliasInvoker(t *testing.T) {
+	client := &fakeLambda{}
+	if err := NewAliasInvoker(client, "stack-lambda", "performance", "stack-bucket").Invoke(context.Background(), "abc123"); err != nil {
+		t.Fatalf("Invoke failed: %v", err)
+	}
+	if aws.ToString(client.input.Qualifier) != "performance" {
+		t.Errorf("Expected the performance alias to be invoked, got %q", aws.ToString(client.input.Qualifier))
+	}
+}
+
+// memoryLambda reports the memory of the function and its aliases
+type memoryLambda map[string]int32 // qualifier ("" = function) -> MB
+
+func (m memoryLambda) GetFunction(ctx context.Context, input *lambda.GetFunctionInput, optFns ...func(*lambda.Options)) (*lambda.GetFunctionOutput, error) {
+	memory, ok := m[aws.ToString(input.Qualifier)]
+	if !ok {
+		return nil, &smithy.GenericAPIError{Code: "ResourceNotFoundException", Message: "no such alias"}
+	}
+	return &lambda.GetFunctionOutput{Configuration: &lambdatypes.FunctionConfiguration{MemorySize: aws.Int32(memory)}}, nil
+}
+
+func TestLookupSessionAlias(t *testing.T) {
+	ctx := context.Background()
+	client := memoryLambda{"": 256, "performance": 512}
+
+	if alias, err := LookupSessionAlias(ctx, client, "stack-lambda", "normal", 256); err != nil || alias != "" {
+		t.Errorf("Expected the function itself for its own memory, got %q (%v)", alias, err)
+	}
+	if alias, err := LookupSessionAlias(ctx, client, "stack-lambda", "performance", 512); err != nil || alias != "performance" {
+		t.Errorf("Expected the performance alias, got %q (%v)", alias, err)
+	}
+	if _, err := LookupSessionAlias(ctx, client, "stack-lambda", "test", 128); err == nil {
+		t.Error("Expected an error for a mode that wasn't published")
+	}
+}
+
+func TestLiveQualifier(t *testing.T) {
+	ctx := context.Background()
+	if q := LiveQualifier(ctx, memoryLambda{"": 256}, "stack-lambda"); q != "" {
+		t.Errorf("Expected no qualifier before upgrade, got %q", q)
+	}
+	if q := LiveQualifier(ctx, memoryLambda{"": 256, shared.LiveAlias: 256}, "stack-lambda"); q != shared.LiveAlias {
+		t.Errorf("Expected the live alias, got %q", q)
+	}
+}
@@ -0,0 +1,169 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/manager"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/metrics"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// dnsStubTimeout bounds a forwarded query, including opening its stream
+const dnsStubTimeout = 10 * time.Second
+
+// DNSStub is a local DNS server that forwards every query through the tunnel
+// to the Lambda's resolver, so names are never resolved on this machine.
+// Point the system's DNS at it to keep applications that ignore the SOCKS5
+// proxy from leaking lookups.
+type DNSStub struct {
+	opener func() (streamOpener, error) // tunnel to forward through
+}
+
+// NewDNSStub creates a stub forwarding through cm's primary session
+func NewDNSStub(cm *manager.ConnManager) *DNSStub {
+	return &DNSStub{
+		opener: func() (streamOpener, error) {
+			session := cm.Primary()
+			if session == nil || !session.IsHealthy() {
+				return nil, fmt.Errorf("no healthy session")
+			}
+			return session.QuicConn, nil
+		},
+	}
+}
+
+// ListenAndServe answers queries on addr over UDP and TCP until ctx is done
+func (s *DNSStub) ListenAndServe(ctx context.Context, addr string) error {
+	packetConn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to start DNS stub: %w", err)
+	}
+	listener, err := net.Listen("tcp", packetConn.LocalAddr().String())
+	if err != nil {
+		packetConn.Close()
+		return fmt.Errorf("failed to start DNS stub: %w", err)
+	}
+	shared.LogSuccessf("DNS stub listening on %s (UDP and TCP), forwarding queries through the tunnel", packetConn.LocalAddr())
+
+	go func() {
+		<-ctx.Done()
+		packetConn.Close()
+		listener.Close()
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.serveUDP(ctx, packetConn)
+	}()
+	go func() {
+		defer wg.Done()
+		s.serveTCP(ctx, listener)
+	}()
+	wg.Wait()
+	return nil
+}
+
+// serveUDP answers each datagram in its own goroutine
+func (s *DNSStub) serveUDP(ctx context.Context, conn net.PacketConn) {
+	buf := make([]byte, shared.MaxUDPPayload)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				shared.LogErrorf("DNS stub stopped: %v", err)
+			}
+			return
+		}
+		query := append([]byte(nil), buf[:n]...)
+		go func() {
+			if response := s.answer(ctx, query); response != nil {
+				conn.WriteTo(response, addr)
+			}
+		}()
+	}
+}
+
+// serveTCP answers length-prefixed queries on each accepted connection
+func (s *DNSStub) serveTCP(ctx context.Context, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				shared.LogErrorf("DNS stub stopped: %v", err)
+			}
+			return
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				conn.SetReadDeadline(time.Now().Add(dnsStubTimeout))
+				query, err := shared.ReadDNSMessage(conn)
+				if err != nil {
+					return
+				}
+				response := s.answer(ctx, query)
+				if response == nil || shared.WriteDNSMessage(conn, response) != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// answer forwards query, returning SERVFAIL if that fails and nil if query
+// is not worth answering
+func (s *DNSStub) answer(ctx context.Context, query []byte) []byte {
+	metrics.RecordDNSStubQuery()
+	response, err := s.forward(ctx, query)
+	if err != nil {
+		metrics.RecordDNSStubFailure()
+		shared.LogErrorf("DNS stub: %v", err)
+		return shared.DNSFailure(query)
+	}
+	return response
+}
+
+// forward sends query to the Lambda's resolver on a new stream
+func (s *DNSStub) forward(ctx context.Context, query []byte) ([]byte, error) {
+	opener, err := s.opener()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, dnsStubTimeout)
+	defer cancel()
+	return exchangeDNS(ctx, opener, query)
+}
+
+// exchangeDNS sends query to the Lambda's resolver on a new stream and
+// returns its response
+func exchangeDNS(ctx context.Context, opener streamOpener, query []byte) ([]byte, error) {
+	stream, err := openTunnel(ctx, opener, shared.StreamFrame{Command: shared.StreamDNS})
+	if err != nil {
+		if errors.Is(err, errTunnelDenied) {
+			return nil, fmt.Errorf("lambda refused DNS forwarding")
+		}
+		return nil, err
+	}
+	defer (&streamConn{stream}).Close()
+	stream.SetDeadline(time.Now().Add(dnsStubTimeout))
+
+	if err := shared.WriteDNSMessage(stream, query); err != nil {
+		return nil, fmt.Errorf("failed to forward DNS query: %w", err)
+	}
+	response, err := shared.ReadDNSMessage(stream)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("lambda closed DNS stream without answering")
+		}
+		return nil, fmt.Errorf("failed to read DNS response: %w", err)
+	}
+	return response, nil
+}
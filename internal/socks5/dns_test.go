@@ -0,0 +1,73 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// startDNSStub serves stub on a free local port and returns its address
+func startDNSStub(t *testing.T, stub *DNSStub) string {
+	t.Helper()
+	probe, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	addr := probe.LocalAddr().String()
+	probe.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go stub.ListenAndServe(ctx, addr)
+	time.Sleep(50 * time.Millisecond)
+	return addr
+}
+
+func TestDNSStubForwardsQueries(t *testing.T) {
+	// The echo Lambda sends each framed query straight back as its answer
+	stub := &DNSStub{opener: func() (streamOpener, error) { return &echoLambda{status: 0x00}, nil }}
+	addr := startDNSStub(t, stub)
+	query := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0, 3, 'f', 'o', 'o', 0, 0, 1, 0, 1}
+
+	udp, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("Failed to dial UDP: %v", err)
+	}
+	defer udp.Close()
+	udp.SetDeadline(time.Now().Add(5 * time.Second))
+	udp.Write(query)
+	response := make([]byte, 512)
+	n, err := udp.Read(response)
+	if err != nil || !bytes.Equal(response[:n], query) {
+		t.Errorf("Expected UDP query to be forwarded, got %x (%v)", response[:n], err)
+	}
+
+	tcp, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to dial TCP: %v", err)
+	}
+	defer tcp.Close()
+	tcp.SetDeadline(time.Now().Add(5 * time.Second))
+	shared.WriteDNSMessage(tcp, query)
+	if got, err := shared.ReadDNSMessage(tcp); err != nil || !bytes.Equal(got, query) {
+		t.Errorf("Expected TCP query to be forwarded, got %x (%v)", got, err)
+	}
+}
+
+func TestDNSStubAnswersServFailWithoutSession(t *testing.T) {
+	stub := &DNSStub{opener: func() (streamOpener, error) { return nil, fmt.Errorf("no healthy session") }}
+	query := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0, 3, 'f', 'o', 'o', 0, 0, 1, 0, 1}
+
+	response := stub.answer(context.Background(), query)
+	if len(response) != len(query) || response[0] != 0x12 || response[1] != 0x34 {
+		t.Fatalf("Expected a response to the same query, got %x", response)
+	}
+	if response[2]&0x80 == 0 || response[3]&0x0f != 2 {
+		t.Errorf("Expected SERVFAIL response, got flags %02x%02x", response[2], response[3])
+	}
+}
@@ -0,0 +1,140 @@
+package socks5
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// maxAnswerPins bounds how many domains are pinned at once
+const maxAnswerPins = 4096
+
+// answerPins resolves each CONNECT domain once with the Lambda's resolver and
+// sends the chosen address with every stream to that domain until the pin
+// expires, so retries and parallel downloads reach the same backend instead of
+// whichever CDN node each lookup returns
+type answerPins struct {
+	ttl time.Duration
+
+	mu   sync.Mutex
+	pins map[string]*answerPin
+}
+
+// answerPin is one domain's pinned address. done is closed once the lookup
+// that creates it finishes; ip is nil if it failed.
+type answerPin struct {
+	done    chan struct{}
+	ip      net.IP
+	expires time.Time
+}
+
+// newAnswerPins returns pins kept for ttl, or nil when ttl disables pinning
+func newAnswerPins(ttl time.Duration) *answerPins {
+	if ttl <= 0 {
+		return nil
+	}
+	return &answerPins{ttl: ttl, pins: make(map[string]*answerPin)}
+}
+
+// apply sets frame's pinned address if its host is a domain, resolving the
+// domain through opener if it has no pin yet. Lookups for the same domain
+// share one query. A failed lookup leaves the frame unpinned, for the Lambda
+// to resolve itself. Safe on nil pins.
+func (p *answerPins) apply(ctx context.Context, opener streamOpener, frame *shared.StreamFrame) {
+	if p == nil || frame.Host == "" || net.ParseIP(frame.Host) != nil || !shared.SupportsStreamFrames(opener) {
+		return
+	}
+	name := strings.ToLower(strings.TrimSuffix(frame.Host, "."))
+
+	p.mu.Lock()
+	pin, ok := p.pins[name]
+	if ok && isClosed(pin.done) && (pin.ip == nil || time.Now().After(pin.expires)) {
+		ok = false
+	}
+	if !ok {
+		if len(p.pins) >= maxAnswerPins {
+			p.pruneLocked()
+		}
+		if len(p.pins) >= maxAnswerPins {
+			p.mu.Unlock()
+			return
+		}
+		pin = &answerPin{done: make(chan struct{})}
+		p.pins[name] = pin
+		go p.resolve(opener, name, pin)
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-pin.done:
+	case <-ctx.Done():
+		return
+	}
+	if pin.ip != nil {
+		frame.PinnedIP = pin.ip.String()
+	}
+}
+
+// resolve looks name up with the Lambda's resolver and completes pin. It
+// runs on its own so a cancelled connection doesn't fail the lookup for the
+// others waiting on it.
+func (p *answerPins) resolve(opener streamOpener, name string, pin *answerPin) {
+	ctx, cancel := context.WithTimeout(context.Background(), dnsStubTimeout)
+	defer cancel()
+	exchange := func(ctx context.Context, query []byte) ([]byte, error) {
+		return exchangeDNS(ctx, opener, query)
+	}
+	ips, _, err := shared.ResolveWith(ctx, exchange, name)
+
+	p.mu.Lock()
+	if err != nil {
+		shared.LogNetworkf("Not pinning %s: %v", name, err)
+	} else {
+		pin.ip = ips[0]
+		pin.expires = time.Now().Add(p.ttl)
+		shared.LogTargetf("Pinned %s to %s for %v", name, pin.ip, p.ttl)
+	}
+	close(pin.done)
+	p.mu.Unlock()
+}
+
+// unpin forgets host's pin if it is still ip, after the Lambda failed to
+// connect there, so the next connection resolves the domain again. Safe on
+// nil pins.
+func (p *answerPins) unpin(host, ip string) {
+	if p == nil || ip == "" {
+		return
+	}
+	name := strings.ToLower(strings.TrimSuffix(host, "."))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if pin, ok := p.pins[name]; ok && isClosed(pin.done) && pin.ip.String() == ip {
+		delete(p.pins, name)
+		shared.LogNetworkf("Unpinned %s from %s after a failed connection", name, ip)
+	}
+}
+
+// pruneLocked drops expired and failed pins. Callers hold p.mu.
+func (p *answerPins) pruneLocked() {
+	now := time.Now()
+	for name, pin := range p.pins {
+		if isClosed(pin.done) && (pin.ip == nil || now.After(pin.expires)) {
+			delete(p.pins, name)
+		}
+	}
+}
+
+// isClosed reports whether ch has been closed
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
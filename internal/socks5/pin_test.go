@@ -0,0 +1,114 @@
+package socks5
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+	"github.com/quic-go/quic-go"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// resolvingLambda negotiated stream frames and answers DNS streams with a
+// different IPv4 address for every A query, like a CDN rotating nodes
+type resolvingLambda struct {
+	framedLambda
+	lookups atomic.Int32
+}
+
+func (l *resolvingLambda) OpenStreamSync(ctx context.Context) (quic.Stream, error) {
+	local, remote := net.Pipe()
+	go func() {
+		defer remote.Close()
+		if _, err := shared.ReadStreamHeader(remote); err != nil {
+			return
+		}
+		remote.Write([]byte{byte(shared.SOCKS5ResponseSuccess)})
+		query, err := shared.ReadDNSMessage(remote)
+		if err != nil {
+			return
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(query); err != nil {
+			return
+		}
+		msg.Response = true
+		if msg.Questions[0].Type == dnsmessage.TypeA {
+			n := byte(l.lookups.Add(1))
+			msg.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: msg.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 1},
+				Body:   &dnsmessage.AResource{A: [4]byte{203, 0, 113, n}},
+			}}
+		}
+		response, _ := msg.Pack()
+		shared.WriteDNSMessage(remote, response)
+	}()
+	return &pipeStream{conn: local}, nil
+}
+
+func TestAnswerPins(t *testing.T) {
+	lambda := &resolvingLambda{}
+	pins := newAnswerPins(shared.DefaultDNSMaxTTL)
+	ctx := context.Background()
+
+	first := shared.StreamFrame{Command: shared.StreamConnect, Host: "cdn.example.com", Port: 443}
+	pins.apply(ctx, lambda, &first)
+	if first.PinnedIP != "203.0.113.1" {
+		t.Fatalf("Expected the Lambda's answer to be pinned, got %q", first.PinnedIP)
+	}
+
+	// Later streams reuse the pin even though the record's TTL has passed
+	second := shared.StreamFrame{Command: shared.StreamConnect, Host: "CDN.example.com.", Port: 443}
+	pins.apply(ctx, lambda, &second)
+	if second.PinnedIP != first.PinnedIP || lambda.lookups.Load() != 1 {
+		t.Errorf("Expected the pinned address without another lookup, got %q after %d lookups", second.PinnedIP, lambda.lookups.Load())
+	}
+
+	// A failed connection to the pinned address resolves the domain again
+	pins.unpin("cdn.example.com", first.PinnedIP)
+	third := shared.StreamFrame{Command: shared.StreamConnect, Host: "cdn.example.com", Port: 443}
+	pins.apply(ctx, lambda, &third)
+	if third.PinnedIP != "203.0.113.2" {
+		t.Errorf("Expected a fresh pin after unpinning, got %q", third.PinnedIP)
+	}
+
+	ip := shared.StreamFrame{Command: shared.StreamConnect, Host: "198.51.100.7", Port: 443}
+	pins.apply(ctx, lambda, &ip)
+	if ip.PinnedIP != "" {
+		t.Errorf("Expected IP targets not to be pinned, got %q", ip.PinnedIP)
+	}
+
+	legacy := shared.StreamFrame{Command: shared.StreamConnect, Host: "cdn.example.com", Port: 443}
+	pins.apply(ctx, &echoLambda{}, &legacy)
+	if legacy.PinnedIP != "" {
+		t.Errorf("Expected no pin for a Lambda without stream frames, got %q", legacy.PinnedIP)
+	}
+
+	var disabled *answerPins
+	disabled.apply(ctx, lambda, &legacy)
+	disabled.unpin("cdn.example.com", "203.0.113.1")
+}
+
+func TestAnswerPinsSharedLookup(t *testing.T) {
+	lambda := &resolvingLambda{}
+	pins := newAnswerPins(shared.DefaultDNSMaxTTL)
+
+	results := make(chan string, 8)
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			frame := shared.StreamFrame{Command: shared.StreamConnect, Host: "cdn.example.com", Port: 443}
+			pins.apply(context.Background(), lambda, &frame)
+			results <- frame.PinnedIP
+		}()
+	}
+	for i := 0; i < cap(results); i++ {
+		if got := <-results; got != "203.0.113.1" {
+			t.Errorf("Expected every parallel stream on the same address, got %q", got)
+		}
+	}
+	if lambda.lookups.Load() != 1 {
+		t.Errorf("Expected parallel streams to share one lookup, got %d", lambda.lookups.Load())
+	}
+}
@@ -5,14 +5,23 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/audit"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/dashboard"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/events"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/features"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/manager"
 	"github.com/dan-v/lambda-nat-punch-proxy/internal/metrics"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/policy"
 	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
 	"github.com/quic-go/quic-go"
 )
@@ -25,14 +34,214 @@ type Proxy interface {
 	StartWithContext(ctx context.Context, port int, quicConn quic.Connection) error
 	StartWithConfigAndContext(ctx context.Context, port int, quicConn quic.Connection, bufferSize int) error
 	StartWithConnManagerAndContext(ctx context.Context, port int, cm *manager.ConnManager) error
+	
+	// Reconfigure replaces the settings for connections accepted from now on
+	Reconfigure(s Settings)
+}
+
+// Options configures a DefaultProxy
+type Options struct {
+	// Listen is the IP address the SOCKS5 server binds to. Empty binds to
+	// shared.DefaultSOCKS5Listen, so only this machine can use the proxy.
+	Listen string
+	
+	// SessionWaitTimeout is how long a connection arriving while no session is
+	// available waits for one before being closed. Zero closes it immediately.
+	SessionWaitTimeout time.Duration
+
+	// MaxQueuedConnections bounds how many connections may wait at once
+	MaxQueuedConnections int
+
+	// MaxConnections bounds concurrent SOCKS5 connections, including queued
+	// ones. Each tunnelled connection holds one QUIC stream, so this also caps
+	// streams. Connections beyond it get the Refusal reply.
+	MaxConnections int
+
+	// Bandwidth caps tunnelled throughput globally, per client IP and per destination
+	Bandwidth shared.BandwidthLimits
+
+	// IdleTimeout closes tunnels that carry no data in either direction for
+	// this long, releasing their QUIC stream. Zero disables it.
+	IdleTimeout time.Duration
+
+	// ConnectTimeout bounds how long the Lambda spends dialing a CONNECT
+	// destination, and direct routes dial for. Zero uses
+	// shared.DefaultConnectionTimeout.
+	ConnectTimeout time.Duration
+
+	// MaxStreamLifetime closes tunnels open this long, however busy. The
+	// Lambda is told too, and closes its side even if the proxy can't. Zero
+	// disables it.
+	MaxStreamLifetime time.Duration
+
+	// Compression compresses tunnel payloads to sessions whose Lambda
+	// announced CapCompression, except to ports that carry encrypted traffic
+	Compression shared.Compression
+
+	// ACL refuses destinations before a stream is opened for them. The Lambda
+	// enforces the same rules before dialing. Nil allows everything.
+	ACL *shared.ACL
+
+	// Resolvers resolve matching domains locally, for split-horizon DNS, and
+	// dial the answer through the tunnel or directly from this machine
+	Resolvers []shared.ResolverRule
+
+	// Policy routes, refuses and rate limits CONNECT requests by rule, and
+	// enforces per-client quotas. It is checked after ACL. Nil tunnels everything.
+	Policy *policy.Policy
+
+	// Egress holds the connection managers of the regions policy rules name
+	// with egress, including the proxy's own. A connection a rule sends to a
+	// region missing here, or evacuated, is refused rather than tunnelled
+	// elsewhere. Other connections use these regions while the proxy's own
+	// is evacuated.
+	Egress map[string]*manager.ConnManager
+
+	// Resources caps the process's goroutines, open files and memory. Over a
+	// limit, new connections wait in the listen backlog and tunnels idle for
+	// shared.ShedIdleAfter are closed. Zero values disable the checks.
+	Resources shared.ResourceLimits
+
+	// Refusal sets the reply given to connections refused by the ACL, the
+	// connection limit or, if enabled, a resource limit pause
+	Refusal shared.RefusalPolicy
+
+	// Audit receives one entry per CONNECT request once it ends. Nil disables it.
+	Audit *audit.Logger
+	
+	// Events receives a connection.opened event once a CONNECT request's
+	// destination is known and a connection.closed event once it ends. Nil
+	// disables them.
+	Events *events.Bus
+	
+	// PipelineConnect replies to CONNECT requests as soon as the target is
+	// sent to the Lambda, so the client's first data follows in the same
+	// round trip. The client then sees a closed connection rather than a
+	// SOCKS5 error if the Lambda can't connect.
+	PipelineConnect bool
+	
+	// Tracker follows live connections for the dashboard. Nil uses
+	// dashboard.GlobalConnectionTracker.
+	Tracker *dashboard.ConnectionTracker
+	
+	// ProxyProtocol reads PROXY protocol headers from load balancers, so
+	// tracking, policy, limits and audit logs see the real client address
+	ProxyProtocol shared.ProxyProtocolPolicy
+	
+	// PinDNSAnswers resolves each CONNECT domain once with the Lambda's
+	// resolver and sends every stream to it for this long to the same
+	// address. Zero lets the Lambda resolve each connection itself.
+	PinDNSAnswers time.Duration
+}
+
+// Settings are the options a running proxy can change with Reconfigure. See
+// Options for what each does.
+type Settings struct {
+	Bandwidth         shared.BandwidthLimits
+	IdleTimeout       time.Duration
+	ConnectTimeout    time.Duration
+	MaxStreamLifetime time.Duration
+	Compression       shared.Compression
+	ACL               *shared.ACL
+	Resolvers         []shared.ResolverRule
+	Policy            *policy.Policy
+}
+
+// Settings returns the options that Reconfigure can change
+func (o Options) Settings() Settings {
+	return Settings{
+		Bandwidth:         o.Bandwidth,
+		IdleTimeout:       o.IdleTimeout,
+		ConnectTimeout:    o.ConnectTimeout,
+		MaxStreamLifetime: o.MaxStreamLifetime,
+		Compression:       o.Compression,
+		ACL:               o.ACL,
+		Resolvers:         o.Resolvers,
+		Policy:            o.Policy,
+	}
+}
+
+// liveSettings is the state built from Settings that new connections use
+type liveSettings struct {
+	limits   *bandwidthLimits // nil when unlimited
+	resolver *nameResolver    // nil without resolver rules
+	idle     time.Duration
+	connect  time.Duration
+	lifetime time.Duration
+	compress shared.Compression
+	acl      *shared.ACL
+	policy   *policy.Policy
+}
+
+// DefaultOptions returns the default proxy options
+func DefaultOptions() Options {
+	return Options{
+		SessionWaitTimeout:   shared.DefaultSessionWaitTimeout,
+		MaxQueuedConnections: shared.DefaultMaxQueuedConnections,
+		MaxConnections:       shared.DefaultMaxConnections,
+	}
 }
 
 // DefaultProxy implements Proxy
-type DefaultProxy struct{}
+type DefaultProxy struct {
+	opts    Options
+	metrics metricsSink
+	tracker connTracker
+	live    atomic.Pointer[liveSettings] // replaced by Reconfigure
+	guard   *resourceGuard  // nil without resource limits
+	tunnels *tunnelSet      // established tunnels, for shedding
+	pins    *answerPins     // nil without DNS answer pinning
+	queue   chan struct{} // slots for connections waiting on a session
+	slots   chan struct{} // slots for concurrent connections
+	mu      sync.Mutex
+	routers map[string]*datagramRouter // QUIC datagram routers by session ID
+}
 
-// New creates a new SOCKS5 proxy
+// New creates a new SOCKS5 proxy with default options
 func New() Proxy {
-	return &DefaultProxy{}
+	return NewWithOptions(DefaultOptions())
+}
+
+// NewWithOptions creates a new SOCKS5 proxy with the given options
+func NewWithOptions(opts Options) Proxy {
+	if opts.MaxQueuedConnections <= 0 {
+		opts.MaxQueuedConnections = shared.DefaultMaxQueuedConnections
+	}
+	if opts.MaxConnections <= 0 {
+		opts.MaxConnections = shared.DefaultMaxConnections
+	}
+	if opts.Tracker == nil {
+		opts.Tracker = dashboard.GlobalConnectionTracker
+	}
+	p := &DefaultProxy{
+		opts:    opts,
+		metrics: globalMetrics{},
+		tracker: opts.Tracker,
+		guard:   newResourceGuard(opts.Resources),
+		tunnels: newTunnelSet(),
+		pins:    newAnswerPins(opts.PinDNSAnswers),
+		queue:   make(chan struct{}, opts.MaxQueuedConnections),
+		slots:   make(chan struct{}, opts.MaxConnections),
+		routers: make(map[string]*datagramRouter),
+	}
+	p.Reconfigure(opts.Settings())
+	return p
+}
+
+// Reconfigure replaces the settings for connections accepted from now on.
+// Connections already open keep the settings, including the bandwidth
+// limiters, they were accepted under.
+func (p *DefaultProxy) Reconfigure(s Settings) {
+	p.live.Store(&liveSettings{
+		limits:   newBandwidthLimits(s.Bandwidth),
+		resolver: newNameResolver(s.Resolvers),
+		idle:     s.IdleTimeout,
+		connect:  s.ConnectTimeout,
+		lifetime: s.MaxStreamLifetime,
+		compress: s.Compression,
+		acl:      s.ACL,
+		policy:   s.Policy,
+	})
 }
 
 // Start starts the SOCKS5 proxy server
@@ -47,935 +256,1336 @@ func generateConnectionID() string {
 	return hex.EncodeToString(bytes)
 }
 
-// handleSOCKS5Connection handles a single SOCKS5 connection
-func (p *DefaultProxy) handleSOCKS5Connection(clientConn net.Conn, quicConn quic.Connection) {
-	// Generate unique connection ID for tracking
-	connID := generateConnectionID()
-	
-	defer func() {
-		clientConn.Close()
-		metrics.DecrementActiveSOCKS5Connections()
-		// Clean up connection tracking
-		dashboard.GlobalConnectionTracker.RemoveConnection(connID)
-	}()
+// connectionEvent describes the connection entry records as an event
+func connectionEvent(eventType, connID string, entry audit.Entry) events.Event {
+	fields := map[string]interface{}{
+		"connection_id": connID,
+		"client":        entry.Client,
+		"destination":   entry.Destination,
+		"route":         entry.Route,
+	}
+	if eventType == events.ConnectionClosed {
+		fields["bytes_in"] = entry.BytesIn
+		fields["bytes_out"] = entry.BytesOut
+		fields["duration_ms"] = entry.DurationMs
+		fields["close_reason"] = entry.CloseReason
+	}
+	return events.Event{Type: eventType, Session: entry.SessionID, Fields: fields}
+}
 
-	// Record new connection
-	metrics.RecordSOCKS5Connection()
-	metrics.IncrementActiveSOCKS5Connections()
-	connStart := time.Now()
+// StartWithConfig starts the SOCKS5 proxy server with configuration
+func (p *DefaultProxy) StartWithConfig(port int, quicConn quic.Connection, bufferSize int) error {
+	return p.StartWithConfigAndContext(context.Background(), port, quicConn, bufferSize)
+}
 
-	log.Printf("📞 New SOCKS5 connection from %s", clientConn.RemoteAddr())
+// StartWithConnManager starts the SOCKS5 proxy server with a connection manager
+func (p *DefaultProxy) StartWithConnManager(port int, cm *manager.ConnManager) error {
+	return p.StartWithConnManagerAndContext(context.Background(), port, cm)
+}
 
-	// Handle SOCKS5 handshake (use optimized buffer size)
-	buf := make([]byte, shared.OptimizedBufferSize)
-	_, err := clientConn.Read(buf)
-	if err != nil {
-		log.Printf("Failed to read SOCKS5 handshake: %v", err)
-		metrics.RecordSOCKS5FailedConnection()
-		return
-	}
+// StartWithContext starts the SOCKS5 proxy server with context support for graceful shutdown
+func (p *DefaultProxy) StartWithContext(ctx context.Context, port int, quicConn quic.Connection) error {
+	return p.StartWithConfigAndContext(ctx, port, quicConn, shared.OptimizedBufferSize)
+}
 
-	// Respond to SOCKS5 handshake (no auth)
-	if buf[0] == shared.SOCKS5Version {
-		clientConn.Write(shared.SOCKS5AuthResponse)
-	} else {
-		log.Printf("Not a SOCKS5 connection")
-		return
-	}
+// StartWithConfigAndContext starts the SOCKS5 proxy server with configuration and context support
+func (p *DefaultProxy) StartWithConfigAndContext(ctx context.Context, port int, quicConn quic.Connection, bufferSize int) error {
+	opts := p.handlerOptions(quicConn)
+	opts.bufferSize = bufferSize
+	
+	return p.serve(ctx, port, func(conn net.Conn) {
+		p.handleConnection(ctx, conn, opts)
+	})
+}
 
-	// Read SOCKS5 request
-	_, err = clientConn.Read(buf)
-	if err != nil {
-		log.Printf("Failed to read SOCKS5 request: %v", err)
-		return
+// StartWithConnManagerAndContext starts the SOCKS5 proxy server with a connection manager and context support
+func (p *DefaultProxy) StartWithConnManagerAndContext(ctx context.Context, port int, cm *manager.ConnManager) error {
+	return p.serve(ctx, port, func(conn net.Conn) {
+		// Tunnel through another region while this one is evacuated
+		sessions := cm
+		if sessions.Evacuated() {
+			if regionCM := p.evacuationTarget(); regionCM != nil {
+				sessions = regionCM
+			}
+		}
+		
+		// Get current primary session from ConnManager
+		session := sessions.Primary()
+		if session == nil || session.IsDraining() || session.IsExpiring() || !session.IsHealthy() {
+			p.queueForSession(ctx, conn, sessions)
+			return
+		}
+		
+		p.handleConnection(ctx, conn, p.sessionOptions(session))
+	})
+}
+
+// evacuationTarget returns the connection manager of the first region by
+// name in Egress that isn't evacuated, or nil if every one is
+func (p *DefaultProxy) evacuationTarget() *manager.ConnManager {
+	regions := make([]string, 0, len(p.opts.Egress))
+	for region := range p.opts.Egress {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	for _, region := range regions {
+		if cm := p.opts.Egress[region]; !cm.Evacuated() {
+			return cm
+		}
 	}
+	return nil
+}
 
-	if buf[0] != shared.SOCKS5Version || buf[1] != shared.SOCKS5Connect {
-		log.Printf("Only SOCKS5 CONNECT supported")
-		return
+// serve listens on port and passes accepted connections to handle until ctx is cancelled
+func (p *DefaultProxy) serve(ctx context.Context, port int, handle func(conn net.Conn)) error {
+	socksAddr := p.listenAddress(port)
+	socksListener, err := net.Listen("tcp", socksAddr)
+	if err != nil {
+		return fmt.Errorf("failed to start SOCKS5 server: %w", err)
 	}
+	defer socksListener.Close()
+	
+	shared.LogSuccessf("SOCKS5 proxy server started on %s", socksAddr)
+	shared.LogInfof("Configure your browser to use SOCKS5 proxy: localhost:%d", port)
 
-	// Parse target address
-	var targetAddr string
-	var targetPort uint16
+	return p.acceptLoop(ctx, socksListener, handle)
+}
 
-	switch buf[3] { // Address type
-	case shared.SOCKS5IPv4:
-		targetAddr = fmt.Sprintf("%d.%d.%d.%d", buf[4], buf[5], buf[6], buf[7])
-		targetPort = binary.BigEndian.Uint16(buf[8:10])
-	case shared.SOCKS5DomainName:
-		domainLen := buf[4]
-		targetAddr = string(buf[5 : 5+domainLen])
-		targetPort = binary.BigEndian.Uint16(buf[5+domainLen : 7+domainLen])
-	default:
-		log.Printf("Unsupported address type: %d", buf[3])
-		return
+// listenAddress is the address serve listens on for port
+func (p *DefaultProxy) listenAddress(port int) string {
+	host := p.opts.Listen
+	if host == "" {
+		host = shared.DefaultSOCKS5Listen
 	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
 
-	target := fmt.Sprintf("%s:%d", targetAddr, targetPort)
-	log.Printf("🎯 SOCKS5 request to %s", target)
+// acceptLoop runs handle in a new goroutine for each connection accepted from
+// socksListener until ctx is cancelled. Connections beyond MaxConnections are
+// rejected without calling handle, and accepting pauses while the process is
+// over its resource limits.
+func (p *DefaultProxy) acceptLoop(ctx context.Context, socksListener net.Listener, handle func(conn net.Conn)) error {
+	// Set up graceful shutdown
+	go func() {
+		<-ctx.Done()
+		shared.LogNetwork("Shutting down SOCKS5 proxy server")
+		socksListener.Close()
+	}()
 	
-	// Add connection to tracker now that we know the destination
-	dashboard.GlobalConnectionTracker.AddConnection(connID, clientConn.RemoteAddr().String(), target)
+	go p.guard.run(ctx, p.shedIdleTunnels)
 
-	// Open QUIC stream for this connection
-	stream, err := quicConn.OpenStreamSync(context.Background())
-	if err != nil {
-		log.Printf("Failed to open QUIC stream: %v", err)
-		metrics.RecordSOCKS5FailedConnection()
-		clientConn.Write(shared.SOCKS5FailureResponse)
-		return
+	// Accept SOCKS5 connections
+	for {
+		// Leave new clients in the listen backlog while over a resource limit,
+		// unless they should be refused instead
+		if !p.opts.Refusal.WhilePaused {
+			if err := p.guard.wait(ctx); err != nil {
+				shared.LogNetwork("SOCKS5 proxy server shutdown completed")
+				return nil
+			}
+		}
+		
+		conn, err := socksListener.Accept()
+		if err != nil {
+			// Check if this is due to context cancellation (expected)
+			if ctx.Err() != nil {
+				shared.LogNetwork("SOCKS5 proxy server shutdown completed")
+				return nil
+			}
+			// Check if listener was closed
+			if ne, ok := err.(net.Error); ok && !ne.Temporary() {
+				shared.LogNetwork("SOCKS5 listener closed")
+				break
+			}
+			shared.LogErrorf("Failed to accept connection: %v", err)
+			continue
+		}
+		
+		if p.opts.Refusal.WhilePaused && p.guard.paused() {
+			go p.rejectConnection(conn, "The proxy is over a resource limit and is refusing new connections.")
+			continue
+		}
+		
+		// Take a connection slot without blocking the accept loop
+		select {
+		case p.slots <- struct{}{}:
+		default:
+			shared.LogNetworkf("Connection limit of %d reached, rejecting connection from %s", p.opts.MaxConnections, conn.RemoteAddr())
+			go p.rejectConnection(conn, fmt.Sprintf("The proxy's limit of %d concurrent connections has been reached.", p.opts.MaxConnections))
+			continue
+		}
+		
+		go func() {
+			defer func() { <-p.slots }()
+			client, err := p.acceptProxyHeader(conn)
+			if err != nil {
+				shared.LogNetworkf("Dropping connection from %s: %v", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+			handle(client)
+		}()
 	}
-	defer stream.Close()
 
-	// Send target address to lambda over QUIC
-	targetBytes := []byte(target)
-	targetLenBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(targetLenBytes, uint32(len(targetBytes)))
+	return nil
+}
+
+// shedIdleTunnels closes tunnels idle for shared.ShedIdleAfter, returning how many
+func (p *DefaultProxy) shedIdleTunnels() int {
+	return p.tunnels.shedIdle(shared.ShedIdleAfter)
+}
 
-	if _, err := stream.Write(targetLenBytes); err != nil {
-		log.Printf("Failed to write target length: %v", err)
-		clientConn.Write(shared.SOCKS5FailureResponse)
+// rejectConnection turns away a client while the proxy is at MaxConnections
+// or paused by a resource limit. It completes the SOCKS5 handshake so the
+// client sees the refusal reply rather than a dropped connection, and shows
+// HTTP clients a 403 page with reason if the refusal policy asks for it.
+func (p *DefaultProxy) rejectConnection(conn net.Conn, reason string) {
+	defer conn.Close()
+	if p.metrics != nil {
+		p.metrics.ConnectionRejected()
+	}
+	if client, err := p.acceptProxyHeader(conn); err == nil {
+		conn = client
+	}
+	
+	conn.SetDeadline(time.Now().Add(shared.SOCKS5RejectTimeout))
+	buf := make([]byte, 512)
+	n, err := conn.Read(buf)
+	if err != nil || n == 0 {
 		return
 	}
-
-	if _, err := stream.Write(targetBytes); err != nil {
-		log.Printf("Failed to write target address: %v", err)
-		clientConn.Write(shared.SOCKS5FailureResponse)
+	if buf[0] != shared.SOCKS5Version {
+		if p.opts.Refusal.HTTPPage && shared.LooksLikeHTTP(buf[:n]) {
+			conn.Write(shared.HTTPRefusalPage(reason))
+		}
 		return
 	}
-
-	// Read response from lambda
-	responseBuf := make([]byte, 1)
-	if _, err := stream.Read(responseBuf); err != nil {
-		log.Printf("Failed to read lambda response: %v", err)
-		clientConn.Write(shared.SOCKS5FailureResponse)
+	if _, err := conn.Write(shared.SOCKS5AuthResponse); err != nil {
 		return
 	}
-
-	if responseBuf[0] != 0x00 { // Success
-		log.Printf("Lambda failed to connect to target")
-		metrics.RecordSOCKS5FailedConnection()
-		clientConn.Write(shared.SOCKS5FailureResponse)
+	if _, err := conn.Read(buf); err != nil {
 		return
 	}
+	conn.Write(p.opts.Refusal.RefusalReply())
+}
 
-	// Send SOCKS5 success response
-	clientConn.Write(shared.SOCKS5SuccessResponse)
+// streamOpener opens the QUIC streams that carry proxied connections
+type streamOpener interface {
+	OpenStreamSync(ctx context.Context) (quic.Stream, error)
+}
 
-	log.Printf("✅ SOCKS5 tunnel established to %s", target)
+// metricsSink receives per-connection proxy metrics
+type metricsSink interface {
+	ConnectionOpened()
+	ConnectionClosed()
+	ConnectionFailed()
+	ConnectionRejected()
+	ConnectionReaped()
+	ConnectionDenied()
+	ConnectionShed()
+	BytesTransferred(n int64)
+	ConnectionEstablished(route, target string, latency time.Duration)
+	ConnectionFinished(route, target string, duration time.Duration)
+}
 
-	// Create a combined metrics recording function
-	recordBytes := func(bytes int64) {
-		metrics.RecordSOCKS5BytesTransferred(bytes)
-		dashboard.GlobalConnectionTracker.UpdateConnection(connID, bytes, 0, 0) // Update dashboard tracker
-	}
-	
-	// Start optimized bidirectional data forwarding with metrics
-	shared.OptimizedCopyWithMetrics(clientConn, &streamConn{stream}, recordBytes)
-	
-	// Record connection latency
-	connectionTime := time.Since(connStart)
-	metrics.RecordSOCKS5Latency(connectionTime)
-	
-	log.Printf("🔚 SOCKS5 connection to %s closed", target)
+// connTracker follows live connections, e.g. for the dashboard
+type connTracker interface {
+	AddConnection(id, clientAddr, destination string)
+	UpdateConnection(id string, bytesIn, bytesOut int64, latency float64)
+	RemoveConnection(id string)
 }
 
-// StartWithConfig starts the SOCKS5 proxy server with configuration
-func (p *DefaultProxy) StartWithConfig(port int, quicConn quic.Connection, bufferSize int) error {
-	return p.StartWithConfigAndContext(context.Background(), port, quicConn, bufferSize)
+// globalMetrics reports to the process-wide metrics package
+type globalMetrics struct{}
+
+func (globalMetrics) ConnectionOpened() {
+	metrics.RecordSOCKS5Connection()
+	metrics.IncrementActiveSOCKS5Connections()
 }
 
-// StartWithConnManager starts the SOCKS5 proxy server with a connection manager
-func (p *DefaultProxy) StartWithConnManager(port int, cm *manager.ConnManager) error {
-	return p.StartWithConnManagerAndContext(context.Background(), port, cm)
+func (globalMetrics) ConnectionClosed()        { metrics.DecrementActiveSOCKS5Connections() }
+func (globalMetrics) ConnectionFailed()        { metrics.RecordSOCKS5FailedConnection() }
+func (globalMetrics) ConnectionRejected()      { metrics.RecordSOCKS5RejectedConnection() }
+func (globalMetrics) ConnectionReaped()        { metrics.RecordSOCKS5IdleReaped() }
+func (globalMetrics) ConnectionDenied()        { metrics.RecordSOCKS5ACLDenied() }
+func (globalMetrics) ConnectionShed()          { metrics.RecordSOCKS5Shed() }
+func (globalMetrics) BytesTransferred(n int64) { metrics.RecordSOCKS5BytesTransferred(n) }
+
+func (globalMetrics) ConnectionEstablished(route, target string, latency time.Duration) {
+	metrics.RecordSOCKS5ConnectLatency(route, target, latency)
 }
 
-// handleSOCKS5ConnectionWithSession handles a single SOCKS5 connection using a specific session
-func (p *DefaultProxy) handleSOCKS5ConnectionWithSession(clientConn net.Conn, session *manager.Session) {
-	defer clientConn.Close()
+func (globalMetrics) ConnectionFinished(route, target string, duration time.Duration) {
+	metrics.RecordSOCKS5ConnectionDuration(route, target, duration)
+}
 
-	log.Printf("📞 New SOCKS5 connection from %s using session %s", clientConn.RemoteAddr(), session.ID)
+// handlerOptions parameterizes handleConnection. Every Start variant differs
+// only in these settings, so new per-connection features belong in the handler.
+type handlerOptions struct {
+	opener     streamOpener       // where tunnel streams are opened
+	session    *manager.Session   // session behind opener; enables UDP ASSOCIATE (optional)
+	bufferSize int                // copy buffer size (0 = shared.OptimizedBufferSize)
+	metrics    metricsSink        // optional
+	tracker    connTracker        // optional
+	limits     *bandwidthLimits   // optional
+	idle       time.Duration      // idle timeout for tunnels (0 = none)
+	connect    time.Duration      // target dial timeout (0 = shared.DefaultConnectionTimeout)
+	lifetime   time.Duration      // maximum tunnel lifetime (0 = none)
+	compress   shared.Compression // tunnel payload compression, if the Lambda supports it
+	trace      bool               // send the connection's trace context, which the Lambda supports
+	acl        *shared.ACL        // destination rules (nil = allow all)
+	policy     *policy.Policy     // routing, rule limits and quotas (nil = tunnel all)
+	resolver   *nameResolver      // local name resolution (optional)
+	pins       *answerPins        // addresses pinned per domain (optional)
+	audit      *audit.Logger      // per-connection audit log (optional)
+	events     *events.Bus        // connection events (optional)
+	pipeline   bool               // reply to CONNECT before the Lambda has connected
+}
 
-	// Handle SOCKS5 handshake (use optimized buffer size)
-	buf := make([]byte, shared.OptimizedBufferSize)
-	_, err := clientConn.Read(buf)
-	if err != nil {
-		log.Printf("Failed to read SOCKS5 handshake: %v", err)
-		return
+// handlerOptions returns the proxy's default handler options for opener
+func (p *DefaultProxy) handlerOptions(opener streamOpener) handlerOptions {
+	live := p.live.Load()
+	return handlerOptions{
+		opener:     opener,
+		bufferSize: shared.OptimizedBufferSize,
+		metrics:    p.metrics,
+		tracker:    p.tracker,
+		limits:     live.limits,
+		idle:       live.idle,
+		connect:    live.connect,
+		lifetime:   live.lifetime,
+		acl:        live.acl,
+		policy:     live.policy,
+		resolver:   live.resolver,
+		pins:       p.pins,
+		audit:      p.opts.Audit,
+		events:     p.opts.Events,
+		pipeline:   p.opts.PipelineConnect,
 	}
+}
 
-	// Respond to SOCKS5 handshake (no auth)
-	if buf[0] == shared.SOCKS5Version {
-		clientConn.Write(shared.SOCKS5AuthResponse)
-	} else {
-		log.Printf("Not a SOCKS5 connection")
-		return
+// sessionOptions returns handler options that tunnel through session
+func (p *DefaultProxy) sessionOptions(session *manager.Session) handlerOptions {
+	opts := p.handlerOptions(session.StreamConn())
+	opts.session = session
+	opts.trace = session.Protocol.Supports(shared.CapTraceContext)
+	if session.Protocol.Supports(shared.CapCompression) && features.Enabled(features.Compression) {
+		opts.compress = p.live.Load().compress
 	}
+	return opts
+}
 
-	// Read SOCKS5 request
-	_, err = clientConn.Read(buf)
-	if err != nil {
-		log.Printf("Failed to read SOCKS5 request: %v", err)
-		return
+// egressOptions returns opts changed to tunnel through a session in region,
+// waiting up to SessionWaitTimeout for one to become usable
+func (p *DefaultProxy) egressOptions(ctx context.Context, opts handlerOptions, region string) (handlerOptions, error) {
+	cm, ok := p.opts.Egress[region]
+	if !ok {
+		return opts, fmt.Errorf("no sessions are kept in %s; restart the proxy to start them", region)
+	}
+	if until := cm.EvacuatedUntil(); !until.IsZero() {
+		return opts, fmt.Errorf("%s is evacuated until %s", region, until.Local().Format(time.Kitchen))
+	}
+	session := cm.Primary()
+	if session == nil || session.IsDraining() || session.IsExpiring() || !session.IsHealthy() {
+		waitCtx, cancel := context.WithTimeout(ctx, p.opts.SessionWaitTimeout)
+		defer cancel()
+		var err error
+		if session, err = waitForUsableSession(waitCtx, cm); err != nil {
+			return opts, fmt.Errorf("no session in %s became available: %w", region, err)
+		}
 	}
 
-	if buf[0] != shared.SOCKS5Version || buf[1] != shared.SOCKS5Connect {
-		log.Printf("Only SOCKS5 CONNECT supported")
-		return
+	opts.opener = session.StreamConn()
+	opts.session = session
+	opts.trace = session.Protocol.Supports(shared.CapTraceContext)
+	opts.compress = shared.CompressionNone
+	if session.Protocol.Supports(shared.CapCompression) && features.Enabled(features.Compression) {
+		opts.compress = p.live.Load().compress
 	}
+	return opts, nil
+}
 
-	// Parse target address
-	var targetAddr string
-	var targetPort uint16
+// handleConnection handles a single SOCKS5 connection
+func (p *DefaultProxy) handleConnection(ctx context.Context, clientConn net.Conn, opts handlerOptions) {
+	c := &socksConn{
+		p:      p,
+		client: clientConn,
+		opts:   opts,
+		id:     generateConnectionID(), // unique connection ID for tracking
+		start:  time.Now(),
+		entry:  audit.Entry{Client: clientConn.RemoteAddr().String(), Route: "tunnel", CloseReason: audit.ReasonClosed},
+	}
+	if opts.session != nil {
+		c.via = " via session " + opts.session.ID
+		c.entry.SessionID = opts.session.ID
+	}
+	
+	defer func() {
+		clientConn.Close()
+		if opts.metrics != nil {
+			opts.metrics.ConnectionClosed()
+		}
+		// Clean up connection tracking
+		if opts.tracker != nil {
+			opts.tracker.RemoveConnection(c.id)
+		}
+	}()
 
-	switch buf[3] { // Address type
-	case shared.SOCKS5IPv4:
-		targetAddr = fmt.Sprintf("%d.%d.%d.%d", buf[4], buf[5], buf[6], buf[7])
-		targetPort = binary.BigEndian.Uint16(buf[8:10])
-	case shared.SOCKS5DomainName:
-		domainLen := buf[4]
-		targetAddr = string(buf[5 : 5+domainLen])
-		targetPort = binary.BigEndian.Uint16(buf[5+domainLen : 7+domainLen])
-	default:
-		log.Printf("Unsupported address type: %d", buf[3])
-		return
+	// Record new connection
+	if opts.metrics != nil {
+		opts.metrics.ConnectionOpened()
 	}
+	
+	ctx, span := shared.StartSpan(ctx, "socks5.connection",
+		shared.Attr("client.addr", c.entry.Client), shared.Attr("session.id", c.entry.SessionID))
+	defer func() {
+		span.SetAttributes(shared.Attr("target", c.entry.Destination), shared.Attr("route", c.entry.Route),
+			shared.Attr("bytes_in", c.entry.BytesIn), shared.Attr("bytes_out", c.entry.BytesOut),
+			shared.Attr("close_reason", c.entry.CloseReason))
+		if c.entry.CloseReason == audit.ReasonFailed || c.entry.CloseReason == audit.ReasonDenied {
+			span.RecordError(errors.New(c.entry.CloseReason))
+		}
+		span.End()
+	}()
 
-	target := fmt.Sprintf("%s:%d", targetAddr, targetPort)
-	log.Printf("🎯 SOCKS5 request to %s via session %s", target, session.ID)
+	shared.LogConnectionf("New SOCKS5 connection from %s%s", clientConn.RemoteAddr(), c.via)
 
-	// Open QUIC stream for this connection on the primary session
-	stream, err := session.QuicConn.OpenStreamSync(context.Background())
-	if err != nil {
-		log.Printf("Failed to open QUIC stream on session %s: %v", session.ID, err)
-		clientConn.Write(shared.SOCKS5FailureResponse)
-		return
-	}
-	defer stream.Close()
+	// Create a context for this connection
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	defer c.cancel()
 
-	// Send target address to lambda over QUIC
-	targetBytes := []byte(target)
-	targetLenBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(targetLenBytes, uint32(len(targetBytes)))
+	// Monitor for context cancellation
+	go func() {
+		<-c.ctx.Done()
+		clientConn.Close()
+	}()
 
-	if _, err := stream.Write(targetLenBytes); err != nil {
-		log.Printf("Failed to write target length: %v", err)
-		clientConn.Write(shared.SOCKS5FailureResponse)
+	command, target, ok := c.readRequest(ctx)
+	if !ok {
 		return
 	}
-
-	if _, err := stream.Write(targetBytes); err != nil {
-		log.Printf("Failed to write target address: %v", err)
-		clientConn.Write(shared.SOCKS5FailureResponse)
+	if command == shared.SOCKS5UDPAssociate {
+		p.handleUDPAssociate(c.ctx, clientConn, opts.session)
 		return
 	}
-
-	// Read response from lambda
-	responseBuf := make([]byte, 1)
-	if _, err := stream.Read(responseBuf); err != nil {
-		log.Printf("Failed to read lambda response: %v", err)
-		clientConn.Write(shared.SOCKS5FailureResponse)
+	
+	// Record the request in the audit log and the event stream once it
+	// ends, however it ends
+	c.entry.Destination = target
+	if opts.audit != nil || opts.events != nil {
+		defer func() {
+			if ctx.Err() != nil && c.entry.CloseReason == audit.ReasonClosed {
+				c.entry.CloseReason = audit.ReasonShutdown
+			}
+			c.entry.Time = time.Now()
+			c.entry.DurationMs = time.Since(c.start).Milliseconds()
+			opts.audit.Log(c.entry)
+			opts.events.Publish(connectionEvent(events.ConnectionClosed, c.id, c.entry))
+		}()
+	}
+	
+	// The Lambda serves the throughput test targets itself
+	if command, ok := shared.ThroughputTargetCommand(target); ok {
+		var err error
+		c.entry.BytesIn, c.entry.BytesOut, err = serveThroughputTarget(c.ctx, clientConn, opts, command)
+		if err != nil && c.ctx.Err() == nil {
+			shared.LogErrorf("Throughput test target %s: %v%s", target, err, c.via)
+			c.failed()
+		}
 		return
 	}
+	
+	decision, ok := c.checkPolicy(target)
+	if !ok {
+		return
+	}
+	rt, ok := c.resolveRoute(target, decision)
+	if !ok {
+		return
+	}
+	
+	// Add connection to tracker now that we know the destination
+	if c.opts.tracker != nil {
+		c.opts.tracker.AddConnection(c.id, clientConn.RemoteAddr().String(), target)
+	}
+	c.opts.events.Publish(connectionEvent(events.ConnectionOpened, c.id, c.entry))
 
-	if responseBuf[0] != 0x00 { // Success
-		log.Printf("Lambda failed to connect to target")
-		metrics.RecordSOCKS5FailedConnection()
-		clientConn.Write(shared.SOCKS5FailureResponse)
+	upstream, ok := c.dial(target, rt)
+	if !ok {
 		return
 	}
+	c.relay(target, upstream, decision)
+}
 
-	// Send SOCKS5 success response
-	clientConn.Write(shared.SOCKS5SuccessResponse)
+// socksConn carries one SOCKS5 connection through handleConnection's steps:
+// the handshake, the ACL and policy checks, dialing and relaying
+type socksConn struct {
+	p      *DefaultProxy
+	client net.Conn
+	opts   handlerOptions // replaced when a policy rule picks another region
+	id     string
+	via    string // how the connection is carried, for log lines
+	start  time.Time
+	entry  audit.Entry     // filled in as the connection progresses
+	ctx    context.Context // cancelled when the connection ends
+	cancel context.CancelFunc
+}
 
-	log.Printf("✅ SOCKS5 tunnel established to %s via session %s", target, session.ID)
+// failed records that the connection failed
+func (c *socksConn) failed() {
+	c.entry.CloseReason = audit.ReasonFailed
+	if c.opts.metrics != nil {
+		c.opts.metrics.ConnectionFailed()
+	}
+}
 
-	// Start optimized bidirectional data forwarding
-	shared.OptimizedCopy(clientConn, &streamConn{stream})
-	log.Printf("🔚 SOCKS5 connection to %s closed (session %s)", target, session.ID)
+// denied records that the connection was refused and tells the client
+func (c *socksConn) denied() {
+	c.entry.CloseReason = audit.ReasonDenied
+	if c.opts.metrics != nil {
+		c.opts.metrics.ConnectionDenied()
+	}
+	c.client.Write(c.p.opts.Refusal.RefusalReply())
 }
 
-// handleSOCKS5ConnectionWithConfig handles a single SOCKS5 connection with custom buffer size
-func (p *DefaultProxy) handleSOCKS5ConnectionWithConfig(clientConn net.Conn, quicConn quic.Connection, bufferSize int) {
-	defer clientConn.Close()
+// clientIP is the client's address without its port, which quotas are kept by
+func (c *socksConn) clientIP() string {
+	clientIP := c.client.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(clientIP); err == nil {
+		return host
+	}
+	return clientIP
+}
 
-	log.Printf("📞 New SOCKS5 connection from %s (mode-optimized)", clientConn.RemoteAddr())
+// bufferSize is the size of the buffers the connection is read with
+func (c *socksConn) bufferSize() int {
+	if c.opts.bufferSize <= 0 {
+		return shared.OptimizedBufferSize
+	}
+	return c.opts.bufferSize
+}
 
-	// Handle SOCKS5 handshake (use mode-specific buffer size)
-	buf := make([]byte, bufferSize)
-	_, err := clientConn.Read(buf)
+// readRequest performs the SOCKS5 handshake (no auth) and reads the client's
+// request, returning its command and, for CONNECT, its target. The buffer it
+// reads into goes back to the pool before it returns.
+func (c *socksConn) readRequest(ctx context.Context) (command byte, target string, ok bool) {
+	_, span := shared.StartSpan(ctx, "socks5.handshake")
+	defer span.End()
+	bufPtr := shared.GetBuffer(c.bufferSize())
+	defer shared.PutBuffer(bufPtr)
+	buf := *bufPtr
+	
+	n, err := c.client.Read(buf)
 	if err != nil {
-		log.Printf("Failed to read SOCKS5 handshake: %v", err)
-		return
+		if c.ctx.Err() != nil {
+			return 0, "", false // Context cancelled
+		}
+		shared.LogErrorf("Failed to read SOCKS5 handshake: %v", err)
+		c.failed()
+		return 0, "", false
 	}
-
-	// Respond to SOCKS5 handshake (no auth)
-	if buf[0] == shared.SOCKS5Version {
-		clientConn.Write(shared.SOCKS5AuthResponse)
-	} else {
-		log.Printf("Not a SOCKS5 connection")
-		return
+	if n == 0 || buf[0] != shared.SOCKS5Version {
+		shared.LogNetwork("Not a SOCKS5 connection")
+		return 0, "", false
 	}
+	c.client.Write(shared.SOCKS5AuthResponse)
 
-	// Read SOCKS5 request
-	_, err = clientConn.Read(buf)
+	n, err = c.client.Read(buf)
 	if err != nil {
-		log.Printf("Failed to read SOCKS5 request: %v", err)
-		return
+		if c.ctx.Err() != nil {
+			return 0, "", false // Context cancelled
+		}
+		shared.LogErrorf("Failed to read SOCKS5 request: %v", err)
+		return 0, "", false
 	}
+	request := buf[:n]
 
-	if buf[0] != shared.SOCKS5Version || buf[1] != shared.SOCKS5Connect {
-		log.Printf("Only SOCKS5 CONNECT supported")
-		return
+	if n >= 2 && request[0] == shared.SOCKS5Version && request[1] == shared.SOCKS5UDPAssociate && c.opts.session != nil {
+		return shared.SOCKS5UDPAssociate, "", true
 	}
-
-	// Parse target address
-	var targetAddr string
-	var targetPort uint16
-
-	switch buf[3] { // Address type
-	case shared.SOCKS5IPv4:
-		targetAddr = fmt.Sprintf("%d.%d.%d.%d", buf[4], buf[5], buf[6], buf[7])
-		targetPort = binary.BigEndian.Uint16(buf[8:10])
-	case shared.SOCKS5DomainName:
-		domainLen := buf[4]
-		targetAddr = string(buf[5 : 5+domainLen])
-		targetPort = binary.BigEndian.Uint16(buf[5+domainLen : 7+domainLen])
-	default:
-		log.Printf("Unsupported address type: %d", buf[3])
-		return
+	if n < 2 || request[0] != shared.SOCKS5Version || request[1] != shared.SOCKS5Connect {
+		shared.LogNetwork("Only SOCKS5 CONNECT and UDP ASSOCIATE supported")
+		return 0, "", false
 	}
 
-	target := fmt.Sprintf("%s:%d", targetAddr, targetPort)
-	log.Printf("🎯 SOCKS5 request to %s (mode-optimized)", target)
-
-	// Open QUIC stream for this connection
-	stream, err := quicConn.OpenStreamSync(context.Background())
+	target, err = parseConnectTarget(request)
 	if err != nil {
-		log.Printf("Failed to open QUIC stream: %v", err)
-		metrics.RecordSOCKS5FailedConnection()
-		clientConn.Write(shared.SOCKS5FailureResponse)
-		return
+		shared.LogErrorf("%v", err)
+		return 0, "", false
 	}
-	defer stream.Close()
-
-	// Send target address to lambda over QUIC
-	targetBytes := []byte(target)
-	targetLenBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(targetLenBytes, uint32(len(targetBytes)))
+	shared.LogTargetf("SOCKS5 request to %s%s", target, c.via)
+	return shared.SOCKS5Connect, target, true
+}
 
-	if _, err := stream.Write(targetLenBytes); err != nil {
-		log.Printf("Failed to write target length: %v", err)
-		clientConn.Write(shared.SOCKS5FailureResponse)
-		return
+// checkPolicy applies the ACL, the policy's first matching rule and the
+// client's quota to target, and moves the connection to the region the rule
+// tunnels through. A refused connection is answered and reported as not ok.
+func (c *socksConn) checkPolicy(target string) (decision policy.Decision, ok bool) {
+	// Apply the ACL, the policy's first matching rule and the client's quota
+	decision, err := c.opts.policy.Check(c.ctx, c.opts.acl, c.clientIP(), target)
+	if errors.Is(err, policy.ErrRefused) {
+		shared.LogNetworkf("Refusing SOCKS5 request from %s: %v", c.client.RemoteAddr(), err)
+		c.denied()
+		if errors.Is(err, policy.ErrQuotaUsedUp) {
+			c.entry.CloseReason = audit.ReasonQuota
+		}
+		return decision, false
 	}
-
-	if _, err := stream.Write(targetBytes); err != nil {
-		log.Printf("Failed to write target address: %v", err)
-		clientConn.Write(shared.SOCKS5FailureResponse)
-		return
+	if err != nil {
+		shared.LogErrorf("%v", err)
+		c.failed()
+		c.client.Write(shared.SOCKS5FailureResponse)
+		return decision, false
 	}
-
-	// Read response from lambda
-	responseBuf := make([]byte, 1)
-	if _, err := stream.Read(responseBuf); err != nil {
-		log.Printf("Failed to read lambda response: %v", err)
-		clientConn.Write(shared.SOCKS5FailureResponse)
-		return
+	
+	// Tunnel through the rule's region, and nowhere else
+	if decision.Egress != "" {
+		egress, err := c.p.egressOptions(c.ctx, c.opts, decision.Egress)
+		if err != nil {
+			shared.LogErrorf("Refusing SOCKS5 request to %s by policy rule %s: %v", target, decision.Rule, err)
+			c.failed()
+			c.client.Write(shared.SOCKS5Reply(shared.SOCKS5NetworkUnreachable))
+			return decision, false
+		}
+		c.opts = egress
+		c.via = fmt.Sprintf(" via session %s in %s", c.opts.session.ID, decision.Egress)
+		c.entry.SessionID = c.opts.session.ID
+		shared.LogTargetf("Tunnelling %s through %s by policy rule %s", target, decision.Egress, decision.Rule)
 	}
+	return decision, true
+}
 
-	if responseBuf[0] != 0x00 { // Success
-		log.Printf("Lambda failed to connect to target")
-		metrics.RecordSOCKS5FailedConnection()
-		clientConn.Write(shared.SOCKS5FailureResponse)
-		return
+// resolveRoute picks what to dial for target: the target itself through the
+// tunnel, an address a resolver rule found for it locally, or the target
+// directly from here if the policy rule says so
+func (c *socksConn) resolveRoute(target string, decision policy.Decision) (route, bool) {
+	// Resolve split-horizon names locally when a resolver rule matches
+	rt, err := c.opts.resolver.route(c.ctx, target)
+	if err != nil {
+		shared.LogErrorf("%v", err)
+		c.failed()
+		c.client.Write(shared.SOCKS5FailureResponse)
+		return rt, false
+	}
+	if decision.Action == policy.ActionDirect {
+		rt = route{address: target, direct: true}
+		c.via = " directly"
+		c.entry.Route = "direct"
+		if decision.Address != "" {
+			shared.LogTargetf("Connecting to %s directly by policy rule %s, which matched its address %s", target, decision.Rule, decision.Address)
+		} else {
+			shared.LogTargetf("Connecting to %s directly by policy rule %s", target, decision.Rule)
+		}
+	} else if rt.address != target {
+		if err := c.opts.acl.CheckResolved(target, rt.address); err != nil {
+			shared.LogNetworkf("Refusing SOCKS5 request from %s: %v", c.client.RemoteAddr(), err)
+			c.denied()
+			return rt, false
+		}
+		shared.LogTargetf("Resolved %s locally to %s", target, rt.address)
+		if rt.direct {
+			c.via = " directly"
+			c.entry.Route = "direct"
+		}
 	}
-
-	// Send SOCKS5 success response
-	clientConn.Write(shared.SOCKS5SuccessResponse)
-
-	log.Printf("✅ SOCKS5 tunnel established to %s (mode-optimized)", target)
-
-	// Start optimized bidirectional data forwarding with custom buffer size
-	shared.OptimizedCopyWithBufferSize(clientConn, &streamConn{stream}, bufferSize)
-	log.Printf("🔚 SOCKS5 connection to %s closed (mode-optimized)", target)
+	return rt, true
 }
 
-// streamConn adapts a QUIC stream to net.Conn interface for optimized copying
-type streamConn struct {
-	quic.Stream
+// upstream is where a connection's data is relayed: a tunnel stream through
+// the Lambda or, for direct routes, a TCP connection from here
+type upstream struct {
+	conn      net.Conn
+	frame     shared.StreamFrame // the header the stream opened with
+	tunnel    quic.Stream        // nil for direct routes
+	pipelined *pipelinedStream   // set when the Lambda's reply comes with the first data
 }
 
-func (sc *streamConn) LocalAddr() net.Addr {
-	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}
-}
-
-func (sc *streamConn) RemoteAddr() net.Addr {
-	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}
-}
-
-func (sc *streamConn) SetDeadline(t time.Time) error {
-	sc.SetReadDeadline(t)
-	sc.SetWriteDeadline(t)
-	return nil
-}
-
-// handleSOCKS5ConnectionWithContext handles a single SOCKS5 connection with context support
-func (p *DefaultProxy) handleSOCKS5ConnectionWithContext(ctx context.Context, clientConn net.Conn, quicConn quic.Connection) {
-	defer clientConn.Close()
-
-	shared.LogConnectionf("New SOCKS5 connection from %s", clientConn.RemoteAddr())
-
-	// Create a context for this connection
-	connCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
-
-	// Monitor for context cancellation
-	go func() {
-		<-connCtx.Done()
-		clientConn.Close()
-	}()
-
-	// Handle SOCKS5 handshake (use optimized buffer size)
-	buf := make([]byte, shared.OptimizedBufferSize)
-	_, err := clientConn.Read(buf)
+// dial connects to rt through the Lambda, or from here for direct routes. A
+// failure is answered and reported as not ok.
+func (c *socksConn) dial(target string, rt route) (up upstream, ok bool) {
+	frame, err := shared.NewStreamFrame(shared.StreamConnect, rt.address)
 	if err != nil {
-		if connCtx.Err() != nil {
-			return // Context cancelled
+		shared.LogErrorf("%v", err)
+		c.failed()
+		c.client.Write(shared.SOCKS5FailureResponse)
+		return up, false
+	}
+	frame.ConnectTimeout = c.opts.connect
+	frame.MaxLifetime = c.opts.lifetime
+	if c.opts.trace {
+		// The Lambda's spans for this stream join the connection's trace
+		frame.Traceparent = shared.ContextTraceparent(c.ctx)
+	}
+	if !rt.direct && !shared.LikelyEncryptedPort(frame.Port) {
+		frame.Compression = c.opts.compress
+	}
+	if !rt.direct {
+		c.opts.pins.apply(c.ctx, c.opts.opener, &frame)
+	}
+	up.frame = frame
+	
+	if rt.direct {
+		_, dialSpan := shared.StartSpan(c.ctx, "direct.dial", shared.Attr("address", rt.address))
+		dialTimeout := shared.DefaultConnectionTimeout
+		if c.opts.connect > 0 {
+			dialTimeout = c.opts.connect
 		}
-		shared.LogErrorf("Failed to read SOCKS5 handshake: %v", err)
-		return
+		conn, err := net.DialTimeout("tcp", rt.address, dialTimeout)
+		dialSpan.RecordError(err)
+		dialSpan.End()
+		if err != nil {
+			shared.LogErrorf("Failed to connect directly to %s: %v", rt.address, err)
+			c.failed()
+			c.client.Write(failureReply(err))
+			return up, false
+		}
+		up.conn = conn
+		return up, true
 	}
-
-	// Respond to SOCKS5 handshake (no auth)
-	if buf[0] == shared.SOCKS5Version {
-		clientConn.Write(shared.SOCKS5AuthResponse)
-	} else {
-		shared.LogNetwork("Not a SOCKS5 connection")
-		return
+	
+	if c.opts.pipeline {
+		// Tell the client it's connected now and learn the Lambda's reply
+		// when the first response data is read
+		stream, err := openPipelinedTunnel(c.ctx, c.opts.opener, frame)
+		if err != nil {
+			if c.ctx.Err() != nil {
+				return up, false // Context cancelled
+			}
+			shared.LogErrorf("%v%s", err, c.via)
+			c.failed()
+			c.client.Write(shared.SOCKS5FailureResponse)
+			return up, false
+		}
+		up.conn, up.tunnel, up.pipelined = stream, stream.Stream, stream
+		return up, true
 	}
-
-	// Read SOCKS5 request
-	_, err = clientConn.Read(buf)
+	
+	stream, err := openTunnel(c.ctx, c.opts.opener, frame)
 	if err != nil {
-		if connCtx.Err() != nil {
-			return // Context cancelled
+		if c.ctx.Err() != nil {
+			return up, false // Context cancelled
 		}
-		shared.LogErrorf("Failed to read SOCKS5 request: %v", err)
-		return
-	}
+		if errors.Is(err, errTunnelDenied) {
+			shared.LogNetworkf("Lambda refused %s: destination denied by ACL", target)
+			c.denied()
+			return up, false
+		}
+		c.opts.pins.unpin(frame.Host, frame.PinnedIP)
+		shared.LogErrorf("%v%s", err, c.via)
+		c.failed()
+		c.client.Write(failureReply(err))
+		return up, false
+	}
+	up.conn, up.tunnel = &streamConn{stream}, stream
+	return up, true
+}
 
-	if buf[0] != shared.SOCKS5Version || buf[1] != shared.SOCKS5Connect {
-		shared.LogNetwork("Only SOCKS5 CONNECT supported")
-		return
+// relay tells the client it's connected and copies data between it and up
+// until either side closes, a limit closes the tunnel or the connection's
+// context is cancelled
+func (c *socksConn) relay(target string, up upstream, decision policy.Decision) {
+	opts := c.opts
+	direct := up.tunnel == nil
+	conn, wire, compressed := c.wrap(up)
+	defer conn.Close()
+	
+	// Count the stream on its session so a drain can wait for it to finish
+	if opts.session != nil && !direct {
+		defer opts.session.TrackStream()()
 	}
 
-	// Parse target address
-	var targetAddr string
-	var targetPort uint16
-
-	switch buf[3] { // Address type
-	case shared.SOCKS5IPv4:
-		targetAddr = fmt.Sprintf("%d.%d.%d.%d", buf[4], buf[5], buf[6], buf[7])
-		targetPort = binary.BigEndian.Uint16(buf[8:10])
-	case shared.SOCKS5DomainName:
-		domainLen := buf[4]
-		targetAddr = string(buf[5 : 5+domainLen])
-		targetPort = binary.BigEndian.Uint16(buf[5+domainLen : 7+domainLen])
-	default:
-		shared.LogErrorf("Unsupported address type: %d", buf[3])
-		return
+	// Send SOCKS5 success response
+	c.client.Write(shared.SOCKS5SuccessResponse)
+	if opts.metrics != nil {
+		opts.metrics.ConnectionEstablished(c.entry.Route, target, time.Since(c.start))
 	}
 
-	target := fmt.Sprintf("%s:%d", targetAddr, targetPort)
-	shared.LogTargetf("SOCKS5 request to %s", target)
+	shared.LogSuccessf("SOCKS5 tunnel established to %s%s", target, c.via)
 
-	// Open QUIC stream for this connection with context
-	stream, err := quicConn.OpenStreamSync(connCtx)
-	if err != nil {
-		if connCtx.Err() != nil {
-			return // Context cancelled
+	// Close the tunnel if it goes quiet for longer than the idle timeout
+	reaper := newIdleReaper(opts.idle)
+	go reaper.watch(c.ctx, c.cancel)
+	
+	// Close the tunnel once it reaches its maximum lifetime, however busy
+	var expired atomic.Bool
+	if opts.lifetime > 0 {
+		timer := time.AfterFunc(opts.lifetime, func() {
+			expired.Store(true)
+			c.cancel()
+		})
+		defer timer.Stop()
+	}
+	
+	// Let the resource guard shed the tunnel if it idles while the process is over a limit
+	if c.p.tunnels != nil {
+		untrack := c.p.tunnels.add(reaper, c.cancel)
+		defer untrack()
+	}
+	
+	// Create a combined metrics recording function
+	clientIP := c.clientIP()
+	quotas := opts.policy.Quotas()
+	var quotaUsedUp atomic.Bool
+	recordBytes := func(bytes int64) {
+		reaper.touch()
+		if !quotas.Add(clientIP, bytes) && quotaUsedUp.CompareAndSwap(false, true) {
+			c.cancel()
 		}
-		shared.LogErrorf("Failed to open QUIC stream: %v", err)
-		clientConn.Write(shared.SOCKS5FailureResponse)
-		return
+		if opts.metrics != nil {
+			opts.metrics.BytesTransferred(bytes)
+		}
+		if opts.tracker != nil {
+			opts.tracker.UpdateConnection(c.id, bytes, 0, 0) // Update dashboard tracker
+		}
+	}
+	
+	// Share the client's and destination's bandwidth budgets for the lifetime of the tunnel
+	limits, releaseLimits := opts.limits.acquire(c.client.RemoteAddr(), target)
+	defer releaseLimits()
+	if decision.Limiter != nil {
+		limits = append(limits, decision.Limiter)
 	}
-	defer stream.Close()
+	
+	// Cancel the tunnel the moment the client resets or times out, so the
+	// Lambda stops sending instead of the proxy draining what's in flight
+	watched := &clientWatchConn{Conn: c.client, gone: func(code quic.StreamErrorCode) {
+		if up.tunnel != nil {
+			up.tunnel.CancelRead(code)
+			up.tunnel.CancelWrite(code)
+		} else if tcp, ok := up.conn.(*net.TCPConn); ok {
+			tcp.SetLinger(0) // reset the destination too
+		}
+	}}
+	
+	// Start optimized bidirectional data forwarding with context awareness, metrics and rate limits
+	counted := &countingConn{Conn: watched}
+	shared.OptimizedCopyWithLimits(c.ctx, counted, conn, c.bufferSize(), recordBytes, limits)
+	c.entry.BytesIn, c.entry.BytesOut = counted.written.Load(), counted.read.Load()
+	if wire != nil {
+		opts.session.Bytes.Finished(wire.counter.Bytes(uint64(wire.id)))
+	}
+	
+	// Record how long the tunnel lived
+	if opts.metrics != nil {
+		opts.metrics.ConnectionFinished(c.entry.Route, target, time.Since(c.start))
+	}
+	
+	c.closed(target, tunnelEnd{
+		pipelined:   up.pipelined,
+		watched:     watched,
+		reaper:      reaper,
+		expired:     expired.Load(),
+		quotaUsedUp: quotaUsedUp.Load(),
+		clientIP:    clientIP,
+		compressed:  compressed,
+	})
+}
+
+// wrap returns the connection relay copies to: up's, counting the bytes on
+// the wire and compressing the stream if it should
+func (c *socksConn) wrap(up upstream) (conn net.Conn, wire *byteCountedConn, compressed *shared.CompressedStream) {
+	conn = up.conn
+	
+	// Count the bytes on the wire for the session to reconcile with the
+	// Lambda's count. Stream IDs repeat across stripes, so only streams on
+	// the session's first connection are counted.
+	session := c.opts.session
+	if up.tunnel != nil && session != nil && session.Bytes != nil && c.opts.opener == streamOpener(session.QuicConn) {
+		wire = &byteCountedConn{Conn: conn, id: up.tunnel.StreamID()}
+		conn = wire
+	}
+	if up.frame.Compression != shared.CompressionNone {
+		compressed = shared.NewCompressedStream(conn)
+		conn = &compressedConn{Conn: conn, stream: compressed}
+	}
+	return conn, wire, compressed
+}
 
-	// Send target address to lambda over QUIC
-	targetBytes := []byte(target)
-	targetLenBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(targetLenBytes, uint32(len(targetBytes)))
+// tunnelEnd is what relay saw of how a tunnel ended
+type tunnelEnd struct {
+	pipelined   *pipelinedStream // set for pipelined tunnels
+	watched     *clientWatchConn
+	reaper      *idleReaper
+	expired     bool // reached its maximum lifetime
+	quotaUsedUp bool
+	clientIP    string
+	compressed  *shared.CompressedStream // set for compressed tunnels
+}
 
-	if _, err := stream.Write(targetLenBytes); err != nil {
-		if connCtx.Err() != nil {
-			return // Context cancelled
+// closed records and logs why a relayed tunnel ended
+func (c *socksConn) closed(target string, end tunnelEnd) {
+	opts := c.opts
+	
+	// A pipelined tunnel only learns the Lambda couldn't connect after the
+	// client was told it had, so the client just sees the connection close
+	if end.pipelined != nil && end.pipelined.err != nil {
+		if errors.Is(end.pipelined.err, errTunnelDenied) {
+			shared.LogNetworkf("Lambda refused %s: destination denied by ACL", target)
+			c.entry.CloseReason = audit.ReasonDenied
+			if opts.metrics != nil {
+				opts.metrics.ConnectionDenied()
+			}
+		} else {
+			shared.LogErrorf("%v%s", end.pipelined.err, c.via)
+			c.failed()
 		}
-		shared.LogErrorf("Failed to write target length: %v", err)
-		clientConn.Write(shared.SOCKS5FailureResponse)
-		return
 	}
-
-	if _, err := stream.Write(targetBytes); err != nil {
-		if connCtx.Err() != nil {
-			return // Context cancelled
+	
+	if code, gone := end.watched.goneCode(); gone {
+		c.entry.CloseReason = audit.ReasonClientReset
+		shared.LogClosef("SOCKS5 client of %s went away (code %#x), cancelled the tunnel%s", target, uint64(code), c.via)
+	}
+	if end.reaper.reaped() {
+		c.entry.CloseReason = audit.ReasonIdle
+		shared.LogClosef("SOCKS5 connection to %s idle for %v, closing%s", target, opts.idle, c.via)
+		if opts.metrics != nil {
+			opts.metrics.ConnectionReaped()
 		}
-		shared.LogErrorf("Failed to write target address: %v", err)
-		clientConn.Write(shared.SOCKS5FailureResponse)
-		return
 	}
-
-	// Read response from lambda
-	responseBuf := make([]byte, 1)
-	if _, err := stream.Read(responseBuf); err != nil {
-		if connCtx.Err() != nil {
-			return // Context cancelled
+	if end.expired {
+		c.entry.CloseReason = audit.ReasonLifetime
+		shared.LogClosef("SOCKS5 connection to %s open for %v, closing%s", target, opts.lifetime, c.via)
+	}
+	if end.quotaUsedUp {
+		c.entry.CloseReason = audit.ReasonQuota
+		shared.LogClosef("SOCKS5 connection to %s closed: %s used up its policy quota%s", target, end.clientIP, c.via)
+	}
+	if end.reaper.wasShed() {
+		c.entry.CloseReason = audit.ReasonShed
+		shared.LogClosef("SOCKS5 connection to %s shed while over a resource limit%s", target, c.via)
+		if opts.metrics != nil {
+			opts.metrics.ConnectionShed()
 		}
-		shared.LogErrorf("Failed to read lambda response: %v", err)
-		clientConn.Write(shared.SOCKS5FailureResponse)
-		return
 	}
-
-	if responseBuf[0] != 0x00 { // Success
-		shared.LogNetwork("Lambda failed to connect to target")
-		clientConn.Write(shared.SOCKS5FailureResponse)
+	
+	if end.compressed != nil {
+		shared.LogClosef("SOCKS5 connection to %s closed, sent %.1fx compressed%s", target, end.compressed.Ratio(), c.via)
 		return
 	}
-
-	// Send SOCKS5 success response
-	clientConn.Write(shared.SOCKS5SuccessResponse)
-
-	shared.LogSuccessf("SOCKS5 tunnel established to %s", target)
-
-	// Start optimized bidirectional data forwarding with context awareness
-	shared.OptimizedCopyWithContext(connCtx, clientConn, &streamConn{stream})
-	shared.LogClosef("SOCKS5 connection to %s closed", target)
+	shared.LogClosef("SOCKS5 connection to %s closed%s", target, c.via)
 }
 
-// handleSOCKS5ConnectionWithConfigAndContext handles a single SOCKS5 connection with custom buffer size and context
-func (p *DefaultProxy) handleSOCKS5ConnectionWithConfigAndContext(ctx context.Context, clientConn net.Conn, quicConn quic.Connection, bufferSize int) {
-	defer clientConn.Close()
-
-	shared.LogConnectionf("New SOCKS5 connection from %s (optimized)", clientConn.RemoteAddr())
-
-	// Create a context for this connection
-	connCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
+// idleReaper cancels a tunnel once no bytes have moved for its timeout. A
+// reaper with a zero timeout never fires.
+type idleReaper struct {
+	timeout time.Duration
+	last    atomic.Int64 // unix nanoseconds of the last transfer
+	didReap atomic.Bool
+	didShed atomic.Bool
+}
 
-	// Monitor for context cancellation
-	go func() {
-		<-connCtx.Done()
-		clientConn.Close()
-	}()
+func newIdleReaper(timeout time.Duration) *idleReaper {
+	r := &idleReaper{timeout: timeout}
+	r.touch()
+	return r
+}
 
-	// Handle SOCKS5 handshake (use mode-specific buffer size)
-	buf := make([]byte, bufferSize)
-	_, err := clientConn.Read(buf)
-	if err != nil {
-		if connCtx.Err() != nil {
-			return // Context cancelled
-		}
-		shared.LogErrorf("Failed to read SOCKS5 handshake: %v", err)
-		return
-	}
+// touch records activity on the tunnel
+func (r *idleReaper) touch() {
+	r.last.Store(time.Now().UnixNano())
+}
 
-	// Respond to SOCKS5 handshake (no auth)
-	if buf[0] == shared.SOCKS5Version {
-		clientConn.Write(shared.SOCKS5AuthResponse)
-	} else {
-		shared.LogNetwork("Not a SOCKS5 connection")
+// watch calls cancel once the tunnel has been idle for the timeout, and
+// returns when that happens or ctx is done
+func (r *idleReaper) watch(ctx context.Context, cancel context.CancelFunc) {
+	if r.timeout <= 0 {
 		return
 	}
-
-	// Read SOCKS5 request
-	_, err = clientConn.Read(buf)
-	if err != nil {
-		if connCtx.Err() != nil {
-			return // Context cancelled
+	ticker := time.NewTicker(r.timeout / 4)
+	defer ticker.Stop()
+	
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if time.Since(time.Unix(0, r.last.Load())) >= r.timeout {
+				r.didReap.Store(true)
+				cancel()
+				return
+			}
 		}
-		shared.LogErrorf("Failed to read SOCKS5 request: %v", err)
-		return
-	}
-
-	if buf[0] != shared.SOCKS5Version || buf[1] != shared.SOCKS5Connect {
-		shared.LogNetwork("Only SOCKS5 CONNECT supported")
-		return
 	}
+}
 
-	// Parse target address
-	var targetAddr string
-	var targetPort uint16
+// reaped reports whether the tunnel was closed for being idle
+func (r *idleReaper) reaped() bool {
+	return r.didReap.Load()
+}
 
-	switch buf[3] { // Address type
-	case shared.SOCKS5IPv4:
-		targetAddr = fmt.Sprintf("%d.%d.%d.%d", buf[4], buf[5], buf[6], buf[7])
-		targetPort = binary.BigEndian.Uint16(buf[8:10])
-	case shared.SOCKS5DomainName:
-		domainLen := buf[4]
-		targetAddr = string(buf[5 : 5+domainLen])
-		targetPort = binary.BigEndian.Uint16(buf[5+domainLen : 7+domainLen])
-	default:
-		shared.LogErrorf("Unsupported address type: %d", buf[3])
-		return
-	}
+// idleFor returns how long the tunnel has carried no data
+func (r *idleReaper) idleFor() time.Duration {
+	return time.Since(time.Unix(0, r.last.Load()))
+}
 
-	target := fmt.Sprintf("%s:%d", targetAddr, targetPort)
-	shared.LogTargetf("SOCKS5 request to %s (optimized)", target)
+// markShed records that the tunnel is being shed, reporting false if it
+// already was or was reaped
+func (r *idleReaper) markShed() bool {
+	return !r.didReap.Load() && r.didShed.CompareAndSwap(false, true)
+}
 
-	// Open QUIC stream for this connection with context
-	stream, err := quicConn.OpenStreamSync(connCtx)
-	if err != nil {
-		if connCtx.Err() != nil {
-			return // Context cancelled
-		}
-		shared.LogErrorf("Failed to open QUIC stream: %v", err)
-		clientConn.Write(shared.SOCKS5FailureResponse)
-		return
-	}
-	defer stream.Close()
+// wasShed reports whether the tunnel was shed by the resource guard
+func (r *idleReaper) wasShed() bool {
+	return r.didShed.Load()
+}
 
-	// Send target address to lambda over QUIC
-	targetBytes := []byte(target)
-	targetLenBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(targetLenBytes, uint32(len(targetBytes)))
+// bandwidthLimits holds the rate limiters shared by all of a proxy's connections
+type bandwidthLimits struct {
+	global         *shared.RateLimiter
+	perClient      *shared.RateLimiterSet
+	perDestination *shared.RateLimiterSet
+}
 
-	if _, err := stream.Write(targetLenBytes); err != nil {
-		if connCtx.Err() != nil {
-			return // Context cancelled
-		}
-		shared.LogErrorf("Failed to write target length: %v", err)
-		clientConn.Write(shared.SOCKS5FailureResponse)
-		return
+// newBandwidthLimits returns nil when no limit is configured
+func newBandwidthLimits(l shared.BandwidthLimits) *bandwidthLimits {
+	if l.IsZero() {
+		return nil
 	}
-
-	if _, err := stream.Write(targetBytes); err != nil {
-		if connCtx.Err() != nil {
-			return // Context cancelled
-		}
-		shared.LogErrorf("Failed to write target address: %v", err)
-		clientConn.Write(shared.SOCKS5FailureResponse)
-		return
+	return &bandwidthLimits{
+		global:         shared.NewRateLimiter(l.Global),
+		perClient:      shared.NewRateLimiterSet(l.PerClient),
+		perDestination: shared.NewRateLimiterSet(l.PerDestination),
 	}
+}
 
-	// Read response from lambda
-	responseBuf := make([]byte, 1)
-	if _, err := stream.Read(responseBuf); err != nil {
-		if connCtx.Err() != nil {
-			return // Context cancelled
-		}
-		shared.LogErrorf("Failed to read lambda response: %v", err)
-		clientConn.Write(shared.SOCKS5FailureResponse)
-		return
+// acquire returns the limiters that apply to a connection from client to
+// target, and a function releasing the per-key ones once the connection ends
+func (b *bandwidthLimits) acquire(client net.Addr, target string) (shared.RateLimiters, func()) {
+	if b == nil {
+		return nil, func() {}
 	}
 
-	if responseBuf[0] != 0x00 { // Success
-		shared.LogNetwork("Lambda failed to connect to target")
-		clientConn.Write(shared.SOCKS5FailureResponse)
-		return
+	clientKey := client.String()
+	if host, _, err := net.SplitHostPort(clientKey); err == nil {
+		clientKey = host
+	}
+	destKey := target
+	if host, _, err := net.SplitHostPort(target); err == nil {
+		destKey = host
 	}
 
-	// Send SOCKS5 success response
-	clientConn.Write(shared.SOCKS5SuccessResponse)
-
-	shared.LogSuccessf("SOCKS5 tunnel established to %s (optimized)", target)
-
-	// Start optimized bidirectional data forwarding with context awareness and custom buffer size
-	shared.OptimizedCopyWithContextAndBufferSize(connCtx, clientConn, &streamConn{stream}, bufferSize)
-	shared.LogClosef("SOCKS5 connection to %s closed (optimized)", target)
+	clientLimiter, releaseClient := b.perClient.Acquire(clientKey)
+	destLimiter, releaseDest := b.perDestination.Acquire(destKey)
+	limits := shared.RateLimiters{b.global, clientLimiter, destLimiter}
+	return limits, func() {
+		releaseClient()
+		releaseDest()
+	}
 }
 
-// handleSOCKS5ConnectionWithSessionAndContext handles a single SOCKS5 connection using a specific session with context
-func (p *DefaultProxy) handleSOCKS5ConnectionWithSessionAndContext(ctx context.Context, clientConn net.Conn, session *manager.Session) {
-	// Generate unique connection ID for tracking
-	connID := generateConnectionID()
-	
-	defer func() {
-		clientConn.Close()
-		metrics.DecrementActiveSOCKS5Connections()
-		// Clean up connection tracking
-		dashboard.GlobalConnectionTracker.RemoveConnection(connID)
-	}()
+// errTunnelDenied is returned by openTunnel when the Lambda's ACL refuses the target
+var errTunnelDenied = errors.New("destination denied by the Lambda's ACL")
 
-	// Record new connection
-	metrics.RecordSOCKS5Connection()
-	metrics.IncrementActiveSOCKS5Connections()
-	connStart := time.Now()
+// tunnelError is returned by openTunnel when the Lambda couldn't connect to the target
+type tunnelError struct {
+	target string
+	reply  shared.StreamReply
+}
 
-	shared.LogConnectionf("New SOCKS5 connection from %s using session %s", clientConn.RemoteAddr(), session.ID)
+func (e *tunnelError) Error() string {
+	if e.reply.Reason == "" {
+		return fmt.Sprintf("lambda failed to connect to %s", e.target)
+	}
+	return fmt.Sprintf("lambda failed to connect to %s: %s", e.target, e.reply.Reason)
+}
 
-	// Create a context for this connection
-	connCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
+// failureReply returns the SOCKS5 reply telling a client why its tunnel
+// couldn't be opened: the class of the Lambda's dial failure, or of a direct
+// dial's error, and a general failure otherwise
+func failureReply(err error) []byte {
+	var tunnelErr *tunnelError
+	if errors.As(err, &tunnelErr) {
+		return shared.SOCKS5Reply(tunnelErr.reply.Class.SOCKS5Reply())
+	}
+	if class := shared.ClassifyDialError(err); class != shared.ErrorClassUnknown {
+		return shared.SOCKS5Reply(class.SOCKS5Reply())
+	}
+	return shared.SOCKS5FailureResponse
+}
 
-	// Monitor for context cancellation
-	go func() {
-		<-connCtx.Done()
-		clientConn.Close()
+// openTunnel opens a stream to target through the Lambda and waits until the
+// Lambda has connected. The stream is closed on error.
+func openTunnel(ctx context.Context, opener streamOpener, frame shared.StreamFrame) (stream quic.Stream, err error) {
+	_, span := shared.StartSpan(ctx, "tunnel.open", shared.Attr("target", frame.String()))
+	defer func() {
+		span.RecordError(err)
+		span.End()
 	}()
-
-	// Handle SOCKS5 handshake (use optimized buffer size)
-	buf := make([]byte, shared.OptimizedBufferSize)
-	_, err := clientConn.Read(buf)
+	
+	stream, err = startTunnel(ctx, opener, frame)
 	if err != nil {
-		if connCtx.Err() != nil {
-			return // Context cancelled
-		}
-		shared.LogErrorf("Failed to read SOCKS5 handshake: %v", err)
-		return
+		return nil, err
 	}
+	if err := readTunnelReply(stream, frame.String()); err != nil {
+		(&streamConn{stream}).Close()
+		return nil, err
+	}
+	return stream, nil
+}
 
-	// Respond to SOCKS5 handshake (no auth)
-	if buf[0] == shared.SOCKS5Version {
-		clientConn.Write(shared.SOCKS5AuthResponse)
-	} else {
-		shared.LogNetwork("Not a SOCKS5 connection")
-		return
+// openPipelinedTunnel opens a stream to target through the Lambda without
+// waiting for its reply. Data written to the stream follows the target header
+// and is sent to the target once the Lambda connects, so the client's first
+// request costs no extra round trip. The reply is read by the first Read.
+func openPipelinedTunnel(ctx context.Context, opener streamOpener, frame shared.StreamFrame) (*pipelinedStream, error) {
+	_, span := shared.StartSpan(ctx, "tunnel.open", shared.Attr("target", frame.String()), shared.Attr("pipelined", true))
+	defer span.End()
+	
+	stream, err := startTunnel(ctx, opener, frame)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
 	}
+	return &pipelinedStream{streamConn: streamConn{stream}, target: frame.String()}, nil
+}
 
-	// Read SOCKS5 request
-	_, err = clientConn.Read(buf)
+// startTunnel opens a stream and sends its header, as a stream frame if the
+// Lambda supports them. The stream is closed on error.
+func startTunnel(ctx context.Context, opener streamOpener, frame shared.StreamFrame) (quic.Stream, error) {
+	stream, err := opener.OpenStreamSync(ctx)
 	if err != nil {
-		if connCtx.Err() != nil {
-			return // Context cancelled
-		}
-		shared.LogErrorf("Failed to read SOCKS5 request: %v", err)
-		return
+		return nil, fmt.Errorf("failed to open QUIC stream: %w", err)
+	}
+	
+	// Send the stream header to the lambda over QUIC
+	if err := shared.WriteStreamHeader(stream, frame, shared.SupportsStreamFrames(opener)); err != nil {
+		(&streamConn{stream}).Close()
+		return nil, err
 	}
+	return stream, nil
+}
 
-	if buf[0] != shared.SOCKS5Version || buf[1] != shared.SOCKS5Connect {
-		shared.LogNetwork("Only SOCKS5 CONNECT supported")
-		return
+// readTunnelReply reads the Lambda's reply to a stream header
+func readTunnelReply(stream io.Reader, target string) error {
+	reply, err := shared.ReadStreamReply(stream)
+	if err != nil {
+		return err
+	}
+	
+	switch {
+	case reply.OK():
+		return nil
+	case reply.Code == shared.SOCKS5ResponseDenied:
+		return errTunnelDenied
+	default:
+		return &tunnelError{target: target, reply: reply}
 	}
+}
 
-	// Parse target address
+// parseConnectTarget extracts host:port from a SOCKS5 CONNECT request, as
+// read from the client
+func parseConnectTarget(request []byte) (string, error) {
+	if len(request) < 5 {
+		return "", fmt.Errorf("truncated SOCKS5 request: %d bytes", len(request))
+	}
 	var targetAddr string
-	var targetPort uint16
+	var port []byte
 
-	switch buf[3] { // Address type
+	switch request[3] { // Address type
 	case shared.SOCKS5IPv4:
-		targetAddr = fmt.Sprintf("%d.%d.%d.%d", buf[4], buf[5], buf[6], buf[7])
-		targetPort = binary.BigEndian.Uint16(buf[8:10])
+		if len(request) < 10 {
+			return "", fmt.Errorf("truncated SOCKS5 request: %d bytes", len(request))
+		}
+		targetAddr = fmt.Sprintf("%d.%d.%d.%d", request[4], request[5], request[6], request[7])
+		port = request[8:10]
 	case shared.SOCKS5DomainName:
-		domainLen := buf[4]
-		targetAddr = string(buf[5 : 5+domainLen])
-		targetPort = binary.BigEndian.Uint16(buf[5+domainLen : 7+domainLen])
+		domainLen := int(request[4])
+		if len(request) < 7+domainLen {
+			return "", fmt.Errorf("truncated SOCKS5 request: %d bytes for a %d byte domain", len(request), domainLen)
+		}
+		targetAddr = string(request[5 : 5+domainLen])
+		port = request[5+domainLen : 7+domainLen]
 	default:
-		shared.LogErrorf("Unsupported address type: %d", buf[3])
-		return
+		return "", fmt.Errorf("unsupported address type: %d", request[3])
 	}
 
-	target := fmt.Sprintf("%s:%d", targetAddr, targetPort)
-	shared.LogTargetf("SOCKS5 request to %s via session %s", target, session.ID)
-	
-	// Add connection to tracker now that we know the destination
-	dashboard.GlobalConnectionTracker.AddConnection(connID, clientConn.RemoteAddr().String(), target)
+	return fmt.Sprintf("%s:%d", targetAddr, binary.BigEndian.Uint16(port)), nil
+}
 
-	// Open QUIC stream for this connection on the primary session with context
-	stream, err := session.QuicConn.OpenStreamSync(connCtx)
-	if err != nil {
-		if connCtx.Err() != nil {
-			return // Context cancelled
-		}
-		shared.LogErrorf("Failed to open QUIC stream on session %s: %v", session.ID, err)
-		clientConn.Write(shared.SOCKS5FailureResponse)
+// queueForSession holds a connection that arrived while no session was usable
+// until one becomes available or SessionWaitTimeout expires
+func (p *DefaultProxy) queueForSession(ctx context.Context, conn net.Conn, cm *manager.ConnManager) {
+	if p.opts.SessionWaitTimeout <= 0 {
+		shared.LogNetworkf("No suitable session available for connection from %s", conn.RemoteAddr())
+		conn.Close()
 		return
 	}
-	defer stream.Close()
 
-	// Send target address to lambda over QUIC
-	targetBytes := []byte(target)
-	targetLenBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(targetLenBytes, uint32(len(targetBytes)))
-
-	if _, err := stream.Write(targetLenBytes); err != nil {
-		if connCtx.Err() != nil {
-			return // Context cancelled
-		}
-		shared.LogErrorf("Failed to write target length: %v", err)
-		clientConn.Write(shared.SOCKS5FailureResponse)
+	select {
+	case p.queue <- struct{}{}:
+	default:
+		shared.LogNetworkf("Session wait queue full, closing connection from %s", conn.RemoteAddr())
+		conn.Close()
 		return
 	}
+	metrics.IncrementQueuedSOCKS5Connections()
 
-	if _, err := stream.Write(targetBytes); err != nil {
-		if connCtx.Err() != nil {
-			return // Context cancelled
-		}
-		shared.LogErrorf("Failed to write target address: %v", err)
-		clientConn.Write(shared.SOCKS5FailureResponse)
-		return
-	}
+	waitCtx, cancel := context.WithTimeout(ctx, p.opts.SessionWaitTimeout)
+	session, err := waitForUsableSession(waitCtx, cm)
+	cancel()
 
-	// Read response from lambda
-	responseBuf := make([]byte, 1)
-	if _, err := stream.Read(responseBuf); err != nil {
-		if connCtx.Err() != nil {
-			return // Context cancelled
+	<-p.queue
+	metrics.DecrementQueuedSOCKS5Connections()
+
+	if err != nil {
+		if ctx.Err() == nil {
+			metrics.RecordSOCKS5QueueTimeout()
+			shared.LogNetworkf("No session became available within %v for connection from %s", p.opts.SessionWaitTimeout, conn.RemoteAddr())
 		}
-		shared.LogErrorf("Failed to read lambda response: %v", err)
-		clientConn.Write(shared.SOCKS5FailureResponse)
+		conn.Close()
 		return
 	}
 
-	if responseBuf[0] != 0x00 { // Success
-		shared.LogNetwork("Lambda failed to connect to target")
-		clientConn.Write(shared.SOCKS5FailureResponse)
-		return
+	p.handleConnection(ctx, conn, p.sessionOptions(session))
+}
+
+// waitForUsableSession waits for a healthy, non-draining session
+func waitForUsableSession(ctx context.Context, cm *manager.ConnManager) (*manager.Session, error) {
+	for {
+		session, err := cm.WaitForSession(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !session.IsDraining() && !session.IsExpiring() && session.IsHealthy() {
+			return session, nil
+		}
 	}
+}
 
-	// Send SOCKS5 success response
-	clientConn.Write(shared.SOCKS5SuccessResponse)
+// countingConn counts the bytes read from and written to a client connection
+type countingConn struct {
+	net.Conn
+	read    atomic.Int64
+	written atomic.Int64
+}
 
-	shared.LogSuccessf("SOCKS5 tunnel established to %s via session %s", target, session.ID)
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Add(int64(n))
+	return n, err
+}
 
-	// Create a combined metrics recording function
-	recordBytes := func(bytes int64) {
-		metrics.RecordSOCKS5BytesTransferred(bytes)
-		dashboard.GlobalConnectionTracker.UpdateConnection(connID, bytes, 0, 0) // Update dashboard tracker
-	}
-	
-	// Start optimized bidirectional data forwarding with context awareness and metrics
-	shared.OptimizedCopyWithContextAndMetrics(connCtx, clientConn, &streamConn{stream}, recordBytes)
-	
-	// Record connection latency
-	connectionTime := time.Since(connStart)
-	metrics.RecordSOCKS5Latency(connectionTime)
-	
-	shared.LogClosef("SOCKS5 connection to %s closed (session %s)", target, session.ID)
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.written.Add(int64(n))
+	return n, err
 }
 
-// StartWithContext starts the SOCKS5 proxy server with context support for graceful shutdown
-func (p *DefaultProxy) StartWithContext(ctx context.Context, port int, quicConn quic.Connection) error {
-	socksAddr := fmt.Sprintf(":%d", port)
-	socksListener, err := net.Listen("tcp", socksAddr)
-	if err != nil {
-		return fmt.Errorf("failed to start SOCKS5 server: %w", err)
-	}
-	defer socksListener.Close()
+// clientWatchConn calls gone once, with the code to cancel the tunnel with,
+// when a read or write on the client's socket fails because the client
+// reset the connection or a deadline passed
+type clientWatchConn struct {
+	net.Conn
+	gone func(code quic.StreamErrorCode)
+	once sync.Once
+	code atomic.Int64 // the code passed to gone plus one, or 0
+}
 
-	// Set up graceful shutdown
-	go func() {
-		<-ctx.Done()
-		shared.LogNetwork("Shutting down SOCKS5 proxy server")
-		socksListener.Close()
-	}()
+func (c *clientWatchConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.check(err)
+	return n, err
+}
 
-	shared.LogSuccessf("SOCKS5 proxy server started on %s", socksAddr)
-	shared.LogInfof("Configure your browser to use SOCKS5 proxy: localhost%s", socksAddr)
+func (c *clientWatchConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.check(err)
+	return n, err
+}
 
-	// Accept SOCKS5 connections
-	for {
-		conn, err := socksListener.Accept()
-		if err != nil {
-			// Check if this is due to context cancellation (expected)
-			if ctx.Err() != nil {
-				shared.LogNetwork("SOCKS5 proxy server shutdown completed")
-				return nil
-			}
-			// Check if listener was closed
-			if ne, ok := err.(net.Error); ok && !ne.Temporary() {
-				shared.LogNetwork("SOCKS5 listener closed")
-				break
-			}
-			shared.LogErrorf("Failed to accept connection: %v", err)
-			continue
-		}
+func (c *clientWatchConn) check(err error) {
+	code, ok := shared.ClientErrorCode(err)
+	if !ok {
+		return
+	}
+	c.once.Do(func() {
+		c.code.Store(int64(code) + 1)
+		c.gone(code)
+	})
+}
 
-		go p.handleSOCKS5ConnectionWithContext(ctx, conn, quicConn)
+// goneCode returns the code the tunnel was cancelled with, if the client went away
+func (c *clientWatchConn) goneCode() (quic.StreamErrorCode, bool) {
+	stored := c.code.Load()
+	if stored == 0 {
+		return 0, false
 	}
+	return quic.StreamErrorCode(stored - 1), true
+}
 
-	return nil
+// pipelinedStream is a tunnel whose Lambda reply is read by the first Read,
+// so writes can start before the Lambda has connected
+type pipelinedStream struct {
+	streamConn
+	target string
+	once   sync.Once
+	err    error // why the Lambda failed to connect, once the reply is read
 }
 
-// StartWithConfigAndContext starts the SOCKS5 proxy server with configuration and context support
-func (p *DefaultProxy) StartWithConfigAndContext(ctx context.Context, port int, quicConn quic.Connection, bufferSize int) error {
-	socksAddr := fmt.Sprintf(":%d", port)
-	socksListener, err := net.Listen("tcp", socksAddr)
-	if err != nil {
-		return fmt.Errorf("failed to start SOCKS5 server: %w", err)
+func (ps *pipelinedStream) Read(b []byte) (int, error) {
+	ps.once.Do(func() { ps.err = readTunnelReply(ps.Stream, ps.target) })
+	if ps.err != nil {
+		return 0, ps.err
 	}
-	defer socksListener.Close()
+	return ps.streamConn.Read(b)
+}
 
-	// Set up graceful shutdown
-	go func() {
-		<-ctx.Done()
-		shared.LogNetwork("Shutting down SOCKS5 proxy server")
-		socksListener.Close()
-	}()
+// byteCountedConn counts a tunnel's bytes as they cross the wire
+type byteCountedConn struct {
+	net.Conn
+	id      quic.StreamID
+	counter shared.ByteCounter
+}
 
-	shared.LogSuccessf("SOCKS5 proxy server started on %s (optimized)", socksAddr)
-	shared.LogInfof("Configure your browser to use SOCKS5 proxy: localhost%s", socksAddr)
+func (bc *byteCountedConn) Read(b []byte) (int, error) {
+	n, err := bc.Conn.Read(b)
+	bc.counter.CountRead(n, err)
+	return n, err
+}
 
-	// Accept SOCKS5 connections
-	for {
-		conn, err := socksListener.Accept()
-		if err != nil {
-			// Check if this is due to context cancellation (expected)
-			if ctx.Err() != nil {
-				shared.LogNetwork("SOCKS5 proxy server shutdown completed")
-				return nil
-			}
-			// Check if listener was closed
-			if ne, ok := err.(net.Error); ok && !ne.Temporary() {
-				shared.LogNetwork("SOCKS5 listener closed")
-				break
-			}
-			shared.LogErrorf("Failed to accept connection: %v", err)
-			continue
-		}
+func (bc *byteCountedConn) Write(b []byte) (int, error) {
+	n, err := bc.Conn.Write(b)
+	bc.counter.CountWrite(n)
+	return n, err
+}
 
-		go p.handleSOCKS5ConnectionWithConfigAndContext(ctx, conn, quicConn, bufferSize)
-	}
+// compressedConn sends and receives a tunnel's payload compressed
+type compressedConn struct {
+	net.Conn
+	stream *shared.CompressedStream
+}
 
-	return nil
+func (cc *compressedConn) Read(b []byte) (int, error) {
+	return cc.stream.Read(b)
 }
 
-// StartWithConnManagerAndContext starts the SOCKS5 proxy server with a connection manager and context support
-func (p *DefaultProxy) StartWithConnManagerAndContext(ctx context.Context, port int, cm *manager.ConnManager) error {
-	socksAddr := fmt.Sprintf(":%d", port)
-	socksListener, err := net.Listen("tcp", socksAddr)
-	if err != nil {
-		return fmt.Errorf("failed to start SOCKS5 server: %w", err)
-	}
-	defer socksListener.Close()
+func (cc *compressedConn) Write(b []byte) (int, error) {
+	return cc.stream.Write(b)
+}
 
-	// Set up graceful shutdown
-	go func() {
-		<-ctx.Done()
-		shared.LogNetwork("Shutting down SOCKS5 proxy server")
-		socksListener.Close()
-	}()
+// streamConn adapts a QUIC stream to net.Conn interface for optimized copying
+type streamConn struct {
+	quic.Stream
+}
 
-	shared.LogSuccessf("SOCKS5 proxy server started on %s", socksAddr)
-	shared.LogInfof("Configure your browser to use SOCKS5 proxy: localhost%s", socksAddr)
+// Close closes both directions of the stream. quic.Stream.Close only closes
+// the send side, which would leave a pending Read blocked after the copy is
+// cancelled.
+func (sc *streamConn) Close() error {
+	sc.Stream.CancelRead(0)
+	return sc.Stream.Close()
+}
 
-	// Accept SOCKS5 connections
-	for {
-		conn, err := socksListener.Accept()
-		if err != nil {
-			// Check if this is due to context cancellation (expected)
-			if ctx.Err() != nil {
-				shared.LogNetwork("SOCKS5 proxy server shutdown completed")
-				return nil
-			}
-			// Check if listener was closed
-			if ne, ok := err.(net.Error); ok && !ne.Temporary() {
-				shared.LogNetwork("SOCKS5 listener closed")
-				break
-			}
-			shared.LogErrorf("Failed to accept connection: %v", err)
-			continue
-		}
-		// Get current primary session from ConnManager
-		session := cm.Primary()
-		if session == nil || session.IsDraining() || !session.IsHealthy() {
-			shared.LogNetworkf("No suitable session available for connection from %s", conn.RemoteAddr())
-			conn.Close()
-			continue
-		}
+func (sc *streamConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}
+}
 
-		go p.handleSOCKS5ConnectionWithSessionAndContext(ctx, conn, session)
-	}
+func (sc *streamConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}
+}
 
+func (sc *streamConn) SetDeadline(t time.Time) error {
+	sc.SetReadDeadline(t)
+	sc.SetWriteDeadline(t)
 	return nil
-}
\ No newline at end of file
+}
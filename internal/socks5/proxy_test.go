@@ -0,0 +1,970 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/audit"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/policy"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+	"github.com/quic-go/quic-go"
+)
+
+// pipeStream is a quic.Stream backed by one end of a net.Pipe
+type pipeStream struct {
+	quic.Stream
+	conn net.Conn
+}
+
+func (s *pipeStream) Read(b []byte) (int, error)         { return s.conn.Read(b) }
+func (s *pipeStream) Write(b []byte) (int, error)        { return s.conn.Write(b) }
+func (s *pipeStream) Close() error                       { return s.conn.Close() }
+func (s *pipeStream) CancelRead(quic.StreamErrorCode)    {}
+func (s *pipeStream) SetReadDeadline(t time.Time) error  { return s.conn.SetReadDeadline(t) }
+func (s *pipeStream) SetWriteDeadline(t time.Time) error { return s.conn.SetWriteDeadline(t) }
+func (s *pipeStream) SetDeadline(t time.Time) error      { return s.conn.SetDeadline(t) }
+
+// echoLambda accepts every target and echoes stream data back
+type echoLambda struct {
+	status byte
+}
+
+func (l *echoLambda) OpenStreamSync(ctx context.Context) (quic.Stream, error) {
+	local, remote := net.Pipe()
+	go func() {
+		defer remote.Close()
+		if _, err := shared.ReadSOCKS5TargetAddress(remote); err != nil {
+			return
+		}
+		remote.Write([]byte{l.status})
+		if l.status == 0x00 {
+			io.Copy(remote, remote)
+		}
+	}()
+	return &pipeStream{conn: local}, nil
+}
+
+// framedLambda negotiated stream frames and reports each header it reads
+type framedLambda struct {
+	frames chan shared.StreamFrame
+}
+
+func (l *framedLambda) ConnectionState() quic.ConnectionState {
+	var state quic.ConnectionState
+	state.TLS.NegotiatedProtocol = shared.StreamFrameProtocol
+	return state
+}
+
+func (l *framedLambda) OpenStreamSync(ctx context.Context) (quic.Stream, error) {
+	local, remote := net.Pipe()
+	go func() {
+		defer remote.Close()
+		frame, err := shared.ReadStreamHeader(remote)
+		if err != nil {
+			return
+		}
+		l.frames <- frame
+		remote.Write([]byte{byte(shared.SOCKS5ResponseSuccess)})
+		io.Copy(remote, remote)
+	}()
+	return &pipeStream{conn: local}, nil
+}
+
+// compressingLambda negotiated stream frames and echoes each stream's
+// payload through a compressed stream if its frame asked for one
+type compressingLambda struct {
+	framedLambda
+}
+
+func (l *compressingLambda) OpenStreamSync(ctx context.Context) (quic.Stream, error) {
+	local, remote := net.Pipe()
+	go func() {
+		defer remote.Close()
+		frame, err := shared.ReadStreamHeader(remote)
+		if err != nil {
+			return
+		}
+		l.frames <- frame
+		shared.WriteStreamReply(remote, shared.StreamReply{Code: shared.SOCKS5ResponseSuccess}, frame.ReplyFrame)
+		var tunnel io.ReadWriter = remote
+		if frame.Compression == shared.CompressionDeflate {
+			tunnel = shared.NewCompressedStream(remote)
+		}
+		io.Copy(tunnel, tunnel)
+	}()
+	return &pipeStream{conn: local}, nil
+}
+
+// failingLambda negotiated stream frames and fails every stream with reply
+type failingLambda struct {
+	framedLambda
+	reply shared.StreamReply
+}
+
+func (l *failingLambda) OpenStreamSync(ctx context.Context) (quic.Stream, error) {
+	local, remote := net.Pipe()
+	go func() {
+		defer remote.Close()
+		frame, err := shared.ReadStreamHeader(remote)
+		if err != nil {
+			return
+		}
+		shared.WriteStreamReply(remote, l.reply, frame.ReplyFrame)
+	}()
+	return &pipeStream{conn: local}, nil
+}
+
+// recordingMetrics counts metric events
+type recordingMetrics struct {
+	mu                     sync.Mutex
+	opened, closed, failed int
+	rejected, reaped       int
+	denied, shed           int
+	bytes                  int64
+	established            []string // "route target" of each tunnel that came up
+}
+
+func (m *recordingMetrics) ConnectionOpened()        { m.mu.Lock(); m.opened++; m.mu.Unlock() }
+func (m *recordingMetrics) ConnectionClosed()        { m.mu.Lock(); m.closed++; m.mu.Unlock() }
+func (m *recordingMetrics) ConnectionFailed()        { m.mu.Lock(); m.failed++; m.mu.Unlock() }
+func (m *recordingMetrics) ConnectionRejected()      { m.mu.Lock(); m.rejected++; m.mu.Unlock() }
+func (m *recordingMetrics) ConnectionReaped()        { m.mu.Lock(); m.reaped++; m.mu.Unlock() }
+func (m *recordingMetrics) ConnectionDenied()        { m.mu.Lock(); m.denied++; m.mu.Unlock() }
+func (m *recordingMetrics) ConnectionShed()          { m.mu.Lock(); m.shed++; m.mu.Unlock() }
+func (m *recordingMetrics) BytesTransferred(n int64) { m.mu.Lock(); m.bytes += n; m.mu.Unlock() }
+
+func (m *recordingMetrics) ConnectionEstablished(route, target string, latency time.Duration) {
+	m.mu.Lock()
+	m.established = append(m.established, route+" "+target)
+	m.mu.Unlock()
+}
+
+func (m *recordingMetrics) ConnectionFinished(string, string, time.Duration) {}
+
+// socks5Connect performs the client side of a SOCKS5 CONNECT to 10.0.0.1:80
+func socks5Connect(t *testing.T, conn net.Conn) byte {
+	t.Helper()
+	conn.Write([]byte{shared.SOCKS5Version, 1, 0})
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatalf("Failed to read auth reply: %v", err)
+	}
+
+	conn.Write([]byte{shared.SOCKS5Version, shared.SOCKS5Connect, 0, shared.SOCKS5IPv4, 10, 0, 0, 1, 0, 80})
+	reply = make([]byte, len(shared.SOCKS5SuccessResponse))
+	if _, err := io.ReadFull(conn, reply[:2]); err != nil {
+		t.Fatalf("Failed to read connect reply: %v", err)
+	}
+	if reply[1] == shared.SOCKS5Success {
+		io.ReadFull(conn, reply[2:])
+	}
+	return reply[1]
+}
+
+func TestListenAddress(t *testing.T) {
+	// Only this machine can use the proxy unless asked otherwise
+	p := NewWithOptions(DefaultOptions()).(*DefaultProxy)
+	if addr := p.listenAddress(1080); addr != "127.0.0.1:1080" {
+		t.Errorf("Expected the proxy to listen on 127.0.0.1:1080 by default, got %s", addr)
+	}
+
+	opts := DefaultOptions()
+	opts.Listen = "::"
+	p = NewWithOptions(opts).(*DefaultProxy)
+	if addr := p.listenAddress(1080); addr != "[::]:1080" {
+		t.Errorf("Expected [::]:1080, got %s", addr)
+	}
+}
+
+func TestParseConnectTarget(t *testing.T) {
+	var v, c byte = shared.SOCKS5Version, shared.SOCKS5Connect
+	domain := append([]byte{v, c, 0, shared.SOCKS5DomainName, 11}, "example.com"...)
+	tests := []struct {
+		request []byte
+		target  string // empty for requests that must be refused
+	}{
+		{[]byte{v, c, 0, shared.SOCKS5IPv4, 10, 0, 0, 1, 0, 80}, "10.0.0.1:80"},
+		{append(domain, 1, 187), "example.com:443"},
+		// Short reads and length bytes past the end of the request
+		{[]byte{v, c, 0}, ""},
+		{[]byte{v, c, 0, shared.SOCKS5IPv4, 10, 0, 0, 1}, ""},
+		{[]byte{v, c, 0, shared.SOCKS5DomainName}, ""},
+		{domain, ""},
+		{[]byte{v, c, 0, shared.SOCKS5DomainName, 255, 'a', 0, 80}, ""},
+		{[]byte{v, c, 0, 9, 0, 0}, ""},
+	}
+	for _, tt := range tests {
+		target, err := parseConnectTarget(tt.request)
+		if tt.target == "" && err == nil {
+			t.Errorf("Expected %v to be refused, got %s", tt.request, target)
+		} else if tt.target != "" && (err != nil || target != tt.target) {
+			t.Errorf("Expected %s from %v, got %q (%v)", tt.target, tt.request, target, err)
+		}
+	}
+}
+
+func TestHandleConnection(t *testing.T) {
+	p := NewWithOptions(DefaultOptions()).(*DefaultProxy)
+	sink := &recordingMetrics{}
+	opts := p.handlerOptions(&echoLambda{status: 0x00})
+	opts.metrics = sink
+	opts.tracker = nil
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		p.handleConnection(context.Background(), server, opts)
+		close(done)
+	}()
+
+	if status := socks5Connect(t, client); status != shared.SOCKS5Success {
+		t.Fatalf("Expected success reply, got %d", status)
+	}
+
+	client.Write([]byte("hello"))
+	echo := make([]byte, 5)
+	if _, err := io.ReadFull(client, echo); err != nil || string(echo) != "hello" {
+		t.Fatalf("Expected echo of hello, got %q (%v)", echo, err)
+	}
+	client.Close()
+	<-done
+
+	if sink.opened != 1 || sink.closed != 1 || sink.failed != 0 {
+		t.Errorf("Unexpected connection metrics: %+v", sink)
+	}
+	if sink.bytes != 10 {
+		t.Errorf("Expected 10 bytes recorded, got %d", sink.bytes)
+	}
+	if len(sink.established) != 1 || sink.established[0] != "tunnel 10.0.0.1:80" {
+		t.Errorf("Expected the tunnel's connect latency to be recorded, got %v", sink.established)
+	}
+}
+
+func TestHandleConnectionSendsStreamFrame(t *testing.T) {
+	p := NewWithOptions(DefaultOptions()).(*DefaultProxy)
+	lambda := &framedLambda{frames: make(chan shared.StreamFrame, 1)}
+	opts := p.handlerOptions(lambda)
+	opts.metrics = nil
+	opts.tracker = nil
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		p.handleConnection(context.Background(), server, opts)
+		close(done)
+	}()
+	if status := socks5Connect(t, client); status != shared.SOCKS5Success {
+		t.Fatalf("Expected success reply, got %d", status)
+	}
+	client.Close()
+	<-done
+
+	frame := <-lambda.frames
+	if frame.Command != shared.StreamConnect || frame.Target() != "10.0.0.1:80" {
+		t.Errorf("Expected a CONNECT frame for 10.0.0.1:80, got %+v", frame)
+	}
+}
+
+func TestHandleConnectionSendsTraceContext(t *testing.T) {
+	ctx := shared.ContextWithTraceparent(context.Background(), "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+
+	// Only Lambdas that announced CapTraceContext get the trace context
+	for _, trace := range []bool{false, true} {
+		p := NewWithOptions(DefaultOptions()).(*DefaultProxy)
+		lambda := &framedLambda{frames: make(chan shared.StreamFrame, 1)}
+		opts := p.handlerOptions(lambda)
+		opts.metrics = nil
+		opts.tracker = nil
+		opts.trace = trace
+
+		client, server := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			p.handleConnection(ctx, server, opts)
+			close(done)
+		}()
+		if status := socks5Connect(t, client); status != shared.SOCKS5Success {
+			t.Fatalf("Expected success reply, got %d", status)
+		}
+		client.Close()
+		<-done
+
+		frame := <-lambda.frames
+		if sent := frame.Traceparent != ""; sent != trace {
+			t.Errorf("Expected trace context sent = %v, got traceparent %q", trace, frame.Traceparent)
+		}
+	}
+}
+
+func TestHandleConnectionCompressesTunnel(t *testing.T) {
+	p := NewWithOptions(DefaultOptions()).(*DefaultProxy)
+	lambda := &compressingLambda{framedLambda{frames: make(chan shared.StreamFrame, 1)}}
+	opts := p.handlerOptions(lambda)
+	opts.metrics = nil
+	opts.tracker = nil
+	opts.compress = shared.CompressionDeflate
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go p.handleConnection(context.Background(), server, opts)
+	if status := socks5Connect(t, client); status != shared.SOCKS5Success {
+		t.Fatalf("Expected success reply, got %d", status)
+	}
+	if frame := <-lambda.frames; frame.Compression != shared.CompressionDeflate {
+		t.Fatalf("Expected the frame to ask for deflate, got %v", frame.Compression)
+	}
+
+	// The payload comes back unchanged through the compressed stream
+	payload := bytes.Repeat([]byte("compressible "), 1000)
+	go client.Write(payload)
+	got := make([]byte, len(payload))
+	if _, err := io.ReadFull(client, got); err != nil {
+		t.Fatalf("Failed to read echoed payload: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Error("Expected the payload to be echoed unchanged")
+	}
+}
+
+func TestHandleConnectionWritesAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	auditLog, err := audit.New(path, audit.Options{})
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	opts := DefaultOptions()
+	opts.Audit = auditLog
+	p := NewWithOptions(opts).(*DefaultProxy)
+	hopts := p.handlerOptions(&echoLambda{status: 0x00})
+	hopts.metrics = nil
+	hopts.tracker = nil
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		p.handleConnection(context.Background(), server, hopts)
+		close(done)
+	}()
+	if status := socks5Connect(t, client); status != shared.SOCKS5Success {
+		t.Fatalf("Expected success reply, got %d", status)
+	}
+	client.Write([]byte("hello"))
+	io.ReadFull(client, make([]byte, 5))
+	client.Close()
+	<-done
+	auditLog.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	var entry audit.Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("Expected one JSON line, got %q: %v", data, err)
+	}
+	if entry.Destination != "10.0.0.1:80" || entry.BytesIn != 5 || entry.BytesOut != 5 ||
+		entry.Route != "tunnel" || entry.CloseReason != audit.ReasonClosed {
+		t.Errorf("Unexpected audit entry: %+v", entry)
+	}
+}
+
+func TestHandleConnectionLambdaRejects(t *testing.T) {
+	p := NewWithOptions(DefaultOptions()).(*DefaultProxy)
+	sink := &recordingMetrics{}
+	opts := p.handlerOptions(&echoLambda{status: 0x01})
+	opts.metrics = sink
+	opts.tracker = nil
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		p.handleConnection(context.Background(), server, opts)
+		close(done)
+	}()
+
+	if status := socks5Connect(t, client); status == shared.SOCKS5Success {
+		t.Fatal("Expected failure reply when the Lambda rejects the target")
+	}
+	client.Close()
+	<-done
+
+	if sink.failed != 1 {
+		t.Errorf("Expected 1 failed connection, got %d", sink.failed)
+	}
+}
+
+func TestHandleConnectionLambdaErrorClass(t *testing.T) {
+	tests := []struct {
+		class shared.ErrorClass
+		want  byte
+	}{
+		{shared.ErrorClassRefused, shared.SOCKS5ConnectionRefused},
+		{shared.ErrorClassTimeout, shared.SOCKS5TTLExpired},
+		{shared.ErrorClassNetworkUnreachable, shared.SOCKS5NetworkUnreachable},
+		{shared.ErrorClassHostUnreachable, shared.SOCKS5HostUnreachable},
+		{shared.ErrorClassDNS, shared.SOCKS5HostUnreachable},
+		{shared.ErrorClassUnknown, shared.SOCKS5Failed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.class.String(), func(t *testing.T) {
+			p := NewWithOptions(DefaultOptions()).(*DefaultProxy)
+			lambda := &failingLambda{reply: shared.StreamReply{Code: shared.SOCKS5ResponseError, Class: tt.class, Reason: "dial failed"}}
+			opts := p.handlerOptions(lambda)
+			opts.metrics = nil
+			opts.tracker = nil
+
+			client, server := net.Pipe()
+			done := make(chan struct{})
+			go func() {
+				p.handleConnection(context.Background(), server, opts)
+				close(done)
+			}()
+			if status := socks5Connect(t, client); status != tt.want {
+				t.Errorf("Expected reply %#x for a %s failure, got %#x", tt.want, tt.class, status)
+			}
+			client.Close()
+			<-done
+		})
+	}
+}
+
+func TestHandleConnectionPipelined(t *testing.T) {
+	p := NewWithOptions(DefaultOptions()).(*DefaultProxy)
+	sink := &recordingMetrics{}
+	opts := p.handlerOptions(&echoLambda{status: 0x00})
+	opts.metrics = sink
+	opts.tracker = nil
+	opts.pipeline = true
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		p.handleConnection(context.Background(), server, opts)
+		close(done)
+	}()
+
+	if status := socks5Connect(t, client); status != shared.SOCKS5Success {
+		t.Fatalf("Expected success reply, got %d", status)
+	}
+
+	// The reply byte is consumed before the echoed data reaches the client
+	client.Write([]byte("hello"))
+	echo := make([]byte, 5)
+	if _, err := io.ReadFull(client, echo); err != nil || string(echo) != "hello" {
+		t.Fatalf("Expected echo of hello, got %q (%v)", echo, err)
+	}
+	client.Close()
+	<-done
+
+	if sink.failed != 0 || sink.denied != 0 {
+		t.Errorf("Unexpected connection metrics: %+v", sink)
+	}
+}
+
+func TestHandleConnectionPipelinedLambdaDenies(t *testing.T) {
+	p := NewWithOptions(DefaultOptions()).(*DefaultProxy)
+	sink := &recordingMetrics{}
+	opts := p.handlerOptions(&echoLambda{status: byte(shared.SOCKS5ResponseDenied)})
+	opts.metrics = sink
+	opts.tracker = nil
+	opts.pipeline = true
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		p.handleConnection(context.Background(), server, opts)
+		close(done)
+	}()
+
+	// The client is answered before the Lambda refuses, then sees the connection close
+	if status := socks5Connect(t, client); status != shared.SOCKS5Success {
+		t.Fatalf("Expected early success reply, got %d", status)
+	}
+	if _, err := client.Read(make([]byte, 1)); err == nil {
+		t.Error("Expected the connection to close after the Lambda refused")
+	}
+	<-done
+
+	if sink.denied != 1 || sink.failed != 0 {
+		t.Errorf("Expected 1 denied connection, got %+v", sink)
+	}
+}
+
+// failingOpener fails the test if a stream is opened
+type failingOpener struct {
+	t *testing.T
+}
+
+func (o failingOpener) OpenStreamSync(context.Context) (quic.Stream, error) {
+	o.t.Error("Expected no stream to be opened for a denied destination")
+	return nil, context.Canceled
+}
+
+func TestHandleConnectionACLDenies(t *testing.T) {
+	acl, err := shared.NewACL(shared.ACLConfig{Deny: []string{"private"}})
+	if err != nil {
+		t.Fatalf("NewACL failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		acl    *shared.ACL
+		opener streamOpener
+	}{
+		{"orchestrator", acl, failingOpener{t}},
+		{"lambda", nil, &echoLambda{status: byte(shared.SOCKS5ResponseDenied)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewWithOptions(DefaultOptions()).(*DefaultProxy)
+			sink := &recordingMetrics{}
+			opts := p.handlerOptions(tc.opener)
+			opts.metrics = sink
+			opts.tracker = nil
+			opts.acl = tc.acl
+
+			client, server := net.Pipe()
+			done := make(chan struct{})
+			go func() {
+				p.handleConnection(context.Background(), server, opts)
+				close(done)
+			}()
+
+			if status := socks5Connect(t, client); status != shared.SOCKS5NotAllowed {
+				t.Fatalf("Expected not-allowed reply, got %d", status)
+			}
+			client.Close()
+			<-done
+
+			if sink.denied != 1 || sink.failed != 0 {
+				t.Errorf("Expected 1 denied and no failed connections, got %+v", sink)
+			}
+		})
+	}
+}
+
+func TestHandleConnectionPolicyRefuses(t *testing.T) {
+	denying, err := policy.Parse([]byte("rules:\n  - {name: lan, match: ['10.0.0.0/8:80'], action: deny}\n"))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	quota, err := policy.Parse([]byte("quotas: {per_client: 1KB}\n"))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	quota.Quotas().Add("pipe", 1024) // net.Pipe's remote address
+
+	for _, tc := range []struct {
+		name   string
+		policy *policy.Policy
+	}{
+		{"rule", denying},
+		{"quota", quota},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewWithOptions(DefaultOptions()).(*DefaultProxy)
+			sink := &recordingMetrics{}
+			opts := p.handlerOptions(failingOpener{t})
+			opts.metrics = sink
+			opts.tracker = nil
+			opts.policy = tc.policy
+
+			client, server := net.Pipe()
+			done := make(chan struct{})
+			go func() {
+				p.handleConnection(context.Background(), server, opts)
+				close(done)
+			}()
+
+			if status := socks5Connect(t, client); status != shared.SOCKS5NotAllowed {
+				t.Fatalf("Expected not-allowed reply, got %d", status)
+			}
+			client.Close()
+			<-done
+
+			if sink.denied != 1 {
+				t.Errorf("Expected 1 denied connection, got %+v", sink)
+			}
+		})
+	}
+}
+
+func TestBandwidthLimitsAcquire(t *testing.T) {
+	if limits := newBandwidthLimits(shared.BandwidthLimits{}); limits != nil {
+		t.Fatal("Expected no limiters when bandwidth is unlimited")
+	}
+
+	b := newBandwidthLimits(shared.BandwidthLimits{PerClient: 1024, PerDestination: 1024})
+	client := &net.TCPAddr{IP: net.IPv4(192, 168, 1, 10), Port: 50000}
+	otherPort := &net.TCPAddr{IP: net.IPv4(192, 168, 1, 10), Port: 50001}
+
+	first, releaseFirst := b.acquire(client, "example.com:443")
+	second, releaseSecond := b.acquire(otherPort, "example.com:80")
+	defer releaseFirst()
+	defer releaseSecond()
+
+	if first[1] == nil || first[1] != second[1] {
+		t.Error("Expected connections from the same client IP to share a limiter")
+	}
+	if first[2] == nil || first[2] != second[2] {
+		t.Error("Expected connections to the same host to share a limiter")
+	}
+	if b.perClient.Len() != 1 || b.perDestination.Len() != 1 {
+		t.Errorf("Expected one key per set, got %d clients and %d destinations", b.perClient.Len(), b.perDestination.Len())
+	}
+}
+
+func TestAcceptLoopRejectsOverLimit(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxConnections = 1
+	p := NewWithOptions(opts).(*DefaultProxy)
+	sink := &recordingMetrics{}
+	p.metrics = sink
+	p.tracker = nil
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The first connection holds the only slot until release is closed
+	release := make(chan struct{})
+	handled := make(chan struct{}, 2)
+	go p.acceptLoop(ctx, listener, func(conn net.Conn) {
+		handled <- struct{}{}
+		<-release
+		conn.Close()
+	})
+
+	first, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer first.Close()
+	<-handled
+
+	second, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer second.Close()
+	second.SetDeadline(time.Now().Add(5 * time.Second))
+	if status := socks5Connect(t, second); status != shared.SOCKS5NotAllowed {
+		t.Errorf("Expected not-allowed reply while saturated, got %d", status)
+	}
+	sink.mu.Lock()
+	rejected := sink.rejected
+	sink.mu.Unlock()
+	if rejected != 1 {
+		t.Errorf("Expected 1 rejected connection, got %d", rejected)
+	}
+
+	// Once the slot is freed new connections are handled again
+	close(release)
+	deadline := time.After(5 * time.Second)
+	for {
+		third, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to dial: %v", err)
+		}
+		select {
+		case <-handled:
+			third.Close()
+			return
+		case <-time.After(100 * time.Millisecond):
+			third.Close()
+		case <-deadline:
+			third.Close()
+			t.Fatal("Expected a connection to be handled after the slot was released")
+		}
+	}
+}
+
+func TestHandleConnectionEgressRegionUnavailable(t *testing.T) {
+	eu, err := policy.Parse([]byte("rules:\n  - {name: eu, match: ['10.0.0.0/8'], egress: eu-west-1}\n"))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	p := NewWithOptions(DefaultOptions()).(*DefaultProxy)
+	opts := p.handlerOptions(failingOpener{t})
+	opts.metrics = nil
+	opts.tracker = nil
+	opts.policy = eu
+
+	// Without sessions in eu-west-1 the connection is refused, not tunnelled
+	// through the session it arrived on
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		p.handleConnection(context.Background(), server, opts)
+		close(done)
+	}()
+	if status := socks5Connect(t, client); status != shared.SOCKS5NetworkUnreachable {
+		t.Fatalf("Expected network-unreachable reply, got %d", status)
+	}
+	client.Close()
+	<-done
+}
+
+func TestRejectConnectionRefusalPolicy(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Refusal = shared.RefusalPolicy{Reply: shared.SOCKS5Failed, HTTPPage: true}
+	p := NewWithOptions(opts).(*DefaultProxy)
+	p.metrics = nil
+
+	// SOCKS5 clients get the configured reply code
+	client, server := net.Pipe()
+	go p.rejectConnection(server, "limit reached")
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	if status := socks5Connect(t, client); status != shared.SOCKS5Failed {
+		t.Errorf("Expected configured general failure reply, got %d", status)
+	}
+	client.Close()
+
+	// HTTP clients get a 403 page with the reason
+	client, server = net.Pipe()
+	go p.rejectConnection(server, "limit <reached>")
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	go client.Write([]byte("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n"))
+	page, _ := io.ReadAll(client)
+	if !strings.HasPrefix(string(page), "HTTP/1.1 403 Forbidden") || !strings.Contains(string(page), "limit &lt;reached&gt;") {
+		t.Errorf("Expected 403 page with escaped reason, got %q", page)
+	}
+}
+
+func TestAcceptLoopRefusesWhilePaused(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Refusal.WhilePaused = true
+	p := NewWithOptions(opts).(*DefaultProxy)
+	p.metrics = nil
+	p.guard = &resourceGuard{
+		limits:  shared.ResourceLimits{MaxGoroutines: 1},
+		sample:  func() shared.ResourceUsage { return shared.ResourceUsage{Goroutines: 100} },
+		resumed: make(chan struct{}),
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.acceptLoop(ctx, listener, func(conn net.Conn) {
+		t.Error("Expected no connection to be handled while paused")
+		conn.Close()
+	})
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if status := socks5Connect(t, conn); status != shared.SOCKS5NotAllowed {
+		t.Errorf("Expected not-allowed reply while paused, got %d", status)
+	}
+}
+
+func TestHandleConnectionReapsIdleTunnel(t *testing.T) {
+	opts := DefaultOptions()
+	opts.IdleTimeout = 100 * time.Millisecond
+	p := NewWithOptions(opts).(*DefaultProxy)
+	sink := &recordingMetrics{}
+	hopts := p.handlerOptions(&echoLambda{status: 0x00})
+	hopts.metrics = sink
+	hopts.tracker = nil
+
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		p.handleConnection(context.Background(), server, hopts)
+		close(done)
+	}()
+
+	if status := socks5Connect(t, client); status != shared.SOCKS5Success {
+		t.Fatalf("Expected success reply, got %d", status)
+	}
+
+	// Traffic keeps the tunnel open past the timeout
+	for i := 0; i < 4; i++ {
+		time.Sleep(50 * time.Millisecond)
+		client.Write([]byte("x"))
+		if _, err := io.ReadFull(client, make([]byte, 1)); err != nil {
+			t.Fatalf("Expected active tunnel to stay open, got %v", err)
+		}
+	}
+
+	// Then going quiet gets it reaped
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected idle tunnel to be closed")
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if sink.reaped != 1 {
+		t.Errorf("Expected 1 reaped connection, got %d", sink.reaped)
+	}
+}
+
+func TestHandleConnectionClosesTunnelAtMaxLifetime(t *testing.T) {
+	opts := DefaultOptions()
+	opts.ConnectTimeout = 3 * time.Second
+	opts.MaxStreamLifetime = 200 * time.Millisecond
+	p := NewWithOptions(opts).(*DefaultProxy)
+	lambda := &framedLambda{frames: make(chan shared.StreamFrame, 1)}
+	hopts := p.handlerOptions(lambda)
+	hopts.metrics = nil
+	hopts.tracker = nil
+
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		p.handleConnection(context.Background(), server, hopts)
+		close(done)
+	}()
+	if status := socks5Connect(t, client); status != shared.SOCKS5Success {
+		t.Fatalf("Expected success reply, got %d", status)
+	}
+
+	frame := <-lambda.frames
+	if frame.ConnectTimeout != 3*time.Second || frame.MaxLifetime != 200*time.Millisecond {
+		t.Errorf("Expected the frame to carry the connect timeout and lifetime, got %v and %v", frame.ConnectTimeout, frame.MaxLifetime)
+	}
+
+	// Traffic doesn't keep the tunnel open past its lifetime
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := client.Write([]byte("x")); err != nil {
+				return
+			}
+			if _, err := io.ReadFull(client, buf); err != nil {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected tunnel to be closed at its maximum lifetime")
+	}
+}
+
+// resetConn is a client socket the client has reset
+type resetConn struct {
+	net.Conn
+}
+
+func (resetConn) Read([]byte) (int, error) {
+	return 0, &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+}
+
+func TestClientWatchConnCancelsOnReset(t *testing.T) {
+	var codes []quic.StreamErrorCode
+	watched := &clientWatchConn{Conn: resetConn{}, gone: func(code quic.StreamErrorCode) {
+		codes = append(codes, code)
+	}}
+	if _, gone := watched.goneCode(); gone {
+		t.Fatal("Expected no cancellation before the reset")
+	}
+	watched.Read(make([]byte, 1))
+	watched.Read(make([]byte, 1))
+	if len(codes) != 1 || codes[0] != shared.StreamClientReset {
+		t.Errorf("Expected one cancellation with the reset code, got %v", codes)
+	}
+	if code, gone := watched.goneCode(); !gone || code != shared.StreamClientReset {
+		t.Errorf("Expected goneCode to report the reset, got %#x, %v", code, gone)
+	}
+}
+
+func TestAcceptLoopProxyProtocol(t *testing.T) {
+	opts := DefaultOptions()
+	opts.ProxyProtocol = shared.ProxyProtocolPolicy{Enabled: true}
+	p := NewWithOptions(opts).(*DefaultProxy)
+	p.metrics = nil
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type accepted struct {
+		client   string
+		greeting []byte
+	}
+	handled := make(chan accepted, 1)
+	go p.acceptLoop(ctx, listener, func(conn net.Conn) {
+		defer conn.Close()
+		greeting := make([]byte, 3)
+		io.ReadFull(conn, greeting)
+		handled <- accepted{conn.RemoteAddr().String(), greeting}
+	})
+
+	for _, tt := range []struct {
+		name   string
+		header string
+		client string // "" = the socket's own address
+	}{
+		{"v1 header", "PROXY TCP4 203.0.113.7 10.0.0.1 51000 1080\r\n", "203.0.113.7:51000"},
+		{"direct", "", ""},
+	} {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to dial: %v", err)
+		}
+		conn.Write([]byte(tt.header + "\x05\x01\x00"))
+		select {
+		case got := <-handled:
+			want := tt.client
+			if want == "" {
+				want = conn.LocalAddr().String()
+			}
+			if got.client != want {
+				t.Errorf("%s: expected client %s, got %s", tt.name, want, got.client)
+			}
+			if string(got.greeting) != "\x05\x01\x00" {
+				t.Errorf("%s: expected the SOCKS5 greeting after the header, got %q", tt.name, got.greeting)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: connection was not handled", tt.name)
+		}
+		conn.Close()
+	}
+
+	// Headers from peers outside the trusted list are refused
+	trusted, _ := shared.ParseTrustedProxies([]string{"10.0.0.0/8"})
+	p.opts.ProxyProtocol.Trusted = trusted
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 51000 1080\r\n\x05\x01\x00"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected an untrusted header to close the connection, got %v", err)
+	}
+	select {
+	case got := <-handled:
+		t.Errorf("Expected no connection to be handled, got one from %s", got.client)
+	default:
+	}
+}
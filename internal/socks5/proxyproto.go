@@ -0,0 +1,61 @@
+package socks5
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// proxiedConn is a client connection whose start was read through reader,
+// reporting the client address from its PROXY protocol header
+type proxiedConn struct {
+	net.Conn
+	reader *bufio.Reader
+	client net.Addr
+}
+
+func (c *proxiedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *proxiedConn) RemoteAddr() net.Addr {
+	return c.client
+}
+
+// acceptProxyHeader consumes the PROXY protocol header conn starts with, if
+// the proxy accepts them and one was sent. Clients that connect directly
+// send none and keep their socket address. Headers from untrusted peers
+// are refused, since they could claim any address.
+func (p *DefaultProxy) acceptProxyHeader(conn net.Conn) (net.Conn, error) {
+	if !p.opts.ProxyProtocol.Enabled {
+		return conn, nil
+	}
+
+	conn.SetReadDeadline(time.Now().Add(shared.ProxyHeaderTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	reader := bufio.NewReader(conn)
+	present, err := shared.HasProxyHeader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from client: %w", err)
+	}
+	client := &proxiedConn{Conn: conn, reader: reader, client: conn.RemoteAddr()}
+	if !present {
+		return client, nil
+	}
+	if !p.opts.ProxyProtocol.Trusts(conn.RemoteAddr()) {
+		return nil, fmt.Errorf("PROXY protocol header from untrusted peer")
+	}
+
+	source, err := shared.ReadProxyHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	if source != nil {
+		client.client = source
+	}
+	return client, nil
+}
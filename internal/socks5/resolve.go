@@ -0,0 +1,86 @@
+package socks5
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// nameResolver resolves CONNECT targets matching a ResolverRule locally, so
+// they can be dialed by IP through the tunnel or from this machine
+type nameResolver struct {
+	rules     []shared.ResolverRule
+	resolvers []*net.Resolver // one per rule
+}
+
+// route says where to connect for a CONNECT target
+type route struct {
+	address string // what to dial: the target itself, or its locally resolved IP and port
+	direct  bool   // dial from this machine instead of through the Lambda
+}
+
+// newNameResolver returns a resolver for rules, or nil when there are none
+func newNameResolver(rules []shared.ResolverRule) *nameResolver {
+	if len(rules) == 0 {
+		return nil
+	}
+	r := &nameResolver{rules: rules}
+	for _, rule := range rules {
+		resolver := net.DefaultResolver
+		if server := rule.ServerAddress(); server != "" {
+			resolver = &net.Resolver{
+				PreferGo: true,
+				Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, network, server)
+				},
+			}
+		}
+		r.resolvers = append(r.resolvers, resolver)
+	}
+	return r
+}
+
+// route resolves target if a rule matches its domain. Targets no rule
+// matches, including IP targets, go through the tunnel unchanged. Safe on a
+// nil resolver.
+func (r *nameResolver) route(ctx context.Context, target string) (route, error) {
+	if r == nil {
+		return route{address: target}, nil
+	}
+	host, port, err := net.SplitHostPort(target)
+	if err != nil || net.ParseIP(host) != nil {
+		return route{address: target}, nil
+	}
+
+	for i, rule := range r.rules {
+		if !rule.Matches(host) {
+			continue
+		}
+		lookupCtx, cancel := context.WithTimeout(ctx, shared.DefaultConnectionTimeout)
+		addrs, err := r.resolvers[i].LookupIPAddr(lookupCtx, host)
+		cancel()
+		if err != nil {
+			return route{}, fmt.Errorf("failed to resolve %s locally: %w", host, err)
+		}
+		if len(addrs) == 0 {
+			return route{}, fmt.Errorf("failed to resolve %s locally: no addresses", host)
+		}
+
+		// Prefer IPv4, which every Lambda can reach
+		ip := addrs[0].IP
+		for _, addr := range addrs {
+			if addr.IP.To4() != nil {
+				ip = addr.IP
+				break
+			}
+		}
+		return route{
+			address: net.JoinHostPort(ip.String(), port),
+			direct:  rule.Route == shared.ResolveDirect,
+		}, nil
+	}
+	return route{address: target}, nil
+}
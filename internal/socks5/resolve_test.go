@@ -0,0 +1,84 @@
+package socks5
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+func TestNameResolverRoute(t *testing.T) {
+	var none *nameResolver
+	if rt, err := none.route(context.Background(), "example.com:80"); err != nil || rt.address != "example.com:80" || rt.direct {
+		t.Errorf("Expected nil resolver to pass targets through, got %+v (%v)", rt, err)
+	}
+
+	r := newNameResolver([]shared.ResolverRule{{Domains: []string{"localhost"}}})
+	rt, err := r.route(context.Background(), "localhost:80")
+	if err != nil {
+		t.Fatalf("route failed: %v", err)
+	}
+	if rt.address != "127.0.0.1:80" || rt.direct {
+		t.Errorf("Expected localhost to resolve to 127.0.0.1:80 through the tunnel, got %+v", rt)
+	}
+	if rt, _ := r.route(context.Background(), "example.com:80"); rt.address != "example.com:80" {
+		t.Errorf("Expected unmatched domain to pass through, got %+v", rt)
+	}
+}
+
+func TestHandleConnectionDirectRoute(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	p := NewWithOptions(Options{Resolvers: []shared.ResolverRule{
+		{Domains: []string{"localhost"}, Route: shared.ResolveDirect},
+	}}).(*DefaultProxy)
+	opts := p.handlerOptions(failingOpener{t})
+	opts.metrics = &recordingMetrics{}
+	opts.tracker = nil
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		p.handleConnection(context.Background(), server, opts)
+		close(done)
+	}()
+
+	client.Write([]byte{shared.SOCKS5Version, 1, 0})
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(client, reply); err != nil {
+		t.Fatalf("Failed to read auth reply: %v", err)
+	}
+	host := "localhost"
+	request := []byte{shared.SOCKS5Version, shared.SOCKS5Connect, 0, shared.SOCKS5DomainName, byte(len(host))}
+	request = append(request, host...)
+	request = append(request, byte(port>>8), byte(port))
+	client.Write(request)
+
+	reply = make([]byte, len(shared.SOCKS5SuccessResponse))
+	if _, err := io.ReadFull(client, reply); err != nil || reply[1] != shared.SOCKS5Success {
+		t.Fatalf("Expected success reply for direct route, got %v (%v)", reply, err)
+	}
+
+	client.Write([]byte("hello"))
+	echo := make([]byte, 5)
+	if _, err := io.ReadFull(client, echo); err != nil || string(echo) != "hello" {
+		t.Fatalf("Expected echo of hello, got %q (%v)", echo, err)
+	}
+	client.Close()
+	<-done
+}
@@ -0,0 +1,161 @@
+package socks5
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/metrics"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// resourceGuard watches the process's resource use. While it is over a limit
+// the accept loop pauses and idle tunnels are shed; both resume once usage
+// drops below shared.ResourceResumeFraction of every limit. A nil
+// *resourceGuard never pauses.
+type resourceGuard struct {
+	limits shared.ResourceLimits
+	sample func() shared.ResourceUsage
+
+	mu      sync.Mutex
+	resumed chan struct{} // non-nil while over a limit, closed on recovery
+}
+
+// newResourceGuard returns a guard for limits, or nil when none are set
+func newResourceGuard(limits shared.ResourceLimits) *resourceGuard {
+	if limits.IsZero() {
+		return nil
+	}
+	if limits.MaxMemory > 0 {
+		// Make the GC work harder before the hard limit forces shedding
+		debug.SetMemoryLimit(limits.MaxMemory)
+	}
+	return &resourceGuard{
+		limits: limits,
+		sample: shared.SampleResourceUsage,
+	}
+}
+
+// run samples usage every shared.ResourceSampleInterval until ctx is done,
+// calling shed on every sample taken while over a limit
+func (g *resourceGuard) run(ctx context.Context, shed func() int) {
+	if g == nil {
+		return
+	}
+	ticker := time.NewTicker(shared.ResourceSampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			g.check(shed)
+		}
+	}
+}
+
+// check takes one sample and updates the paused state
+func (g *resourceGuard) check(shed func() int) {
+	usage := g.sample()
+	reason := usage.Exceeds(g.limits)
+
+	g.mu.Lock()
+	newlyOver, recovered := false, false
+	switch {
+	case g.resumed == nil && reason != "":
+		g.resumed = make(chan struct{})
+		newlyOver = true
+	case g.resumed != nil && usage.Exceeds(g.limits.Scaled(shared.ResourceResumeFraction)) == "":
+		close(g.resumed)
+		g.resumed = nil
+		recovered = true
+	}
+	paused := g.resumed != nil
+	g.mu.Unlock()
+
+	metrics.RecordResourceSample(usage.Goroutines, usage.OpenFiles, usage.Memory, newlyOver)
+	metrics.SetSOCKS5AcceptPaused(paused)
+
+	if newlyOver {
+		shared.LogErrorf("Resource limit exceeded (%s: %d goroutines, %d open files, %d MB), pausing new SOCKS5 connections",
+			reason, usage.Goroutines, usage.OpenFiles, usage.Memory>>20)
+	}
+	if recovered {
+		shared.LogSuccessf("Resource usage back under limits, accepting SOCKS5 connections again")
+	}
+	if paused && shed != nil {
+		if n := shed(); n > 0 {
+			shared.LogNetworkf("Shed %d idle SOCKS5 tunnels to free resources", n)
+		}
+	}
+}
+
+// wait blocks while the process is over a limit, returning early if ctx is done
+func (g *resourceGuard) wait(ctx context.Context) error {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	resumed := g.resumed
+	g.mu.Unlock()
+	if resumed == nil {
+		return nil
+	}
+
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// paused reports whether the process is currently over a limit
+func (g *resourceGuard) paused() bool {
+	if g == nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.resumed != nil
+}
+
+// tunnelSet tracks established tunnels so idle ones can be shed
+type tunnelSet struct {
+	mu      sync.Mutex
+	tunnels map[*idleReaper]context.CancelFunc
+}
+
+func newTunnelSet() *tunnelSet {
+	return &tunnelSet{tunnels: make(map[*idleReaper]context.CancelFunc)}
+}
+
+// add tracks a tunnel until the returned function is called
+func (s *tunnelSet) add(reaper *idleReaper, cancel context.CancelFunc) func() {
+	s.mu.Lock()
+	s.tunnels[reaper] = cancel
+	s.mu.Unlock()
+	return func() {
+		s.mu.Lock()
+		delete(s.tunnels, reaper)
+		s.mu.Unlock()
+	}
+}
+
+// shedIdle closes tunnels that have carried no data for idleFor and returns
+// how many were closed
+func (s *tunnelSet) shedIdle(idleFor time.Duration) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shed := 0
+	for reaper, cancel := range s.tunnels {
+		if reaper.idleFor() >= idleFor && reaper.markShed() {
+			cancel()
+			shed++
+		}
+	}
+	return shed
+}
@@ -0,0 +1,78 @@
+package socks5
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+func TestResourceGuardPausesAndResumes(t *testing.T) {
+	usage := shared.ResourceUsage{Goroutines: 50}
+	guard := &resourceGuard{
+		limits: shared.ResourceLimits{MaxGoroutines: 100},
+		sample: func() shared.ResourceUsage { return usage },
+	}
+
+	// Two tunnels: one idle past the shedding threshold, one active
+	tunnels := newTunnelSet()
+	idle, active := newIdleReaper(0), newIdleReaper(0)
+	idle.last.Store(time.Now().Add(-time.Minute).UnixNano())
+	idleCtx, idleCancel := context.WithCancel(context.Background())
+	activeCtx, activeCancel := context.WithCancel(context.Background())
+	defer activeCancel()
+	tunnels.add(idle, idleCancel)
+	tunnels.add(active, activeCancel)
+	shed := func() int { return tunnels.shedIdle(shared.ShedIdleAfter) }
+
+	guard.check(shed)
+	if err := guard.wait(context.Background()); err != nil {
+		t.Fatalf("Expected no pause under the limit, got %v", err)
+	}
+
+	// Going over the limit pauses and sheds only the idle tunnel
+	usage.Goroutines = 150
+	guard.check(shed)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := guard.wait(ctx); err == nil {
+		t.Error("Expected wait to block while over the limit")
+	}
+	if idleCtx.Err() == nil || !idle.wasShed() {
+		t.Error("Expected idle tunnel to be shed")
+	}
+	if activeCtx.Err() != nil {
+		t.Error("Expected active tunnel to stay open")
+	}
+
+	// Just under the limit is not enough to resume
+	usage.Goroutines = 95
+	guard.check(shed)
+	if guard.resumed == nil {
+		t.Error("Expected to stay paused above the resume threshold")
+	}
+
+	usage.Goroutines = 80
+	resumed := make(chan error, 1)
+	go func() { resumed <- guard.wait(context.Background()) }()
+	guard.check(shed)
+	select {
+	case err := <-resumed:
+		if err != nil {
+			t.Errorf("Expected wait to return nil on recovery, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected wait to return once usage recovered")
+	}
+}
+
+func TestNewResourceGuardDisabled(t *testing.T) {
+	if guard := newResourceGuard(shared.ResourceLimits{}); guard != nil {
+		t.Error("Expected nil guard without limits")
+	}
+	var guard *resourceGuard
+	if err := guard.wait(context.Background()); err != nil {
+		t.Errorf("Expected nil guard never to pause, got %v", err)
+	}
+}
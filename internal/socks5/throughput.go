@@ -0,0 +1,48 @@
+package socks5
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// errNoThroughputTargets is returned for throughput targets when the
+// session's Lambda predates them
+var errNoThroughputTargets = errors.New("the Lambda doesn't serve throughput test targets (redeploy it)")
+
+// serveThroughputTarget connects the client to a test target the Lambda
+// serves itself, such as shared.SourceTarget, and relays until either side
+// closes. Only the tunnel is measured, so ACLs, policy rules, local
+// resolution and rate limits don't apply. It returns the bytes sent to and
+// received from the client.
+func serveThroughputTarget(ctx context.Context, clientConn net.Conn, opts handlerOptions, command shared.StreamCommand) (int64, int64, error) {
+	if !shared.SupportsStreamFrames(opts.opener) {
+		clientConn.Write(shared.SOCKS5FailureResponse)
+		return 0, 0, errNoThroughputTargets
+	}
+	stream, err := openTunnel(ctx, opts.opener, shared.StreamFrame{Command: command})
+	if err != nil {
+		clientConn.Write(failureReply(err))
+		return 0, 0, err
+	}
+	upstream := &streamConn{stream}
+	defer upstream.Close()
+	if opts.session != nil {
+		defer opts.session.TrackStream()()
+	}
+
+	clientConn.Write(shared.SOCKS5SuccessResponse)
+	counted := &countingConn{Conn: clientConn}
+	bufferSize := opts.bufferSize
+	if bufferSize <= 0 {
+		bufferSize = shared.OptimizedBufferSize
+	}
+	shared.OptimizedCopyWithLimits(ctx, counted, upstream, bufferSize, func(n int64) {
+		if opts.metrics != nil {
+			opts.metrics.BytesTransferred(n)
+		}
+	}, nil)
+	return counted.written.Load(), counted.read.Load(), nil
+}
@@ -0,0 +1,114 @@
+package socks5
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+	"github.com/quic-go/quic-go"
+)
+
+// targetLambda negotiated stream frames and serves the echo target
+type targetLambda struct {
+	framedLambda
+}
+
+func (l *targetLambda) OpenStreamSync(ctx context.Context) (quic.Stream, error) {
+	local, remote := net.Pipe()
+	go func() {
+		defer remote.Close()
+		frame, err := shared.ReadStreamHeader(remote)
+		if err != nil {
+			return
+		}
+		l.frames <- frame
+		if frame.Command != shared.StreamEcho {
+			remote.Write([]byte{byte(shared.SOCKS5ResponseError)})
+			return
+		}
+		remote.Write([]byte{byte(shared.SOCKS5ResponseSuccess)})
+		io.Copy(remote, remote)
+	}()
+	return &pipeStream{conn: local}, nil
+}
+
+// socks5ConnectDomain performs the client side of a SOCKS5 CONNECT to host:port
+func socks5ConnectDomain(t *testing.T, conn net.Conn, host string, port uint16) byte {
+	t.Helper()
+	conn.Write([]byte{shared.SOCKS5Version, 1, 0})
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatalf("Failed to read auth reply: %v", err)
+	}
+
+	request := []byte{shared.SOCKS5Version, shared.SOCKS5Connect, 0, shared.SOCKS5DomainName, byte(len(host))}
+	request = append(request, host...)
+	request = binary.BigEndian.AppendUint16(request, port)
+	conn.Write(request)
+	reply = make([]byte, len(shared.SOCKS5SuccessResponse))
+	if _, err := io.ReadFull(conn, reply[:2]); err != nil {
+		t.Fatalf("Failed to read connect reply: %v", err)
+	}
+	if reply[1] == shared.SOCKS5Success {
+		io.ReadFull(conn, reply[2:])
+	}
+	return reply[1]
+}
+
+func TestHandleConnectionThroughputTarget(t *testing.T) {
+	p := NewWithOptions(DefaultOptions()).(*DefaultProxy)
+	lambda := &targetLambda{framedLambda{frames: make(chan shared.StreamFrame, 1)}}
+	opts := p.handlerOptions(lambda)
+	opts.metrics = nil
+	opts.tracker = nil
+	// The targets are served even where the ACL would refuse their names
+	acl, err := shared.NewACL(shared.ACLConfig{Default: shared.ACLDeny})
+	if err != nil {
+		t.Fatalf("NewACL failed: %v", err)
+	}
+	opts.acl = acl
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		p.handleConnection(context.Background(), server, opts)
+		close(done)
+	}()
+	if status := socks5ConnectDomain(t, client, "ECHO.lambda-nat-proxy.invalid", 7); status != shared.SOCKS5Success {
+		t.Fatalf("Expected success reply, got %d", status)
+	}
+	client.Write([]byte("hello"))
+	echo := make([]byte, 5)
+	if _, err := io.ReadFull(client, echo); err != nil || string(echo) != "hello" {
+		t.Fatalf("Expected echo of hello, got %q (%v)", echo, err)
+	}
+	client.Close()
+	<-done
+
+	frame := <-lambda.frames
+	if frame.Command != shared.StreamEcho || frame.Host != "" {
+		t.Errorf("Expected an addressless echo frame, got %+v", frame)
+	}
+}
+
+func TestHandleConnectionThroughputTargetLegacyLambda(t *testing.T) {
+	p := NewWithOptions(DefaultOptions()).(*DefaultProxy)
+	opts := p.handlerOptions(&echoLambda{status: 0x00})
+	opts.metrics = nil
+	opts.tracker = nil
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		p.handleConnection(context.Background(), server, opts)
+		close(done)
+	}()
+	if status := socks5ConnectDomain(t, client, shared.SourceTarget, 9); status == shared.SOCKS5Success {
+		t.Error("Expected a Lambda without stream frames to refuse the source target")
+	}
+	client.Close()
+	<-done
+}
@@ -0,0 +1,341 @@
+package socks5
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/features"
+	"github.com/dan-v/lambda-nat-punch-proxy/internal/manager"
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+	"github.com/quic-go/quic-go"
+)
+
+// flowCounter hands out UDP association flow IDs
+var flowCounter atomic.Uint32
+
+// datagramRouter fans QUIC datagrams received on one session out to UDP associations by flow ID
+type datagramRouter struct {
+	mu    sync.Mutex
+	flows map[uint32]chan shared.UDPDatagram
+}
+
+// register adds a flow and returns the channel its responses are delivered on
+func (r *datagramRouter) register(flowID uint32) chan shared.UDPDatagram {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ch := make(chan shared.UDPDatagram, 64)
+	r.flows[flowID] = ch
+	return ch
+}
+
+// unregister removes a flow
+func (r *datagramRouter) unregister(flowID uint32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.flows, flowID)
+}
+
+// run receives datagrams until the QUIC connection closes
+func (r *datagramRouter) run(conn quic.Connection) {
+	for {
+		data, err := conn.ReceiveDatagram(conn.Context())
+		if err != nil {
+			return
+		}
+
+		dgram, err := shared.DecodeUDPDatagram(data)
+		if err != nil {
+			shared.LogErrorf("Dropping invalid datagram: %v", err)
+			continue
+		}
+
+		r.mu.Lock()
+		ch, ok := r.flows[dgram.FlowID]
+		r.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		select {
+		case ch <- dgram:
+		default:
+			// Association is not keeping up; drop like a real UDP socket would
+		}
+	}
+}
+
+// datagramRouterFor returns the router for a session, starting it on first use
+func (p *DefaultProxy) datagramRouterFor(session *manager.Session) *datagramRouter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if router, ok := p.routers[session.ID]; ok {
+		return router
+	}
+
+	router := &datagramRouter{flows: make(map[uint32]chan shared.UDPDatagram)}
+	p.routers[session.ID] = router
+	go func() {
+		router.run(session.QuicConn)
+		p.mu.Lock()
+		delete(p.routers, session.ID)
+		p.mu.Unlock()
+	}()
+	return router
+}
+
+// udpAssociation relays one SOCKS5 UDP ASSOCIATE through a session
+type udpAssociation struct {
+	ctx       context.Context
+	session   *manager.Session
+	relay     *net.UDPConn
+	flowID    uint32
+	datagrams bool
+	acl       *shared.ACL // packets to denied destinations are dropped
+	metrics   metricsSink // optional
+	clientIP  net.IP      // the TCP client's IP; packets from other IPs are dropped
+
+	mu         sync.Mutex
+	clientAddr *net.UDPAddr
+	streams    map[string]quic.Stream
+}
+
+// handleUDPAssociate serves a SOCKS5 UDP ASSOCIATE request. The association
+// lives until the client closes its TCP control connection.
+func (p *DefaultProxy) handleUDPAssociate(ctx context.Context, clientConn net.Conn, session *manager.Session) {
+	localIP := net.IPv4(127, 0, 0, 1)
+	if tcpAddr, ok := clientConn.LocalAddr().(*net.TCPAddr); ok {
+		localIP = tcpAddr.IP
+	}
+
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: localIP, Port: 0})
+	if err != nil {
+		shared.LogErrorf("Failed to create UDP relay: %v", err)
+		clientConn.Write(shared.SOCKS5FailureResponse)
+		return
+	}
+	defer relay.Close()
+
+	if _, err := clientConn.Write(shared.BuildSOCKS5BindResponse(relay.LocalAddr().(*net.UDPAddr))); err != nil {
+		return
+	}
+
+	assoc := &udpAssociation{
+		ctx:       ctx,
+		session:   session,
+		relay:     relay,
+		flowID:    flowCounter.Add(1),
+		datagrams: session.QuicConn.ConnectionState().SupportsDatagrams && features.Enabled(features.Datagrams),
+		acl:       p.live.Load().acl,
+		metrics:   p.metrics,
+		clientIP:  tcpIP(clientConn.RemoteAddr()),
+		streams:   make(map[string]quic.Stream),
+	}
+	defer assoc.closeStreams()
+	defer session.TrackStream()()
+
+	if assoc.datagrams {
+		router := p.datagramRouterFor(session)
+		responses := router.register(assoc.flowID)
+		defer router.unregister(assoc.flowID)
+		go assoc.deliverDatagrams(responses)
+	}
+
+	shared.LogConnectionf("UDP association %d on %s via session %s (datagrams: %v)",
+		assoc.flowID, relay.LocalAddr(), session.ID, assoc.datagrams)
+
+	// The association ends when the TCP control connection closes
+	go func() {
+		io.Copy(io.Discard, clientConn)
+		relay.Close()
+	}()
+
+	assoc.relayFromClient()
+	shared.LogClosef("UDP association %d closed", assoc.flowID)
+}
+
+// relayFromClient forwards packets from the SOCKS5 client into the tunnel
+func (a *udpAssociation) relayFromClient() {
+	buf := make([]byte, shared.MaxUDPPayload)
+	for {
+		n, addr, err := a.relay.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		if !a.fromClient(addr) {
+			continue
+		}
+
+		target, payload, err := shared.ParseSOCKS5UDPRequest(buf[:n])
+		if err != nil {
+			shared.LogErrorf("Dropping SOCKS5 UDP packet: %v", err)
+			continue
+		}
+		if err := a.acl.Check(target); err != nil {
+			shared.LogNetworkf("Dropping UDP packet on association %d: %v", a.flowID, err)
+			if a.metrics != nil {
+				a.metrics.ConnectionDenied()
+			}
+			continue
+		}
+
+		if a.datagrams {
+			encoded, err := shared.EncodeUDPDatagram(shared.UDPDatagram{
+				FlowID:  a.flowID,
+				Address: target,
+				Payload: payload,
+			})
+			if err == nil && len(encoded) <= shared.MaxDatagramPayload {
+				if err := a.session.QuicConn.SendDatagram(encoded); err == nil {
+					continue
+				}
+			}
+		}
+
+		// Payload too large for a datagram (or datagrams disabled): use a relay stream
+		if err := a.sendOnStream(target, payload); err != nil {
+			shared.LogErrorf("Failed to relay UDP packet to %s: %v", target, err)
+		}
+	}
+}
+
+// fromClient reports whether a packet from addr came from the association's
+// client: the first sender from the TCP client's IP, as RFC 1928 section 7
+// requires. Later senders, even from that IP, are someone else.
+func (a *udpAssociation) fromClient(addr *net.UDPAddr) bool {
+	if a.clientIP != nil && !a.clientIP.Equal(addr.IP) {
+		shared.LogNetworkf("Dropping UDP packet on association %d from %s: not the client's address", a.flowID, addr)
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.clientAddr == nil {
+		a.clientAddr = addr
+	}
+	return a.clientAddr.String() == addr.String()
+}
+
+// tcpIP returns the IP of a TCP address, or nil for any other kind
+func tcpIP(addr net.Addr) net.IP {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP
+	}
+	return nil
+}
+
+// sendOnStream sends a payload over the relay stream for target, opening it if needed
+func (a *udpAssociation) sendOnStream(target string, payload []byte) error {
+	a.mu.Lock()
+	stream, ok := a.streams[target]
+	a.mu.Unlock()
+
+	if !ok {
+		var err error
+		stream, err = a.openStream(target)
+		if err != nil {
+			return err
+		}
+		a.mu.Lock()
+		a.streams[target] = stream
+		a.mu.Unlock()
+		go a.deliverStream(target, stream)
+	}
+
+	return shared.WriteUDPFrame(stream, payload)
+}
+
+// openStream opens a UDP relay stream to target on the session
+func (a *udpAssociation) openStream(target string) (quic.Stream, error) {
+	stream, err := a.session.QuicConn.OpenStreamSync(a.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open QUIC stream: %w", err)
+	}
+
+	frame, err := shared.NewStreamFrame(shared.StreamUDP, target)
+	if err != nil {
+		stream.Close()
+		return nil, err
+	}
+	if err := shared.WriteStreamHeader(stream, frame, shared.SupportsStreamFrames(a.session.QuicConn)); err != nil {
+		stream.Close()
+		return nil, err
+	}
+
+	reply, err := shared.ReadStreamReply(stream)
+	if err != nil {
+		stream.Close()
+		return nil, err
+	}
+	if !reply.OK() {
+		stream.Close()
+		if reply.Reason != "" {
+			return nil, fmt.Errorf("lambda refused UDP relay to %s: %s", target, reply.Reason)
+		}
+		return nil, fmt.Errorf("lambda refused UDP relay to %s", target)
+	}
+
+	return stream, nil
+}
+
+// deliverStream forwards packets from a relay stream back to the client
+func (a *udpAssociation) deliverStream(target string, stream quic.Stream) {
+	defer func() {
+		a.mu.Lock()
+		delete(a.streams, target)
+		a.mu.Unlock()
+		stream.Close()
+	}()
+
+	for {
+		payload, err := shared.ReadUDPFrame(stream)
+		if err != nil {
+			return
+		}
+		a.writeToClient(target, payload)
+	}
+}
+
+// deliverDatagrams forwards datagram responses back to the client
+func (a *udpAssociation) deliverDatagrams(responses <-chan shared.UDPDatagram) {
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case dgram := <-responses:
+			a.writeToClient(dgram.Address, dgram.Payload)
+		}
+	}
+}
+
+// writeToClient wraps a payload in a SOCKS5 UDP header and sends it to the client
+func (a *udpAssociation) writeToClient(source string, payload []byte) {
+	a.mu.Lock()
+	clientAddr := a.clientAddr
+	a.mu.Unlock()
+	if clientAddr == nil {
+		return
+	}
+
+	packet, err := shared.BuildSOCKS5UDPResponse(source, payload)
+	if err != nil {
+		shared.LogErrorf("Dropping UDP response from %s: %v", source, err)
+		return
+	}
+	a.relay.WriteToUDP(packet, clientAddr)
+}
+
+// closeStreams closes all relay streams of the association
+func (a *udpAssociation) closeStreams() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, stream := range a.streams {
+		stream.CancelRead(0)
+		stream.Close()
+	}
+}
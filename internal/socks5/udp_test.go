@@ -0,0 +1,27 @@
+package socks5
+
+import (
+	"net"
+	"testing"
+)
+
+func TestUDPAssociationFromClient(t *testing.T) {
+	a := &udpAssociation{clientIP: net.ParseIP("192.0.2.10")}
+
+	// Packets from other hosts neither count nor claim the association
+	if a.fromClient(&net.UDPAddr{IP: net.ParseIP("198.51.100.7"), Port: 5353}) {
+		t.Error("Expected a packet from another IP to be dropped")
+	}
+	if !a.fromClient(&net.UDPAddr{IP: net.ParseIP("192.0.2.10"), Port: 5353}) {
+		t.Error("Expected the first packet from the client's IP to be accepted")
+	}
+	if a.fromClient(&net.UDPAddr{IP: net.ParseIP("192.0.2.10"), Port: 5354}) {
+		t.Error("Expected another port on the client's IP to be dropped once the client was seen")
+	}
+
+	// Without a TCP address to go by, the first sender is the client
+	a = &udpAssociation{clientIP: tcpIP(&net.UnixAddr{Name: "/tmp/socks.sock"})}
+	if !a.fromClient(&net.UDPAddr{IP: net.ParseIP("198.51.100.7"), Port: 5353}) {
+		t.Error("Expected the first sender to be accepted without a client IP")
+	}
+}
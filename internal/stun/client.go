@@ -3,8 +3,8 @@ package stun
 import (
 	"context"
 	"fmt"
-	"net"
 
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
 	"github.com/pion/stun"
 )
 
@@ -14,17 +14,23 @@ type Client interface {
 }
 
 // DefaultClient implements Client
-type DefaultClient struct{}
+type DefaultClient struct {
+	ports shared.PortRange
+}
 
 // New creates a new STUN client
 func New() Client {
 	return &DefaultClient{}
 }
 
+// NewWithPortRange creates a STUN client that sends from a port in ports
+func NewWithPortRange(ports shared.PortRange) Client {
+	return &DefaultClient{ports: ports}
+}
+
 // DiscoverPublicIP discovers the public IP address using STUN
 func (c *DefaultClient) DiscoverPublicIP(ctx context.Context, stunServer string) (string, error) {
-	dialer := &net.Dialer{}
-	conn, err := dialer.DialContext(ctx, "udp", stunServer)
+	conn, err := shared.DialUDPInRange(ctx, c.ports, stunServer)
 	if err != nil {
 		return "", fmt.Errorf("failed to dial STUN server: %w", err)
 	}
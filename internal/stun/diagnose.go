@@ -0,0 +1,122 @@
+package stun
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pion/stun"
+)
+
+// NAT mapping behaviours reported by Diagnose
+const (
+	NATMappingIndependent = "endpoint-independent" // same public port for every destination; hole punching works
+	NATMappingDependent   = "endpoint-dependent"   // new public port per destination (symmetric NAT)
+	NATMappingUnknown     = "unknown"              // fewer than two servers answered
+)
+
+// diagnoseTimeout bounds each binding request
+const diagnoseTimeout = 3 * time.Second
+
+// NATMapping is the public address one STUN server saw
+type NATMapping struct {
+	Server string `json:"server"`
+	Mapped string `json:"mapped,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// NATReport describes how this machine's NAT maps outgoing UDP
+type NATReport struct {
+	LocalAddr string       `json:"local_addr"`
+	Mappings  []NATMapping `json:"mappings"`
+	Mapping   string       `json:"mapping"`
+	Advice    string       `json:"advice,omitempty"`
+}
+
+// Diagnose sends binding requests to each server from a single UDP socket
+// and compares the public addresses they report. Differing ports mean the NAT
+// picks a new port per destination, which defeats plain hole punching.
+func Diagnose(ctx context.Context, servers []string) (*NATReport, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open UDP socket: %w", err)
+	}
+	defer conn.Close()
+
+	report := &NATReport{LocalAddr: conn.LocalAddr().String()}
+	var ports []int
+	for _, server := range servers {
+		mapping := NATMapping{Server: server}
+		mapped, err := bindingRequest(ctx, conn, server)
+		if err != nil {
+			mapping.Error = err.Error()
+		} else {
+			mapping.Mapped = mapped.String()
+			ports = append(ports, mapped.Port)
+		}
+		report.Mappings = append(report.Mappings, mapping)
+	}
+
+	switch {
+	case len(ports) < 2:
+		report.Mapping = NATMappingUnknown
+		report.Advice = "Fewer than two STUN servers answered; check that outbound UDP is allowed"
+	case allEqual(ports):
+		report.Mapping = NATMappingIndependent
+	default:
+		report.Mapping = NATMappingDependent
+		report.Advice = "The NAT changes ports per destination; try punch_predict_ports or a different network"
+	}
+	return report, nil
+}
+
+// bindingRequest asks server for the public address of conn
+func bindingRequest(ctx context.Context, conn *net.UDPConn, server string) (*net.UDPAddr, error) {
+	serverAddr, err := net.ResolveUDPAddr("udp4", server)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", server, err)
+	}
+
+	request := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	if _, err := conn.WriteToUDP(request.Raw, serverAddr); err != nil {
+		return nil, fmt.Errorf("failed to send binding request: %w", err)
+	}
+
+	deadline := time.Now().Add(diagnoseTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+	defer conn.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return nil, fmt.Errorf("no response: %w", err)
+		}
+		if !from.IP.Equal(serverAddr.IP) {
+			continue // late answer from an earlier server
+		}
+
+		response := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+		if err := response.Decode(); err != nil || response.TransactionID != request.TransactionID {
+			continue
+		}
+		var xorAddr stun.XORMappedAddress
+		if err := xorAddr.GetFrom(response); err != nil {
+			return nil, fmt.Errorf("invalid binding response: %w", err)
+		}
+		return &net.UDPAddr{IP: xorAddr.IP, Port: xorAddr.Port}, nil
+	}
+}
+
+func allEqual(values []int) bool {
+	for _, v := range values[1:] {
+		if v != values[0] {
+			return false
+		}
+	}
+	return true
+}
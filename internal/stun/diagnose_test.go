@@ -0,0 +1,75 @@
+package stun
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/pion/stun"
+)
+
+// startFakeSTUN answers binding requests with the sender's address, its port
+// shifted by portShift to imitate a NAT
+func startFakeSTUN(t *testing.T, portShift int) string {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			request := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+			if err := request.Decode(); err != nil {
+				continue
+			}
+			response := stun.MustBuild(
+				stun.NewTransactionIDSetter(request.TransactionID),
+				stun.BindingSuccess,
+				&stun.XORMappedAddress{IP: from.IP, Port: from.Port + portShift},
+			)
+			conn.WriteToUDP(response.Raw, from)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestDiagnose(t *testing.T) {
+	tests := []struct {
+		name    string
+		shifts  []int
+		mapping string
+	}{
+		{"endpoint independent", []int{0, 0}, NATMappingIndependent},
+		{"symmetric", []int{0, 7}, NATMappingDependent},
+		{"single server", []int{0}, NATMappingUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var servers []string
+			for _, shift := range tt.shifts {
+				servers = append(servers, startFakeSTUN(t, shift))
+			}
+
+			report, err := Diagnose(context.Background(), servers)
+			if err != nil {
+				t.Fatalf("Diagnose failed: %v", err)
+			}
+			if report.Mapping != tt.mapping {
+				t.Errorf("Expected mapping %s, got %s (%+v)", tt.mapping, report.Mapping, report.Mappings)
+			}
+			for _, m := range report.Mappings {
+				if m.Error != "" {
+					t.Errorf("Unexpected error from %s: %s", m.Server, m.Error)
+				}
+			}
+		})
+	}
+}
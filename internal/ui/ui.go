@@ -0,0 +1,193 @@
+// Package ui holds the symbols the CLI decorates its output with and writes
+// that output in plain ASCII where a terminal can't render them. Commands
+// print through Printf, Println and Stdout instead of fmt and os.Stdout, so
+// a single switch covers every table, progress line and log message.
+package ui
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ASCIIEnv forces ASCII output on (1) or off (0), overriding detection
+const ASCIIEnv = "LAMBDA_PROXY_ASCII"
+
+// Status symbols. Use these rather than literal emoji so the ASCII
+// replacements below stay complete.
+const (
+	OK   = "✅"
+	Fail = "❌"
+	Warn = "⚠️"
+	Info = "ℹ️"
+	Tip  = "💡"
+)
+
+// replacements are the ASCII forms of the symbols that carry meaning. Other
+// emoji are decoration and are dropped in ASCII mode.
+var replacements = map[rune]string{
+	'✅': "[OK]",
+	'❌': "[FAIL]",
+	'⚠': "[WARN]",
+	'ℹ': "[INFO]",
+	'💡': "Tip:",
+	'─': "-",
+	'━': "-",
+	'│': "|",
+	'•': "*",
+	'→': "->",
+	'←': "<-",
+	'…': "...",
+	'×': "x",
+	'≈': "~",
+	'µ': "u",
+	'“': "\"",
+	'”': "\"",
+	'‘': "'",
+	'’': "'",
+	'—': "-",
+	'–': "-",
+}
+
+var ascii atomic.Bool
+
+func init() {
+	ascii.Store(detect(os.Getenv, runtime.GOOS))
+}
+
+// SetASCII turns ASCII output on or off
+func SetASCII(enabled bool) {
+	ascii.Store(enabled)
+}
+
+// ASCII reports whether output is being written in ASCII
+func ASCII() bool {
+	return ascii.Load()
+}
+
+// detect guesses whether the terminal renders emoji: not if ASCIIEnv says
+// so, TERM is dumb, the locale isn't UTF-8, or on a Windows console other
+// than Windows Terminal or an editor's terminal
+func detect(getenv func(string) string, goos string) bool {
+	if enabled, err := strconv.ParseBool(getenv(ASCIIEnv)); err == nil {
+		return enabled
+	}
+	if getenv("TERM") == "dumb" {
+		return true
+	}
+	if goos == "windows" {
+		return getenv("WT_SESSION") == "" && getenv("TERM_PROGRAM") == "" && getenv("ConEmuANSI") != "ON"
+	}
+	for _, name := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if locale := strings.ToLower(getenv(name)); locale != "" {
+			return !strings.Contains(locale, "utf-8") && !strings.Contains(locale, "utf8")
+		}
+	}
+	return false
+}
+
+// Text returns s as it should be shown: unchanged, or in ASCII mode with
+// symbols replaced and other emoji dropped
+func Text(s string) string {
+	if !ASCII() {
+		return s
+	}
+	return toASCII(s)
+}
+
+func toASCII(s string) string {
+	var b strings.Builder
+	dropped := false
+	for _, r := range s {
+		switch {
+		case r < utf8.RuneSelf:
+			// Don't leave the space that followed a dropped emoji at the start of a line
+			if dropped && r == ' ' && (b.Len() == 0 || strings.HasSuffix(b.String(), "\n")) {
+				dropped = false
+				continue
+			}
+			b.WriteRune(r)
+		case replacements[r] != "":
+			b.WriteString(replacements[r])
+		case r == 0xFE0F || r == 0xFE0E || r == 0x200D:
+			// Variation selectors and joiners only style the emoji around them
+			continue
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		default:
+			dropped = true
+			continue
+		}
+		dropped = false
+	}
+	return b.String()
+}
+
+// Writer passes what is written through Text. It buffers a multi-byte
+// character split across writes.
+type Writer struct {
+	out func() io.Writer
+
+	mu      sync.Mutex
+	partial []byte
+}
+
+// NewWriter returns a Writer that writes to out(), resolved on every write
+// so a replaced os.Stdout is honoured
+func NewWriter(out func() io.Writer) *Writer {
+	return &Writer{out: out}
+}
+
+// Write writes p, in ASCII if that mode is on
+func (w *Writer) Write(p []byte) (int, error) {
+	if !ASCII() {
+		return w.out().Write(p)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	data := append(w.partial, p...)
+	w.partial = nil
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	w.partial = append([]byte(nil), data[cut:]...)
+	if _, err := io.WriteString(w.out(), toASCII(string(data[:cut]))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Stdout and Stderr write to the process's standard output and error
+var (
+	Stdout = NewWriter(func() io.Writer { return os.Stdout })
+	Stderr = NewWriter(func() io.Writer { return os.Stderr })
+)
+
+// Printf formats to Stdout like fmt.Printf
+func Printf(format string, a ...interface{}) {
+	fmt.Fprintf(Stdout, format, a...)
+}
+
+// Println prints to Stdout like fmt.Println
+func Println(a ...interface{}) {
+	fmt.Fprintln(Stdout, a...)
+}
+
+// Print prints to Stdout like fmt.Print
+func Print(a ...interface{}) {
+	fmt.Fprint(Stdout, a...)
+}
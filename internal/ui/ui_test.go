@@ -0,0 +1,74 @@
+package ui
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	defer SetASCII(ASCII())
+
+	SetASCII(false)
+	if got := Text("✅ ready"); got != "✅ ready" {
+		t.Errorf("Expected text unchanged, got %q", got)
+	}
+
+	SetASCII(true)
+	tests := []struct {
+		in, want string
+	}{
+		{"Status:      " + OK + " CREATE_COMPLETE", "Status:      [OK] CREATE_COMPLETE"},
+		{Warn + "  3 sections skipped", "[WARN]  3 sections skipped"},
+		{"\n🚀 Lambda NAT Proxy Status\n", "\nLambda NAT Proxy Status\n"},
+		{"🗑️  Removed", " Removed"},
+		{"──── a → b • café", "---- a -> b * café"},
+		{Tip + " Set region", "Tip: Set region"},
+	}
+	for _, tt := range tests {
+		if got := Text(tt.in); got != tt.want {
+			t.Errorf("Text(%q) = %q, expected %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWriterSplitRune(t *testing.T) {
+	defer SetASCII(ASCII())
+	SetASCII(true)
+
+	var buf bytes.Buffer
+	w := NewWriter(func() io.Writer { return &buf })
+	check := []byte("ok ✅ done")
+	split := 5 // inside the check mark's three bytes
+	if n, err := w.Write(check[:split]); err != nil || n != split {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	w.Write(check[split:])
+	if buf.String() != "ok [OK] done" {
+		t.Errorf("Expected the split symbol to be replaced, got %q", buf.String())
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		env  map[string]string
+		goos string
+		want bool
+	}{
+		{map[string]string{"LANG": "en_US.UTF-8"}, "linux", false},
+		{map[string]string{"LANG": "C"}, "linux", true},
+		{map[string]string{"LC_ALL": "de_DE.utf8", "LANG": "C"}, "linux", false},
+		{map[string]string{"TERM": "dumb", "LANG": "en_US.UTF-8"}, "linux", true},
+		{map[string]string{}, "darwin", false},
+		{map[string]string{}, "windows", true},
+		{map[string]string{"WT_SESSION": "1"}, "windows", false},
+		{map[string]string{ASCIIEnv: "0"}, "windows", false},
+		{map[string]string{ASCIIEnv: "1", "LANG": "en_US.UTF-8"}, "linux", true},
+	}
+	for _, tt := range tests {
+		getenv := func(name string) string { return tt.env[name] }
+		if got := detect(getenv, tt.goos); got != tt.want {
+			t.Errorf("detect(%v, %s) = %v, expected %v", tt.env, tt.goos, got, tt.want)
+		}
+	}
+}
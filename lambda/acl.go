@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// aclDenials counts destinations refused by the session ACL in this invocation
+var aclDenials atomic.Int64
+
+// guardACL holds the destinations this function never dials, whatever the
+// orchestrator asks for, from BLOCKED_TARGETS (nil when turned off)
+var guardACL *shared.ACL
+
+func init() {
+	value := os.Getenv(shared.BlockedTargetsEnv)
+	acl, err := shared.ParseBlockedTargets(value)
+	if err != nil {
+		// Fall back to the defaults rather than dialing anything
+		shared.LogErrorf("Invalid %s %q, using defaults: %v", shared.BlockedTargetsEnv, value, err)
+		acl, _ = shared.ParseBlockedTargets("")
+		value = ""
+	}
+	guardACL = acl
+
+	switch {
+	case acl == nil:
+		shared.LogNetworkf("Destination guard disabled by %s", shared.BlockedTargetsEnv)
+	case value == "":
+		shared.LogNetworkf("Blocking destinations: %s", strings.Join(shared.DefaultBlockedTargets, ", "))
+	default:
+		shared.LogNetworkf("Blocking destinations: %s", value)
+	}
+}
+
+// sessionACL returns the guard rules plus the destination rules sent by the orchestrator
+func sessionACL(settings *shared.SessionSettings) (shared.ACLs, error) {
+	acls := shared.ACLs{guardACL}
+	if settings == nil || settings.ACL == nil {
+		return acls, nil
+	}
+	acl, err := shared.NewACL(*settings.ACL)
+	if err != nil {
+		return nil, err
+	}
+	return append(acls, acl), nil
+}
+
+// recordDenial logs and counts err if it is an ACL denial, reporting whether it was
+func recordDenial(err error) bool {
+	if !errors.Is(err, shared.ErrACLDenied) {
+		return false
+	}
+	total := aclDenials.Add(1)
+	shared.LogNetworkf("Refused by ACL: %v (%d denied this invocation)", err, total)
+	return true
+}
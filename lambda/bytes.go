@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// byteCountSettle is how long a finished stream's counters are left alone
+// before they are reported, so its copy goroutines have returned
+const byteCountSettle = time.Second
+
+// byteLedger keeps the byte counters of finished tunnel streams until the
+// orchestrator asks for them with OpByteReport
+type byteLedger struct {
+	mu       sync.Mutex
+	finished []finishedStream
+}
+
+type finishedStream struct {
+	id      quic.StreamID
+	counter *shared.ByteCounter
+	at      time.Time
+}
+
+// add records that the stream with id finished. Stream IDs repeat across a
+// session's QUIC connections, so streams on extra connections go uncounted
+// with a nil ledger.
+func (l *byteLedger) add(id quic.StreamID, counter *shared.ByteCounter) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.finished = append(l.finished, finishedStream{id: id, counter: counter, at: time.Now()})
+}
+
+// drain returns the counts of up to shared.MaxByteCounts streams that have
+// settled and forgets them
+func (l *byteLedger) drain() []shared.StreamBytes {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cutoff := time.Now().Add(-byteCountSettle)
+	var counts []shared.StreamBytes
+	n := 0
+	for n < len(l.finished) && len(counts) < shared.MaxByteCounts && l.finished[n].at.Before(cutoff) {
+		counts = append(counts, l.finished[n].counter.Bytes(uint64(l.finished[n].id)))
+		n++
+	}
+	l.finished = append(l.finished[:0], l.finished[n:]...)
+	return counts
+}
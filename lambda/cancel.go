@@ -0,0 +1,36 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/quic-go/quic-go"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// peerWatchStream calls onCancel once when the orchestrator cancels the
+// stream because its SOCKS5 client went away, so the target can be dropped
+// before more of its data is pumped toward a client that is gone
+type peerWatchStream struct {
+	quic.Stream
+	onCancel func(reason string, code quic.StreamErrorCode)
+	once     sync.Once
+}
+
+func (s *peerWatchStream) Read(p []byte) (int, error) {
+	n, err := s.Stream.Read(p)
+	s.check(err)
+	return n, err
+}
+
+func (s *peerWatchStream) Write(p []byte) (int, error) {
+	n, err := s.Stream.Write(p)
+	s.check(err)
+	return n, err
+}
+
+func (s *peerWatchStream) check(err error) {
+	if reason, code, ok := shared.PeerCancelReason(err); ok {
+		s.once.Do(func() { s.onCancel(reason, code) })
+	}
+}
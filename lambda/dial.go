@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// sessionDialer connects to the targets of one session, enforcing its ACLs
+// and resolving domains with its DNS settings
+type sessionDialer struct {
+	acls     shared.ACLs
+	resolver *shared.DNSResolver   // nil = system resolver
+	metrics  *shared.EMFRecorder   // nil = Lambda metrics off
+	bytes    byteLedger            // finished streams' byte counts, for the orchestrator
+	logs     *shared.LogForwarding // nil = log lines stay in CloudWatch
+	expiry   *sessionExpiry        // nil = the session doesn't expire
+}
+
+// newSessionDialer builds the dialer for the settings sent by the orchestrator
+func newSessionDialer(settings *shared.SessionSettings) (*sessionDialer, error) {
+	acls, err := sessionACL(settings)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ACL: %w", err)
+	}
+	dialer := &sessionDialer{acls: acls}
+	if settings != nil && settings.Logs != nil {
+		if err := settings.Logs.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid log forwarding settings: %w", err)
+		}
+		dialer.logs = settings.Logs
+	}
+	if settings != nil && settings.DNS != nil {
+		resolver, err := shared.NewDNSResolver(*settings.DNS)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DNS settings: %w", err)
+		}
+		if resolver != nil {
+			shared.LogNetworkf("Resolving targets via %s", settings.DNS.Upstream)
+		}
+		dialer.resolver = resolver
+	}
+	return dialer, nil
+}
+
+// dialTCP connects to frame's target, or to its pinned address when the
+// orchestrator pinned the address the target's domain resolves to, within
+// the frame's connect timeout
+func (d *sessionDialer) dialTCP(frame shared.StreamFrame) (net.Conn, error) {
+	timeout := shared.DefaultConnectionTimeout
+	if frame.ConnectTimeout > 0 {
+		timeout = frame.ConnectTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var conn net.Conn
+	var err error
+	if frame.PinnedIP != "" {
+		conn, err = d.dialPinned(ctx, frame.Target(), frame.PinnedIP)
+	} else {
+		conn, err = d.resolver.DialContext(ctx, "tcp", frame.Target(), d.acls)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok && frame.KeepAlive > 0 {
+		tcp.SetKeepAlive(true)
+		tcp.SetKeepAlivePeriod(frame.KeepAlive)
+	}
+	return conn, nil
+}
+
+// dialPinned connects to target's port at pinnedIP, checking the pair
+// against the ACLs as if target had resolved to it
+func (d *sessionDialer) dialPinned(ctx context.Context, target, pinnedIP string) (net.Conn, error) {
+	if err := d.acls.Check(target); err != nil {
+		return nil, err
+	}
+	_, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target %q: %w", target, err)
+	}
+	address := net.JoinHostPort(pinnedIP, port)
+	if err := d.acls.CheckResolved(target, address); err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", address)
+}
+
+// dialUDP resolves and connects a UDP socket to target, refusing
+// destinations any of the ACLs deny
+func (d *sessionDialer) dialUDP(target string) (*net.UDPConn, error) {
+	if err := shared.ValidateTargetAddress(target); err != nil {
+		return nil, err
+	}
+	if err := d.acls.Check(target); err != nil {
+		return nil, err
+	}
+
+	addr, err := d.resolveUDP(target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", target, err)
+	}
+	if err := d.acls.CheckResolved(target, addr.String()); err != nil {
+		return nil, err
+	}
+
+	return net.DialUDP("udp", nil, addr)
+}
+
+// resolveUDP resolves target with the session resolver, or the system one
+func (d *sessionDialer) resolveUDP(target string) (*net.UDPAddr, error) {
+	if d.resolver == nil {
+		return net.ResolveUDPAddr("udp", target)
+	}
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shared.DefaultConnectionTimeout)
+	defer cancel()
+	ips, err := d.resolver.LookupIP(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	return net.ResolveUDPAddr("udp", net.JoinHostPort(ips[0].String(), port))
+}
+
+// flushDNS empties the session's DNS cache
+func (d *sessionDialer) flushDNS() {
+	if d.resolver == nil {
+		shared.LogNetwork("DNS flush requested, but the session uses the system resolver")
+		return
+	}
+	shared.LogNetworkf("Flushed %d cached DNS entries", d.resolver.Flush())
+}
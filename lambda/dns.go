@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+	"github.com/quic-go/quic-go"
+)
+
+var (
+	systemResolverOnce sync.Once
+	systemResolver     *shared.DNSResolver
+	systemResolverErr  error
+)
+
+// queryResolver returns the resolver that answers forwarded DNS queries: the
+// session's upstream, or the nameserver this function is configured with
+func (d *sessionDialer) queryResolver() (*shared.DNSResolver, error) {
+	if d.resolver != nil {
+		return d.resolver, nil
+	}
+	systemResolverOnce.Do(func() {
+		var upstream string
+		upstream, systemResolverErr = shared.SystemDNSUpstream()
+		if systemResolverErr == nil {
+			systemResolver, systemResolverErr = shared.NewDNSResolver(shared.DNSConfig{Upstream: upstream})
+		}
+	})
+	return systemResolver, systemResolverErr
+}
+
+// handleDNSStream answers the DNS queries sent by the orchestrator's local DNS
+// stub until the stream is closed. Each query is a raw DNS message framed as
+// in DNS over TCP; failed lookups are answered with SERVFAIL.
+func handleDNSStream(stream quic.Stream, dialer *sessionDialer) {
+	resolver, err := dialer.queryResolver()
+	if err != nil {
+		shared.LogErrorf("No resolver for forwarded DNS queries: %v", err)
+		shared.WriteSOCKS5Response(stream, shared.SOCKS5ResponseError)
+		return
+	}
+	if err := shared.WriteSOCKS5Response(stream, shared.SOCKS5ResponseSuccess); err != nil {
+		shared.LogError("Failed to send success response", err)
+		return
+	}
+
+	for {
+		query, err := shared.ReadDNSMessage(stream)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				shared.LogErrorf("Failed to read forwarded DNS query: %v", err)
+			}
+			return
+		}
+
+		response, err := resolver.Exchange(context.Background(), query)
+		if err != nil {
+			shared.LogErrorf("Forwarded DNS query failed: %v", err)
+			response = shared.DNSFailure(query)
+			if response == nil {
+				return
+			}
+		}
+		if err := shared.WriteDNSMessage(stream, response); err != nil {
+			shared.LogErrorf("Failed to send DNS response: %v", err)
+			return
+		}
+	}
+}
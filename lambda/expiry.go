@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// expiryPollInterval is how often an expiring session checks for open streams
+const expiryPollInterval = 250 * time.Millisecond
+
+// The notice and exit margins are at most these fractions of the time a
+// session has when it starts, so a function with a short timeout still takes
+// streams for most of its run
+const (
+	expiryNoticeShare = 4  // the notice comes at most a quarter of the run before the deadline
+	expiryExitShare   = 20 // the exit comes at most a twentieth of the run before it
+)
+
+// sessionExpiry ends a session before the function times out.
+// shared.LambdaExpiryNotice before the deadline it stops taking new streams
+// and, if the orchestrator understands it, tells it with OpExpiring so it
+// moves traffic to another session. The session then exits once its open
+// streams finish, or shared.LambdaExitMargin before the deadline at the
+// latest, so AWS never kills it mid-stream. Both margins shrink for short
+// function timeouts. A nil *sessionExpiry, for a context without a deadline,
+// never expires.
+type sessionExpiry struct {
+	deadline time.Time
+	noticeAt time.Time     // when new streams are refused
+	exitAt   time.Time     // when the session exits with streams still open
+	poll     time.Duration // how often open streams are checked after the notice
+	notice   chan struct{} // closed at the notice
+	exit     chan struct{} // closed when the session should exit
+	streams  atomic.Int64  // tunnel streams open
+}
+
+// newSessionExpiry returns the expiry of a session run under ctx, or nil
+// if ctx has no deadline
+func newSessionExpiry(ctx context.Context) *sessionExpiry {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil
+	}
+	return expiryAt(deadline, time.Now())
+}
+
+// expiryAt returns the expiry of a session starting at now that must end by
+// deadline
+func expiryAt(deadline, now time.Time) *sessionExpiry {
+	remaining := deadline.Sub(now)
+	noticeMargin := min(shared.LambdaExpiryNotice, remaining/expiryNoticeShare)
+	exitMargin := min(shared.LambdaExitMargin, remaining/expiryExitShare)
+	return &sessionExpiry{
+		deadline: deadline,
+		noticeAt: deadline.Add(-noticeMargin),
+		exitAt:   deadline.Add(-exitMargin),
+		poll:     max(min(expiryPollInterval, (noticeMargin-exitMargin)/10), time.Millisecond),
+		notice:   make(chan struct{}),
+		exit:     make(chan struct{}),
+	}
+}
+
+// run waits for the notice, then for the open streams to finish, until ctx
+// is done
+func (e *sessionExpiry) run(ctx context.Context) {
+	if e == nil {
+		return
+	}
+	notice := time.NewTimer(time.Until(e.noticeAt))
+	defer notice.Stop()
+	select {
+	case <-notice.C:
+	case <-ctx.Done():
+		return
+	}
+	close(e.notice)
+	shared.LogNetworkf("Function times out in %v, taking no new streams (%d open)", time.Until(e.deadline).Round(time.Second), e.streams.Load())
+
+	exit := time.NewTimer(time.Until(e.exitAt))
+	defer exit.Stop()
+	poll := time.NewTicker(e.poll)
+	defer poll.Stop()
+	for {
+		select {
+		case <-poll.C:
+			if e.streams.Load() > 0 {
+				continue
+			}
+			shared.LogNetwork("All streams finished before the function timeout, exiting")
+		case <-exit.C:
+			shared.LogNetworkf("Function timeout near, exiting with %d streams open", e.streams.Load())
+		case <-ctx.Done():
+			return
+		}
+		close(e.exit)
+		return
+	}
+}
+
+// notify writes OpExpiring with write once the notice is due, unless ctx is
+// done first
+func (e *sessionExpiry) notify(ctx context.Context, write func(func(io.Writer) error) error) {
+	if e == nil {
+		return
+	}
+	select {
+	case <-e.notice:
+	case <-ctx.Done():
+		return
+	}
+	remaining := time.Until(e.deadline)
+	if err := write(func(w io.Writer) error { return shared.WriteExpiring(w, remaining) }); err != nil {
+		shared.LogError("Failed to send expiring notice", err)
+	}
+}
+
+// expiring reports whether the notice is past, so new streams are refused
+func (e *sessionExpiry) expiring() bool {
+	if e == nil {
+		return false
+	}
+	select {
+	case <-e.notice:
+		return true
+	default:
+		return false
+	}
+}
+
+// done is closed when the session should exit
+func (e *sessionExpiry) done() <-chan struct{} {
+	if e == nil {
+		return nil
+	}
+	return e.exit
+}
+
+// track counts a tunnel stream as open until the returned function is called
+func (e *sessionExpiry) track() func() {
+	if e == nil {
+		return func() {}
+	}
+	e.streams.Add(1)
+	return func() { e.streams.Add(-1) }
+}
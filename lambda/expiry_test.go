@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+func TestExpiryMargins(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		timeout time.Duration
+		notice  time.Duration // before the deadline
+		exit    time.Duration // before the deadline
+	}{
+		// Long timeouts get the full margins
+		{15 * time.Minute, shared.LambdaExpiryNotice, shared.LambdaExitMargin},
+		{2 * time.Minute, shared.LambdaExpiryNotice, shared.LambdaExitMargin},
+		// Short ones still take streams for most of the run
+		{15 * time.Second, 3750 * time.Millisecond, 750 * time.Millisecond},
+		{10 * time.Second, 2500 * time.Millisecond, 500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		deadline := now.Add(tt.timeout)
+		e := expiryAt(deadline, now)
+		if notice := deadline.Sub(e.noticeAt); notice != tt.notice {
+			t.Errorf("With a %v timeout expected the notice %v before the deadline, got %v", tt.timeout, tt.notice, notice)
+		}
+		if exit := deadline.Sub(e.exitAt); exit != tt.exit {
+			t.Errorf("With a %v timeout expected the exit %v before the deadline, got %v", tt.timeout, tt.exit, exit)
+		}
+		if e.poll <= 0 || e.poll > expiryPollInterval || e.poll > e.exitAt.Sub(e.noticeAt) {
+			t.Errorf("With a %v timeout expected a poll interval within the notice, got %v", tt.timeout, e.poll)
+		}
+	}
+}
+
+func TestExpiryWaitsForStreams(t *testing.T) {
+	// The notice comes 250ms before the deadline and the exit 50ms before
+	deadline := time.Now().Add(time.Second)
+	e := expiryAt(deadline, time.Now())
+	release := e.track()
+	go e.run(context.Background())
+
+	if e.expiring() {
+		t.Fatal("Expected streams to be taken before the notice")
+	}
+	<-e.notice
+	if !e.expiring() {
+		t.Fatal("Expected streams to be refused after the notice")
+	}
+
+	// The session exits once its last stream finishes, before the exit margin
+	release()
+	select {
+	case <-e.done():
+		if time.Now().After(e.exitAt) {
+			t.Errorf("Expected to exit before %v once the streams finished", e.exitAt)
+		}
+	case <-time.After(time.Until(deadline)):
+		t.Fatal("Expected to exit before the deadline")
+	}
+}
+
+func TestExpiryExitsWithStreamsOpen(t *testing.T) {
+	deadline := time.Now().Add(500 * time.Millisecond)
+	e := expiryAt(deadline, time.Now())
+	defer e.track()()
+	go e.run(context.Background())
+
+	select {
+	case <-e.done():
+		if now := time.Now(); now.Before(e.exitAt) || now.After(deadline) {
+			t.Errorf("Expected to exit between %v and %v, exited at %v", e.exitAt, deadline, now)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected to exit before the deadline with a stream open")
+	}
+}
+
+func TestExpiryNotify(t *testing.T) {
+	deadline := time.Now().Add(time.Second)
+	e := expiryAt(deadline, time.Now())
+	go e.run(context.Background())
+
+	// OpExpiring is written at the notice with the time left
+	var buf bytes.Buffer
+	e.notify(context.Background(), func(write func(io.Writer) error) error { return write(&buf) })
+	opcode, _, err := shared.ReadControlMessage(&buf)
+	if err != nil || opcode != shared.OpExpiring {
+		t.Fatalf("Expected OpExpiring, got 0x%02x (%v)", opcode, err)
+	}
+	remaining, err := shared.ReadExpiring(&buf)
+	if err != nil || remaining <= 0 || remaining > deadline.Sub(e.noticeAt) {
+		t.Errorf("Expected up to %v left, got %v (%v)", deadline.Sub(e.noticeAt), remaining, err)
+	}
+}
+
+func TestNilExpiry(t *testing.T) {
+	e := newSessionExpiry(context.Background())
+	if e != nil {
+		t.Fatal("Expected no expiry without a deadline")
+	}
+	e.run(context.Background())
+	e.notify(context.Background(), func(func(io.Writer) error) error {
+		t.Fatal("Expected no notice without a deadline")
+		return nil
+	})
+	e.track()()
+	if e.expiring() || e.done() != nil {
+		t.Error("Expected a session without a deadline to never expire")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// logQueueSize bounds the log lines waiting to be forwarded; past it lines
+// are dropped and counted rather than slowing the Lambda down
+const logQueueSize = 256
+
+// forwardedLine is a log line waiting to be sent to the orchestrator
+type forwardedLine struct {
+	level slog.Level
+	text  string
+}
+
+// logForwarder is a slog.Handler that queues the Lambda's log records for
+// the orchestrator while a session asks for them. It sees every record the
+// logger handles, and ignores them when no session is forwarding.
+type logForwarder struct {
+	mu      sync.Mutex
+	queue   chan forwardedLine // nil while no session is forwarding
+	level   slog.Level
+	dropped atomic.Uint64
+}
+
+// forwarder is installed in the logger once, at init
+var forwarder = &logForwarder{}
+
+func (f *logForwarder) Enabled(_ context.Context, level slog.Level) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.queue != nil && level >= f.level
+}
+
+func (f *logForwarder) Handle(_ context.Context, r slog.Record) error {
+	var text strings.Builder
+	text.WriteString(r.Message)
+	r.Attrs(func(attr slog.Attr) bool {
+		// The message already holds these
+		if attr.Key != "formatted_message" && attr.Key != "timestamp" {
+			fmt.Fprintf(&text, " %s=%v", attr.Key, attr.Value)
+		}
+		return true
+	})
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.queue == nil {
+		return nil
+	}
+	select {
+	case f.queue <- forwardedLine{level: r.Level, text: text.String()}:
+	default:
+		f.dropped.Add(1)
+	}
+	return nil
+}
+
+// WithAttrs drops the logger's fixed attributes, such as the service name,
+// which say nothing the orchestrator doesn't know
+func (f *logForwarder) WithAttrs([]slog.Attr) slog.Handler { return f }
+
+func (f *logForwarder) WithGroup(string) slog.Handler { return f }
+
+// start forwards log lines at settings' level and above through write until
+// ctx is done or a write fails
+func (f *logForwarder) start(ctx context.Context, settings shared.LogForwarding, write func(func(io.Writer) error) error) {
+	level, _ := shared.ParseLogLevel(settings.Level)
+	queue := make(chan forwardedLine, logQueueSize)
+	f.mu.Lock()
+	f.queue, f.level = queue, level
+	f.mu.Unlock()
+	f.dropped.Store(0)
+
+	go func() {
+		defer f.stop(queue)
+		limiter := shared.NewRateLimiter(int64(settings.LinesPerSecond()))
+		for {
+			var line forwardedLine
+			select {
+			case <-ctx.Done():
+				return
+			case line = <-queue:
+			}
+			if limiter.WaitN(ctx, 1) != nil {
+				return
+			}
+			if dropped := f.dropped.Swap(0); dropped > 0 {
+				line.text = fmt.Sprintf("(%d log lines dropped) %s", dropped, line.text)
+			}
+			err := write(func(w io.Writer) error {
+				return shared.WriteLogLine(w, line.level, line.text)
+			})
+			if err != nil {
+				return
+			}
+		}
+	}()
+	shared.LogInfof("Forwarding %s and higher log lines to the orchestrator (%d/s)", settings.Level, settings.LinesPerSecond())
+}
+
+// stop ends forwarding through queue, unless a later session replaced it
+func (f *logForwarder) stop(queue chan forwardedLine) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.queue == queue {
+		f.queue = nil
+	}
+}
@@ -2,22 +2,28 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/quic-go/quic-go"
 	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
 )
 
 
-var s3Client *s3.S3
+var s3Client *s3.Client
 
 func init() {
 	// Initialize structured logging for Lambda
@@ -26,15 +32,33 @@ func init() {
 		Format:      "json", // JSON format for Lambda logs
 		AddSource:   true,
 		ServiceName: "lambda-nat-proxy",
+		Forward:     forwarder, // idle until a session asks for the Lambda's log lines
 	})
 	// S3 client will be initialized lazily in getS3Client()
 }
 
+// region returns the region the function runs in, whose S3 endpoint the
+// coordination bucket is reached through, including in the GovCloud and
+// China partitions
+func region() string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return shared.DefaultAWSRegion
+}
+
+// memorySize returns the memory size in MB this version of the function was
+// configured with, or 0 if Lambda didn't say
+func memorySize() int {
+	size, _ := strconv.Atoi(os.Getenv("AWS_LAMBDA_FUNCTION_MEMORY_SIZE"))
+	return size
+}
+
 // getS3Client returns the S3 client, initializing it if necessary
-func getS3Client() (*s3.S3, error) {
+func getS3Client(ctx context.Context) (*s3.Client, error) {
 	if s3Client == nil {
 		var err error
-		s3Client, err = shared.CreateS3Client(shared.DefaultAWSRegion)
+		s3Client, err = shared.CreateS3Client(ctx, region())
 		if err != nil {
 			shared.LogError("Failed to create S3 client", err)
 			return nil, fmt.Errorf("failed to initialize S3 client: %w", err)
@@ -43,7 +67,40 @@ func getS3Client() (*s3.S3, error) {
 	return s3Client, nil
 }
 
-func LambdaHandler(ctx context.Context, s3Event events.S3Event) error {
+// LambdaHandler handles the S3 notifications and direct invocations of S3
+// coordination, the requests of function URL coordination, and scheduled
+// warm-up pings
+func LambdaHandler(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	var event struct {
+		Records []json.RawMessage `json:"Records"`
+		RawPath string            `json:"rawPath"`
+		Warmup  bool              `json:"warmup"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("failed to parse event: %w", err)
+	}
+	// Anything without coordination data, such as shared.WarmupPayload from
+	// the warm-up schedule, only keeps this execution environment warm
+	if event.Warmup || (event.Records == nil && event.RawPath == "") {
+		shared.LogTargetf("Warm-up invocation, no session to start")
+		return nil, nil
+	}
+	if event.Records == nil {
+		var request events.LambdaFunctionURLRequest
+		if err := json.Unmarshal(payload, &request); err != nil {
+			return nil, fmt.Errorf("failed to parse function URL request: %w", err)
+		}
+		return handleFunctionURLRequest(ctx, request), nil
+	}
+	
+	var s3Event events.S3Event
+	if err := json.Unmarshal(payload, &s3Event); err != nil {
+		return nil, fmt.Errorf("failed to parse S3 event: %w", err)
+	}
+	return nil, handleS3Event(ctx, s3Event)
+}
+
+func handleS3Event(ctx context.Context, s3Event events.S3Event) error {
 	shared.LogTargetf("Lambda triggered with %d S3 events", len(s3Event.Records))
 	
 	// Create a channel to signal when we're done
@@ -57,6 +114,10 @@ func LambdaHandler(ctx context.Context, s3Event events.S3Event) error {
 	// Wait for completion or context cancellation
 	select {
 	case err := <-done:
+		if errors.Is(err, errSessionStopped) {
+			// Not a failure, so S3 doesn't retry the invocation
+			return nil
+		}
 		return err
 	case <-ctx.Done():
 		return ctx.Err()
@@ -65,7 +126,7 @@ func LambdaHandler(ctx context.Context, s3Event events.S3Event) error {
 
 func handleHolePunchRequest(ctx context.Context, record events.S3EventRecord, done chan<- error) {
 	// 1. Get S3 client
-	client, err := getS3Client()
+	client, err := getS3Client(ctx)
 	if err != nil {
 		shared.LogError("Failed to get S3 client", err)
 		done <- fmt.Errorf("S3 client initialization failed: %w", err)
@@ -73,18 +134,171 @@ func handleHolePunchRequest(ctx context.Context, record events.S3EventRecord, do
 	}
 	
 	// 2. Read coordination data from S3
-	coord, err := shared.GetCoordinationData(client, record.S3.Bucket.Name, record.S3.Object.Key)
+	coord, err := shared.GetCoordinationData(ctx, client, record.S3.Bucket.Name, record.S3.Object.Key)
 	if err != nil {
 		shared.LogError("Failed to read coordination data from S3", err)
 		done <- fmt.Errorf("failed to read coordination data: %w", err)
 		return
 	}
 	
-	shared.LogSuccessf("Target orchestrator: %s:%d", coord.LaptopPublicIP, coord.LaptopPublicPort)
+	// A session launched for another mode's memory is answered by that
+	// mode's alias, which the orchestrator invokes itself
+	if coord.Settings != nil && coord.Settings.MemoryMB != 0 && coord.Settings.MemoryMB != memorySize() {
+		shared.LogInfof("Session %s wants %dMB, leaving it to the alias (this version has %dMB)", coord.SessionID, coord.Settings.MemoryMB, memorySize())
+		done <- nil
+		return
+	}
+	
+	// With session credentials the execution role can no longer write
+	// responses, and the session's own credentials can't touch any other
+	if coord.Credentials != nil {
+		client, err = shared.CreateSessionS3Client(ctx, region(), coord.Credentials)
+		if err != nil {
+			shared.LogError("Failed to create session S3 client", err)
+			done <- fmt.Errorf("session S3 client initialization failed: %w", err)
+			return
+		}
+		shared.LogInfof("Answering session %s with its scoped credentials", coord.SessionID)
+	}
+	
+	// The orchestrator invokes us directly when the S3 notification is late, so
+	// whichever trigger arrives second finds the session already answered
+	trigger, triggerDelay := triggerOf(record)
+	if shared.LambdaResponseExists(ctx, client, record.S3.Bucket.Name, coord.SessionID) {
+		shared.LogInfof("Session %s already answered, ignoring %s trigger after %v", coord.SessionID, trigger, triggerDelay)
+		done <- nil
+		return
+	}
+	
+	// A proxy started after the orchestrator crashed stops the session
+	// through its response object
+	sessionCtx, stop := context.WithCancelCause(ctx)
+	defer stop(nil)
+	
+	respond := func(ctx context.Context, response shared.LambdaResponse) error {
+		_, s3Span := shared.StartSpan(ctx, "s3.write_response")
+		defer s3Span.End()
+		var storage *shared.ObjectStorage
+		if coord.Settings != nil {
+			storage = coord.Settings.Storage
+		}
+		err := shared.PutLambdaResponse(ctx, client, record.S3.Bucket.Name, coord.SessionID, response, coord.IntegrityKey, storage)
+		s3Span.RecordError(err)
+		if err != nil {
+			return fmt.Errorf("failed to write response to S3: %w", err)
+		}
+		shared.LogSuccess("Lambda response written to S3")
+		go watchForStop(sessionCtx, stop, client, record.S3.Bucket.Name, coord.SessionID, coord.IntegrityKey)
+		return nil
+	}
+	runSession(sessionCtx, coord, trigger, triggerDelay, respond, done)
+}
+
+// handleFunctionURLRequest starts the session POSTed to the function URL.
+// The streamed response carries the Lambda's endpoint as a JSON line and
+// stays open until the session ends, since the invocation ends with it.
+func handleFunctionURLRequest(ctx context.Context, request events.LambdaFunctionURLRequest) *events.LambdaFunctionURLStreamingResponse {
+	body := []byte(request.Body)
+	if request.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(request.Body)
+		if err != nil {
+			return functionURLError(http.StatusBadRequest, "request body is not valid base64")
+		}
+		body = decoded
+	}
+	if request.RequestContext.HTTP.Method != http.MethodPost {
+		return functionURLError(http.StatusBadRequest, "expected coordination data in a POST body")
+	}
+	coord, err := shared.UnmarshalCoordination(body)
+	if err != nil {
+		return functionURLError(http.StatusBadRequest, err.Error())
+	}
+	if coord.SessionID == "" {
+		return functionURLError(http.StatusBadRequest, "expected coordination data in a POST body")
+	}
+	
+	var triggerDelay time.Duration
+	if request.RequestContext.TimeEpoch > 0 {
+		triggerDelay = time.Since(time.UnixMilli(request.RequestContext.TimeEpoch))
+		if triggerDelay < 0 {
+			triggerDelay = 0
+		}
+	}
+	
+	reader, writer := io.Pipe()
+	go func() {
+		done := make(chan error, 1)
+		respond := func(_ context.Context, response shared.LambdaResponse) error {
+			data, err := shared.MarshalResponse(response, coord.IntegrityKey)
+			if err != nil {
+				return fmt.Errorf("failed to encode response: %w", err)
+			}
+			if _, err := writer.Write(append(data, '\n')); err != nil {
+				return fmt.Errorf("failed to stream response: %w", err)
+			}
+			shared.LogSuccess("Lambda response streamed to the function URL")
+			return nil
+		}
+		runSession(ctx, coord, shared.TriggerFunctionURL, triggerDelay, respond, done)
+		
+		select {
+		case err := <-done:
+			writer.CloseWithError(err)
+		case <-ctx.Done():
+			writer.CloseWithError(ctx.Err())
+		}
+	}()
+	
+	return &events.LambdaFunctionURLStreamingResponse{
+		StatusCode: http.StatusOK,
+		Headers:    map[string]string{"Content-Type": "application/x-ndjson"},
+		Body:       reader,
+	}
+}
+
+// functionURLError answers a function URL request that can't start a session
+func functionURLError(status int, message string) *events.LambdaFunctionURLStreamingResponse {
+	shared.LogErrorf("Rejected function URL request: %s", message)
+	return &events.LambdaFunctionURLStreamingResponse{
+		StatusCode: status,
+		Headers:    map[string]string{"Content-Type": "text/plain"},
+		Body:       strings.NewReader(message + "\n"),
+	}
+}
+
+// runSession sets up the session described by coord: it discovers the
+// Lambda's endpoint, tells the orchestrator through respond, punches through
+// to it and connects. A coordination with no orchestrator endpoint but a
+// relay is a relayed session, which meets the orchestrator at the relay
+// instead of punching. done receives the outcome.
+func runSession(ctx context.Context, coord *shared.CoordinationData, trigger string, triggerDelay time.Duration, respond func(context.Context, shared.LambdaResponse) error, done chan<- error) {
+	shared.SetLogSession(coord.SessionID)
+	defer shared.SetLogSession("")
+	relayed := coord.LaptopPublicPort == 0 && coord.Settings != nil && coord.Settings.Relay != nil
+	if relayed {
+		shared.LogSuccessf("Target orchestrator: through the relay at %s", coord.Settings.Relay.URL)
+	} else {
+		shared.LogSuccessf("Target orchestrator: %s:%d", coord.LaptopPublicIP, coord.LaptopPublicPort)
+	}
+	shared.LogInfof("Triggered by %s %v after the coordination was sent", trigger, triggerDelay)
+	
+	// Join the orchestrator's launch trace if it asked for Lambda spans
+	ctx, stopTracing := startTracing(ctx, coord)
+	defer stopTracing()
+	
+	// Publish CloudWatch metrics for this session if the orchestrator asked for them
+	recorder, stopMetrics := startMetrics(coord)
+	defer stopMetrics()
+	setupCtx, setupSpan := shared.StartSpan(ctx, "lambda.setup", shared.Attr("session.id", coord.SessionID))
+	defer setupSpan.End()
 	
 	// 3. Discover our public IP
+	_, ipSpan := shared.StartSpan(setupCtx, "lambda.discover_ip")
 	lambdaPublicIP, err := shared.DiscoverPublicIPHTTP()
+	ipSpan.RecordError(err)
+	ipSpan.End()
 	if err != nil {
+		setupSpan.RecordError(err)
 		shared.LogError("Failed to discover public IP", err)
 		done <- fmt.Errorf("failed to discover public IP: %w", err)
 		return
@@ -92,29 +306,60 @@ func handleHolePunchRequest(ctx context.Context, record events.S3EventRecord, do
 	shared.LogSuccessf("Lambda public IP: %s", lambdaPublicIP)
 	
 	// 4. Create UDP socket (will be used for hole punching)
-	udpConn, lambdaPort, err := shared.CreateUDPSocket()
-	if err != nil {
-		shared.LogError("Failed to create UDP socket", err)
-		done <- fmt.Errorf("failed to create UDP socket: %w", err)
-		return
+	var udpConn *net.UDPConn
+	var lambdaPort int
+	if !relayed {
+		udpConn, lambdaPort, err = shared.CreateUDPSocket()
+		if err != nil {
+			shared.LogError("Failed to create UDP socket", err)
+			done <- fmt.Errorf("failed to create UDP socket: %w", err)
+			return
+		}
+		shared.LogSuccessf("UDP socket created on port %d", lambdaPort)
 	}
-	shared.LogSuccessf("UDP socket created on port %d", lambdaPort)
 	
-	// 5. Write Lambda's response to S3
+	// 5. Send the Lambda's response to the orchestrator
 	response := shared.LambdaResponse{
 		SessionID:        coord.SessionID,
 		LambdaPublicIP:   lambdaPublicIP,
 		LambdaPublicPort: lambdaPort,
 		Status:           "ready",
 		Timestamp:        time.Now().Unix(),
+		Trigger:          trigger,
+		TriggerDelayMs:   triggerDelay.Milliseconds(),
 	}
 	
-	if err := shared.PutLambdaResponse(client, record.S3.Bucket.Name, coord.SessionID, response); err != nil {
-		shared.LogError("Failed to write response to S3", err)
-		done <- fmt.Errorf("failed to write response to S3: %w", err)
+	if err := respond(setupCtx, response); err != nil {
+		setupSpan.RecordError(err)
+		shared.LogError("Failed to send response", err)
+		if udpConn != nil {
+			udpConn.Close()
+		}
+		done <- err
+		return
+	}
+	
+	if relayed {
+		relayCtx, cancel := context.WithTimeout(setupCtx, shared.RelayPairTimeout)
+		_, relaySpan := shared.StartSpan(relayCtx, "relay.dial", shared.Attr("relay.url", coord.Settings.Relay.URL))
+		relayConn, err := shared.DialRelay(relayCtx, *coord.Settings.Relay, shared.RelayRoleLambda, coord.SessionID)
+		cancel()
+		relaySpan.RecordError(err)
+		relaySpan.End()
+		if err != nil {
+			setupSpan.RecordError(err)
+			shared.LogError("Failed to meet the orchestrator at the relay", err)
+			done <- fmt.Errorf("failed to meet the orchestrator at the relay: %w", err)
+			return
+		}
+		defer relayConn.Close()
+		shared.LogSuccess("Paired with the orchestrator at the relay")
+		setupSpan.End()
+		
+		shared.LogNetwork("Connecting to orchestrator QUIC server through the relay...")
+		dialOrchestrator(ctx, shared.NewRelayPacketConn(relayConn), relayConn.RemoteAddr(), coord.Settings, recorder, done)
 		return
 	}
-	shared.LogSuccess("Lambda response written to S3")
 	
 	// 6. Perform NAT hole punching
 	orchestratorAddr := &net.UDPAddr{
@@ -122,28 +367,46 @@ func handleHolePunchRequest(ctx context.Context, record events.S3EventRecord, do
 		Port: coord.LaptopPublicPort,
 	}
 	
-	if !performNATPunch(udpConn, coord.SessionID, orchestratorAddr) {
+	_, punchSpan := shared.StartSpan(setupCtx, "nat.hole_punch", shared.Attr("orchestrator.addr", orchestratorAddr.String()))
+	punched := performNATPunch(udpConn, coord.SessionID, orchestratorAddr)
+	punchSpan.End()
+	if !punched {
+		setupSpan.RecordError(fmt.Errorf("NAT hole punching failed"))
 		shared.LogError("NAT hole punching failed", nil)
 		udpConn.Close()
 		done <- fmt.Errorf("NAT hole punching failed")
 		return
 	}
 	shared.LogSuccess("NAT hole punched successfully!")
+	setupSpan.End()
 	
 	// 7. Connect to orchestrator's QUIC server
 	shared.LogNetwork("Connecting to orchestrator QUIC server...")
-	startQUICClient(ctx, coord.LaptopPublicIP, coord.LaptopPublicPort, lambdaPort, udpConn, done)
+	startQUICClient(ctx, coord.LaptopPublicIP, coord.LaptopPublicPort, lambdaPort, udpConn, coord.Settings, recorder, done)
+}
+
+// triggerOf returns how the Lambda was started for record and how long after
+// the coordination write. Both times come from AWS clocks for S3 notifications
+// and from the orchestrator's clock for direct invocations.
+func triggerOf(record events.S3EventRecord) (string, time.Duration) {
+	trigger := shared.TriggerS3
+	if record.EventSource == shared.DirectInvokeEventSource {
+		trigger = shared.TriggerInvoke
+	}
+	if record.EventTime.IsZero() {
+		return trigger, 0
+	}
+	delay := time.Since(record.EventTime)
+	if delay < 0 {
+		delay = 0
+	}
+	return trigger, delay
 }
 
-func startQUICClient(ctx context.Context, orchestratorIP string, orchestratorPort int, localPort int, udpConn *net.UDPConn, done chan<- error) {
+func startQUICClient(ctx context.Context, orchestratorIP string, orchestratorPort int, localPort int, udpConn *net.UDPConn, settings *shared.SessionSettings, recorder *shared.EMFRecorder, done chan<- error) {
 	// Connect to orchestrator's QUIC server using the same local port
 	remoteAddr := fmt.Sprintf("%s:%d", orchestratorIP, orchestratorPort)
 	
-	tlsConfig := &tls.Config{
-		InsecureSkipVerify: true,
-		NextProtos:         []string{"h3"},
-	}
-	
 	// Get local address for port reuse
 	localAddr := udpConn.LocalAddr().(*net.UDPAddr)
 	
@@ -168,6 +431,14 @@ func startQUICClient(ctx context.Context, orchestratorIP string, orchestratorPor
 		return
 	}
 	
+	dialOrchestrator(ctx, udpDialConn, remoteUDPAddr, settings, recorder, done)
+}
+
+// dialOrchestrator connects to the orchestrator's QUIC server at remoteAddr
+// over conn, the punched socket or the relay, and serves the session
+func dialOrchestrator(ctx context.Context, conn net.PacketConn, remoteAddr net.Addr, settings *shared.SessionSettings, recorder *shared.EMFRecorder, done chan<- error) {
+	tlsConfig := shared.ClientTLSConfig()
+	
 	// Create high-performance QUIC configuration (same as server)
 	quicConfig := &quic.Config{
 		// Flow control optimization for streaming
@@ -187,11 +458,33 @@ func startQUICClient(ctx context.Context, orchestratorIP string, orchestratorPor
 		
 		// Enable connection migration for better reliability
 		DisablePathMTUDiscovery: false,
-		EnableDatagrams:         false, // Focus on stream performance
+		EnableDatagrams:         true, // Only used if the orchestrator also enables datagrams
+	}
+	
+	// Match the orchestrator's transport tuning
+	if settings != nil && settings.QUIC != nil {
+		if err := settings.QUIC.Apply(quicConfig); err != nil {
+			shared.LogErrorf("Ignoring QUIC tuning from orchestrator: %v", err)
+		}
+	}
+	
+	// Enforce the guard and the orchestrator's destination rules and DNS settings; refuse the session if they don't parse
+	dialer, err := newSessionDialer(settings)
+	if err != nil {
+		shared.LogError("Invalid session settings from orchestrator", err)
+		done <- err
+		return
 	}
+	dialer.metrics = recorder
 
-	// Connect to orchestrator's QUIC server with optimized config
-	quicConn, err := quic.Dial(ctx, udpDialConn, remoteUDPAddr, tlsConfig, quicConfig)
+	// Connect to orchestrator's QUIC server with optimized config. The
+	// transport lets extra connections share the punched socket.
+	transport := &quic.Transport{Conn: conn}
+	defer transport.Close()
+	_, dialSpan := shared.StartSpan(ctx, "quic.dial", shared.Attr("orchestrator.addr", remoteAddr.String()))
+	quicConn, err := transport.Dial(ctx, remoteAddr, tlsConfig, quicConfig)
+	dialSpan.RecordError(err)
+	dialSpan.End()
 	if err != nil {
 		shared.LogError("Failed to connect to orchestrator", err)
 		done <- err
@@ -200,13 +493,48 @@ func startQUICClient(ctx context.Context, orchestratorIP string, orchestratorPor
 	defer quicConn.CloseWithError(0, "done")
 	
 	shared.LogSuccess("Connected to orchestrator QUIC server!")
+	crypto := shared.DescribeTunnelCrypto(quicConn.ConnectionState())
+	shared.LogNetworkf("Tunnel encryption: %s", crypto)
+	for _, warning := range crypto.Warnings {
+		shared.LogErrorf("Weak tunnel encryption: %s", warning)
+	}
+	
+	// Open the extra connections the orchestrator asked for, striping its
+	// streams across them
+	openStripes := func(ctx context.Context) {
+		if settings == nil {
+			return
+		}
+		for i := 0; i < settings.QUIC.Stripes(); i++ {
+			stripe, err := transport.Dial(ctx, remoteAddr, tlsConfig, quicConfig)
+			if err != nil {
+				shared.LogErrorf("Failed to open extra QUIC connection %d: %v", i+1, err)
+				return
+			}
+			go handleStripe(ctx, stripe, dialer)
+		}
+	}
 	
 	// Handle QUIC connection streams
-	handleQUICConnection(ctx, quicConn, done)
+	handleQUICConnection(ctx, quicConn, dialer, openStripes, done)
 }
 
+// handleStripe serves tunnel streams opened on an extra QUIC connection
+// until it or ctx closes
+func handleStripe(ctx context.Context, conn quic.Connection, dialer *sessionDialer) {
+	defer conn.CloseWithError(0, "done")
+	shared.LogNetworkf("Extra QUIC connection from %s ready", conn.LocalAddr())
+	for {
+		stream, err := conn.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+		go handleSOCKS5Stream(stream, dialer, nil)
+	}
+}
 
-func handleQUICConnection(ctx context.Context, conn quic.Connection, done chan<- error) {
+
+func handleQUICConnection(ctx context.Context, conn quic.Connection, dialer *sessionDialer, openStripes func(context.Context), done chan<- error) {
 	defer conn.CloseWithError(0, "done")
 	
 	// Accept the first stream as control stream
@@ -217,13 +545,17 @@ func handleQUICConnection(ctx context.Context, conn quic.Connection, done chan<-
 		return
 	}
 	
+	// Stop taking streams and exit before the function times out
+	dialer.expiry = newSessionExpiry(ctx)
+	
 	// Handle control stream in background
 	controlDone := make(chan error, 1)
-	go handleControlStream(controlStream, controlDone)
+	go handleControlStream(controlStream, dialer, controlDone)
 	
 	// Create a context that cancels when we need to exit
 	exitCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	go dialer.expiry.run(exitCtx)
 	
 	// Monitor for connection loss or control stream error
 	go func() {
@@ -236,13 +568,25 @@ func handleQUICConnection(ctx context.Context, conn quic.Connection, done chan<-
 			shared.LogNetwork("Control stream closed, exiting")
 			cancel()
 			done <- err
+		case <-dialer.expiry.done():
+			cancel()
+			done <- nil
 		case <-ctx.Done():
 			shared.LogNetwork("Lambda context cancelled, exiting")
 			cancel()
-			done <- ctx.Err()
+			done <- context.Cause(ctx)
 		}
 	}()
 	
+	// The orchestrator waits for extra connections once it has opened the control stream
+	go openStripes(exitCtx)
+	
+	// Relay UDP datagrams if the orchestrator negotiated them
+	if conn.ConnectionState().SupportsDatagrams {
+		shared.LogNetwork("QUIC datagrams enabled for UDP relay")
+		go handleDatagrams(exitCtx, conn, dialer)
+	}
+	
 	// Accept subsequent streams for SOCKS5
 	for {
 		stream, err := conn.AcceptStream(exitCtx)
@@ -257,14 +601,24 @@ func handleQUICConnection(ctx context.Context, conn quic.Connection, done chan<-
 			return
 		}
 		
-		go handleSOCKS5Stream(stream)
+		go handleSOCKS5Stream(stream, dialer, &dialer.bytes)
 	}
 }
 
-func handleControlStream(stream quic.Stream, done chan<- error) {
+func handleControlStream(stream quic.Stream, dialer *sessionDialer, done chan<- error) {
 	defer stream.Close()
 	shared.LogNetwork("Control stream established")
 	
+	// Forwarded log lines are written alongside the replies
+	var writeMu sync.Mutex
+	write := func(fn func(io.Writer) error) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return fn(stream)
+	}
+	forwardCtx, stopForwarding := context.WithCancel(context.Background())
+	defer stopForwarding()
+	
 	for {
 		opcode, nonce, err := shared.ReadControlMessage(stream)
 		if err != nil {
@@ -283,7 +637,7 @@ func handleControlStream(stream quic.Stream, done chan<- error) {
 		switch opcode {
 		case shared.OpPing:
 			// Respond with pong
-			if err := shared.WritePong(stream, nonce); err != nil {
+			if err := write(func(w io.Writer) error { return shared.WritePong(w, nonce) }); err != nil {
 				shared.LogError("Failed to send pong", err)
 				return
 			}
@@ -293,47 +647,180 @@ func handleControlStream(stream quic.Stream, done chan<- error) {
 			done <- nil
 			return
 			
+		case shared.OpFlushDNS:
+			dialer.flushDNS()
+			
+		case shared.OpByteReport:
+			if err := write(func(w io.Writer) error { return shared.WriteByteCounts(w, dialer.bytes.drain()) }); err != nil {
+				shared.LogError("Failed to send byte counts", err)
+				done <- err
+				return
+			}
+			
+		case shared.OpHello:
+			// The orchestrator closes the session if the versions don't
+			// overlap, and explains which side to update
+			hello, err := shared.ReadHello(stream)
+			if err != nil {
+				shared.LogError("Failed to read hello", err)
+				done <- err
+				return
+			}
+			if err := write(func(w io.Writer) error { return shared.WriteHello(w, shared.LocalHello()) }); err != nil {
+				shared.LogError("Failed to send hello", err)
+				done <- err
+				return
+			}
+			if err := shared.CheckHello(shared.LocalHello(), hello); err != nil {
+				shared.LogErrorf("Orchestrator protocol mismatch: %v", err)
+			} else {
+				shared.LogNetworkf("Orchestrator speaks protocol version %d", hello.Version)
+			}
+			
+			// Log lines may follow only once the orchestrator has read the hello
+			if dialer.logs != nil && hello.Supports(shared.CapLogForwarding) {
+				forwarder.start(forwardCtx, *dialer.logs, write)
+			}
+			if hello.Supports(shared.CapExpiring) {
+				go dialer.expiry.notify(forwardCtx, write)
+			}
+			
 		default:
 			shared.LogErrorf("Unknown control opcode: %02x", opcode)
 		}
 	}
 }
 
-func handleSOCKS5Stream(stream quic.Stream) {
+func handleSOCKS5Stream(stream quic.Stream, dialer *sessionDialer, ledger *byteLedger) {
 	defer stream.Close()
+	defer dialer.expiry.track()()
 	
-	// Read target address using shared utility
-	target, err := shared.ReadSOCKS5TargetAddress(stream)
+	// Read the stream frame, or the legacy target string of an older orchestrator
+	frame, err := shared.ReadStreamHeader(stream)
 	if err != nil {
-		shared.LogError("Failed to read target address", err)
+		shared.LogError("Failed to read stream header", err)
 		shared.WriteSOCKS5Response(stream, shared.SOCKS5ResponseError)
 		return
 	}
 	
-	shared.LogTargetf("Connecting to target: %s", target)
+	// Spans for this stream join the trace of the orchestrator's connection
+	ctx := shared.ContextWithTraceparent(context.Background(), frame.Traceparent)
+	target := frame.Target()
+	if dialer.expiry.expiring() {
+		shared.LogTargetf("Refusing stream to %s: the function is about to time out", target)
+		refuseStream(stream, frame, shared.StreamReply{Code: shared.SOCKS5ResponseError, Reason: "the Lambda is about to time out"})
+		return
+	}
+	dialer.metrics.Add(shared.MetricStreamsHandled, 1)
 	
-	// Connect to target
-	targetConn, err := shared.ConnectToTarget(target, shared.DefaultConnectionTimeout)
+	switch frame.Command {
+	case shared.StreamConnect:
+	case shared.StreamDNS:
+		handleDNSStream(stream, dialer)
+		return
+	case shared.StreamUDP:
+		handleUDPStream(stream, frame, dialer)
+		return
+	case shared.StreamEcho, shared.StreamDiscard, shared.StreamSource:
+		handleThroughputStream(stream, frame.Command)
+		return
+	default:
+		shared.LogErrorf("Unsupported stream command %d for %s", frame.Command, target)
+		refuseStream(stream, frame, shared.StreamReply{Code: shared.SOCKS5ResponseError, Class: shared.ErrorClassUnsupported, Reason: fmt.Sprintf("unsupported stream command %d", frame.Command)})
+		return
+	}
+	
+	if frame.PinnedIP != "" {
+		shared.LogTargetf("Connecting to target: %s at pinned address %s", target, frame.PinnedIP)
+	} else {
+		shared.LogTargetf("Connecting to target: %s", target)
+	}
+	ctx, span := shared.StartSpan(ctx, "lambda.stream", shared.Attr("target", target))
+	defer span.End()
+	
+	// Connect to target, checking it and every address it resolves to against the ACL
+	_, dialSpan := shared.StartSpan(ctx, "lambda.dial", shared.Attr("target", target))
+	dialStart := time.Now()
+	conn, err := dialer.dialTCP(frame)
+	dialSpan.RecordError(err)
+	dialSpan.End()
+	span.RecordError(err)
+	if recordDenial(err) {
+		dialer.metrics.Add(shared.MetricDialsDenied, 1)
+		refuseStream(stream, frame, shared.FailedStreamReply(err))
+		return
+	}
 	if err != nil {
+		dialer.metrics.Add(shared.MetricDialFailures, 1)
 		shared.LogErrorf("Failed to connect to target %s: %v", target, err)
-		shared.WriteSOCKS5Response(stream, shared.SOCKS5ResponseError)
+		refuseStream(stream, frame, shared.FailedStreamReply(err))
 		return
 	}
+	dialer.metrics.Observe(shared.MetricDialLatency, time.Since(dialStart))
+	targetConn := &countingConn{Conn: conn}
+	defer func() {
+		dialer.metrics.Add(shared.MetricBytesTransferred, float64(targetConn.n.Load()))
+	}()
 	defer targetConn.Close()
 	
 	// Send success response
-	if err := shared.WriteSOCKS5Response(stream, shared.SOCKS5ResponseSuccess); err != nil {
+	if err := shared.WriteStreamReply(stream, shared.StreamReply{Code: shared.SOCKS5ResponseSuccess}, frame.ReplyFrame); err != nil {
 		shared.LogError("Failed to send success response", err)
 		return
 	}
 	
 	shared.LogSuccessf("Connected to %s, starting data forwarding", target)
 	
+	// When the orchestrator's client goes away, reset the target and stop
+	// both directions at once instead of finishing what's in flight
+	watched := &peerWatchStream{Stream: stream, onCancel: func(reason string, code quic.StreamErrorCode) {
+		shared.LogClosef("Connection to %s cancelled by the orchestrator: %s", target, reason)
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.SetLinger(0)
+		}
+		targetConn.Close()
+		stream.CancelRead(code)
+		stream.CancelWrite(code)
+	}}
+	
+	// Count the stream's payload as it crosses the wire, for the orchestrator
+	// to reconcile with its own count
+	counted := &shared.CountingStream{ReadWriteCloser: watched}
+	defer ledger.add(stream.StreamID(), &counted.Counter)
+	
+	// The orchestrator only asks for compression it knows this build supports
+	var tunnel io.ReadWriteCloser = counted
+	if frame.Compression == shared.CompressionDeflate {
+		compressed := shared.NewCompressedStream(counted)
+		defer func() {
+			shared.LogInfof("Connection to %s sent %.1fx compressed", target, compressed.Ratio())
+		}()
+		tunnel = compressed
+	}
+	
+	// Close the stream once it reaches the lifetime the orchestrator asked
+	// for, in case the orchestrator can't
+	if frame.MaxLifetime > 0 {
+		timer := time.AfterFunc(frame.MaxLifetime, func() {
+			shared.LogClosef("Connection to %s open for %v, closing", target, frame.MaxLifetime)
+			targetConn.Close()
+			stream.CancelRead(0)
+			stream.CancelWrite(0)
+		})
+		defer timer.Stop()
+	}
+	
 	// Start bidirectional forwarding using shared utility
-	shared.ForwardData(stream, targetConn)
+	shared.ForwardData(tunnel, targetConn)
 	shared.LogClosef("Connection to %s closed", target)
 }
 
+// refuseStream answers frame with reply and discards anything the
+// orchestrator pipelined behind it, so the stream can finish
+func refuseStream(stream quic.Stream, frame shared.StreamFrame, reply shared.StreamReply) {
+	shared.WriteStreamReply(stream, reply, frame.ReplyFrame)
+	stream.CancelRead(0)
+}
 
 func performNATPunch(udpConn *net.UDPConn, sessionID string, orchestratorAddr *net.UDPAddr) bool {
 	err := shared.PerformNATHolePunch(udpConn, sessionID, orchestratorAddr, shared.DefaultNATHolePunchTimeout, false)
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// startMetrics creates a recorder for this session if the orchestrator asked
+// for Lambda metrics, flushing it every interval. The returned function
+// writes the last record; call it before the invocation returns, since a
+// frozen function can't log.
+func startMetrics(coord *shared.CoordinationData) (*shared.EMFRecorder, func()) {
+	if coord.Settings == nil || coord.Settings.Metrics == nil {
+		return nil, func() {}
+	}
+	cfg := *coord.Settings.Metrics
+	if err := cfg.Validate(); err != nil {
+		shared.LogErrorf("Ignoring metrics settings from orchestrator: %v", err)
+		return nil, func() {}
+	}
+	interval := cfg.Interval
+	if interval == 0 {
+		interval = shared.DefaultLambdaMetricsInterval
+	}
+
+	recorder := shared.NewEMFRecorder(cfg.Namespace,
+		map[string]string{"FunctionName": os.Getenv("AWS_LAMBDA_FUNCTION_NAME")},
+		map[string]string{"SessionId": coord.SessionID},
+		os.Stdout)
+	shared.LogInfof("Publishing CloudWatch metrics to namespace %s every %v", cfg.Namespace, interval)
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := recorder.Flush(); err != nil {
+					shared.LogErrorf("%v", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return recorder, func() {
+		close(stop)
+		<-stopped
+		if err := recorder.Flush(); err != nil {
+			shared.LogErrorf("%v", err)
+		}
+	}
+}
+
+// countingConn counts the bytes a target connection carries in both directions
+type countingConn struct {
+	net.Conn
+	n atomic.Int64
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.n.Add(int64(n))
+	return n, err
+}
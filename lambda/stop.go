@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// stopCheckInterval is how often a connected session reads its response
+// object for a shutdown notice
+const stopCheckInterval = 30 * time.Second
+
+// errSessionStopped ends a session whose orchestrator is gone: a proxy
+// started after it crashed replaced the session's response with a
+// shutdown notice
+var errSessionStopped = errors.New("session stopped by a later proxy")
+
+// watchForStop cancels the session with errSessionStopped once its response
+// object holds a shutdown notice checksummed with the session's integrity
+// key. Its tunnel can't tell the Lambda, since the orchestrator that owned
+// it is gone, so without this the session would run until the tunnel's idle
+// timeout. Errors reading the object, and notices that don't check out, are
+// ignored; the tunnel's own timeouts still apply.
+func watchForStop(ctx context.Context, stop context.CancelCauseFunc, client *s3.Client, bucket, sessionID, integrityKey string) {
+	ticker := time.NewTicker(stopCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		response, err := shared.GetLambdaResponse(ctx, client, bucket, sessionID, integrityKey)
+		if err == nil && response.Status == shared.ResponseStatusShutdown {
+			shared.LogInfof("Session %s was stopped by a later proxy, exiting", sessionID)
+			stop(errSessionStopped)
+			return
+		}
+	}
+}
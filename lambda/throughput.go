@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+	"github.com/quic-go/quic-go"
+)
+
+// sourceChunk is written repeatedly to source streams
+var sourceChunk = make([]byte, 32<<10)
+
+// handleThroughputStream serves the echo, discard and source test targets,
+// which measure the tunnel without depending on any outside server
+func handleThroughputStream(stream quic.Stream, command shared.StreamCommand) {
+	if err := shared.WriteSOCKS5Response(stream, shared.SOCKS5ResponseSuccess); err != nil {
+		shared.LogError("Failed to send success response", err)
+		return
+	}
+
+	var n int64
+	switch command {
+	case shared.StreamEcho:
+		n, _ = io.Copy(stream, stream)
+	case shared.StreamDiscard:
+		n, _ = io.Copy(io.Discard, stream)
+	case shared.StreamSource:
+		// Writes fail once the orchestrator stops reading and closes the stream
+		stream.CancelRead(0)
+		for {
+			written, err := stream.Write(sourceChunk)
+			n += int64(written)
+			if err != nil {
+				break
+			}
+		}
+	}
+	shared.LogClosef("Throughput test stream (%s) closed after %d bytes", shared.StreamFrame{Command: command}, n)
+}
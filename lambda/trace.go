@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// tracingShutdownTimeout bounds the final span export before the invocation returns
+const tracingShutdownTimeout = 2 * time.Second
+
+// startTracing installs a tracer for this invocation if the orchestrator
+// asked for Lambda spans. The returned context carries the launch's trace
+// context, and the returned function exports what is left and removes the
+// tracer; call it before the invocation returns, since a frozen function
+// can't export.
+func startTracing(ctx context.Context, coord *shared.CoordinationData) (context.Context, func()) {
+	if coord.Settings == nil || coord.Settings.Tracing == nil {
+		return ctx, func() {}
+	}
+	tracer, err := shared.NewTracer(*coord.Settings.Tracing)
+	if err != nil || tracer == nil {
+		if err != nil {
+			shared.LogErrorf("Ignoring tracing settings from orchestrator: %v", err)
+		}
+		return ctx, func() {}
+	}
+	shared.SetTracer(tracer)
+	ctx = shared.ContextWithTraceparent(ctx, coord.Traceparent)
+
+	return ctx, func() {
+		shared.SetTracer(nil)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		if err := tracer.Shutdown(shutdownCtx); err != nil {
+			shared.LogErrorf("Failed to export final spans: %v", err)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+	"github.com/quic-go/quic-go"
+)
+
+// maxDatagramFlows caps the UDP flows a session relays over datagrams at once
+const maxDatagramFlows = 256
+
+// datagramDrops counts UDP packets dropped from the datagram path in this invocation
+var datagramDrops atomic.Int64
+
+// udpFlow is a connected UDP socket serving one (flow ID, target) pair
+type udpFlow struct {
+	conn       *net.UDPConn
+	lastActive atomic.Int64 // unix nanoseconds of the last payload either way
+}
+
+func newUDPFlow(conn *net.UDPConn) *udpFlow {
+	flow := &udpFlow{conn: conn}
+	flow.touch()
+	return flow
+}
+
+// touch records traffic on the flow, keeping it from the reaper
+func (f *udpFlow) touch() {
+	f.lastActive.Store(time.Now().UnixNano())
+}
+
+// datagramFlows are a session's open UDP flows. Flows idle in both
+// directions for longer than idle are closed by reap.
+type datagramFlows struct {
+	mu    sync.Mutex
+	flows map[string]*udpFlow
+	max   int
+	idle  time.Duration
+}
+
+func newDatagramFlows(limit int, idle time.Duration) *datagramFlows {
+	return &datagramFlows{flows: make(map[string]*udpFlow), max: limit, idle: idle}
+}
+
+func (d *datagramFlows) get(key string) *udpFlow {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.flows[key]
+}
+
+// add stores flow under key, reporting false if the session has its most flows open
+func (d *datagramFlows) add(key string, flow *udpFlow) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.flows) >= d.max {
+		return false
+	}
+	d.flows[key] = flow
+	return true
+}
+
+// remove deletes flow from key, unless key has already been given to another flow
+func (d *datagramFlows) remove(key string, flow *udpFlow) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.flows[key] == flow {
+		delete(d.flows, key)
+	}
+}
+
+// reap closes and deletes the flows idle since before now minus d.idle,
+// returning how many it closed
+func (d *datagramFlows) reap(now time.Time) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	cutoff := now.Add(-d.idle).UnixNano()
+	reaped := 0
+	for key, flow := range d.flows {
+		if flow.lastActive.Load() < cutoff {
+			flow.conn.Close()
+			delete(d.flows, key)
+			reaped++
+		}
+	}
+	return reaped
+}
+
+// runReaper reaps idle flows until ctx is done
+func (d *datagramFlows) runReaper(ctx context.Context) {
+	ticker := time.NewTicker(d.idle / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if n := d.reap(now); n > 0 {
+				shared.LogClosef("Closed %d idle UDP flows", n)
+			}
+		}
+	}
+}
+
+func (d *datagramFlows) closeAll() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, flow := range d.flows {
+		flow.conn.Close()
+		delete(d.flows, key)
+	}
+}
+
+// dropDatagram logs and counts a UDP packet the datagram path couldn't carry
+func dropDatagram(format string, args ...interface{}) {
+	total := datagramDrops.Add(1)
+	shared.LogErrorf("%s (%d dropped this invocation)", fmt.Sprintf(format, args...), total)
+}
+
+// handleDatagrams relays UDP payloads carried in QUIC datagrams until the connection closes
+func handleDatagrams(ctx context.Context, conn quic.Connection, dialer *sessionDialer) {
+	flows := newDatagramFlows(maxDatagramFlows, shared.UDPRelayIdleTimeout)
+	defer flows.closeAll()
+
+	reapCtx, stopReaper := context.WithCancel(ctx)
+	defer stopReaper()
+	go flows.runReaper(reapCtx)
+
+	for {
+		data, err := conn.ReceiveDatagram(ctx)
+		if err != nil {
+			return
+		}
+
+		dgram, err := shared.DecodeUDPDatagram(data)
+		if err != nil {
+			shared.LogErrorf("Dropping invalid datagram: %v", err)
+			continue
+		}
+
+		key := fmt.Sprintf("%d/%s", dgram.FlowID, dgram.Address)
+		flow := flows.get(key)
+		if flow == nil {
+			targetConn, err := dialer.dialUDP(dgram.Address)
+			if recordDenial(err) {
+				continue
+			}
+			if err != nil {
+				shared.LogErrorf("Failed to open UDP flow to %s: %v", dgram.Address, err)
+				continue
+			}
+			flow = newUDPFlow(targetConn)
+			if !flows.add(key, flow) {
+				targetConn.Close()
+				dropDatagram("Dropping UDP payload to %s: %d flows already open", dgram.Address, maxDatagramFlows)
+				continue
+			}
+
+			go func(flowID uint32, key string, flow *udpFlow) {
+				relayDatagramResponses(conn, flowID, flow)
+				flows.remove(key, flow)
+				flow.conn.Close()
+			}(dgram.FlowID, key, flow)
+		}
+
+		flow.touch()
+		if _, err := flow.conn.Write(dgram.Payload); err != nil {
+			shared.LogErrorf("Failed to send UDP payload to %s: %v", dgram.Address, err)
+		}
+	}
+}
+
+// relayDatagramResponses sends replies from a UDP target back as datagrams
+// until the flow is closed
+func relayDatagramResponses(conn quic.Connection, flowID uint32, flow *udpFlow) {
+	buf := make([]byte, shared.MaxUDPPayload)
+	source := flow.conn.RemoteAddr().String()
+
+	for {
+		n, err := flow.conn.Read(buf)
+		if err != nil {
+			return
+		}
+		flow.touch()
+
+		encoded, err := shared.EncodeUDPDatagram(shared.UDPDatagram{
+			FlowID:  flowID,
+			Address: source,
+			Payload: buf[:n],
+		})
+		if err != nil {
+			continue
+		}
+		if len(encoded) > shared.MaxDatagramPayload {
+			// Only the orchestrator can open streams, so oversized replies cannot fall back
+			dropDatagram("Dropping %d byte UDP reply from %s: exceeds datagram size", n, source)
+			continue
+		}
+		if err := conn.SendDatagram(encoded); err != nil {
+			shared.LogErrorf("Failed to send datagram for %s: %v", source, err)
+		}
+	}
+}
+
+// handleUDPStream relays UDP payloads framed on a QUIC stream, used when
+// datagrams are disabled or a payload is too large for one
+func handleUDPStream(stream quic.Stream, frame shared.StreamFrame, dialer *sessionDialer) {
+	target := frame.Target()
+	targetConn, err := dialer.dialUDP(target)
+	if recordDenial(err) {
+		shared.WriteStreamReply(stream, shared.FailedStreamReply(err), frame.ReplyFrame)
+		return
+	}
+	if err != nil {
+		shared.LogErrorf("Failed to open UDP relay to %s: %v", target, err)
+		shared.WriteStreamReply(stream, shared.FailedStreamReply(err), frame.ReplyFrame)
+		return
+	}
+	defer targetConn.Close()
+
+	if err := shared.WriteStreamReply(stream, shared.StreamReply{Code: shared.SOCKS5ResponseSuccess}, frame.ReplyFrame); err != nil {
+		shared.LogError("Failed to send success response", err)
+		return
+	}
+
+	shared.LogSuccessf("UDP relay to %s established", target)
+
+	// stream -> target
+	go func() {
+		defer targetConn.Close()
+		for {
+			payload, err := shared.ReadUDPFrame(stream)
+			if err != nil {
+				return
+			}
+			if _, err := targetConn.Write(payload); err != nil {
+				return
+			}
+		}
+	}()
+
+	// target -> stream
+	buf := make([]byte, shared.MaxUDPPayload)
+	for {
+		targetConn.SetReadDeadline(time.Now().Add(shared.UDPRelayIdleTimeout))
+		n, err := targetConn.Read(buf)
+		if err != nil {
+			break
+		}
+		if err := shared.WriteUDPFrame(stream, buf[:n]); err != nil {
+			break
+		}
+	}
+	shared.LogClosef("UDP relay to %s closed", target)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestFlow opens a UDP flow to a local listener
+func newTestFlow(t *testing.T) *udpFlow {
+	t.Helper()
+	listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP failed: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	conn, err := net.DialUDP("udp", nil, listener.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("DialUDP failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return newUDPFlow(conn)
+}
+
+func TestDatagramFlowsLimit(t *testing.T) {
+	flows := newDatagramFlows(2, time.Minute)
+	a, b, c := newTestFlow(t), newTestFlow(t), newTestFlow(t)
+
+	if !flows.add("1/a", a) || !flows.add("2/b", b) {
+		t.Fatal("Expected flows up to the limit to be added")
+	}
+	if flows.add("3/c", c) {
+		t.Error("Expected a flow over the limit to be refused")
+	}
+
+	// A closed flow frees its slot, but only its own key
+	flows.remove("1/a", c)
+	if flows.get("1/a") != a {
+		t.Error("Expected remove to leave a key given to another flow")
+	}
+	flows.remove("1/a", a)
+	if !flows.add("3/c", c) {
+		t.Error("Expected room for a flow once another closed")
+	}
+}
+
+func TestDatagramFlowsReap(t *testing.T) {
+	flows := newDatagramFlows(10, time.Minute)
+	idle, busy := newTestFlow(t), newTestFlow(t)
+	flows.add("1/idle", idle)
+	flows.add("2/busy", busy)
+
+	later := time.Now().Add(50 * time.Second)
+	if n := flows.reap(later); n != 0 {
+		t.Fatalf("Expected no flows reaped before the idle timeout, got %d", n)
+	}
+
+	idle.lastActive.Store(time.Now().Add(-2 * time.Minute).UnixNano())
+	if n := flows.reap(time.Now()); n != 1 {
+		t.Fatalf("Expected the idle flow reaped, got %d", n)
+	}
+	if flows.get("1/idle") != nil || flows.get("2/busy") != busy {
+		t.Error("Expected only the idle flow to be deleted")
+	}
+	if _, err := idle.conn.Write([]byte("x")); err == nil {
+		t.Error("Expected the reaped flow's socket to be closed")
+	}
+
+	flows.closeAll()
+	if flows.get("2/busy") != nil {
+		t.Error("Expected closeAll to delete every flow")
+	}
+}
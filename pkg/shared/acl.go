@@ -0,0 +1,407 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ACL default policies
+const (
+	ACLAllow = "allow"
+	ACLDeny  = "deny"
+)
+
+// BlockedTargetsEnv names the Lambda environment variable overriding the
+// guard rules the Lambda applies to every session: a comma-separated list of
+// deny rules, or BlockedTargetsNone to turn the guard off
+const (
+	BlockedTargetsEnv  = "BLOCKED_TARGETS"
+	BlockedTargetsNone = "none"
+)
+
+// DefaultBlockedTargets keeps the Lambda away from its own runtime API,
+// instance metadata and other link-local services unless overridden
+var DefaultBlockedTargets = []string{"loopback", "link-local", "metadata"}
+
+// ErrACLDenied is matched by every error returned for a denied destination
+var ErrACLDenied = errors.New("destination denied by ACL")
+
+// ACLConfig lists destination rules. It travels to the Lambda inside
+// SessionSettings, so both ends enforce the same rules.
+//
+// A rule is a host part, a port part, or both ("host:port"):
+//   - host: an IP, a CIDR, an exact domain, "*.domain" (the domain and its
+//     subdomains), "*" or empty (any host), or one of the aliases "private",
+//     "loopback", "link-local" and "metadata"
+//   - port: a number or a range like "8000-8999"; omitted means any port
+//
+// IPv6 addresses with a port are written in brackets: "[fd00::1]:22".
+// Allow rules win over deny rules; destinations matching neither get Default.
+type ACLConfig struct {
+	Default string   `json:"default,omitempty"` // "allow" (default) or "deny"
+	Allow   []string `json:"allow,omitempty"`
+	Deny    []string `json:"deny,omitempty"`
+}
+
+// IsZero reports whether the config has no rules and the default policy
+func (c ACLConfig) IsZero() bool {
+	return (c.Default == "" || c.Default == ACLAllow) && len(c.Allow) == 0 && len(c.Deny) == 0
+}
+
+// aclAliases maps rule aliases to the ranges they stand for
+var aclAliases = map[string][]string{
+	"private":    {"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7"},
+	"loopback":   {"127.0.0.0/8", "::1/128"},
+	"link-local": {"169.254.0.0/16", "fe80::/10"},
+	"metadata":   {"169.254.169.254/32", "169.254.170.2/32", "fd00:ec2::254/128"},
+}
+
+// aclRule is one parsed rule
+type aclRule struct {
+	text     string
+	nets     []*net.IPNet // IP rules
+	domain   string       // exact domain rules
+	suffix   string       // "*.domain" rules, stored as ".domain"
+	portLow  int
+	portHigh int // 0 = any port
+}
+
+// ACL decides which destinations may be reached. A nil *ACL allows everything.
+type ACL struct {
+	defaultDeny bool
+	allow       []aclRule
+	deny        []aclRule
+}
+
+// ACLDeniedError reports a destination refused by an ACL
+type ACLDeniedError struct {
+	Target string
+	Rule   string // empty when refused by the default policy
+}
+
+func (e *ACLDeniedError) Error() string {
+	if e.Rule == "" {
+		return fmt.Sprintf("destination %s denied by default ACL policy", e.Target)
+	}
+	return fmt.Sprintf("destination %s denied by ACL rule %q", e.Target, e.Rule)
+}
+
+// Is makes errors.Is(err, ErrACLDenied) match
+func (e *ACLDeniedError) Is(target error) bool {
+	return target == ErrACLDenied
+}
+
+// NewACL parses cfg. It returns nil (allow everything) for an empty config.
+func NewACL(cfg ACLConfig) (*ACL, error) {
+	if cfg.IsZero() {
+		return nil, nil
+	}
+
+	acl := &ACL{}
+	switch strings.ToLower(cfg.Default) {
+	case "", ACLAllow:
+	case ACLDeny:
+		acl.defaultDeny = true
+	default:
+		return nil, fmt.Errorf("invalid ACL default %q (must be %q or %q)", cfg.Default, ACLAllow, ACLDeny)
+	}
+
+	for _, text := range cfg.Allow {
+		rule, err := parseACLRule(text)
+		if err != nil {
+			return nil, err
+		}
+		acl.allow = append(acl.allow, rule)
+	}
+	for _, text := range cfg.Deny {
+		rule, err := parseACLRule(text)
+		if err != nil {
+			return nil, err
+		}
+		acl.deny = append(acl.deny, rule)
+	}
+	return acl, nil
+}
+
+// parseACLRule parses one "host:port" rule
+func parseACLRule(text string) (aclRule, error) {
+	rule := aclRule{text: text}
+	s := strings.TrimSpace(text)
+	if s == "" {
+		return rule, fmt.Errorf("empty ACL rule")
+	}
+
+	host, port := s, ""
+	switch {
+	case strings.HasPrefix(s, "["):
+		end := strings.Index(s, "]")
+		if end < 0 {
+			return rule, fmt.Errorf("invalid ACL rule %q: missing ]", text)
+		}
+		host, port = s[1:end], strings.TrimPrefix(s[end+1:], ":")
+		if port == "" && end+1 < len(s) {
+			return rule, fmt.Errorf("invalid ACL rule %q", text)
+		}
+	case strings.Count(s, ":") == 1:
+		host, port = s[:strings.Index(s, ":")], s[strings.Index(s, ":")+1:]
+	}
+
+	if port != "" {
+		low, high, err := parseACLPorts(port)
+		if err != nil {
+			return rule, fmt.Errorf("invalid ACL rule %q: %w", text, err)
+		}
+		rule.portLow, rule.portHigh = low, high
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	switch {
+	case host == "" || host == "*":
+	case aclAliases[host] != nil:
+		for _, cidr := range aclAliases[host] {
+			_, ipNet, _ := net.ParseCIDR(cidr)
+			rule.nets = append(rule.nets, ipNet)
+		}
+	case strings.Contains(host, "/"):
+		_, ipNet, err := net.ParseCIDR(host)
+		if err != nil {
+			return rule, fmt.Errorf("invalid ACL rule %q: %w", text, err)
+		}
+		rule.nets = []*net.IPNet{ipNet}
+	case net.ParseIP(host) != nil:
+		ip := net.ParseIP(host)
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		rule.nets = []*net.IPNet{{IP: ip, Mask: net.CIDRMask(bits, bits)}}
+	case strings.HasPrefix(host, "*."):
+		rule.suffix = host[1:]
+	case strings.Contains(host, "*"):
+		return rule, fmt.Errorf("invalid ACL rule %q: wildcards are only allowed as a leading \"*.\"", text)
+	default:
+		rule.domain = host
+	}
+
+	if rule.portHigh == 0 && rule.nets == nil && rule.domain == "" && rule.suffix == "" {
+		return rule, fmt.Errorf("invalid ACL rule %q: matches every destination (use default: deny instead)", text)
+	}
+	return rule, nil
+}
+
+// parseACLPorts parses "25" or "8000-8999"
+func parseACLPorts(s string) (int, int, error) {
+	lowText, highText, isRange := strings.Cut(s, "-")
+	low, err := strconv.Atoi(lowText)
+	if err != nil || low < 1 || low > 65535 {
+		return 0, 0, fmt.Errorf("invalid port %q", s)
+	}
+	if !isRange {
+		return low, low, nil
+	}
+	high, err := strconv.Atoi(highText)
+	if err != nil || high < low || high > 65535 {
+		return 0, 0, fmt.Errorf("invalid port range %q", s)
+	}
+	return low, high, nil
+}
+
+// matches reports whether the rule covers host (an IP or a lowercase domain)
+// and port
+func (r *aclRule) matches(host string, ip net.IP, port int) bool {
+	if r.portHigh != 0 && (port < r.portLow || port > r.portHigh) {
+		return false
+	}
+	switch {
+	case r.nets != nil:
+		if ip == nil {
+			return false
+		}
+		for _, ipNet := range r.nets {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	case r.domain != "":
+		return ip == nil && host == r.domain
+	case r.suffix != "":
+		return ip == nil && (host == r.suffix[1:] || strings.HasSuffix(host, r.suffix))
+	}
+	return true // port-only rule
+}
+
+// Check returns an *ACLDeniedError if target ("host:port") may not be reached
+func (a *ACL) Check(target string) error {
+	if a == nil {
+		return nil
+	}
+	host, ip, port, err := splitACLTarget(target)
+	if err != nil {
+		return err
+	}
+
+	for i := range a.allow {
+		if a.allow[i].matches(host, ip, port) {
+			return nil
+		}
+	}
+	for i := range a.deny {
+		if a.deny[i].matches(host, ip, port) {
+			return &ACLDeniedError{Target: target, Rule: a.deny[i].text}
+		}
+	}
+	if a.defaultDeny {
+		return &ACLDeniedError{Target: target}
+	}
+	return nil
+}
+
+// DestinationRule is a single rule in ACL syntax, for matching destinations
+// outside an ACL
+type DestinationRule struct {
+	rule aclRule
+}
+
+// ParseDestinationRule parses one rule in ACL syntax
+func ParseDestinationRule(text string) (*DestinationRule, error) {
+	rule, err := parseACLRule(text)
+	if err != nil {
+		return nil, err
+	}
+	return &DestinationRule{rule: rule}, nil
+}
+
+// String returns the rule as written
+func (r *DestinationRule) String() string {
+	return r.rule.text
+}
+
+// Matches reports whether the rule covers target ("host:port")
+func (r *DestinationRule) Matches(target string) (bool, error) {
+	host, ip, port, err := splitACLTarget(target)
+	if err != nil {
+		return false, err
+	}
+	return r.rule.matches(host, ip, port), nil
+}
+
+// CheckResolved checks an address that target's domain resolved to. Only IP
+// rules apply, so a name that passed Check cannot be pointed at a
+// denied range such as the metadata service.
+func (a *ACL) CheckResolved(target, address string) error {
+	if a == nil {
+		return nil
+	}
+	host, ip, port, err := splitACLTarget(address)
+	if err != nil || ip == nil {
+		return err
+	}
+
+	for i := range a.allow {
+		if a.allow[i].nets != nil && a.allow[i].matches(host, ip, port) {
+			return nil
+		}
+	}
+	for i := range a.deny {
+		if a.deny[i].nets != nil && a.deny[i].matches(host, ip, port) {
+			return &ACLDeniedError{Target: fmt.Sprintf("%s (%s)", target, address), Rule: a.deny[i].text}
+		}
+	}
+	return nil
+}
+
+// splitACLTarget splits "host:port" into its lowercase host, IP (nil for
+// domains) and port
+func splitACLTarget(target string) (string, net.IP, int, error) {
+	host, portText, err := net.SplitHostPort(target)
+	if err != nil {
+		return "", nil, 0, fmt.Errorf("invalid target %q: %w", target, err)
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		return "", nil, 0, fmt.Errorf("invalid port in target %q", target)
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	ip := net.ParseIP(host)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return host, ip, port, nil
+}
+
+// DialTimeout connects to target like net.DialTimeout after checking it
+// against the ACL. Every address a domain resolves to is checked as well,
+// right before it is dialed.
+func (a *ACL) DialTimeout(network, target string, timeout time.Duration) (net.Conn, error) {
+	return ACLs{a}.DialTimeout(network, target, timeout)
+}
+
+// ACLs applies several ACLs to the same destinations; a destination must
+// pass every one. Allow rules in one ACL do not override another's denials.
+type ACLs []*ACL
+
+// Check returns the first denial for target
+func (as ACLs) Check(target string) error {
+	for _, a := range as {
+		if err := a.Check(target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckResolved returns the first denial for an address target resolved to
+func (as ACLs) CheckResolved(target, address string) error {
+	for _, a := range as {
+		if err := a.CheckResolved(target, address); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DialTimeout connects to target after checking it, and every address it
+// resolves to, against each ACL
+func (as ACLs) DialTimeout(network, target string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return as.DialContext(ctx, network, target)
+}
+
+// DialContext is DialTimeout with a context
+func (as ACLs) DialContext(ctx context.Context, network, target string) (net.Conn, error) {
+	if err := as.Check(target); err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{
+		Control: func(_, address string, _ syscall.RawConn) error {
+			return as.CheckResolved(target, address)
+		},
+	}
+	return dialer.DialContext(ctx, network, target)
+}
+
+// ParseBlockedTargets builds the Lambda's guard ACL from the value of
+// BlockedTargetsEnv. Empty means DefaultBlockedTargets and BlockedTargetsNone
+// means no guard (nil).
+func ParseBlockedTargets(value string) (*ACL, error) {
+	value = strings.TrimSpace(value)
+	if strings.EqualFold(value, BlockedTargetsNone) {
+		return nil, nil
+	}
+	rules := DefaultBlockedTargets
+	if value != "" {
+		rules = nil
+		for _, rule := range strings.Split(value, ",") {
+			rules = append(rules, strings.TrimSpace(rule))
+		}
+	}
+	return NewACL(ACLConfig{Deny: rules})
+}
@@ -0,0 +1,164 @@
+package shared
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestACLCheck(t *testing.T) {
+	acl, err := NewACL(ACLConfig{
+		Allow: []string{"10.1.2.3:443"},
+		Deny:  []string{"private", "metadata", ":25", "*.internal.example.com", "[fd00::1]:22", ":8000-8999"},
+	})
+	if err != nil {
+		t.Fatalf("NewACL failed: %v", err)
+	}
+
+	tests := []struct {
+		target string
+		denied bool
+	}{
+		{"example.com:443", false},
+		{"10.0.0.1:80", true},
+		{"10.1.2.3:443", false}, // allow wins over deny
+		{"10.1.2.3:80", true},
+		{"169.254.169.254:80", true},
+		{"mail.example.com:25", true},
+		{"internal.example.com:443", true},
+		{"db.internal.example.com:5432", true},
+		{"notinternal.example.com:443", false},
+		{"[fd00::1]:22", true},
+		{"[fd00::1]:443", true}, // fc00::/7 is private
+		{"[2001:db8::1]:22", false},
+		{"example.com:8080", true},
+	}
+	for _, tt := range tests {
+		err := acl.Check(tt.target)
+		if (err != nil) != tt.denied {
+			t.Errorf("Check(%q) = %v, expected denied %v", tt.target, err, tt.denied)
+		}
+		if err != nil && !errors.Is(err, ErrACLDenied) {
+			t.Errorf("Check(%q) error %v does not match ErrACLDenied", tt.target, err)
+		}
+	}
+}
+
+func TestACLDefaultDeny(t *testing.T) {
+	acl, err := NewACL(ACLConfig{Default: ACLDeny, Allow: []string{"*.example.com:443"}})
+	if err != nil {
+		t.Fatalf("NewACL failed: %v", err)
+	}
+	if err := acl.Check("api.example.com:443"); err != nil {
+		t.Errorf("Expected allowed destination, got %v", err)
+	}
+	if err := acl.Check("api.example.com:80"); !errors.Is(err, ErrACLDenied) {
+		t.Errorf("Expected default deny for unlisted port, got %v", err)
+	}
+
+	if acl, err := NewACL(ACLConfig{}); acl != nil || err != nil {
+		t.Errorf("Expected nil ACL for an empty config, got %v (%v)", acl, err)
+	}
+	var none *ACL
+	if err := none.Check("10.0.0.1:80"); err != nil {
+		t.Errorf("Expected nil ACL to allow everything, got %v", err)
+	}
+}
+
+func TestACLInvalidRules(t *testing.T) {
+	for _, rule := range []string{"", "*", "10.0.0.0/33", "host:0", "host:99999", ":90-80", "foo*.com", "[fd00::1"} {
+		if _, err := NewACL(ACLConfig{Deny: []string{rule}}); err == nil {
+			t.Errorf("Expected error for rule %q", rule)
+		}
+	}
+	if _, err := NewACL(ACLConfig{Default: "maybe"}); err == nil {
+		t.Error("Expected error for invalid default policy")
+	}
+}
+
+func TestACLCheckResolved(t *testing.T) {
+	acl, err := NewACL(ACLConfig{Allow: []string{"10.1.2.3"}, Deny: []string{"private", "rebind.example.com"}})
+	if err != nil {
+		t.Fatalf("NewACL failed: %v", err)
+	}
+	if err := acl.CheckResolved("evil.example.com:80", "10.0.0.5:80"); !errors.Is(err, ErrACLDenied) {
+		t.Errorf("Expected resolved private address to be denied, got %v", err)
+	}
+	if err := acl.CheckResolved("ok.example.com:80", "10.1.2.3:80"); err != nil {
+		t.Errorf("Expected explicitly allowed address, got %v", err)
+	}
+	if err := acl.CheckResolved("ok.example.com:80", "93.184.216.34:80"); err != nil {
+		t.Errorf("Expected public address to be allowed, got %v", err)
+	}
+}
+
+func TestACLDialTimeoutChecksResolvedAddress(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	acl, err := NewACL(ACLConfig{Deny: []string{"loopback"}})
+	if err != nil {
+		t.Fatalf("NewACL failed: %v", err)
+	}
+	// "localhost" passes the name check but resolves to a denied address
+	if _, err := acl.DialTimeout("tcp", net.JoinHostPort("localhost", port), time.Second); !errors.Is(err, ErrACLDenied) {
+		t.Errorf("Expected dial to a denied resolved address to fail with ErrACLDenied, got %v", err)
+	}
+
+	var none *ACL
+	conn, err := none.DialTimeout("tcp", listener.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("Expected nil ACL dial to succeed, got %v", err)
+	}
+	conn.Close()
+}
+
+func TestParseBlockedTargets(t *testing.T) {
+	guard, err := ParseBlockedTargets("")
+	if err != nil {
+		t.Fatalf("ParseBlockedTargets failed: %v", err)
+	}
+	for _, target := range []string{"169.254.169.254:80", "127.0.0.1:9001", "[::1]:80", "169.254.170.2:80"} {
+		if err := guard.Check(target); !errors.Is(err, ErrACLDenied) {
+			t.Errorf("Expected default guard to deny %s, got %v", target, err)
+		}
+	}
+	if err := guard.Check("10.0.0.1:80"); err != nil {
+		t.Errorf("Expected default guard to allow private ranges, got %v", err)
+	}
+
+	if guard, err := ParseBlockedTargets("None"); guard != nil || err != nil {
+		t.Errorf("Expected no guard for %q, got %v (%v)", "None", guard, err)
+	}
+
+	guard, err = ParseBlockedTargets("private, :25")
+	if err != nil {
+		t.Fatalf("ParseBlockedTargets failed: %v", err)
+	}
+	if err := guard.Check("127.0.0.1:80"); err != nil {
+		t.Errorf("Expected custom guard to replace the defaults, got %v", err)
+	}
+	if err := guard.Check("example.com:25"); err == nil {
+		t.Error("Expected custom guard to deny port 25")
+	}
+}
+
+func TestACLsAllowDoesNotOverrideOtherDeny(t *testing.T) {
+	guard, _ := ParseBlockedTargets("")
+	session, err := NewACL(ACLConfig{Allow: []string{"169.254.169.254"}})
+	if err != nil {
+		t.Fatalf("NewACL failed: %v", err)
+	}
+	acls := ACLs{guard, session}
+	if err := acls.Check("169.254.169.254:80"); !errors.Is(err, ErrACLDenied) {
+		t.Errorf("Expected the guard to deny despite the session allow rule, got %v", err)
+	}
+	if err := acls.Check("example.com:443"); err != nil {
+		t.Errorf("Expected public destination to be allowed, got %v", err)
+	}
+}
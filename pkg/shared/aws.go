@@ -1,41 +1,82 @@
 package shared
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
 )
 
-// CreateAWSSession creates a new AWS session with the specified region
-func CreateAWSSession(region string) (*session.Session, error) {
-	return session.NewSession(&aws.Config{
-		Region: aws.String(region),
-	})
+// CodeHashEnv names the Lambda environment variable holding the SHA-256 of
+// the bootstrap binary it was deployed with, so upgrade can tell whether the
+// CLI embeds different code
+const CodeHashEnv = "LAMBDA_CODE_SHA256"
+
+// LiveAlias is the alias upgrade publishes the function's code behind. Once
+// it exists, the S3 trigger and direct invocations run it instead of $LATEST.
+const LiveAlias = "live"
+
+// LoadAWSConfig loads the default AWS configuration for region. Throttled
+// and failed calls are retried by the SDK's adaptive retryer, which also
+// slows down the client while the service is throttling it.
+func LoadAWSConfig(ctx context.Context, region string, optFns ...func(*config.LoadOptions) error) (aws.Config, error) {
+	optFns = append([]func(*config.LoadOptions) error{
+		config.WithRegion(region),
+		config.WithRetryer(func() aws.Retryer {
+			return retry.NewAdaptiveMode()
+		}),
+	}, optFns...)
+	return config.LoadDefaultConfig(ctx, optFns...)
+}
+
+// AWSErrorCode returns the error code of an AWS API error wrapped by err,
+// such as "ExpiredToken", or "" if err isn't one
+func AWSErrorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
 }
 
 // CreateS3Client creates a new S3 client with the specified region
-func CreateS3Client(region string) (*s3.S3, error) {
-	sess, err := CreateAWSSession(region)
+func CreateS3Client(ctx context.Context, region string) (*s3.Client, error) {
+	cfg, err := LoadAWSConfig(ctx, region)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create AWS session: %w", err)
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
-	return s3.New(sess), nil
+	return s3.NewFromConfig(cfg), nil
+}
+
+// CreateSessionS3Client creates an S3 client that signs with a session's
+// scoped credentials instead of the default credential chain
+func CreateSessionS3Client(ctx context.Context, region string, creds *SessionCredentials) (*s3.Client, error) {
+	cfg, err := LoadAWSConfig(ctx, region, config.WithCredentialsProvider(
+		credentials.NewStaticCredentialsProvider(creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	return s3.NewFromConfig(cfg), nil
 }
 
 // PutCoordinationData writes coordination data to S3
-func PutCoordinationData(s3Client *s3.S3, bucket, sessionID string, data CoordinationData) error {
-	coordinationData, err := json.Marshal(data)
+func PutCoordinationData(ctx context.Context, s3Client *s3.Client, bucket, sessionID string, data CoordinationData) error {
+	coordinationData, err := MarshalCoordination(data)
 	if err != nil {
 		return fmt.Errorf("failed to marshal coordination data: %w", err)
 	}
 
 	coordinationKey := fmt.Sprintf(CoordinationKeyPattern, sessionID)
-	_, err = s3Client.PutObject(&s3.PutObjectInput{
+	_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(coordinationKey),
 		Body:   strings.NewReader(string(coordinationData)),
@@ -47,9 +88,10 @@ func PutCoordinationData(s3Client *s3.S3, bucket, sessionID string, data Coordin
 	return nil
 }
 
-// GetCoordinationData reads and parses coordination data from S3
-func GetCoordinationData(s3Client *s3.S3, bucket, key string) (*CoordinationData, error) {
-	obj, err := s3Client.GetObject(&s3.GetObjectInput{
+// GetCoordinationData reads coordination data from S3, checking its schema
+// version and checksum
+func GetCoordinationData(ctx context.Context, s3Client *s3.Client, bucket, key string) (*CoordinationData, error) {
+	obj, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 	})
@@ -58,27 +100,32 @@ func GetCoordinationData(s3Client *s3.S3, bucket, key string) (*CoordinationData
 	}
 	defer obj.Body.Close()
 
-	var coord CoordinationData
-	if err := json.NewDecoder(obj.Body).Decode(&coord); err != nil {
-		return nil, fmt.Errorf("failed to decode coordination data: %w", err)
+	data, err := io.ReadAll(obj.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read S3 object: %w", err)
 	}
-
-	return &coord, nil
+	return UnmarshalCoordination(data)
 }
 
-// PutLambdaResponse writes lambda response data to S3
-func PutLambdaResponse(s3Client *s3.S3, bucket, sessionID string, response LambdaResponse) error {
-	responseData, err := json.Marshal(response)
+// PutLambdaResponse writes lambda response data to S3, checksummed with the
+// session's integrity key and with the storage class and tags of storage if
+// it's set
+func PutLambdaResponse(ctx context.Context, s3Client *s3.Client, bucket, sessionID string, response LambdaResponse, integrityKey string, storage *ObjectStorage) error {
+	responseData, err := MarshalResponse(response, integrityKey)
 	if err != nil {
 		return fmt.Errorf("failed to marshal lambda response: %w", err)
 	}
 
 	responseKey := fmt.Sprintf(ResponseKeyPattern, sessionID)
-	_, err = s3Client.PutObject(&s3.PutObjectInput{
+	input := &s3.PutObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(responseKey),
 		Body:   strings.NewReader(string(responseData)),
-	})
+	}
+	if storage != nil {
+		storage.Apply(input)
+	}
+	_, err = s3Client.PutObject(ctx, input)
 	if err != nil {
 		return fmt.Errorf("failed to write lambda response to S3: %w", err)
 	}
@@ -86,12 +133,22 @@ func PutLambdaResponse(s3Client *s3.S3, bucket, sessionID string, response Lambd
 	return nil
 }
 
+// LambdaResponseExists reports whether a Lambda has already answered the
+// session, so a late or duplicate trigger can be ignored
+func LambdaResponseExists(ctx context.Context, s3Client *s3.Client, bucket, sessionID string) bool {
+	_, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(fmt.Sprintf(ResponseKeyPattern, sessionID)),
+	})
+	return err == nil
+}
+
 // WaitForS3Object polls for an S3 object until it exists or timeout is reached
-func WaitForS3Object(s3Client *s3.S3, bucket, key string, timeout time.Duration) ([]byte, error) {
+func WaitForS3Object(ctx context.Context, s3Client *s3.Client, bucket, key string, timeout time.Duration) ([]byte, error) {
 	deadline := time.Now().Add(timeout)
 	
 	for time.Now().Before(deadline) {
-		obj, err := s3Client.GetObject(&s3.GetObjectInput{
+		obj, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
 			Bucket: aws.String(bucket),
 			Key:    aws.String(key),
 		})
@@ -119,11 +176,12 @@ func WaitForS3Object(s3Client *s3.S3, bucket, key string, timeout time.Duration)
 	return nil, fmt.Errorf("timeout waiting for S3 object %s/%s", bucket, key)
 }
 
-// GetLambdaResponse reads and parses lambda response data from S3
-func GetLambdaResponse(s3Client *s3.S3, bucket, sessionID string) (*LambdaResponse, error) {
+// GetLambdaResponse reads lambda response data from S3, checking its schema
+// version and its checksum against the session's integrity key
+func GetLambdaResponse(ctx context.Context, s3Client *s3.Client, bucket, sessionID, integrityKey string) (*LambdaResponse, error) {
 	responseKey := fmt.Sprintf(ResponseKeyPattern, sessionID)
 	
-	obj, err := s3Client.GetObject(&s3.GetObjectInput{
+	obj, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(responseKey),
 	})
@@ -132,10 +190,9 @@ func GetLambdaResponse(s3Client *s3.S3, bucket, sessionID string) (*LambdaRespon
 	}
 	defer obj.Body.Close()
 
-	var response LambdaResponse
-	if err := json.NewDecoder(obj.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("failed to decode lambda response: %w", err)
+	data, err := io.ReadAll(obj.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lambda response from S3: %w", err)
 	}
-
-	return &response, nil
+	return UnmarshalResponse(data, integrityKey)
 }
\ No newline at end of file
@@ -0,0 +1,67 @@
+package shared
+
+import "sync"
+
+// bufferSizeClasses are the pooled buffer sizes, smallest first. Requests are
+// rounded up to the nearest class; larger requests bypass the pool.
+var bufferSizeClasses = []int{
+	8 * 1024,
+	16 * 1024,
+	32 * 1024,
+	64 * 1024,
+	128 * 1024,
+}
+
+// bufferPools holds one sync.Pool per size class
+var bufferPools = newBufferPools()
+
+func newBufferPools() []*sync.Pool {
+	pools := make([]*sync.Pool, len(bufferSizeClasses))
+	for i, size := range bufferSizeClasses {
+		size := size
+		pools[i] = &sync.Pool{
+			New: func() interface{} {
+				buf := make([]byte, size)
+				return &buf
+			},
+		}
+	}
+	return pools
+}
+
+// bufferClass returns the index of the smallest class that fits size, or -1
+func bufferClass(size int) int {
+	for i, classSize := range bufferSizeClasses {
+		if size <= classSize {
+			return i
+		}
+	}
+	return -1
+}
+
+// GetBuffer returns a buffer of length size from the shared pool.
+// The buffer must be handed back with PutBuffer once it is no longer used.
+func GetBuffer(size int) *[]byte {
+	class := bufferClass(size)
+	if class < 0 {
+		buf := make([]byte, size)
+		return &buf
+	}
+	bufPtr := bufferPools[class].Get().(*[]byte)
+	*bufPtr = (*bufPtr)[:size]
+	return bufPtr
+}
+
+// PutBuffer returns a buffer obtained from GetBuffer to the shared pool
+func PutBuffer(bufPtr *[]byte) {
+	if bufPtr == nil {
+		return
+	}
+	class := bufferClass(cap(*bufPtr))
+	if class < 0 || bufferSizeClasses[class] != cap(*bufPtr) {
+		// Not one of ours (oversized or foreign), let the GC have it
+		return
+	}
+	*bufPtr = (*bufPtr)[:cap(*bufPtr)]
+	bufferPools[class].Put(bufPtr)
+}
@@ -0,0 +1,87 @@
+package shared
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestBufferPoolSizeClasses(t *testing.T) {
+	tests := []struct {
+		size        int
+		expectedCap int
+	}{
+		{1, 8 * 1024},
+		{8 * 1024, 8 * 1024},
+		{20 * 1024, 32 * 1024},
+		{64 * 1024, 64 * 1024},
+		{200 * 1024, 200 * 1024},
+	}
+
+	for _, tt := range tests {
+		bufPtr := GetBuffer(tt.size)
+		if len(*bufPtr) != tt.size {
+			t.Errorf("GetBuffer(%d): expected length %d, got %d", tt.size, tt.size, len(*bufPtr))
+		}
+		if cap(*bufPtr) != tt.expectedCap {
+			t.Errorf("GetBuffer(%d): expected capacity %d, got %d", tt.size, tt.expectedCap, cap(*bufPtr))
+		}
+		PutBuffer(bufPtr)
+	}
+
+	// Reused buffers come back at full class length before being resliced
+	bufPtr := GetBuffer(10)
+	PutBuffer(bufPtr)
+	if bufPtr = GetBuffer(4096); len(*bufPtr) != 4096 {
+		t.Errorf("Expected reused buffer of length 4096, got %d", len(*bufPtr))
+	}
+	PutBuffer(bufPtr)
+}
+
+func TestCopyWithPooledBuffer(t *testing.T) {
+	data := bytes.Repeat([]byte("lambda"), 50000)
+	var dst bytes.Buffer
+
+	written, err := copyWithBuffer(&dst, bytes.NewReader(data), 32*1024)
+	if err != nil {
+		t.Fatalf("copyWithBuffer failed: %v", err)
+	}
+	if written != int64(len(data)) || !bytes.Equal(dst.Bytes(), data) {
+		t.Errorf("Copied data mismatch: wrote %d of %d bytes", written, len(data))
+	}
+}
+
+// copyWithFreshBuffer is the pre-pool copy loop, kept as a benchmark baseline
+func copyWithFreshBuffer(dst io.Writer, src io.Reader, bufferSize int) (int64, error) {
+	buf := make([]byte, bufferSize)
+	return io.CopyBuffer(dst, onlyReader{src}, buf)
+}
+
+// onlyReader hides WriterTo so io.CopyBuffer uses the supplied buffer
+type onlyReader struct{ io.Reader }
+
+func BenchmarkCopyFreshBuffer(b *testing.B) {
+	data := make([]byte, 64*1024)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		copyWithFreshBuffer(io.Discard, bytes.NewReader(data), 64*1024)
+	}
+}
+
+func BenchmarkCopyPooledBuffer(b *testing.B) {
+	data := make([]byte, 64*1024)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		copyWithBuffer(io.Discard, bytes.NewReader(data), 64*1024)
+	}
+}
+
+func BenchmarkCopyPooledBufferParallel(b *testing.B) {
+	data := make([]byte, 64*1024)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			copyWithBuffer(io.Discard, bytes.NewReader(data), 64*1024)
+		}
+	})
+}
@@ -0,0 +1,70 @@
+package shared
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+// StreamBytes are the payload bytes one end moved over a finished tunnel
+// stream, after its header and reply, as they crossed the wire
+type StreamBytes struct {
+	StreamID    uint64
+	Received    uint64
+	Sent        uint64
+	ReceivedEOF bool // the peer finished sending, so Received is all it sent
+}
+
+// ByteCounter counts the bytes read from and written to a tunnel stream.
+// Both ends count the same stream, so for each direction that ended with
+// the sender's FIN the receiver's count must equal the sender's.
+type ByteCounter struct {
+	received atomic.Uint64
+	sent     atomic.Uint64
+	eof      atomic.Bool
+}
+
+// CountRead records the result of a Read from the stream
+func (c *ByteCounter) CountRead(n int, err error) {
+	if n > 0 {
+		c.received.Add(uint64(n))
+	}
+	if errors.Is(err, io.EOF) {
+		c.eof.Store(true)
+	}
+}
+
+// CountWrite records n bytes written to the stream
+func (c *ByteCounter) CountWrite(n int) {
+	if n > 0 {
+		c.sent.Add(uint64(n))
+	}
+}
+
+// Bytes returns the counts so far for the stream with id
+func (c *ByteCounter) Bytes(id uint64) StreamBytes {
+	return StreamBytes{
+		StreamID:    id,
+		Received:    c.received.Load(),
+		Sent:        c.sent.Load(),
+		ReceivedEOF: c.eof.Load(),
+	}
+}
+
+// CountingStream counts what passes through a stream with a ByteCounter
+type CountingStream struct {
+	io.ReadWriteCloser
+	Counter ByteCounter
+}
+
+func (s *CountingStream) Read(p []byte) (int, error) {
+	n, err := s.ReadWriteCloser.Read(p)
+	s.Counter.CountRead(n, err)
+	return n, err
+}
+
+func (s *CountingStream) Write(p []byte) (int, error) {
+	n, err := s.ReadWriteCloser.Write(p)
+	s.Counter.CountWrite(n)
+	return n, err
+}
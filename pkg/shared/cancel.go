@@ -0,0 +1,51 @@
+package shared
+
+import (
+	"errors"
+	"net"
+	"syscall"
+
+	"github.com/quic-go/quic-go"
+)
+
+// Application error codes a tunnel stream is cancelled with, telling the
+// other end why it should stop sending
+const (
+	StreamCancelled     quic.StreamErrorCode = 0x00 // no particular reason
+	StreamClientReset   quic.StreamErrorCode = 0x10 // the SOCKS5 client reset its connection
+	StreamClientTimeout quic.StreamErrorCode = 0x11 // the SOCKS5 client's socket passed a deadline
+)
+
+// ClientErrorCode returns the code to cancel a tunnel stream with after a
+// read or write on the client's socket failed with err. Errors that don't
+// mean the client went away, such as EOF or the proxy closing the socket
+// itself, return false.
+func ClientErrorCode(err error) (quic.StreamErrorCode, bool) {
+	if err == nil || errors.Is(err, net.ErrClosed) {
+		return 0, false
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNABORTED) {
+		return StreamClientReset, true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return StreamClientTimeout, true
+	}
+	return 0, false
+}
+
+// PeerCancelReason describes why the peer cancelled a stream, if err from a
+// read or write on it says the peer's client went away
+func PeerCancelReason(err error) (string, quic.StreamErrorCode, bool) {
+	var streamErr *quic.StreamError
+	if !errors.As(err, &streamErr) || !streamErr.Remote {
+		return "", 0, false
+	}
+	switch streamErr.ErrorCode {
+	case StreamClientReset:
+		return "client reset the connection", streamErr.ErrorCode, true
+	case StreamClientTimeout:
+		return "client timed out", streamErr.ErrorCode, true
+	}
+	return "", 0, false
+}
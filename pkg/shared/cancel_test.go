@@ -0,0 +1,48 @@
+package shared
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/quic-go/quic-go"
+)
+
+func TestClientErrorCode(t *testing.T) {
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	timeout := &net.OpError{Op: "write", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	tests := []struct {
+		err  error
+		code quic.StreamErrorCode
+		ok   bool
+	}{
+		{reset, StreamClientReset, true},
+		{fmt.Errorf("copy: %w", reset), StreamClientReset, true},
+		{timeout, StreamClientTimeout, true},
+		{io.EOF, 0, false},
+		{net.ErrClosed, 0, false},
+		{nil, 0, false},
+	}
+	for _, tt := range tests {
+		if code, ok := ClientErrorCode(tt.err); code != tt.code || ok != tt.ok {
+			t.Errorf("ClientErrorCode(%v) = %#x, %v; want %#x, %v", tt.err, code, ok, tt.code, tt.ok)
+		}
+	}
+}
+
+func TestPeerCancelReason(t *testing.T) {
+	remote := &quic.StreamError{StreamID: 4, ErrorCode: StreamClientReset, Remote: true}
+	if _, code, ok := PeerCancelReason(fmt.Errorf("read: %w", remote)); !ok || code != StreamClientReset {
+		t.Errorf("Expected a client reset, got %#x, %v", code, ok)
+	}
+	local := &quic.StreamError{StreamID: 4, ErrorCode: StreamClientReset}
+	if _, _, ok := PeerCancelReason(local); ok {
+		t.Error("Expected a local cancellation to be ignored")
+	}
+	if _, _, ok := PeerCancelReason(&quic.StreamError{ErrorCode: StreamCancelled, Remote: true}); ok {
+		t.Error("Expected a plain cancellation to be ignored")
+	}
+}
@@ -0,0 +1,263 @@
+package shared
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+)
+
+// Compression is the algorithm compressing a tunnel stream's payload
+type Compression byte
+
+const (
+	CompressionNone    Compression = 0x00
+	CompressionDeflate Compression = 0x01
+)
+
+// ParseCompression parses a compression setting: "off" (or "") or "deflate"
+func ParseCompression(s string) (Compression, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "off", "none":
+		return CompressionNone, nil
+	case "deflate":
+		return CompressionDeflate, nil
+	}
+	return CompressionNone, fmt.Errorf("unknown compression %q (use off or deflate)", s)
+}
+
+// String names the algorithm as the config file does
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "off"
+	case CompressionDeflate:
+		return "deflate"
+	}
+	return fmt.Sprintf("compression(%d)", byte(c))
+}
+
+// Compressed streams carry their payload as chunks, each compressed on its
+// own so either side can send any chunk raw:
+//
+// Format: [1 byte kind][2 bytes payload length][payload]
+const (
+	chunkRaw     byte = 0x00
+	chunkDeflate byte = 0x01
+
+	// compressChunkSize is the most payload a chunk carries, before compression
+	compressChunkSize = 16 * 1024
+
+	// minCompressSize is the smallest write worth compressing
+	minCompressSize = 64
+
+	// incompressibleLimit is how many chunks in a row may fail to shrink
+	// before the writer stops trying
+	incompressibleLimit = 4
+
+	// probeInterval is how often a writer that stopped trying tries again,
+	// in chunks, in case the payload changed
+	probeInterval = 64
+)
+
+// encryptedPorts are destination ports whose traffic is encrypted, so
+// compressing it would only cost CPU
+var encryptedPorts = map[uint16]bool{
+	22: true, 443: true, 465: true, 853: true, 993: true, 995: true, 8443: true,
+}
+
+// LikelyEncryptedPort reports whether traffic to port is almost certainly
+// encrypted and not worth compressing
+func LikelyEncryptedPort(port uint16) bool {
+	return encryptedPorts[port]
+}
+
+// incompressibleMagic are the leading bytes of TLS records and common
+// compressed formats
+var incompressibleMagic = [][]byte{
+	{0x16, 0x03},             // TLS handshake
+	{0x17, 0x03},             // TLS application data
+	{0x1f, 0x8b},             // gzip
+	{0x28, 0xb5, 0x2f, 0xfd}, // zstd
+	{0x50, 0x4b, 0x03, 0x04}, // zip
+	{0xff, 0xd8, 0xff},       // JPEG
+	{0x89, 0x50, 0x4e, 0x47}, // PNG
+	[]byte("SSH-"),
+}
+
+// looksIncompressible reports whether p opens like encrypted or already
+// compressed data
+func looksIncompressible(p []byte) bool {
+	for _, magic := range incompressibleMagic {
+		if bytes.HasPrefix(p, magic) {
+			return true
+		}
+	}
+	return false
+}
+
+// CompressedStream compresses what is written to a stream and decompresses
+// what is read from it. Writes and reads may run concurrently, but not
+// several of either.
+type CompressedStream struct {
+	rw io.ReadWriteCloser
+
+	// Write side
+	deflate        *flate.Writer
+	packed         bytes.Buffer
+	header         [3]byte
+	sniffed        bool
+	incompressible int // chunks in a row that didn't shrink
+	skipped        int // chunks sent raw without trying, once skipping
+
+	// Read side
+	inflate io.ReadCloser
+	payload []byte
+	plain   bytes.Buffer
+
+	plainOut, wireOut atomic.Int64
+}
+
+// NewCompressedStream wraps rw, which must carry a compressed stream in
+// both directions
+func NewCompressedStream(rw io.ReadWriteCloser) *CompressedStream {
+	w, _ := flate.NewWriter(nil, flate.BestSpeed)
+	return &CompressedStream{
+		rw:      rw,
+		deflate: w,
+		inflate: flate.NewReader(bytes.NewReader(nil)),
+		payload: make([]byte, 0, compressChunkSize),
+	}
+}
+
+// Write sends p in one or more chunks, compressing those that shrink
+func (s *CompressedStream) Write(p []byte) (int, error) {
+	if !s.sniffed {
+		s.sniffed = true
+		if looksIncompressible(p) {
+			s.incompressible = incompressibleLimit
+		}
+	}
+
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > compressChunkSize {
+			chunk = chunk[:compressChunkSize]
+		}
+		if err := s.writeChunk(chunk); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// writeChunk sends chunk compressed if that makes it smaller and raw otherwise
+func (s *CompressedStream) writeChunk(chunk []byte) error {
+	kind, payload := chunkRaw, chunk
+	if s.shouldTry(len(chunk)) {
+		s.packed.Reset()
+		s.deflate.Reset(&s.packed)
+		s.deflate.Write(chunk)
+		s.deflate.Close()
+		if s.packed.Len() < len(chunk)*9/10 {
+			kind, payload = chunkDeflate, s.packed.Bytes()
+			s.incompressible = 0
+		} else {
+			s.incompressible++
+		}
+	}
+
+	s.header[0] = kind
+	binary.BigEndian.PutUint16(s.header[1:], uint16(len(payload)))
+	if _, err := s.rw.Write(s.header[:]); err != nil {
+		return err
+	}
+	if _, err := s.rw.Write(payload); err != nil {
+		return err
+	}
+	s.plainOut.Add(int64(len(chunk)))
+	s.wireOut.Add(int64(len(s.header) + len(payload)))
+	return nil
+}
+
+// shouldTry reports whether a chunk of n bytes is worth compressing
+func (s *CompressedStream) shouldTry(n int) bool {
+	if n < minCompressSize {
+		return false
+	}
+	if s.incompressible < incompressibleLimit {
+		return true
+	}
+	s.skipped++
+	if s.skipped < probeInterval {
+		return false
+	}
+	s.skipped = 0
+	return true
+}
+
+// Read returns decompressed payload, reading chunks as needed
+func (s *CompressedStream) Read(p []byte) (int, error) {
+	for s.plain.Len() == 0 {
+		if err := s.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	return s.plain.Read(p)
+}
+
+// readChunk reads the next chunk into s.plain
+func (s *CompressedStream) readChunk() error {
+	var header [3]byte
+	if _, err := io.ReadFull(s.rw, header[:]); err != nil {
+		return err
+	}
+	size := int(binary.BigEndian.Uint16(header[1:]))
+	if size > compressChunkSize {
+		return fmt.Errorf("compressed chunk too long: %d bytes (max %d)", size, compressChunkSize)
+	}
+	payload := s.payload[:size]
+	if _, err := io.ReadFull(s.rw, payload); err != nil {
+		return fmt.Errorf("failed to read compressed chunk: %w", err)
+	}
+
+	s.plain.Reset()
+	switch header[0] {
+	case chunkRaw:
+		s.plain.Write(payload)
+	case chunkDeflate:
+		s.inflate.(flate.Resetter).Reset(bytes.NewReader(payload), nil)
+		// A chunk never holds more than compressChunkSize bytes of payload
+		n, err := s.plain.ReadFrom(io.LimitReader(s.inflate, compressChunkSize+1))
+		if err != nil {
+			return fmt.Errorf("failed to decompress chunk: %w", err)
+		}
+		if n > compressChunkSize {
+			return fmt.Errorf("compressed chunk expands past %d bytes", compressChunkSize)
+		}
+	default:
+		return fmt.Errorf("unknown compressed chunk kind %02x", header[0])
+	}
+	return nil
+}
+
+// Close closes the underlying stream
+func (s *CompressedStream) Close() error {
+	return s.rw.Close()
+}
+
+// Ratio returns the bytes written to the stream over the bytes they took
+// on the wire, or 0 before any were written
+func (s *CompressedStream) Ratio() float64 {
+	wire := s.wireOut.Load()
+	if wire == 0 {
+		return 0
+	}
+	return float64(s.plainOut.Load()) / float64(wire)
+}
@@ -0,0 +1,88 @@
+package shared
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+)
+
+// roundTrip writes data through one end of a compressed pipe and returns
+// what the other end read, along with the writer
+func roundTrip(t *testing.T, data []byte) ([]byte, *CompressedStream) {
+	t.Helper()
+	a, b := net.Pipe()
+	writer, reader := NewCompressedStream(a), NewCompressedStream(b)
+	defer reader.Close()
+
+	go func() {
+		writer.Write(data)
+		writer.Close()
+	}()
+	got, err := io.ReadAll(reader)
+	if err != nil && err != io.EOF {
+		t.Fatalf("Failed to read compressed stream: %v", err)
+	}
+	return got, writer
+}
+
+func TestCompressedStreamRoundTrip(t *testing.T) {
+	text := bytes.Repeat([]byte("GET /index.html HTTP/1.1\r\nHost: example.com\r\n\r\n"), 2000)
+	random := make([]byte, 100*1024)
+	rand.Read(random)
+	tls := append([]byte{0x16, 0x03, 0x01}, bytes.Repeat([]byte("a"), 4096)...)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		compress bool // whether the writer should have saved bytes
+	}{
+		{"text", text, true},
+		{"random", random, false},
+		{"tls", tls, false},
+		{"small", []byte("hi"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, writer := roundTrip(t, tt.data)
+			if !bytes.Equal(got, tt.data) {
+				t.Fatalf("Expected %d bytes back unchanged, got %d bytes", len(tt.data), len(got))
+			}
+			if ratio := writer.Ratio(); (ratio > 1) != tt.compress {
+				t.Errorf("Expected compression %v, got ratio %.2f", tt.compress, ratio)
+			}
+		})
+	}
+}
+
+func TestCompressedStreamRejectsBadChunks(t *testing.T) {
+	for _, chunk := range [][]byte{
+		{0x07, 0x00, 0x01, 'x'},  // unknown kind
+		{0x00, 0xff, 0xff},       // too long
+		{0x01, 0x00, 0x02, 1, 2}, // not deflate
+	} {
+		s := NewCompressedStream(nopCloser{bytes.NewBuffer(chunk)})
+		if _, err := s.Read(make([]byte, 16)); err == nil || err == io.EOF {
+			t.Errorf("Expected an error reading chunk %x, got %v", chunk, err)
+		}
+	}
+}
+
+func TestParseCompression(t *testing.T) {
+	for in, want := range map[string]Compression{"": CompressionNone, "off": CompressionNone, "Deflate": CompressionDeflate} {
+		got, err := ParseCompression(in)
+		if err != nil || got != want {
+			t.Errorf("ParseCompression(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParseCompression("zstd"); err == nil {
+		t.Error("Expected an error for an unknown algorithm")
+	}
+}
+
+type nopCloser struct {
+	io.ReadWriter
+}
+
+func (nopCloser) Close() error { return nil }
@@ -6,6 +6,7 @@ import "time"
 const (
 	DefaultAWSRegion         = "us-west-2"
 	DefaultSOCKS5Port        = 1080
+	DefaultSOCKS5Listen      = "127.0.0.1" // only this machine can use the proxy unless proxy.listen says otherwise
 	DefaultSTUNServer        = "stun.l.google.com:19302"
 	DefaultSocketReleaseDelay = 100 * time.Millisecond
 )
@@ -19,6 +20,47 @@ const (
 	HolePunchInterval           = 100 * time.Millisecond
 	ResponsePollInterval        = 500 * time.Millisecond
 	UDPReadTimeout             = 200 * time.Millisecond
+	DefaultSessionWaitTimeout  = 10 * time.Second
+	DefaultInvokeFallback      = 5 * time.Second
+	HTTPShutdownTimeout        = 3 * time.Second // time the dashboard and metrics servers give open requests on exit
+)
+
+// Session pool constants
+const (
+	DefaultPoolSecondaries   = 1  // secondaries alongside the primary
+	DefaultPoolMaxSessions   = 2  // all sessions, including draining ones
+	DefaultPoolMaxGoroutines = 50 // connection manager launches, promotions and drains in flight
+)
+
+// Drain policies for the previous primary after a rotation
+const (
+	DrainPolicyTimer   = "timer"   // shut down after the drain timeout
+	DrainPolicyStreams = "streams" // shut down once no streams are open, up to a maximum wait
+	
+	DefaultMaxDrainWait = 10 * time.Minute // longest a streams drain waits for transfers to finish
+)
+
+// Lambda timeout margins. Before the function times out the Lambda tells
+// the orchestrator and stops taking new streams, then exits once its open
+// streams finish, rather than being killed with them open.
+const (
+	LambdaExpiryNotice = 15 * time.Second // time before the function timeout the Lambda warns and stops taking streams
+	LambdaExitMargin   = 2 * time.Second  // time before the function timeout the Lambda exits, closing any streams left
+)
+
+// SOCKS5 queueing and concurrency constants
+const (
+	DefaultMaxQueuedConnections = 256
+	DefaultMaxConnections       = 1024
+	SOCKS5RejectTimeout         = 5 * time.Second // time allowed to tell a rejected client why
+	ProxyHeaderTimeout          = 5 * time.Second // time allowed for a load balancer's PROXY protocol header
+)
+
+// Resource self-limit constants
+const (
+	ResourceSampleInterval = time.Second      // how often usage is checked against ResourceLimits
+	ResourceResumeFraction = 0.9              // accepting resumes once usage is below this share of every limit
+	ShedIdleAfter          = 10 * time.Second // tunnels idle this long are shed while over a limit
 )
 
 // NAT traversal constants
@@ -37,6 +79,41 @@ const (
 const (
 	CoordinationKeyPattern = "coordination/%s.json"
 	ResponseKeyPattern     = "punch-response/%s.json"
+	
+	CoordinationKeyPrefix = "coordination/"
+	ResponseKeyPrefix     = "punch-response/"
+)
+
+// How a Lambda was started for a session: by the bucket's S3 notification,
+// invoked directly by the orchestrator when the notification was late, or by
+// a request to its function URL
+const (
+	TriggerS3          = "s3"
+	TriggerInvoke      = "invoke"
+	TriggerFunctionURL = "function_url"
+	
+	// DirectInvokeEventSource marks the S3 event records of direct invocations
+	DirectInvokeEventSource = "lambda-nat-proxy:invoke"
+)
+
+// How the orchestrator hands a session to the Lambda: through the S3
+// coordination bucket, or by POSTing to the Lambda's function URL and reading
+// its endpoint from the response
+const (
+	CoordinationS3          = "s3"
+	CoordinationFunctionURL = "function_url"
+)
+
+// WarmupPayload is the event scheduled warm-up invocations send. The Lambda
+// returns at once, leaving an initialized execution environment for the
+// next session.
+const WarmupPayload = `{"warmup":true}`
+
+// Lambda instruction set architectures. arm64 (Graviton) costs about 20%
+// less per GB-second than x86_64.
+const (
+	ArchitectureX86_64 = "x86_64"
+	ArchitectureARM64  = "arm64"
 )
 
 // SOCKS5 protocol constants
@@ -46,8 +123,15 @@ const (
 	SOCKS5NoAuth     = 0x00
 	SOCKS5Success    = 0x00
 	SOCKS5Failed     = 0x01
+	SOCKS5NotAllowed = 0x02 // connection not allowed by ruleset
 	SOCKS5IPv4       = 0x01
 	SOCKS5DomainName = 0x03
+
+	SOCKS5NetworkUnreachable  = 0x03
+	SOCKS5HostUnreachable     = 0x04
+	SOCKS5ConnectionRefused   = 0x05
+	SOCKS5TTLExpired          = 0x06 // used for connect timeouts
+	SOCKS5CommandNotSupported = 0x07
 )
 
 // TLS certificate constants
@@ -99,4 +183,5 @@ var (
 	SOCKS5AuthResponse    = []byte{SOCKS5Version, SOCKS5NoAuth}
 	SOCKS5SuccessResponse = []byte{SOCKS5Version, SOCKS5Success, 0x00, SOCKS5IPv4, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
 	SOCKS5FailureResponse = []byte{SOCKS5Version, SOCKS5Failed, 0x00, SOCKS5IPv4, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	SOCKS5NotAllowedResponse = []byte{SOCKS5Version, SOCKS5NotAllowed, 0x00, SOCKS5IPv4, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
 )
\ No newline at end of file
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"time"
 )
 
 // Control message opcodes
@@ -11,8 +12,200 @@ const (
 	OpPing     byte = 0x01
 	OpPong     byte = 0x02
 	OpShutdown byte = 0x03
+	OpFlushDNS byte = 0x04
+	OpHello    byte = 0x05
+	
+	OpByteReport byte = 0x06 // asks for the byte counts of streams finished since the last report
+	OpByteCounts byte = 0x07 // answers OpByteReport
+	OpLog        byte = 0x08 // a Lambda log line, sent unasked when SessionSettings.Logs is set
+	OpExpiring   byte = 0x09 // the Lambda is near its function timeout and takes no new streams
+)
+
+// Protocol versions of the stream and control wire formats. Version 1 is
+// every orchestrator and Lambda from before the hello exchange.
+const (
+	ProtocolVersion    uint16 = 2 // spoken by this build
+	MinProtocolVersion uint16 = 2 // oldest version this build works with
+	
+	HelloTimeout = 5 * time.Second // time the orchestrator waits for the Lambda's hello
+)
+
+// Capability flags announced in a hello
+const (
+	CapStreamFrame uint64 = 1 << iota // streams open with a StreamFrame
+	CapDNS                            // StreamDNS streams
+	CapUDP                            // StreamUDP streams
+	CapThroughput                     // StreamEcho, StreamDiscard and StreamSource streams
+	CapFlushDNS                       // OpFlushDNS
+	CapCompression                    // CompressionDeflate streams (OptCompression)
+	CapByteCounts                     // OpByteReport
+	CapStripes                        // extra QUIC connections (QUICTuning.Connections)
+	CapLogForwarding                  // OpLog (SessionSettings.Logs)
+	CapExpiring                       // OpExpiring
+	CapTraceContext                   // OptTraceparent in stream frames
 )
 
+// Capabilities are the capability flags of this build
+const Capabilities = CapStreamFrame | CapDNS | CapUDP | CapThroughput | CapFlushDNS | CapCompression | CapByteCounts | CapStripes | CapLogForwarding | CapExpiring | CapTraceContext
+
+// Hello is the first control message each side sends, announcing the
+// protocol versions and capabilities it supports
+type Hello struct {
+	Version      uint16
+	MinVersion   uint16
+	Capabilities uint64
+}
+
+// LocalHello returns the hello of this build
+func LocalHello() Hello {
+	return Hello{Version: ProtocolVersion, MinVersion: MinProtocolVersion, Capabilities: Capabilities}
+}
+
+// Supports reports whether the sender of h has every capability in caps
+func (h Hello) Supports(caps uint64) bool {
+	return h.Capabilities&caps == caps
+}
+
+// ProtocolMismatchError reports a peer whose protocol versions don't overlap ours
+type ProtocolMismatchError struct {
+	Local, Peer Hello
+}
+
+func (e *ProtocolMismatchError) Error() string {
+	if e.Peer.Version < e.Local.MinVersion {
+		return fmt.Sprintf("peer speaks protocol version %d, but version %d or later is needed", e.Peer.Version, e.Local.MinVersion)
+	}
+	return fmt.Sprintf("peer needs protocol version %d or later, but version %d is spoken here", e.Peer.MinVersion, e.Local.Version)
+}
+
+// PeerOutdated reports whether the peer, rather than this build, is too old
+func (e *ProtocolMismatchError) PeerOutdated() bool {
+	return e.Peer.Version < e.Local.MinVersion
+}
+
+// CheckHello returns a *ProtocolMismatchError if peer can't work with local
+func CheckHello(local, peer Hello) error {
+	if peer.Version < local.MinVersion || local.Version < peer.MinVersion {
+		return &ProtocolMismatchError{Local: local, Peer: peer}
+	}
+	return nil
+}
+
+// WriteHello writes a hello message to the writer
+func WriteHello(w io.Writer, h Hello) error {
+	buf := make([]byte, 13)
+	buf[0] = OpHello
+	binary.BigEndian.PutUint16(buf[1:], h.Version)
+	binary.BigEndian.PutUint16(buf[3:], h.MinVersion)
+	binary.BigEndian.PutUint64(buf[5:], h.Capabilities)
+	if _, err := w.Write(buf); err != nil {
+		return fmt.Errorf("failed to write hello: %w", err)
+	}
+	return nil
+}
+
+// ReadHello reads the rest of a hello message after ReadControlMessage
+// returned OpHello
+func ReadHello(r io.Reader) (Hello, error) {
+	buf := make([]byte, 12)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return Hello{}, fmt.Errorf("failed to read hello: %w", err)
+	}
+	return Hello{
+		Version:      binary.BigEndian.Uint16(buf[0:]),
+		MinVersion:   binary.BigEndian.Uint16(buf[2:]),
+		Capabilities: binary.BigEndian.Uint64(buf[4:]),
+	}, nil
+}
+
+// MaxByteCounts is the most streams one OpByteCounts message reports
+const MaxByteCounts = 1024
+
+// byteCountsEntrySize is the encoded size of one StreamBytes
+const byteCountsEntrySize = 25
+
+// WriteByteReport writes a message asking the Lambda for the byte counts of
+// the streams it finished since it last answered one
+func WriteByteReport(w io.Writer) error {
+	return writeByte(w, OpByteReport)
+}
+
+// WriteByteCounts writes an answer to OpByteReport carrying up to
+// MaxByteCounts of counts
+func WriteByteCounts(w io.Writer, counts []StreamBytes) error {
+	if len(counts) > MaxByteCounts {
+		return fmt.Errorf("too many byte counts: %d (max %d)", len(counts), MaxByteCounts)
+	}
+	buf := make([]byte, 3, 3+len(counts)*byteCountsEntrySize)
+	buf[0] = OpByteCounts
+	binary.BigEndian.PutUint16(buf[1:], uint16(len(counts)))
+	for _, c := range counts {
+		var flags byte
+		if c.ReceivedEOF {
+			flags = 1
+		}
+		buf = binary.BigEndian.AppendUint64(buf, c.StreamID)
+		buf = binary.BigEndian.AppendUint64(buf, c.Received)
+		buf = binary.BigEndian.AppendUint64(buf, c.Sent)
+		buf = append(buf, flags)
+	}
+	if _, err := w.Write(buf); err != nil {
+		return fmt.Errorf("failed to write byte counts: %w", err)
+	}
+	return nil
+}
+
+// ReadByteCounts reads the rest of a byte counts message after
+// ReadControlMessage returned OpByteCounts
+func ReadByteCounts(r io.Reader) ([]StreamBytes, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, fmt.Errorf("failed to read byte counts: %w", err)
+	}
+	n := int(binary.BigEndian.Uint16(head[:]))
+	if n > MaxByteCounts {
+		return nil, fmt.Errorf("too many byte counts: %d (max %d)", n, MaxByteCounts)
+	}
+	buf := make([]byte, n*byteCountsEntrySize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, fmt.Errorf("failed to read byte counts: %w", err)
+	}
+	counts := make([]StreamBytes, n)
+	for i := range counts {
+		entry := buf[i*byteCountsEntrySize:]
+		counts[i] = StreamBytes{
+			StreamID:    binary.BigEndian.Uint64(entry[0:]),
+			Received:    binary.BigEndian.Uint64(entry[8:]),
+			Sent:        binary.BigEndian.Uint64(entry[16:]),
+			ReceivedEOF: entry[24]&1 != 0,
+		}
+	}
+	return counts, nil
+}
+
+// WriteExpiring writes a message telling the orchestrator the Lambda times
+// out in remaining and takes no new streams
+func WriteExpiring(w io.Writer, remaining time.Duration) error {
+	buf := make([]byte, 9)
+	buf[0] = OpExpiring
+	binary.BigEndian.PutUint64(buf[1:], uint64(max(remaining, 0).Milliseconds()))
+	if _, err := w.Write(buf); err != nil {
+		return fmt.Errorf("failed to write expiring: %w", err)
+	}
+	return nil
+}
+
+// ReadExpiring reads the rest of an expiring message after
+// ReadControlMessage returned OpExpiring, returning the time left before the
+// Lambda times out
+func ReadExpiring(r io.Reader) (time.Duration, error) {
+	ms, err := readUint64(r)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read expiring: %w", err)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
 // Ping represents a ping message with a nonce
 type Ping struct {
 	Nonce uint64
@@ -45,6 +238,11 @@ func WriteShutdown(w io.Writer) error {
 	return writeByte(w, OpShutdown)
 }
 
+// WriteFlushDNS writes a message asking the Lambda to empty its DNS cache
+func WriteFlushDNS(w io.Writer) error {
+	return writeByte(w, OpFlushDNS)
+}
+
 // ReadControlMessage reads a control message from the reader
 func ReadControlMessage(r io.Reader) (opcode byte, nonce uint64, err error) {
 	opcode, err = readByte(r)
@@ -58,8 +256,16 @@ func ReadControlMessage(r io.Reader) (opcode byte, nonce uint64, err error) {
 		if err != nil {
 			return opcode, 0, fmt.Errorf("failed to read nonce: %w", err)
 		}
-	case OpShutdown:
-		// No additional data for shutdown
+	case OpShutdown, OpFlushDNS, OpByteReport:
+		// No additional data
+	case OpHello:
+		// The caller reads the rest with ReadHello
+	case OpByteCounts:
+		// The caller reads the rest with ReadByteCounts
+	case OpLog:
+		// The caller reads the rest with ReadLogLine
+	case OpExpiring:
+		// The caller reads the rest with ReadExpiring
 	default:
 		return opcode, 0, fmt.Errorf("unknown opcode: %02x", opcode)
 	}
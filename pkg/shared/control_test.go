@@ -2,7 +2,11 @@ package shared
 
 import (
 	"bytes"
+	"errors"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 )
 
 func TestPingPongRoundTrip(t *testing.T) {
@@ -81,6 +85,122 @@ func TestShutdownMessage(t *testing.T) {
 	}
 }
 
+func TestFlushDNSMessage(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFlushDNS(&buf); err != nil {
+		t.Fatalf("WriteFlushDNS failed: %v", err)
+	}
+	WritePing(&buf, 7)
+	
+	opcode, _, err := ReadControlMessage(&buf)
+	if err != nil {
+		t.Fatalf("ReadControlMessage failed: %v", err)
+	}
+	if opcode != OpFlushDNS {
+		t.Errorf("Expected OpFlushDNS (0x%02x), got 0x%02x", OpFlushDNS, opcode)
+	}
+	
+	// The flush carries no payload, so the next message follows directly
+	opcode, nonce, err := ReadControlMessage(&buf)
+	if err != nil || opcode != OpPing || nonce != 7 {
+		t.Errorf("Expected ping 7 after flush, got 0x%02x %d (%v)", opcode, nonce, err)
+	}
+}
+
+func TestHelloRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	sent := Hello{Version: 3, MinVersion: 2, Capabilities: CapDNS | CapUDP}
+	if err := WriteHello(&buf, sent); err != nil {
+		t.Fatalf("WriteHello failed: %v", err)
+	}
+	WritePing(&buf, 9)
+	
+	opcode, _, err := ReadControlMessage(&buf)
+	if err != nil || opcode != OpHello {
+		t.Fatalf("Expected OpHello, got 0x%02x (%v)", opcode, err)
+	}
+	got, err := ReadHello(&buf)
+	if err != nil || got != sent {
+		t.Fatalf("ReadHello = %+v, %v; expected %+v", got, err, sent)
+	}
+	if !got.Supports(CapDNS) || got.Supports(CapDNS|CapFlushDNS) {
+		t.Errorf("Unexpected capabilities %b", got.Capabilities)
+	}
+	if opcode, nonce, err := ReadControlMessage(&buf); err != nil || opcode != OpPing || nonce != 9 {
+		t.Errorf("Expected ping 9 after the hello, got 0x%02x %d (%v)", opcode, nonce, err)
+	}
+}
+
+func TestByteCountsRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	WriteByteReport(&buf)
+	sent := []StreamBytes{
+		{StreamID: 4, Received: 1200, Sent: 56789, ReceivedEOF: true},
+		{StreamID: 8, Received: 3, Sent: 0},
+	}
+	if err := WriteByteCounts(&buf, sent); err != nil {
+		t.Fatalf("WriteByteCounts failed: %v", err)
+	}
+	WritePing(&buf, 3)
+	
+	if opcode, _, err := ReadControlMessage(&buf); err != nil || opcode != OpByteReport {
+		t.Fatalf("Expected OpByteReport, got 0x%02x (%v)", opcode, err)
+	}
+	if opcode, _, err := ReadControlMessage(&buf); err != nil || opcode != OpByteCounts {
+		t.Fatalf("Expected OpByteCounts, got 0x%02x (%v)", opcode, err)
+	}
+	got, err := ReadByteCounts(&buf)
+	if err != nil || len(got) != len(sent) || got[0] != sent[0] || got[1] != sent[1] {
+		t.Fatalf("ReadByteCounts = %+v, %v; expected %+v", got, err, sent)
+	}
+	if opcode, nonce, err := ReadControlMessage(&buf); err != nil || opcode != OpPing || nonce != 3 {
+		t.Errorf("Expected ping 3 after the counts, got 0x%02x %d (%v)", opcode, nonce, err)
+	}
+	
+	if err := WriteByteCounts(&buf, make([]StreamBytes, MaxByteCounts+1)); err == nil {
+		t.Error("Expected an error writing too many counts")
+	}
+}
+
+func TestLogLineRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	WriteLogLine(&buf, LevelWarn, "dial example.com:443 failed")
+	WriteLogLine(&buf, LevelDebug, strings.Repeat("é", MaxLogLine))
+	WritePing(&buf, 5)
+	
+	if opcode, _, err := ReadControlMessage(&buf); err != nil || opcode != OpLog {
+		t.Fatalf("Expected OpLog, got 0x%02x (%v)", opcode, err)
+	}
+	if level, line, err := ReadLogLine(&buf); err != nil || level != LevelWarn || line != "dial example.com:443 failed" {
+		t.Fatalf("ReadLogLine = %v %q, %v", level, line, err)
+	}
+	ReadControlMessage(&buf)
+	level, line, err := ReadLogLine(&buf)
+	if err != nil || level != LevelDebug || len(line) > MaxLogLine || !utf8.ValidString(line) {
+		t.Fatalf("Expected a long line cut to valid UTF-8, got %v %d bytes, %v", level, len(line), err)
+	}
+	if opcode, nonce, err := ReadControlMessage(&buf); err != nil || opcode != OpPing || nonce != 5 {
+		t.Errorf("Expected ping 5 after the log lines, got 0x%02x %d (%v)", opcode, nonce, err)
+	}
+}
+
+func TestCheckHello(t *testing.T) {
+	local := Hello{Version: 3, MinVersion: 2}
+	if err := CheckHello(local, Hello{Version: 2, MinVersion: 1}); err != nil {
+		t.Errorf("Expected overlapping versions to work, got %v", err)
+	}
+	
+	var mismatch *ProtocolMismatchError
+	err := CheckHello(local, Hello{Version: 1, MinVersion: 1})
+	if !errors.As(err, &mismatch) || !mismatch.PeerOutdated() {
+		t.Errorf("Expected an outdated peer, got %v", err)
+	}
+	err = CheckHello(local, Hello{Version: 5, MinVersion: 4})
+	if !errors.As(err, &mismatch) || mismatch.PeerOutdated() {
+		t.Errorf("Expected this side to be outdated, got %v", err)
+	}
+}
+
 func TestUnknownOpcode(t *testing.T) {
 	var buf bytes.Buffer
 	
@@ -92,4 +212,22 @@ func TestUnknownOpcode(t *testing.T) {
 	if err == nil {
 		t.Error("Expected error for unknown opcode, got nil")
 	}
-}
\ No newline at end of file
+}
+func TestExpiringRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	WriteExpiring(&buf, 15*time.Second)
+	WriteExpiring(&buf, -time.Second)
+	WritePing(&buf, 7)
+	
+	for _, want := range []time.Duration{15 * time.Second, 0} {
+		if opcode, _, err := ReadControlMessage(&buf); err != nil || opcode != OpExpiring {
+			t.Fatalf("Expected OpExpiring, got 0x%02x (%v)", opcode, err)
+		}
+		if remaining, err := ReadExpiring(&buf); err != nil || remaining != want {
+			t.Fatalf("ReadExpiring = %v, %v; expected %v", remaining, err, want)
+		}
+	}
+	if opcode, nonce, err := ReadControlMessage(&buf); err != nil || opcode != OpPing || nonce != 7 {
+		t.Errorf("Expected ping 7 after the expiring messages, got 0x%02x %d (%v)", opcode, nonce, err)
+	}
+}
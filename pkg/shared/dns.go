@@ -0,0 +1,537 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DNS resolver constants
+const (
+	DefaultDNSMaxTTL   = 5 * time.Minute // cap on how long answers are cached
+	DNSQueryTimeout    = 5 * time.Second
+	maxDNSCacheEntries = 4096
+	maxDNSMessageSize  = 65535
+	dnsUDPBufferSize   = 4096
+)
+
+// DNS upstream schemes
+const (
+	DNSSchemeUDP   = "udp"
+	DNSSchemeTCP   = "tcp"
+	DNSSchemeTLS   = "tls"   // DNS over TLS
+	DNSSchemeHTTPS = "https" // DNS over HTTPS
+)
+
+// DNSStreamTarget is the stream header target of a stream carrying DNS
+// queries for the Lambda's resolver, each framed as in DNS over TCP
+const DNSStreamTarget = "dns/"
+
+// resolvConfPath lists the system's DNS servers
+const resolvConfPath = "/etc/resolv.conf"
+
+// DNSConfig selects how the Lambda resolves target domains. It travels to the
+// Lambda inside SessionSettings.
+type DNSConfig struct {
+	// Upstream is the resolver to query: "1.1.1.1" or "udp://1.1.1.1:53",
+	// "tcp://1.1.1.1", "tls://1.1.1.1" (DoT) or "https://1.1.1.1/dns-query" (DoH)
+	Upstream string `json:"upstream,omitempty"`
+
+	// MaxTTL caps how long answers are cached (0 = DefaultDNSMaxTTL)
+	MaxTTL time.Duration `json:"max_ttl,omitempty"`
+}
+
+// dnsUpstream is a parsed DNSConfig.Upstream
+type dnsUpstream struct {
+	scheme  string
+	address string // host:port for udp, tcp and tls
+	url     string // for https
+	host    string // TLS server name
+}
+
+// DNSResolver resolves domains with an explicit upstream and caches the
+// answers for their TTL. A nil *DNSResolver defers to the system resolver.
+type DNSResolver struct {
+	upstream dnsUpstream
+	maxTTL   time.Duration
+	client   *http.Client // for DoH
+
+	mu    sync.Mutex
+	cache map[string]dnsCacheEntry
+}
+
+type dnsCacheEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// NewDNSResolver creates a resolver for cfg. It returns nil (system resolver)
+// when no upstream is configured.
+func NewDNSResolver(cfg DNSConfig) (*DNSResolver, error) {
+	if cfg.Upstream == "" {
+		return nil, nil
+	}
+	upstream, err := parseDNSUpstream(cfg.Upstream)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxTTL < 0 {
+		return nil, fmt.Errorf("DNS max TTL cannot be negative")
+	}
+	maxTTL := cfg.MaxTTL
+	if maxTTL == 0 {
+		maxTTL = DefaultDNSMaxTTL
+	}
+	return &DNSResolver{
+		upstream: upstream,
+		maxTTL:   maxTTL,
+		client:   &http.Client{Timeout: DNSQueryTimeout},
+		cache:    make(map[string]dnsCacheEntry),
+	}, nil
+}
+
+// parseDNSUpstream parses an upstream like "tls://1.1.1.1"
+func parseDNSUpstream(s string) (dnsUpstream, error) {
+	if !strings.Contains(s, "://") {
+		s = DNSSchemeUDP + "://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return dnsUpstream{}, fmt.Errorf("invalid DNS upstream %q: %w", s, err)
+	}
+	if u.Hostname() == "" {
+		return dnsUpstream{}, fmt.Errorf("invalid DNS upstream %q: missing host", s)
+	}
+
+	upstream := dnsUpstream{scheme: u.Scheme, host: u.Hostname()}
+	defaultPort := "53"
+	switch u.Scheme {
+	case DNSSchemeUDP, DNSSchemeTCP:
+	case DNSSchemeTLS:
+		defaultPort = "853"
+	case DNSSchemeHTTPS:
+		upstream.url = u.String()
+		return upstream, nil
+	default:
+		return dnsUpstream{}, fmt.Errorf("invalid DNS upstream %q: scheme must be udp, tcp, tls or https", s)
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultPort
+	}
+	upstream.address = net.JoinHostPort(u.Hostname(), port)
+	return upstream, nil
+}
+
+// ResolveError is returned by LookupIP when a name doesn't resolve
+type ResolveError struct {
+	Name string
+	Err  error
+}
+
+func (e *ResolveError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ResolveError) Unwrap() error {
+	return e.Err
+}
+
+// LookupIP returns the addresses of host, IPv4 first, from the cache or the
+// upstream
+func (r *DNSResolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	name := strings.ToLower(strings.TrimSuffix(host, "."))
+	if ip := net.ParseIP(name); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	r.mu.Lock()
+	entry, ok := r.cache[name]
+	r.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.ips, nil
+	}
+
+	ips, ttl, err := r.resolve(ctx, name)
+	if err != nil {
+		return nil, &ResolveError{Name: name, Err: err}
+	}
+	if ttl > r.maxTTL {
+		ttl = r.maxTTL
+	}
+	if ttl > 0 {
+		r.store(name, ips, ttl)
+	}
+	return ips, nil
+}
+
+// store caches ips for ttl, dropping expired entries when the cache is full
+func (r *DNSResolver) store(name string, ips []net.IP, ttl time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if len(r.cache) >= maxDNSCacheEntries {
+		for key, entry := range r.cache {
+			if now.After(entry.expires) {
+				delete(r.cache, key)
+			}
+		}
+		if len(r.cache) >= maxDNSCacheEntries {
+			return
+		}
+	}
+	r.cache[name] = dnsCacheEntry{ips: ips, expires: now.Add(ttl)}
+}
+
+// Flush empties the cache and returns how many entries it held
+func (r *DNSResolver) Flush() int {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := len(r.cache)
+	r.cache = make(map[string]dnsCacheEntry)
+	return n
+}
+
+// Len returns the number of cached names
+func (r *DNSResolver) Len() int {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.cache)
+}
+
+// DNSExchangeFunc sends a packed DNS query and returns the packed response
+type DNSExchangeFunc func(ctx context.Context, query []byte) ([]byte, error)
+
+// resolve queries A and AAAA records for name upstream
+func (r *DNSResolver) resolve(ctx context.Context, name string) ([]net.IP, time.Duration, error) {
+	return ResolveWith(ctx, r.exchange, name)
+}
+
+// ResolveWith queries A and AAAA records for name in parallel with exchange
+// and returns the addresses, IPv4 first, with the lowest TTL among them
+func ResolveWith(ctx context.Context, exchange DNSExchangeFunc, name string) ([]net.IP, time.Duration, error) {
+	fqdn, err := dnsmessage.NewName(name + ".")
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid domain %q: %w", name, err)
+	}
+
+	type result struct {
+		ips []net.IP
+		ttl time.Duration
+		err error
+	}
+	results := make(chan result, 2)
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		go func(qtype dnsmessage.Type) {
+			ips, ttl, err := queryWith(ctx, exchange, fqdn, qtype)
+			results <- result{ips, ttl, err}
+		}(qtype)
+	}
+
+	var ips []net.IP
+	var ttl time.Duration
+	var firstErr error
+	for i := 0; i < 2; i++ {
+		res := <-results
+		if res.err != nil {
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			continue
+		}
+		if len(res.ips) > 0 && (ips == nil || res.ttl < ttl) {
+			ttl = res.ttl
+		}
+		ips = append(ips, res.ips...)
+	}
+	if len(ips) == 0 {
+		if firstErr == nil {
+			firstErr = fmt.Errorf("no addresses for %s", name)
+		}
+		return nil, 0, fmt.Errorf("failed to resolve %s: %w", name, firstErr)
+	}
+
+	// IPv4 first: most Lambdas have no IPv6 egress
+	sort.SliceStable(ips, func(i, j int) bool {
+		return ips[i].To4() != nil && ips[j].To4() == nil
+	})
+	return ips, ttl, nil
+}
+
+// queryWith sends one question with exchange and returns the matching answers
+func queryWith(ctx context.Context, exchange DNSExchangeFunc, name dnsmessage.Name, qtype dnsmessage.Type) ([]net.IP, time.Duration, error) {
+	var id [2]byte
+	rand.Read(id[:])
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: binary.BigEndian.Uint16(id[:]), RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  name,
+			Type:  qtype,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to build DNS query: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, DNSQueryTimeout)
+	defer cancel()
+	response, err := exchange(ctx, packed)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var reply dnsmessage.Message
+	if err := reply.Unpack(response); err != nil {
+		return nil, 0, fmt.Errorf("invalid DNS response: %w", err)
+	}
+	if reply.ID != msg.ID {
+		return nil, 0, fmt.Errorf("DNS response ID mismatch")
+	}
+	switch reply.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, 0, fmt.Errorf("no such host %s", strings.TrimSuffix(name.String(), "."))
+	default:
+		return nil, 0, fmt.Errorf("DNS server returned %v", reply.RCode)
+	}
+
+	var ips []net.IP
+	var ttl uint32
+	for _, answer := range reply.Answers {
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			ips = append(ips, net.IP(body.A[:]))
+		case *dnsmessage.AAAAResource:
+			ips = append(ips, net.IP(body.AAAA[:]))
+		default:
+			continue // CNAMEs etc.
+		}
+		if len(ips) == 1 || answer.Header.TTL < ttl {
+			ttl = answer.Header.TTL
+		}
+	}
+	return ips, time.Duration(ttl) * time.Second, nil
+}
+
+// Exchange forwards a raw DNS query upstream and returns the raw response,
+// bypassing the cache
+func (r *DNSResolver) Exchange(ctx context.Context, query []byte) ([]byte, error) {
+	if len(query) < 12 {
+		return nil, fmt.Errorf("DNS query too short: %d bytes", len(query))
+	}
+	ctx, cancel := context.WithTimeout(ctx, DNSQueryTimeout)
+	defer cancel()
+	return r.exchange(ctx, query)
+}
+
+// exchange sends a packed query over the upstream's transport
+func (r *DNSResolver) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	switch r.upstream.scheme {
+	case DNSSchemeHTTPS:
+		return r.exchangeHTTPS(ctx, query)
+	case DNSSchemeUDP:
+		response, err := r.exchangeUDP(ctx, query)
+		if err == nil && len(response) > 2 && response[2]&0x02 != 0 {
+			// Truncated: retry over TCP
+			return r.exchangeStream(ctx, query, false)
+		}
+		return response, err
+	default:
+		return r.exchangeStream(ctx, query, r.upstream.scheme == DNSSchemeTLS)
+	}
+}
+
+func (r *DNSResolver) exchangeUDP(ctx context.Context, query []byte) ([]byte, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", r.upstream.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach DNS server %s: %w", r.upstream.address, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, fmt.Errorf("failed to send DNS query: %w", err)
+	}
+	buf := make([]byte, dnsUDPBufferSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read DNS response: %w", err)
+		}
+		// Ignore stray packets with the wrong ID
+		if n >= 2 && bytes.Equal(buf[:2], query[:2]) {
+			return buf[:n], nil
+		}
+	}
+}
+
+// exchangeStream sends a length-prefixed query over TCP, or TLS for DoT
+func (r *DNSResolver) exchangeStream(ctx context.Context, query []byte, useTLS bool) ([]byte, error) {
+	var conn net.Conn
+	var err error
+	if useTLS {
+		dialer := &tls.Dialer{Config: &tls.Config{ServerName: r.upstream.host}}
+		conn, err = dialer.DialContext(ctx, "tcp", r.upstream.address)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", r.upstream.address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach DNS server %s: %w", r.upstream.address, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if err := WriteDNSMessage(conn, query); err != nil {
+		return nil, fmt.Errorf("failed to send DNS query: %w", err)
+	}
+	response, err := ReadDNSMessage(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DNS response: %w", err)
+	}
+	return response, nil
+}
+
+// WriteDNSMessage writes msg with the 2-byte length prefix used by DNS over TCP
+func WriteDNSMessage(w io.Writer, msg []byte) error {
+	if len(msg) > maxDNSMessageSize {
+		return fmt.Errorf("DNS message too long: %d bytes", len(msg))
+	}
+	framed := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(framed, uint16(len(msg)))
+	copy(framed[2:], msg)
+	_, err := w.Write(framed)
+	return err
+}
+
+// ReadDNSMessage reads one length-prefixed DNS message
+func ReadDNSMessage(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// DNSFailure turns query into a SERVFAIL response to it, e.g. when it could
+// not be forwarded. It returns nil if query is too short to answer.
+func DNSFailure(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	response := append([]byte(nil), query...)
+	response[2] |= 0x80                                                  // QR: response
+	response[3] = response[3]&0xf0 | byte(dnsmessage.RCodeServerFailure) // keep RA/Z bits
+	return response
+}
+
+// SystemDNSUpstream returns the first nameserver in /etc/resolv.conf, as an
+// upstream for NewDNSResolver
+func SystemDNSUpstream() (string, error) {
+	data, err := os.ReadFile(resolvConfPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", resolvConfPath, err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			if ip := net.ParseIP(fields[1]); ip != nil {
+				return net.JoinHostPort(ip.String(), "53"), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no nameserver in %s", resolvConfPath)
+}
+
+// exchangeHTTPS sends the query as an RFC 8484 POST
+func (r *DNSResolver) exchangeHTTPS(ctx context.Context, query []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.upstream.url, bytes.NewReader(query))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build DoH request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach DoH server: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH server returned %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxDNSMessageSize))
+}
+
+// DialContext connects to target, checking it and every address it resolves
+// to against acls. Domains are resolved with r; a nil r uses the system
+// resolver.
+func (r *DNSResolver) DialContext(ctx context.Context, network, target string, acls ACLs) (net.Conn, error) {
+	if r == nil {
+		return acls.DialContext(ctx, network, target)
+	}
+	if err := acls.Check(target); err != nil {
+		return nil, err
+	}
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target %q: %w", target, err)
+	}
+	ips, err := r.LookupIP(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var dialer net.Dialer
+	var firstErr error
+	for _, ip := range ips {
+		address := net.JoinHostPort(ip.String(), port)
+		if err := acls.CheckResolved(target, address); err != nil {
+			if firstErr == nil || !errors.Is(firstErr, ErrACLDenied) {
+				firstErr = err
+			}
+			continue
+		}
+		conn, err := dialer.DialContext(ctx, network, address)
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
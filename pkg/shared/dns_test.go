@@ -0,0 +1,170 @@
+package shared
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// startTestDNSServer answers A queries for every name with 10.0.0.1 and ttl,
+// and AAAA queries with no answers. It returns its address and query count.
+func startTestDNSServer(t *testing.T, ttl uint32) (string, *atomic.Int64) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	var queries atomic.Int64
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if err := query.Unpack(buf[:n]); err != nil || len(query.Questions) != 1 {
+				continue
+			}
+			queries.Add(1)
+
+			question := query.Questions[0]
+			reply := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.ID, Response: true},
+				Questions: query.Questions,
+			}
+			if strings.HasPrefix(question.Name.String(), "missing.") {
+				reply.RCode = dnsmessage.RCodeNameError
+			} else if question.Type == dnsmessage.TypeA {
+				reply.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: ttl},
+					Body:   &dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}},
+				}}
+			}
+			packed, _ := reply.Pack()
+			conn.WriteTo(packed, addr)
+		}
+	}()
+	return conn.LocalAddr().String(), &queries
+}
+
+func TestDNSResolverCachesAnswers(t *testing.T) {
+	addr, queries := startTestDNSServer(t, 60)
+	resolver, err := NewDNSResolver(DNSConfig{Upstream: addr})
+	if err != nil {
+		t.Fatalf("NewDNSResolver failed: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		ips, err := resolver.LookupIP(context.Background(), "Example.COM")
+		if err != nil {
+			t.Fatalf("LookupIP failed: %v", err)
+		}
+		if len(ips) != 1 || !ips[0].Equal(net.IPv4(10, 0, 0, 1)) {
+			t.Fatalf("Expected [10.0.0.1], got %v", ips)
+		}
+	}
+	if got := queries.Load(); got != 2 {
+		t.Errorf("Expected one A and one AAAA query, got %d queries", got)
+	}
+	if resolver.Len() != 1 {
+		t.Errorf("Expected 1 cached name, got %d", resolver.Len())
+	}
+
+	if flushed := resolver.Flush(); flushed != 1 {
+		t.Errorf("Expected Flush to drop 1 entry, got %d", flushed)
+	}
+	if _, err := resolver.LookupIP(context.Background(), "example.com"); err != nil {
+		t.Fatalf("LookupIP after flush failed: %v", err)
+	}
+	if got := queries.Load(); got != 4 {
+		t.Errorf("Expected a fresh lookup after flush, got %d queries", got)
+	}
+}
+
+func TestDNSResolverZeroTTLNotCached(t *testing.T) {
+	addr, queries := startTestDNSServer(t, 0)
+	resolver, _ := NewDNSResolver(DNSConfig{Upstream: "udp://" + addr})
+
+	resolver.LookupIP(context.Background(), "example.com")
+	resolver.LookupIP(context.Background(), "example.com")
+	if got := queries.Load(); got != 4 {
+		t.Errorf("Expected zero-TTL answers to be looked up again, got %d queries", got)
+	}
+}
+
+func TestDNSResolverMaxTTL(t *testing.T) {
+	addr, _ := startTestDNSServer(t, 3600)
+	resolver, _ := NewDNSResolver(DNSConfig{Upstream: addr, MaxTTL: time.Minute})
+
+	if _, err := resolver.LookupIP(context.Background(), "example.com"); err != nil {
+		t.Fatalf("LookupIP failed: %v", err)
+	}
+	entry := resolver.cache["example.com"]
+	if remaining := time.Until(entry.expires); remaining > time.Minute {
+		t.Errorf("Expected TTL capped at 1m, got %v", remaining)
+	}
+}
+
+func TestDNSResolverNameError(t *testing.T) {
+	addr, _ := startTestDNSServer(t, 60)
+	resolver, _ := NewDNSResolver(DNSConfig{Upstream: addr})
+
+	if _, err := resolver.LookupIP(context.Background(), "missing.example.com"); err == nil {
+		t.Error("Expected error for NXDOMAIN")
+	}
+	if resolver.Len() != 0 {
+		t.Error("Expected failed lookups not to be cached")
+	}
+}
+
+func TestDNSResolverDialChecksACL(t *testing.T) {
+	addr, _ := startTestDNSServer(t, 60)
+	resolver, _ := NewDNSResolver(DNSConfig{Upstream: addr})
+	acl, _ := NewACL(ACLConfig{Deny: []string{"10.0.0.0/8"}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := resolver.DialContext(ctx, "tcp", "internal.example.com:80", ACLs{acl})
+	if err == nil || !strings.Contains(err.Error(), "10.0.0.0/8") {
+		t.Errorf("Expected denial of resolved address, got %v", err)
+	}
+}
+
+func TestNewDNSResolver(t *testing.T) {
+	tests := []struct {
+		upstream string
+		address  string
+		wantErr  bool
+	}{
+		{"1.1.1.1", "1.1.1.1:53", false},
+		{"udp://1.1.1.1:5353", "1.1.1.1:5353", false},
+		{"tcp://[2606:4700::1111]", "[2606:4700::1111]:53", false},
+		{"tls://one.one.one.one", "one.one.one.one:853", false},
+		{"https://1.1.1.1/dns-query", "", false},
+		{"quic://1.1.1.1", "", true},
+		{"udp://", "", true},
+	}
+
+	for _, tt := range tests {
+		resolver, err := NewDNSResolver(DNSConfig{Upstream: tt.upstream})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewDNSResolver(%q) error = %v, wantErr %v", tt.upstream, err, tt.wantErr)
+			continue
+		}
+		if err == nil && resolver.upstream.address != tt.address {
+			t.Errorf("NewDNSResolver(%q) address = %q, want %q", tt.upstream, resolver.upstream.address, tt.address)
+		}
+	}
+
+	if resolver, err := NewDNSResolver(DNSConfig{}); resolver != nil || err != nil {
+		t.Errorf("Expected nil resolver for empty config, got %v, %v", resolver, err)
+	}
+}
@@ -0,0 +1,161 @@
+package shared
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Lambda metrics defaults
+const (
+	DefaultLambdaMetricsNamespace = "LambdaNatProxy"
+	DefaultLambdaMetricsInterval  = time.Minute
+
+	// emfMaxValues is the most values CloudWatch accepts for one metric in one record
+	emfMaxValues = 100
+)
+
+// Lambda-side metric names
+const (
+	MetricStreamsHandled   = "StreamsHandled"
+	MetricBytesTransferred = "BytesTransferred"
+	MetricDialFailures     = "DialFailures"
+	MetricDialsDenied      = "DialsDenied"
+	MetricDialLatency      = "DialLatency"
+)
+
+var emfNamespacePattern = regexp.MustCompile(`^[.\-_/#:A-Za-z0-9]{1,255}$`)
+
+// LambdaMetricsConfig has the Lambda publish CloudWatch metrics in the
+// embedded metric format (EMF): JSON lines in its log that CloudWatch Logs
+// turns into metrics, so no extra API calls or permissions are needed
+type LambdaMetricsConfig struct {
+	Namespace string        `json:"namespace"`
+	Interval  time.Duration `json:"interval,omitempty"` // 0 = DefaultLambdaMetricsInterval
+}
+
+// Validate checks the namespace and interval
+func (cfg LambdaMetricsConfig) Validate() error {
+	if !emfNamespacePattern.MatchString(cfg.Namespace) {
+		return fmt.Errorf("invalid CloudWatch namespace %q", cfg.Namespace)
+	}
+	if cfg.Interval < 0 || (cfg.Interval > 0 && cfg.Interval < time.Second) {
+		return fmt.Errorf("invalid metrics interval %v: must be at least 1s", cfg.Interval)
+	}
+	return nil
+}
+
+// EMFRecorder aggregates counters and latency samples and writes them as one
+// EMF record per flush. Its methods are safe for concurrent use and do
+// nothing on a nil recorder.
+type EMFRecorder struct {
+	namespace  string
+	dimensions map[string]string // metric dimensions, e.g. FunctionName
+	properties map[string]string // extra fields searchable in Logs Insights, not dimensions
+	out        io.Writer
+
+	mu       sync.Mutex
+	counters map[string]float64
+	samples  map[string][]float64
+}
+
+// NewEMFRecorder creates a recorder writing to out
+func NewEMFRecorder(namespace string, dimensions, properties map[string]string, out io.Writer) *EMFRecorder {
+	return &EMFRecorder{
+		namespace:  namespace,
+		dimensions: dimensions,
+		properties: properties,
+		out:        out,
+		counters:   make(map[string]float64),
+		samples:    make(map[string][]float64),
+	}
+}
+
+// Add adds n to a counter
+func (r *EMFRecorder) Add(name string, n float64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.counters[name] += n
+	r.mu.Unlock()
+}
+
+// Observe records a duration sample in milliseconds
+func (r *EMFRecorder) Observe(name string, d time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.samples[name] = append(r.samples[name], float64(d)/float64(time.Millisecond))
+	r.mu.Unlock()
+}
+
+// Flush writes what was recorded since the last flush as one EMF line and
+// resets it. Counters are written even when zero, so alarms see a datapoint
+// for every interval the Lambda was up.
+func (r *EMFRecorder) Flush() error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	counters, samples := r.counters, r.samples
+	r.counters = make(map[string]float64)
+	r.samples = make(map[string][]float64)
+	r.mu.Unlock()
+
+	record := make(map[string]interface{})
+	for k, v := range r.properties {
+		record[k] = v
+	}
+	dimensionKeys := make([]string, 0, len(r.dimensions))
+	for k, v := range r.dimensions {
+		record[k] = v
+		dimensionKeys = append(dimensionKeys, k)
+	}
+	sort.Strings(dimensionKeys)
+
+	type metricDefinition struct {
+		Name string `json:"Name"`
+		Unit string `json:"Unit"`
+	}
+	var definitions []metricDefinition
+	for _, name := range []string{MetricStreamsHandled, MetricDialFailures, MetricDialsDenied} {
+		definitions = append(definitions, metricDefinition{name, "Count"})
+		record[name] = counters[name]
+	}
+	definitions = append(definitions, metricDefinition{MetricBytesTransferred, "Bytes"})
+	record[MetricBytesTransferred] = counters[MetricBytesTransferred]
+
+	// CloudWatch drops a record whose metric has an empty array, so latency
+	// is left out of intervals without dials
+	if values := samples[MetricDialLatency]; len(values) > 0 {
+		if len(values) > emfMaxValues {
+			values = values[len(values)-emfMaxValues:]
+		}
+		definitions = append(definitions, metricDefinition{MetricDialLatency, "Milliseconds"})
+		record[MetricDialLatency] = values
+	}
+
+	record["_aws"] = map[string]interface{}{
+		"Timestamp": time.Now().UnixMilli(),
+		"CloudWatchMetrics": []map[string]interface{}{{
+			"Namespace":  r.namespace,
+			"Dimensions": [][]string{dimensionKeys},
+			"Metrics":    definitions,
+		}},
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode metrics: %w", err)
+	}
+	if _, err := r.out.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestEMFRecorderFlush(t *testing.T) {
+	var out bytes.Buffer
+	r := NewEMFRecorder("LambdaNatProxy", map[string]string{"FunctionName": "fn"}, map[string]string{"SessionId": "s1"}, &out)
+	r.Add(MetricStreamsHandled, 2)
+	r.Add(MetricBytesTransferred, 1500)
+	r.Add(MetricDialFailures, 1)
+	r.Observe(MetricDialLatency, 25*time.Millisecond)
+	if err := r.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+
+	var record struct {
+		AWS struct {
+			Timestamp         int64
+			CloudWatchMetrics []struct {
+				Namespace  string
+				Dimensions [][]string
+				Metrics    []struct{ Name, Unit string }
+			}
+		} `json:"_aws"`
+		FunctionName     string
+		SessionId        string
+		StreamsHandled   float64
+		BytesTransferred float64
+		DialFailures     float64
+		DialsDenied      float64
+		DialLatency      []float64
+	}
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("Expected one JSON record, got %q: %v", out.String(), err)
+	}
+	directive := record.AWS.CloudWatchMetrics[0]
+	if directive.Namespace != "LambdaNatProxy" || len(directive.Dimensions[0]) != 1 || directive.Dimensions[0][0] != "FunctionName" {
+		t.Errorf("Unexpected metric directive: %+v", directive)
+	}
+	if len(directive.Metrics) != 5 || record.AWS.Timestamp == 0 {
+		t.Errorf("Expected 5 metrics with a timestamp, got %+v", record.AWS)
+	}
+	if record.FunctionName != "fn" || record.SessionId != "s1" || record.StreamsHandled != 2 ||
+		record.BytesTransferred != 1500 || record.DialFailures != 1 || record.DialsDenied != 0 {
+		t.Errorf("Unexpected record: %+v", record)
+	}
+	if len(record.DialLatency) != 1 || record.DialLatency[0] != 25 {
+		t.Errorf("Expected one 25ms dial latency, got %v", record.DialLatency)
+	}
+
+	// Flushing resets the counters, and latency is left out without dials
+	out.Reset()
+	r.Flush()
+	var next map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &next); err != nil {
+		t.Fatalf("Failed to decode second record: %v", err)
+	}
+	if next[MetricStreamsHandled] != 0.0 {
+		t.Errorf("Expected counters to reset, got %v", next[MetricStreamsHandled])
+	}
+	if _, ok := next[MetricDialLatency]; ok {
+		t.Error("Expected no dial latency without dials")
+	}
+}
+
+func TestEMFRecorderNil(t *testing.T) {
+	var r *EMFRecorder
+	r.Add(MetricStreamsHandled, 1)
+	r.Observe(MetricDialLatency, time.Millisecond)
+	if err := r.Flush(); err != nil {
+		t.Errorf("Expected nil recorder to do nothing, got %v", err)
+	}
+}
+
+func TestLambdaMetricsConfigValidate(t *testing.T) {
+	if err := (LambdaMetricsConfig{Namespace: "Custom/Proxy"}).Validate(); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+	for _, cfg := range []LambdaMetricsConfig{
+		{},
+		{Namespace: "has space"},
+		{Namespace: "ok", Interval: 100 * time.Millisecond},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", cfg)
+		}
+	}
+}
@@ -0,0 +1,459 @@
+package shared
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// Stream frames replace the bare target string that opens every tunnel
+// stream. A frame is versioned and carries a command, a typed address and
+// optional settings, so new stream kinds don't need more string prefixes.
+//
+// Format: [4 bytes magic][1 byte version][1 byte command][1 byte address type]
+// [address][2 bytes port][2 bytes options length][options]
+//
+// The address is 4 bytes for IPv4, 16 for IPv6, a length byte and the name for
+// domains, and empty for AddrNone. Each option is [1 byte type][1 byte length]
+// [value]; unknown options are skipped so newer peers can add settings.
+//
+// The magic read as a legacy big-endian length is far above
+// MaxTargetAddressLength, so a reader tells the two formats apart by the first
+// four bytes. Peers only send frames when the QUIC handshake negotiated
+// StreamFrameProtocol; older peers keep getting the legacy header. The
+// Lambda answers a frame that sets OptReplyFrame with a StreamReply.
+
+// ALPN protocols offered on the tunnel's QUIC connection, preferred first
+const (
+	StreamFrameProtocol = "lnp-frame/1" // peer reads stream frames
+	LegacyProtocol      = "h3"          // peer only reads legacy target strings
+)
+
+// TunnelProtocols is the ALPN list both ends of the tunnel offer
+var TunnelProtocols = []string{StreamFrameProtocol, LegacyProtocol}
+
+// StreamFrameVersion is the frame version written by this build
+const StreamFrameVersion byte = 1
+
+var streamFrameMagic = [4]byte{0xFF, 'L', 'N', 'F'}
+
+// StreamCommand says what a tunnel stream is for. Commands from 0x80 up are
+// served by the Lambda itself and carry no address.
+type StreamCommand byte
+
+const (
+	StreamConnect StreamCommand = 0x01 // TCP connection to the address
+	StreamBind    StreamCommand = 0x02 // accept one inbound TCP connection (SOCKS5 BIND)
+	StreamUDP     StreamCommand = 0x03 // UDP relay to the address
+	StreamDNS     StreamCommand = 0x80 // DNS queries for the Lambda's resolver; no address
+	StreamEcho    StreamCommand = 0x81 // the Lambda sends back everything it reads
+	StreamDiscard StreamCommand = 0x82 // the Lambda reads and drops everything
+	StreamSource  StreamCommand = 0x83 // the Lambda writes until the stream is closed
+)
+
+// hasAddress reports whether streams for c name an address
+func (c StreamCommand) hasAddress() bool {
+	return c < StreamDNS
+}
+
+// Address types, numbered like SOCKS5's
+const (
+	AddrNone   byte = 0x00
+	AddrIPv4   byte = 0x01
+	AddrDomain byte = 0x03
+	AddrIPv6   byte = 0x04
+)
+
+// Frame option types
+const (
+	OptTraceparent byte = 0x01 // W3C traceparent of the connection that opened the stream
+	OptPriority    byte = 0x02 // 1 byte, higher is more urgent
+	OptBindAddress byte = 0x03 // local host:port the Lambda should bind or dial from
+	OptPinnedIP    byte = 0x04 // 4 or 16 byte address a domain target should be dialed at
+
+	OptConnectTimeout byte = 0x05 // 4 byte milliseconds the Lambda may spend dialing the target
+	OptKeepAlive      byte = 0x06 // 4 byte milliseconds between TCP keepalives to the target
+	OptReplyFrame     byte = 0x07 // empty; the opener reads reply frames (see StreamReply)
+	OptMaxLifetime    byte = 0x08 // 4 byte milliseconds after which the Lambda closes the stream
+	OptCompression    byte = 0x09 // 1 byte Compression of the payload after the reply
+)
+
+// StreamFrame is the parsed header of a tunnel stream
+type StreamFrame struct {
+	Command StreamCommand
+	Host    string // IP address or domain; empty for StreamDNS
+	Port    uint16
+
+	Traceparent string // optional
+	Priority    uint8  // optional, 0 = default
+	BindAddress string // optional
+	PinnedIP    string // optional, for domain targets: the address to dial
+
+	ConnectTimeout time.Duration // optional, 0 = the Lambda's default
+	KeepAlive      time.Duration // optional, 0 = the Lambda's default
+	MaxLifetime    time.Duration // optional, 0 = unlimited
+	Compression    Compression   // optional, sent only to Lambdas with CapCompression
+	ReplyFrame     bool          // set on every frame this build writes
+}
+
+// NewStreamFrame builds a frame for command to a host:port target
+func NewStreamFrame(command StreamCommand, target string) (StreamFrame, error) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return StreamFrame{}, fmt.Errorf("invalid stream target %q: %w", target, err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return StreamFrame{}, fmt.Errorf("invalid port in stream target %q", target)
+	}
+	return StreamFrame{Command: command, Host: host, Port: uint16(port)}, nil
+}
+
+// Target returns the frame's address as host:port, or "" if it has none
+func (f StreamFrame) Target() string {
+	if f.Host == "" {
+		return ""
+	}
+	return net.JoinHostPort(f.Host, strconv.Itoa(int(f.Port)))
+}
+
+// String describes the frame for logs
+func (f StreamFrame) String() string {
+	switch f.Command {
+	case StreamDNS:
+		return "dns"
+	case StreamUDP:
+		return "udp/" + f.Target()
+	case StreamBind:
+		return "bind/" + f.Target()
+	case StreamEcho:
+		return "echo"
+	case StreamDiscard:
+		return "discard"
+	case StreamSource:
+		return "source"
+	default:
+		return f.Target()
+	}
+}
+
+// MarshalBinary encodes the frame
+func (f StreamFrame) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(streamFrameMagic[:])
+	buf.WriteByte(StreamFrameVersion)
+	buf.WriteByte(byte(f.Command))
+
+	ip := net.ParseIP(f.Host)
+	switch {
+	case f.Host == "":
+		buf.WriteByte(AddrNone)
+	case ip != nil && ip.To4() != nil:
+		buf.WriteByte(AddrIPv4)
+		buf.Write(ip.To4())
+	case ip != nil:
+		buf.WriteByte(AddrIPv6)
+		buf.Write(ip.To16())
+	default:
+		if len(f.Host) > 255 {
+			return nil, fmt.Errorf("stream frame host too long: %d bytes", len(f.Host))
+		}
+		buf.WriteByte(AddrDomain)
+		buf.WriteByte(byte(len(f.Host)))
+		buf.WriteString(f.Host)
+	}
+	binary.Write(&buf, binary.BigEndian, f.Port)
+
+	options, err := f.options()
+	if err != nil {
+		return nil, err
+	}
+	binary.Write(&buf, binary.BigEndian, uint16(len(options)))
+	buf.Write(options)
+
+	return buf.Bytes(), nil
+}
+
+// options encodes the frame's options, failing if one of them or all of them
+// together are longer than a reader accepts
+func (f StreamFrame) options() (frameOptions, error) {
+	var options frameOptions
+	if err := options.putString(OptTraceparent, f.Traceparent); err != nil {
+		return nil, err
+	}
+	if f.Priority != 0 {
+		if err := options.put(OptPriority, []byte{f.Priority}); err != nil {
+			return nil, err
+		}
+	}
+	if err := options.putString(OptBindAddress, f.BindAddress); err != nil {
+		return nil, err
+	}
+	if pinned := net.ParseIP(f.PinnedIP); pinned != nil {
+		if ip4 := pinned.To4(); ip4 != nil {
+			pinned = ip4
+		}
+		if err := options.put(OptPinnedIP, pinned); err != nil {
+			return nil, err
+		}
+	}
+	for _, option := range []struct {
+		kind byte
+		d    time.Duration
+	}{
+		{OptConnectTimeout, f.ConnectTimeout},
+		{OptKeepAlive, f.KeepAlive},
+		{OptMaxLifetime, f.MaxLifetime},
+	} {
+		if err := options.putDuration(option.kind, option.d); err != nil {
+			return nil, err
+		}
+	}
+	if f.Compression != CompressionNone {
+		if err := options.put(OptCompression, []byte{byte(f.Compression)}); err != nil {
+			return nil, err
+		}
+	}
+	if f.ReplyFrame {
+		if err := options.put(OptReplyFrame, nil); err != nil {
+			return nil, err
+		}
+	}
+	if len(options) > MaxTargetAddressLength {
+		return nil, fmt.Errorf("stream frame options too long: %d bytes (max %d)", len(options), MaxTargetAddressLength)
+	}
+	return options, nil
+}
+
+// legacyHeader returns the target string older peers expect for the frame
+func (f StreamFrame) legacyHeader() (string, error) {
+	switch f.Command {
+	case StreamConnect:
+		return f.Target(), nil
+	case StreamUDP:
+		return UDPStreamTargetPrefix + f.Target(), nil
+	case StreamDNS:
+		return DNSStreamTarget, nil
+	default:
+		return "", fmt.Errorf("stream command %d needs a peer that supports stream frames", f.Command)
+	}
+}
+
+// WriteStreamHeader opens a tunnel stream with frame, encoded as a frame if
+// the peer supports them and as a legacy target string otherwise. The header
+// is sent in a single write.
+func WriteStreamHeader(stream io.Writer, frame StreamFrame, framed bool) error {
+	if !framed {
+		header, err := frame.legacyHeader()
+		if err != nil {
+			return err
+		}
+		return WriteSOCKS5TargetAddress(stream, header)
+	}
+
+	// Every reader in this build accepts reply frames
+	frame.ReplyFrame = true
+	data, err := frame.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if _, err := stream.Write(data); err != nil {
+		return fmt.Errorf("failed to write stream frame: %w", err)
+	}
+	return nil
+}
+
+// ReadStreamHeader reads the header that opens a tunnel stream, accepting
+// both stream frames and legacy target strings
+func ReadStreamHeader(stream io.Reader) (StreamFrame, error) {
+	var lead [4]byte
+	if _, err := io.ReadFull(stream, lead[:]); err != nil {
+		return StreamFrame{}, fmt.Errorf("failed to read stream header: %w", err)
+	}
+	if lead != streamFrameMagic {
+		header, err := ReadSOCKS5TargetAddress(io.MultiReader(bytes.NewReader(lead[:]), stream))
+		if err != nil {
+			return StreamFrame{}, err
+		}
+		return ParseLegacyHeader(header)
+	}
+	return readStreamFrame(stream)
+}
+
+// readStreamFrame reads the rest of a frame after its magic
+func readStreamFrame(stream io.Reader) (StreamFrame, error) {
+	var f StreamFrame
+	var head [3]byte
+	if _, err := io.ReadFull(stream, head[:]); err != nil {
+		return f, fmt.Errorf("failed to read stream frame: %w", err)
+	}
+	if head[0] != StreamFrameVersion {
+		return f, fmt.Errorf("unsupported stream frame version %d", head[0])
+	}
+	f.Command = StreamCommand(head[1])
+
+	switch head[2] {
+	case AddrNone:
+	case AddrIPv4, AddrIPv6:
+		ip := make(net.IP, 4)
+		if head[2] == AddrIPv6 {
+			ip = make(net.IP, 16)
+		}
+		if _, err := io.ReadFull(stream, ip); err != nil {
+			return f, fmt.Errorf("failed to read stream frame address: %w", err)
+		}
+		f.Host = ip.String()
+	case AddrDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(stream, n[:]); err != nil {
+			return f, fmt.Errorf("failed to read stream frame address: %w", err)
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(stream, name); err != nil {
+			return f, fmt.Errorf("failed to read stream frame address: %w", err)
+		}
+		f.Host = string(name)
+	default:
+		return f, fmt.Errorf("unsupported stream frame address type %d", head[2])
+	}
+
+	var tail [4]byte
+	if _, err := io.ReadFull(stream, tail[:]); err != nil {
+		return f, fmt.Errorf("failed to read stream frame: %w", err)
+	}
+	f.Port = binary.BigEndian.Uint16(tail[:2])
+	optionsLen := binary.BigEndian.Uint16(tail[2:])
+	if optionsLen > MaxTargetAddressLength {
+		return f, fmt.Errorf("stream frame options too long: %d bytes (max %d)", optionsLen, MaxTargetAddressLength)
+	}
+	options := make([]byte, optionsLen)
+	if _, err := io.ReadFull(stream, options); err != nil {
+		return f, fmt.Errorf("failed to read stream frame options: %w", err)
+	}
+
+	err := eachFrameOption(options, func(kind byte, value []byte) {
+		switch kind {
+		case OptTraceparent:
+			f.Traceparent = string(value)
+		case OptPriority:
+			if len(value) == 1 {
+				f.Priority = value[0]
+			}
+		case OptBindAddress:
+			f.BindAddress = string(value)
+		case OptPinnedIP:
+			if len(value) == net.IPv4len || len(value) == net.IPv6len {
+				f.PinnedIP = net.IP(value).String()
+			}
+		case OptConnectTimeout:
+			f.ConnectTimeout = durationOption(value)
+		case OptKeepAlive:
+			f.KeepAlive = durationOption(value)
+		case OptMaxLifetime:
+			f.MaxLifetime = durationOption(value)
+		case OptCompression:
+			if len(value) == 1 {
+				f.Compression = Compression(value[0])
+			}
+		case OptReplyFrame:
+			f.ReplyFrame = true
+		}
+	})
+	if err != nil {
+		return f, err
+	}
+
+	if f.Command.hasAddress() && f.Host == "" {
+		return f, fmt.Errorf("stream frame for command %d has no address", f.Command)
+	}
+	return f, nil
+}
+
+// frameOptions builds the option block of a stream or reply frame
+type frameOptions []byte
+
+// put appends an option
+func (o *frameOptions) put(kind byte, value []byte) error {
+	if len(value) > 255 {
+		return fmt.Errorf("frame option %d too long: %d bytes", kind, len(value))
+	}
+	*o = append(*o, kind, byte(len(value)))
+	*o = append(*o, value...)
+	return nil
+}
+
+// putString appends a text option unless value is empty
+func (o *frameOptions) putString(kind byte, value string) error {
+	if value == "" {
+		return nil
+	}
+	return o.put(kind, []byte(value))
+}
+
+// putDuration appends a duration option in milliseconds unless d is zero
+func (o *frameOptions) putDuration(kind byte, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	ms := d.Milliseconds()
+	if ms > int64(^uint32(0)) {
+		ms = int64(^uint32(0))
+	}
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value, uint32(ms))
+	return o.put(kind, value)
+}
+
+// durationOption decodes a duration option, or 0 if value isn't 4 bytes
+func durationOption(value []byte) time.Duration {
+	if len(value) != 4 {
+		return 0
+	}
+	return time.Duration(binary.BigEndian.Uint32(value)) * time.Millisecond
+}
+
+// eachFrameOption calls fn for every option in an option block. Unknown
+// options are passed on too, for fn to skip.
+func eachFrameOption(options []byte, fn func(kind byte, value []byte)) error {
+	for len(options) > 0 {
+		if len(options) < 2 || len(options) < 2+int(options[1]) {
+			return fmt.Errorf("truncated frame option")
+		}
+		kind, value := options[0], options[2:2+int(options[1])]
+		fn(kind, value)
+		options = options[2+len(value):]
+	}
+	return nil
+}
+
+// ParseLegacyHeader converts a legacy target string into a frame
+func ParseLegacyHeader(target string) (StreamFrame, error) {
+	if target == DNSStreamTarget {
+		return StreamFrame{Command: StreamDNS}, nil
+	}
+
+	command := StreamConnect
+	if strings.HasPrefix(target, UDPStreamTargetPrefix) {
+		command = StreamUDP
+		target = strings.TrimPrefix(target, UDPStreamTargetPrefix)
+	}
+	return NewStreamFrame(command, target)
+}
+
+// SupportsStreamFrames reports whether the peer on conn negotiated stream
+// frames. Openers that aren't QUIC connections, such as test fakes, get the
+// legacy header.
+func SupportsStreamFrames(conn interface{}) bool {
+	stater, ok := conn.(interface{ ConnectionState() quic.ConnectionState })
+	if !ok {
+		return false
+	}
+	return stater.ConnectionState().TLS.NegotiatedProtocol == StreamFrameProtocol
+}
@@ -0,0 +1,158 @@
+package shared
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamFrameRoundTrip(t *testing.T) {
+	frames := []StreamFrame{
+		{Command: StreamConnect, Host: "93.184.216.34", Port: 443},
+		{Command: StreamConnect, Host: "2001:db8::1", Port: 22, Priority: 7},
+		{Command: StreamUDP, Host: "example.com", Port: 53, BindAddress: "0.0.0.0:5353"},
+		{Command: StreamBind, Host: "10.0.0.1", Port: 0},
+		{Command: StreamDNS},
+		{Command: StreamEcho},
+		{Command: StreamSource},
+		{Command: StreamConnect, Host: "cdn.example.com", Port: 443, PinnedIP: "203.0.113.9"},
+		{Command: StreamConnect, Host: "cdn.example.com", Port: 443, PinnedIP: "2001:db8::9"},
+		{Command: StreamConnect, Host: "example.com", Port: 80,
+			Traceparent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+		{Command: StreamConnect, Host: "example.com", Port: 443,
+			ConnectTimeout: 2500 * time.Millisecond, KeepAlive: 30 * time.Second, MaxLifetime: time.Hour, Compression: CompressionDeflate},
+	}
+	for _, frame := range frames {
+		var buf bytes.Buffer
+		if err := WriteStreamHeader(&buf, frame, true); err != nil {
+			t.Fatalf("Failed to write %+v: %v", frame, err)
+		}
+		got, err := ReadStreamHeader(&buf)
+		if err != nil {
+			t.Fatalf("Failed to read %+v: %v", frame, err)
+		}
+		// Frames from this build ask for reply frames
+		frame.ReplyFrame = true
+		if got != frame {
+			t.Errorf("Expected %+v, got %+v", frame, got)
+		}
+	}
+}
+
+func TestStreamHeaderLegacy(t *testing.T) {
+	tests := []struct {
+		frame  StreamFrame
+		header string
+	}{
+		{StreamFrame{Command: StreamConnect, Host: "example.com", Port: 443}, "example.com:443"},
+		{StreamFrame{Command: StreamUDP, Host: "1.1.1.1", Port: 53}, "udp/1.1.1.1:53"},
+		{StreamFrame{Command: StreamDNS}, DNSStreamTarget},
+	}
+	for _, tt := range tests {
+		// Older peers get the target string they already understand
+		var buf bytes.Buffer
+		if err := WriteStreamHeader(&buf, tt.frame, false); err != nil {
+			t.Fatalf("Failed to write %+v: %v", tt.frame, err)
+		}
+		header, err := ReadSOCKS5TargetAddress(bytes.NewReader(buf.Bytes()))
+		if err != nil || header != tt.header {
+			t.Errorf("Expected legacy header %q, got %q (%v)", tt.header, header, err)
+		}
+
+		// and headers from older peers read as frames
+		got, err := ReadStreamHeader(&buf)
+		if err != nil || got != tt.frame {
+			t.Errorf("Expected %+v from %q, got %+v (%v)", tt.frame, tt.header, got, err)
+		}
+	}
+
+	// The trace context only travels in frames
+	var buf bytes.Buffer
+	traced := StreamFrame{Command: StreamConnect, Host: "example.com", Port: 443,
+		Traceparent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}
+	if err := WriteStreamHeader(&buf, traced, false); err != nil {
+		t.Fatalf("Failed to write %+v: %v", traced, err)
+	}
+	if header, err := ReadSOCKS5TargetAddress(&buf); err != nil || header != "example.com:443" {
+		t.Errorf("Expected legacy header without the traceparent, got %q (%v)", header, err)
+	}
+
+	// BIND and the throughput targets have no legacy form
+	if err := WriteStreamHeader(&bytes.Buffer{}, StreamFrame{Command: StreamBind, Host: "10.0.0.1"}, false); err == nil {
+		t.Error("Expected BIND to need stream frames")
+	}
+	if err := WriteStreamHeader(&bytes.Buffer{}, StreamFrame{Command: StreamDiscard}, false); err == nil {
+		t.Error("Expected the discard target to need stream frames")
+	}
+}
+
+func TestThroughputTargetCommand(t *testing.T) {
+	tests := map[string]StreamCommand{
+		"echo.lambda-nat-proxy.invalid:7":     StreamEcho,
+		"DISCARD.lambda-nat-proxy.invalid.:9": StreamDiscard,
+		"source.lambda-nat-proxy.invalid":     StreamSource,
+	}
+	for target, want := range tests {
+		if got, ok := ThroughputTargetCommand(target); !ok || got != want {
+			t.Errorf("Expected %s to be served by command %d, got %d (%v)", target, want, got, ok)
+		}
+	}
+	for _, target := range []string{"example.com:443", "lambda-nat-proxy.invalid:9", "echo.example.com:7"} {
+		if _, ok := ThroughputTargetCommand(target); ok {
+			t.Errorf("Expected %s not to be a throughput target", target)
+		}
+	}
+}
+
+func TestStreamFrameSkipsUnknownOptions(t *testing.T) {
+	data, err := StreamFrame{Command: StreamConnect, Host: "10.0.0.1", Port: 80}.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed to marshal frame: %v", err)
+	}
+	// Replace the empty option block with an option from a newer peer
+	data = append(data[:len(data)-2], 0, 4, 0x7f, 2, 'h', 'i')
+	frame, err := ReadStreamHeader(bytes.NewReader(data))
+	if err != nil || frame.Target() != "10.0.0.1:80" {
+		t.Errorf("Expected unknown option to be skipped, got %+v (%v)", frame, err)
+	}
+}
+
+func TestStreamFrameRejectsLongOptions(t *testing.T) {
+	// An option that doesn't fit its length byte fails the stream open
+	long := strings.Repeat("x", 256)
+	for name, frame := range map[string]StreamFrame{
+		"traceparent":  {Command: StreamConnect, Host: "10.0.0.1", Port: 80, Traceparent: long},
+		"bind address": {Command: StreamBind, Host: "10.0.0.1", BindAddress: long},
+	} {
+		if err := WriteStreamHeader(&bytes.Buffer{}, frame, true); err == nil {
+			t.Errorf("Expected error for a %d byte %s", len(long), name)
+		}
+	}
+}
+
+func TestStreamFrameRejectsBadInput(t *testing.T) {
+	valid, _ := StreamFrame{Command: StreamConnect, Host: "10.0.0.1", Port: 80}.MarshalBinary()
+
+	newerVersion := append([]byte(nil), valid...)
+	newerVersion[4] = StreamFrameVersion + 1
+
+	badAddress := append([]byte(nil), valid...)
+	badAddress[6] = 0x09
+
+	truncatedOption := append(append([]byte(nil), valid[:len(valid)-2]...), 0, 3, OptTraceparent, 5, 'x')
+
+	noAddress, _ := StreamFrame{Command: StreamConnect}.MarshalBinary()
+
+	for name, data := range map[string][]byte{
+		"newer version":    newerVersion,
+		"address type":     badAddress,
+		"truncated option": truncatedOption,
+		"missing address":  noAddress,
+		"truncated frame":  valid[:8],
+	} {
+		if _, err := ReadStreamHeader(bytes.NewReader(data)); err == nil {
+			t.Errorf("Expected error for %s", name)
+		}
+	}
+}
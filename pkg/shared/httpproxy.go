@@ -0,0 +1,98 @@
+package shared
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// HTTPProxyConfig routes outbound HTTP and HTTPS, such as AWS API calls and
+// OTLP exports, through a proxy. An empty URL falls back to the HTTP_PROXY,
+// HTTPS_PROXY and NO_PROXY environment variables. The tunnel itself is UDP
+// and never goes through the proxy.
+type HTTPProxyConfig struct {
+	URL     string // http://, https:// or socks5:// proxy for both HTTP and HTTPS
+	NoProxy string // comma-separated hosts reached directly; empty = NO_PROXY
+}
+
+// Validate checks that the proxy URL is one the HTTP transport can use
+func (c HTTPProxyConfig) Validate() error {
+	if c.URL == "" {
+		return nil
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("invalid HTTP proxy URL %q: %w", c.URL, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("invalid HTTP proxy URL %q: scheme must be http, https or socks5", c.URL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid HTTP proxy URL %q: missing host", c.URL)
+	}
+	return nil
+}
+
+// ProxyFunc returns the proxy selection function for an http.Transport
+func (c HTTPProxyConfig) ProxyFunc() func(*http.Request) (*url.URL, error) {
+	if c.URL == "" {
+		return http.ProxyFromEnvironment
+	}
+	noProxy := c.NoProxy
+	if noProxy == "" {
+		noProxy = firstEnv("NO_PROXY", "no_proxy")
+	}
+	proxyFor := (&httpproxy.Config{
+		HTTPProxy:  c.URL,
+		HTTPSProxy: c.URL,
+		NoProxy:    noProxy,
+	}).ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFor(req.URL)
+	}
+}
+
+// ProxyFor returns the proxy a request to rawURL goes through, or nil if it
+// goes direct
+func (c HTTPProxyConfig) ProxyFor(rawURL string) (*url.URL, error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.ProxyFunc()(req)
+}
+
+// Source describes where the proxy setting comes from, for diagnostics
+func (c HTTPProxyConfig) Source() string {
+	switch {
+	case c.URL != "":
+		return "config"
+	case firstEnv("HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy") != "":
+		return "environment"
+	default:
+		return "none"
+	}
+}
+
+// ApplyHTTPProxy makes http.DefaultTransport, which the AWS SDK and this
+// module's HTTP clients use unless told otherwise, pick proxies with c
+func ApplyHTTPProxy(c HTTPProxyConfig) {
+	if transport, ok := http.DefaultTransport.(*http.Transport); ok {
+		transport.Proxy = c.ProxyFunc()
+	}
+}
+
+// firstEnv returns the first non-empty environment variable among names
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,42 @@
+package shared
+
+import "testing"
+
+func TestHTTPProxyConfig(t *testing.T) {
+	cfg := HTTPProxyConfig{URL: "http://proxy.corp.example:3128", NoProxy: "internal.example,10.0.0.0/8"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected valid proxy config, got %v", err)
+	}
+	if cfg.Source() != "config" {
+		t.Errorf("Expected source config, got %s", cfg.Source())
+	}
+
+	proxyURL, err := cfg.ProxyFor("https://sts.us-west-2.amazonaws.com")
+	if err != nil || proxyURL == nil || proxyURL.Host != "proxy.corp.example:3128" {
+		t.Errorf("Expected AWS requests to use the proxy, got %v, %v", proxyURL, err)
+	}
+	for _, direct := range []string{"https://api.internal.example", "http://10.1.2.3/"} {
+		if proxyURL, err := cfg.ProxyFor(direct); err != nil || proxyURL != nil {
+			t.Errorf("Expected %s to go direct, got %v, %v", direct, proxyURL, err)
+		}
+	}
+
+	for _, bad := range []string{"ftp://proxy:21", "http://", "://x"} {
+		if err := (HTTPProxyConfig{URL: bad}).Validate(); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestHTTPProxyConfig_Environment(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "http://env-proxy:8080")
+	t.Setenv("HTTP_PROXY", "")
+	t.Setenv("NO_PROXY", "")
+	cfg := HTTPProxyConfig{}
+	if cfg.Source() != "environment" {
+		t.Errorf("Expected source environment, got %s", cfg.Source())
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected an empty config to be valid, got %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package shared
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// DefaultLogForwardRate is how many log lines per second a Lambda forwards
+// unless told otherwise
+const DefaultLogForwardRate = 20
+
+// MaxLogLine is the longest forwarded log line; longer ones are cut short
+const MaxLogLine = 2048
+
+// LogForwarding has the Lambda send its own log lines to the orchestrator
+// over the control stream, so both sides of a session show in one terminal
+type LogForwarding struct {
+	Level string `json:"level"`          // lowest level sent: debug, info, warn or error
+	Rate  int    `json:"rate,omitempty"` // lines per second (0 = DefaultLogForwardRate)
+}
+
+// Validate checks the level and rate
+func (f LogForwarding) Validate() error {
+	if _, err := ParseLogLevel(f.Level); err != nil {
+		return err
+	}
+	if f.Rate < 0 {
+		return fmt.Errorf("invalid log forwarding rate %d: cannot be negative", f.Rate)
+	}
+	return nil
+}
+
+// LinesPerSecond returns the rate, applying the default
+func (f LogForwarding) LinesPerSecond() int {
+	if f.Rate > 0 {
+		return f.Rate
+	}
+	return DefaultLogForwardRate
+}
+
+// WriteLogLine writes a forwarded log line at level, cut to MaxLogLine bytes
+func WriteLogLine(w io.Writer, level slog.Level, line string) error {
+	if len(line) > MaxLogLine {
+		line = strings.ToValidUTF8(line[:MaxLogLine], "")
+	}
+	buf := make([]byte, 4, 4+len(line))
+	buf[0] = OpLog
+	buf[1] = byte(int8(level))
+	binary.BigEndian.PutUint16(buf[2:], uint16(len(line)))
+	buf = append(buf, line...)
+	if _, err := w.Write(buf); err != nil {
+		return fmt.Errorf("failed to write log line: %w", err)
+	}
+	return nil
+}
+
+// ReadLogLine reads the rest of a log line message after ReadControlMessage
+// returned OpLog
+func ReadLogLine(r io.Reader) (slog.Level, string, error) {
+	var head [3]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, "", fmt.Errorf("failed to read log line: %w", err)
+	}
+	n := int(binary.BigEndian.Uint16(head[1:]))
+	if n > MaxLogLine {
+		return 0, "", fmt.Errorf("log line too long: %d bytes (max %d)", n, MaxLogLine)
+	}
+	line := make([]byte, n)
+	if _, err := io.ReadFull(r, line); err != nil {
+		return 0, "", fmt.Errorf("failed to read log line: %w", err)
+	}
+	return slog.Level(int8(head[0])), string(line), nil
+}
+
+// teeHandler passes each record to both handlers, each at its own level
+type teeHandler struct {
+	primary, forward slog.Handler
+}
+
+func (h teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.primary.Enabled(ctx, level) || h.forward.Enabled(ctx, level)
+}
+
+func (h teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	if h.forward.Enabled(ctx, r.Level) {
+		h.forward.Handle(ctx, r.Clone())
+	}
+	if h.primary.Enabled(ctx, r.Level) {
+		return h.primary.Handle(ctx, r)
+	}
+	return nil
+}
+
+func (h teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return teeHandler{h.primary.WithAttrs(attrs), h.forward.WithAttrs(attrs)}
+}
+
+func (h teeHandler) WithGroup(name string) slog.Handler {
+	return teeHandler{h.primary.WithGroup(name), h.forward.WithGroup(name)}
+}
@@ -2,8 +2,11 @@ package shared
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"sync/atomic"
 	"time"
 )
 
@@ -11,6 +14,9 @@ var (
 	// Global structured logger
 	logger *slog.Logger
 	
+	// logLevel is the logger's level, changed at runtime by SetLogLevel
+	logLevel = new(slog.LevelVar)
+	
 	// Log levels
 	LevelDebug = slog.LevelDebug
 	LevelInfo  = slog.LevelInfo
@@ -24,6 +30,8 @@ type LogConfig struct {
 	Format      string // "json" or "text"
 	AddSource   bool
 	ServiceName string
+	Output      io.Writer    // where log lines go (nil = os.Stdout)
+	Forward     slog.Handler // also handles every record at its own level (nil = none)
 }
 
 // DefaultLogConfig returns a default logger configuration
@@ -44,18 +52,27 @@ func InitLogger(config *LogConfig) {
 	
 	var handler slog.Handler
 	
+	logLevel.Set(config.Level)
 	opts := &slog.HandlerOptions{
-		Level:     config.Level,
+		Level:     logLevel,
 		AddSource: config.AddSource,
 	}
 	
+	output := config.Output
+	if output == nil {
+		output = os.Stdout
+	}
 	if config.Format == "json" {
-		handler = slog.NewJSONHandler(os.Stdout, opts)
+		handler = slog.NewJSONHandler(output, opts)
 	} else {
-		handler = slog.NewTextHandler(os.Stdout, opts)
+		handler = slog.NewTextHandler(output, opts)
+	}
+	handler = sessionHandler{handler}
+	if config.Forward != nil {
+		handler = teeHandler{handler, config.Forward}
 	}
 	
-	logger = slog.New(handler).With(
+	logger = slog.New(privacyHandler{handler}).With(
 		"service", config.ServiceName,
 		"version", "1.0.0",
 	)
@@ -64,6 +81,15 @@ func InitLogger(config *LogConfig) {
 	slog.SetDefault(logger)
 }
 
+// ParseLogLevel parses "debug", "info", "warn" or "error"
+func ParseLogLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return LevelInfo, fmt.Errorf("unknown log level %q (use debug, info, warn or error)", name)
+	}
+	return level, nil
+}
+
 // GetLogger returns the global structured logger
 func GetLogger() *slog.Logger {
 	if logger == nil {
@@ -159,11 +185,40 @@ func LogMetrics(component string, metrics map[string]interface{}) {
 	StructuredInfo("Performance metrics", attrs...)
 }
 
-// SetLogLevel dynamically sets the log level
+// SetLogLevel dynamically sets the log level, keeping the logger's format
+// and service name
 func SetLogLevel(level slog.Level) {
-	// Note: slog doesn't support dynamic level changes easily
-	// This would require reinitializing the handler
-	config := DefaultLogConfig()
-	config.Level = level
-	InitLogger(config)
-}
\ No newline at end of file
+	logLevel.Set(level)
+}
+
+// logSession is the session ID added to every log line, if any
+var logSession atomic.Value
+
+// SetLogSession adds session_id=id to every log line from now on, so a
+// session's lines can be picked out of a shared log such as the Lambda's
+// CloudWatch stream. An empty id stops it. Lines forwarded to the proxy
+// don't carry it; the proxy knows which session sent them.
+func SetLogSession(id string) {
+	logSession.Store(id)
+}
+
+// sessionHandler adds the current log session to each record
+type sessionHandler struct {
+	slog.Handler
+}
+
+func (h sessionHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id, _ := logSession.Load().(string); id != "" {
+		r = r.Clone()
+		r.AddAttrs(slog.String("session_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h sessionHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return sessionHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h sessionHandler) WithGroup(name string) slog.Handler {
+	return sessionHandler{h.Handler.WithGroup(name)}
+}
@@ -1,70 +1,251 @@
 package shared
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"math/rand"
 	"net"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 // PerformNATHolePunch performs NAT hole punching between two UDP endpoints
 func PerformNATHolePunch(conn *net.UDPConn, sessionID string, remoteAddr *net.UDPAddr, timeout time.Duration, isServer bool) error {
+	_, err := PerformNATHolePunchWithPrediction(conn, sessionID, remoteAddr, timeout, isServer, 0)
+	return err
+}
+
+// PerformNATHolePunchWithPrediction punches towards remoteAddr and, in parallel,
+// towards every port within predictRadius of it. This helps when the remote's
+// NAT is moderately symmetric and the port it uses towards us differs slightly
+// from the one STUN reported. It returns the address the remote's punch
+// actually arrived from.
+func PerformNATHolePunchWithPrediction(conn *net.UDPConn, sessionID string, remoteAddr *net.UDPAddr, timeout time.Duration, isServer bool, predictRadius int) (*net.UDPAddr, error) {
 	role := "client"
 	if isServer {
 		role = "server"
 	}
-	log.Printf("🔨 [%s] Starting NAT hole punching to %s", role, remoteAddr)
+	
+	candidates := PredictPorts(remoteAddr.Port, predictRadius)
+	if len(candidates) > 1 {
+		log.Printf("🔨 [%s] Starting NAT hole punching to %s (+%d predicted ports)", role, remoteAddr, len(candidates)-1)
+	} else {
+		log.Printf("🔨 [%s] Starting NAT hole punching to %s", role, remoteAddr)
+	}
 
-	// Send punch packets
-	punchDone := make(chan bool)
+	targets := make([]*net.UDPAddr, len(candidates))
+	for i, port := range candidates {
+		targets[i] = &net.UDPAddr{IP: remoteAddr.IP, Port: port, Zone: remoteAddr.Zone}
+	}
+
+	var mu sync.Mutex
+	stop := make(chan struct{})
+
+	// Send punch packets to every candidate each round. Once the remote is
+	// found, later rounds only go to the confirmed address.
+	punchDone := make(chan struct{})
 	go func() {
+		defer close(punchDone)
 		for i := 0; i < HolePunchPacketCount; i++ {
-			message := fmt.Sprintf("PUNCH:%s:%d", sessionID, i)
-			conn.WriteToUDP([]byte(message), remoteAddr)
-			time.Sleep(HolePunchInterval)
+			message := []byte(fmt.Sprintf("PUNCH:%s:%d", sessionID, i))
+			mu.Lock()
+			round := targets
+			mu.Unlock()
+			for _, target := range round {
+				conn.WriteToUDP(message, target)
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(HolePunchInterval):
+			}
 		}
-		close(punchDone)
 	}()
 
 	// Listen for remote's punch packets
-	successChan := make(chan bool)
+	prefix := "PUNCH:" + sessionID + ":"
+	found := make(chan *net.UDPAddr, 1)
+	listenDone := make(chan struct{})
 	go func() {
+		defer close(listenDone)
 		buf := make([]byte, UDPBufferSize)
 		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
 			conn.SetReadDeadline(time.Now().Add(UDPReadTimeout))
 			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil || !addr.IP.Equal(remoteAddr.IP) {
+				continue
+			}
 
-			if err == nil && addr.IP.Equal(remoteAddr.IP) && addr.Port == remoteAddr.Port {
-				data := string(buf[:n])
-				if strings.HasPrefix(data, "PUNCH:") {
-					log.Printf("✅ [%s] Received punch packet from remote: %s", role, data)
-					successChan <- true
-					return
+			data := string(buf[:n])
+			if predictRadius == 0 {
+				// Exact endpoint match, as before prediction existed
+				if addr.Port != remoteAddr.Port || !strings.HasPrefix(data, "PUNCH:") {
+					continue
 				}
+			} else if !strings.HasPrefix(data, prefix) {
+				// Any port is accepted when predicting, so require our session ID
+				continue
 			}
+
+			log.Printf("✅ [%s] Received punch packet from remote %s: %s", role, addr, data)
+			found <- addr
+			return
 		}
 	}()
 
 	// Wait for success or timeout
 	select {
-	case <-successChan:
+	case addr := <-found:
+		if addr.Port != remoteAddr.Port {
+			log.Printf("🎯 [%s] Remote port differs from reported %d, using %d", role, remoteAddr.Port, addr.Port)
+		}
+		mu.Lock()
+		targets = []*net.UDPAddr{addr}
+		mu.Unlock()
 		<-punchDone // Wait for sender to finish
 		conn.SetReadDeadline(time.Time{}) // Clear deadline
-		return nil
+		return addr, nil
 	case <-time.After(timeout):
+		close(stop)
+		<-listenDone // Don't let the listener reset the deadline after we clear it
 		conn.SetReadDeadline(time.Time{}) // Clear deadline
-		return fmt.Errorf("NAT hole punching timeout")
+		return nil, fmt.Errorf("NAT hole punching timeout")
 	}
 }
 
+// PredictPorts returns base followed by the ports within radius of it,
+// nearest first, alternating above and below
+func PredictPorts(base, radius int) []int {
+	ports := []int{base}
+	for d := 1; d <= radius; d++ {
+		if base+d <= 65535 {
+			ports = append(ports, base+d)
+		}
+		if base-d >= 1 {
+			ports = append(ports, base-d)
+		}
+	}
+	return ports
+}
+
 // CreateUDPSocket creates a UDP socket for NAT traversal
 func CreateUDPSocket() (*net.UDPConn, int, error) {
-	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: 0})
+	return CreateUDPSocketInRange(PortRange{})
+}
+
+// PortRange constrains the local UDP port used for STUN and hole punching.
+// The zero value means any ephemeral port.
+type PortRange struct {
+	Min int
+	Max int
+}
+
+// ParsePortRange parses "", "40000" or "40000-40100"
+func ParsePortRange(s string) (PortRange, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return PortRange{}, nil
+	}
+
+	low, high, isRange := strings.Cut(s, "-")
+	min, err := strconv.Atoi(strings.TrimSpace(low))
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to create UDP socket: %w", err)
+		return PortRange{}, fmt.Errorf("invalid port %q", low)
+	}
+	max := min
+	if isRange {
+		if max, err = strconv.Atoi(strings.TrimSpace(high)); err != nil {
+			return PortRange{}, fmt.Errorf("invalid port %q", high)
+		}
 	}
 
-	port := conn.LocalAddr().(*net.UDPAddr).Port
-	return conn, port, nil
+	if min < 1 || max > 65535 {
+		return PortRange{}, fmt.Errorf("ports must be between 1 and 65535")
+	}
+	if min > max {
+		return PortRange{}, fmt.Errorf("range start %d is greater than end %d", min, max)
+	}
+	return PortRange{Min: min, Max: max}, nil
+}
+
+// IsZero reports whether the range is unconstrained
+func (r PortRange) IsZero() bool {
+	return r.Min == 0 && r.Max == 0
+}
+
+// Size returns the number of ports in the range (0 if unconstrained)
+func (r PortRange) Size() int {
+	if r.IsZero() {
+		return 0
+	}
+	return r.Max - r.Min + 1
+}
+
+func (r PortRange) String() string {
+	if r.IsZero() {
+		return "any"
+	}
+	if r.Min == r.Max {
+		return strconv.Itoa(r.Min)
+	}
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+// ports returns the ports of the range starting at a random offset, so
+// concurrent sessions don't all contend for the first port
+func (r PortRange) ports() []int {
+	if r.IsZero() {
+		return []int{0}
+	}
+	size := r.Size()
+	start := rand.Intn(size)
+	ports := make([]int, size)
+	for i := range ports {
+		ports[i] = r.Min + (start+i)%size
+	}
+	return ports
+}
+
+// CreateUDPSocketInRange creates a UDP socket for NAT traversal bound to a
+// free port in r. Ports already in use are skipped.
+func CreateUDPSocketInRange(r PortRange) (*net.UDPConn, int, error) {
+	var lastErr error
+	for _, port := range r.ports() {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return conn, conn.LocalAddr().(*net.UDPAddr).Port, nil
+	}
+	return nil, 0, fmt.Errorf("failed to create UDP socket in port range %s: %w", r, lastErr)
+}
+
+// DialUDPInRange dials address over UDP from a free local port in r
+func DialUDPInRange(ctx context.Context, r PortRange, address string) (net.Conn, error) {
+	var lastErr error
+	for _, port := range r.ports() {
+		dialer := &net.Dialer{}
+		if port != 0 {
+			dialer.LocalAddr = &net.UDPAddr{Port: port}
+		}
+		conn, err := dialer.DialContext(ctx, "udp", address)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			lastErr = err
+			continue
+		}
+		return conn, nil
+	}
+	return nil, fmt.Errorf("failed to dial %s from port range %s: %w", address, r, lastErr)
 }
\ No newline at end of file
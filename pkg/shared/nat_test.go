@@ -0,0 +1,139 @@
+package shared
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected PortRange
+		wantErr  bool
+	}{
+		{"", PortRange{}, false},
+		{"40000", PortRange{Min: 40000, Max: 40000}, false},
+		{"40000-40100", PortRange{Min: 40000, Max: 40100}, false},
+		{" 40000 - 40100 ", PortRange{Min: 40000, Max: 40100}, false},
+		{"40100-40000", PortRange{}, true},
+		{"0-10", PortRange{}, true},
+		{"40000-70000", PortRange{}, true},
+		{"abc", PortRange{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			r, err := ParsePortRange(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePortRange(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if r != tt.expected {
+				t.Errorf("ParsePortRange(%q) = %+v, expected %+v", tt.input, r, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCreateUDPSocketInRange(t *testing.T) {
+	// Find a free port to build a two-port range around
+	probe, err := net.ListenUDP("udp", &net.UDPAddr{Port: 0})
+	if err != nil {
+		t.Fatalf("Failed to create probe socket: %v", err)
+	}
+	base := probe.LocalAddr().(*net.UDPAddr).Port
+	probe.Close()
+	r := PortRange{Min: base, Max: base + 1}
+
+	first, firstPort, err := CreateUDPSocketInRange(r)
+	if err != nil {
+		t.Skipf("Ports %s unavailable: %v", r, err)
+	}
+	defer first.Close()
+
+	// The second socket must skip the port already in use
+	second, secondPort, err := CreateUDPSocketInRange(r)
+	if err != nil {
+		t.Skipf("Ports %s unavailable: %v", r, err)
+	}
+	defer second.Close()
+
+	for _, port := range []int{firstPort, secondPort} {
+		if port < r.Min || port > r.Max {
+			t.Errorf("Port %d outside range %s", port, r)
+		}
+	}
+	if firstPort == secondPort {
+		t.Errorf("Expected distinct ports, both got %d", firstPort)
+	}
+
+	// Range exhausted
+	if _, _, err := CreateUDPSocketInRange(r); err == nil {
+		t.Error("Expected error when all ports in range are in use")
+	}
+
+	conn, err := DialUDPInRange(context.Background(), PortRange{}, first.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("DialUDPInRange failed: %v", err)
+	}
+	conn.Close()
+}
+
+func TestPredictPorts(t *testing.T) {
+	ports := PredictPorts(40000, 2)
+	expected := []int{40000, 40001, 39999, 40002, 39998}
+	if len(ports) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, ports)
+	}
+	for i := range expected {
+		if ports[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, ports)
+			break
+		}
+	}
+
+	if ports := PredictPorts(65535, 1); len(ports) != 2 || ports[1] != 65534 {
+		t.Errorf("Expected ports clamped to 65535, got %v", ports)
+	}
+	if ports := PredictPorts(1234, 0); len(ports) != 1 {
+		t.Errorf("Expected only the base port without prediction, got %v", ports)
+	}
+}
+
+func TestHolePunchWithPrediction(t *testing.T) {
+	if testing.Short() {
+		t.Skip("hole punching sends a full round of punch packets")
+	}
+
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to create server socket: %v", err)
+	}
+	defer server.Close()
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Failed to create client socket: %v", err)
+	}
+	defer client.Close()
+
+	// The server is told a port two off from the one the client really uses
+	clientAddr := client.LocalAddr().(*net.UDPAddr)
+	reported := &net.UDPAddr{IP: clientAddr.IP, Port: clientAddr.Port - 2}
+
+	clientErr := make(chan error, 1)
+	go func() {
+		clientErr <- PerformNATHolePunch(client, "session", server.LocalAddr().(*net.UDPAddr), 3*time.Second, false)
+	}()
+
+	found, err := PerformNATHolePunchWithPrediction(server, "session", reported, 3*time.Second, true, 3)
+	if err != nil {
+		t.Fatalf("Hole punch with prediction failed: %v", err)
+	}
+	if found.Port != clientAddr.Port {
+		t.Errorf("Expected remote port %d, got %d", clientAddr.Port, found.Port)
+	}
+	if err := <-clientErr; err != nil {
+		t.Errorf("Client hole punch failed: %v", err)
+	}
+}
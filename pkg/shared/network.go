@@ -41,6 +41,31 @@ func DiscoverPublicIPHTTPWithTimeout(timeout time.Duration) (string, error) {
 	return ip, nil
 }
 
+// ServeHTTPUntilDone serves server on listener until ctx is done, then shuts
+// it down, giving open requests HTTPShutdownTimeout to finish before their
+// connections are closed. It returns nil once the server has shut down, or
+// the error that stopped it serving.
+func ServeHTTPUntilDone(ctx context.Context, server *http.Server, listener net.Listener) error {
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), HTTPShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		server.Close()
+	}
+	<-served
+	return nil
+}
+
 // CreateUDPSocketWithPort creates a UDP socket bound to a specific port
 func CreateUDPSocketWithPort(port int) (*net.UDPConn, error) {
 	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf(":%d", port))
@@ -100,34 +125,14 @@ func OptimizedCopy(dst, src net.Conn) {
 
 // OptimizedCopyWithBufferSize performs optimized copying with custom buffer size
 func OptimizedCopyWithBufferSize(dst, src net.Conn, bufferSize int) {
-	done := make(chan struct{}, 2)
-	
-	// Copy from src to dst
-	go func() {
-		defer func() { done <- struct{}{} }()
-		copyWithBuffer(dst, src, bufferSize)
-	}()
-	
-	// Copy from dst to src
-	go func() {
-		defer func() { done <- struct{}{} }()
-		copyWithBuffer(src, dst, bufferSize)
-	}()
-	
-	// Wait for either direction to complete
-	<-done
-	
-	// Close both connections to stop the other direction
-	dst.Close()
-	src.Close()
-	
-	// Wait for the other direction to complete
-	<-done
+	bidirectionalCopy(context.Background(), dst, src, bufferSize, nil, nil)
 }
 
 // copyWithBuffer performs optimized copying with a custom buffer size
 func copyWithBuffer(dst io.Writer, src io.Reader, bufferSize int) (written int64, err error) {
-	buf := make([]byte, bufferSize)
+	bufPtr := GetBuffer(bufferSize)
+	defer PutBuffer(bufPtr)
+	buf := *bufPtr
 	for {
 		nr, er := src.Read(buf)
 		if nr > 0 {
@@ -158,12 +163,20 @@ func copyWithBuffer(dst io.Writer, src io.Reader, bufferSize int) (written int64
 	return written, err
 }
 
-// copyWithBufferAndMetrics performs optimized copying with metrics tracking
-func copyWithBufferAndMetrics(dst io.Writer, src io.Reader, bufferSize int, recordBytes func(int64)) (written int64, err error) {
-	buf := make([]byte, bufferSize)
+// copyWithBufferAndMetrics performs optimized copying with metrics tracking.
+// Each chunk read is charged to limits before it is written, so a capped
+// connection stops reading from src until it is back under its rate.
+func copyWithBufferAndMetrics(ctx context.Context, dst io.Writer, src io.Reader, bufferSize int, recordBytes func(int64), limits RateLimiters) (written int64, err error) {
+	bufPtr := GetBuffer(bufferSize)
+	defer PutBuffer(bufPtr)
+	buf := *bufPtr
 	for {
 		nr, er := src.Read(buf)
 		if nr > 0 {
+			if el := limits.WaitN(ctx, nr); el != nil {
+				err = el
+				break
+			}
 			nw, ew := dst.Write(buf[0:nr])
 			if nw < 0 || nr < nw {
 				nw = 0
@@ -202,100 +215,7 @@ func OptimizedCopyWithContext(ctx context.Context, dst, src net.Conn) {
 
 // OptimizedCopyWithContextAndBufferSize performs optimized copying with custom buffer size and context support
 func OptimizedCopyWithContextAndBufferSize(ctx context.Context, dst, src net.Conn, bufferSize int) {
-	done := make(chan struct{}, 2)
-	
-	// Create a context for this copy operation
-	copyCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
-	
-	// Copy from src to dst
-	go func() {
-		defer func() { done <- struct{}{} }()
-		copyWithBufferAndContext(copyCtx, dst, src, bufferSize)
-	}()
-	
-	// Copy from dst to src
-	go func() {
-		defer func() { done <- struct{}{} }()
-		copyWithBufferAndContext(copyCtx, src, dst, bufferSize)
-	}()
-	
-	// Monitor for context cancellation
-	go func() {
-		<-copyCtx.Done()
-		// Close both connections to interrupt any ongoing operations
-		dst.Close()
-		src.Close()
-	}()
-	
-	// Wait for either direction to complete
-	<-done
-	
-	// Cancel context to signal the other direction to stop
-	cancel()
-	
-	// Close both connections to stop the other direction
-	dst.Close()
-	src.Close()
-	
-	// Wait for the other direction to complete
-	<-done
-}
-
-// copyWithBufferAndContext performs optimized copying with a custom buffer size and context awareness
-func copyWithBufferAndContext(ctx context.Context, dst io.Writer, src io.Reader, bufferSize int) (written int64, err error) {
-	buf := make([]byte, bufferSize)
-	for {
-		// Check for context cancellation
-		select {
-		case <-ctx.Done():
-			return written, ctx.Err()
-		default:
-		}
-		
-		// Set a read deadline if possible to avoid blocking indefinitely
-		if conn, ok := src.(net.Conn); ok {
-			conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
-		}
-		
-		nr, er := src.Read(buf)
-		if nr > 0 {
-			// Check for context cancellation before writing
-			select {
-			case <-ctx.Done():
-				return written, ctx.Err()
-			default:
-			}
-			
-			nw, ew := dst.Write(buf[0:nr])
-			if nw < 0 || nr < nw {
-				nw = 0
-				if ew == nil {
-					ew = fmt.Errorf("invalid write result")
-				}
-			}
-			written += int64(nw)
-			if ew != nil {
-				err = ew
-				break
-			}
-			if nr != nw {
-				err = io.ErrShortWrite
-				break
-			}
-		}
-		if er != nil {
-			// Check if this is a timeout error due to read deadline
-			if netErr, ok := er.(net.Error); ok && netErr.Timeout() {
-				continue // Continue if it's just a timeout, not a real error
-			}
-			if er != io.EOF {
-				err = er
-			}
-			break
-		}
-	}
-	return written, err
+	bidirectionalCopy(ctx, dst, src, bufferSize, nil, nil)
 }
 
 // OptimizedCopyWithMetrics performs high-performance bidirectional copying with metrics tracking
@@ -305,29 +225,7 @@ func OptimizedCopyWithMetrics(dst, src net.Conn, recordBytes func(int64)) {
 
 // OptimizedCopyWithBufferSizeAndMetrics performs optimized copying with custom buffer size and metrics
 func OptimizedCopyWithBufferSizeAndMetrics(dst, src net.Conn, bufferSize int, recordBytes func(int64)) {
-	done := make(chan struct{}, 2)
-	
-	// Copy from src to dst
-	go func() {
-		defer func() { done <- struct{}{} }()
-		copyWithBufferAndMetrics(dst, src, bufferSize, recordBytes)
-	}()
-	
-	// Copy from dst to src
-	go func() {
-		defer func() { done <- struct{}{} }()
-		copyWithBufferAndMetrics(src, dst, bufferSize, recordBytes)
-	}()
-	
-	// Wait for either direction to complete
-	<-done
-	
-	// Close both connections to stop the other direction
-	dst.Close()
-	src.Close()
-	
-	// Wait for the other direction to complete
-	<-done
+	bidirectionalCopy(context.Background(), dst, src, bufferSize, recordBytes, nil)
 }
 
 // OptimizedCopyWithContextAndMetrics performs high-performance bidirectional copying with context and metrics
@@ -337,102 +235,48 @@ func OptimizedCopyWithContextAndMetrics(ctx context.Context, dst, src net.Conn,
 
 // OptimizedCopyWithContextBufferSizeAndMetrics performs optimized copying with context, buffer size, and metrics
 func OptimizedCopyWithContextBufferSizeAndMetrics(ctx context.Context, dst, src net.Conn, bufferSize int, recordBytes func(int64)) {
+	bidirectionalCopy(ctx, dst, src, bufferSize, recordBytes, nil)
+}
+
+// OptimizedCopyWithLimits performs optimized copying with context, buffer size,
+// metrics and bandwidth limits applied to both directions
+func OptimizedCopyWithLimits(ctx context.Context, dst, src net.Conn, bufferSize int, recordBytes func(int64), limits RateLimiters) {
+	bidirectionalCopy(ctx, dst, src, bufferSize, recordBytes, limits)
+}
+
+// bidirectionalCopy copies between dst and src until either direction finishes
+// or ctx is cancelled. The calling goroutine watches for both and then closes
+// the connections, which unblocks any pending Read or Write in the other
+// direction, so the copy loops never need to poll with read deadlines.
+func bidirectionalCopy(ctx context.Context, dst, src net.Conn, bufferSize int, recordBytes func(int64), limits RateLimiters) {
 	done := make(chan struct{}, 2)
 	
-	// Create a context for this copy operation
-	copyCtx, cancel := context.WithCancel(ctx)
-	defer cancel()
-	
 	// Copy from src to dst
 	go func() {
 		defer func() { done <- struct{}{} }()
-		copyWithBufferContextAndMetrics(copyCtx, dst, src, bufferSize, recordBytes)
+		copyWithBufferAndMetrics(ctx, dst, src, bufferSize, recordBytes, limits)
 	}()
 	
 	// Copy from dst to src
 	go func() {
 		defer func() { done <- struct{}{} }()
-		copyWithBufferContextAndMetrics(copyCtx, src, dst, bufferSize, recordBytes)
+		copyWithBufferAndMetrics(ctx, src, dst, bufferSize, recordBytes, limits)
 	}()
 	
-	// Monitor for context cancellation
-	go func() {
-		<-copyCtx.Done()
-		// Close both connections to interrupt any ongoing operations
-		dst.Close()
-		src.Close()
-	}()
-	
-	// Wait for either direction to complete
-	<-done
-	
-	// Cancel context to signal the other direction to stop
-	cancel()
+	// Wait for either direction to complete or the context to be cancelled
+	finished := 0
+	select {
+	case <-done:
+		finished++
+	case <-ctx.Done():
+	}
 	
-	// Close both connections to stop the other direction
+	// Close both connections to stop the remaining direction(s)
 	dst.Close()
 	src.Close()
 	
-	// Wait for the other direction to complete
-	<-done
-}
-
-// copyWithBufferContextAndMetrics performs optimized copying with context, custom buffer size, and metrics tracking
-func copyWithBufferContextAndMetrics(ctx context.Context, dst io.Writer, src io.Reader, bufferSize int, recordBytes func(int64)) (written int64, err error) {
-	buf := make([]byte, bufferSize)
-	for {
-		// Check for context cancellation
-		select {
-		case <-ctx.Done():
-			return written, ctx.Err()
-		default:
-		}
-		
-		// Set a read deadline if possible to avoid blocking indefinitely
-		if conn, ok := src.(net.Conn); ok {
-			conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
-		}
-		
-		nr, er := src.Read(buf)
-		if nr > 0 {
-			// Check for context cancellation before writing
-			select {
-			case <-ctx.Done():
-				return written, ctx.Err()
-			default:
-			}
-			
-			nw, ew := dst.Write(buf[0:nr])
-			if nw < 0 || nr < nw {
-				nw = 0
-				if ew == nil {
-					ew = fmt.Errorf("invalid write result")
-				}
-			}
-			written += int64(nw)
-			// Record bytes transferred for metrics
-			if recordBytes != nil && nw > 0 {
-				recordBytes(int64(nw))
-			}
-			if ew != nil {
-				err = ew
-				break
-			}
-			if nr != nw {
-				err = io.ErrShortWrite
-				break
-			}
-		}
-		if er != nil {
-			// Check if this is a timeout error due to read deadline
-			if netErr, ok := er.(net.Error); ok && netErr.Timeout() {
-				continue // Continue if it's just a timeout, not a real error
-			}
-			if er != io.EOF {
-				err = er
-			}
-			break
-		}
+	// Wait for both directions to exit so their buffers go back to the pool
+	for ; finished < 2; finished++ {
+		<-done
 	}
-	return written, err
-}
\ No newline at end of file
+}
@@ -0,0 +1,66 @@
+package shared
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+// AWS partitions with their own ARN prefix, endpoints and accounts
+const (
+	PartitionAWS      = "aws"
+	PartitionAWSUSGov = "aws-us-gov"
+	PartitionAWSCN    = "aws-cn"
+)
+
+// regionPattern matches region names like "us-east-1", "us-gov-west-1" or "cn-northwest-1"
+var regionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+
+// ValidRegion reports whether region is a syntactically valid region name.
+// Regions are not checked against a list, so new ones work without an update.
+func ValidRegion(region string) bool {
+	return regionPattern.MatchString(region)
+}
+
+// PartitionForRegion returns the partition region belongs to by its name,
+// falling back to the commercial partition
+func PartitionForRegion(region string) string {
+	switch {
+	case strings.HasPrefix(region, "us-gov-"):
+		return PartitionAWSUSGov
+	case strings.HasPrefix(region, "cn-"):
+		return PartitionAWSCN
+	}
+	return PartitionAWS
+}
+
+// PartitionOfARN returns the partition of an ARN such as a caller identity
+// or role ARN, or "" if it can't be parsed
+func PartitionOfARN(resourceARN string) string {
+	parsed, err := arn.Parse(resourceARN)
+	if err != nil {
+		return ""
+	}
+	return parsed.Partition
+}
+
+// DNSSuffixForRegion returns the domain of region's service endpoints,
+// "amazonaws.com" or, in China, "amazonaws.com.cn"
+func DNSSuffixForRegion(region string) string {
+	if PartitionForRegion(region) == PartitionAWSCN {
+		return "amazonaws.com.cn"
+	}
+	return "amazonaws.com"
+}
+
+// BucketARN returns the ARN of an S3 bucket in partition
+func BucketARN(partition, bucket string) string {
+	return fmt.Sprintf("arn:%s:s3:::%s", partition, bucket)
+}
+
+// FunctionARN returns the ARN of a Lambda function
+func FunctionARN(partition, region, accountID, functionName string) string {
+	return fmt.Sprintf("arn:%s:lambda:%s:%s:function:%s", partition, region, accountID, functionName)
+}
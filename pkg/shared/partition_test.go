@@ -0,0 +1,37 @@
+package shared
+
+import "testing"
+
+func TestPartitions(t *testing.T) {
+	for region, want := range map[string]string{
+		"us-west-2":      PartitionAWS,
+		"ap-southeast-7": PartitionAWS,
+		"us-gov-west-1":  PartitionAWSUSGov,
+		"cn-northwest-1": PartitionAWSCN,
+	} {
+		if !ValidRegion(region) {
+			t.Errorf("Expected %s to be a valid region", region)
+		}
+		if got := PartitionForRegion(region); got != want {
+			t.Errorf("PartitionForRegion(%s) = %s, want %s", region, got, want)
+		}
+	}
+	for _, region := range []string{"", "us-west", "US-WEST-2", "us_west_2", "uswest2"} {
+		if ValidRegion(region) {
+			t.Errorf("Expected %q to be invalid", region)
+		}
+	}
+
+	if got := DNSSuffixForRegion("cn-north-1"); got != "amazonaws.com.cn" {
+		t.Errorf("Expected the China endpoint domain, got %s", got)
+	}
+	if got := PartitionOfARN("arn:aws-us-gov:iam::123456789012:user/deployer"); got != PartitionAWSUSGov {
+		t.Errorf("Expected the GovCloud partition from the ARN, got %q", got)
+	}
+	if got := FunctionARN(PartitionAWSCN, "cn-north-1", "123456789012", "proxy-lambda"); got != "arn:aws-cn:lambda:cn-north-1:123456789012:function:proxy-lambda" {
+		t.Errorf("Unexpected function ARN %s", got)
+	}
+	if got := BucketARN(PartitionAWSUSGov, "bucket"); got != "arn:aws-us-gov:s3:::bucket" {
+		t.Errorf("Unexpected bucket ARN %s", got)
+	}
+}
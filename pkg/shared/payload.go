@@ -0,0 +1,137 @@
+package shared
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// PayloadSchemaVersion is the version of the coordination data and Lambda
+// response written by this build. Bump it whenever a field is added,
+// removed or changes meaning, so a proxy and Lambda from different releases
+// refuse each other's payloads with a clear error instead of misreading
+// them.
+const PayloadSchemaVersion = 1
+
+// Errors returned when a payload can't be trusted
+var (
+	ErrPayloadSchema   = errors.New("payload schema version mismatch")
+	ErrPayloadChecksum = errors.New("payload checksum mismatch")
+)
+
+// NewIntegrityKey returns a random key for a session's payload checksums
+func NewIntegrityKey() string {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		// crypto/rand doesn't fail on supported platforms
+		panic(fmt.Sprintf("failed to generate integrity key: %v", err))
+	}
+	return hex.EncodeToString(key)
+}
+
+// MarshalCoordination encodes coord with the current schema version and a
+// checksum keyed by its integrity key. The key travels in the payload, so
+// the checksum catches corruption and mismatched encoders rather than
+// tampering; the bucket's access policy keeps others from writing it.
+func MarshalCoordination(coord CoordinationData) ([]byte, error) {
+	if coord.IntegrityKey == "" {
+		return nil, errors.New("coordination data has no integrity key")
+	}
+	coord.SchemaVersion = PayloadSchemaVersion
+	coord.Checksum = ""
+	return sealPayload(coord, coord.IntegrityKey)
+}
+
+// UnmarshalCoordination decodes coordination data, checking its schema
+// version and checksum
+func UnmarshalCoordination(data []byte) (*CoordinationData, error) {
+	var coord CoordinationData
+	if err := json.Unmarshal(data, &coord); err != nil {
+		return nil, fmt.Errorf("failed to decode coordination data: %w", err)
+	}
+	if err := verifyPayload("coordination data", data, coord.SchemaVersion, coord.Checksum, coord.IntegrityKey); err != nil {
+		return nil, err
+	}
+	return &coord, nil
+}
+
+// MarshalResponse encodes response with the current schema version and a
+// checksum keyed by the session's integrity key. Only the Lambda that read
+// the session's coordination data, or the proxy that wrote it, can produce
+// one that UnmarshalResponse accepts.
+func MarshalResponse(response LambdaResponse, key string) ([]byte, error) {
+	if key == "" {
+		return nil, errors.New("no integrity key to sign the Lambda response with")
+	}
+	response.SchemaVersion = PayloadSchemaVersion
+	response.Checksum = ""
+	return sealPayload(response, key)
+}
+
+// UnmarshalResponse decodes a Lambda response, checking its schema version
+// and its checksum against the session's integrity key
+func UnmarshalResponse(data []byte, key string) (*LambdaResponse, error) {
+	var response LambdaResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		return nil, fmt.Errorf("failed to decode Lambda response: %w", err)
+	}
+	if err := verifyPayload("Lambda response", data, response.SchemaVersion, response.Checksum, key); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// checksumSuffix is the checksum field sealPayload appends to a payload
+func checksumSuffix(sum string) []byte {
+	return []byte(`,"checksum":"` + sum + `"}`)
+}
+
+// sealPayload encodes v, which must not set its checksum, and appends the
+// HMAC-SHA256 of the encoding as the last field. Checking the exact bytes
+// written, rather than a re-encoding, keeps fields the reader doesn't know
+// from changing the sum.
+func sealPayload(v interface{}, key string) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	sum := payloadMAC(key, data)
+	return append(data[:len(data)-1], checksumSuffix(sum)...), nil
+}
+
+// verifyPayload checks a payload's schema version, then that its checksum
+// is the last field and matches the bytes before it
+func verifyPayload(what string, data []byte, version int, sum, key string) error {
+	switch {
+	case version == 0:
+		return fmt.Errorf("%w: %s has no schema version, so it was written by an older release; run 'lambda-nat-proxy deploy' so the proxy and the Lambda match", ErrPayloadSchema, what)
+	case version != PayloadSchemaVersion:
+		return fmt.Errorf("%w: %s has schema version %d, but this release reads version %d; run the same release of the proxy and the Lambda", ErrPayloadSchema, what, version, PayloadSchemaVersion)
+	case sum == "":
+		return fmt.Errorf("%w: %s has no checksum", ErrPayloadChecksum, what)
+	case key == "":
+		return fmt.Errorf("%w: no integrity key to check the %s with", ErrPayloadChecksum, what)
+	}
+	data = bytes.TrimSpace(data)
+	suffix := checksumSuffix(sum)
+	if !bytes.HasSuffix(data, suffix) {
+		return fmt.Errorf("%w: the checksum isn't the last field of the %s", ErrPayloadChecksum, what)
+	}
+	signed := append(data[:len(data)-len(suffix):len(data)-len(suffix)], '}')
+	if !hmac.Equal([]byte(payloadMAC(key, signed)), []byte(sum)) {
+		return fmt.Errorf("%w: the %s was changed or corrupted after it was written", ErrPayloadChecksum, what)
+	}
+	return nil
+}
+
+// payloadMAC returns the hex HMAC-SHA256 of data under key
+func payloadMAC(key string, data []byte) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
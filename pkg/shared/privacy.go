@@ -0,0 +1,139 @@
+package shared
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/netip"
+	"regexp"
+	"sync/atomic"
+)
+
+// RedactedIP replaces public IP addresses in privacy mode
+const RedactedIP = "<ip>"
+
+// privacyMode redacts public IP addresses from logs and the dashboard
+var privacyMode atomic.Bool
+
+// SetPrivacyMode turns redaction of public IP addresses on or off
+func SetPrivacyMode(enabled bool) {
+	privacyMode.Store(enabled)
+}
+
+// PrivacyMode reports whether public IP addresses are being redacted
+func PrivacyMode() bool {
+	return privacyMode.Load()
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, not reachable from the internet
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// IsPublicIP reports whether addr is routable on the internet, unlike
+// loopback, link-local, private and carrier-grade NAT addresses
+func IsPublicIP(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// RedactIP returns ip, or RedactedIP if privacy mode is on and ip is public
+func RedactIP(ip string) string {
+	if !privacyMode.Load() {
+		return ip
+	}
+	if addr, err := netip.ParseAddr(ip); err == nil && IsPublicIP(addr) {
+		return RedactedIP
+	}
+	return ip
+}
+
+// ipCandidatePattern matches text that may be an IPv4 or IPv6 address
+var ipCandidatePattern = regexp.MustCompile(`\b(\d{1,3}\.){3}\d{1,3}\b|[0-9a-fA-F]*:[0-9a-fA-F:]*:[0-9a-fA-F.:]*`)
+
+// RedactPublicIPs replaces every public IP address in text with RedactedIP,
+// leaving ports and private addresses as they are
+func RedactPublicIPs(text string) string {
+	return ipCandidatePattern.ReplaceAllStringFunc(text, func(candidate string) string {
+		if addr, err := netip.ParseAddr(candidate); err == nil && IsPublicIP(addr) {
+			return RedactedIP
+		}
+		return candidate
+	})
+}
+
+// privacyWriter redacts public IP addresses from what is written to it while
+// privacy mode is on
+type privacyWriter struct {
+	w io.Writer
+}
+
+// NewPrivacyWriter returns a writer for the standard log package that
+// redacts public IP addresses from each line while privacy mode is on. The
+// log package writes each line in a single call, so addresses are never split
+// across writes.
+func NewPrivacyWriter(w io.Writer) io.Writer {
+	return privacyWriter{w}
+}
+
+func (pw privacyWriter) Write(p []byte) (int, error) {
+	if !privacyMode.Load() {
+		return pw.w.Write(p)
+	}
+	if _, err := io.WriteString(pw.w, RedactPublicIPs(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// privacyHandler redacts public IP addresses from records while privacy mode is on
+type privacyHandler struct {
+	slog.Handler
+}
+
+func (h privacyHandler) Handle(ctx context.Context, r slog.Record) error {
+	if !privacyMode.Load() {
+		return h.Handler.Handle(ctx, r)
+	}
+	redacted := slog.NewRecord(r.Time, r.Level, RedactPublicIPs(r.Message), r.PC)
+	r.Attrs(func(attr slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(attr))
+		return true
+	})
+	return h.Handler.Handle(ctx, redacted)
+}
+
+// WithAttrs redacts attrs if privacy mode is on now, since the wrapped
+// handler may format them straight away
+func (h privacyHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if privacyMode.Load() {
+		redacted := make([]slog.Attr, len(attrs))
+		for i, attr := range attrs {
+			redacted[i] = redactAttr(attr)
+		}
+		attrs = redacted
+	}
+	return privacyHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h privacyHandler) WithGroup(name string) slog.Handler {
+	return privacyHandler{h.Handler.WithGroup(name)}
+}
+
+// redactAttr redacts public IP addresses in string values, including those in groups
+func redactAttr(attr slog.Attr) slog.Attr {
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		attr.Value = slog.StringValue(RedactPublicIPs(attr.Value.String()))
+	case slog.KindGroup:
+		group := attr.Value.Group()
+		redacted := make([]slog.Attr, len(group))
+		for i, member := range group {
+			redacted[i] = redactAttr(member)
+		}
+		attr.Value = slog.GroupValue(redacted...)
+	case slog.KindAny:
+		if err, ok := attr.Value.Any().(error); ok {
+			attr.Value = slog.StringValue(RedactPublicIPs(err.Error()))
+		}
+	}
+	return attr
+}
@@ -0,0 +1,76 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedactPublicIPs(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"Lambda endpoint: 203.0.113.7:4242", "Lambda endpoint: <ip>:4242"},
+		{"local 192.168.1.20, loopback 127.0.0.1, cgnat 100.64.1.1", "local 192.168.1.20, loopback 127.0.0.1, cgnat 100.64.1.1"},
+		{"from [2600:1f14::1]:443", "from [<ip>]:443"},
+		{"link-local fe80::1 at 12:37:09", "link-local fe80::1 at 12:37:09"},
+		{"version 1.2.3.4567", "version 1.2.3.4567"},
+	}
+	for _, tt := range tests {
+		if got := RedactPublicIPs(tt.input); got != tt.want {
+			t.Errorf("RedactPublicIPs(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestPrivacyMode(t *testing.T) {
+	defer SetPrivacyMode(false)
+
+	if got := RedactIP("203.0.113.7"); got != "203.0.113.7" {
+		t.Errorf("Expected IPs shown with privacy mode off, got %q", got)
+	}
+
+	SetPrivacyMode(true)
+	if got := RedactIP("203.0.113.7"); got != RedactedIP {
+		t.Errorf("Expected a public IP redacted, got %q", got)
+	}
+	if got := RedactIP("10.0.0.1"); got != "10.0.0.1" {
+		t.Errorf("Expected a private IP shown, got %q", got)
+	}
+
+	var buf bytes.Buffer
+	logger := slog.New(privacyHandler{slog.NewTextHandler(&buf, nil)}).With("peer", "198.51.100.5")
+	logger.LogAttrs(context.Background(), slog.LevelInfo, "Public IP: 198.51.100.1",
+		slog.String("addr", "198.51.100.2:5000"),
+		slog.Any("error", errors.New("dial 198.51.100.3 failed")),
+		slog.Group("lambda", slog.String("ip", "198.51.100.4")))
+	out := buf.String()
+	for _, ip := range []string{"198.51.100.1", "198.51.100.2", "198.51.100.3", "198.51.100.4", "198.51.100.5"} {
+		if strings.Contains(out, ip) {
+			t.Errorf("Expected %s redacted from log line %q", ip, out)
+		}
+	}
+}
+
+func TestPrivacyWriter(t *testing.T) {
+	defer SetPrivacyMode(false)
+	var buf bytes.Buffer
+	logger := log.New(NewPrivacyWriter(&buf), "", 0)
+
+	logger.Printf("Launcher: Public IP: %s", "203.0.113.7")
+	if got := buf.String(); got != "Launcher: Public IP: 203.0.113.7\n" {
+		t.Errorf("Expected IPs shown with privacy mode off, got %q", got)
+	}
+
+	buf.Reset()
+	SetPrivacyMode(true)
+	logger.Printf("Lambda connected from %s, local %s", "203.0.113.7:4242", "192.168.1.20:5000")
+	if got := buf.String(); got != "Lambda connected from <ip>:4242, local 192.168.1.20:5000\n" {
+		t.Errorf("Expected the public IP redacted, got %q", got)
+	}
+}
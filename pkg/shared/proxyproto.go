@@ -0,0 +1,181 @@
+package shared
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// proxyV2Signature starts every PROXY protocol v2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyV1Prefix starts every PROXY protocol v1 header
+const proxyV1Prefix = "PROXY "
+
+// proxyV1MaxLength is the longest v1 header the spec allows, CRLF included
+const proxyV1MaxLength = 107
+
+// ProxyProtocolPolicy controls whether the SOCKS5 listener accepts PROXY
+// protocol headers from load balancers such as HAProxy or an AWS NLB
+type ProxyProtocolPolicy struct {
+	// Enabled reads a v1 or v2 header, if one is sent, before the SOCKS5
+	// handshake and uses its source address as the client's
+	Enabled bool
+
+	// Trusted lists the peers whose headers are believed. Headers from
+	// anyone else are refused. Empty trusts every peer.
+	Trusted []*net.IPNet
+}
+
+// Trusts reports whether a header sent by addr should be believed
+func (p ProxyProtocolPolicy) Trusts(addr net.Addr) bool {
+	if len(p.Trusted) == 0 {
+		return true
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range p.Trusted {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTrustedProxies parses addresses like "10.0.0.0/8" or "192.0.2.10"
+func ParseTrustedProxies(entries []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q (expected an IP address or CIDR)", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q (expected an IP address or CIDR)", entry)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// HasProxyHeader reports whether r starts with a PROXY protocol header. It
+// only waits for more than the first byte when that byte could start one,
+// since SOCKS5 clients send a 3 byte greeting and then wait for an answer.
+func HasProxyHeader(r *bufio.Reader) (bool, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return false, err
+	}
+	switch first[0] {
+	case proxyV2Signature[0]:
+		start, err := r.Peek(len(proxyV2Signature))
+		if err != nil {
+			return false, err
+		}
+		return bytes.Equal(start, proxyV2Signature), nil
+	case proxyV1Prefix[0]:
+		start, err := r.Peek(len(proxyV1Prefix))
+		if err != nil {
+			return false, err
+		}
+		return string(start) == proxyV1Prefix, nil
+	}
+	return false, nil
+}
+
+// ReadProxyHeader consumes the PROXY protocol header at the start of r and
+// returns the source address it carries. The address is nil for health
+// checks (v2 LOCAL, v1 UNKNOWN) and for address families other than TCP
+// over IPv4 or IPv6, which should keep the socket's own address.
+func ReadProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	start, err := r.Peek(len(proxyV2Signature))
+	if err == nil && bytes.Equal(start, proxyV2Signature) {
+		return readProxyV2(r)
+	}
+	return readProxyV1(r)
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("truncated PROXY v2 header: %w", err)
+	}
+	if version := header[12] >> 4; version != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", version)
+	}
+	command := header[12] & 0x0F
+	family := header[13]
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("truncated PROXY v2 addresses: %w", err)
+	}
+
+	switch command {
+	case 0x0: // LOCAL: the balancer's own connection, e.g. a health check
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("unknown PROXY v2 command %d", command)
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, fmt.Errorf("PROXY v2 IPv4 addresses too short")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, fmt.Errorf("PROXY v2 IPv6 addresses too short")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	}
+	return nil, nil
+}
+
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxLength {
+			return nil, fmt.Errorf("PROXY v1 header longer than %d bytes", proxyV1MaxLength)
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("truncated PROXY v1 header: %w", err)
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, fmt.Errorf("malformed PROXY v1 header")
+	}
+	if fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if (fields[1] != "TCP4" && fields[1] != "TCP6") || len(fields) != 6 {
+		return nil, fmt.Errorf("malformed PROXY v1 header")
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("malformed PROXY v1 source address %s:%s", fields[2], fields[4])
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
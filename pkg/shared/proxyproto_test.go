@@ -0,0 +1,112 @@
+package shared
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+// proxyV2Header builds a v2 PROXY header for a TCP connection from src
+func proxyV2Header(src *net.TCPAddr) []byte {
+	var header bytes.Buffer
+	header.Write(proxyV2Signature)
+	header.WriteByte(0x21) // version 2, PROXY
+	addresses := make([]byte, 12)
+	family := byte(0x11)
+	if src.IP.To4() == nil {
+		family = 0x21
+		addresses = make([]byte, 36)
+		copy(addresses[0:16], src.IP)
+		binary.BigEndian.PutUint16(addresses[32:34], uint16(src.Port))
+	} else {
+		copy(addresses[0:4], src.IP.To4())
+		binary.BigEndian.PutUint16(addresses[8:10], uint16(src.Port))
+	}
+	header.WriteByte(family)
+	binary.Write(&header, binary.BigEndian, uint16(len(addresses)))
+	header.Write(addresses)
+	return header.Bytes()
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	socksGreeting := []byte{SOCKS5Version, 0x01, 0x00}
+	local := append(append([]byte{}, proxyV2Signature...), 0x20, 0x00, 0x00, 0x00)
+
+	tests := []struct {
+		name   string
+		input  []byte
+		source string // "" = no address
+	}{
+		{"v2 IPv4", proxyV2Header(&net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 51000}), "203.0.113.7:51000"},
+		{"v2 IPv6", proxyV2Header(&net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 51000}), "[2001:db8::7]:51000"},
+		{"v2 LOCAL", local, ""},
+		{"v1 TCP4", []byte("PROXY TCP4 198.51.100.1 10.0.0.1 40000 1080\r\n"), "198.51.100.1:40000"},
+		{"v1 UNKNOWN", []byte("PROXY UNKNOWN\r\n"), ""},
+	}
+	for _, tt := range tests {
+		reader := bufio.NewReader(bytes.NewReader(append(tt.input, socksGreeting...)))
+		present, err := HasProxyHeader(reader)
+		if err != nil || !present {
+			t.Errorf("%s: expected a header to be detected, got %v, %v", tt.name, present, err)
+			continue
+		}
+		source, err := ReadProxyHeader(reader)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		got := ""
+		if source != nil {
+			got = source.String()
+		}
+		if got != tt.source {
+			t.Errorf("%s: expected source %q, got %q", tt.name, tt.source, got)
+		}
+		rest := make([]byte, len(socksGreeting))
+		if _, err := reader.Read(rest); err != nil || !bytes.Equal(rest, socksGreeting) {
+			t.Errorf("%s: expected the SOCKS5 greeting after the header, got %v", tt.name, rest)
+		}
+	}
+
+	// A SOCKS5 greeting alone is answered without waiting for more bytes
+	if present, err := HasProxyHeader(bufio.NewReader(bytes.NewReader(socksGreeting))); err != nil || present {
+		t.Errorf("Expected no header before a SOCKS5 greeting, got %v, %v", present, err)
+	}
+
+	for _, malformed := range []string{
+		"PROXY TCP4 not-an-ip 10.0.0.1 40000 1080\r\n",
+		"PROXY TCP4 198.51.100.1\r\n",
+		"PROXY " + strings.Repeat("x", 120),
+	} {
+		if _, err := ReadProxyHeader(bufio.NewReader(strings.NewReader(malformed))); err == nil {
+			t.Errorf("Expected an error for %q", malformed)
+		}
+	}
+}
+
+func TestProxyProtocolTrust(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.10"})
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies failed: %v", err)
+	}
+	policy := ProxyProtocolPolicy{Enabled: true, Trusted: trusted}
+	for addr, want := range map[string]bool{
+		"10.1.2.3:1000":   true,
+		"192.0.2.10:1000": true,
+		"192.0.2.11:1000": false,
+	} {
+		tcpAddr, _ := net.ResolveTCPAddr("tcp", addr)
+		if got := policy.Trusts(tcpAddr); got != want {
+			t.Errorf("Trusts(%s) = %v, want %v", addr, got, want)
+		}
+	}
+	if !(ProxyProtocolPolicy{Enabled: true}).Trusts(&net.TCPAddr{IP: net.ParseIP("198.51.100.1")}) {
+		t.Error("Expected every peer to be trusted without a trusted list")
+	}
+	if _, err := ParseTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("Expected an error for an invalid CIDR")
+	}
+}
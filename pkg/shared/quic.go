@@ -0,0 +1,88 @@
+package shared
+
+import (
+	"fmt"
+
+	"github.com/quic-go/quic-go"
+)
+
+// Congestion controller names accepted in configuration
+const (
+	CongestionCubic = "cubic"
+	CongestionReno  = "reno"
+	CongestionBBR   = "bbr"
+)
+
+// SupportedCongestionControls lists the controllers the bundled quic-go can run.
+// quic-go v0.40 only ships CUBIC and does not expose a way to swap it; reno and
+// bbr are recognised so configs can be written ahead of a quic-go upgrade.
+var SupportedCongestionControls = []string{CongestionCubic}
+
+// QUICTuning holds transport settings shared by the orchestrator and the Lambda.
+// It travels to the Lambda inside the coordination data so both ends agree.
+type QUICTuning struct {
+	CongestionControl string `json:"congestion_control,omitempty"`
+
+	// InitialWindow is the initial stream receive window in bytes (0 = mode default).
+	// The connection window is scaled to four times this value.
+	InitialWindow uint64 `json:"initial_window,omitempty"`
+
+	// Connections is how many QUIC connections a session opens, striping its
+	// tunnel streams across them (0 or 1 = one)
+	Connections int `json:"connections,omitempty"`
+}
+
+// MaxQUICConnections is the most QUIC connections one session may open
+const MaxQUICConnections = 8
+
+// Stripes returns how many connections a session opens beyond the first
+func (t *QUICTuning) Stripes() int {
+	if t == nil || t.Connections <= 1 {
+		return 0
+	}
+	if t.Connections > MaxQUICConnections {
+		return MaxQUICConnections - 1
+	}
+	return t.Connections - 1
+}
+
+// ValidateCongestionControl reports whether name can be used with the bundled quic-go
+func ValidateCongestionControl(name string) error {
+	if name == "" {
+		return nil
+	}
+	for _, supported := range SupportedCongestionControls {
+		if name == supported {
+			return nil
+		}
+	}
+	switch name {
+	case CongestionReno, CongestionBBR:
+		return fmt.Errorf("congestion controller %q is not supported by the bundled quic-go (supported: %v)", name, SupportedCongestionControls)
+	default:
+		return fmt.Errorf("unknown congestion controller %q (supported: %v)", name, SupportedCongestionControls)
+	}
+}
+
+// Apply applies the tuning to a quic.Config. Max windows are raised if needed
+// so they never fall below the initial windows. Window settings are applied
+// even if the congestion controller is unsupported, which is reported as an error.
+func (t *QUICTuning) Apply(cfg *quic.Config) error {
+	if t == nil {
+		return nil
+	}
+
+	if t.InitialWindow > 0 {
+		cfg.InitialStreamReceiveWindow = t.InitialWindow
+		if cfg.MaxStreamReceiveWindow < t.InitialWindow {
+			cfg.MaxStreamReceiveWindow = t.InitialWindow
+		}
+
+		connWindow := t.InitialWindow * 4
+		cfg.InitialConnectionReceiveWindow = connWindow
+		if cfg.MaxConnectionReceiveWindow < connWindow {
+			cfg.MaxConnectionReceiveWindow = connWindow
+		}
+	}
+	return ValidateCongestionControl(t.CongestionControl)
+}
@@ -0,0 +1,207 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BandwidthLimits caps proxied throughput in bytes per second (0 = unlimited).
+// Each cap counts both directions of a connection together.
+type BandwidthLimits struct {
+	Global         int64 // shared by every connection
+	PerClient      int64 // per SOCKS5 client IP
+	PerDestination int64 // per destination host
+}
+
+// IsZero reports whether no limits are set
+func (l BandwidthLimits) IsZero() bool {
+	return l.Global == 0 && l.PerClient == 0 && l.PerDestination == 0
+}
+
+// RateLimiter is a token bucket measured in bytes. Callers may take more
+// tokens than the bucket holds; the debt is paid off by waiting, so any read
+// size can be limited. A nil *RateLimiter never blocks.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a limiter allowing bytesPerSecond with a one-second
+// burst. It returns nil (unlimited) when bytesPerSecond is not positive.
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &RateLimiter{
+		rate:   float64(bytesPerSecond),
+		burst:  float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+	}
+}
+
+// WaitN takes n tokens, blocking until they are available or ctx is done
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	deficit := -l.tokens
+	l.mu.Unlock()
+
+	if deficit <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Duration(deficit / l.rate * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RateLimiters applies several limiters to the same bytes
+type RateLimiters []*RateLimiter
+
+// WaitN takes n tokens from every limiter
+func (ls RateLimiters) WaitN(ctx context.Context, n int) error {
+	for _, l := range ls {
+		if err := l.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RateLimiterSet hands out one limiter per key, e.g. per client IP, so that
+// every connection with the same key shares a budget. Limiters are dropped
+// once no connection holds them.
+type RateLimiterSet struct {
+	bytesPerSecond int64
+	mu             sync.Mutex
+	limiters       map[string]*keyedLimiter
+}
+
+type keyedLimiter struct {
+	limiter *RateLimiter
+	refs    int
+}
+
+// NewRateLimiterSet creates a set of per-key limiters. It returns nil
+// (unlimited) when bytesPerSecond is not positive.
+func NewRateLimiterSet(bytesPerSecond int64) *RateLimiterSet {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &RateLimiterSet{
+		bytesPerSecond: bytesPerSecond,
+		limiters:       make(map[string]*keyedLimiter),
+	}
+}
+
+// Acquire returns the limiter for key and a function releasing it. Both are
+// safe to use on a nil set.
+func (s *RateLimiterSet) Acquire(key string) (*RateLimiter, func()) {
+	if s == nil {
+		return nil, func() {}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.limiters[key]
+	if !ok {
+		entry = &keyedLimiter{limiter: NewRateLimiter(s.bytesPerSecond)}
+		s.limiters[key] = entry
+	}
+	entry.refs++
+
+	var once sync.Once
+	return entry.limiter, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			entry.refs--
+			if entry.refs == 0 {
+				delete(s.limiters, key)
+			}
+		})
+	}
+}
+
+// Len returns how many keys currently hold a limiter
+func (s *RateLimiterSet) Len() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.limiters)
+}
+
+// ParseByteRate parses a bandwidth like "5MB", "5MB/s", "512KB" or "1048576"
+// into bytes per second. Units are binary (1KB = 1024 bytes). An empty
+// string means unlimited and parses as 0.
+func ParseByteRate(s string) (int64, error) {
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	upper = strings.TrimSuffix(upper, "/S")
+	upper = strings.TrimSuffix(upper, "PS")
+	rate, err := ParseByteSize(upper)
+	if err != nil {
+		return 0, fmt.Errorf("invalid byte rate %q", s)
+	}
+	return rate, nil
+}
+
+// ParseByteSize parses a size like "512MB", "1.5GB" or "1048576" into bytes.
+// Units are binary (1KB = 1024 bytes). An empty string parses as 0.
+func ParseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	upper := strings.ToUpper(s)
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{
+		{"GB", 1 << 30}, {"G", 1 << 30},
+		{"MB", 1 << 20}, {"M", 1 << 20},
+		{"KB", 1 << 10}, {"K", 1 << 10},
+		{"B", 1},
+	} {
+		if strings.HasSuffix(upper, unit.suffix) {
+			upper = strings.TrimSuffix(upper, unit.suffix)
+			multiplier = unit.size
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(upper), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid byte size %q", s)
+	}
+	if value < 0 {
+		return 0, fmt.Errorf("byte size %q cannot be negative", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
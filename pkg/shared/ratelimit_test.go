@@ -0,0 +1,116 @@
+package shared
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseByteRate(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+		wantErr  bool
+	}{
+		{"", 0, false},
+		{"1048576", 1048576, false},
+		{"512KB", 512 * 1024, false},
+		{"5MB", 5 * 1024 * 1024, false},
+		{"5mb/s", 5 * 1024 * 1024, false},
+		{"1.5M", 3 * 512 * 1024, false},
+		{"1GBps", 1 << 30, false},
+		{"fast", 0, true},
+		{"-1MB", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			rate, err := ParseByteRate(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseByteRate(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if rate != tt.expected {
+				t.Errorf("ParseByteRate(%q) = %d, expected %d", tt.input, rate, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRateLimiterWaitN(t *testing.T) {
+	l := NewRateLimiter(100 * 1024)
+	ctx := context.Background()
+
+	// The first second's worth is available immediately as burst
+	start := time.Now()
+	if err := l.WaitN(ctx, 100*1024); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Expected burst to pass immediately, took %v", elapsed)
+	}
+
+	// The next 20KB has to wait roughly 200ms
+	start = time.Now()
+	if err := l.WaitN(ctx, 20*1024); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Expected to be throttled for ~200ms, took %v", elapsed)
+	}
+}
+
+func TestRateLimiterWaitNCancelled(t *testing.T) {
+	l := NewRateLimiter(1024)
+	l.WaitN(context.Background(), 1024)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.WaitN(ctx, 1024*1024); err == nil {
+		t.Error("Expected error when context expires while throttled")
+	}
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	if l := NewRateLimiter(0); l != nil {
+		t.Fatal("Expected nil limiter for zero rate")
+	}
+	var limits RateLimiters = []*RateLimiter{nil, nil}
+	if err := limits.WaitN(context.Background(), 1<<30); err != nil {
+		t.Errorf("Expected nil limiters never to block, got %v", err)
+	}
+}
+
+func TestRateLimiterSetSharesAndReleases(t *testing.T) {
+	s := NewRateLimiterSet(1024)
+
+	a, releaseA := s.Acquire("10.0.0.1")
+	b, releaseB := s.Acquire("10.0.0.1")
+	c, releaseC := s.Acquire("10.0.0.2")
+	if a != b {
+		t.Error("Expected connections with the same key to share a limiter")
+	}
+	if a == c {
+		t.Error("Expected different keys to get separate limiters")
+	}
+	if s.Len() != 2 {
+		t.Errorf("Expected 2 keys, got %d", s.Len())
+	}
+
+	releaseA()
+	releaseA() // releasing twice must not drop b's reference
+	if s.Len() != 2 {
+		t.Errorf("Expected key to stay while still in use, got %d keys", s.Len())
+	}
+	releaseB()
+	releaseC()
+	if s.Len() != 0 {
+		t.Errorf("Expected all keys released, got %d", s.Len())
+	}
+
+	var unlimited *RateLimiterSet
+	if l, release := unlimited.Acquire("x"); l != nil {
+		t.Error("Expected nil limiter from nil set")
+	} else {
+		release()
+	}
+}
@@ -0,0 +1,86 @@
+package shared
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// SOCKS5 reply codes a refused connection can be given, by config name
+var refusalReplies = map[string]byte{
+	"not-allowed":         SOCKS5NotAllowed,
+	"general-failure":     SOCKS5Failed,
+	"network-unreachable": SOCKS5NetworkUnreachable,
+	"host-unreachable":    SOCKS5HostUnreachable,
+	"connection-refused":  SOCKS5ConnectionRefused,
+}
+
+// RefusalPolicy controls what clients refused by the ACL, the connection
+// limit or a resource limit pause are told
+type RefusalPolicy struct {
+	// Reply is the SOCKS5 reply code (0 = SOCKS5NotAllowed, "connection not
+	// allowed by ruleset")
+	Reply byte
+
+	// WhilePaused refuses new connections while the proxy is over a resource
+	// limit, instead of leaving them in the listen backlog until it recovers
+	WhilePaused bool
+
+	// HTTPPage answers refused clients that speak HTTP, e.g. a browser set up
+	// to use the SOCKS5 port as an HTTP proxy, with a 403 page saying why
+	HTTPPage bool
+}
+
+// ParseRefusalReply parses a reply name such as "not-allowed" or
+// "general-failure". An empty name selects "not-allowed".
+func ParseRefusalReply(name string) (byte, error) {
+	if name == "" {
+		return SOCKS5NotAllowed, nil
+	}
+	code, ok := refusalReplies[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown SOCKS5 reply %q (expected not-allowed, general-failure, network-unreachable, host-unreachable or connection-refused)", name)
+	}
+	return code, nil
+}
+
+// SOCKS5Reply builds a CONNECT reply with code and an empty bound address
+func SOCKS5Reply(code byte) []byte {
+	return []byte{SOCKS5Version, code, 0x00, SOCKS5IPv4, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+}
+
+// RefusalReply returns the SOCKS5 reply for refused connections
+func (p RefusalPolicy) RefusalReply() []byte {
+	if p.Reply == 0 {
+		return SOCKS5NotAllowedResponse
+	}
+	return SOCKS5Reply(p.Reply)
+}
+
+// httpMethods are the request line prefixes recognised by LooksLikeHTTP
+var httpMethods = [][]byte{
+	[]byte("GET "), []byte("POST "), []byte("PUT "), []byte("HEAD "), []byte("DELETE "),
+	[]byte("OPTIONS "), []byte("PATCH "), []byte("CONNECT "),
+}
+
+// LooksLikeHTTP reports whether data starts with an HTTP request line
+func LooksLikeHTTP(data []byte) bool {
+	for _, method := range httpMethods {
+		if bytes.HasPrefix(data, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// HTTPRefusalPage builds a 403 response explaining why the proxy refused
+// the connection
+func HTTPRefusalPage(reason string) []byte {
+	body := fmt.Sprintf("<!DOCTYPE html>\n<html><head><title>403 Forbidden</title></head>\n"+
+		"<body><h1>Connection refused by lambda-nat-proxy</h1>\n<p>%s</p>\n"+
+		"<p>This port is a SOCKS5 proxy; configure your client to use SOCKS5.</p></body></html>\n",
+		html.EscapeString(reason))
+	return []byte(fmt.Sprintf("HTTP/1.1 403 Forbidden\r\nContent-Type: text/html; charset=utf-8\r\n"+
+		"Content-Length: %d\r\nConnection: close\r\n\r\n%s", len(body), body))
+}
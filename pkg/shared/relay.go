@@ -0,0 +1,343 @@
+package shared
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// On networks that block all outbound UDP, a session can run through a
+// relay instead of a punched hole. The orchestrator and the Lambda each open
+// a TCP connection, optionally TLS, to the relay and send a hello naming the
+// session. The relay pairs the two connections and copies bytes between
+// them, and QUIC runs over the pair with each datagram prefixed by its
+// length. It is slower than UDP, since a lost segment stalls every stream,
+// but works wherever outbound TCP does.
+
+// Relay modes
+const (
+	RelayModeFallback = "fallback" // relay sessions once a launch finds UDP blocked
+	RelayModeAlways   = "always"   // relay every session
+)
+
+// Roles a relay connection is opened in
+const (
+	RelayRoleOrchestrator byte = 0x01
+	RelayRoleLambda       byte = 0x02
+)
+
+// Relay answers to a hello
+const (
+	RelayStatusPaired  byte = 0x00 // the other side is connected; datagrams follow
+	RelayStatusDenied  byte = 0x01 // the token is wrong
+	RelayStatusTimeout byte = 0x02 // the other side didn't connect in time
+	RelayStatusBusy    byte = 0x03 // the session already has a connection in this role
+)
+
+const (
+	RelayProtocolVersion byte = 1
+
+	RelayHelloTimeout = 10 * time.Second // time the relay waits for a hello
+	RelayPairTimeout  = 60 * time.Second // time the relay holds a connection waiting for the other side
+
+	relayMagic         = "LNPR"
+	maxRelayFieldBytes = 1024
+)
+
+// RelaySettings tell both ends how to reach the relay. They travel to the
+// Lambda in the coordination data, and a session coordinated without a UDP
+// endpoint is run through them.
+type RelaySettings struct {
+	// URL is tcp://host:port or tls://host:port
+	URL string `json:"url"`
+
+	// Token is the shared secret the relay admits connections with
+	Token string `json:"token"`
+
+	// Fingerprint is the hex SHA-256 of the relay's TLS certificate, checked
+	// instead of its CA chain, for a self-signed relay (empty = system roots)
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// Validate checks the URL and that a token is set
+func (s RelaySettings) Validate() error {
+	useTLS, _, err := ParseRelayURL(s.URL)
+	if err != nil {
+		return err
+	}
+	if s.Token == "" {
+		return fmt.Errorf("relay token is required")
+	}
+	if len(s.Token) > maxRelayFieldBytes {
+		return fmt.Errorf("relay token is longer than %d bytes", maxRelayFieldBytes)
+	}
+	if s.Fingerprint != "" {
+		if !useTLS {
+			return fmt.Errorf("a relay fingerprint needs a tls:// URL")
+		}
+		if _, err := parseFingerprint(s.Fingerprint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseRelayURL returns whether a tcp:// or tls:// relay URL uses TLS, and
+// its host:port
+func ParseRelayURL(raw string) (bool, string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false, "", fmt.Errorf("invalid relay URL %q: %w", raw, err)
+	}
+	if u.Host == "" || u.Port() == "" {
+		return false, "", fmt.Errorf("relay URL %q needs a host and port, e.g. tls://relay.example.com:443", raw)
+	}
+	switch u.Scheme {
+	case "tcp":
+		return false, u.Host, nil
+	case "tls":
+		return true, u.Host, nil
+	}
+	return false, "", fmt.Errorf("relay URL %q must start with tcp:// or tls://", raw)
+}
+
+// CertificateFingerprint returns the hex SHA-256 of a DER certificate, as
+// RelaySettings.Fingerprint expects it
+func CertificateFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// parseFingerprint decodes a hex SHA-256, with or without colons
+func parseFingerprint(s string) ([]byte, error) {
+	sum, err := hex.DecodeString(strings.ReplaceAll(s, ":", ""))
+	if err != nil || len(sum) != sha256.Size {
+		return nil, fmt.Errorf("relay fingerprint %q is not a hex SHA-256", s)
+	}
+	return sum, nil
+}
+
+// relayTLSConfig verifies the relay at host against the system roots, or
+// against fingerprint alone when it is set
+func relayTLSConfig(host, fingerprint string) (*tls.Config, error) {
+	config := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	if fingerprint == "" {
+		return config, nil
+	}
+	want, err := parseFingerprint(fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	config.InsecureSkipVerify = true // replaced by the fingerprint check
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("relay sent no certificate")
+		}
+		got := sha256.Sum256(rawCerts[0])
+		if subtle.ConstantTimeCompare(got[:], want) != 1 {
+			return fmt.Errorf("relay certificate fingerprint %s doesn't match %s", hex.EncodeToString(got[:]), fingerprint)
+		}
+		return nil
+	}
+	return config, nil
+}
+
+// DialRelay connects to the relay in role for sessionID and waits, until ctx
+// is done, for the session's other side to connect. The connection returned
+// carries the session's datagrams; wrap it with NewRelayPacketConn.
+func DialRelay(ctx context.Context, settings RelaySettings, role byte, sessionID string) (net.Conn, error) {
+	useTLS, addr, err := ParseRelayURL(settings.URL)
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to relay %s: %w", addr, err)
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetNoDelay(true)
+	}
+	if useTLS {
+		host, _, _ := net.SplitHostPort(addr)
+		config, err := relayTLSConfig(host, settings.Fingerprint)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed TLS handshake with relay %s: %w", addr, err)
+		}
+		conn = tlsConn
+	}
+
+	// Unblock the hello and the wait for the other side when ctx is done
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if err := WriteRelayHello(conn, role, sessionID, settings.Token); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send relay hello: %w", err)
+	}
+	var status [1]byte
+	if _, err := io.ReadFull(conn, status[:]); err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("relay session %s wasn't paired: %w", sessionID, ctx.Err())
+		}
+		return nil, fmt.Errorf("failed to read relay answer: %w", err)
+	}
+	if !stop() {
+		conn.Close()
+		return nil, fmt.Errorf("relay session %s wasn't paired: %w", sessionID, ctx.Err())
+	}
+	if err := RelayStatusError(status[0]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// RelayStatusError returns the error a relay answer stands for, nil for
+// RelayStatusPaired
+func RelayStatusError(status byte) error {
+	switch status {
+	case RelayStatusPaired:
+		return nil
+	case RelayStatusDenied:
+		return fmt.Errorf("relay refused the token")
+	case RelayStatusTimeout:
+		return fmt.Errorf("relay timed out waiting for the session's other side")
+	case RelayStatusBusy:
+		return fmt.Errorf("relay already has a connection for this session")
+	}
+	return fmt.Errorf("unknown relay answer 0x%02x", status)
+}
+
+// WriteRelayHello writes the hello a relay connection opens with:
+//
+//	"LNPR" | version (1) | role (1) | len (2) | session ID | len (2) | token
+func WriteRelayHello(w io.Writer, role byte, sessionID, token string) error {
+	if len(sessionID) > maxRelayFieldBytes || len(token) > maxRelayFieldBytes {
+		return fmt.Errorf("relay session ID or token longer than %d bytes", maxRelayFieldBytes)
+	}
+	buf := make([]byte, 0, len(relayMagic)+6+len(sessionID)+len(token))
+	buf = append(buf, relayMagic...)
+	buf = append(buf, RelayProtocolVersion, role)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(sessionID)))
+	buf = append(buf, sessionID...)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(token)))
+	buf = append(buf, token...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadRelayHello reads a hello written by WriteRelayHello
+func ReadRelayHello(r io.Reader) (role byte, sessionID, token string, err error) {
+	var head [len(relayMagic) + 2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, "", "", err
+	}
+	if string(head[:len(relayMagic)]) != relayMagic {
+		return 0, "", "", fmt.Errorf("not a relay hello")
+	}
+	if version := head[len(relayMagic)]; version != RelayProtocolVersion {
+		return 0, "", "", fmt.Errorf("unsupported relay protocol version %d", version)
+	}
+	role = head[len(relayMagic)+1]
+	if role != RelayRoleOrchestrator && role != RelayRoleLambda {
+		return 0, "", "", fmt.Errorf("unknown relay role 0x%02x", role)
+	}
+	if sessionID, err = readRelayField(r); err != nil {
+		return 0, "", "", err
+	}
+	if token, err = readRelayField(r); err != nil {
+		return 0, "", "", err
+	}
+	return role, sessionID, token, nil
+}
+
+func readRelayField(r io.Reader) (string, error) {
+	var n [2]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return "", err
+	}
+	length := int(binary.BigEndian.Uint16(n[:]))
+	if length > maxRelayFieldBytes {
+		return "", fmt.Errorf("relay hello field of %d bytes is too long", length)
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// relayPacketConn carries datagrams over a paired relay connection, each
+// prefixed with its length. Every datagram comes from, and goes to, the
+// relay's address.
+type relayPacketConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	writeMu sync.Mutex
+	header  [2]byte
+}
+
+// NewRelayPacketConn returns a net.PacketConn, for QUIC, that exchanges
+// datagrams with the other side of a connection returned by DialRelay
+func NewRelayPacketConn(conn net.Conn) net.PacketConn {
+	return &relayPacketConn{conn: conn, reader: bufio.NewReaderSize(conn, 64*1024)}
+}
+
+// ReadFrom reads the next datagram. One longer than p is truncated, like
+// a UDP read.
+func (c *relayPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return 0, nil, err
+	}
+	length := int(binary.BigEndian.Uint16(header[:]))
+	n, err := io.ReadFull(c.reader, p[:min(length, len(p))])
+	if err != nil {
+		return n, nil, err
+	}
+	if _, err := c.reader.Discard(length - n); err != nil {
+		return n, nil, err
+	}
+	return n, c.conn.RemoteAddr(), nil
+}
+
+// WriteTo sends p to the other side, whatever addr is
+func (c *relayPacketConn) WriteTo(p []byte, _ net.Addr) (int, error) {
+	if len(p) > 0xFFFF {
+		return 0, fmt.Errorf("datagram of %d bytes is too long for the relay", len(p))
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	binary.BigEndian.PutUint16(c.header[:], uint16(len(p)))
+	if _, err := (&net.Buffers{c.header[:], p}).WriteTo(c.conn); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *relayPacketConn) Close() error                       { return c.conn.Close() }
+func (c *relayPacketConn) LocalAddr() net.Addr                { return c.conn.LocalAddr() }
+func (c *relayPacketConn) SetDeadline(t time.Time) error      { return c.conn.SetDeadline(t) }
+func (c *relayPacketConn) SetReadDeadline(t time.Time) error  { return c.conn.SetReadDeadline(t) }
+func (c *relayPacketConn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
@@ -0,0 +1,216 @@
+package shared
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+)
+
+// Reply frames answer a stream frame that asked for one (OptReplyFrame).
+// Older Lambdas answer with a single SOCKS5Response byte, so a reader
+// accepts both and tells them apart by the first byte.
+//
+// Format: [1 byte marker][1 byte version][1 byte code][2 bytes options length]
+// [options]
+//
+// Options use the stream frame's encoding; unknown options are skipped.
+
+// StreamReplyVersion is the reply frame version written by this build
+const StreamReplyVersion byte = 1
+
+// streamReplyMarker opens a reply frame; response bytes never take its value
+const streamReplyMarker byte = 0xFE
+
+// Reply frame option types
+const (
+	ReplyOptReason     byte = 0x01 // why the Lambda refused or failed the stream
+	ReplyOptErrorClass byte = 0x02 // 1 byte ErrorClass
+)
+
+// ErrorClass says what kind of failure the Lambda met dialing a target
+type ErrorClass byte
+
+const (
+	ErrorClassUnknown            ErrorClass = 0x00
+	ErrorClassRefused            ErrorClass = 0x01 // the target refused the connection
+	ErrorClassTimeout            ErrorClass = 0x02 // the dial timed out
+	ErrorClassNetworkUnreachable ErrorClass = 0x03
+	ErrorClassHostUnreachable    ErrorClass = 0x04
+	ErrorClassDNS                ErrorClass = 0x05 // the target's name didn't resolve
+	ErrorClassDenied             ErrorClass = 0x06 // blocked by the Lambda's ACL
+	ErrorClassUnsupported        ErrorClass = 0x07 // the Lambda doesn't serve the stream command
+)
+
+// ClassifyDialError returns the class of an error from dialing a target
+func ClassifyDialError(err error) ErrorClass {
+	var dnsErr *net.DNSError
+	var resolveErr *ResolveError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return ErrorClassUnknown
+	case errors.Is(err, ErrACLDenied):
+		return ErrorClassDenied
+	case errors.As(err, &dnsErr), errors.As(err, &resolveErr):
+		return ErrorClassDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorClassRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return ErrorClassNetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH):
+		return ErrorClassHostUnreachable
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorClassTimeout
+	}
+	return ErrorClassUnknown
+}
+
+// SOCKS5Reply returns the SOCKS5 reply code for failures of class c
+func (c ErrorClass) SOCKS5Reply() byte {
+	switch c {
+	case ErrorClassRefused:
+		return SOCKS5ConnectionRefused
+	case ErrorClassTimeout:
+		return SOCKS5TTLExpired
+	case ErrorClassNetworkUnreachable:
+		return SOCKS5NetworkUnreachable
+	case ErrorClassHostUnreachable, ErrorClassDNS:
+		return SOCKS5HostUnreachable
+	case ErrorClassDenied:
+		return SOCKS5NotAllowed
+	case ErrorClassUnsupported:
+		return SOCKS5CommandNotSupported
+	}
+	return SOCKS5Failed
+}
+
+// String names the class for logs and metrics
+func (c ErrorClass) String() string {
+	switch c {
+	case ErrorClassRefused:
+		return "refused"
+	case ErrorClassTimeout:
+		return "timeout"
+	case ErrorClassNetworkUnreachable:
+		return "network-unreachable"
+	case ErrorClassHostUnreachable:
+		return "host-unreachable"
+	case ErrorClassDNS:
+		return "dns"
+	case ErrorClassDenied:
+		return "denied"
+	case ErrorClassUnsupported:
+		return "unsupported"
+	}
+	return "unknown"
+}
+
+// StreamReply is the Lambda's answer to a stream header
+type StreamReply struct {
+	Code   SOCKS5Response
+	Class  ErrorClass // optional, for failures
+	Reason string     // optional, for failures
+}
+
+// FailedStreamReply builds the reply for a stream the Lambda couldn't
+// connect because of err
+func FailedStreamReply(err error) StreamReply {
+	class := ClassifyDialError(err)
+	code := SOCKS5ResponseError
+	if class == ErrorClassDenied {
+		code = SOCKS5ResponseDenied
+	}
+	return StreamReply{Code: code, Class: class, Reason: err.Error()}
+}
+
+// OK reports whether the Lambda accepted the stream
+func (r StreamReply) OK() bool {
+	return r.Code == SOCKS5ResponseSuccess
+}
+
+// MarshalBinary encodes the reply as a reply frame
+func (r StreamReply) MarshalBinary() ([]byte, error) {
+	var options frameOptions
+	reason := r.Reason
+	if len(reason) > 255 {
+		reason = reason[:255]
+	}
+	if err := options.putString(ReplyOptReason, reason); err != nil {
+		return nil, err
+	}
+	if r.Class != ErrorClassUnknown {
+		if err := options.put(ReplyOptErrorClass, []byte{byte(r.Class)}); err != nil {
+			return nil, err
+		}
+	}
+
+	data := make([]byte, 5, 5+len(options))
+	data[0] = streamReplyMarker
+	data[1] = StreamReplyVersion
+	data[2] = byte(r.Code)
+	binary.BigEndian.PutUint16(data[3:], uint16(len(options)))
+	return append(data, options...), nil
+}
+
+// WriteStreamReply answers a stream header with reply, as a reply frame if
+// the header asked for one and as a single response byte otherwise
+func WriteStreamReply(stream io.Writer, reply StreamReply, framed bool) error {
+	if !framed {
+		return WriteSOCKS5Response(stream, reply.Code)
+	}
+
+	data, err := reply.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if _, err := stream.Write(data); err != nil {
+		return fmt.Errorf("failed to write stream reply: %w", err)
+	}
+	return nil
+}
+
+// ReadStreamReply reads the Lambda's answer to a stream header, accepting
+// both reply frames and single response bytes
+func ReadStreamReply(stream io.Reader) (StreamReply, error) {
+	var lead [1]byte
+	if _, err := io.ReadFull(stream, lead[:]); err != nil {
+		return StreamReply{}, fmt.Errorf("failed to read lambda response: %w", err)
+	}
+	if lead[0] != streamReplyMarker {
+		return StreamReply{Code: SOCKS5Response(lead[0])}, nil
+	}
+
+	var r StreamReply
+	var head [4]byte
+	if _, err := io.ReadFull(stream, head[:]); err != nil {
+		return r, fmt.Errorf("failed to read stream reply: %w", err)
+	}
+	if head[0] != StreamReplyVersion {
+		return r, fmt.Errorf("unsupported stream reply version %d", head[0])
+	}
+	r.Code = SOCKS5Response(head[1])
+	optionsLen := binary.BigEndian.Uint16(head[2:])
+	if optionsLen > MaxTargetAddressLength {
+		return r, fmt.Errorf("stream reply options too long: %d bytes (max %d)", optionsLen, MaxTargetAddressLength)
+	}
+	options := make([]byte, optionsLen)
+	if _, err := io.ReadFull(stream, options); err != nil {
+		return r, fmt.Errorf("failed to read stream reply options: %w", err)
+	}
+
+	err := eachFrameOption(options, func(kind byte, value []byte) {
+		switch kind {
+		case ReplyOptReason:
+			r.Reason = string(value)
+		case ReplyOptErrorClass:
+			if len(value) == 1 {
+				r.Class = ErrorClass(value[0])
+			}
+		}
+	})
+	return r, err
+}
@@ -0,0 +1,104 @@
+package shared
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestStreamReplyRoundTrip(t *testing.T) {
+	replies := []StreamReply{
+		{Code: SOCKS5ResponseSuccess},
+		{Code: SOCKS5ResponseError, Class: ErrorClassRefused, Reason: "dial tcp 10.0.0.1:80: connect: connection refused"},
+		{Code: SOCKS5ResponseDenied, Reason: "10.0.0.1:80 is denied by the ACL"},
+	}
+	for _, reply := range replies {
+		for _, framed := range []bool{true, false} {
+			var buf bytes.Buffer
+			if err := WriteStreamReply(&buf, reply, framed); err != nil {
+				t.Fatalf("Failed to write %+v: %v", reply, err)
+			}
+			// Data the Lambda relays follows the reply
+			buf.WriteString("data")
+
+			got, err := ReadStreamReply(&buf)
+			if err != nil {
+				t.Fatalf("Failed to read %+v: %v", reply, err)
+			}
+			want := reply
+			if !framed {
+				// A single response byte has no room for a class or reason
+				want.Class, want.Reason = ErrorClassUnknown, ""
+			}
+			if got != want {
+				t.Errorf("Expected %+v (framed %v), got %+v", want, framed, got)
+			}
+			if buf.String() != "data" {
+				t.Errorf("Expected the reply to leave the stream's data, got %q", buf.String())
+			}
+		}
+	}
+}
+
+func TestStreamReplyLongReason(t *testing.T) {
+	var buf bytes.Buffer
+	reply := StreamReply{Code: SOCKS5ResponseError, Reason: strings.Repeat("x", 300)}
+	if err := WriteStreamReply(&buf, reply, true); err != nil {
+		t.Fatalf("Expected a long reason to be truncated, got %v", err)
+	}
+	got, err := ReadStreamReply(&buf)
+	if err != nil || len(got.Reason) != 255 {
+		t.Errorf("Expected a 255 byte reason, got %d bytes (%v)", len(got.Reason), err)
+	}
+}
+
+func TestStreamReplyRejectsBadInput(t *testing.T) {
+	valid, _ := StreamReply{Code: SOCKS5ResponseError, Reason: "refused"}.MarshalBinary()
+
+	newerVersion := append([]byte(nil), valid...)
+	newerVersion[1] = StreamReplyVersion + 1
+
+	for name, data := range map[string][]byte{
+		"newer version":   newerVersion,
+		"truncated reply": valid[:len(valid)-1],
+		"empty stream":    nil,
+	} {
+		if _, err := ReadStreamReply(bytes.NewReader(data)); err == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+}
+
+func TestClassifyDialError(t *testing.T) {
+	dialErr := func(errno syscall.Errno) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", errno)}
+	}
+	tests := []struct {
+		err  error
+		want ErrorClass
+	}{
+		{dialErr(syscall.ECONNREFUSED), ErrorClassRefused},
+		{dialErr(syscall.ENETUNREACH), ErrorClassNetworkUnreachable},
+		{dialErr(syscall.EHOSTUNREACH), ErrorClassHostUnreachable},
+		{fmt.Errorf("dial failed: %w", context.DeadlineExceeded), ErrorClassTimeout},
+		{&net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}, ErrorClassDNS},
+		{&ResolveError{Name: "example.invalid", Err: errors.New("no addresses for example.invalid")}, ErrorClassDNS},
+		{fmt.Errorf("%w: 10.0.0.1:80", ErrACLDenied), ErrorClassDenied},
+		{errors.New("something else"), ErrorClassUnknown},
+	}
+	for _, tt := range tests {
+		if got := ClassifyDialError(tt.err); got != tt.want {
+			t.Errorf("ClassifyDialError(%v) = %s, expected %s", tt.err, got, tt.want)
+		}
+	}
+
+	if reply := FailedStreamReply(fmt.Errorf("%w: 10.0.0.1:80", ErrACLDenied)); reply.Code != SOCKS5ResponseDenied {
+		t.Errorf("Expected an ACL denial to be answered as denied, got %+v", reply)
+	}
+}
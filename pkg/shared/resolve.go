@@ -0,0 +1,95 @@
+package shared
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Name resolution routes for ResolverRule
+const (
+	ResolveViaTunnel = "tunnel" // dial the locally resolved address through the Lambda
+	ResolveDirect    = "direct" // dial the locally resolved address from this machine
+)
+
+// ResolverRule resolves matching domains on the orchestrator's side, with a
+// chosen DNS server, instead of on the Lambda. This serves split-horizon DNS,
+// where only the local network gives the right answers for some names.
+type ResolverRule struct {
+	Domains []string // "corp.example.com" (exact) or "*.corp.example.com" (the domain and its subdomains)
+	Server  string   // DNS server, "10.0.0.53" or "10.0.0.53:53" (empty = system resolver)
+	Route   string   // ResolveViaTunnel (default) or ResolveDirect
+}
+
+// Validate checks the rule's domains, server and route
+func (r ResolverRule) Validate() error {
+	if len(r.Domains) == 0 {
+		return fmt.Errorf("resolver rule needs at least one domain")
+	}
+	for _, domain := range r.Domains {
+		pattern := strings.TrimPrefix(domain, "*.")
+		if pattern == "" || strings.Contains(pattern, "*") || strings.ContainsAny(pattern, ":/ ") || net.ParseIP(pattern) != nil {
+			return fmt.Errorf("invalid resolver domain %q (use example.com or *.example.com)", domain)
+		}
+	}
+	if r.Server != "" {
+		host, port, err := net.SplitHostPort(r.ServerAddress())
+		if err != nil {
+			return fmt.Errorf("invalid resolver server %q: %w", r.Server, err)
+		}
+		if portNum, err := strconv.Atoi(port); err != nil || portNum < 1 || portNum > 65535 {
+			return fmt.Errorf("invalid resolver server %q: bad port", r.Server)
+		}
+		if host == "" || (strings.Contains(host, ":") && net.ParseIP(host) == nil) {
+			return fmt.Errorf("invalid resolver server %q", r.Server)
+		}
+	}
+	switch r.Route {
+	case "", ResolveViaTunnel, ResolveDirect:
+	default:
+		return fmt.Errorf("invalid resolver route %q (must be %q or %q)", r.Route, ResolveViaTunnel, ResolveDirect)
+	}
+	return nil
+}
+
+// Matches reports whether host is covered by one of the rule's domains
+func (r ResolverRule) Matches(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, domain := range r.Domains {
+		domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+		if suffix, ok := strings.CutPrefix(domain, "*."); ok {
+			if host == suffix || strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// String describes the rule for logs, e.g.
+// "*.corp.example.com via 10.0.0.53:53, dialed directly"
+func (r ResolverRule) String() string {
+	server := r.ServerAddress()
+	if server == "" {
+		server = "the system resolver"
+	}
+	how := "through the tunnel"
+	if r.Route == ResolveDirect {
+		how = "directly"
+	}
+	return fmt.Sprintf("%s via %s, dialed %s", strings.Join(r.Domains, ", "), server, how)
+}
+
+// ServerAddress returns the DNS server as host:port, defaulting to port 53
+func (r ResolverRule) ServerAddress() string {
+	if r.Server == "" {
+		return ""
+	}
+	if _, _, err := net.SplitHostPort(r.Server); err == nil {
+		return r.Server
+	}
+	return net.JoinHostPort(strings.Trim(r.Server, "[]"), "53")
+}
@@ -0,0 +1,65 @@
+package shared
+
+import "testing"
+
+func TestResolverRuleMatches(t *testing.T) {
+	rule := ResolverRule{Domains: []string{"*.corp.example.com", "intranet"}}
+	tests := []struct {
+		host    string
+		matches bool
+	}{
+		{"corp.example.com", true},
+		{"git.corp.example.com", true},
+		{"GIT.Corp.Example.com.", true},
+		{"intranet", true},
+		{"intranet.example.com", false},
+		{"notcorp.example.com", false},
+		{"example.com", false},
+	}
+	for _, tt := range tests {
+		if got := rule.Matches(tt.host); got != tt.matches {
+			t.Errorf("Matches(%q) = %v, expected %v", tt.host, got, tt.matches)
+		}
+	}
+}
+
+func TestResolverRuleValidate(t *testing.T) {
+	valid := []ResolverRule{
+		{Domains: []string{"*.corp.example.com"}},
+		{Domains: []string{"corp.example.com"}, Server: "10.0.0.53", Route: ResolveDirect},
+		{Domains: []string{"corp.example.com"}, Server: "[fd00::53]:5353", Route: ResolveViaTunnel},
+	}
+	for _, rule := range valid {
+		if err := rule.Validate(); err != nil {
+			t.Errorf("Expected %+v to be valid, got %v", rule, err)
+		}
+	}
+
+	invalid := []ResolverRule{
+		{},
+		{Domains: []string{"corp*.example.com"}},
+		{Domains: []string{"10.0.0.1"}},
+		{Domains: []string{"corp.example.com"}, Route: "sideways"},
+		{Domains: []string{"corp.example.com"}, Server: "10.0.0.53:53:53"},
+	}
+	for _, rule := range invalid {
+		if err := rule.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", rule)
+		}
+	}
+}
+
+func TestResolverRuleServerAddress(t *testing.T) {
+	tests := map[string]string{
+		"":                "",
+		"10.0.0.53":       "10.0.0.53:53",
+		"10.0.0.53:5353":  "10.0.0.53:5353",
+		"fd00::53":        "[fd00::53]:53",
+		"[fd00::53]:5353": "[fd00::53]:5353",
+	}
+	for server, expected := range tests {
+		if got := (ResolverRule{Server: server}).ServerAddress(); got != expected {
+			t.Errorf("ServerAddress(%q) = %q, expected %q", server, got, expected)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package shared
+
+import (
+	"os"
+	"runtime"
+)
+
+// ResourceLimits caps what the proxy process may use before it degrades
+// gracefully (0 = no limit). Past a limit the proxy stops accepting new
+// connections and sheds idle tunnels until usage falls back below it.
+type ResourceLimits struct {
+	MaxGoroutines int
+	MaxOpenFiles  int
+	MaxMemory     int64 // bytes obtained from the OS and not returned to it
+}
+
+// IsZero reports whether no limits are set
+func (l ResourceLimits) IsZero() bool {
+	return l.MaxGoroutines == 0 && l.MaxOpenFiles == 0 && l.MaxMemory == 0
+}
+
+// ResourceUsage is a sample of the process's resource use
+type ResourceUsage struct {
+	Goroutines int
+	OpenFiles  int // -1 when the platform cannot report it
+	Memory     int64
+}
+
+// SampleResourceUsage measures the current process
+func SampleResourceUsage() ResourceUsage {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return ResourceUsage{
+		Goroutines: runtime.NumGoroutine(),
+		OpenFiles:  CountOpenFiles(),
+		Memory:     int64(m.Sys - m.HeapReleased),
+	}
+}
+
+// CountOpenFiles returns the number of open file descriptors, or -1 where
+// neither /proc/self/fd nor /dev/fd is available
+func CountOpenFiles() int {
+	for _, dir := range []string{"/proc/self/fd", "/dev/fd"} {
+		entries, err := os.ReadDir(dir)
+		if err == nil {
+			return len(entries) - 1 // minus the descriptor used to read the directory
+		}
+	}
+	return -1
+}
+
+// Exceeds returns which limit usage is over, or "" if none
+func (u ResourceUsage) Exceeds(l ResourceLimits) string {
+	switch {
+	case l.MaxGoroutines > 0 && u.Goroutines > l.MaxGoroutines:
+		return "goroutines"
+	case l.MaxOpenFiles > 0 && u.OpenFiles > l.MaxOpenFiles:
+		return "open files"
+	case l.MaxMemory > 0 && u.Memory > l.MaxMemory:
+		return "memory"
+	}
+	return ""
+}
+
+// Scaled returns the limits multiplied by factor, e.g. to resume below 90%
+func (l ResourceLimits) Scaled(factor float64) ResourceLimits {
+	return ResourceLimits{
+		MaxGoroutines: int(float64(l.MaxGoroutines) * factor),
+		MaxOpenFiles:  int(float64(l.MaxOpenFiles) * factor),
+		MaxMemory:     int64(float64(l.MaxMemory) * factor),
+	}
+}
@@ -14,6 +14,7 @@ type SOCKS5Response byte
 const (
 	SOCKS5ResponseSuccess SOCKS5Response = 0x00
 	SOCKS5ResponseError   SOCKS5Response = 0x01
+	SOCKS5ResponseDenied  SOCKS5Response = 0x02 // refused by the Lambda's ACL
 )
 
 // SOCKS5TargetRequest represents a parsed target request
@@ -63,19 +64,12 @@ func WriteSOCKS5Response(stream io.Writer, response SOCKS5Response) error {
 // WriteSOCKS5TargetAddress writes a target address in SOCKS5 format
 // Format: [4 bytes length][target address string]
 func WriteSOCKS5TargetAddress(stream io.Writer, target string) error {
-	targetBytes := []byte(target)
-	targetLen := uint32(len(targetBytes))
+	// Write length and target together so they leave in one packet
+	header := make([]byte, 4+len(target))
+	binary.BigEndian.PutUint32(header, uint32(len(target)))
+	copy(header[4:], target)
 
-	// Write length
-	lengthBuf := make([]byte, 4)
-	binary.BigEndian.PutUint32(lengthBuf, targetLen)
-	
-	if _, err := stream.Write(lengthBuf); err != nil {
-		return fmt.Errorf("failed to write target length: %w", err)
-	}
-
-	// Write target address
-	if _, err := stream.Write(targetBytes); err != nil {
+	if _, err := stream.Write(header); err != nil {
 		return fmt.Errorf("failed to write target address: %w", err)
 	}
 
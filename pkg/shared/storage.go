@@ -0,0 +1,99 @@
+package shared
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3 limits on object tags
+const (
+	maxObjectTags        = 10
+	maxObjectTagKeyLen   = 128
+	maxObjectTagValueLen = 256
+)
+
+// objectStorageClasses are the storage classes a coordination or response
+// object can be written with. The archive classes are left out: their
+// objects can't be read back without a restore.
+var objectStorageClasses = []string{
+	string(s3types.StorageClassStandard),
+	string(s3types.StorageClassReducedRedundancy),
+	string(s3types.StorageClassStandardIa),
+	string(s3types.StorageClassOnezoneIa),
+	string(s3types.StorageClassIntelligentTiering),
+	string(s3types.StorageClassGlacierIr),
+}
+
+// ObjectStorage sets the storage class and tags of the coordination and
+// response objects, e.g. a tag a bucket lifecycle rule deletes by
+type ObjectStorage struct {
+	StorageClass string            `json:"storage_class,omitempty"`
+	Tags         map[string]string `json:"tags,omitempty"`
+}
+
+// IsZero reports whether objects are stored with the bucket's defaults
+func (o ObjectStorage) IsZero() bool {
+	return o.StorageClass == "" && len(o.Tags) == 0
+}
+
+// Validate checks the storage class and tags against what S3 accepts
+func (o ObjectStorage) Validate() error {
+	if o.StorageClass != "" && !contains(objectStorageClasses, o.StorageClass) {
+		return fmt.Errorf("invalid storage class %q: must be one of %s", o.StorageClass, strings.Join(objectStorageClasses, ", "))
+	}
+	if len(o.Tags) > maxObjectTags {
+		return fmt.Errorf("too many tags: %d, S3 allows %d per object", len(o.Tags), maxObjectTags)
+	}
+	for key, value := range o.Tags {
+		if key == "" || len(key) > maxObjectTagKeyLen {
+			return fmt.Errorf("invalid tag key %q: must be 1 to %d characters", key, maxObjectTagKeyLen)
+		}
+		if strings.HasPrefix(strings.ToLower(key), "aws:") {
+			return fmt.Errorf("invalid tag key %q: the aws: prefix is reserved", key)
+		}
+		if len(value) > maxObjectTagValueLen {
+			return fmt.Errorf("invalid value for tag %q: longer than %d characters", key, maxObjectTagValueLen)
+		}
+	}
+	return nil
+}
+
+// Apply sets the storage class and tags on a PutObject request
+func (o ObjectStorage) Apply(input *s3.PutObjectInput) {
+	if o.StorageClass != "" {
+		input.StorageClass = s3types.StorageClass(o.StorageClass)
+	}
+	if len(o.Tags) > 0 {
+		input.Tagging = aws.String(o.tagging())
+	}
+}
+
+// tagging encodes the tags as the URL query PutObject takes, sorted by key
+func (o ObjectStorage) tagging() string {
+	keys := make([]string, 0, len(o.Tags))
+	for key := range o.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = url.QueryEscape(key) + "=" + url.QueryEscape(o.Tags[key])
+	}
+	return strings.Join(pairs, "&")
+}
+
+// contains reports whether list holds s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package shared
+
+import (
+	"net"
+	"strings"
+)
+
+// ThroughputTargetDomain holds the names SOCKS5 clients connect to for the
+// throughput test targets the Lambda serves itself. The .invalid TLD is
+// reserved, so the names can never belong to a real destination.
+const ThroughputTargetDomain = "lambda-nat-proxy.invalid"
+
+// Throughput test target names, reachable on any port
+const (
+	EchoTarget    = "echo." + ThroughputTargetDomain
+	DiscardTarget = "discard." + ThroughputTargetDomain
+	SourceTarget  = "source." + ThroughputTargetDomain
+)
+
+// ThroughputTargetCommand returns the stream command serving a SOCKS5 target
+// such as "source.lambda-nat-proxy.invalid:9", and whether it is one
+func ThroughputTargetCommand(target string) (StreamCommand, bool) {
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		host = target
+	}
+	switch strings.ToLower(strings.TrimSuffix(host, ".")) {
+	case EchoTarget:
+		return StreamEcho, true
+	case DiscardTarget:
+		return StreamDiscard, true
+	case SourceTarget:
+		return StreamSource, true
+	}
+	return 0, false
+}
@@ -9,7 +9,10 @@ import (
 	"fmt"
 	"math/big"
 	"net"
+	"strings"
 	"time"
+
+	"github.com/quic-go/quic-go"
 )
 
 // TLSConfigOptions holds configuration options for TLS certificate generation
@@ -63,6 +66,60 @@ func GenerateTLSConfig(opts TLSConfigOptions) (*tls.Config, error) {
 
 	return &tls.Config{
 		Certificates: []tls.Certificate{cert},
-		NextProtos:   []string{"h3"},
+		NextProtos:   TunnelProtocols,
 	}, nil
-}
\ No newline at end of file
+}
+
+// ClientTLSConfig returns the TLS configuration the Lambda dials the
+// orchestrator with. The orchestrator's certificate is self-signed and new
+// each run, so it isn't verified: the tunnel is encrypted but an attacker on
+// the path could impersonate the orchestrator.
+func ClientTLSConfig() *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         TunnelProtocols,
+	}
+}
+
+// TunnelCrypto describes the encryption negotiated on a tunnel connection
+type TunnelCrypto struct {
+	TLSVersion  string   `json:"tls_version"`
+	CipherSuite string   `json:"cipher_suite"`
+	QUICVersion string   `json:"quic_version"`
+	Used0RTT    bool     `json:"used_0rtt,omitempty"`
+	Warnings    []string `json:"warnings,omitempty"` // weaknesses in what was negotiated
+}
+
+// DescribeTunnelCrypto reports the TLS and QUIC versions and cipher suite
+// of a connection, with a warning for each one weaker than QUIC's defaults
+func DescribeTunnelCrypto(state quic.ConnectionState) TunnelCrypto {
+	c := TunnelCrypto{
+		TLSVersion:  tls.VersionName(state.TLS.Version),
+		CipherSuite: tls.CipherSuiteName(state.TLS.CipherSuite),
+		QUICVersion: state.Version.String(),
+		Used0RTT:    state.Used0RTT,
+	}
+	if state.TLS.Version < tls.VersionTLS13 {
+		c.Warnings = append(c.Warnings, fmt.Sprintf("%s is older than TLS 1.3", c.TLSVersion))
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		if suite.ID == state.TLS.CipherSuite {
+			c.Warnings = append(c.Warnings, fmt.Sprintf("cipher suite %s is insecure", c.CipherSuite))
+		}
+	}
+	if state.Version != quic.Version1 && state.Version != quic.Version2 {
+		c.Warnings = append(c.Warnings, fmt.Sprintf("QUIC version %s is not an RFC version", c.QUICVersion))
+	}
+	if state.Used0RTT {
+		c.Warnings = append(c.Warnings, "0-RTT data was accepted and can be replayed")
+	}
+	return c
+}
+
+// String summarizes the negotiated versions and cipher suite for logs and tables
+func (c TunnelCrypto) String() string {
+	if c.TLSVersion == "" {
+		return "-"
+	}
+	return strings.Join([]string{c.TLSVersion, c.CipherSuite, "QUIC " + c.QUICVersion}, ", ")
+}
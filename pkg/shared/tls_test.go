@@ -0,0 +1,31 @@
+package shared
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/quic-go/quic-go"
+)
+
+func TestDescribeTunnelCrypto(t *testing.T) {
+	state := quic.ConnectionState{Version: quic.Version1}
+	state.TLS.Version = tls.VersionTLS13
+	state.TLS.CipherSuite = tls.TLS_AES_128_GCM_SHA256
+
+	c := DescribeTunnelCrypto(state)
+	if c.String() != "TLS 1.3, TLS_AES_128_GCM_SHA256, QUIC v1" || len(c.Warnings) != 0 {
+		t.Errorf("Expected a clean TLS 1.3 connection, got %s %v", c, c.Warnings)
+	}
+
+	state.TLS.Version = tls.VersionTLS12
+	state.TLS.CipherSuite = tls.TLS_RSA_WITH_RC4_128_SHA
+	state.Version = 0xff00001d
+	state.Used0RTT = true
+	if c := DescribeTunnelCrypto(state); len(c.Warnings) != 4 {
+		t.Errorf("Expected a warning for each weak setting, got %v", c.Warnings)
+	}
+
+	if (TunnelCrypto{}).String() != "-" {
+		t.Error("Expected a session without a handshake to show a dash")
+	}
+}
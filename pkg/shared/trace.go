@@ -0,0 +1,211 @@
+package shared
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultTraceSampleRate is the fraction of new traces recorded when
+// TracingConfig.SampleRate is unset: all of them
+const DefaultTraceSampleRate = 1.0
+
+// TracingConfig enables OpenTelemetry tracing. Finished spans are sent in
+// batches to Endpoint with OTLP/HTTP, so any OTLP collector, Jaeger or Tempo
+// can receive them.
+//
+// SampleRate is the fraction of new traces recorded, from 0 to 1. Zero means
+// unset and records DefaultTraceSampleRate; tracing is turned off by leaving
+// Endpoint empty. Spans continuing a trace from another process follow that
+// trace's sampling decision.
+type TracingConfig struct {
+	Endpoint    string            `json:"endpoint"`               // collector base URL, e.g. http://localhost:4318
+	ServiceName string            `json:"service_name,omitempty"` // resource service.name
+	SampleRate  float64           `json:"sample_rate,omitempty"`  // fraction of new traces recorded (0 = DefaultTraceSampleRate)
+	Headers     map[string]string `json:"headers,omitempty"`      // added to every export, e.g. authorization
+}
+
+// Attribute is a span attribute
+type Attribute = attribute.KeyValue
+
+// Attr builds an Attribute. Value may be a string, bool, integer, float or
+// time.Duration (recorded in milliseconds); anything else is formatted.
+func Attr(key string, value interface{}) Attribute {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case float64:
+		return attribute.Float64(key, v)
+	case time.Duration:
+		return attribute.Float64(key, float64(v)/float64(time.Millisecond))
+	default:
+		return attribute.String(key, fmt.Sprint(v))
+	}
+}
+
+// traceContext carries trace context across processes as W3C traceparent
+var traceContext = propagation.TraceContext{}
+
+// Traceparent returns the W3C traceparent of ctx's span, sampled or not, or
+// "" when ctx carries none
+func Traceparent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	traceContext.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// ContextTraceparent returns the traceparent to propagate for ctx's span, or
+// "" when ctx carries no sampled span
+func ContextTraceparent(ctx context.Context) string {
+	if !trace.SpanContextFromContext(ctx).IsSampled() {
+		return ""
+	}
+	return Traceparent(ctx)
+}
+
+// ContextWithTraceparent makes the span described by traceparent, received
+// from another process, the parent of spans started from the returned
+// context. An empty or invalid traceparent leaves ctx unchanged.
+func ContextWithTraceparent(ctx context.Context, traceparent string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+	return traceContext.Extract(ctx, propagation.MapCarrier{"traceparent": traceparent})
+}
+
+// Tracer records spans and hands finished ones to its exporter
+type Tracer struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+}
+
+// Validate checks the endpoint URL and sample rate; an empty endpoint is valid
+func (cfg TracingConfig) Validate() error {
+	if cfg.Endpoint == "" {
+		return nil
+	}
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid tracing endpoint %q: expected http(s)://host:port", cfg.Endpoint)
+	}
+	if cfg.SampleRate < 0 || cfg.SampleRate > 1 {
+		return fmt.Errorf("invalid trace sample rate %v: expected 0 to 1", cfg.SampleRate)
+	}
+	return nil
+}
+
+// NewTracer creates a tracer exporting to cfg.Endpoint. It returns nil, and
+// tracing stays off, when no endpoint is set.
+func NewTracer(cfg TracingConfig) (*Tracer, error) {
+	if err := cfg.Validate(); err != nil || cfg.Endpoint == "" {
+		return nil, err
+	}
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(strings.TrimSuffix(cfg.Endpoint, "/")+"/v1/traces"),
+		otlptracehttp.WithHeaders(cfg.Headers))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+	return newTracer(cfg, exporter), nil
+}
+
+// newTracer creates a tracer batching spans to exporter
+func newTracer(cfg TracingConfig, exporter sdktrace.SpanExporter) *Tracer {
+	rate := cfg.SampleRate
+	if rate == 0 {
+		rate = DefaultTraceSampleRate
+	}
+	service := cfg.ServiceName
+	if service == "" {
+		service = "lambda-nat-proxy"
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(rate))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", service))),
+	)
+	return &Tracer{provider: provider, tracer: provider.Tracer("lambda-nat-proxy")}
+}
+
+// Shutdown exports any pending spans and stops the exporter
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	return t.provider.Shutdown(ctx)
+}
+
+var globalTracer atomic.Pointer[Tracer]
+
+// SetTracer installs the tracer used by StartSpan; nil turns tracing off
+func SetTracer(t *Tracer) {
+	globalTracer.Store(t)
+}
+
+func init() {
+	// Report failed exports in the proxy's own log rather than the SDK's
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		LogErrorf("Trace export failed: %v", err)
+	}))
+}
+
+// Span is one timed operation. A nil *Span ignores every call, so callers
+// never need to check whether tracing is on.
+type Span struct {
+	span trace.Span
+}
+
+// StartSpan starts a span named name as a child of the span in ctx, or a new
+// trace if there is none. It returns ctx unchanged and a nil span when
+// tracing is off.
+func StartSpan(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	t := globalTracer.Load()
+	if t == nil {
+		return ctx, nil
+	}
+	ctx, span := t.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+	return ctx, &Span{span: span}
+}
+
+// SetAttributes adds attributes to the span
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.span.SetAttributes(attrs...)
+}
+
+// RecordError marks the span as failed with err; nil is ignored
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// End finishes the span and queues it for export if its trace is sampled.
+// Later calls do nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.span.End()
+}
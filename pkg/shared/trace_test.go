@@ -0,0 +1,209 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const testTraceparent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+
+func TestContextTraceparent(t *testing.T) {
+	// Without a span there is nothing to propagate
+	if traceparent := ContextTraceparent(context.Background()); traceparent != "" {
+		t.Errorf("Expected no traceparent, got %q", traceparent)
+	}
+
+	ctx := ContextWithTraceparent(context.Background(), testTraceparent)
+	if traceparent := ContextTraceparent(ctx); traceparent != testTraceparent {
+		t.Errorf("Expected %s, got %q", testTraceparent, traceparent)
+	}
+
+	// Unsampled traces aren't propagated in stream frames, but are in full
+	unsampled := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00"
+	ctx = ContextWithTraceparent(context.Background(), unsampled)
+	if traceparent := ContextTraceparent(ctx); traceparent != "" {
+		t.Errorf("Expected unsampled trace to be left out, got %q", traceparent)
+	}
+	if traceparent := Traceparent(ctx); traceparent != unsampled {
+		t.Errorf("Expected %s, got %q", unsampled, traceparent)
+	}
+
+	for _, value := range []string{
+		"",
+		"00-abc-def-01",
+		"ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+		"00-00000000000000000000000000000000-b7ad6b7169203331-01",
+		"00-0af7651916cd43dd8448eb211c80319c-zzad6b7169203331-01",
+	} {
+		if traceparent := Traceparent(ContextWithTraceparent(context.Background(), value)); traceparent != "" {
+			t.Errorf("Expected traceparent %q to be ignored, got %q", value, traceparent)
+		}
+	}
+}
+
+func TestTracingConfigValidate(t *testing.T) {
+	valid := []TracingConfig{
+		{},
+		{Endpoint: "http://localhost:4318"},
+		{Endpoint: "https://otel.example.com", SampleRate: 0.5},
+	}
+	for _, cfg := range valid {
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Expected %+v to be valid, got %v", cfg, err)
+		}
+	}
+
+	invalid := []TracingConfig{
+		{Endpoint: "localhost:4318"},
+		{Endpoint: "grpc://localhost:4317"},
+		{Endpoint: "http://localhost:4318", SampleRate: 2},
+	}
+	for _, cfg := range invalid {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Expected %+v to be invalid", cfg)
+		}
+	}
+}
+
+func TestStartSpanDisabled(t *testing.T) {
+	SetTracer(nil)
+	ctx, span := StartSpan(context.Background(), "test")
+	if span != nil {
+		t.Fatal("Expected nil span with tracing off")
+	}
+	// Nil spans ignore every call
+	span.SetAttributes(Attr("key", "value"))
+	span.RecordError(errors.New("failed"))
+	span.End()
+	if traceparent := Traceparent(ctx); traceparent != "" {
+		t.Errorf("Expected no trace context with tracing off, got %q", traceparent)
+	}
+}
+
+func TestTracerRecordsSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := newTracer(TracingConfig{ServiceName: "test-service"}, exporter)
+	SetTracer(tracer)
+	defer SetTracer(nil)
+
+	ctx, parent := StartSpan(context.Background(), "session.launch", Attr("region", "us-west-2"))
+	_, child := StartSpan(ctx, "stun.discover", Attr("elapsed", 1500*time.Millisecond))
+	child.RecordError(errors.New("timeout"))
+	child.End()
+	parent.End()
+	parent.End() // ended spans are exported once
+
+	// Flush rather than shut down, which would clear the exporter
+	if err := tracer.provider.ForceFlush(context.Background()); err != nil {
+		t.Fatalf("Failed to flush spans: %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	exportedChild, exportedParent := spans[0], spans[1]
+	if exportedChild.Name != "stun.discover" || exportedParent.Name != "session.launch" {
+		t.Errorf("Unexpected span names %q, %q", exportedChild.Name, exportedParent.Name)
+	}
+	if exportedChild.Parent.SpanID() != exportedParent.SpanContext.SpanID() ||
+		exportedChild.SpanContext.TraceID() != exportedParent.SpanContext.TraceID() {
+		t.Error("Expected stun.discover to be a child of session.launch")
+	}
+	if exportedParent.Parent.IsValid() {
+		t.Errorf("Expected root span, got parent %s", exportedParent.Parent.SpanID())
+	}
+	if exportedChild.Status.Code != codes.Error || exportedChild.Status.Description != "timeout" {
+		t.Errorf("Expected error status, got %+v", exportedChild.Status)
+	}
+	if attr := exportedParent.Attributes[0]; attr.Key != "region" || attr.Value.AsString() != "us-west-2" {
+		t.Errorf("Expected region attribute, got %+v", attr)
+	}
+	if attr := exportedChild.Attributes[0]; attr.Value.AsFloat64() != 1500 {
+		t.Errorf("Expected a duration in milliseconds, got %+v", attr)
+	}
+	if service, ok := exportedParent.Resource.Set().Value("service.name"); !ok || service.AsString() != "test-service" {
+		t.Errorf("Expected service.name test-service, got %v", service)
+	}
+}
+
+func TestTracerSampling(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := newTracer(TracingConfig{SampleRate: 0.000001}, exporter)
+	SetTracer(tracer)
+	defer SetTracer(nil)
+
+	// A trace continued from another process keeps its decision
+	ctx := ContextWithTraceparent(context.Background(), testTraceparent)
+	_, sampled := StartSpan(ctx, "tunnel.open")
+	sampled.End()
+	ctx = ContextWithTraceparent(context.Background(), "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00")
+	_, unsampled := StartSpan(ctx, "tunnel.open")
+	unsampled.End()
+	tracer.provider.ForceFlush(context.Background())
+
+	if spans := exporter.GetSpans(); len(spans) != 1 || spans[0].Parent.SpanID().String() != "b7ad6b7169203331" {
+		t.Errorf("Expected only the sampled parent's child to be recorded, got %d spans", len(spans))
+	}
+
+	// An unset rate records every new trace
+	exporter = tracetest.NewInMemoryExporter()
+	tracer = newTracer(TracingConfig{}, exporter)
+	SetTracer(tracer)
+	for i := 0; i < 10; i++ {
+		_, span := StartSpan(context.Background(), "session.launch")
+		span.End()
+	}
+	tracer.provider.ForceFlush(context.Background())
+	if spans := exporter.GetSpans(); len(spans) != 10 {
+		t.Errorf("Expected every trace recorded at the default rate, got %d of 10", len(spans))
+	}
+}
+
+func TestTracerExportsOTLP(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+		headers  []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("Expected /v1/traces, got %s", r.URL.Path)
+		}
+		mu.Lock()
+		requests++
+		headers = append(headers, r.Header.Get("Authorization"))
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	tracer, err := NewTracer(TracingConfig{
+		Endpoint: server.URL,
+		Headers:  map[string]string{"Authorization": "Bearer token"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create tracer: %v", err)
+	}
+	SetTracer(tracer)
+	defer SetTracer(nil)
+
+	_, span := StartSpan(context.Background(), "session.launch")
+	span.End()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Failed to shut down tracer: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 1 || headers[0] != "Bearer token" {
+		t.Fatalf("Expected one authorized export, got %d with %v", requests, headers)
+	}
+}
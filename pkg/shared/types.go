@@ -1,18 +1,94 @@
 package shared
 
+import "time"
+
 // CoordinationData represents the coordination information sent from orchestrator to lambda
 type CoordinationData struct {
+	// SchemaVersion is the PayloadSchemaVersion of the release that wrote it
+	SchemaVersion int `json:"schema_version"`
+
 	SessionID        string `json:"session_id"`
 	LaptopPublicIP   string `json:"laptop_public_ip"`
 	LaptopPublicPort int    `json:"laptop_public_port"`
 	Timestamp        int64  `json:"timestamp"`
+
+	// Settings carries orchestrator-side options the Lambda should honour
+	Settings *SessionSettings `json:"settings,omitempty"`
+
+	// Traceparent is the W3C trace context of the launch, if traced
+	Traceparent string `json:"traceparent,omitempty"`
+
+	// Credentials, if set, are the only ones the Lambda may use to answer
+	// this session; they can write nothing but its response object
+	Credentials *SessionCredentials `json:"credentials,omitempty"`
+
+	// IntegrityKey keys the checksums of this payload and of the Lambda's
+	// response to it
+	IntegrityKey string `json:"integrity_key"`
+
+	// Checksum is written last, by MarshalCoordination
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// SessionCredentials are short-lived AWS credentials scoped to one session
+type SessionCredentials struct {
+	AccessKeyID     string    `json:"access_key_id"`
+	SecretAccessKey string    `json:"secret_access_key"`
+	SessionToken    string    `json:"session_token"`
+	Expiration      time.Time `json:"expiration"`
+}
+
+// SessionSettings holds per-session options passed to the Lambda
+type SessionSettings struct {
+	QUIC *QUICTuning `json:"quic,omitempty"`
+	ACL  *ACLConfig  `json:"acl,omitempty"`
+	DNS  *DNSConfig  `json:"dns,omitempty"`
+
+	// Tracing has the Lambda export its own spans (nil = Lambda spans off)
+	Tracing *TracingConfig `json:"tracing,omitempty"`
+
+	// Metrics has the Lambda publish CloudWatch metrics (nil = off)
+	Metrics *LambdaMetricsConfig `json:"metrics,omitempty"`
+
+	// Logs has the Lambda forward its log lines over the control stream (nil = off)
+	Logs *LogForwarding `json:"logs,omitempty"`
+
+	// Storage sets the storage class and tags of the Lambda's response
+	// object (nil = the bucket's defaults)
+	Storage *ObjectStorage `json:"storage,omitempty"`
+
+	// MemoryMB is the memory size the session was launched for. A Lambda
+	// with a different size leaves the session to the mode's alias, which
+	// the orchestrator invokes directly (0 = any).
+	MemoryMB int `json:"memory_mb,omitempty"`
+
+	// Relay is where the Lambda meets the orchestrator when the session's
+	// coordination has no UDP endpoint (nil = no relay)
+	Relay *RelaySettings `json:"relay,omitempty"`
 }
 
+// ResponseStatusShutdown replaces a session's response to tell its Lambda
+// to exit: the orchestrator that launched it is gone
+const ResponseStatusShutdown = "shutdown"
+
 // LambdaResponse represents the response sent from lambda back to orchestrator
 type LambdaResponse struct {
+	// SchemaVersion is the PayloadSchemaVersion of the release that wrote it
+	SchemaVersion int `json:"schema_version"`
+
 	SessionID        string `json:"session_id"`
 	LambdaPublicIP   string `json:"lambda_public_ip"`
 	LambdaPublicPort int    `json:"lambda_public_port"`
 	Status           string `json:"status"`
 	Timestamp        int64  `json:"timestamp"`
+
+	// Trigger is how the Lambda was started, TriggerS3, TriggerInvoke or TriggerFunctionURL
+	Trigger string `json:"trigger,omitempty"`
+
+	// TriggerDelayMs is how long after the coordination object was written, or
+	// the function URL received the request, the Lambda started handling it
+	TriggerDelayMs int64 `json:"trigger_delay_ms,omitempty"`
+
+	// Checksum is written last, by MarshalResponse
+	Checksum string `json:"checksum,omitempty"`
 }
\ No newline at end of file
@@ -0,0 +1,205 @@
+package shared
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// UDP relay constants
+const (
+	// UDPStreamTargetPrefix marks a stream header target as a UDP relay instead of TCP
+	UDPStreamTargetPrefix = "udp/"
+
+	// MaxDatagramPayload is the largest tunnel datagram (header included) sent over
+	// QUIC datagrams. Anything larger falls back to a UDP relay stream.
+	MaxDatagramPayload = 1100
+
+	// MaxUDPPayload is the largest UDP payload relayed over a stream
+	MaxUDPPayload = 65507
+
+	// UDPRelayIdleTimeout closes UDP relays that have seen no traffic
+	UDPRelayIdleTimeout = 60 * time.Second
+
+	// datagramVersion prefixes every tunnel datagram
+	datagramVersion byte = 0x01
+)
+
+// SOCKS5 UDP ASSOCIATE constants
+const (
+	SOCKS5UDPAssociate = 0x03
+	SOCKS5IPv6         = 0x04
+)
+
+// UDPDatagram is a single UDP payload exchanged over QUIC datagrams.
+// Format: [1 byte version][4 bytes flow ID][2 bytes address length][address][payload]
+type UDPDatagram struct {
+	FlowID  uint32
+	Address string // target for requests, source for responses (host:port)
+	Payload []byte
+}
+
+// EncodeUDPDatagram serializes a datagram for QUIC SendDatagram
+func EncodeUDPDatagram(d UDPDatagram) ([]byte, error) {
+	if len(d.Address) > MaxTargetAddressLength {
+		return nil, fmt.Errorf("datagram address too long: %d bytes", len(d.Address))
+	}
+
+	buf := make([]byte, 7+len(d.Address)+len(d.Payload))
+	buf[0] = datagramVersion
+	binary.BigEndian.PutUint32(buf[1:5], d.FlowID)
+	binary.BigEndian.PutUint16(buf[5:7], uint16(len(d.Address)))
+	copy(buf[7:], d.Address)
+	copy(buf[7+len(d.Address):], d.Payload)
+	return buf, nil
+}
+
+// DecodeUDPDatagram parses a datagram received from QUIC ReceiveDatagram
+func DecodeUDPDatagram(b []byte) (UDPDatagram, error) {
+	if len(b) < 7 {
+		return UDPDatagram{}, fmt.Errorf("datagram too short: %d bytes", len(b))
+	}
+	if b[0] != datagramVersion {
+		return UDPDatagram{}, fmt.Errorf("unknown datagram version: %02x", b[0])
+	}
+
+	addrLen := int(binary.BigEndian.Uint16(b[5:7]))
+	if len(b) < 7+addrLen {
+		return UDPDatagram{}, fmt.Errorf("datagram truncated: address needs %d bytes", addrLen)
+	}
+
+	return UDPDatagram{
+		FlowID:  binary.BigEndian.Uint32(b[1:5]),
+		Address: string(b[7 : 7+addrLen]),
+		Payload: b[7+addrLen:],
+	}, nil
+}
+
+// WriteUDPFrame writes one UDP payload to a relay stream
+// Format: [2 bytes length][payload]
+func WriteUDPFrame(w io.Writer, payload []byte) error {
+	if len(payload) > MaxUDPPayload {
+		return fmt.Errorf("UDP payload too large: %d bytes", len(payload))
+	}
+
+	buf := make([]byte, 2+len(payload))
+	binary.BigEndian.PutUint16(buf[:2], uint16(len(payload)))
+	copy(buf[2:], payload)
+	if _, err := w.Write(buf); err != nil {
+		return fmt.Errorf("failed to write UDP frame: %w", err)
+	}
+	return nil
+}
+
+// ReadUDPFrame reads one UDP payload from a relay stream
+func ReadUDPFrame(r io.Reader) ([]byte, error) {
+	lengthBuf := make([]byte, 2)
+	if _, err := io.ReadFull(r, lengthBuf); err != nil {
+		return nil, err
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(lengthBuf))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("failed to read UDP frame: %w", err)
+	}
+	return payload, nil
+}
+
+// ParseSOCKS5UDPRequest parses a SOCKS5 UDP request header (RFC 1928 section 7)
+// and returns the destination address and payload
+func ParseSOCKS5UDPRequest(b []byte) (target string, payload []byte, err error) {
+	if len(b) < 4 {
+		return "", nil, fmt.Errorf("SOCKS5 UDP request too short")
+	}
+	if b[2] != 0x00 {
+		return "", nil, fmt.Errorf("SOCKS5 UDP fragmentation not supported")
+	}
+
+	var host string
+	offset := 4
+	switch b[3] {
+	case SOCKS5IPv4:
+		if len(b) < offset+4+2 {
+			return "", nil, fmt.Errorf("SOCKS5 UDP request truncated")
+		}
+		host = net.IP(b[offset : offset+4]).String()
+		offset += 4
+	case SOCKS5IPv6:
+		if len(b) < offset+16+2 {
+			return "", nil, fmt.Errorf("SOCKS5 UDP request truncated")
+		}
+		host = net.IP(b[offset : offset+16]).String()
+		offset += 16
+	case SOCKS5DomainName:
+		if len(b) < offset+1 {
+			return "", nil, fmt.Errorf("SOCKS5 UDP request truncated")
+		}
+		domainLen := int(b[offset])
+		offset++
+		if len(b) < offset+domainLen+2 {
+			return "", nil, fmt.Errorf("SOCKS5 UDP request truncated")
+		}
+		host = string(b[offset : offset+domainLen])
+		offset += domainLen
+	default:
+		return "", nil, fmt.Errorf("unsupported address type: %d", b[3])
+	}
+
+	port := binary.BigEndian.Uint16(b[offset : offset+2])
+	offset += 2
+
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), b[offset:], nil
+}
+
+// BuildSOCKS5UDPResponse prefixes a payload with a SOCKS5 UDP header for the given source address
+func BuildSOCKS5UDPResponse(source string, payload []byte) ([]byte, error) {
+	header, err := buildSOCKS5Address(source)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 0, 3+len(header)+len(payload))
+	buf = append(buf, 0x00, 0x00, 0x00)
+	buf = append(buf, header...)
+	return append(buf, payload...), nil
+}
+
+// BuildSOCKS5BindResponse builds a SOCKS5 reply carrying a bound address,
+// as used by UDP ASSOCIATE
+func BuildSOCKS5BindResponse(addr *net.UDPAddr) []byte {
+	header, _ := buildSOCKS5Address(addr.String())
+	return append([]byte{SOCKS5Version, SOCKS5Success, 0x00}, header...)
+}
+
+// buildSOCKS5Address encodes host:port as ATYP + ADDR + PORT
+func buildSOCKS5Address(address string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %s: %w", address, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %s: %w", address, err)
+	}
+
+	var buf []byte
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			buf = append([]byte{SOCKS5IPv4}, ip4...)
+		} else {
+			buf = append([]byte{SOCKS5IPv6}, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("domain name too long: %s", host)
+		}
+		buf = append([]byte{SOCKS5DomainName, byte(len(host))}, host...)
+	}
+
+	portBytes := make([]byte, 2)
+	binary.BigEndian.PutUint16(portBytes, uint16(port))
+	return append(buf, portBytes...), nil
+}
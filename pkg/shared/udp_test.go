@@ -0,0 +1,109 @@
+package shared
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestUDPDatagramRoundTrip(t *testing.T) {
+	original := UDPDatagram{
+		FlowID:  42,
+		Address: "1.1.1.1:53",
+		Payload: []byte("dns query"),
+	}
+
+	encoded, err := EncodeUDPDatagram(original)
+	if err != nil {
+		t.Fatalf("EncodeUDPDatagram failed: %v", err)
+	}
+
+	decoded, err := DecodeUDPDatagram(encoded)
+	if err != nil {
+		t.Fatalf("DecodeUDPDatagram failed: %v", err)
+	}
+
+	if decoded.FlowID != original.FlowID {
+		t.Errorf("Expected flow ID %d, got %d", original.FlowID, decoded.FlowID)
+	}
+	if decoded.Address != original.Address {
+		t.Errorf("Expected address %s, got %s", original.Address, decoded.Address)
+	}
+	if !bytes.Equal(decoded.Payload, original.Payload) {
+		t.Errorf("Expected payload %q, got %q", original.Payload, decoded.Payload)
+	}
+
+	if _, err := DecodeUDPDatagram(encoded[:5]); err == nil {
+		t.Error("Expected error for truncated datagram")
+	}
+}
+
+func TestUDPFrameRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteUDPFrame(&buf, []byte("first")); err != nil {
+		t.Fatalf("WriteUDPFrame failed: %v", err)
+	}
+	if err := WriteUDPFrame(&buf, []byte("second")); err != nil {
+		t.Fatalf("WriteUDPFrame failed: %v", err)
+	}
+
+	for _, expected := range []string{"first", "second"} {
+		payload, err := ReadUDPFrame(&buf)
+		if err != nil {
+			t.Fatalf("ReadUDPFrame failed: %v", err)
+		}
+		if string(payload) != expected {
+			t.Errorf("Expected %q, got %q", expected, payload)
+		}
+	}
+}
+
+func TestSOCKS5UDPRequestParsing(t *testing.T) {
+	tests := []struct {
+		name     string
+		packet   []byte
+		expected string
+	}{
+		{"ipv4", []byte{0, 0, 0, SOCKS5IPv4, 8, 8, 8, 8, 0, 53, 'x'}, "8.8.8.8:53"},
+		{"domain", append([]byte{0, 0, 0, SOCKS5DomainName, 7}, append([]byte("dns.foo"), 0, 53, 'x')...), "dns.foo:53"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, payload, err := ParseSOCKS5UDPRequest(tt.packet)
+			if err != nil {
+				t.Fatalf("ParseSOCKS5UDPRequest failed: %v", err)
+			}
+			if target != tt.expected {
+				t.Errorf("Expected target %s, got %s", tt.expected, target)
+			}
+			if string(payload) != "x" {
+				t.Errorf("Expected payload x, got %q", payload)
+			}
+		})
+	}
+
+	if _, _, err := ParseSOCKS5UDPRequest([]byte{0, 0, 1, SOCKS5IPv4, 8, 8, 8, 8, 0, 53}); err == nil {
+		t.Error("Expected error for fragmented packet")
+	}
+}
+
+func TestSOCKS5UDPResponseRoundTrip(t *testing.T) {
+	packet, err := BuildSOCKS5UDPResponse("8.8.4.4:53", []byte("answer"))
+	if err != nil {
+		t.Fatalf("BuildSOCKS5UDPResponse failed: %v", err)
+	}
+
+	source, payload, err := ParseSOCKS5UDPRequest(packet)
+	if err != nil {
+		t.Fatalf("ParseSOCKS5UDPRequest failed: %v", err)
+	}
+	if source != "8.8.4.4:53" || string(payload) != "answer" {
+		t.Errorf("Unexpected round trip result: %s %q", source, payload)
+	}
+
+	bind := BuildSOCKS5BindResponse(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353})
+	if len(bind) != 10 || bind[1] != SOCKS5Success || bind[3] != SOCKS5IPv4 {
+		t.Errorf("Unexpected bind response: %v", bind)
+	}
+}
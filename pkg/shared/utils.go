@@ -4,24 +4,78 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"sync"
 	"time"
 )
 
-// GenerateSessionID creates a unique session identifier
+// sessionIDs hands out session IDs, keeping them in order within the process
+var sessionIDs struct {
+	mu     sync.Mutex
+	lastMs int64
+	seq    uint16 // 12-bit counter for IDs in the same millisecond
+}
+
+// GenerateSessionID creates a session identifier: a UUIDv7 (RFC 9562) whose
+// first 48 bits are the Unix time in milliseconds, so IDs sort by creation
+// time in logs and S3 listings. A 12-bit counter, started at a random value
+// each millisecond, keeps IDs from one process unique and in order even
+// within a millisecond; the last 62 bits are random, which keeps IDs from
+// different processes apart.
 func GenerateSessionID() string {
-	bytes := make([]byte, 8)
-	if _, err := rand.Read(bytes); err != nil {
-		// This should never happen with crypto/rand, but handle gracefully
-		// Fall back to a timestamp-based ID if crypto/rand fails
-		LogError("Failed to generate cryptographic session ID, falling back to timestamp", err)
-		return GenerateTimestampID()
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		// This should never happen with crypto/rand; the time and counter
+		// still keep the ID unique within this process
+		LogError("Failed to generate cryptographic session ID, falling back to time and counter only", err)
+		id = [16]byte{}
+	}
+
+	sessionIDs.mu.Lock()
+	ms := time.Now().UnixMilli()
+	if ms > sessionIDs.lastMs {
+		sessionIDs.lastMs = ms
+		sessionIDs.seq = (uint16(id[6])<<8 | uint16(id[7])) & 0x07ff // leave room to count up
+	} else {
+		// Same millisecond, or the clock stepped back: count on from the last ID
+		sessionIDs.seq++
+		if sessionIDs.seq > 0x0fff {
+			sessionIDs.lastMs++
+			sessionIDs.seq = 0
+		}
 	}
-	return hex.EncodeToString(bytes)
+	ms, seq := sessionIDs.lastMs, sessionIDs.seq
+	sessionIDs.mu.Unlock()
+
+	id[0] = byte(ms >> 40)
+	id[1] = byte(ms >> 32)
+	id[2] = byte(ms >> 24)
+	id[3] = byte(ms >> 16)
+	id[4] = byte(ms >> 8)
+	id[5] = byte(ms)
+	id[6] = 0x70 | byte(seq>>8) // version 7
+	id[7] = byte(seq)
+	id[8] = 0x80 | id[8]&0x3f // RFC 9562 variant
+	return formatUUID(id)
+}
+
+// formatUUID writes id in the 8-4-4-4-12 hex form
+func formatUUID(id [16]byte) string {
+	buf := make([]byte, 36)
+	hex.Encode(buf[0:8], id[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], id[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], id[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], id[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], id[10:])
+	return string(buf)
 }
 
-// GenerateTimestampID creates a session ID based on current time as fallback
+// GenerateTimestampID creates an ID based on the current time
 func GenerateTimestampID() string {
 	// Use nanosecond timestamp as fallback ID
 	timestamp := time.Now().UnixNano()
 	return hex.EncodeToString([]byte(fmt.Sprintf("%d", timestamp)))
-}
\ No newline at end of file
+}
@@ -0,0 +1,70 @@
+package tunnel
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/quic-go/quic-go"
+)
+
+// tunnelAddr is the net.Addr reported by tunnelled connections
+type tunnelAddr struct {
+	addr string
+}
+
+func (a tunnelAddr) Network() string { return "quic-tunnel" }
+func (a tunnelAddr) String() string  { return a.addr }
+
+// conn adapts a QUIC stream to net.Conn and counts bytes for the OnClose hook
+type conn struct {
+	quic.Stream
+	info    DialInfo
+	onClose func(info DialInfo, bytesSent, bytesReceived int64)
+
+	sent      atomic.Int64
+	received  atomic.Int64
+	closeOnce sync.Once
+}
+
+func newConn(stream quic.Stream, info DialInfo, onClose func(DialInfo, int64, int64)) *conn {
+	return &conn{
+		Stream:  stream,
+		info:    info,
+		onClose: onClose,
+	}
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	n, err := c.Stream.Read(b)
+	c.received.Add(int64(n))
+	return n, err
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	n, err := c.Stream.Write(b)
+	c.sent.Add(int64(n))
+	return n, err
+}
+
+// Close closes both directions of the stream. quic.Stream.Close only closes
+// the send side, which would leave the Lambda's copy loop waiting.
+func (c *conn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.Stream.CancelRead(0)
+		err = c.Stream.Close()
+		if c.onClose != nil {
+			c.onClose(c.info, c.sent.Load(), c.received.Load())
+		}
+	})
+	return err
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return tunnelAddr{addr: c.info.SessionID}
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return tunnelAddr{addr: c.info.Target}
+}
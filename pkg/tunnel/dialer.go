@@ -0,0 +1,176 @@
+// Package tunnel lets Go programs send traffic through the QUIC tunnel without
+// going through the local SOCKS5 listener.
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+)
+
+// DialInfo describes a single tunnelled connection, passed to Hooks
+type DialInfo struct {
+	Network   string
+	Target    string
+	SessionID string
+	StartedAt time.Time
+}
+
+// Hooks are optional callbacks for recording metrics on tunnelled connections.
+// Any nil hook is skipped.
+type Hooks struct {
+	// OnConnect is called once the Lambda has connected to the target
+	OnConnect func(info DialInfo)
+	// OnDialError is called when the dial fails at any stage
+	OnDialError func(info DialInfo, err error)
+	// OnClose is called when the connection is closed with the bytes sent and received
+	OnClose func(info DialInfo, bytesSent, bytesReceived int64)
+}
+
+// Policy decides whether the Dialer may connect to a target
+type Policy interface {
+	CheckTarget(ctx context.Context, target string) error
+}
+
+// PolicyFunc adapts a plain function to the Policy interface
+type PolicyFunc func(ctx context.Context, target string) error
+
+// CheckTarget calls f(ctx, target)
+func (f PolicyFunc) CheckTarget(ctx context.Context, target string) error {
+	return f(ctx, target)
+}
+
+// Dialer opens TCP connections to targets through the Lambda tunnel
+type Dialer struct {
+	Selector SessionSelector
+	Hooks    Hooks
+
+	// Policy is checked before each dial, as the SOCKS5 listener checks its
+	// ACL and policy rules. A nil Policy allows every target, leaving only
+	// the Lambda's own ACL to refuse any.
+	Policy Policy
+
+	// Timeout bounds stream setup and the Lambda's connect to the target.
+	// Zero uses shared.DefaultConnectionTimeout.
+	Timeout time.Duration
+}
+
+// NewDialer creates a new Dialer using the given session selector
+func NewDialer(selector SessionSelector) *Dialer {
+	return &Dialer{Selector: selector}
+}
+
+// DialContext connects to addr through the tunnel, once d.Policy allows it.
+// Only TCP networks are supported. Its signature matches
+// http.Transport.DialContext.
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	info := DialInfo{
+		Network:   network,
+		Target:    addr,
+		StartedAt: time.Now(),
+	}
+
+	conn, err := d.dial(ctx, &info)
+	if err != nil {
+		if d.Hooks.OnDialError != nil {
+			d.Hooks.OnDialError(info, err)
+		}
+		return nil, err
+	}
+
+	if d.Hooks.OnConnect != nil {
+		d.Hooks.OnConnect(info)
+	}
+	return conn, nil
+}
+
+func (d *Dialer) dial(ctx context.Context, info *DialInfo) (net.Conn, error) {
+	switch info.Network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("unsupported network %q: only tcp is tunnelled", info.Network)
+	}
+	if err := shared.ValidateTargetAddress(info.Target); err != nil {
+		return nil, err
+	}
+	frame, err := shared.NewStreamFrame(shared.StreamConnect, info.Target)
+	if err != nil {
+		return nil, err
+	}
+	if d.Selector == nil {
+		return nil, errors.New("no session selector configured")
+	}
+	if d.Policy != nil {
+		if err := d.Policy.CheckTarget(ctx, info.Target); err != nil {
+			return nil, fmt.Errorf("refusing %s: %w", info.Target, err)
+		}
+	}
+
+	timeout := d.Timeout
+	if timeout == 0 {
+		timeout = shared.DefaultConnectionTimeout
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	sessionID, opener, err := d.Selector.SelectSession(dialCtx, info.Target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select session: %w", err)
+	}
+	info.SessionID = sessionID
+
+	stream, err := opener.OpenStreamSync(dialCtx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open QUIC stream on session %s: %w", sessionID, err)
+	}
+
+	// Bound the handshake with the Lambda by the dial context
+	deadline, _ := dialCtx.Deadline()
+	stream.SetDeadline(deadline)
+
+	if err := shared.WriteStreamHeader(stream, frame, shared.SupportsStreamFrames(opener)); err != nil {
+		stream.CancelRead(0)
+		stream.Close()
+		return nil, err
+	}
+
+	reply, err := shared.ReadStreamReply(stream)
+	if err != nil {
+		stream.CancelRead(0)
+		stream.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	if !reply.OK() {
+		stream.CancelRead(0)
+		stream.Close()
+		if reply.Reason != "" {
+			return nil, fmt.Errorf("lambda failed to connect to %s: %s", info.Target, reply.Reason)
+		}
+		return nil, fmt.Errorf("lambda failed to connect to %s", info.Target)
+	}
+
+	stream.SetDeadline(time.Time{})
+	return newConn(stream, *info, d.Hooks.OnClose), nil
+}
+
+// NewTransport returns an http.Transport that dials every connection through
+// the tunnel, checking each against policy. Proxy settings from the
+// environment are ignored.
+func NewTransport(selector SessionSelector, policy Policy, hooks Hooks) *http.Transport {
+	dialer := NewDialer(selector)
+	dialer.Policy = policy
+	dialer.Hooks = hooks
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}
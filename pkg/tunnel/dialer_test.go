@@ -0,0 +1,188 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dan-v/lambda-nat-punch-proxy/pkg/shared"
+	"github.com/quic-go/quic-go"
+)
+
+// pipeStream is a quic.Stream backed by one end of a net.Pipe
+type pipeStream struct {
+	quic.Stream
+	conn net.Conn
+}
+
+func (s *pipeStream) Read(b []byte) (int, error)         { return s.conn.Read(b) }
+func (s *pipeStream) Write(b []byte) (int, error)        { return s.conn.Write(b) }
+func (s *pipeStream) Close() error                       { return s.conn.Close() }
+func (s *pipeStream) CancelRead(quic.StreamErrorCode)    {}
+func (s *pipeStream) SetDeadline(t time.Time) error      { return s.conn.SetDeadline(t) }
+func (s *pipeStream) SetReadDeadline(t time.Time) error  { return s.conn.SetReadDeadline(t) }
+func (s *pipeStream) SetWriteDeadline(t time.Time) error { return s.conn.SetWriteDeadline(t) }
+
+// fakeLambda answers one stream the way the Lambda does
+type fakeLambda struct {
+	status byte
+	target chan string
+}
+
+func (f *fakeLambda) OpenStreamSync(ctx context.Context) (quic.Stream, error) {
+	local, remote := net.Pipe()
+	go func() {
+		defer remote.Close()
+		target, err := shared.ReadSOCKS5TargetAddress(remote)
+		if err != nil {
+			return
+		}
+		f.target <- target
+		remote.Write([]byte{f.status})
+		if f.status == 0x00 {
+			io.Copy(remote, remote)
+		}
+	}()
+	return &pipeStream{conn: local}, nil
+}
+
+func TestDialContext(t *testing.T) {
+	lambda := &fakeLambda{status: 0x00, target: make(chan string, 1)}
+	selector := SessionSelectorFunc(func(ctx context.Context, target string) (string, StreamOpener, error) {
+		sessionID, _ := SessionIDFromContext(ctx)
+		return sessionID, lambda, nil
+	})
+
+	var connected DialInfo
+	closed := make(chan int64, 1)
+	dialer := NewDialer(selector)
+	dialer.Hooks = Hooks{
+		OnConnect: func(info DialInfo) { connected = info },
+		OnClose:   func(info DialInfo, sent, received int64) { closed <- sent },
+	}
+
+	ctx := WithSessionID(context.Background(), "session-a")
+	conn, err := dialer.DialContext(ctx, "tcp", "example.com:80")
+	if err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+
+	if got := <-lambda.target; got != "example.com:80" {
+		t.Errorf("Expected target example.com:80, got %s", got)
+	}
+	if connected.SessionID != "session-a" {
+		t.Errorf("Expected OnConnect for session-a, got %q", connected.SessionID)
+	}
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("Expected echoed ping, got %q (err %v)", buf, err)
+	}
+
+	conn.Close()
+	if sent := <-closed; sent != 4 {
+		t.Errorf("Expected 4 bytes sent, got %d", sent)
+	}
+}
+
+func TestDialContextLambdaFailure(t *testing.T) {
+	lambda := &fakeLambda{status: 0x01, target: make(chan string, 1)}
+	selector := SessionSelectorFunc(func(ctx context.Context, target string) (string, StreamOpener, error) {
+		return "session-a", lambda, nil
+	})
+
+	var dialErr error
+	dialer := NewDialer(selector)
+	dialer.Hooks.OnDialError = func(info DialInfo, err error) { dialErr = err }
+
+	if _, err := dialer.DialContext(context.Background(), "tcp", "example.com:80"); err == nil {
+		t.Fatal("Expected error when lambda fails to connect")
+	}
+	if dialErr == nil {
+		t.Error("Expected OnDialError to be called")
+	}
+}
+
+func TestDialContextPolicy(t *testing.T) {
+	lambda := &fakeLambda{status: 0x00, target: make(chan string, 1)}
+	selector := SessionSelectorFunc(func(ctx context.Context, target string) (string, StreamOpener, error) {
+		return "session-a", lambda, nil
+	})
+	denied := errors.New("denied")
+
+	dialer := NewDialer(selector)
+	dialer.Policy = PolicyFunc(func(ctx context.Context, target string) error {
+		if target == "blocked.example.com:80" {
+			return denied
+		}
+		return nil
+	})
+
+	if _, err := dialer.DialContext(context.Background(), "tcp", "blocked.example.com:80"); !errors.Is(err, denied) {
+		t.Fatalf("Expected the policy to refuse the dial, got %v", err)
+	}
+	select {
+	case target := <-lambda.target:
+		t.Fatalf("Expected no stream for a refused target, got one for %s", target)
+	default:
+	}
+
+	conn, err := dialer.DialContext(context.Background(), "tcp", "example.com:80")
+	if err != nil {
+		t.Fatalf("Expected an allowed target to connect, got %v", err)
+	}
+	conn.Close()
+}
+
+func TestDialContextRejectsUDP(t *testing.T) {
+	dialer := NewDialer(nil)
+	if _, err := dialer.DialContext(context.Background(), "udp", "example.com:53"); err == nil {
+		t.Error("Expected error for udp network")
+	}
+}
+
+// fakeSession is a Session for PoolSelector tests
+type fakeSession struct {
+	id     string
+	usable bool
+}
+
+func (s fakeSession) SessionID() string  { return s.id }
+func (s fakeSession) Usable() bool       { return s.usable }
+func (s fakeSession) Conn() StreamOpener { return &fakeLambda{} }
+
+// fakePool is a Pool whose primary is its first session
+type fakePool []Session
+
+func (p fakePool) Sessions() []Session { return p }
+func (p fakePool) Primary() Session {
+	if len(p) == 0 {
+		return nil
+	}
+	return p[0]
+}
+
+func TestPoolSelector(t *testing.T) {
+	selector := NewPoolSelector(fakePool{fakeSession{"a", true}, fakeSession{"b", false}})
+
+	if id, conn, err := selector.SelectSession(context.Background(), "example.com:80"); err != nil || id != "a" || conn == nil {
+		t.Errorf("Expected the primary session a, got %q (%v)", id, err)
+	}
+	if _, _, err := selector.SelectSession(WithSessionID(context.Background(), "b"), "example.com:80"); err == nil {
+		t.Error("Expected an unusable pinned session to be refused")
+	}
+	if _, _, err := selector.SelectSession(WithSessionID(context.Background(), "c"), "example.com:80"); err == nil {
+		t.Error("Expected an unknown pinned session to be refused")
+	}
+
+	empty := NewPoolSelector(fakePool{})
+	if _, _, err := empty.SelectSession(context.Background(), "example.com:80"); err == nil {
+		t.Error("Expected an error from an empty pool")
+	}
+}
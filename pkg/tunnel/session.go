@@ -0,0 +1,91 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/quic-go/quic-go"
+)
+
+// StreamOpener is the part of a QUIC connection needed to open tunnel streams
+type StreamOpener interface {
+	OpenStreamSync(ctx context.Context) (quic.Stream, error)
+}
+
+// SessionSelector picks the session used for an outgoing connection
+type SessionSelector interface {
+	SelectSession(ctx context.Context, target string) (sessionID string, conn StreamOpener, err error)
+}
+
+// SessionSelectorFunc adapts a plain function to the SessionSelector interface
+type SessionSelectorFunc func(ctx context.Context, target string) (string, StreamOpener, error)
+
+// SelectSession calls f(ctx, target)
+func (f SessionSelectorFunc) SelectSession(ctx context.Context, target string) (string, StreamOpener, error) {
+	return f(ctx, target)
+}
+
+type sessionIDKey struct{}
+
+// WithSessionID returns a context that pins dials made with it to the given session.
+// Use it with http.Request.WithContext to choose a session per request.
+func WithSessionID(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, sessionIDKey{}, sessionID)
+}
+
+// SessionIDFromContext returns the session ID pinned by WithSessionID, if any
+func SessionIDFromContext(ctx context.Context) (string, bool) {
+	sessionID, ok := ctx.Value(sessionIDKey{}).(string)
+	return sessionID, ok && sessionID != ""
+}
+
+// Session is one tunnel session of a Pool
+type Session interface {
+	// SessionID is the ID WithSessionID pins dials to
+	SessionID() string
+	// Usable reports whether the session takes new connections
+	Usable() bool
+	// Conn returns the connection to open the next stream on
+	Conn() StreamOpener
+}
+
+// Pool is a set of tunnel sessions, such as those of a running proxy. Its
+// methods must be safe for concurrent use.
+type Pool interface {
+	Sessions() []Session
+	// Primary returns the session new connections use, or nil if there is none
+	Primary() Session
+}
+
+// PoolSelector selects sessions from a Pool. Requests pinned with
+// WithSessionID use that session; all others use the pool's primary.
+type PoolSelector struct {
+	pool Pool
+}
+
+// NewPoolSelector creates a selector backed by pool
+func NewPoolSelector(pool Pool) *PoolSelector {
+	return &PoolSelector{pool: pool}
+}
+
+// SelectSession implements SessionSelector
+func (s *PoolSelector) SelectSession(ctx context.Context, target string) (string, StreamOpener, error) {
+	if sessionID, ok := SessionIDFromContext(ctx); ok {
+		for _, session := range s.pool.Sessions() {
+			if session.SessionID() != sessionID {
+				continue
+			}
+			if !session.Usable() {
+				return "", nil, fmt.Errorf("session %s is not usable", sessionID)
+			}
+			return sessionID, session.Conn(), nil
+		}
+		return "", nil, fmt.Errorf("session %s not found", sessionID)
+	}
+
+	session := s.pool.Primary()
+	if session == nil || !session.Usable() {
+		return "", nil, fmt.Errorf("no suitable session available for %s", target)
+	}
+	return session.SessionID(), session.Conn(), nil
+}
@@ -74,6 +74,7 @@ RUN go mod download && cd lambda && go mod download
 
 # Build Lambda function (always Linux/amd64 for AWS)
 RUN cd lambda && GOOS=linux GOARCH=amd64 go build -o ../cmd/lambda-nat-proxy/assets/bootstrap .
+RUN cd lambda && GOOS=linux GOARCH=arm64 go build -o ../cmd/lambda-nat-proxy/assets/bootstrap-arm64 .
 
 # Build main binary for target platform
 RUN CGO_ENABLED=0 GOOS=${TARGETOS} GOARCH=${TARGETARCH} go build -a -installsuffix cgo -o lambda-nat-proxy ./cmd/lambda-nat-proxy
@@ -151,6 +152,7 @@ RUN go mod download && cd lambda && go mod download
 
 # Build Lambda function (always Linux/amd64 for AWS)
 RUN cd lambda && GOOS=linux GOARCH=amd64 go build -o ../cmd/lambda-nat-proxy/assets/bootstrap .
+RUN cd lambda && GOOS=linux GOARCH=arm64 go build -o ../cmd/lambda-nat-proxy/assets/bootstrap-arm64 .
 
 # Build main binary for target platform
 RUN CGO_ENABLED=0 GOOS=${TARGETOS} GOARCH=${TARGETARCH} go build -a -installsuffix cgo -o lambda-nat-proxy ./cmd/lambda-nat-proxy
@@ -0,0 +1,39 @@
+import React from 'react';
+import { BudgetStatus } from '../types';
+import { formatBytes } from '../utils/formatters';
+
+interface BudgetBannerProps {
+  budget?: BudgetStatus;
+}
+
+// Shown once usage nears a budget cap, and while one stops new sessions
+const WARN_FRACTION = 0.8;
+
+export const BudgetBanner: React.FC<BudgetBannerProps> = ({ budget }) => {
+  if (!budget) return null;
+
+  const fractions = [
+    budget.max_transfer ? budget.bytes / budget.max_transfer : 0,
+    budget.max_spend ? budget.spend / budget.max_spend : 0,
+  ];
+  const used = Math.max(...fractions);
+  if (!budget.exceeded && used < WARN_FRACTION) return null;
+
+  const usage = [
+    budget.max_transfer ? `${formatBytes(budget.bytes)} of ${formatBytes(budget.max_transfer)}` : null,
+    budget.max_spend ? `~$${budget.spend.toFixed(2)} of $${budget.max_spend.toFixed(2)}` : null,
+  ].filter(Boolean).join(', ');
+  const resets = new Date(budget.resets_at).toLocaleString();
+
+  return (
+    <div className={`budget-banner ${budget.exceeded ? 'exceeded' : 'warning'}`}>
+      <span className="budget-icon">{budget.exceeded ? '⛔' : '⚠️'}</span>
+      <span>
+        {budget.exceeded
+          ? `Budget exceeded: ${budget.reason}. No new sessions will launch until ${resets}.`
+          : `${Math.round(used * 100)}% of this ${budget.period}'s budget used.`}
+        {' '}({usage})
+      </span>
+    </div>
+  );
+};
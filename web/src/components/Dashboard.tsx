@@ -5,6 +5,9 @@ import { LambdaFleet } from './LambdaFleet';
 import { SimpleChart } from './SimpleChart';
 import { SimpleDestinations } from './SimpleDestinations';
 import { ConnectionsTable } from './ConnectionsTable';
+import { RotationTimeline } from './RotationTimeline';
+import { LaunchBreakdown } from './LaunchBreakdown';
+import { BudgetBanner } from './BudgetBanner';
 
 interface DashboardProps {
   data: DashboardData;
@@ -18,6 +21,9 @@ export const Dashboard: React.FC<DashboardProps> = ({ data, connected }) => {
       {/* Simple Header */}
       <SimpleHeader data={data} connected={connected} />
       
+      {/* Budget warning */}
+      <BudgetBanner budget={data.budget} />
+      
       {/* Main Grid Layout */}
       <div className="dashboard-grid">
         {/* Lambda Fleet Status */}
@@ -39,6 +45,16 @@ export const Dashboard: React.FC<DashboardProps> = ({ data, connected }) => {
         <div className="dashboard-section connections-table">
           <ConnectionsTable connections={data.connections} />
         </div>
+        
+        {/* Rotation Timeline */}
+        <div className="dashboard-section rotation-timeline">
+          <RotationTimeline rotations={data.rotations || []} />
+        </div>
+        
+        {/* Session Launch Breakdown */}
+        <div className="dashboard-section launch-breakdown">
+          <LaunchBreakdown launches={data.launches || []} />
+        </div>
       </div>
     </div>
   );
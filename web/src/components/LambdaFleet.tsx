@@ -33,8 +33,27 @@ export const LambdaFleet: React.FC<LambdaFleetProps> = ({ sessions }) => {
                   {session.health > 0.8 ? 'Healthy' : 'Degraded'}
                 </span>
               </div>
+              <div className="stat">
+                <span className="stat-label">{session.role === 'draining' ? 'Streams left' : 'Streams'}</span>
+                <span className="stat-value">{session.active_streams ?? 0}</span>
+              </div>
+              {session.crypto?.tls_version && (
+                <div className="stat">
+                  <span className="stat-label">Encryption</span>
+                  <span
+                    className={`stat-value ${session.crypto.warnings?.length ? 'unhealthy' : ''}`}
+                    title={session.crypto.cipher_suite}
+                  >
+                    {session.crypto.tls_version} · QUIC {session.crypto.quic_version}
+                  </span>
+                </div>
+              )}
             </div>
             
+            {session.crypto?.warnings?.map(warning => (
+              <div key={warning} className="crypto-warning">⚠️ {warning}</div>
+            ))}
+            
             <div className="lambda-health-bar">
               <div 
                 className="health-fill"
@@ -0,0 +1,79 @@
+import React from 'react';
+import { LaunchRecord } from '../types';
+import { formatDuration } from '../utils/formatters';
+
+interface LaunchBreakdownProps {
+  launches: LaunchRecord[];
+}
+
+// Go encodes time.Duration as nanoseconds
+const nsToMs = (ns: number): number => ns / 1e6;
+
+const phaseLabels: Record<string, string> = {
+  stun: 'STUN',
+  s3_put: 'S3 put',
+  lambda_wait: 'Lambda wait',
+  hole_punch: 'Hole punch',
+  quic_handshake: 'QUIC handshake',
+  control_stream: 'Control stream',
+  hello: 'Protocol hello',
+};
+
+// launchTitle names the session and how its Lambda was started
+const launchTitle = (launch: LaunchRecord): string | undefined => {
+  if (!launch.trigger) {
+    return launch.session_id;
+  }
+  const how = launch.trigger === 'invoke' ? 'invoked directly' : 'started by S3';
+  return `${launch.session_id} (${how} after ${formatDuration(nsToMs(launch.trigger_latency || 0))})`;
+};
+
+export const LaunchBreakdown: React.FC<LaunchBreakdownProps> = ({ launches }) => {
+  return (
+    <div className="launch-breakdown-container">
+      <h2 className="section-title">
+        <span className="title-icon">⏱️</span>
+        Session Launches
+      </h2>
+
+      {launches.length === 0 ? (
+        <div className="rotation-empty">No launches yet</div>
+      ) : (
+        <>
+          <div className="launch-legend">
+            {Object.entries(phaseLabels).map(([phase, label]) => (
+              <span key={phase}>
+                <span className={`launch-segment ${phase}`} />
+                {label}
+              </span>
+            ))}
+          </div>
+          <div className="launch-list">
+            {launches.map((launch) => (
+              <div
+                key={launch.started_at}
+                className={`launch-row ${launch.error ? 'failed' : ''}`}
+                title={launch.error || launchTitle(launch)}
+              >
+                <span className="launch-time">{new Date(launch.started_at).toLocaleTimeString()}</span>
+                <div className="launch-bar">
+                  {launch.phases.map((phase) => (
+                    <div
+                      key={phase.name}
+                      className={`launch-segment ${phase.name}`}
+                      style={{ width: `${launch.duration > 0 ? (phase.duration / launch.duration) * 100 : 0}%` }}
+                      title={`${phaseLabels[phase.name] || phase.name}: ${formatDuration(nsToMs(phase.duration))}`}
+                    />
+                  ))}
+                </div>
+                <span className="launch-total">
+                  {launch.error ? 'failed' : formatDuration(nsToMs(launch.duration))}
+                </span>
+              </div>
+            ))}
+          </div>
+        </>
+      )}
+    </div>
+  );
+};
@@ -0,0 +1,66 @@
+import React from 'react';
+import { RotationRecord } from '../types';
+import { formatDuration } from '../utils/formatters';
+
+interface RotationTimelineProps {
+  rotations: RotationRecord[];
+}
+
+// Go encodes time.Duration as nanoseconds
+const nsToMs = (ns: number): number => ns / 1e6;
+
+const stageLabels: Record<string, string> = {
+  started: 'Started',
+  secondary_launched: 'Launched',
+  secondary_healthy: 'Healthy',
+  promoted: 'Promoted',
+  drained: 'Drained',
+  failed: 'Failed',
+};
+
+export const RotationTimeline: React.FC<RotationTimelineProps> = ({ rotations }) => {
+  return (
+    <div className="rotation-timeline-container">
+      <h2 className="section-title">
+        <span className="title-icon">🔄</span>
+        Rotation Timeline
+      </h2>
+
+      {rotations.length === 0 ? (
+        <div className="rotation-empty">No rotations yet</div>
+      ) : (
+        <div className="rotation-list">
+          {rotations.map((rotation) => (
+            <div key={rotation.id} className={`rotation-row ${rotation.outcome}`}>
+              <div className="rotation-header">
+                <span className="rotation-id">#{rotation.id}</span>
+                <span className="rotation-time">
+                  {new Date(rotation.started_at).toLocaleTimeString()}
+                </span>
+                <span className={`rotation-outcome ${rotation.outcome}`}>
+                  {rotation.outcome.replace('_', ' ')}
+                </span>
+                {rotation.duration > 0 && (
+                  <span className="rotation-duration">{formatDuration(nsToMs(rotation.duration))}</span>
+                )}
+              </div>
+
+              <div className="rotation-stages">
+                {rotation.events.map((event, index) => (
+                  <div
+                    key={index}
+                    className={`rotation-stage ${event.stage}`}
+                    title={event.detail || undefined}
+                  >
+                    <span className="stage-label">{stageLabels[event.stage] || event.stage}</span>
+                    <span className="stage-elapsed">+{formatDuration(nsToMs(event.elapsed))}</span>
+                  </div>
+                ))}
+              </div>
+            </div>
+          ))}
+        </div>
+      )}
+    </div>
+  );
+};
@@ -27,6 +27,12 @@ export const SimpleHeader: React.FC<SimpleHeaderProps> = ({ data, connected }) =
           <span className="stat-label">Connections</span>
           <span className="stat-value">{data?.connections?.length || 0}</span>
         </div>
+        {(data?.reaped_connections || 0) > 0 && (
+          <div className="stat-item" title="Tunnels closed after carrying no traffic for the idle timeout">
+            <span className="stat-label">Idle Reaped</span>
+            <span className="stat-value">{data.reaped_connections}</span>
+          </div>
+        )}
         <div className="stat-item">
           <span className="stat-label">Uptime</span>
           <span className="stat-value">{formatUptime(data?.uptime || '0s')}</span>
@@ -29,11 +29,67 @@ export interface SessionInfo {
   ttl_seconds: number;
   healthy: boolean;
   lambda_public_ip?: string;
+  active_streams?: number;
+  crypto?: TunnelCrypto;
+}
+
+export interface TunnelCrypto {
+  tls_version: string;
+  cipher_suite: string;
+  quic_version: string;
+  used_0rtt?: boolean;
+  warnings?: string[];
 }
 
 // Alias for compatibility
 export type Session = SessionInfo;
 
+export interface RotationEvent {
+  stage: string;
+  at: string;
+  elapsed: number; // nanoseconds since the rotation started
+  detail?: string;
+}
+
+export interface RotationRecord {
+  id: number;
+  old_primary: string;
+  new_session?: string;
+  started_at: string;
+  outcome: 'in_progress' | 'succeeded' | 'failed';
+  duration: number; // nanoseconds; 0 while in progress
+  events: RotationEvent[];
+}
+
+export interface LaunchPhase {
+  name: string;
+  duration: number; // nanoseconds
+}
+
+export interface LaunchRecord {
+  session_id?: string;
+  started_at: string;
+  duration: number; // nanoseconds
+  phases: LaunchPhase[];
+  error?: string;
+  trigger?: 's3' | 'invoke';
+  trigger_latency?: number; // nanoseconds from the S3 write to the Lambda starting
+}
+
+export interface BudgetStatus {
+  period: 'day' | 'month';
+  period_start: string;
+  resets_at: string;
+  bytes: number;
+  max_transfer?: number;
+  sessions: number;
+  lambda_seconds: number;
+  spend: number; // estimated USD
+  max_spend?: number;
+  exceeded: boolean;
+  reason?: string;
+}
+
 export interface DestinationStats {
   hostname: string;
   connection_count: number;
@@ -54,10 +110,14 @@ export interface DashboardData {
   uptime: string;
   status: string;
   total_connections: number;
+  reaped_connections: number;
   bytes_per_second: number;
   avg_latency: number;
   public_ip: string;
+  budget?: BudgetStatus;
   sessions: SessionInfo[];
+  rotations: RotationRecord[];
+  launches: LaunchRecord[];
   connections: TrackedConnection[];
   top_destinations: DestinationStats[];
   destinations: Destination[];